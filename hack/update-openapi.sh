@@ -0,0 +1,50 @@
+#!/bin/bash
+
+# Copyright 2017 The Kubernetes Authors.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+GOPKG="github.com/zalando-incubator/kube-metrics-adapter"
+
+SCRIPT_ROOT="$(cd "$(dirname "${BASH_SOURCE[0]}")/.." && pwd)"
+
+# OPENAPI_OUTPUT_DIR can be overridden to generate the definitions to another
+# directory, e.g. to check that the committed definitions are up to date.
+OPENAPI_OUTPUT_DIR="${OPENAPI_OUTPUT_DIR:-pkg/api/generated/openapi}"
+
+cd "${SCRIPT_ROOT}"
+
+echo "Generating OpenAPI definitions at ${OPENAPI_OUTPUT_DIR}"
+go run k8s.io/kube-openapi/cmd/openapi-gen \
+  --go-header-file "${SCRIPT_ROOT}/hack/boilerplate.go.txt" \
+  --logtostderr \
+  --output-dir "${OPENAPI_OUTPUT_DIR}" \
+  --output-pkg "${GOPKG}/pkg/api/generated/openapi" \
+  --output-file zz_generated.openapi.go \
+  -r /dev/null \
+  k8s.io/metrics/pkg/apis/custom_metrics \
+  k8s.io/metrics/pkg/apis/custom_metrics/v1beta1 \
+  k8s.io/metrics/pkg/apis/custom_metrics/v1beta2 \
+  k8s.io/metrics/pkg/apis/external_metrics \
+  k8s.io/metrics/pkg/apis/external_metrics/v1beta1 \
+  k8s.io/metrics/pkg/apis/metrics \
+  k8s.io/metrics/pkg/apis/metrics/v1beta1 \
+  k8s.io/apimachinery/pkg/apis/meta/v1 \
+  k8s.io/apimachinery/pkg/api/resource \
+  k8s.io/apimachinery/pkg/version \
+  k8s.io/api/core/v1 \
+  "${GOPKG}/pkg/apis/zalando.org/v1"
@@ -0,0 +1,289 @@
+package annotations
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigBinder binds the values of a metric config to typed variables.
+// Collectors declare each config key they read with its type and validation,
+// the variable holds the default if the key isn't set. Invalid values are
+// reported with a uniform error naming the config key and the annotation
+// which defined the value.
+//
+// Only the first error is kept, so the error of a collector is stable
+// regardless of the number of invalid values.
+type ConfigBinder struct {
+	values  map[string]string
+	sources map[string]string
+	bound   map[string]struct{}
+	// ignoreUnbound disables the warnings of unbound config keys.
+	ignoreUnbound bool
+	err           error
+}
+
+// NewConfigBinder initializes a new ConfigBinder for the config values.
+// sources maps config keys to the annotations which defined their values,
+// values without a source, e.g. those of metric selector labels, are
+// reported without an annotation.
+func NewConfigBinder(values, sources map[string]string) *ConfigBinder {
+	return &ConfigBinder{
+		values:  values,
+		sources: sources,
+		bound:   map[string]struct{}{},
+	}
+}
+
+// lookup returns the value of the config key and marks the key as bound.
+func (b *ConfigBinder) lookup(key string) (string, bool) {
+	b.bound[key] = struct{}{}
+	value, ok := b.values[key]
+	return value, ok
+}
+
+// invalid records the error of an invalid value of the config key.
+func (b *ConfigBinder) invalid(key, value, reason string) {
+	if b.err != nil {
+		return
+	}
+
+	if annotation, ok := b.sources[key]; ok {
+		b.err = fmt.Errorf("invalid value '%s' of config key '%s' in annotation %s: %s", value, key, annotation, reason)
+		return
+	}
+	b.err = fmt.Errorf("invalid value '%s' of config key '%s': %s", value, key, reason)
+}
+
+// Missing records the error of a missing config key. If multiple keys are
+// given, one of them is required.
+func (b *ConfigBinder) Missing(keys ...string) {
+	if b.err != nil {
+		return
+	}
+
+	if len(keys) == 1 {
+		b.err = fmt.Errorf("missing config key '%s'", keys[0])
+		return
+	}
+	b.err = fmt.Errorf("missing config key, one of '%s' is required", strings.Join(keys, "', '"))
+}
+
+// Invalid records the error of a value of the config key failing the
+// validation of the collector, e.g. an unparsable pattern.
+func (b *ConfigBinder) Invalid(key, reason string) {
+	b.invalid(key, b.values[key], reason)
+}
+
+// Has returns true if the config key is set without binding it.
+func (b *ConfigBinder) Has(key string) bool {
+	_, ok := b.values[key]
+	return ok
+}
+
+// Used marks the config keys as bound without binding them, e.g. keys bound
+// by the caller of the collector.
+func (b *ConfigBinder) Used(keys ...string) {
+	for _, key := range keys {
+		b.bound[key] = struct{}{}
+	}
+}
+
+// IgnoreUnbound disables the warnings of config keys which aren't bound, e.g.
+// if keys are referenced by name like the named queries of the prometheus
+// collector.
+func (b *ConfigBinder) IgnoreUnbound() {
+	b.ignoreUnbound = true
+}
+
+// String binds the value of the config key. It returns true if the key is
+// set.
+func (b *ConfigBinder) String(key string, target *string) bool {
+	value, ok := b.lookup(key)
+	if ok {
+		*target = value
+	}
+	return ok
+}
+
+// RequiredString binds the value of the config key which must be set. It
+// returns true if the key is set.
+func (b *ConfigBinder) RequiredString(key string, target *string) bool {
+	if !b.String(key, target) {
+		b.Missing(key)
+		return false
+	}
+	return true
+}
+
+// Enum binds the value of the config key which must be one of the allowed
+// values. It returns true if the key is set to a valid value.
+func (b *ConfigBinder) Enum(key string, target *string, allowed ...string) bool {
+	value, ok := b.lookup(key)
+	if !ok {
+		return false
+	}
+
+	for _, v := range allowed {
+		if v == value {
+			*target = value
+			return true
+		}
+	}
+	b.invalid(key, value, "must be one of "+strings.Join(allowed, ", "))
+	return false
+}
+
+// Bool binds the value of the config key parsed by strconv.ParseBool, so
+// true, True, TRUE and 1 are all accepted. It returns true if the key is set
+// to a valid value.
+func (b *ConfigBinder) Bool(key string, target *bool) bool {
+	value, ok := b.lookup(key)
+	if !ok {
+		return false
+	}
+
+	v, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		b.invalid(key, value, "must be a boolean")
+		return false
+	}
+	*target = v
+	return true
+}
+
+// Int binds the value of the config key parsed as a decimal integer between
+// min and max. It returns true if the key is set to a valid value.
+func (b *ConfigBinder) Int(key string, target *int, min, max int) bool {
+	value, ok := b.lookup(key)
+	if !ok {
+		return false
+	}
+
+	v, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		b.invalid(key, value, "must be an integer")
+		return false
+	}
+	if v < min || v > max {
+		b.invalid(key, value, fmt.Sprintf("must be between %d and %d", min, max))
+		return false
+	}
+	*target = v
+	return true
+}
+
+// Float binds the value of the config key parsed as a floating point number.
+// It returns true if the key is set to a valid value.
+func (b *ConfigBinder) Float(key string, target *float64) bool {
+	value, ok := b.lookup(key)
+	if !ok {
+		return false
+	}
+
+	v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		b.invalid(key, value, "must be a number")
+		return false
+	}
+	*target = v
+	return true
+}
+
+// Duration binds the value of the config key parsed by time.ParseDuration.
+// Negative durations are rejected. It returns true if the key is set to a
+// valid value.
+func (b *ConfigBinder) Duration(key string, target *time.Duration) bool {
+	return b.duration(key, target, false)
+}
+
+// PositiveDuration binds the value of the config key like Duration, but
+// also rejects zero. It returns true if the key is set to a valid value.
+func (b *ConfigBinder) PositiveDuration(key string, target *time.Duration) bool {
+	return b.duration(key, target, true)
+}
+
+func (b *ConfigBinder) duration(key string, target *time.Duration, positive bool) bool {
+	value, ok := b.lookup(key)
+	if !ok {
+		return false
+	}
+
+	v, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		b.invalid(key, value, "must be a duration, e.g. 30s")
+		return false
+	}
+	if positive && v <= 0 {
+		b.invalid(key, value, "must be a positive duration")
+		return false
+	}
+	if v < 0 {
+		b.invalid(key, value, "must not be negative")
+		return false
+	}
+	*target = v
+	return true
+}
+
+// List binds the comma separated values of the config key. Values are
+// trimmed and empty values are dropped. It returns true if the key is set.
+func (b *ConfigBinder) List(key string, target *[]string) bool {
+	value, ok := b.lookup(key)
+	if !ok {
+		return false
+	}
+
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	*target = values
+	return true
+}
+
+// Prefix binds all config keys starting with the prefix and returns their
+// values by the remainder of the key, e.g. the tag-<name> keys of the zmon
+// collector.
+func (b *ConfigBinder) Prefix(prefix string) map[string]string {
+	values := map[string]string{}
+	for key, value := range b.values {
+		if strings.HasPrefix(key, prefix) && len(key) > len(prefix) {
+			b.bound[key] = struct{}{}
+			values[strings.TrimPrefix(key, prefix)] = value
+		}
+	}
+	return values
+}
+
+// Err returns the first error of binding the config.
+func (b *ConfigBinder) Err() error {
+	return b.err
+}
+
+// Warnings returns a warning for each config key defined by an annotation
+// which wasn't bound. The config keys supported by all collectors are
+// handled outside of the collectors and never reported.
+func (b *ConfigBinder) Warnings() []string {
+	if b.ignoreUnbound {
+		return nil
+	}
+
+	var warnings []string
+	for key, annotation := range b.sources {
+		if _, ok := b.bound[key]; ok {
+			continue
+		}
+		if _, ok := findConfigKey(CommonConfigKeys, key); ok {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("config key '%s' in annotation %s is not used by the collector", key, annotation))
+	}
+	sort.Strings(warnings)
+	return warnings
+}
@@ -0,0 +1,295 @@
+package annotations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testAnnotation = "metric-config.external.rps.test/key"
+
+func TestConfigBinderCoercion(t *testing.T) {
+	for _, tc := range []struct {
+		msg      string
+		value    string
+		bind     func(b *ConfigBinder) interface{}
+		expected interface{}
+		err      string
+	}{
+		{
+			msg:   "bool true",
+			value: "true",
+			bind: func(b *ConfigBinder) interface{} {
+				var v bool
+				b.Bool("key", &v)
+				return v
+			},
+			expected: true,
+		},
+		{
+			msg:   "bool capitalized",
+			value: "True",
+			bind: func(b *ConfigBinder) interface{} {
+				var v bool
+				b.Bool("key", &v)
+				return v
+			},
+			expected: true,
+		},
+		{
+			msg:   "bool with whitespace",
+			value: " false ",
+			bind: func(b *ConfigBinder) interface{} {
+				v := true
+				b.Bool("key", &v)
+				return v
+			},
+			expected: false,
+		},
+		{
+			msg:   "invalid bool",
+			value: "yes",
+			bind: func(b *ConfigBinder) interface{} {
+				var v bool
+				b.Bool("key", &v)
+				return v
+			},
+			expected: false,
+			err:      "invalid value 'yes' of config key 'key' in annotation " + testAnnotation + ": must be a boolean",
+		},
+		{
+			msg:   "int",
+			value: "9090",
+			bind: func(b *ConfigBinder) interface{} {
+				var v int
+				b.Int("key", &v, 1, 65535)
+				return v
+			},
+			expected: 9090,
+		},
+		{
+			msg:   "invalid int",
+			value: "http",
+			bind: func(b *ConfigBinder) interface{} {
+				var v int
+				b.Int("key", &v, 1, 65535)
+				return v
+			},
+			expected: 0,
+			err:      "invalid value 'http' of config key 'key' in annotation " + testAnnotation + ": must be an integer",
+		},
+		{
+			msg:   "int out of range",
+			value: "70000",
+			bind: func(b *ConfigBinder) interface{} {
+				var v int
+				b.Int("key", &v, 1, 65535)
+				return v
+			},
+			expected: 0,
+			err:      "invalid value '70000' of config key 'key' in annotation " + testAnnotation + ": must be between 1 and 65535",
+		},
+		{
+			msg:   "float",
+			value: " 42.5",
+			bind: func(b *ConfigBinder) interface{} {
+				var v float64
+				b.Float("key", &v)
+				return v
+			},
+			expected: 42.5,
+		},
+		{
+			msg:   "invalid float",
+			value: "42%",
+			bind: func(b *ConfigBinder) interface{} {
+				var v float64
+				b.Float("key", &v)
+				return v
+			},
+			expected: 0.0,
+			err:      "invalid value '42%' of config key 'key' in annotation " + testAnnotation + ": must be a number",
+		},
+		{
+			msg:   "duration",
+			value: "1m30s",
+			bind: func(b *ConfigBinder) interface{} {
+				var v time.Duration
+				b.Duration("key", &v)
+				return v
+			},
+			expected: 90 * time.Second,
+		},
+		{
+			msg:   "zero duration",
+			value: "0",
+			bind: func(b *ConfigBinder) interface{} {
+				v := time.Minute
+				b.Duration("key", &v)
+				return v
+			},
+			expected: time.Duration(0),
+		},
+		{
+			msg:   "invalid duration",
+			value: "30",
+			bind: func(b *ConfigBinder) interface{} {
+				var v time.Duration
+				b.Duration("key", &v)
+				return v
+			},
+			expected: time.Duration(0),
+			err:      "invalid value '30' of config key 'key' in annotation " + testAnnotation + ": must be a duration, e.g. 30s",
+		},
+		{
+			msg:   "negative duration",
+			value: "-1s",
+			bind: func(b *ConfigBinder) interface{} {
+				var v time.Duration
+				b.Duration("key", &v)
+				return v
+			},
+			expected: time.Duration(0),
+			err:      "invalid value '-1s' of config key 'key' in annotation " + testAnnotation + ": must not be negative",
+		},
+		{
+			msg:   "zero positive duration",
+			value: "0s",
+			bind: func(b *ConfigBinder) interface{} {
+				var v time.Duration
+				b.PositiveDuration("key", &v)
+				return v
+			},
+			expected: time.Duration(0),
+			err:      "invalid value '0s' of config key 'key' in annotation " + testAnnotation + ": must be a positive duration",
+		},
+		{
+			msg:   "enum",
+			value: "max",
+			bind: func(b *ConfigBinder) interface{} {
+				v := "sum"
+				b.Enum("key", &v, "sum", "max")
+				return v
+			},
+			expected: "max",
+		},
+		{
+			msg:   "invalid enum",
+			value: "Max",
+			bind: func(b *ConfigBinder) interface{} {
+				v := "sum"
+				b.Enum("key", &v, "sum", "max")
+				return v
+			},
+			expected: "sum",
+			err:      "invalid value 'Max' of config key 'key' in annotation " + testAnnotation + ": must be one of sum, max",
+		},
+		{
+			msg:   "list",
+			value: "a, b,,c ",
+			bind: func(b *ConfigBinder) interface{} {
+				var v []string
+				b.List("key", &v)
+				return v
+			},
+			expected: []string{"a", "b", "c"},
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			b := NewConfigBinder(map[string]string{"key": tc.value}, map[string]string{"key": testAnnotation})
+			require.Equal(t, tc.expected, tc.bind(b))
+			if tc.err != "" {
+				require.EqualError(t, b.Err(), tc.err)
+			} else {
+				require.NoError(t, b.Err())
+			}
+		})
+	}
+}
+
+func TestConfigBinderDefaults(t *testing.T) {
+	b := NewConfigBinder(map[string]string{}, nil)
+
+	aggregator := "sum"
+	duration := 10 * time.Minute
+	port := 8080
+	enabled := true
+	var query string
+
+	require.False(t, b.Enum("aggregator", &aggregator, "sum", "max"))
+	require.False(t, b.Duration("duration", &duration))
+	require.False(t, b.Int("port", &port, 1, 65535))
+	require.False(t, b.Bool("enabled", &enabled))
+	require.False(t, b.String("query", &query))
+
+	require.Equal(t, "sum", aggregator)
+	require.Equal(t, 10*time.Minute, duration)
+	require.Equal(t, 8080, port)
+	require.True(t, enabled)
+	require.Equal(t, "", query)
+	require.NoError(t, b.Err())
+}
+
+func TestConfigBinderErrors(t *testing.T) {
+	// values of metric selector labels have no annotation
+	b := NewConfigBinder(map[string]string{"port": "http"}, nil)
+	var port int
+	b.Int("port", &port, 1, 65535)
+	require.EqualError(t, b.Err(), "invalid value 'http' of config key 'port': must be an integer")
+
+	b = NewConfigBinder(map[string]string{}, nil)
+	var query string
+	require.False(t, b.RequiredString("query", &query))
+	require.EqualError(t, b.Err(), "missing config key 'query'")
+
+	b = NewConfigBinder(map[string]string{}, nil)
+	b.Missing("check-id", "check-alias")
+	require.EqualError(t, b.Err(), "missing config key, one of 'check-id', 'check-alias' is required")
+
+	b = NewConfigBinder(map[string]string{"exclude-hosts": "[a"}, map[string]string{"exclude-hosts": testAnnotation})
+	b.Invalid("exclude-hosts", "invalid pattern")
+	require.EqualError(t, b.Err(), "invalid value '[a' of config key 'exclude-hosts' in annotation "+testAnnotation+": invalid pattern")
+
+	// only the first error is kept
+	b = NewConfigBinder(map[string]string{"port": "http", "duration": "1"}, nil)
+	var duration time.Duration
+	b.Int("port", &port, 1, 65535)
+	b.Duration("duration", &duration)
+	b.Missing("query")
+	require.EqualError(t, b.Err(), "invalid value 'http' of config key 'port': must be an integer")
+}
+
+func TestConfigBinderWarnings(t *testing.T) {
+	values := map[string]string{
+		"query":        "up",
+		"querry":       "up",
+		"interval":     "30s",
+		"tag-app":      "foo",
+		"selector-key": "bar",
+	}
+	sources := map[string]string{
+		"query":    "metric-config.external.rps.prometheus/query",
+		"querry":   "metric-config.external.rps.prometheus/querry",
+		"interval": "metric-config.external.rps.prometheus/interval",
+		"tag-app":  "metric-config.external.rps.prometheus/tag-app",
+	}
+
+	b := NewConfigBinder(values, sources)
+	var query string
+	b.String("query", &query)
+	require.Equal(t, []string{
+		"config key 'querry' in annotation metric-config.external.rps.prometheus/querry is not used by the collector",
+		"config key 'tag-app' in annotation metric-config.external.rps.prometheus/tag-app is not used by the collector",
+	}, b.Warnings())
+
+	b = NewConfigBinder(values, sources)
+	b.String("query", &query)
+	b.Used("querry")
+	require.Equal(t, map[string]string{"app": "foo"}, b.Prefix("tag-"))
+	require.Empty(t, b.Warnings())
+
+	b = NewConfigBinder(values, sources)
+	b.IgnoreUnbound()
+	require.Empty(t, b.Warnings())
+}
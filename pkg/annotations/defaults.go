@@ -0,0 +1,135 @@
+package annotations
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+)
+
+// namespaceDefaultsPrefix is the prefix of the annotations of a Namespace
+// defining default metric configs for the HPAs in the namespace.
+const namespaceDefaultsPrefix = "metric-config-default."
+
+// DefaultConfigKey identifies the metrics a namespace default applies to.
+type DefaultConfigKey struct {
+	Type          autoscalingv2.MetricSourceType
+	CollectorType string
+}
+
+// DefaultConfigMap holds the default metric configs of a namespace.
+type DefaultConfigMap map[DefaultConfigKey]*AnnotationConfigs
+
+// NamespaceDefaults returns the annotations defining default metric configs.
+// It returns nil if there are none.
+func NamespaceDefaults(annotations map[string]string) map[string]string {
+	var defaults map[string]string
+	for key, value := range annotations {
+		if !strings.HasPrefix(key, namespaceDefaultsPrefix) {
+			continue
+		}
+		if defaults == nil {
+			defaults = map[string]string{}
+		}
+		defaults[key] = value
+	}
+	return defaults
+}
+
+// IsNamespaceDefault returns true if the annotation defines a default
+// metric config of a namespace.
+func IsNamespaceDefault(annotation string) bool {
+	return strings.HasPrefix(annotation, namespaceDefaultsPrefix)
+}
+
+// ParseWithWarnings parses the default metric config annotations of a
+// Namespace into the DefaultConfigMap. It returns a warning for each
+// annotation which can't be parsed or whose config key isn't known for the
+// registered collector type. Values of known config keys must match their
+// type.
+//
+// The annotation keys have the format
+// metric-config-default.<metricType>.<collectorType>/<configKey>, e.g.
+// metric-config-default.external.prometheus/prometheus-server.
+func (m DefaultConfigMap) ParseWithWarnings(annotations map[string]string) ([]string, error) {
+	var warnings []string
+
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		if strings.HasPrefix(key, namespaceDefaultsPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, annotation := range keys {
+		val := annotations[annotation]
+
+		key, configKey, err := parseDefaultKey(annotation)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("ignoring namespace annotation %s: %v", annotation, err))
+			continue
+		}
+
+		knownKey, known, registered := LookupConfigKey(key.CollectorType, configKey)
+		if registered && !known {
+			warnings = append(warnings, fmt.Sprintf("unknown config key '%s' of collector '%s' in namespace annotation %s", configKey, key.CollectorType, annotation))
+		}
+		if known {
+			if err := knownKey.Validate(val); err != nil {
+				return warnings, fmt.Errorf("failed to parse %s value %s of namespace annotation %s: %v", configKey, val, annotation, err)
+			}
+		}
+
+		config, ok := m[key]
+		if !ok {
+			config = &AnnotationConfigs{
+				CollectorType: key.CollectorType,
+				Configs:       map[string]string{},
+				Sources:       map[string]string{},
+			}
+			m[key] = config
+		}
+
+		if err := config.set(annotation, configKey, val); err != nil {
+			return warnings, fmt.Errorf("%v of namespace annotation %s", err, annotation)
+		}
+	}
+	return warnings, nil
+}
+
+// Get returns the default config of the metrics of the type collected by
+// the collector type.
+func (m DefaultConfigMap) Get(metricType autoscalingv2.MetricSourceType, collectorType string) (*AnnotationConfigs, bool) {
+	config, ok := m[DefaultConfigKey{Type: metricType, CollectorType: collectorType}]
+	return config, ok
+}
+
+// parseDefaultKey parses a default metric config annotation key into the
+// metrics it applies to and the config key.
+func parseDefaultKey(annotation string) (DefaultConfigKey, string, error) {
+	metricPart, configKey, found := strings.Cut(strings.TrimPrefix(annotation, namespaceDefaultsPrefix), "/")
+	if !found || configKey == "" {
+		return DefaultConfigKey{}, "", fmt.Errorf("missing config key")
+	}
+
+	typ, collectorType, found := strings.Cut(metricPart, ".")
+	if !found || collectorType == "" || strings.Contains(collectorType, ".") {
+		return DefaultConfigKey{}, "", fmt.Errorf("expected <metricType>.<collectorType>")
+	}
+
+	key := DefaultConfigKey{CollectorType: collectorType}
+	switch typ {
+	case "pods":
+		key.Type = autoscalingv2.PodsMetricSourceType
+	case "object":
+		key.Type = autoscalingv2.ObjectMetricSourceType
+	case "external":
+		key.Type = autoscalingv2.ExternalMetricSourceType
+	default:
+		return DefaultConfigKey{}, "", fmt.Errorf("unknown metric type '%s'", typ)
+	}
+
+	return key, configKey, nil
+}
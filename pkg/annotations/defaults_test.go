@@ -0,0 +1,76 @@
+package annotations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+)
+
+func TestNamespaceDefaults(t *testing.T) {
+	require.Nil(t, NamespaceDefaults(map[string]string{"unrelated-annotation": "value"}))
+	require.Equal(t, map[string]string{
+		"metric-config-default.external.prometheus/prometheus-server": "http://prometheus",
+	}, NamespaceDefaults(map[string]string{
+		"metric-config-default.external.prometheus/prometheus-server": "http://prometheus",
+		"metric-config.external.rps.prometheus/query":                 "sum(rps)",
+	}))
+}
+
+func TestDefaultsParser(t *testing.T) {
+	defaults := make(DefaultConfigMap)
+	warnings, err := defaults.ParseWithWarnings(map[string]string{
+		"metric-config-default.external.prometheus/prometheus-server": "http://prometheus",
+		"metric-config-default.external.prometheus/interval":          "1m",
+		"metric-config-default.pods.json-path/per-replica":            "true",
+		"metric-config-default.pods.json-path/min-pod-ready-age":      "30s",
+		"unrelated-annotation":                                        "value",
+	})
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+
+	config, ok := defaults.Get(autoscalingv2.ExternalMetricSourceType, "prometheus")
+	require.True(t, ok)
+	require.Equal(t, "prometheus", config.CollectorType)
+	require.Equal(t, map[string]string{"prometheus-server": "http://prometheus"}, config.Configs)
+	require.Equal(t, time.Minute, config.Interval)
+
+	config, ok = defaults.Get(autoscalingv2.PodsMetricSourceType, "json-path")
+	require.True(t, ok)
+	require.True(t, config.PerReplica)
+	require.Equal(t, 30*time.Second, config.MinPodReadyAge)
+
+	_, ok = defaults.Get(autoscalingv2.PodsMetricSourceType, "prometheus")
+	require.False(t, ok)
+}
+
+func TestDefaultsParserWarnings(t *testing.T) {
+	defaults := make(DefaultConfigMap)
+	warnings, err := defaults.ParseWithWarnings(map[string]string{
+		"metric-config-default.external.prometheus":         "http://prometheus",
+		"metric-config-default.external/prometheus-server":  "http://prometheus",
+		"metric-config-default.unknown.prometheus/query":    "sum(rps)",
+		"metric-config-default.external.rps.prometheus/key": "value",
+		"metric-config-default.pods.json-path/jsonkey":      "$.rps",
+	})
+	require.NoError(t, err)
+	require.Len(t, warnings, 5)
+
+	// unknown keys are still passed to the collector.
+	config, ok := defaults.Get(autoscalingv2.PodsMetricSourceType, "json-path")
+	require.True(t, ok)
+	require.Equal(t, map[string]string{"jsonkey": "$.rps"}, config.Configs)
+}
+
+func TestDefaultsParserInvalidValues(t *testing.T) {
+	for _, annotations := range []map[string]string{
+		{"metric-config-default.pods.json-path/interval": "30"},
+		{"metric-config-default.pods.json-path/aggregator": "median"},
+		{"metric-config-default.external.zmon/derive": "increase"},
+	} {
+		defaults := make(DefaultConfigMap)
+		_, err := defaults.ParseWithWarnings(annotations)
+		require.Error(t, err, annotations)
+	}
+}
@@ -2,6 +2,8 @@ package annotations
 
 import (
 	"fmt"
+	"net/url"
+	"sort"
 	"strings"
 	"time"
 
@@ -9,18 +11,24 @@ import (
 )
 
 const (
-	customMetricsPrefix      = "metric-config."
-	perReplicaMetricsConfKey = "per-replica"
-	intervalMetricsConfKey   = "interval"
-	minPodReadyAgeConfKey    = "min-pod-ready-age"
+	customMetricsPrefix = "metric-config."
+
+	// maxConfigValueLength is the maximum length of the value of a
+	// metric config annotation. Values are echoed in events and logs, so
+	// they're bounded well below the total size limit of annotations.
+	maxConfigValueLength = 16 * 1024
 )
 
 type AnnotationConfigs struct {
-	CollectorType  string
-	Configs        map[string]string
+	CollectorType string
+	Configs       map[string]string
+	// Sources are the annotations defining the Configs by config key.
+	Sources        map[string]string
 	PerReplica     bool
 	Interval       time.Duration
 	MinPodReadyAge time.Duration
+	// TTL overrides the metrics TTL of the metric store if set.
+	TTL time.Duration
 }
 
 type MetricConfigKey struct {
@@ -30,81 +38,164 @@ type MetricConfigKey struct {
 
 type AnnotationConfigMap map[MetricConfigKey]*AnnotationConfigs
 
+// Parse parses the metric config annotations into the AnnotationConfigMap.
+// Annotations which can't be attributed to a metric are ignored.
 func (m AnnotationConfigMap) Parse(annotations map[string]string) error {
-	for key, val := range annotations {
-		if !strings.HasPrefix(key, customMetricsPrefix) {
-			continue
-		}
+	_, err := m.ParseWithWarnings(annotations)
+	return err
+}
 
-		parts := strings.Split(key, "/")
-		if len(parts) != 2 {
-			// TODO: error?
-			continue
+// ParseWithWarnings parses the metric config annotations into the
+// AnnotationConfigMap and returns a warning for each metric config
+// annotation which can't be attributed to a metric or whose config key isn't
+// known for the registered collector type. Values of known config keys must
+// match their type.
+//
+// The annotation keys have the format
+// metric-config.<metricType>.<metricName>.<collectorType>/<configKey>. The
+// metric type is one of pods or object, any other metric type is parsed as
+// external. The collector type can't contain dots, everything in between is
+// the metric name. Metric names containing a '/' must escape it as '%2F' (URL
+// path escaping).
+func (m AnnotationConfigMap) ParseWithWarnings(annotations map[string]string) ([]string, error) {
+	var warnings []string
+
+	// parse annotations in a stable order to get deterministic results
+	// for conflicting collector types.
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		if strings.HasPrefix(key, customMetricsPrefix) {
+			keys = append(keys, key)
 		}
+	}
+	sort.Strings(keys)
 
-		configs := strings.Split(parts[0], ".")
-		if len(configs) != 4 {
-			// TODO: error?
-			continue
-		}
+	for _, annotation := range keys {
+		val := annotations[annotation]
 
-		key := MetricConfigKey{
-			MetricName: configs[2],
+		key, metricCollector, configKey, err := parseAnnotationKey(annotation)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("ignoring annotation %s: %v", annotation, err))
+			continue
 		}
 
-		switch configs[1] {
-		case "pods":
-			key.Type = autoscalingv2.PodsMetricSourceType
-		case "object":
-			key.Type = autoscalingv2.ObjectMetricSourceType
-		default:
-			key.Type = autoscalingv2.ExternalMetricSourceType
+		if len(val) > maxConfigValueLength {
+			return warnings, fmt.Errorf("value of %s for %s exceeds %d bytes", configKey, key, maxConfigValueLength)
 		}
 
-		metricCollector := configs[3]
-
 		config, ok := m[key]
 		if !ok {
 			config = &AnnotationConfigs{
 				CollectorType: metricCollector,
 				Configs:       map[string]string{},
+				Sources:       map[string]string{},
 			}
 			m[key] = config
 		}
 
-		// TODO: fail if collector name doesn't match
 		if config.CollectorType != metricCollector {
+			warnings = append(warnings, fmt.Sprintf("ignoring annotation %s: collector '%s' doesn't match collector '%s' of metric %s", annotation, metricCollector, config.CollectorType, key.MetricName))
 			continue
 		}
 
-		if parts[1] == perReplicaMetricsConfKey {
-			config.PerReplica = true
-			continue
+		knownKey, known, registered := LookupConfigKey(metricCollector, configKey)
+		if registered && !known {
+			// unknown keys are still passed to the collector.
+			warnings = append(warnings, fmt.Sprintf("unknown config key '%s' of collector '%s' in annotation %s", configKey, metricCollector, annotation))
 		}
-
-		if parts[1] == intervalMetricsConfKey {
-			interval, err := time.ParseDuration(val)
-			if err != nil {
-				return fmt.Errorf("failed to parse interval value %s for %s: %v", val, key, err)
+		if known {
+			if err := knownKey.Validate(val); err != nil {
+				return warnings, fmt.Errorf("failed to parse %s value %s for %s: %v", configKey, val, key, err)
 			}
-			config.Interval = interval
-			continue
 		}
 
-		if parts[1] == minPodReadyAgeConfKey {
-			minPodReadyAge, err := time.ParseDuration(val)
-			if err != nil {
-				return fmt.Errorf("failed to parse min-pod-ready-age value %s for %s: %v", val, key, err)
-			}
-			config.MinPodReadyAge = minPodReadyAge
-			continue
+		if err := config.set(annotation, configKey, val); err != nil {
+			return warnings, fmt.Errorf("%v for %s", err, key)
 		}
+	}
+	return warnings, nil
+}
 
-		config.Configs[parts[1]] = val
+// set sets the config key to the value of the annotation. The keys handled
+// by the parser are set on their fields, all other keys are added to the
+// Configs.
+func (c *AnnotationConfigs) set(annotation, configKey, val string) error {
+	switch configKey {
+	case PerReplicaConfigKey:
+		c.PerReplica = true
+	case IntervalConfigKey:
+		interval, err := time.ParseDuration(val)
+		if err != nil {
+			return fmt.Errorf("failed to parse interval value %s: %v", val, err)
+		}
+		c.Interval = interval
+	case MinPodReadyAgeConfigKey:
+		minPodReadyAge, err := time.ParseDuration(val)
+		if err != nil {
+			return fmt.Errorf("failed to parse min-pod-ready-age value %s: %v", val, err)
+		}
+		c.MinPodReadyAge = minPodReadyAge
+	case TTLConfigKey:
+		ttl, err := time.ParseDuration(val)
+		if err != nil {
+			return fmt.Errorf("failed to parse ttl value %s: %v", val, err)
+		}
+		if ttl <= 0 {
+			return fmt.Errorf("ttl must be positive, got %s", val)
+		}
+		c.TTL = ttl
+	default:
+		c.Configs[configKey] = val
+		c.Sources[configKey] = annotation
 	}
 	return nil
 }
 
+// parseAnnotationKey parses a metric config annotation key into the metric
+// it configures, the collector type and the config key.
+func parseAnnotationKey(annotation string) (MetricConfigKey, string, string, error) {
+	// the config key is everything after the first '/' and may contain
+	// further slashes.
+	metricPart, configKey, found := strings.Cut(strings.TrimPrefix(annotation, customMetricsPrefix), "/")
+	if !found || configKey == "" {
+		return MetricConfigKey{}, "", "", fmt.Errorf("missing config key")
+	}
+
+	typ, rest, found := strings.Cut(metricPart, ".")
+	if !found {
+		return MetricConfigKey{}, "", "", fmt.Errorf("missing metric name and collector")
+	}
+
+	key := MetricConfigKey{}
+	switch typ {
+	case "pods":
+		key.Type = autoscalingv2.PodsMetricSourceType
+	case "object":
+		key.Type = autoscalingv2.ObjectMetricSourceType
+	default:
+		key.Type = autoscalingv2.ExternalMetricSourceType
+	}
+
+	idx := strings.LastIndex(rest, ".")
+	if idx <= 0 || idx == len(rest)-1 {
+		return MetricConfigKey{}, "", "", fmt.Errorf("missing metric name or collector")
+	}
+
+	metricName, err := url.PathUnescape(rest[:idx])
+	if err != nil {
+		return MetricConfigKey{}, "", "", fmt.Errorf("invalid escaping of metric name: %v", err)
+	}
+	key.MetricName = metricName
+
+	return key, rest[idx+1:], configKey, nil
+}
+
+// IsIntervalAnnotation returns true if the annotation key configures the
+// collection interval of a metric.
+func IsIntervalAnnotation(key string) bool {
+	return strings.HasPrefix(key, customMetricsPrefix) && strings.HasSuffix(key, "/"+IntervalConfigKey)
+}
+
 func (m AnnotationConfigMap) GetAnnotationConfig(metricName string, metricType autoscalingv2.MetricSourceType) (*AnnotationConfigs, bool) {
 	key := MetricConfigKey{MetricName: metricName, Type: metricType}
 	config, ok := m[key]
@@ -1,7 +1,9 @@
 package annotations
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
@@ -15,6 +17,7 @@ func TestParser(t *testing.T) {
 		MetricType     autoscalingv2.MetricSourceType
 		ExpectedConfig map[string]string
 		PerReplica     bool
+		TTL            time.Duration
 	}{
 		{
 			Name:           "no annotations",
@@ -102,6 +105,63 @@ func TestParser(t *testing.T) {
 				"aggregator": "avg",
 			},
 		},
+		{
+			Name: "dotted metric name",
+			Annotations: map[string]string{
+				"metric-config.external.queue.primary.zmon/key": "queue.depth",
+			},
+			MetricName: "queue.primary",
+			MetricType: autoscalingv2.ExternalMetricSourceType,
+			ExpectedConfig: map[string]string{
+				"key": "queue.depth",
+			},
+		},
+		{
+			Name: "config key containing slashes",
+			Annotations: map[string]string{
+				"metric-config.pods.requests.v1.json-path/path/to/metrics": "true",
+			},
+			MetricName: "requests.v1",
+			MetricType: autoscalingv2.PodsMetricSourceType,
+			ExpectedConfig: map[string]string{
+				"path/to/metrics": "true",
+			},
+		},
+		{
+			Name: "escaped metric name",
+			Annotations: map[string]string{
+				"metric-config.external.queue.primary%2Fdepth.prometheus/query": "sum(queue_depth)",
+			},
+			MetricName: "queue.primary/depth",
+			MetricType: autoscalingv2.ExternalMetricSourceType,
+			ExpectedConfig: map[string]string{
+				"query": "sum(queue_depth)",
+			},
+		},
+		{
+			Name: "unknown metric type defaults to external",
+			Annotations: map[string]string{
+				"metric-config.queue.length.sqs/queue-name": "jobs",
+			},
+			MetricName: "length",
+			MetricType: autoscalingv2.ExternalMetricSourceType,
+			ExpectedConfig: map[string]string{
+				"queue-name": "jobs",
+			},
+		},
+		{
+			Name: "metric ttl",
+			Annotations: map[string]string{
+				"metric-config.external.queue-length.sqs/queue-name": "jobs",
+				"metric-config.external.queue-length.sqs/ttl":        "5m",
+			},
+			MetricName: "queue-length",
+			MetricType: autoscalingv2.ExternalMetricSourceType,
+			ExpectedConfig: map[string]string{
+				"queue-name": "jobs",
+			},
+			TTL: 5 * time.Minute,
+		},
 	} {
 		t.Run(tc.Name, func(t *testing.T) {
 			hpaMap := make(AnnotationConfigMap)
@@ -117,6 +177,101 @@ func TestParser(t *testing.T) {
 				require.Equal(t, v, config.Configs[k])
 			}
 			require.Equal(t, tc.PerReplica, config.PerReplica)
+			require.Equal(t, tc.TTL, config.TTL)
+			require.NotContains(t, config.Configs, TTLConfigKey)
 		})
 	}
 }
+
+func TestParserWarnings(t *testing.T) {
+	hpaMap := make(AnnotationConfigMap)
+	warnings, err := hpaMap.ParseWithWarnings(map[string]string{
+		"metric-config.external.prometheus/query":     "sum(rps)",
+		"metric-config.external.rps/query":            "sum(rps)",
+		"metric-config.external.rps.prometheus":       "sum(rps)",
+		"metric-config.external.rps%zz.prometheus/q":  "sum(rps)",
+		"metric-config.external.rps.prometheus/query": "sum(rps)",
+		"metric-config.external.rps.zmon/key":         "custom.*",
+		"unrelated-annotation":                        "value",
+	})
+	require.NoError(t, err)
+	require.Len(t, warnings, 5)
+
+	config, present := hpaMap.GetAnnotationConfig("rps", autoscalingv2.ExternalMetricSourceType)
+	require.True(t, present)
+	require.Equal(t, "prometheus", config.CollectorType)
+	require.Equal(t, map[string]string{"query": "sum(rps)"}, config.Configs)
+}
+
+func TestParserUnknownConfigKeys(t *testing.T) {
+	hpaMap := make(AnnotationConfigMap)
+	warnings, err := hpaMap.ParseWithWarnings(map[string]string{
+		"metric-config.pods.rps.json-path/json-key":  "$.rps",
+		"metric-config.pods.rps.json-path/jsonkey":   "$.rps",
+		"metric-config.external.rps.custom/anything": "value",
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"unknown config key 'jsonkey' of collector 'json-path' in annotation metric-config.pods.rps.json-path/jsonkey"}, warnings)
+
+	// unknown keys are still passed to the collector.
+	config, present := hpaMap.GetAnnotationConfig("rps", autoscalingv2.PodsMetricSourceType)
+	require.True(t, present)
+	require.Equal(t, map[string]string{"json-key": "$.rps", "jsonkey": "$.rps"}, config.Configs)
+}
+
+func TestParserInvalidValues(t *testing.T) {
+	for _, annotations := range []map[string]string{
+		{"metric-config.pods.rps.json-path/interval": "30"},
+		{"metric-config.pods.rps.json-path/port": "http"},
+		{"metric-config.pods.rps.json-path/aggregator": "median"},
+		{"metric-config.external.lag.nakadi/unassigned-partitions": "min"},
+		{"metric-config.external.rps.zmon/derive": "increase"},
+		{"metric-config.external.rps.zmon/ttl": "5"},
+		{"metric-config.external.rps.zmon/ttl": "0s"},
+	} {
+		hpaMap := make(AnnotationConfigMap)
+		require.Error(t, hpaMap.Parse(annotations), annotations)
+	}
+}
+
+func TestParserValueTooLong(t *testing.T) {
+	annotations := map[string]string{
+		"metric-config.external.foo.prometheus/query": strings.Repeat("x", maxConfigValueLength+1),
+	}
+	err := AnnotationConfigMap{}.Parse(annotations)
+	require.EqualError(t, err, "value of query for {External foo} exceeds 16384 bytes")
+
+	annotations["metric-config.external.foo.prometheus/query"] = strings.Repeat("x", maxConfigValueLength)
+	require.NoError(t, AnnotationConfigMap{}.Parse(annotations))
+}
+
+func FuzzParseWithWarnings(f *testing.F) {
+	f.Add("metric-config.pods.requests-per-second.json-path/json-key", "$.http_server.rps")
+	f.Add("metric-config.external.processed-events-per-second.prometheus/query", "scalar(sum(rate(event-service_events_count{application=\"event-service\",processed=\"true\"}[1m])))")
+	f.Add("metric-config.object.requests-per-second.skipper/interval", "30s")
+	f.Add("metric-config.pods.foo.json-path/interval", "-1h")
+	f.Add("metric-config.external.foo%2Fbar.prometheus/per-replica", "")
+	f.Add("metric-config.external.foo%zz.prometheus/query", "q")
+	f.Add("metric-config.pods..json-path/port", "9090")
+	f.Add("metric-config.pods.foo./port", "9090")
+	f.Add("metric-config.external/", "")
+	f.Add("metric-config.object.foo.redis/kind", "hash")
+	f.Add("metric-config.pods.foo.json-path/min-pod-ready-age", "9223372036854775807ns")
+
+	f.Fuzz(func(t *testing.T, annotation, value string) {
+		annotations := map[string]string{annotation: value}
+		if !strings.HasPrefix(annotation, customMetricsPrefix) {
+			annotations[customMetricsPrefix+annotation] = value
+		}
+
+		m := AnnotationConfigMap{}
+		_, err := m.ParseWithWarnings(annotations)
+		if err != nil {
+			return
+		}
+		for _, config := range m {
+			require.NotNil(t, config.Configs)
+			require.GreaterOrEqual(t, len(config.Configs), len(config.Sources))
+		}
+	})
+}
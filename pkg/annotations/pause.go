@@ -0,0 +1,11 @@
+package annotations
+
+// DefaultPauseAnnotation is the default annotation pausing the metric
+// collection and the scheduled scaling of an HPA.
+const DefaultPauseAnnotation = "autoscaling.zalando.org/paused"
+
+// IsPaused returns true if the pause annotation is set to "true". An empty
+// pauseAnnotation disables pausing.
+func IsPaused(annotations map[string]string, pauseAnnotation string) bool {
+	return pauseAnnotation != "" && annotations[pauseAnnotation] == "true"
+}
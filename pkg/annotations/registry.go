@@ -0,0 +1,358 @@
+package annotations
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValueType is the type of the value of a metric config key.
+type ValueType string
+
+const (
+	// StringValue is an arbitrary string.
+	StringValue ValueType = "string"
+	// DurationValue is a duration parsed by time.ParseDuration, e.g. 30s.
+	DurationValue ValueType = "duration"
+	// BooleanValue is a boolean parsed by strconv.ParseBool.
+	BooleanValue ValueType = "boolean"
+	// IntegerValue is a decimal integer.
+	IntegerValue ValueType = "integer"
+	// NumberValue is a floating point number.
+	NumberValue ValueType = "number"
+)
+
+// Config keys handled by the parser.
+const (
+	PerReplicaConfigKey     = "per-replica"
+	IntervalConfigKey       = "interval"
+	MinPodReadyAgeConfigKey = "min-pod-ready-age"
+	TTLConfigKey            = "ttl"
+)
+
+// ConfigKey describes a metric config key.
+type ConfigKey struct {
+	Name        string
+	Type        ValueType
+	Enum        []string
+	Description string
+	// Prefix matches all config keys starting with Name, e.g. the
+	// tag-<name> keys of the zmon collector.
+	Prefix bool
+	// Sensitive keys carry credentials, e.g. tokens.
+	Sensitive bool
+	// Endpoint keys override the server the collector queries instead of
+	// the server configured for the adapter.
+	Endpoint bool
+}
+
+// CollectorConfig describes the metric config keys supported by a
+// collector type.
+type CollectorConfig struct {
+	Type string
+	Keys []ConfigKey
+	// AdditionalKeys allows config keys which aren't listed, e.g. the
+	// named queries referenced by the query-name of the prometheus
+	// collector.
+	AdditionalKeys bool
+}
+
+// CommonConfigKeys are the config keys supported by all collectors.
+var CommonConfigKeys = []ConfigKey{
+	{Name: PerReplicaConfigKey, Type: StringValue, Description: "divide the metric value by the number of replicas of the scale target, enabled if set"},
+	{Name: IntervalConfigKey, Type: DurationValue, Description: "interval at which the metric is collected"},
+	{Name: MinPodReadyAgeConfigKey, Type: DurationValue, Description: "minimum time a pod must be ready before it's considered"},
+	{Name: TTLConfigKey, Type: DurationValue, Description: "time the collected metric is served without a new collection, overriding --metrics-ttl"},
+	{Name: "timeout", Type: DurationValue, Description: "timeout of a single collection"},
+	{Name: "max-source-age", Type: DurationValue, Description: "fail the collection if the timestamp of the upstream sample is older, Prometheus samples carry the query evaluation time"},
+	{Name: "derive", Type: StringValue, Enum: []string{"rate", "delta"}, Description: "serve the change of the value instead of the value"},
+	{Name: "reset-policy", Type: StringValue, Enum: []string{"zero", "error"}, Description: "handling of decreasing values when a rate or delta is derived"},
+	{Name: "drop-labels", Type: StringValue, Description: "comma separated labels dropped from the external metric series"},
+	{Name: "keep-labels", Type: StringValue, Description: "comma separated labels kept on the external metric series"},
+	{Name: "serve-aggregation", Type: StringValue, Enum: []string{"all", "max", "sum", "avg"}, Description: "serve a single series aggregated from all series of the external metric"},
+	{Name: "cluster-scoped", Type: BooleanValue, Description: "store the external metric cluster scoped to serve it for queries of any namespace, requires --allow-cluster-scoped-external-metrics"},
+	{Name: "shared", Type: BooleanValue, Description: "collect the external metric once for all HPAs of the namespace configuring it with the same config"},
+	{Name: "outlier-filter", Type: StringValue, Enum: []string{"mad"}, Description: "reject collections with values deviating too much from the median of the previous values"},
+	{Name: "outlier-window", Type: IntegerValue, Description: "number of previous values the outlier filter compares to, defaults to 10"},
+	{Name: "outlier-threshold", Type: NumberValue, Description: "number of median absolute deviations from the median a value is rejected beyond, defaults to 6"},
+	{Name: "smoothing", Type: StringValue, Enum: []string{"ewma", "max-change"}, Description: "serve the smoothed value to dampen spikes of the collected value, not applied to scaling schedules by namespace defaults"},
+	{Name: "ewma-alpha", Type: NumberValue, Description: "weight of the collected value in the moving average of ewma smoothing, defaults to 0.5"},
+	{Name: "max-change-percent", Type: NumberValue, Description: "max change of the served value between two collections relative to the previous value for max-change smoothing"},
+}
+
+var podAggregateConfigKeys = []ConfigKey{
+	{Name: "emit-aggregate", Type: StringValue, Enum: []string{"sum", "max", "avg"}, Description: "additionally emit the aggregate of the pod values as Object metric of the scale target"},
+	{Name: "aggregate-only", Type: BooleanValue, Description: "only emit the aggregate of the pod values"},
+}
+
+var podMetricsConfigKeys = append([]ConfigKey{
+	{Name: "aggregator", Type: StringValue, Enum: []string{"avg", "min", "max", "sum"}, Description: "aggregation of multiple values returned by a pod"},
+}, podAggregateConfigKeys...)
+
+var httpConfigKeys = []ConfigKey{
+	{Name: "scheme", Type: StringValue, Enum: []string{"http", "https"}, Description: "scheme of the metrics endpoint"},
+	{Name: "path", Type: StringValue, Description: "path of the metrics endpoint"},
+	{Name: "raw-query", Type: StringValue, Description: "query string of the metrics endpoint"},
+	{Name: "port", Type: IntegerValue, Description: "port of the metrics endpoint"},
+	{Name: "request-timeout", Type: DurationValue, Description: "timeout of the requests to the metrics endpoint"},
+	{Name: "connect-timeout", Type: DurationValue, Description: "timeout of the connections to the metrics endpoint"},
+	{Name: "max-response-size", Type: IntegerValue, Description: "maximum number of bytes read from the metrics endpoint overriding --max-response-size"},
+}
+
+// Collectors are the collector types with the config keys they support.
+var Collectors = []CollectorConfig{
+	{
+		Type: "prometheus",
+		Keys: []ConfigKey{
+			{Name: "query", Type: StringValue, Description: "PromQL query returning the metric value"},
+			{Name: "query-name", Type: StringValue, Description: "name of the config key holding the query"},
+			{Name: "prometheus-server", Type: StringValue, Endpoint: true, Description: "URL of the Prometheus server overriding the default"},
+		},
+		AdditionalKeys: true,
+	},
+	{
+		Type: "json-path",
+		Keys: append(append([]ConfigKey{
+			{Name: "json-key", Type: StringValue, Description: "JSONPath of the value in the response"},
+			{Name: "endpoint", Type: StringValue, Description: "URL of the endpoint of an external metric"},
+		}, httpConfigKeys...), podMetricsConfigKeys...),
+	},
+	{
+		Type: "kubelet",
+		Keys: append([]ConfigKey{
+			{Name: "container", Type: StringValue, Description: "name of the container whose stats are used"},
+			{Name: "json-key", Type: StringValue, Description: "JSONPath of the value in the container stats"},
+		}, podMetricsConfigKeys...),
+	},
+	{
+		Type: "prometheus-exposition",
+		Keys: append(append([]ConfigKey{
+			{Name: "metric-name", Type: StringValue, Description: "name of the metric in the Prometheus text format"},
+			{Name: "match-labels", Type: StringValue, Description: "comma separated <label>=<value> matchers of the series"},
+			{Name: "rate-over", Type: DurationValue, Description: "return the per-second rate of a counter over the window"},
+		}, httpConfigKeys...), podMetricsConfigKeys...),
+	},
+	{
+		Type: "pushed",
+		Keys: append([]ConfigKey{
+			{Name: "metric-name", Type: StringValue, Description: "name of the pushed metric overriding the name of the HPA metric"},
+			{Name: "max-staleness", Type: DurationValue, Description: "maximum age of a pushed value, pods with older values are skipped"},
+		}, podAggregateConfigKeys...),
+	},
+	{
+		Type: "influxdb",
+		Keys: []ConfigKey{
+			{Name: "address", Type: StringValue, Endpoint: true, Description: "address of the InfluxDB server overriding the default"},
+			{Name: "token", Type: StringValue, Sensitive: true, Description: "token of the InfluxDB server overriding the default"},
+			{Name: "org", Type: StringValue, Description: "organization overriding the default"},
+			{Name: "query-name", Type: StringValue, Description: "name of the config key holding the Flux query"},
+		},
+		AdditionalKeys: true,
+	},
+	{
+		Type: "zmon",
+		Keys: []ConfigKey{
+			{Name: "check-id", Type: StringValue, Description: "ID or comma separated IDs of the ZMON checks"},
+			{Name: "check-alias", Type: StringValue, Description: "alias of the ZMON check configured in the adapter"},
+			{Name: "key", Type: StringValue, Description: "key of the check result"},
+			{Name: "duration", Type: DurationValue, Description: "time range of the check results"},
+			{Name: "aggregators", Type: StringValue, Description: "comma separated aggregators of the check results"},
+			{Name: "tag-", Type: StringValue, Prefix: true, Description: "tag-<name> filters the check results by the entity tag"},
+			{Name: "aggregator-across-checks", Type: StringValue, Enum: []string{"sum", "max", "min", "avg"}, Description: "aggregation of the values of multiple checks"},
+			{Name: "partial", Type: StringValue, Enum: []string{"error", "skip"}, Description: "handling of checks without a result when multiple checks are aggregated"},
+		},
+	},
+	{
+		Type: "nakadi",
+		Keys: []ConfigKey{
+			{Name: "subscription-id", Type: StringValue, Description: "ID of the Nakadi subscription"},
+			{Name: "metric-type", Type: StringValue, Enum: []string{"consumer-lag-seconds", "unconsumed-events"}, Description: "type of the subscription metric"},
+			{Name: "unassigned-partitions", Type: StringValue, Enum: []string{"ignore", "max", "error"}, Description: "handling of partitions not assigned to a consumer"},
+		},
+	},
+	{
+		Type: "sql",
+		Keys: []ConfigKey{
+			{Name: "query", Type: StringValue, Description: "SQL query returning the metric value"},
+		},
+	},
+	{
+		Type: "redis",
+		Keys: []ConfigKey{
+			{Name: "key", Type: StringValue, Description: "key of the Redis list, stream or set"},
+			{Name: "kind", Type: StringValue, Enum: []string{"list", "stream", "set"}, Description: "kind of the key, measured by LLEN, XLEN or SCARD"},
+			{Name: "address", Type: StringValue, Endpoint: true, Description: "address of the Redis server overriding the default, must be allowed by --redis-allowed-addresses"},
+		},
+	},
+	{
+		Type: "skipper",
+		Keys: []ConfigKey{
+			{Name: "backend", Type: StringValue, Description: "backend used to weight the requests"},
+			{Name: "exclude-hosts", Type: StringValue, Description: "comma separated host globs excluded from the requests"},
+			{Name: "skip-existence-check", Type: BooleanValue, Description: "create the collector even if the Ingress or RouteGroup doesn't exist yet"},
+			{Name: "not-found-retries", Type: IntegerValue, Description: "number of retries of lookups of the Ingress or RouteGroup failing with NotFound, defaults to 2"},
+			{Name: "not-found-retry-backoff", Type: DurationValue, Description: "backoff before the first retry of a lookup, doubled for each retry, defaults to 500ms"},
+			{Name: "tolerate-missing-object-for", Type: DurationValue, Description: "grace period the last query is reused for while the Ingress or RouteGroup is missing"},
+		},
+	},
+	{
+		Type: "requests-per-second",
+		Keys: []ConfigKey{
+			{Name: "hostnames", Type: StringValue, Description: "comma separated hostnames of the requests"},
+			{Name: "weight", Type: NumberValue, Description: "percentage of the requests accounted for"},
+			{Name: "exclude-hosts", Type: StringValue, Description: "comma separated host globs excluded from the requests"},
+		},
+	},
+	{
+		Type: "http-probe",
+		Keys: []ConfigKey{
+			{Name: "url", Type: StringValue, Description: "URL of the probed endpoint"},
+			{Name: "method", Type: StringValue, Enum: []string{"GET", "HEAD"}, Description: "method of the probe requests, defaults to GET"},
+			{Name: "measurement", Type: StringValue, Enum: []string{"latency-ms", "status-ok-ratio"}, Description: "median latency of the probes in milliseconds or ratio of probes with a 2xx status"},
+			{Name: "samples", Type: IntegerValue, Description: "number of probes per collection, defaults to 3"},
+		},
+	},
+	{
+		Type: "sqs-queue-length",
+		Keys: []ConfigKey{
+			{Name: "queue-name", Type: StringValue, Description: "name or URL of the SQS queue"},
+			{Name: "queue-prefix", Type: StringValue, Description: "prefix of the SQS queues whose lengths are summed up"},
+			{Name: "queue-list-ttl", Type: DurationValue, Description: "time the queues matching the prefix are cached"},
+			{Name: "region", Type: StringValue, Description: "AWS region of the SQS queue"},
+		},
+	},
+	{
+		Type: "time-to-drain",
+		Keys: []ConfigKey{
+			{Name: "backlog-type", Type: StringValue, Enum: []string{"sqs-queue-length", "nakadi"}, Description: "collector type of the backlog"},
+			{Name: "backlog.", Type: StringValue, Prefix: true, Description: "backlog.<key> is the config key of the backlog collector"},
+			{Name: "rate.", Type: StringValue, Prefix: true, Description: "rate.<key> is the config key of the prometheus collector of the processing rate"},
+			{Name: "zero-rate", Type: StringValue, Enum: []string{"error", "max"}, Description: "handling of a processing rate of 0, max serves max-time-to-drain"},
+			{Name: "max-time-to-drain", Type: DurationValue, Description: "upper bound of the served time to drain"},
+		},
+	},
+	{
+		Type: "scaling-schedule",
+		Keys: []ConfigKey{
+			{Name: "schedule-names", Type: StringValue, Description: "comma separated names of the schedules considered"},
+			{Name: "skip-existence-check", Type: BooleanValue, Description: "create the collector even if the ScalingSchedule doesn't exist yet"},
+		},
+	},
+}
+
+var collectorsByType = func() map[string]*CollectorConfig {
+	byType := make(map[string]*CollectorConfig, len(Collectors))
+	for i := range Collectors {
+		byType[Collectors[i].Type] = &Collectors[i]
+	}
+	return byType
+}()
+
+// LookupConfigKey returns the description of the config key of the collector
+// type. The collector is false if the collector type isn't registered, in
+// which case all config keys are accepted.
+func LookupConfigKey(collectorType, key string) (configKey ConfigKey, known bool, collector bool) {
+	if configKey, ok := findConfigKey(CommonConfigKeys, key); ok {
+		return configKey, true, true
+	}
+
+	config, ok := collectorsByType[collectorType]
+	if !ok {
+		return ConfigKey{}, false, false
+	}
+
+	if configKey, ok := findConfigKey(config.Keys, key); ok {
+		return configKey, true, true
+	}
+
+	if config.AdditionalKeys {
+		return ConfigKey{Name: key, Type: StringValue}, true, true
+	}
+
+	return ConfigKey{}, false, true
+}
+
+// sensitiveKeyParts are parts of config key names indicating credentials,
+// e.g. in the additional keys of the prometheus collector.
+var sensitiveKeyParts = []string{"token", "password", "secret", "credential"}
+
+// IsSensitiveConfigKey returns true if the config key of the collector type
+// carries credentials. Keys are sensitive if they're marked as such in the
+// registry or if their name contains a part like "token" or "password".
+func IsSensitiveConfigKey(collectorType, key string) bool {
+	if configKey, known, _ := LookupConfigKey(collectorType, key); known && configKey.Sensitive {
+		return true
+	}
+
+	lower := strings.ToLower(key)
+	for _, part := range sensitiveKeyParts {
+		if strings.Contains(lower, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsEndpointConfigKey returns true if the config key of the collector type
+// overrides the server queried by the collector.
+func IsEndpointConfigKey(collectorType, key string) bool {
+	configKey, known, _ := LookupConfigKey(collectorType, key)
+	return known && configKey.Endpoint
+}
+
+func findConfigKey(keys []ConfigKey, key string) (ConfigKey, bool) {
+	for _, configKey := range keys {
+		if configKey.Prefix {
+			if strings.HasPrefix(key, configKey.Name) && len(key) > len(configKey.Name) {
+				return configKey, true
+			}
+			continue
+		}
+		if configKey.Name == key {
+			return configKey, true
+		}
+	}
+	return ConfigKey{}, false
+}
+
+// Validate returns an error if the value doesn't match the type of the
+// config key.
+func (k ConfigKey) Validate(value string) error {
+	if len(k.Enum) > 0 {
+		for _, v := range k.Enum {
+			if v == value {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(k.Enum, ", "))
+	}
+
+	// surrounding whitespace is ignored like by the ConfigBinder.
+	value = strings.TrimSpace(value)
+
+	var err error
+	switch k.Type {
+	case DurationValue:
+		_, err = time.ParseDuration(value)
+	case BooleanValue:
+		_, err = strconv.ParseBool(value)
+	case IntegerValue:
+		_, err = strconv.Atoi(value)
+	case NumberValue:
+		_, err = strconv.ParseFloat(value, 64)
+	}
+	return err
+}
+
+// RegisteredCollectorTypes returns the sorted collector types of the
+// registry.
+func RegisteredCollectorTypes() []string {
+	types := make([]string, 0, len(Collectors))
+	for _, config := range Collectors {
+		types = append(types, config.Type)
+	}
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,72 @@
+package annotations
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const (
+	durationPattern = `^[+-]?(0|([0-9]*(\.[0-9]*)?(ns|us|µs|μs|ms|s|m|h))+)$`
+	integerPattern  = `^[+-]?[0-9]+$`
+	numberPattern   = `^\s*[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][+-]?[0-9]+)?\s*$`
+)
+
+// booleanValues are the values accepted by strconv.ParseBool.
+var booleanValues = []string{"1", "t", "T", "TRUE", "true", "True", "0", "f", "F", "FALSE", "false", "False"}
+
+// Schema returns a JSON schema of the metric config annotations of an HPA
+// generated from the registry. It describes the annotations map: each
+// config key of a collector type is a pattern property matching the
+// annotations of all metrics configuring it. Annotation values are strings,
+// so typed values are described by patterns.
+func Schema() map[string]interface{} {
+	properties := map[string]interface{}{}
+	for _, collector := range Collectors {
+		keys := append(append([]ConfigKey{}, CommonConfigKeys...), collector.Keys...)
+		for _, key := range keys {
+			properties[annotationPattern(collector.Type, key)] = valueSchema(key)
+		}
+	}
+
+	return map[string]interface{}{
+		"$schema":           "https://json-schema.org/draft/2020-12/schema",
+		"title":             "kube-metrics-adapter metric config annotations",
+		"description":       "Annotations have the format metric-config.<metricType>.<metricName>.<collectorType>/<configKey>.",
+		"type":              "object",
+		"patternProperties": properties,
+	}
+}
+
+// annotationPattern returns the pattern of the annotation keys configuring
+// the config key of the collector type.
+func annotationPattern(collectorType string, key ConfigKey) string {
+	configKey := regexp.QuoteMeta(key.Name)
+	if key.Prefix {
+		configKey += ".+"
+	}
+	return fmt.Sprintf(`^%s(pods|object|external)\..+\.%s/%s$`, regexp.QuoteMeta(customMetricsPrefix), regexp.QuoteMeta(collectorType), configKey)
+}
+
+func valueSchema(key ConfigKey) map[string]interface{} {
+	schema := map[string]interface{}{
+		"type":        "string",
+		"description": key.Description,
+	}
+
+	if len(key.Enum) > 0 {
+		schema["enum"] = key.Enum
+		return schema
+	}
+
+	switch key.Type {
+	case DurationValue:
+		schema["pattern"] = durationPattern
+	case BooleanValue:
+		schema["enum"] = booleanValues
+	case IntegerValue:
+		schema["pattern"] = integerPattern
+	case NumberValue:
+		schema["pattern"] = numberPattern
+	}
+	return schema
+}
@@ -0,0 +1,101 @@
+package annotations
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchema(t *testing.T) {
+	schema := Schema()
+	_, err := json.Marshal(schema)
+	require.NoError(t, err)
+
+	properties := schema["patternProperties"].(map[string]interface{})
+
+	// propertySchema returns the schema of the pattern property matching
+	// the annotation.
+	propertySchema := func(annotation string) map[string]interface{} {
+		var matched map[string]interface{}
+		for pattern, property := range properties {
+			if regexp.MustCompile(pattern).MatchString(annotation) {
+				require.Nil(t, matched, "multiple patterns match %s", annotation)
+				matched = property.(map[string]interface{})
+			}
+		}
+		return matched
+	}
+
+	for _, annotation := range []string{
+		"metric-config.external.rps.prometheus/query",
+		"metric-config.object.rps.prometheus/per-replica",
+		"metric-config.pods.requests-per-second.json-path/json-key",
+		"metric-config.pods.requests-per-second.json-path/port",
+		"metric-config.external.queue.primary.zmon/key",
+		"metric-config.external.zmon-check.zmon/tag-application",
+		"metric-config.external.consumer.nakadi/unassigned-partitions",
+		"metric-config.external.flux-query.influxdb/address",
+		"metric-config.external.flux-query.influxdb/interval",
+	} {
+		require.NotNil(t, propertySchema(annotation), annotation)
+	}
+
+	require.Nil(t, propertySchema("metric-config.pods.requests-per-second.json-path/unknown"))
+	require.Nil(t, propertySchema("metric-config.unknown.rps.prometheus/query"))
+
+	require.Equal(t, []string{"ignore", "max", "error"}, propertySchema("metric-config.external.consumer.nakadi/unassigned-partitions")["enum"])
+
+	interval := regexp.MustCompile(propertySchema("metric-config.external.rps.prometheus/interval")["pattern"].(string))
+	for _, value := range []string{"30s", "1m30s", "1.5h", "0"} {
+		require.True(t, interval.MatchString(value), value)
+	}
+	require.False(t, interval.MatchString("30"))
+
+	port := regexp.MustCompile(propertySchema("metric-config.pods.rps.json-path/port")["pattern"].(string))
+	require.True(t, port.MatchString("9090"))
+	require.False(t, port.MatchString("http"))
+}
+
+func TestLookupConfigKey(t *testing.T) {
+	key, known, registered := LookupConfigKey("json-path", "port")
+	require.True(t, known)
+	require.True(t, registered)
+	require.Equal(t, IntegerValue, key.Type)
+
+	// common keys are known for all collectors.
+	_, known, _ = LookupConfigKey("custom", IntervalConfigKey)
+	require.True(t, known)
+
+	_, known, registered = LookupConfigKey("json-path", "unknown")
+	require.False(t, known)
+	require.True(t, registered)
+
+	// the named queries of the prometheus collector.
+	_, known, _ = LookupConfigKey("prometheus", "processed-events")
+	require.True(t, known)
+
+	_, known, _ = LookupConfigKey("zmon", "tag-")
+	require.False(t, known)
+
+	// the config keys of the child collectors of time-to-drain.
+	_, known, _ = LookupConfigKey("time-to-drain", "backlog.queue-name")
+	require.True(t, known)
+	key, known, _ = LookupConfigKey("time-to-drain", "max-time-to-drain")
+	require.True(t, known)
+	require.Equal(t, DurationValue, key.Type)
+
+	_, known, registered = LookupConfigKey("custom", "unknown")
+	require.False(t, known)
+	require.False(t, registered)
+}
+
+func TestIsSensitiveConfigKey(t *testing.T) {
+	require.True(t, IsSensitiveConfigKey("influxdb", "token"))
+	// keys of other collectors are matched by name.
+	require.True(t, IsSensitiveConfigKey("prometheus", "api-token"))
+	require.True(t, IsSensitiveConfigKey("custom", "db-password"))
+	require.False(t, IsSensitiveConfigKey("prometheus", "query"))
+	require.False(t, IsSensitiveConfigKey("influxdb", "org"))
+}
@@ -0,0 +1,39 @@
+package openapi
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const generatedFile = "zz_generated.openapi.go"
+
+// TestGeneratedDefinitionsUpToDate regenerates the OpenAPI definitions and
+// fails if they differ from the committed ones. Run `make
+// pkg/api/generated/openapi/zz_generated.openapi.go` or
+// hack/update-openapi.sh to update them.
+func TestGeneratedDefinitionsUpToDate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping OpenAPI generation in short mode")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	outputDir := t.TempDir()
+	cmd := exec.Command(filepath.Join("..", "..", "..", "..", "hack", "update-openapi.sh"))
+	cmd.Env = append(os.Environ(), "OPENAPI_OUTPUT_DIR="+outputDir)
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+
+	generated, err := os.ReadFile(filepath.Join(outputDir, generatedFile))
+	require.NoError(t, err)
+
+	committed, err := os.ReadFile(generatedFile)
+	require.NoError(t, err)
+
+	require.True(t, string(generated) == string(committed), "%s is stale, run hack/update-openapi.sh to update it", generatedFile)
+}
@@ -82,6 +82,11 @@ type ScalingScheduleSpec struct {
 	// +optional
 	ScalingWindowDurationMinutes *int64 `json:"scalingWindowDurationMinutes,omitempty"`
 
+	// Disable the pre-scaling of the HPAs referencing this resource. The
+	// metric is still served to the HPAs.
+	// +optional
+	DisablePreScaling bool `json:"disablePreScaling,omitempty"`
+
 	// Schedules is the list of schedules for this ScalingSchedule
 	// resource. All the schedules defined here will result on the value
 	// to the same metric. New metrics require a new ScalingSchedule
@@ -91,7 +96,14 @@ type ScalingScheduleSpec struct {
 
 // Schedule is the schedule details to be used inside a ScalingSchedule.
 // +k8s:deepcopy-gen=true
+// +kubebuilder:validation:XValidation:rule="!has(self.dayValues) || (has(self.period) && self.dayValues.all(day, day in self.period.days))",message="dayValues can only be defined for days of the period"
+// +kubebuilder:validation:XValidation:rule="self.type != 'OneTime' || !has(self.date) || !has(self.endDate) || (has(self.durationMinutes) && self.durationMinutes > 0) || timestamp(self.endDate) >= timestamp(self.date)",message="endDate of a OneTime schedule must not be before its date unless durationMinutes is defined"
 type Schedule struct {
+	// Name of the schedule. It's used by HPAs to only consider a subset
+	// of the schedules of the resource, see the schedule-names metric
+	// config.
+	// +optional
+	Name string       `json:"name,omitempty"`
 	Type ScheduleType `json:"type"`
 	// Defines the details of a Repeating schedule.
 	// +optional
@@ -110,6 +122,11 @@ type Schedule struct {
 	DurationMinutes int `json:"durationMinutes"`
 	// The metric value that will be returned for the defined schedule.
 	Value int64 `json:"value"`
+	// Per weekday overrides of the value of a Repeating schedule. The
+	// weekday is determined by the start of the schedule in its
+	// timezone. Only days defined in the period can be overridden.
+	// +optional
+	DayValues map[ScheduleDay]int64 `json:"dayValues,omitempty"`
 }
 
 func (in Schedule) Duration() time.Duration {
@@ -172,8 +189,24 @@ type ScalingScheduleStatus struct {
 	// +kubebuilder:default:=false
 	// +optional
 	Active bool `json:"active"`
+	// Conditions describe the state of the scaling schedule, e.g. whether
+	// all of its schedules are valid.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// ScalingScheduleValidCondition is the condition of a scaling schedule
+// which is false if any of its schedules ends before it starts or exceeds
+// the maximum duration.
+const ScalingScheduleValidCondition = "Valid"
+
+// ScalingScheduleConflictsCondition is the condition of a scaling schedule
+// which is set while schedules with values differing by more than the
+// conflict factor overlap within the next days.
+const ScalingScheduleConflictsCondition = "Conflicts"
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // ScalingScheduleList is a list of namespaced scaling schedules.
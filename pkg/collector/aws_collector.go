@@ -3,12 +3,18 @@ package collector
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -18,113 +24,395 @@ import (
 const (
 	AWSSQSQueueLengthMetric = "sqs-queue-length"
 	sqsQueueNameLabelKey    = "queue-name"
+	sqsQueuePrefixLabelKey  = "queue-prefix"
+	sqsQueueListTTLKey      = "queue-list-ttl"
 	sqsQueueRegionLabelKey  = "region"
+
+	// defaultSQSQueueListTTL is the time the queues matching a prefix are
+	// cached before they are listed again.
+	defaultSQSQueueListTTL = 5 * time.Minute
+	// sqsMaxConcurrentRequests limits the concurrent requests for the
+	// attributes of the queues matching a prefix.
+	sqsMaxConcurrentRequests = 10
 )
 
+// AWSConfigFactory creates the AWS config (session) for a region.
+type AWSConfigFactory func(ctx context.Context, region string) (aws.Config, error)
+
+// LoadAWSConfig loads the default AWS config for a region.
+func LoadAWSConfig(ctx context.Context, region string) (aws.Config, error) {
+	return awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(region))
+}
+
+// awsRegionConfig is a cached AWS config of a region.
+type awsRegionConfig struct {
+	config  aws.Config
+	created time.Time
+}
+
 type AWSCollectorPlugin struct {
-	configs map[string]aws.Config
+	newConfig           AWSConfigFactory
+	newSQS              func(cfg aws.Config) sqsiface
+	regions             map[string]struct{}
+	allowDynamicRegions bool
+	refreshInterval     time.Duration
+	configs             map[string]awsRegionConfig
+	now                 func() time.Time
+	sync.Mutex
 }
 
-func NewAWSCollectorPlugin(configs map[string]aws.Config) *AWSCollectorPlugin {
-	return &AWSCollectorPlugin{
-		configs: configs,
+// NewAWSCollectorPlugin initializes a new AWSCollectorPlugin. The configs of
+// the pre-configured regions are created upfront. If allowDynamicRegions is
+// set, configs for other regions are created when a metric references them.
+// Configs are recreated once they are older than refreshInterval, 0
+// disables the refresh.
+func NewAWSCollectorPlugin(ctx context.Context, regions []string, configFactory AWSConfigFactory, allowDynamicRegions bool, refreshInterval time.Duration) (*AWSCollectorPlugin, error) {
+	plugin := &AWSCollectorPlugin{
+		newConfig: configFactory,
+		newSQS: func(cfg aws.Config) sqsiface {
+			return sqs.NewFromConfig(cfg)
+		},
+		regions:             make(map[string]struct{}, len(regions)),
+		allowDynamicRegions: allowDynamicRegions,
+		refreshInterval:     refreshInterval,
+		configs:             make(map[string]awsRegionConfig, len(regions)),
+		now:                 time.Now,
 	}
+
+	for _, region := range regions {
+		plugin.regions[region] = struct{}{}
+		cfg, err := configFactory(ctx, region)
+		if err != nil {
+			return nil, fmt.Errorf("unabled to create aws session for region: %s: %v", region, err)
+		}
+		plugin.configs[region] = awsRegionConfig{config: cfg, created: plugin.now()}
+	}
+
+	return plugin, nil
 }
 
 // NewCollector initializes a new skipper collector from the specified HPA.
 func (c *AWSCollectorPlugin) NewCollector(ctx context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
-	return NewAWSSQSCollector(ctx, c.configs, hpa, config, interval)
+	return NewAWSSQSCollector(ctx, c, hpa, config, interval)
+}
+
+// config returns the AWS config of the region. Configs older than the
+// refresh interval are recreated to pick up rotated credentials.
+func (c *AWSCollectorPlugin) config(ctx context.Context, region string) (aws.Config, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	cached, ok := c.configs[region]
+	if ok && (c.refreshInterval <= 0 || c.now().Sub(cached.created) < c.refreshInterval) {
+		return cached.config, nil
+	}
+
+	if !ok {
+		if _, configured := c.regions[region]; !configured && !c.allowDynamicRegions {
+			return aws.Config{}, NewPermanentConfigError(fmt.Errorf("the metric region: %s is not configured", region))
+		}
+	}
+
+	cfg, err := c.newConfig(ctx, region)
+	if err != nil {
+		if ok {
+			// keep using the previous config, it might still
+			// be valid.
+			log.Warnf("Failed to refresh aws session for region %s: %v", region, err)
+			return cached.config, nil
+		}
+		return aws.Config{}, NewTransientError(fmt.Errorf("failed to create aws session for region %s: %v", region, err))
+	}
+
+	c.configs[region] = awsRegionConfig{config: cfg, created: c.now()}
+	return cfg, nil
+}
+
+// sqsClient returns an SQS client for the region.
+func (c *AWSCollectorPlugin) sqsClient(ctx context.Context, region string) (sqsiface, error) {
+	cfg, err := c.config(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+	return c.newSQS(cfg), nil
 }
 
 type sqsiface interface {
+	GetQueueUrl(ctx context.Context, params *sqs.GetQueueUrlInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error)
 	GetQueueAttributes(ctx context.Context, params *sqs.GetQueueAttributesInput, optFns ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error)
+	ListQueues(ctx context.Context, params *sqs.ListQueuesInput, optFns ...func(*sqs.Options)) (*sqs.ListQueuesOutput, error)
 }
 
 type AWSSQSCollector struct {
-	sqs        sqsiface
-	interval   time.Duration
-	queueURL   string
-	queueName  string
-	namespace  string
-	metric     autoscalingv2.MetricIdentifier
-	metricType autoscalingv2.MetricSourceType
+	plugin    *AWSCollectorPlugin
+	region    string
+	interval  time.Duration
+	queueURL  string
+	queueName string
+	namespace string
+	metric    autoscalingv2.MetricIdentifier
+
+	// queuePrefix selects all queues with the prefix instead of a
+	// single queue. Their lengths are summed up.
+	queuePrefix  string
+	queueListTTL time.Duration
+	queuesMu     sync.Mutex
+	queueURLs    []string
+	queuesListed time.Time
 }
 
-func NewAWSSQSCollector(ctx context.Context, configs map[string]aws.Config, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (*AWSSQSCollector, error) {
+// NewAWSSQSCollector initializes a new AWSSQSCollector. The queue can be
+// specified by name or by URL. If the region is not specified it's
+// discovered from the queue URL. Alternatively all queues with a prefix can
+// be selected, which requires the region.
+func NewAWSSQSCollector(ctx context.Context, plugin *AWSCollectorPlugin, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (*AWSSQSCollector, error) {
 	if config.Metric.Selector == nil {
-		return nil, fmt.Errorf("selector for queue is not specified")
+		return nil, NewPermanentConfigError(fmt.Errorf("selector for queue is not specified"))
+	}
+
+	if prefix, ok := config.Config[sqsQueuePrefixLabelKey]; ok {
+		return newAWSSQSPrefixCollector(ctx, plugin, hpa, config, interval, prefix)
 	}
 
 	name, ok := config.Config[sqsQueueNameLabelKey]
 	if !ok {
-		return nil, fmt.Errorf("sqs queue name not specified on metric")
+		return nil, NewPermanentConfigError(fmt.Errorf("sqs queue name not specified on metric"))
 	}
+
+	queueURL := ""
+	urlRegion, isURL := regionFromQueueURL(name)
+	if isURL {
+		queueURL = name
+	}
+
 	region, ok := config.Config[sqsQueueRegionLabelKey]
 	if !ok {
-		return nil, fmt.Errorf("sqs queue region is not specified on metric")
+		if !isURL {
+			return nil, NewPermanentConfigError(fmt.Errorf("sqs queue region is not specified on metric"))
+		}
+		region = urlRegion
+	}
+
+	service, err := plugin.sqsClient(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+
+	if queueURL == "" {
+		params := &sqs.GetQueueUrlInput{
+			QueueName: aws.String(name),
+		}
+
+		resp, err := service.GetQueueUrl(ctx, params)
+		if err != nil {
+			return nil, NewTransientError(fmt.Errorf("failed to get queue URL for queue '%s': %v", name, err))
+		}
+		queueURL = aws.ToString(resp.QueueUrl)
+	}
+
+	return &AWSSQSCollector{
+		plugin:    plugin,
+		region:    region,
+		interval:  interval,
+		queueURL:  queueURL,
+		queueName: name,
+		namespace: hpa.Namespace,
+		metric:    config.Metric,
+	}, nil
+}
+
+// newAWSSQSPrefixCollector initializes a new AWSSQSCollector summing up the
+// lengths of all queues with the prefix.
+func newAWSSQSPrefixCollector(ctx context.Context, plugin *AWSCollectorPlugin, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration, prefix string) (*AWSSQSCollector, error) {
+	if _, ok := config.Config[sqsQueueNameLabelKey]; ok {
+		return nil, NewPermanentConfigError(fmt.Errorf("%s and %s are mutually exclusive", sqsQueueNameLabelKey, sqsQueuePrefixLabelKey))
+	}
+
+	if prefix == "" {
+		return nil, NewPermanentConfigError(fmt.Errorf("sqs queue prefix must not be empty"))
 	}
 
-	cfg, ok := configs[region]
+	region, ok := config.Config[sqsQueueRegionLabelKey]
 	if !ok {
-		return nil, fmt.Errorf("the metric region: %s is not configured", region)
+		return nil, NewPermanentConfigError(fmt.Errorf("sqs queue region is not specified on metric"))
 	}
 
-	service := sqs.NewFromConfig(cfg)
-	params := &sqs.GetQueueUrlInput{
-		QueueName: aws.String(name),
+	queueListTTL := defaultSQSQueueListTTL
+	if v, ok := config.Config[sqsQueueListTTLKey]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, NewPermanentConfigError(fmt.Errorf("invalid %s value %s", sqsQueueListTTLKey, v))
+		}
+		queueListTTL = d
 	}
 
-	resp, err := service.GetQueueUrl(context.TODO(), params)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get queue URL for queue '%s': %v", name, err)
+	// check the region upfront.
+	if _, err := plugin.sqsClient(ctx, region); err != nil {
+		return nil, err
 	}
 
 	return &AWSSQSCollector{
-		sqs:        service,
-		interval:   interval,
-		queueURL:   aws.ToString(resp.QueueUrl),
-		queueName:  name,
-		namespace:  hpa.Namespace,
-		metric:     config.Metric,
-		metricType: config.Type,
+		plugin:       plugin,
+		region:       region,
+		interval:     interval,
+		queueName:    prefix + "*",
+		namespace:    hpa.Namespace,
+		metric:       config.Metric,
+		queuePrefix:  prefix,
+		queueListTTL: queueListTTL,
 	}, nil
 }
 
 func (c *AWSSQSCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, error) {
+	service, err := c.plugin.sqsClient(ctx, c.region)
+	if err != nil {
+		return nil, err
+	}
+
+	var length int64
+	if c.queuePrefix != "" {
+		length, err = c.prefixQueueLength(ctx, service)
+	} else {
+		length, err = queueLength(ctx, service, c.queueURL)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	metricValue := NewExternalMetric(c.namespace, external_metrics.ExternalMetricValue{
+		MetricName:   c.metric.Name,
+		MetricLabels: c.metric.Selector.MatchLabels,
+		Timestamp:    metav1.Time{Time: time.Now().UTC()},
+		Value:        *resource.NewQuantity(length, resource.DecimalSI),
+	})
+
+	return []CollectedMetric{metricValue}, nil
+}
+
+// queueLength returns the approximate number of messages of the queue.
+func queueLength(ctx context.Context, service sqsiface, queueURL string) (int64, error) {
 	params := &sqs.GetQueueAttributesInput{
-		QueueUrl:       aws.String(c.queueURL),
+		QueueUrl:       aws.String(queueURL),
 		AttributeNames: []types.QueueAttributeName{types.QueueAttributeNameApproximateNumberOfMessages},
 	}
 
-	resp, err := c.sqs.GetQueueAttributes(ctx, params)
+	resp, err := service.GetQueueAttributes(ctx, params)
 	if err != nil {
-		return nil, err
+		return 0, NewTransientError(err)
 	}
 
-	if v, ok := resp.Attributes[string(types.QueueAttributeNameApproximateNumberOfMessages)]; ok {
-		i, err := strconv.Atoi(v)
+	v, ok := resp.Attributes[string(types.QueueAttributeNameApproximateNumberOfMessages)]
+	if !ok {
+		return 0, fmt.Errorf("failed to get queue length for '%s'", queueURL)
+	}
+
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return i, nil
+}
+
+// prefixQueueLength returns the sum of the lengths of the queues with the
+// prefix. Failing queues are skipped, it only fails if all queues fail.
+func (c *AWSSQSCollector) prefixQueueLength(ctx context.Context, service sqsiface) (int64, error) {
+	queueURLs, err := c.prefixQueues(ctx, service)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		mu     sync.Mutex
+		sum    int64
+		failed int
+		errs   []string
+	)
+
+	var group errgroup.Group
+	group.SetLimit(sqsMaxConcurrentRequests)
+	for _, queueURL := range queueURLs {
+		group.Go(func() error {
+			length, err := queueLength(ctx, service, queueURL)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed++
+				errs = append(errs, fmt.Sprintf("%s: %v", queueURL, err))
+				return nil
+			}
+			sum += length
+			return nil
+		})
+	}
+	_ = group.Wait()
+
+	if failed > 0 && failed == len(queueURLs) {
+		return 0, NewTransientError(fmt.Errorf("failed to get the length of all queues with prefix '%s': %s", c.queuePrefix, strings.Join(errs, "; ")))
+	}
+	if failed > 0 {
+		log.Warnf("Failed to get the length of %d of %d queues with prefix '%s': %s", failed, len(queueURLs), c.queuePrefix, strings.Join(errs, "; "))
+	}
+
+	return sum, nil
+}
+
+// prefixQueues returns the URLs of the queues with the prefix. The queues
+// are listed again once the cached list is older than the queue list TTL.
+// If listing fails, the previous list is used.
+func (c *AWSSQSCollector) prefixQueues(ctx context.Context, service sqsiface) ([]string, error) {
+	c.queuesMu.Lock()
+	defer c.queuesMu.Unlock()
+
+	now := c.plugin.now()
+	if c.queueURLs != nil && now.Sub(c.queuesListed) < c.queueListTTL {
+		return c.queueURLs, nil
+	}
+
+	queueURLs := []string{}
+	params := &sqs.ListQueuesInput{
+		QueueNamePrefix: aws.String(c.queuePrefix),
+		MaxResults:      aws.Int32(1000),
+	}
+	for {
+		resp, err := service.ListQueues(ctx, params)
 		if err != nil {
-			return nil, err
+			if c.queueURLs != nil {
+				log.Warnf("Failed to list queues with prefix '%s', using the previous list: %v", c.queuePrefix, err)
+				return c.queueURLs, nil
+			}
+			return nil, NewTransientError(fmt.Errorf("failed to list queues with prefix '%s': %v", c.queuePrefix, err))
 		}
-
-		metricValue := CollectedMetric{
-			Namespace: c.namespace,
-			Type:      c.metricType,
-			External: external_metrics.ExternalMetricValue{
-				MetricName:   c.metric.Name,
-				MetricLabels: c.metric.Selector.MatchLabels,
-				Timestamp:    metav1.Time{Time: time.Now().UTC()},
-				Value:        *resource.NewQuantity(int64(i), resource.DecimalSI),
-			},
+		queueURLs = append(queueURLs, resp.QueueUrls...)
+		if resp.NextToken == nil {
+			break
 		}
-
-		return []CollectedMetric{metricValue}, nil
+		params.NextToken = resp.NextToken
 	}
 
-	return nil, fmt.Errorf("failed to get queue length for '%s'", c.queueName)
+	c.queueURLs = queueURLs
+	c.queuesListed = now
+	return queueURLs, nil
 }
 
 // Interval returns the interval at which the collector should run.
 func (c *AWSSQSCollector) Interval() time.Duration {
 	return c.interval
 }
+
+// regionFromQueueURL returns the region of an SQS queue URL like
+// https://sqs.eu-central-1.amazonaws.com/123456789012/queue. It returns
+// false if the value is not an SQS queue URL.
+func regionFromQueueURL(value string) (string, bool) {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" {
+		return "", false
+	}
+
+	parts := strings.Split(u.Hostname(), ".")
+	if len(parts) < 4 || parts[0] != "sqs" || parts[2] != "amazonaws" {
+		return "", false
+	}
+
+	return parts[1], true
+}
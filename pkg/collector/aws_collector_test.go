@@ -0,0 +1,337 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/stretchr/testify/require"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type fakeSQS struct {
+	region string
+	length string
+}
+
+func (f fakeSQS) GetQueueUrl(_ context.Context, params *sqs.GetQueueUrlInput, _ ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error) {
+	return &sqs.GetQueueUrlOutput{
+		QueueUrl: aws.String("https://sqs." + f.region + ".amazonaws.com/123456789012/" + aws.ToString(params.QueueName)),
+	}, nil
+}
+
+func (f fakeSQS) GetQueueAttributes(_ context.Context, _ *sqs.GetQueueAttributesInput, _ ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	return &sqs.GetQueueAttributesOutput{
+		Attributes: map[string]string{
+			string(types.QueueAttributeNameApproximateNumberOfMessages): f.length,
+		},
+	}, nil
+}
+
+func (f fakeSQS) ListQueues(_ context.Context, _ *sqs.ListQueuesInput, _ ...func(*sqs.Options)) (*sqs.ListQueuesOutput, error) {
+	return &sqs.ListQueuesOutput{}, nil
+}
+
+// prefixSQS is a fake SQS API with queues listed by prefix in pages of two
+// queues. The attributes of the queues in failing can't be fetched.
+type prefixSQS struct {
+	fakeSQS
+	mu         sync.Mutex
+	queues     []string
+	lengths    map[string]string
+	failing    map[string]bool
+	listErr    error
+	listCalls  int
+	fetchCalls int
+}
+
+func (f *prefixSQS) ListQueues(_ context.Context, params *sqs.ListQueuesInput, _ ...func(*sqs.Options)) (*sqs.ListQueuesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.listCalls++
+	if f.listErr != nil {
+		return nil, f.listErr
+	}
+
+	var matching []string
+	for _, queue := range f.queues {
+		if strings.HasPrefix(queue, aws.ToString(params.QueueNamePrefix)) {
+			matching = append(matching, "https://sqs.eu-central-1.amazonaws.com/123456789012/"+queue)
+		}
+	}
+
+	start := 0
+	if params.NextToken != nil {
+		start = len(aws.ToString(params.NextToken))
+	}
+	end := min(start+2, len(matching))
+	out := &sqs.ListQueuesOutput{QueueUrls: matching[start:end]}
+	if end < len(matching) {
+		out.NextToken = aws.String(strings.Repeat("x", end))
+	}
+	return out, nil
+}
+
+func (f *prefixSQS) GetQueueAttributes(_ context.Context, params *sqs.GetQueueAttributesInput, _ ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fetchCalls++
+
+	queue := aws.ToString(params.QueueUrl)
+	queue = queue[strings.LastIndex(queue, "/")+1:]
+	if f.failing[queue] {
+		return nil, errors.New("access denied")
+	}
+	return &sqs.GetQueueAttributesOutput{
+		Attributes: map[string]string{
+			string(types.QueueAttributeNameApproximateNumberOfMessages): f.lengths[queue],
+		},
+	}, nil
+}
+
+// fakeAWSConfigFactory counts the configs created per region and fails for
+// the regions in failing.
+type fakeAWSConfigFactory struct {
+	created map[string]int
+	failing map[string]bool
+}
+
+func (f *fakeAWSConfigFactory) newConfig(_ context.Context, region string) (aws.Config, error) {
+	if f.failing[region] {
+		return aws.Config{}, errors.New("no credentials")
+	}
+	f.created[region]++
+	return aws.Config{Region: region}, nil
+}
+
+func newTestAWSCollectorPlugin(t *testing.T, factory *fakeAWSConfigFactory, regions []string, allowDynamicRegions bool, refreshInterval time.Duration) *AWSCollectorPlugin {
+	plugin, err := NewAWSCollectorPlugin(context.Background(), regions, factory.newConfig, allowDynamicRegions, refreshInterval)
+	require.NoError(t, err)
+	plugin.newSQS = func(cfg aws.Config) sqsiface {
+		return fakeSQS{region: cfg.Region, length: "42"}
+	}
+	return plugin
+}
+
+func sqsMetricConfig(config map[string]string) *MetricConfig {
+	return &MetricConfig{
+		MetricTypeName: MetricTypeName{
+			Type: autoscalingv2.ExternalMetricSourceType,
+			Metric: autoscalingv2.MetricIdentifier{
+				Name:     AWSSQSQueueLengthMetric,
+				Selector: &metav1.LabelSelector{MatchLabels: config},
+			},
+		},
+		Config: config,
+	}
+}
+
+func TestAWSCollectorPluginRegions(t *testing.T) {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "hpa", Namespace: "default"},
+	}
+
+	for _, tc := range []struct {
+		msg                 string
+		allowDynamicRegions bool
+		config              map[string]string
+		failing             map[string]bool
+		expectedURL         string
+		expectedErr         error
+	}{
+		{
+			msg:         "pre-configured region",
+			config:      map[string]string{sqsQueueNameLabelKey: "queue", sqsQueueRegionLabelKey: "eu-central-1"},
+			expectedURL: "https://sqs.eu-central-1.amazonaws.com/123456789012/queue",
+		},
+		{
+			msg:                 "dynamic region allowed",
+			allowDynamicRegions: true,
+			config:              map[string]string{sqsQueueNameLabelKey: "queue", sqsQueueRegionLabelKey: "us-east-1"},
+			expectedURL:         "https://sqs.us-east-1.amazonaws.com/123456789012/queue",
+		},
+		{
+			msg:         "dynamic region denied",
+			config:      map[string]string{sqsQueueNameLabelKey: "queue", sqsQueueRegionLabelKey: "us-east-1"},
+			expectedErr: ErrPermanentConfig,
+		},
+		{
+			msg:                 "dynamic region session failure",
+			allowDynamicRegions: true,
+			config:              map[string]string{sqsQueueNameLabelKey: "queue", sqsQueueRegionLabelKey: "us-east-1"},
+			failing:             map[string]bool{"us-east-1": true},
+			expectedErr:         ErrTransient,
+		},
+		{
+			msg:                 "region discovered from queue URL",
+			allowDynamicRegions: true,
+			config:              map[string]string{sqsQueueNameLabelKey: "https://sqs.ap-south-1.amazonaws.com/123456789012/queue"},
+			expectedURL:         "https://sqs.ap-south-1.amazonaws.com/123456789012/queue",
+		},
+		{
+			msg:         "missing region",
+			config:      map[string]string{sqsQueueNameLabelKey: "queue"},
+			expectedErr: ErrPermanentConfig,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			factory := &fakeAWSConfigFactory{created: map[string]int{}, failing: tc.failing}
+			plugin := newTestAWSCollectorPlugin(t, factory, []string{"eu-central-1"}, tc.allowDynamicRegions, 0)
+
+			c, err := plugin.NewCollector(context.Background(), hpa, sqsMetricConfig(tc.config), time.Minute)
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedURL, c.(*AWSSQSCollector).queueURL)
+
+			metrics, err := c.GetMetrics(context.Background())
+			require.NoError(t, err)
+			require.Len(t, metrics, 1)
+			require.Equal(t, int64(42), metrics[0].External.Value.Value())
+		})
+	}
+}
+
+func TestAWSCollectorPluginRefresh(t *testing.T) {
+	factory := &fakeAWSConfigFactory{created: map[string]int{}, failing: map[string]bool{}}
+	plugin := newTestAWSCollectorPlugin(t, factory, []string{"eu-central-1"}, false, time.Hour)
+
+	now := time.Now()
+	plugin.now = func() time.Time { return now }
+
+	_, err := plugin.config(context.Background(), "eu-central-1")
+	require.NoError(t, err)
+	require.Equal(t, 1, factory.created["eu-central-1"])
+
+	now = now.Add(2 * time.Hour)
+	_, err = plugin.config(context.Background(), "eu-central-1")
+	require.NoError(t, err)
+	require.Equal(t, 2, factory.created["eu-central-1"])
+
+	// a failing refresh keeps the previous config
+	factory.failing["eu-central-1"] = true
+	now = now.Add(2 * time.Hour)
+	cfg, err := plugin.config(context.Background(), "eu-central-1")
+	require.NoError(t, err)
+	require.Equal(t, "eu-central-1", cfg.Region)
+}
+
+func TestRegionFromQueueURL(t *testing.T) {
+	region, ok := regionFromQueueURL("https://sqs.eu-west-1.amazonaws.com/123456789012/queue")
+	require.True(t, ok)
+	require.Equal(t, "eu-west-1", region)
+
+	_, ok = regionFromQueueURL("queue")
+	require.False(t, ok)
+
+	_, ok = regionFromQueueURL("https://example.org/queue")
+	require.False(t, ok)
+}
+
+func TestAWSSQSCollectorQueuePrefix(t *testing.T) {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "hpa", Namespace: "default"},
+	}
+
+	service := &prefixSQS{
+		queues: []string{"jobs-tenant-a", "jobs-tenant-b", "jobs-tenant-c", "other"},
+		lengths: map[string]string{
+			"jobs-tenant-a": "10",
+			"jobs-tenant-b": "20",
+			"jobs-tenant-c": "30",
+			"other":         "1000",
+		},
+		failing: map[string]bool{},
+	}
+
+	factory := &fakeAWSConfigFactory{created: map[string]int{}}
+	plugin := newTestAWSCollectorPlugin(t, factory, []string{"eu-central-1"}, false, 0)
+	plugin.newSQS = func(aws.Config) sqsiface { return service }
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	plugin.now = func() time.Time { return now }
+
+	c, err := plugin.NewCollector(context.Background(), hpa, sqsMetricConfig(map[string]string{
+		sqsQueuePrefixLabelKey: "jobs-tenant-",
+		sqsQueueRegionLabelKey: "eu-central-1",
+		sqsQueueListTTLKey:     "10m",
+	}), time.Minute)
+	require.NoError(t, err)
+
+	collect := func() (int64, error) {
+		metrics, err := c.GetMetrics(context.Background())
+		if err != nil {
+			return 0, err
+		}
+		require.Len(t, metrics, 1)
+		return metrics[0].External.Value.Value(), nil
+	}
+
+	// all pages of the matching queues are summed up.
+	value, err := collect()
+	require.NoError(t, err)
+	require.Equal(t, int64(60), value)
+	require.Equal(t, 2, service.listCalls)
+
+	// the queue list is reused within the TTL.
+	service.queues = append(service.queues, "jobs-tenant-d")
+	service.lengths["jobs-tenant-d"] = "40"
+	now = now.Add(5 * time.Minute)
+	value, err = collect()
+	require.NoError(t, err)
+	require.Equal(t, int64(60), value)
+	require.Equal(t, 2, service.listCalls)
+
+	// the queues are listed again after the TTL.
+	now = now.Add(5 * time.Minute)
+	value, err = collect()
+	require.NoError(t, err)
+	require.Equal(t, int64(100), value)
+	require.Equal(t, 4, service.listCalls)
+
+	// failing queues are skipped.
+	service.failing["jobs-tenant-a"] = true
+	service.failing["jobs-tenant-c"] = true
+	value, err = collect()
+	require.NoError(t, err)
+	require.Equal(t, int64(60), value)
+
+	// a failing listing falls back to the previous list.
+	service.listErr = errors.New("throttled")
+	now = now.Add(time.Hour)
+	value, err = collect()
+	require.NoError(t, err)
+	require.Equal(t, int64(60), value)
+
+	// the collection fails if all queues fail.
+	service.failing["jobs-tenant-b"] = true
+	service.failing["jobs-tenant-d"] = true
+	_, err = collect()
+	require.ErrorIs(t, err, ErrTransient)
+}
+
+func TestAWSSQSCollectorQueuePrefixInvalidConfig(t *testing.T) {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "hpa", Namespace: "default"},
+	}
+
+	for _, config := range []map[string]string{
+		{sqsQueuePrefixLabelKey: "jobs-", sqsQueueNameLabelKey: "jobs", sqsQueueRegionLabelKey: "eu-central-1"},
+		{sqsQueuePrefixLabelKey: "", sqsQueueRegionLabelKey: "eu-central-1"},
+		{sqsQueuePrefixLabelKey: "jobs-"},
+		{sqsQueuePrefixLabelKey: "jobs-", sqsQueueRegionLabelKey: "eu-central-1", sqsQueueListTTLKey: "often"},
+	} {
+		factory := &fakeAWSConfigFactory{created: map[string]int{}}
+		plugin := newTestAWSCollectorPlugin(t, factory, []string{"eu-central-1"}, false, 0)
+		_, err := plugin.NewCollector(context.Background(), hpa, sqsMetricConfig(config), time.Minute)
+		require.ErrorIs(t, err, ErrPermanentConfig, config)
+	}
+}
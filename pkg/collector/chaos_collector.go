@@ -0,0 +1,110 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/sleep"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+)
+
+// ChaosEnvVar is the environment variable which must be set to "true" to
+// allow enabling the chaos mode. This prevents enabling it by accident in
+// production.
+const ChaosEnvVar = "KUBE_METRICS_ADAPTER_ALLOW_CHAOS"
+
+// ErrChaos is the error returned by collections failed by the chaos mode.
+var ErrChaos = errors.New("collection failed by chaos mode")
+
+// ChaosConfig configures the faults injected into collections.
+type ChaosConfig struct {
+	// MaxLatency is the upper bound of the random latency added to each
+	// collection.
+	MaxLatency time.Duration
+	// FailureRate is the probability in the range [0, 1] of a
+	// collection to fail.
+	FailureRate float64
+	// Seed seeds the random number generators of the collectors.
+	Seed int64
+}
+
+// Validate validates the chaos config.
+func (c ChaosConfig) Validate() error {
+	if c.MaxLatency < 0 {
+		return fmt.Errorf("chaos latency must not be negative, got %s", c.MaxLatency)
+	}
+	if c.FailureRate < 0 || c.FailureRate > 1 {
+		return fmt.Errorf("chaos failure rate must be in the range [0, 1], got %v", c.FailureRate)
+	}
+	return nil
+}
+
+// seedFor derives the seed of a collector from the configured seed and the
+// metric it collects. This makes the injected faults of a collector
+// independent of the order in which collectors are created.
+func (c ChaosConfig) seedFor(hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig) int64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s/%s/%s/%s", hpa.Namespace, hpa.Name, config.Type, config.Metric.Name)
+	return c.Seed ^ int64(h.Sum64())
+}
+
+// ChaosCollector is a developer tool wrapping a collector to delay and fail
+// collections at random. It's used to verify the behavior of the adapter
+// with flaky upstreams.
+type ChaosCollector struct {
+	collector Collector
+	config    ChaosConfig
+	rnd       *rand.Rand
+	mu        sync.Mutex
+	sleep     func(ctx context.Context, d time.Duration) error
+}
+
+// NewChaosCollector initializes a new ChaosCollector wrapping collector.
+// Collectors with the same seed inject the same sequence of faults.
+func NewChaosCollector(collector Collector, config ChaosConfig, seed int64) *ChaosCollector {
+	return &ChaosCollector{
+		collector: collector,
+		config:    config,
+		rnd:       rand.New(rand.NewSource(seed)),
+		sleep:     sleep.Context,
+	}
+}
+
+// faults draws the latency and failure of the next collection.
+func (c *ChaosCollector) faults() (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var latency time.Duration
+	if c.config.MaxLatency > 0 {
+		latency = time.Duration(c.rnd.Int63n(int64(c.config.MaxLatency)))
+	}
+	return latency, c.rnd.Float64() < c.config.FailureRate
+}
+
+// GetMetrics delays the collection by a random latency and fails it with
+// the configured failure rate. Otherwise the wrapped collector is called.
+func (c *ChaosCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, error) {
+	latency, fail := c.faults()
+
+	err := c.sleep(ctx, latency)
+	if err != nil {
+		return nil, err
+	}
+
+	if fail {
+		return nil, NewTransientError(ErrChaos)
+	}
+
+	return c.collector.GetMetrics(ctx)
+}
+
+// Interval returns the interval of the wrapped collector.
+func (c *ChaosCollector) Interval() time.Duration {
+	return c.collector.Interval()
+}
@@ -0,0 +1,126 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type chaosTestCollector struct {
+	calls int
+}
+
+func (c *chaosTestCollector) GetMetrics(_ context.Context) ([]CollectedMetric, error) {
+	c.calls++
+	return []CollectedMetric{{}}, nil
+}
+
+func (c *chaosTestCollector) Interval() time.Duration {
+	return time.Minute
+}
+
+// runChaos runs n collections and returns the injected latencies and
+// failures.
+func runChaos(t *testing.T, config ChaosConfig, seed int64, n int) ([]time.Duration, []bool) {
+	c := NewChaosCollector(&chaosTestCollector{}, config, seed)
+
+	latencies := make([]time.Duration, 0, n)
+	c.sleep = func(_ context.Context, d time.Duration) error {
+		latencies = append(latencies, d)
+		return nil
+	}
+
+	failures := make([]bool, 0, n)
+	for i := 0; i < n; i++ {
+		_, err := c.GetMetrics(context.Background())
+		if err != nil {
+			require.ErrorIs(t, err, ErrChaos)
+			require.ErrorIs(t, err, ErrTransient)
+		}
+		failures = append(failures, err != nil)
+	}
+
+	return latencies, failures
+}
+
+func TestChaosCollectorDistribution(t *testing.T) {
+	config := ChaosConfig{
+		MaxLatency:  time.Second,
+		FailureRate: 0.3,
+	}
+
+	n := 10000
+	latencies, failures := runChaos(t, config, 42, n)
+
+	failed := 0
+	for _, f := range failures {
+		if f {
+			failed++
+		}
+	}
+	require.InDelta(t, config.FailureRate, float64(failed)/float64(n), 0.02)
+
+	var total time.Duration
+	for _, latency := range latencies {
+		require.GreaterOrEqual(t, latency, time.Duration(0))
+		require.Less(t, latency, config.MaxLatency)
+		total += latency
+	}
+	require.InDelta(t, float64(config.MaxLatency/2), float64(total/time.Duration(n)), float64(50*time.Millisecond))
+}
+
+func TestChaosCollectorNoFaults(t *testing.T) {
+	latencies, failures := runChaos(t, ChaosConfig{}, 1, 100)
+	for i := range failures {
+		require.False(t, failures[i])
+		require.Zero(t, latencies[i])
+	}
+
+	_, failures = runChaos(t, ChaosConfig{FailureRate: 1}, 1, 100)
+	for _, f := range failures {
+		require.True(t, f)
+	}
+}
+
+func TestChaosCollectorSeedDeterminism(t *testing.T) {
+	config := ChaosConfig{
+		MaxLatency:  time.Second,
+		FailureRate: 0.5,
+	}
+
+	latencies1, failures1 := runChaos(t, config, 7, 100)
+	latencies2, failures2 := runChaos(t, config, 7, 100)
+	require.Equal(t, latencies1, latencies2)
+	require.Equal(t, failures1, failures2)
+
+	latencies3, _ := runChaos(t, config, 8, 100)
+	require.NotEqual(t, latencies1, latencies3)
+
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Name: "hpa", Namespace: "default"}}
+	metricConfig := &MetricConfig{MetricTypeName: MetricTypeName{Type: autoscalingv2.ExternalMetricSourceType, Metric: autoscalingv2.MetricIdentifier{Name: "a"}}}
+	otherConfig := &MetricConfig{MetricTypeName: MetricTypeName{Type: autoscalingv2.ExternalMetricSourceType, Metric: autoscalingv2.MetricIdentifier{Name: "b"}}}
+	require.Equal(t, config.seedFor(hpa, metricConfig), config.seedFor(hpa, metricConfig))
+	require.NotEqual(t, config.seedFor(hpa, metricConfig), config.seedFor(hpa, otherConfig))
+}
+
+func TestChaosCollectorLatencyCanceled(t *testing.T) {
+	inner := &chaosTestCollector{}
+	c := NewChaosCollector(inner, ChaosConfig{MaxLatency: time.Hour}, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := c.GetMetrics(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 0, inner.calls)
+}
+
+func TestChaosConfigValidate(t *testing.T) {
+	require.NoError(t, ChaosConfig{MaxLatency: time.Second, FailureRate: 0.5}.Validate())
+	require.Error(t, ChaosConfig{MaxLatency: -time.Second}.Validate())
+	require.Error(t, ChaosConfig{FailureRate: 1.5}.Validate())
+}
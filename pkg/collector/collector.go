@@ -3,17 +3,24 @@ package collector
 import (
 	"context"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/zalando-incubator/kube-metrics-adapter/pkg/annotations"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/metrics/pkg/apis/custom_metrics"
 	"k8s.io/metrics/pkg/apis/external_metrics"
 )
 
 const (
 	typeLabelKey = "type"
+
+	// maxSuggestionDistance is the maximum edit distance of a registered
+	// external plugin key suggested for an unknown type.
+	maxSuggestionDistance = 2
 )
 
 type ObjectReference struct {
@@ -22,10 +29,14 @@ type ObjectReference struct {
 }
 
 type CollectorFactory struct {
+	// mu guards the plugins as plugins may be registered while collectors
+	// are created, e.g. once the ScalingSchedule CRDs are installed.
+	mu              sync.RWMutex
 	podsPlugins     pluginMap
 	objectPlugins   objectPluginMap
 	externalPlugins map[string]CollectorPlugin
 	logger          *log.Entry
+	chaos           *ChaosConfig
 }
 
 type objectPluginMap struct {
@@ -56,9 +67,14 @@ type CollectorPlugin interface {
 
 type PluginNotFoundError struct {
 	metricTypeName MetricTypeName
+	// suggestion is the closest registered external plugin key, if any.
+	suggestion string
 }
 
 func (p *PluginNotFoundError) Error() string {
+	if p.suggestion != "" {
+		return fmt.Sprintf("no plugin found for %s, did you mean '%s'?", p.metricTypeName, p.suggestion)
+	}
 	return fmt.Sprintf("no plugin found for %s", p.metricTypeName)
 }
 
@@ -68,6 +84,9 @@ func (p *PluginNotFoundError) Is(target error) bool {
 }
 
 func (c *CollectorFactory) RegisterPodsCollector(metricCollector string, plugin CollectorPlugin) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if metricCollector == "" {
 		c.podsPlugins.Any = plugin
 	} else {
@@ -78,6 +97,9 @@ func (c *CollectorFactory) RegisterPodsCollector(metricCollector string, plugin
 }
 
 func (c *CollectorFactory) RegisterObjectCollector(kind, metricCollector string, plugin CollectorPlugin) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if kind == "" {
 		if metricCollector == "" {
 			c.objectPlugins.Any.Any = plugin
@@ -116,43 +138,272 @@ func (c *CollectorFactory) RegisterObjectCollector(kind, metricCollector string,
 }
 
 func (c *CollectorFactory) RegisterExternalCollector(metrics []string, plugin CollectorPlugin) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	for _, metric := range metrics {
 		c.externalPlugins[metric] = plugin
 	}
 }
 
+// RegisteredPlugins returns a sorted list describing the registered
+// plugins e.g. "pods/*", "object/ScalingSchedule/*", "object/*/prometheus"
+// or "external/prometheus".
+func (c *CollectorFactory) RegisteredPlugins() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var plugins []string
+
+	describe := func(prefix string, m pluginMap) {
+		if m.Any != nil {
+			plugins = append(plugins, prefix+"/*")
+		}
+		for name := range m.Named {
+			plugins = append(plugins, prefix+"/"+name)
+		}
+	}
+
+	describe("pods", c.podsPlugins)
+	describe("object/*", c.objectPlugins.Any)
+	for kind, m := range c.objectPlugins.Named {
+		describe("object/"+kind, *m)
+	}
+	for metric := range c.externalPlugins {
+		plugins = append(plugins, "external/"+metric)
+	}
+
+	sort.Strings(plugins)
+	return plugins
+}
+
+// ListRegisteredExternalTypes returns the sorted keys of the registered
+// external plugins, i.e. the supported values of the type label.
+func (c *CollectorFactory) ListRegisteredExternalTypes() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.externalTypes()
+}
+
+func (c *CollectorFactory) externalTypes() []string {
+	types := make([]string, 0, len(c.externalPlugins))
+	for typ := range c.externalPlugins {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// closestExternalType returns the registered external plugin key closest to
+// the given key within maxSuggestionDistance or an empty string if none is
+// close enough. The caller must hold the lock of the factory.
+func (c *CollectorFactory) closestExternalType(key string) string {
+	closest := ""
+	closestDistance := maxSuggestionDistance + 1
+	for _, typ := range c.externalTypes() {
+		if distance := levenshtein(key, typ); distance < closestDistance {
+			closest, closestDistance = typ, distance
+		}
+	}
+	return closest
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	previous := make([]int, len(t)+1)
+	current := make([]int, len(t)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(s); i++ {
+		current[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(t)]
+}
+
+// clusterScopedKinds are the kinds of cluster scoped objects which may be
+// described by an Object metric.
+var clusterScopedKinds = map[string]struct{}{
+	"ClusterScalingSchedule": {},
+	"Namespace":              {},
+	"Node":                   {},
+	"PersistentVolume":       {},
+}
+
+// NewCollector initializes a new collector for the metric config using the
+// registered plugins. If the config defines a derive option the collector is
+// wrapped to emit the derived values. If it defines drop-labels or
+// keep-labels the collector is wrapped to filter the labels of external
+// metrics. If it defines max-source-age collections of outdated metrics
+// fail. If it defines outlier-filter collections of outlier values are
+// rejected. If it defines smoothing the emitted values are smoothed, except for
+// the metrics of scaling schedules unless smoothing is configured by the
+// annotations of the HPA rather than the namespace defaults.
 func (c *CollectorFactory) NewCollector(ctx context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
+	c.defaultObjectNamespace(hpa, config)
+
+	collector, err := c.newCollector(ctx, hpa, config, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.TTL > 0 {
+		collector = &ttlCollector{collector: collector, ttl: config.TTL}
+	}
+
+	if c.chaos != nil {
+		collector = NewChaosCollector(collector, *c.chaos, c.chaos.seedFor(hpa, config))
+	}
+
+	if _, ok := config.Config[maxSourceAgeConfigKey]; ok {
+		collector, err = NewSourceAgeCollector(collector, config.Config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := config.Config[outlierFilterConfigKey]; ok {
+		collector, err = NewOutlierCollector(collector, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// labels are filtered before deriving values, so the derived values
+	// are tracked by the labels they're stored with.
+	_, drop := config.Config[dropLabelsConfigKey]
+	_, keep := config.Config[keepLabelsConfigKey]
+	if drop || keep {
+		collector, err = NewLabelFilterCollector(collector, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := config.Config[deriveConfigKey]; ok {
+		collector, err = NewDeriveCollector(collector, config.Config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := config.Config[smoothingConfigKey]; ok {
+		_, schedule := scalingScheduleKinds[config.ObjectReference.Kind]
+		if schedule && annotations.IsNamespaceDefault(config.ConfigSources[smoothingConfigKey]) {
+			c.logger.Infof("HPA %s/%s: not smoothing %s metric %s of %s '%s' configured by the namespace defaults", hpa.Namespace, hpa.Name, config.Type, config.Metric.Name, config.ObjectReference.Kind, config.ObjectReference.Name)
+			return collector, nil
+		}
+		return NewSmoothingCollector(collector, config)
+	}
+
+	return collector, nil
+}
+
+// ttlCollector sets the TTL of the metric config on the collected metrics,
+// so they're stored with it instead of the metrics TTL of the store.
+type ttlCollector struct {
+	collector Collector
+	ttl       time.Duration
+}
+
+func (c *ttlCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, error) {
+	metrics, err := c.collector.GetMetrics(ctx)
+	for i := range metrics {
+		metrics[i].TTL = c.ttl
+	}
+	return metrics, err
+}
+
+func (c *ttlCollector) Interval() time.Duration {
+	return c.collector.Interval()
+}
+
+// EnableChaos wraps all collectors created by the factory with a
+// ChaosCollector. It's meant for testing the adapter against flaky
+// upstreams and must not be used in production.
+func (c *CollectorFactory) EnableChaos(config ChaosConfig) {
+	c.logger.Warnf("Chaos mode enabled: collections are delayed by up to %s and fail with a rate of %v (seed %d)", config.MaxLatency, config.FailureRate, config.Seed)
+	c.chaos = &config
+}
+
+// defaultObjectNamespace sets the namespace of the object described by an
+// Object metric config to the namespace of the HPA if it's missing.
+// Otherwise the collected metrics would be stored as cluster scoped and not
+// be found when queried for the namespace of the HPA.
+func (c *CollectorFactory) defaultObjectNamespace(hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig) {
+	if config.Type != autoscalingv2.ObjectMetricSourceType {
+		return
+	}
+
+	if _, ok := clusterScopedKinds[config.ObjectReference.Kind]; ok {
+		// ParseHPAMetrics sets the namespace of the HPA for any kind.
+		if config.ObjectReference.Namespace != "" && config.ObjectReference.Namespace != hpa.Namespace {
+			c.logger.Warnf("HPA %s/%s references cluster scoped %s '%s' with unexpected namespace '%s'", hpa.Namespace, hpa.Name, config.ObjectReference.Kind, config.ObjectReference.Name, config.ObjectReference.Namespace)
+		}
+		return
+	}
+
+	if config.ObjectReference.Namespace == "" {
+		config.ObjectReference.Namespace = hpa.Namespace
+	}
+}
+
+func (c *CollectorFactory) newCollector(ctx context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
+	plugin, err := c.plugin(hpa, config)
+	if err != nil {
+		return nil, err
+	}
+	return plugin.NewCollector(ctx, hpa, config, interval)
+}
+
+// plugin returns the registered plugin for the metric config.
+func (c *CollectorFactory) plugin(hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig) (CollectorPlugin, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	switch config.Type {
 	case autoscalingv2.PodsMetricSourceType:
 		// first try to find a plugin by format
 		if plugin, ok := c.podsPlugins.Named[config.CollectorType]; ok {
-			return plugin.NewCollector(ctx, hpa, config, interval)
+			return plugin, nil
 		}
 
 		// else try to use the default plugin if set
 		if c.podsPlugins.Any != nil {
-			return c.podsPlugins.Any.NewCollector(ctx, hpa, config, interval)
+			return c.podsPlugins.Any, nil
 		}
 	case autoscalingv2.ObjectMetricSourceType:
 		// first try to find a plugin by kind
 		if kinds, ok := c.objectPlugins.Named[config.ObjectReference.Kind]; ok {
 			if plugin, ok := kinds.Named[config.CollectorType]; ok {
-				return plugin.NewCollector(ctx, hpa, config, interval)
+				return plugin, nil
 			}
 
 			if kinds.Any != nil {
-				return kinds.Any.NewCollector(ctx, hpa, config, interval)
+				return kinds.Any, nil
 			}
 			break
 		}
 
 		// else try to find a default plugin for this kind
 		if plugin, ok := c.objectPlugins.Any.Named[config.CollectorType]; ok {
-			return plugin.NewCollector(ctx, hpa, config, interval)
+			return plugin, nil
 		}
 
 		if c.objectPlugins.Any.Any != nil {
-			return c.objectPlugins.Any.Any.NewCollector(ctx, hpa, config, interval)
+			return c.objectPlugins.Any.Any, nil
 		}
 	case autoscalingv2.ExternalMetricSourceType:
 		// First type to get metric type from the `type` label,
@@ -167,10 +418,21 @@ func (c *CollectorFactory) NewCollector(ctx context.Context, hpa *autoscalingv2.
 		if pluginKey == "" {
 			pluginKey = config.Metric.Name
 			c.logger.Warnf("HPA %s/%s is using deprecated metric type identifier '%s'", hpa.Namespace, hpa.Name, config.Metric.Name)
+		} else if _, ok := c.externalPlugins[config.Metric.Name]; ok && pluginKey != config.Metric.Name {
+			// the legacy metric name maps to a different plugin than
+			// the type label, don't guess which one is meant.
+			if _, ok := c.externalPlugins[pluginKey]; ok {
+				return nil, NewPermanentConfigError(fmt.Errorf("external metric '%s' has the %s label '%s' but its name is the legacy identifier of the '%s' collector, rename the metric or remove the %s label", config.Metric.Name, typeLabelKey, pluginKey, config.Metric.Name, typeLabelKey))
+			}
 		}
 
 		if plugin, ok := c.externalPlugins[pluginKey]; ok {
-			return plugin.NewCollector(ctx, hpa, config, interval)
+			return plugin, nil
+		}
+
+		return nil, &PluginNotFoundError{
+			metricTypeName: config.MetricTypeName,
+			suggestion:     c.closestExternalType(pluginKey),
 		}
 	}
 
@@ -183,10 +445,44 @@ type MetricTypeName struct {
 }
 
 type CollectedMetric struct {
-	Type      autoscalingv2.MetricSourceType
+	Type autoscalingv2.MetricSourceType
+	// Namespace is the namespace of the HPA for external metrics and the
+	// namespace of the described object for custom metrics, see
+	// NewExternalMetric and NewCustomMetric.
 	Namespace string
 	Custom    custom_metrics.MetricValue
 	External  external_metrics.ExternalMetricValue
+	// Query is the effective query sent upstream to collect the metric,
+	// if the collector is query based. It's only recorded for debugging
+	// and never served as part of the metric.
+	Query string
+	// Raw is the collected value before it was smoothed, if smoothing is
+	// configured. It's only recorded for debugging.
+	Raw *resource.Quantity
+	// TTL overrides the metrics TTL of the metric store if set. It's set
+	// from the ttl config of the metric.
+	TTL time.Duration
+}
+
+// NewExternalMetric returns the collected value of an external metric of an
+// HPA. External metrics are always stored in the namespace of the HPA
+// collecting them, otherwise the HPA can't read them.
+func NewExternalMetric(hpaNamespace string, value external_metrics.ExternalMetricValue) CollectedMetric {
+	return CollectedMetric{
+		Type:      autoscalingv2.ExternalMetricSourceType,
+		Namespace: hpaNamespace,
+		External:  value,
+	}
+}
+
+// NewCustomMetric returns the collected value of a pods or object metric.
+// Custom metrics are stored in the namespace of the object they describe.
+func NewCustomMetric(metricType autoscalingv2.MetricSourceType, value custom_metrics.MetricValue) CollectedMetric {
+	return CollectedMetric{
+		Type:      metricType,
+		Namespace: value.DescribedObject.Namespace,
+		Custom:    value,
+	}
 }
 
 type Collector interface {
@@ -196,27 +492,58 @@ type Collector interface {
 
 type MetricConfig struct {
 	MetricTypeName
-	CollectorType   string
-	Config          map[string]string
+	CollectorType string
+	Config        map[string]string
+	// ConfigSources are the annotations defining the values of Config by
+	// config key. Values of metric selector labels have no source.
+	ConfigSources   map[string]string
 	ObjectReference custom_metrics.ObjectReference
 	PerReplica      bool
 	Interval        time.Duration
 	MinPodReadyAge  time.Duration
-	MetricSpec      autoscalingv2.MetricSpec
+	// TTL overrides the metrics TTL of the metric store for the collected
+	// metrics if set.
+	TTL        time.Duration
+	MetricSpec autoscalingv2.MetricSpec
 }
 
 // ParseHPAMetrics parses the HPA object into a list of metric configurations.
 func ParseHPAMetrics(hpa *autoscalingv2.HorizontalPodAutoscaler) ([]*MetricConfig, error) {
+	metricConfigs, _, err := ParseHPAMetricsWithWarnings(hpa)
+	return metricConfigs, err
+}
+
+// ParseHPAMetricsWithWarnings parses the HPA object into a list of metric
+// configurations. Additionally it returns a warning for each metric config
+// annotation which can't be attributed to a metric of the HPA.
+func ParseHPAMetricsWithWarnings(hpa *autoscalingv2.HorizontalPodAutoscaler) ([]*MetricConfig, []string, error) {
+	return ParseHPAMetricsWithDefaults(hpa, nil)
+}
+
+// ParseHPAMetricsWithDefaults parses the HPA object into a list of metric
+// configurations like ParseHPAMetricsWithWarnings. The default metric configs
+// defined by the annotations of the namespace of the HPA are merged under
+// the configs of the HPA, so the annotations and labels of the HPA win.
+// Invalid namespace defaults are ignored with a warning.
+func ParseHPAMetricsWithDefaults(hpa *autoscalingv2.HorizontalPodAutoscaler, namespaceAnnotations map[string]string) ([]*MetricConfig, []string, error) {
 	metricConfigs := make([]*MetricConfig, 0, len(hpa.Spec.Metrics))
 
-	// TODO: validate that the specified metric names are defined
-	// in the HPA
 	parser := make(annotations.AnnotationConfigMap)
-	err := parser.Parse(hpa.Annotations)
+	warnings, err := parser.ParseWithWarnings(hpa.Annotations)
 	if err != nil {
-		return nil, err
+		return nil, warnings, err
+	}
+
+	defaults := make(annotations.DefaultConfigMap)
+	defaultWarnings, err := defaults.ParseWithWarnings(namespaceAnnotations)
+	warnings = append(warnings, defaultWarnings...)
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("ignoring the metric config defaults of namespace %s: %v", hpa.Namespace, err))
+		defaults = nil
 	}
 
+	used := make(map[annotations.MetricConfigKey]struct{}, len(parser))
+
 	for _, metric := range hpa.Spec.Metrics {
 		typeName := MetricTypeName{
 			Type: metric.Type,
@@ -238,6 +565,10 @@ func ParseHPAMetrics(hpa *autoscalingv2.HorizontalPodAutoscaler) ([]*MetricConfi
 			typeName.Metric = metric.External.Metric
 		case autoscalingv2.ResourceMetricSourceType, autoscalingv2.ContainerResourceMetricSourceType:
 			continue // kube-metrics-adapter does not collect resource or container resource metrics
+		default:
+			// metric types added by future Kubernetes versions.
+			log.Debugf("HPA %s/%s: skipping metric of unsupported type %s", hpa.Namespace, hpa.Name, metric.Type)
+			continue
 		}
 
 		config := &MetricConfig{
@@ -263,17 +594,117 @@ func ParseHPAMetrics(hpa *autoscalingv2.HorizontalPodAutoscaler) ([]*MetricConfi
 
 		annotationConfigs, present := parser.GetAnnotationConfig(typeName.Metric.Name, typeName.Type)
 		if present {
+			used[annotations.MetricConfigKey{Type: typeName.Type, MetricName: typeName.Metric.Name}] = struct{}{}
 			config.CollectorType = annotationConfigs.CollectorType
 			config.Interval = annotationConfigs.Interval
 			config.PerReplica = annotationConfigs.PerReplica
 			config.MinPodReadyAge = annotationConfigs.MinPodReadyAge
+			config.TTL = annotationConfigs.TTL
 			// configs specified in annotations takes precedence
 			// over labels
 			for k, v := range annotationConfigs.Configs {
 				config.Config[k] = v
 			}
+			config.ConfigSources = annotationConfigs.Sources
+		}
+
+		if defaultConfigs, ok := defaults.Get(typeName.Type, defaultsCollectorType(config)); ok {
+			applyDefaults(config, defaultConfigs)
 		}
 		metricConfigs = append(metricConfigs, config)
 	}
-	return metricConfigs, nil
+
+	unused := make([]string, 0, len(parser))
+	for key := range parser {
+		if _, ok := used[key]; !ok {
+			unused = append(unused, fmt.Sprintf("metric config for %s metric %s doesn't match any metric of the HPA", key.Type, key.MetricName))
+		}
+	}
+	sort.Strings(unused)
+
+	return metricConfigs, append(warnings, unused...), nil
+}
+
+// defaultsCollectorType returns the collector type selecting the namespace
+// defaults of a metric config. External metrics without a collector type in
+// the annotations are selected by their type label, or the legacy metric
+// name.
+func defaultsCollectorType(config *MetricConfig) string {
+	if config.CollectorType != "" || config.Type != autoscalingv2.ExternalMetricSourceType {
+		return config.CollectorType
+	}
+	if typ, ok := config.Config[typeLabelKey]; ok {
+		return typ
+	}
+	return config.Metric.Name
+}
+
+// applyDefaults sets the values of the namespace defaults which aren't set
+// by the metric config.
+func applyDefaults(config *MetricConfig, defaults *annotations.AnnotationConfigs) {
+	for k, v := range defaults.Configs {
+		if _, ok := config.Config[k]; !ok {
+			config.Config[k] = v
+			if source, ok := defaults.Sources[k]; ok {
+				if config.ConfigSources == nil {
+					config.ConfigSources = map[string]string{}
+				}
+				config.ConfigSources[k] = source
+			}
+		}
+	}
+	if config.Interval == 0 {
+		config.Interval = defaults.Interval
+	}
+	if config.MinPodReadyAge == 0 {
+		config.MinPodReadyAge = defaults.MinPodReadyAge
+	}
+	if config.TTL == 0 {
+		config.TTL = defaults.TTL
+	}
+	config.PerReplica = config.PerReplica || defaults.PerReplica
+}
+
+// requestTimeoutKey is the metric config key overriding the timeout of the
+// requests of a collector querying a remote service.
+const requestTimeoutKey = "timeout"
+
+// parseRequestTimeout parses the optional request timeout of the metric
+// config. It returns 0 if no timeout is configured.
+func parseRequestTimeout(config *MetricConfig) (time.Duration, error) {
+	var timeout time.Duration
+	b := config.binder()
+	b.PositiveDuration(requestTimeoutKey, &timeout)
+	if err := b.Err(); err != nil {
+		return 0, NewPermanentConfigError(err)
+	}
+	return timeout, nil
+}
+
+// binder returns a ConfigBinder of the config values of the metric config.
+func (c *MetricConfig) binder() *annotations.ConfigBinder {
+	return annotations.NewConfigBinder(c.Config, c.ConfigSources)
+}
+
+// finishBinding logs the config keys of the annotations of the HPA which
+// weren't bound by the collector and returns the error of the binder as a
+// permanent config error.
+func finishBinding(b *annotations.ConfigBinder, hpa *autoscalingv2.HorizontalPodAutoscaler) error {
+	for _, warning := range b.Warnings() {
+		log.Warnf("HPA %s/%s: %s", hpa.Namespace, hpa.Name, warning)
+	}
+	if err := b.Err(); err != nil {
+		return NewPermanentConfigError(err)
+	}
+	return nil
+}
+
+// withRequestTimeout returns a context canceled after timeout, if the
+// timeout is set. Canceling the context aborts in-flight requests including
+// reading their response bodies.
+func withRequestTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
 }
@@ -2,12 +2,24 @@ package collector
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/require"
+	rgfake "github.com/szuecs/routegroup-client/client/clientset/versioned/fake"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/nakadi"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/redis"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/zmon"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/metrics/pkg/apis/custom_metrics"
 )
 
 type mockCollectorPlugin struct {
@@ -35,6 +47,7 @@ func TestNewCollector(t *testing.T) {
 		msg               string
 		hpa               *autoscalingv2.HorizontalPodAutoscaler
 		expectedCollector string
+		expectedPermanent bool
 	}{
 		{
 			msg: "should get create collector type from legacy metric name",
@@ -61,7 +74,7 @@ func TestNewCollector(t *testing.T) {
 			expectedCollector: "external-1",
 		},
 		{
-			msg: "should get create collector type from type label (ignore legacy metric name)",
+			msg: "should get create collector type from type label",
 			hpa: &autoscalingv2.HorizontalPodAutoscaler{
 				ObjectMeta: metav1.ObjectMeta{
 					Annotations: map[string]string{},
@@ -72,7 +85,31 @@ func TestNewCollector(t *testing.T) {
 							Type: autoscalingv2.ExternalMetricSourceType,
 							External: &autoscalingv2.ExternalMetricSource{
 								Metric: autoscalingv2.MetricIdentifier{
-									Name: "external-1",
+									Name: "my-metric",
+									Selector: &metav1.LabelSelector{
+										MatchLabels: map[string]string{"type": "external-2"},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			expectedCollector: "external-2",
+		},
+		{
+			msg: "should get create collector type from type label (unregistered metric name)",
+			hpa: &autoscalingv2.HorizontalPodAutoscaler{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{},
+				},
+				Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+					Metrics: []autoscalingv2.MetricSpec{
+						{
+							Type: autoscalingv2.ExternalMetricSourceType,
+							External: &autoscalingv2.ExternalMetricSource{
+								Metric: autoscalingv2.MetricIdentifier{
+									Name: "external-3",
 									Selector: &metav1.LabelSelector{
 										MatchLabels: map[string]string{"type": "external-2"},
 									},
@@ -84,6 +121,31 @@ func TestNewCollector(t *testing.T) {
 			},
 			expectedCollector: "external-2",
 		},
+		{
+			msg: "should fail when type label and legacy metric name select different collectors",
+			hpa: &autoscalingv2.HorizontalPodAutoscaler{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{},
+				},
+				Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+					Metrics: []autoscalingv2.MetricSpec{
+						{
+							Type: autoscalingv2.ExternalMetricSourceType,
+							External: &autoscalingv2.ExternalMetricSource{
+								Metric: autoscalingv2.MetricIdentifier{
+									Name: "external-1",
+									Selector: &metav1.LabelSelector{
+										MatchLabels: map[string]string{"type": "external-2"},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			expectedCollector: "",
+			expectedPermanent: true,
+		},
 		{
 			msg: "should not find collector when no collector matches",
 			hpa: &autoscalingv2.HorizontalPodAutoscaler{
@@ -118,6 +180,9 @@ func TestNewCollector(t *testing.T) {
 			collector, err := collectorFactory.NewCollector(context.Background(), tc.hpa, configs[0], 0)
 			if tc.expectedCollector == "" {
 				require.Error(t, err)
+				if tc.expectedPermanent {
+					require.ErrorIs(t, err, ErrPermanentConfig)
+				}
 			} else {
 				require.NoError(t, err)
 
@@ -128,3 +193,659 @@ func TestNewCollector(t *testing.T) {
 		})
 	}
 }
+
+func TestNewCollectorSuggestsExternalType(t *testing.T) {
+	collectorFactory := NewCollectorFactory()
+	for _, typ := range []string{"prometheus", "sqs-queue-length", "zmon", "nakadi"} {
+		collectorFactory.RegisterExternalCollector([]string{typ}, &mockCollectorPlugin{Name: typ})
+	}
+
+	for _, tc := range []struct {
+		typ        string
+		suggestion string
+	}{
+		{typ: "prometeus", suggestion: "prometheus"},
+		{typ: "Prometheus", suggestion: "prometheus"},
+		{typ: "sqs-queue-lenght", suggestion: "sqs-queue-length"},
+		{typ: "zmom", suggestion: "zmon"},
+		{typ: "influxdb", suggestion: ""},
+		{typ: "prom", suggestion: ""},
+	} {
+		t.Run(tc.typ, func(t *testing.T) {
+			config := &MetricConfig{
+				MetricTypeName: MetricTypeName{
+					Type:   autoscalingv2.ExternalMetricSourceType,
+					Metric: newMetricIdentifier("requests", tc.typ),
+				},
+			}
+
+			_, err := collectorFactory.NewCollector(context.Background(), &autoscalingv2.HorizontalPodAutoscaler{}, config, 0)
+			require.ErrorIs(t, err, &PluginNotFoundError{})
+			if tc.suggestion != "" {
+				require.Contains(t, err.Error(), "did you mean '"+tc.suggestion+"'?")
+			} else {
+				require.NotContains(t, err.Error(), "did you mean")
+			}
+		})
+	}
+}
+
+func TestListRegisteredExternalTypes(t *testing.T) {
+	collectorFactory := NewCollectorFactory()
+	require.Empty(t, collectorFactory.ListRegisteredExternalTypes())
+
+	collectorFactory.RegisterExternalCollector([]string{"zmon", "prometheus"}, &mockCollectorPlugin{})
+	collectorFactory.RegisterPodsCollector("json-path", &mockCollectorPlugin{})
+	require.Equal(t, []string{"prometheus", "zmon"}, collectorFactory.ListRegisteredExternalTypes())
+}
+
+func TestParseHPAMetricsDottedMetricNames(t *testing.T) {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				"metric-config.external.queue.primary%2Fdepth.prometheus/query":       "sum(queue_depth)",
+				"metric-config.external.queue.primary%2Fdepth.prometheus/path/to/key": "value",
+				"metric-config.external.queue.secondary.prometheus/query":             "sum(queue_depth)",
+			},
+		},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			Metrics: []autoscalingv2.MetricSpec{
+				{
+					Type: autoscalingv2.ExternalMetricSourceType,
+					External: &autoscalingv2.ExternalMetricSource{
+						Metric: autoscalingv2.MetricIdentifier{
+							Name: "queue.primary/depth",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	configs, warnings, err := ParseHPAMetricsWithWarnings(hpa)
+	require.NoError(t, err)
+	require.Len(t, configs, 1)
+	require.Equal(t, "prometheus", configs[0].CollectorType)
+	require.Equal(t, map[string]string{
+		"query":       "sum(queue_depth)",
+		"path/to/key": "value",
+	}, configs[0].Config)
+	require.Len(t, warnings, 1)
+	require.Contains(t, warnings[0], "queue.secondary")
+}
+
+func TestParseHPAMetricsSkipsResourceMetrics(t *testing.T) {
+	utilization := int32(80)
+	value := resource.MustParse("10")
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"metric-config.pods.requests-per-second.json-path/json-key": "$.rps",
+				"metric-config.pods.requests-per-second.json-path/port":     "9090",
+			},
+		},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			Metrics: []autoscalingv2.MetricSpec{
+				{
+					Type: autoscalingv2.ResourceMetricSourceType,
+					Resource: &autoscalingv2.ResourceMetricSource{
+						Name:   corev1.ResourceCPU,
+						Target: autoscalingv2.MetricTarget{Type: autoscalingv2.UtilizationMetricType, AverageUtilization: &utilization},
+					},
+				},
+				{
+					Type: autoscalingv2.ContainerResourceMetricSourceType,
+					ContainerResource: &autoscalingv2.ContainerResourceMetricSource{
+						Name:      corev1.ResourceMemory,
+						Container: "app",
+						Target:    autoscalingv2.MetricTarget{Type: autoscalingv2.UtilizationMetricType, AverageUtilization: &utilization},
+					},
+				},
+				{
+					Type: autoscalingv2.PodsMetricSourceType,
+					Pods: &autoscalingv2.PodsMetricSource{
+						Metric: autoscalingv2.MetricIdentifier{Name: "requests-per-second"},
+						Target: autoscalingv2.MetricTarget{Type: autoscalingv2.AverageValueMetricType, AverageValue: &value},
+					},
+				},
+				{
+					Type: autoscalingv2.ExternalMetricSourceType,
+					External: &autoscalingv2.ExternalMetricSource{
+						Metric: autoscalingv2.MetricIdentifier{
+							Name:     "queue-length",
+							Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"type": "sqs-queue-length", "queue-name": "jobs", "region": "eu-central-1"}},
+						},
+						Target: autoscalingv2.MetricTarget{Type: autoscalingv2.AverageValueMetricType, AverageValue: &value},
+					},
+				},
+				{
+					// a metric type of a future Kubernetes version.
+					Type: autoscalingv2.MetricSourceType("Future"),
+				},
+			},
+		},
+	}
+
+	configs, warnings, err := ParseHPAMetricsWithWarnings(hpa)
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+	require.Len(t, configs, 2)
+
+	require.Equal(t, MetricTypeName{
+		Type:   autoscalingv2.PodsMetricSourceType,
+		Metric: autoscalingv2.MetricIdentifier{Name: "requests-per-second"},
+	}, configs[0].MetricTypeName)
+	require.Equal(t, "json-path", configs[0].CollectorType)
+	require.Equal(t, map[string]string{"json-key": "$.rps", "port": "9090"}, configs[0].Config)
+
+	require.Equal(t, autoscalingv2.ExternalMetricSourceType, configs[1].Type)
+	require.Equal(t, "queue-length", configs[1].Metric.Name)
+	require.Equal(t, "jobs", configs[1].Config["queue-name"])
+}
+
+func TestParseHPAMetricsWithDefaults(t *testing.T) {
+	namespaceAnnotations := map[string]string{
+		"metric-config-default.external.prometheus/prometheus-server": "http://prometheus",
+		"metric-config-default.external.prometheus/interval":          "1m",
+		"metric-config-default.external.prometheus/ttl":               "10m",
+		"metric-config-default.external.zmon/interval":                "5m",
+	}
+
+	newHPA := func(annotations map[string]string, labels map[string]string) *autoscalingv2.HorizontalPodAutoscaler {
+		return &autoscalingv2.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "default",
+				Annotations: annotations,
+			},
+			Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+				Metrics: []autoscalingv2.MetricSpec{
+					{
+						Type: autoscalingv2.ExternalMetricSourceType,
+						External: &autoscalingv2.ExternalMetricSource{
+							Metric: autoscalingv2.MetricIdentifier{
+								Name: "rps",
+								Selector: &metav1.LabelSelector{
+									MatchLabels: labels,
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	for _, tc := range []struct {
+		msg                  string
+		hpa                  *autoscalingv2.HorizontalPodAutoscaler
+		namespaceAnnotations map[string]string
+		expectedConfig       map[string]string
+		expectedInterval     time.Duration
+		expectedTTL          time.Duration
+	}{
+		{
+			msg: "defaults only",
+			hpa: newHPA(map[string]string{
+				"metric-config.external.rps.prometheus/query": "sum(rps)",
+			}, nil),
+			namespaceAnnotations: namespaceAnnotations,
+			expectedConfig: map[string]string{
+				"query":             "sum(rps)",
+				"prometheus-server": "http://prometheus",
+			},
+			expectedInterval: time.Minute,
+			expectedTTL:      10 * time.Minute,
+		},
+		{
+			msg: "hpa annotations override defaults",
+			hpa: newHPA(map[string]string{
+				"metric-config.external.rps.prometheus/query":             "sum(rps)",
+				"metric-config.external.rps.prometheus/prometheus-server": "http://other",
+				"metric-config.external.rps.prometheus/interval":          "10s",
+				"metric-config.external.rps.prometheus/ttl":               "1m",
+			}, nil),
+			namespaceAnnotations: namespaceAnnotations,
+			expectedConfig: map[string]string{
+				"query":             "sum(rps)",
+				"prometheus-server": "http://other",
+			},
+			expectedInterval: 10 * time.Second,
+			expectedTTL:      time.Minute,
+		},
+		{
+			msg: "hpa labels override defaults",
+			hpa: newHPA(nil, map[string]string{
+				"type":              "prometheus",
+				"prometheus-server": "http://other",
+			}),
+			namespaceAnnotations: namespaceAnnotations,
+			expectedConfig: map[string]string{
+				"type":              "prometheus",
+				"prometheus-server": "http://other",
+			},
+			expectedInterval: time.Minute,
+			expectedTTL:      10 * time.Minute,
+		},
+		{
+			msg: "no defaults",
+			hpa: newHPA(map[string]string{
+				"metric-config.external.rps.prometheus/query": "sum(rps)",
+			}, nil),
+			expectedConfig: map[string]string{
+				"query": "sum(rps)",
+			},
+		},
+		{
+			msg: "defaults of other collectors are ignored",
+			hpa: newHPA(map[string]string{
+				"metric-config.external.rps.influxdb/query": "from(bucket: \"rps\")",
+			}, nil),
+			namespaceAnnotations: namespaceAnnotations,
+			expectedConfig: map[string]string{
+				"query": "from(bucket: \"rps\")",
+			},
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			configs, warnings, err := ParseHPAMetricsWithDefaults(tc.hpa, tc.namespaceAnnotations)
+			require.NoError(t, err)
+			require.Empty(t, warnings)
+			require.Len(t, configs, 1)
+			require.Equal(t, tc.expectedConfig, configs[0].Config)
+			require.Equal(t, tc.expectedInterval, configs[0].Interval)
+			require.Equal(t, tc.expectedTTL, configs[0].TTL)
+		})
+	}
+}
+
+func TestParseHPAMetricsWithInvalidDefaults(t *testing.T) {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Annotations: map[string]string{
+				"metric-config.external.rps.prometheus/query": "sum(rps)",
+			},
+		},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			Metrics: []autoscalingv2.MetricSpec{
+				{
+					Type: autoscalingv2.ExternalMetricSourceType,
+					External: &autoscalingv2.ExternalMetricSource{
+						Metric: autoscalingv2.MetricIdentifier{Name: "rps"},
+					},
+				},
+			},
+		},
+	}
+
+	configs, warnings, err := ParseHPAMetricsWithDefaults(hpa, map[string]string{
+		"metric-config-default.external.prometheus/interval": "30",
+	})
+	require.NoError(t, err)
+	require.Len(t, warnings, 1)
+	require.Contains(t, warnings[0], "ignoring the metric config defaults of namespace default")
+	require.Len(t, configs, 1)
+	require.Equal(t, map[string]string{"query": "sum(rps)"}, configs[0].Config)
+	require.Zero(t, configs[0].Interval)
+}
+
+func TestParseHPAMetricsConfigSources(t *testing.T) {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Annotations: map[string]string{
+				"metric-config.external.lag.nakadi/subscription-id": "id",
+				"metric-config.external.lag.nakadi/metric-type":     "unconsumed-events",
+			},
+		},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			Metrics: []autoscalingv2.MetricSpec{
+				{
+					Type: autoscalingv2.ExternalMetricSourceType,
+					External: &autoscalingv2.ExternalMetricSource{
+						Metric: autoscalingv2.MetricIdentifier{
+							Name: "lag",
+							Selector: &metav1.LabelSelector{
+								MatchLabels: map[string]string{"type": "nakadi"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	configs, _, err := ParseHPAMetricsWithDefaults(hpa, map[string]string{
+		"metric-config-default.external.nakadi/unassigned-partitions": "ignore",
+	})
+	require.NoError(t, err)
+	require.Len(t, configs, 1)
+	require.Equal(t, map[string]string{
+		"subscription-id":       "metric-config.external.lag.nakadi/subscription-id",
+		"metric-type":           "metric-config.external.lag.nakadi/metric-type",
+		"unassigned-partitions": "metric-config-default.external.nakadi/unassigned-partitions",
+	}, configs[0].ConfigSources)
+
+	// the values of the annotations are validated by the parser already.
+	configs[0].Config["metric-type"] = "lag"
+	_, err = NewNakadiCollector(context.Background(), nil, hpa, configs[0], time.Second)
+	require.ErrorIs(t, err, ErrPermanentConfig)
+	require.EqualError(t, err, "invalid value 'lag' of config key 'metric-type' in annotation metric-config.external.lag.nakadi/metric-type: must be one of consumer-lag-seconds, unconsumed-events")
+}
+
+func TestNewCollectorDefaultsObjectNamespace(t *testing.T) {
+	factory := NewCollectorFactory()
+	plugin := &FakeCollectorPlugin{}
+	require.NoError(t, factory.RegisterObjectCollector("", "", plugin))
+
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "hpa", Namespace: "default"},
+	}
+
+	for _, tc := range []struct {
+		msg       string
+		kind      string
+		namespace string
+		expected  string
+	}{
+		{
+			msg:      "missing namespace of namespaced kind is defaulted",
+			kind:     "Ingress",
+			expected: "default",
+		},
+		{
+			msg:       "namespace of namespaced kind is kept",
+			kind:      "Ingress",
+			namespace: "other",
+			expected:  "other",
+		},
+		{
+			msg:  "cluster scoped kind is not defaulted",
+			kind: "ClusterScalingSchedule",
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			config := &MetricConfig{
+				MetricTypeName: MetricTypeName{Type: autoscalingv2.ObjectMetricSourceType},
+				ObjectReference: custom_metrics.ObjectReference{
+					Kind:      tc.kind,
+					Name:      "object",
+					Namespace: tc.namespace,
+				},
+			}
+
+			_, err := factory.NewCollector(context.Background(), hpa, config, time.Minute)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, config.ObjectReference.Namespace)
+		})
+	}
+}
+
+func TestNewCollectorSetsMetricTTL(t *testing.T) {
+	factory := NewCollectorFactory()
+	factory.RegisterExternalCollector([]string{PrometheusMetricType}, &childCollectorPlugin{value: 1})
+
+	for _, ttl := range []time.Duration{0, 5 * time.Minute} {
+		config := &MetricConfig{
+			MetricTypeName: MetricTypeName{
+				Type: autoscalingv2.ExternalMetricSourceType,
+				Metric: autoscalingv2.MetricIdentifier{
+					Name:     "rps",
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{typeLabelKey: PrometheusMetricType}},
+				},
+			},
+			TTL: ttl,
+		}
+
+		c, err := factory.NewCollector(context.Background(), &autoscalingv2.HorizontalPodAutoscaler{}, config, time.Minute)
+		require.NoError(t, err)
+		metrics, err := c.GetMetrics(context.Background())
+		require.NoError(t, err)
+		require.Len(t, metrics, 1)
+		require.Equal(t, ttl, metrics[0].TTL)
+	}
+}
+
+// newStalledServer returns a server writing the response headers and
+// stalling while sending the body until the request is canceled.
+func newStalledServer(t *testing.T) *httptest.Server {
+	stop := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{`))
+		w.(http.Flusher).Flush()
+		select {
+		case <-stop:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(func() {
+		close(stop)
+		server.Close()
+	})
+	return server
+}
+
+func TestParseRequestTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		msg      string
+		config   map[string]string
+		expected time.Duration
+		err      bool
+	}{
+		{
+			msg:    "no timeout",
+			config: map[string]string{},
+		},
+		{
+			msg:      "valid timeout",
+			config:   map[string]string{"timeout": "5s"},
+			expected: 5 * time.Second,
+		},
+		{
+			msg:    "invalid timeout",
+			config: map[string]string{"timeout": "soon"},
+			err:    true,
+		},
+		{
+			msg:    "non-positive timeout",
+			config: map[string]string{"timeout": "0s"},
+			err:    true,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			timeout, err := parseRequestTimeout(&MetricConfig{Config: tc.config})
+			if tc.err {
+				require.ErrorIs(t, err, ErrPermanentConfig)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, timeout)
+		})
+	}
+}
+
+type nakadiMock struct {
+	value int64
+}
+
+func (m nakadiMock) ConsumerLagSeconds(_ context.Context, _ string, _ nakadi.UnassignedPartitions) (int64, error) {
+	return m.value, nil
+}
+
+func (m nakadiMock) UnconsumedEvents(_ context.Context, _ string) (int64, error) {
+	return m.value, nil
+}
+
+// TestCollectedMetricNamespace walks the plugins of a factory set up like
+// the one of the adapter and asserts that external metrics are stored in
+// the namespace of the HPA and custom metrics in the namespace of the
+// object they describe. The legacy external types are served by the same
+// plugins and aren't registered.
+func TestCollectedMetricNamespace(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	makeTestDeployment(t, client)
+	host, port, _ := makeTestHTTPServer(t, [][]int64{{1}, {2}, {3}, {4}})
+	podCondition := corev1.PodCondition{Type: corev1.PodReady, Status: corev1.ConditionTrue, LastTransitionTime: metav1.NewTime(time.Now().Add(-30 * time.Second))}
+	makeTestPods(t, host, "test-metric", port, client, 2, podCondition, time.Time{})
+	hpa := makeTestHPA(t, client)
+
+	promPlugin := &PrometheusCollectorPlugin{
+		client: client,
+		promAPI: &mockPromAPI{value: model.Vector{
+			{Metric: model.Metric{"pod": "test-pod-0"}, Value: 1},
+			{Metric: model.Metric{"pod": "test-pod-1"}, Value: 2},
+		}},
+	}
+	rpsPlugin, err := NewExternalRPSCollectorPlugin(promPlugin, "skipper_serve_host_duration_seconds_count")
+	require.NoError(t, err)
+	influxDBPlugin, err := NewInfluxDBCollectorPlugin(client, nil, makeInfluxDBTestServer(t, 1), "secret", "deadbeef")
+	require.NoError(t, err)
+	httpPlugin, err := NewHTTPCollectorPlugin(nil)
+	require.NoError(t, err)
+	zmonPlugin, err := NewZMONCollectorPlugin(zmonMock{dataPoints: []zmon.DataPoint{{Time: time.Now(), Value: 1}}}, nil)
+	require.NoError(t, err)
+	nakadiPlugin, err := NewNakadiCollectorPlugin(nakadiMock{value: 1})
+	require.NoError(t, err)
+	redisPlugin, err := NewRedisCollectorPlugin(newMiniredis(t).Addr(), nil, redis.Options{})
+	require.NoError(t, err)
+	selfPlugin, err := NewSelfCollectorPlugin(fakeCollectionLagSource{})
+	require.NoError(t, err)
+	scalingSchedulePlugin, err := NewScalingScheduleCollectorPlugin(newMockStore("schedule", testNamespace, nil, nil), time.Now, defaultScalingWindowDuration, defaultTimeZone, defaultRampSteps)
+	require.NoError(t, err)
+	clusterScalingSchedulePlugin, err := NewClusterScalingScheduleCollectorPlugin(newClusterMockStore("schedule", nil, nil), time.Now, defaultScalingWindowDuration, defaultTimeZone, defaultRampSteps)
+	require.NoError(t, err)
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectRollback()
+	sqlPlugin, err := NewSQLCollectorPlugin(db, nil, time.Second, 0)
+	require.NoError(t, err)
+
+	rgClient := rgfake.NewSimpleClientset()
+	require.NoError(t, makeIngress(client, testNamespace, "app", testDeploymentName, []string{"example.org"}, nil))
+	require.NoError(t, makeRoutegroup(rgClient, testNamespace, "app", []string{"example.org"}, nil))
+	skipperPlugin, err := NewSkipperCollectorPlugin(client, rgClient, promPlugin, nil)
+	require.NoError(t, err)
+
+	probe := newHTTPProbeTestServer(t, func(int64) (time.Duration, int) { return 0, http.StatusOK })
+
+	factory := NewCollectorFactory()
+	require.NoError(t, factory.RegisterObjectCollector("", PrometheusMetricType, promPlugin))
+	require.NoError(t, factory.RegisterPodsCollector(PrometheusMetricType, promPlugin))
+	factory.RegisterExternalCollector([]string{PrometheusMetricType}, promPlugin)
+	factory.RegisterExternalCollector([]string{TimeToDrainMetricType}, NewTimeToDrainCollectorPlugin(factory))
+	factory.RegisterExternalCollector([]string{ExternalRPSMetricType}, rpsPlugin)
+	require.NoError(t, factory.RegisterObjectCollector("", InfluxDBMetricType, influxDBPlugin))
+	factory.RegisterExternalCollector([]string{InfluxDBMetricType}, influxDBPlugin)
+	factory.RegisterExternalCollector([]string{HTTPJSONPathType}, httpPlugin)
+	factory.RegisterExternalCollector([]string{HTTPProbeMetricType}, NewHTTPProbeCollectorPlugin(nil))
+	podPlugin := NewPodCollectorPlugin(client, nil)
+	require.NoError(t, factory.RegisterPodsCollector("", podPlugin))
+	require.NoError(t, factory.RegisterObjectCollector("", HTTPJSONPathType, podPlugin))
+	factory.RegisterExternalCollector([]string{ZMONMetricType}, zmonPlugin)
+	factory.RegisterExternalCollector([]string{NakadiMetricType}, nakadiPlugin)
+	factory.RegisterExternalCollector([]string{SQLMetricType}, sqlPlugin)
+	factory.RegisterExternalCollector([]string{RedisMetricType}, redisPlugin)
+	factory.RegisterExternalCollector([]string{AWSSQSQueueLengthMetric}, newTestAWSCollectorPlugin(t, &fakeAWSConfigFactory{created: map[string]int{}}, []string{"eu-central-1"}, false, 0))
+	factory.RegisterExternalCollector([]string{SelfMetricType}, selfPlugin)
+	require.NoError(t, factory.RegisterObjectCollector("ScalingSchedule", "", scalingSchedulePlugin))
+	require.NoError(t, factory.RegisterObjectCollector("ClusterScalingSchedule", "", clusterScalingSchedulePlugin))
+	require.NoError(t, factory.RegisterObjectCollector("Ingress", "", skipperPlugin))
+	require.NoError(t, factory.RegisterObjectCollector("RouteGroup", "", skipperPlugin))
+
+	external := func(typ string, config map[string]string) *MetricConfig {
+		values := map[string]string{typeLabelKey: typ}
+		for k, v := range config {
+			values[k] = v
+		}
+		return &MetricConfig{
+			MetricTypeName: MetricTypeName{
+				Type:   autoscalingv2.ExternalMetricSourceType,
+				Metric: autoscalingv2.MetricIdentifier{Name: "test-metric", Selector: &metav1.LabelSelector{MatchLabels: map[string]string{typeLabelKey: typ}}},
+			},
+			Config: values,
+		}
+	}
+	object := func(kind, name, collectorType string, config map[string]string) *MetricConfig {
+		return &MetricConfig{
+			MetricTypeName: MetricTypeName{
+				Type:   autoscalingv2.ObjectMetricSourceType,
+				Metric: autoscalingv2.MetricIdentifier{Name: "test-metric"},
+			},
+			CollectorType:   collectorType,
+			ObjectReference: custom_metrics.ObjectReference{Kind: kind, Name: name},
+			Config:          config,
+		}
+	}
+	pods := func(collectorType string, config map[string]string) *MetricConfig {
+		return &MetricConfig{
+			MetricTypeName: MetricTypeName{
+				Type:   autoscalingv2.PodsMetricSourceType,
+				Metric: autoscalingv2.MetricIdentifier{Name: "test-metric"},
+			},
+			CollectorType: collectorType,
+			Config:        config,
+		}
+	}
+
+	skipper := func(kind string) *MetricConfig {
+		config := makeConfig("app", "", kind, testDeploymentName, false)
+		config.Type = autoscalingv2.ObjectMetricSourceType
+		return config
+	}
+
+	jsonPath := map[string]string{"json-key": "$.values", "port": port, "path": "/metrics", "aggregator": "sum"}
+	configs := map[string]*MetricConfig{
+		"external/" + PrometheusMetricType:    external(PrometheusMetricType, map[string]string{"query": "sum(rate(requests_total[1m]))"}),
+		"external/" + TimeToDrainMetricType:   external(TimeToDrainMetricType, map[string]string{"backlog-type": AWSSQSQueueLengthMetric, "backlog.queue-name": "queue", "backlog.region": "eu-central-1", "rate.query": "sum(rate(processed_total[1m]))"}),
+		"external/" + ExternalRPSMetricType:   external(ExternalRPSMetricType, map[string]string{"hostnames": "example.org"}),
+		"external/" + InfluxDBMetricType:      external(InfluxDBMetricType, map[string]string{"query-name": "rps", "rps": `from(bucket: "?") |> range(start: -1m)`}),
+		"external/" + HTTPJSONPathType:        external(HTTPJSONPathType, map[string]string{HTTPJsonPathAnnotationKey: "$.values", HTTPEndpointAnnotationKey: makeHTTPTestServer(t, []int64{1}), "aggregator": "sum"}),
+		"external/" + HTTPProbeMetricType:     external(HTTPProbeMetricType, map[string]string{httpProbeURLKey: probe.URL, httpProbeMeasurementKey: "status-ok-ratio", httpProbeSamplesKey: "1"}),
+		"external/" + ZMONMetricType:          external(ZMONMetricType, map[string]string{zmonCheckIDLabelKey: "1234", zmonKeyLabelKey: "key"}),
+		"external/" + NakadiMetricType:        external(NakadiMetricType, map[string]string{nakadiSubscriptionIDKey: "subscription", nakadiMetricTypeKey: nakadiMetricTypeUnconsumedEvents}),
+		"external/" + SQLMetricType:           external(SQLMetricType, map[string]string{sqlQueryKey: "SELECT count(*) FROM jobs"}),
+		"external/" + RedisMetricType:         external(RedisMetricType, map[string]string{"key": "jobs", "kind": "list"}),
+		"external/" + AWSSQSQueueLengthMetric: external(AWSSQSQueueLengthMetric, map[string]string{sqsQueueNameLabelKey: "queue", sqsQueueRegionLabelKey: "eu-central-1"}),
+		"external/" + SelfMetricType:          external(SelfMetricType, nil),
+		"object/*/" + PrometheusMetricType:    object("Deployment", testDeploymentName, PrometheusMetricType, map[string]string{"query": "sum(rate(requests_total[1m]))"}),
+		"object/*/" + InfluxDBMetricType:      object("Deployment", testDeploymentName, InfluxDBMetricType, map[string]string{"query-name": "rps", "rps": `from(bucket: "?") |> range(start: -1m)`}),
+		"object/*/" + HTTPJSONPathType:        object("Deployment", testDeploymentName, HTTPJSONPathType, map[string]string{"json-key": "$.values", "port": port, "path": "/metrics", "aggregator": "sum", "emit-aggregate": "sum"}),
+		"object/ScalingSchedule/*":            object("ScalingSchedule", "schedule", "", map[string]string{}),
+		"object/ClusterScalingSchedule/*":     object("ClusterScalingSchedule", "schedule", "", map[string]string{}),
+		"object/Ingress/*":                    skipper("Ingress"),
+		"object/RouteGroup/*":                 skipper("RouteGroup"),
+		"pods/" + PrometheusMetricType:        pods(PrometheusMetricType, map[string]string{"query": `sum by (pod) (rate(requests_total{pod=~"{pod}"}[1m]))`}),
+		"pods/*":                              pods(HTTPJSONPathType, jsonPath),
+	}
+
+	for _, plugin := range factory.RegisteredPlugins() {
+		t.Run(plugin, func(t *testing.T) {
+			config, ok := configs[plugin]
+			require.True(t, ok, "no metric config for plugin %s", plugin)
+
+			c, err := factory.NewCollector(context.Background(), hpa, config, time.Minute)
+			require.NoError(t, err)
+
+			metrics, err := c.GetMetrics(context.Background())
+			require.NoError(t, err)
+			require.NotEmpty(t, metrics)
+
+			for _, metric := range metrics {
+				switch metric.Type {
+				case autoscalingv2.ExternalMetricSourceType:
+					require.Equal(t, hpa.Namespace, metric.Namespace)
+				default:
+					require.Equal(t, metric.Custom.DescribedObject.Namespace, metric.Namespace)
+					require.Equal(t, hpa.Namespace, metric.Namespace)
+				}
+			}
+		})
+	}
+}
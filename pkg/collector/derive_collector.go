@@ -0,0 +1,186 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	deriveConfigKey      = "derive"
+	resetPolicyConfigKey = "reset-policy"
+
+	// DeriveRate derives the per second rate of change of the collected
+	// values.
+	DeriveRate = "rate"
+	// DeriveDelta derives the difference between the current and the
+	// previous collected value.
+	DeriveDelta = "delta"
+
+	// ResetPolicyError fails the collection if the value decreased.
+	ResetPolicyError = "error"
+	// ResetPolicyZero emits zero if the value decreased.
+	ResetPolicyZero = "zero"
+)
+
+// sample is a previously collected value.
+type sample struct {
+	value     float64
+	timestamp time.Time
+}
+
+// DeriveCollector wraps a collector emitting the rate of change or the
+// difference of the collected values instead of the values themselves. The
+// previous values are kept in memory per metric.
+type DeriveCollector struct {
+	collector   Collector
+	derive      string
+	resetPolicy string
+	previous    map[string]sample
+	sync.Mutex
+}
+
+// NewDeriveCollector initializes a new DeriveCollector from the derive and
+// reset-policy config.
+func NewDeriveCollector(collector Collector, config map[string]string) (*DeriveCollector, error) {
+	derive := config[deriveConfigKey]
+	switch derive {
+	case DeriveRate, DeriveDelta:
+	default:
+		return nil, fmt.Errorf("invalid derive option '%s', must be one of '%s' or '%s'", derive, DeriveRate, DeriveDelta)
+	}
+
+	resetPolicy := ResetPolicyError
+	if policy, ok := config[resetPolicyConfigKey]; ok {
+		switch policy {
+		case ResetPolicyError, ResetPolicyZero:
+			resetPolicy = policy
+		default:
+			return nil, fmt.Errorf("invalid reset-policy '%s', must be one of '%s' or '%s'", policy, ResetPolicyError, ResetPolicyZero)
+		}
+	}
+
+	return &DeriveCollector{
+		collector:   collector,
+		derive:      derive,
+		resetPolicy: resetPolicy,
+		previous:    map[string]sample{},
+	}, nil
+}
+
+// GetMetrics collects the metrics of the wrapped collector and derives the
+// values from the previous collection. Metrics without a previous value are
+// skipped, it only returns an error if there is no previous value for any of
+// the metrics yet. Previous values of metrics which are no longer collected
+// are dropped.
+func (c *DeriveCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, error) {
+	metrics, err := c.collector.GetMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	previousSamples := c.previous
+	c.previous = make(map[string]sample, len(metrics))
+	for _, metric := range metrics {
+		c.previous[deriveKey(metric)] = collectedSample(metric)
+	}
+
+	derived := make([]CollectedMetric, 0, len(metrics))
+	var missing []string
+	for _, metric := range metrics {
+		key := deriveKey(metric)
+		previous, ok := previousSamples[key]
+		if !ok {
+			missing = append(missing, key)
+			continue
+		}
+
+		value, err := c.deriveValue(previous, collectedSample(metric))
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive %s of %s: %w", c.derive, key, err)
+		}
+
+		quantity := milliQuantity(value)
+		switch metric.Type {
+		case autoscalingv2.ObjectMetricSourceType, autoscalingv2.PodsMetricSourceType:
+			metric.Custom.Value = quantity
+		case autoscalingv2.ExternalMetricSourceType:
+			metric.External.Value = quantity
+		}
+		derived = append(derived, metric)
+	}
+
+	if len(derived) == 0 && len(missing) > 0 {
+		return nil, fmt.Errorf("no previous sample to derive %s of %v", c.derive, missing)
+	}
+
+	return derived, nil
+}
+
+func (c *DeriveCollector) deriveValue(previous, current sample) (float64, error) {
+	delta := current.value - previous.value
+	if delta < 0 {
+		if c.resetPolicy == ResetPolicyZero {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("value decreased from %v to %v", previous.value, current.value)
+	}
+
+	if c.derive == DeriveDelta {
+		return delta, nil
+	}
+
+	elapsed := current.timestamp.Sub(previous.timestamp).Seconds()
+	if elapsed <= 0 {
+		return 0, fmt.Errorf("no time elapsed since the previous sample at %s", previous.timestamp)
+	}
+
+	return delta / elapsed, nil
+}
+
+// Interval returns the interval of the wrapped collector.
+func (c *DeriveCollector) Interval() time.Duration {
+	return c.collector.Interval()
+}
+
+// collectedSample returns the value and timestamp of a collected metric.
+func collectedSample(metric CollectedMetric) sample {
+	switch metric.Type {
+	case autoscalingv2.ObjectMetricSourceType, autoscalingv2.PodsMetricSourceType:
+		return sample{
+			value:     float64(metric.Custom.Value.MilliValue()) / 1000,
+			timestamp: metric.Custom.Timestamp.Time,
+		}
+	default:
+		return sample{
+			value:     float64(metric.External.Value.MilliValue()) / 1000,
+			timestamp: metric.External.Timestamp.Time,
+		}
+	}
+}
+
+// deriveKey identifies a metric across collections.
+func deriveKey(metric CollectedMetric) string {
+	switch metric.Type {
+	case autoscalingv2.ObjectMetricSourceType, autoscalingv2.PodsMetricSourceType:
+		return fmt.Sprintf("%s/%s/%s/%s",
+			metric.Custom.DescribedObject.Kind,
+			metric.Custom.DescribedObject.Namespace,
+			metric.Custom.DescribedObject.Name,
+			metric.Custom.Metric.Name,
+		)
+	default:
+		return fmt.Sprintf("%s/%s{%s}",
+			metric.Namespace,
+			metric.External.MetricName,
+			labels.Set(metric.External.MetricLabels).String(),
+		)
+	}
+}
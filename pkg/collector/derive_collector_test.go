@@ -0,0 +1,159 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+)
+
+func externalSample(value int64, timestamp time.Time) []CollectedMetric {
+	return []CollectedMetric{
+		{
+			Type: autoscalingv2.ExternalMetricSourceType,
+			External: external_metrics.ExternalMetricValue{
+				MetricName:   "queue-length",
+				MetricLabels: map[string]string{"queue": "a"},
+				Value:        *resource.NewQuantity(value, resource.DecimalSI),
+				Timestamp:    metav1.NewTime(timestamp),
+			},
+		},
+	}
+}
+
+func TestDeriveCollector(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		msg      string
+		config   map[string]string
+		samples  [][]CollectedMetric
+		expected []int64 // milli values, -1 means an error is expected
+	}{
+		{
+			msg:    "rate is computed per second",
+			config: map[string]string{"derive": "rate"},
+			samples: [][]CollectedMetric{
+				externalSample(100, start),
+				externalSample(160, start.Add(30*time.Second)),
+				externalSample(160, start.Add(60*time.Second)),
+			},
+			expected: []int64{-1, 2000, 0},
+		},
+		{
+			msg:    "delta is the raw difference",
+			config: map[string]string{"derive": "delta"},
+			samples: [][]CollectedMetric{
+				externalSample(100, start),
+				externalSample(160, start.Add(30*time.Second)),
+			},
+			expected: []int64{-1, 60000},
+		},
+		{
+			msg:    "reset returns an error by default",
+			config: map[string]string{"derive": "rate"},
+			samples: [][]CollectedMetric{
+				externalSample(100, start),
+				externalSample(10, start.Add(30*time.Second)),
+				externalSample(40, start.Add(60*time.Second)),
+			},
+			expected: []int64{-1, -1, 1000},
+		},
+		{
+			msg:    "reset emits zero with the zero policy",
+			config: map[string]string{"derive": "delta", "reset-policy": "zero"},
+			samples: [][]CollectedMetric{
+				externalSample(100, start),
+				externalSample(10, start.Add(30*time.Second)),
+			},
+			expected: []int64{-1, 0},
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			var samples [][]CollectedMetric
+			inner := makeCollectorWithStub(func() ([]CollectedMetric, error) {
+				s := samples[0]
+				samples = samples[1:]
+				return s, nil
+			})
+			samples = tc.samples
+
+			collector, err := NewDeriveCollector(inner, tc.config)
+			require.NoError(t, err)
+
+			for _, expected := range tc.expected {
+				metrics, err := collector.GetMetrics(context.Background())
+				if expected < 0 {
+					require.Error(t, err)
+					continue
+				}
+				require.NoError(t, err)
+				require.Len(t, metrics, 1)
+				require.Equal(t, expected, metrics[0].External.Value.MilliValue())
+			}
+		})
+	}
+}
+
+func TestDeriveCollectorNewSeries(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	samples := [][]CollectedMetric{
+		externalSample(100, start),
+		append(externalSample(160, start.Add(30*time.Second)), customSample("a", 10)...),
+		append(externalSample(220, start.Add(60*time.Second)), customSample("a", 40)...),
+		customSample("a", 70),
+		append(externalSample(300, start.Add(120*time.Second)), customSample("a", 100)...),
+	}
+	inner := makeCollectorWithStub(func() ([]CollectedMetric, error) {
+		s := samples[0]
+		samples = samples[1:]
+		return s, nil
+	})
+
+	collector, err := NewDeriveCollector(inner, map[string]string{"derive": "delta"})
+	require.NoError(t, err)
+
+	// there is no previous value for any metric.
+	_, err = collector.GetMetrics(context.Background())
+	require.Error(t, err)
+
+	// the new custom metric is skipped.
+	metrics, err := collector.GetMetrics(context.Background())
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	require.Equal(t, autoscalingv2.ExternalMetricSourceType, metrics[0].Type)
+	require.EqualValues(t, 60000, metrics[0].External.Value.MilliValue())
+
+	metrics, err = collector.GetMetrics(context.Background())
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	require.EqualValues(t, 60000, metrics[0].External.Value.MilliValue())
+	require.EqualValues(t, 30000, metrics[1].Custom.Value.MilliValue())
+
+	// the external metric is no longer collected and its previous value
+	// is dropped.
+	metrics, err = collector.GetMetrics(context.Background())
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	require.Len(t, collector.previous, 1)
+
+	// so it starts over when it's collected again.
+	metrics, err = collector.GetMetrics(context.Background())
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	require.Equal(t, autoscalingv2.ObjectMetricSourceType, metrics[0].Type)
+	require.EqualValues(t, 30000, metrics[0].Custom.Value.MilliValue())
+}
+
+func TestNewDeriveCollectorInvalidConfig(t *testing.T) {
+	_, err := NewDeriveCollector(&FakeCollector{}, map[string]string{"derive": "avg"})
+	require.Error(t, err)
+
+	_, err = NewDeriveCollector(&FakeCollector{}, map[string]string{"derive": "rate", "reset-policy": "ignore"})
+	require.Error(t, err)
+}
@@ -0,0 +1,63 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrPermanentConfig classifies errors caused by the metric
+	// configuration of an HPA. Retrying the collection won't help until
+	// the HPA is changed.
+	ErrPermanentConfig = errors.New("permanent configuration error")
+	// ErrTransient classifies errors which are expected to go away on
+	// their own, e.g. failing requests to a metrics backend or the
+	// Kubernetes API.
+	ErrTransient = errors.New("transient error")
+	// ErrOutlier classifies collections rejected because a value was
+	// an outlier compared to the previous values. It's a transient error
+	// as well.
+	ErrOutlier = fmt.Errorf("outlier value: %w", ErrTransient)
+)
+
+// classifiedError wraps an error with one of the error classes while
+// keeping the message of the wrapped error.
+type classifiedError struct {
+	class error
+	err   error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() []error {
+	return []error{e.class, e.err}
+}
+
+// NewPermanentConfigError marks err as a permanent configuration error.
+// errors.Is(err, ErrPermanentConfig) reports true for the returned error.
+func NewPermanentConfigError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{class: ErrPermanentConfig, err: err}
+}
+
+// NewTransientError marks err as a transient error. errors.Is(err,
+// ErrTransient) reports true for the returned error.
+func NewTransientError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{class: ErrTransient, err: err}
+}
+
+// NewOutlierError marks err as an outlier error. errors.Is(err, ErrOutlier)
+// and errors.Is(err, ErrTransient) report true for the returned error.
+func NewOutlierError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{class: ErrOutlier, err: err}
+}
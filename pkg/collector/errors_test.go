@@ -0,0 +1,37 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifiedErrors(t *testing.T) {
+	cause := errors.New("cause")
+
+	permanent := NewPermanentConfigError(cause)
+	require.EqualError(t, permanent, "cause")
+	require.ErrorIs(t, permanent, ErrPermanentConfig)
+	require.ErrorIs(t, permanent, cause)
+	require.NotErrorIs(t, permanent, ErrTransient)
+
+	transient := fmt.Errorf("wrapped: %w", NewTransientError(cause))
+	require.ErrorIs(t, transient, ErrTransient)
+	require.ErrorIs(t, transient, cause)
+	require.NotErrorIs(t, transient, ErrPermanentConfig)
+
+	outlier := NewOutlierError(cause)
+	require.ErrorIs(t, outlier, ErrOutlier)
+	require.ErrorIs(t, outlier, ErrTransient)
+	require.ErrorIs(t, outlier, cause)
+	require.NotErrorIs(t, transient, ErrOutlier)
+
+	var noResult *NoResultError
+	require.ErrorAs(t, NewTransientError(&NoResultError{query: "up"}), &noResult)
+
+	require.NoError(t, NewPermanentConfigError(nil))
+	require.NoError(t, NewTransientError(nil))
+	require.NoError(t, NewOutlierError(nil))
+}
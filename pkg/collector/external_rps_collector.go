@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	log "github.com/sirupsen/logrus"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
 )
 
@@ -79,9 +80,21 @@ func (p *ExternalRPSCollectorPlugin) NewCollector(
 		}
 	}
 
+	b := config.binder()
+	excludedHosts := bindExcludedHosts(b)
+	if err := b.Err(); err != nil {
+		return nil, NewPermanentConfigError(err)
+	}
+
+	hostnames = excludeHosts(hostnames, excludedHosts)
+	if len(hostnames) == 0 {
+		log.Warnf("All hostnames of HPA %s/%s are excluded by %s", hpa.Namespace, hpa.Name, excludeHostsConfigKey)
+		return nil, NewPermanentConfigError(fmt.Errorf("all hostnames are excluded, unable to create collector"))
+	}
+
 	weight := 1.0
 	if w, ok := config.Config["weight"]; ok {
-		num, err := strconv.ParseFloat(w, 64)
+		num, err := strconv.ParseFloat(strings.TrimSpace(w), 64)
 		if err != nil {
 			return nil, fmt.Errorf("could not parse weight annotation, unable to create collector: %s", w)
 		}
@@ -96,6 +109,7 @@ func (p *ExternalRPSCollectorPlugin) NewCollector(
 			weight,
 		),
 	}
+	confCopy.ConfigSources = nil
 
 	c, err := p.promPlugin.NewCollector(ctx, hpa, &confCopy, interval)
 	if err != nil {
@@ -90,6 +90,24 @@ func TestExternalRPSPluginNewCollector(tt *testing.T) {
 			`scalar(sum(rate(a_valid_one{host=~"foo_bar_baz|foz_bax_bas"}[1m])) * 1.0000)`,
 			true,
 		},
+		{
+			"Excluded hostnames are removed from the query",
+			&MetricConfig{Config: map[string]string{"hostnames": "foo.bar.baz,www.bar.baz,foz.bax.bas", "exclude-hosts": "www.*, foz.bax.bas"}},
+			`scalar(sum(rate(a_valid_one{host=~"foo_bar_baz"}[1m])) * 1.0000)`,
+			true,
+		},
+		{
+			"All hostnames excluded",
+			&MetricConfig{Config: map[string]string{"hostnames": "foo.bar.baz,www.bar.baz", "exclude-hosts": "*.bar.baz"}},
+			"",
+			false,
+		},
+		{
+			"Invalid exclude-hosts pattern",
+			&MetricConfig{Config: map[string]string{"hostnames": "foo.bar.baz", "exclude-hosts": "[foo"}},
+			"",
+			false,
+		},
 		{
 			"Valid hostname with prom query config",
 			&MetricConfig{
@@ -279,7 +297,7 @@ func TestExternalRPSPrometheusCollectorInteraction(t *testing.T) {
 	}
 
 	factory := NewCollectorFactory()
-	promPlugin, err := NewPrometheusCollectorPlugin(nil, "http://prometheus")
+	promPlugin, err := NewPrometheusCollectorPlugin(nil, nil, "http://prometheus")
 	require.NoError(t, err)
 	factory.RegisterExternalCollector([]string{PrometheusMetricType, PrometheusMetricNameLegacy}, promPlugin)
 	hostnamePlugin, err := NewExternalRPSCollectorPlugin(promPlugin, "a_metric")
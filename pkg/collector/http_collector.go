@@ -21,10 +21,16 @@ const (
 	HTTPJsonPathAnnotationKey = "json-key"
 )
 
-type HTTPCollectorPlugin struct{}
+type HTTPCollectorPlugin struct {
+	policy *httpmetrics.EndpointPolicy
+}
 
-func NewHTTPCollectorPlugin() (*HTTPCollectorPlugin, error) {
-	return &HTTPCollectorPlugin{}, nil
+// NewHTTPCollectorPlugin initializes a new HTTPCollectorPlugin. If a policy
+// is specified, only endpoints allowed by the policy are queried.
+func NewHTTPCollectorPlugin(policy *httpmetrics.EndpointPolicy) (*HTTPCollectorPlugin, error) {
+	return &HTTPCollectorPlugin{
+		policy: policy,
+	}, nil
 }
 
 func (p *HTTPCollectorPlugin) NewCollector(_ context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
@@ -32,40 +38,52 @@ func (p *HTTPCollectorPlugin) NewCollector(_ context.Context, hpa *autoscalingv2
 		namespace: hpa.Namespace,
 	}
 	var (
-		value string
-		ok    bool
+		jsonPath       string
+		endpoint       string
+		aggregatorName string
 	)
-	if value, ok = config.Config[HTTPJsonPathAnnotationKey]; !ok {
-		return nil, fmt.Errorf("config value %s not found", HTTPJsonPathAnnotationKey)
+	b := config.binder()
+	b.RequiredString(HTTPJsonPathAnnotationKey, &jsonPath)
+	if b.RequiredString(HTTPEndpointAnnotationKey, &endpoint) {
+		var err error
+		collector.endpoint, err = url.Parse(endpoint)
+		if err != nil {
+			b.Invalid(HTTPEndpointAnnotationKey, "must be a URL")
+		}
 	}
-	jsonPath := value
-
-	if value, ok = config.Config[HTTPEndpointAnnotationKey]; !ok {
-		return nil, fmt.Errorf("config value %s not found", HTTPEndpointAnnotationKey)
+	b.Enum("aggregator", &aggregatorName, "avg", "min", "max", "sum")
+	maxResponseSize := httpmetrics.BindMaxResponseSize(b)
+	if err := finishBinding(b, hpa); err != nil {
+		return nil, err
 	}
+
 	var err error
-	collector.endpoint, err = url.Parse(value)
-	if err != nil {
-		return nil, err
+	httpClient := httpmetrics.DefaultMetricsHTTPClient()
+	if p.policy != nil {
+		err = p.policy.CheckURL(collector.endpoint)
+		if err != nil {
+			return nil, err
+		}
+		httpClient = httpmetrics.PolicyMetricsHTTPClient(p.policy, httpmetrics.DefaultRequestTimeout, httpmetrics.DefaultConnectTimeout)
 	}
 	collector.interval = interval
-	collector.metricType = config.Type
 	if config.Metric.Selector == nil || config.Metric.Selector.MatchLabels == nil {
 		return nil, fmt.Errorf("no label selector specified for metric: %s", config.Metric.Name)
 	}
 	collector.metric = config.Metric
 	var aggFunc httpmetrics.AggregatorFunc
 
-	if val, ok := config.Config["aggregator"]; ok {
-		aggFunc, err = httpmetrics.ParseAggregator(val)
+	if aggregatorName != "" {
+		aggFunc, err = httpmetrics.ParseAggregator(aggregatorName)
 		if err != nil {
 			return nil, err
 		}
 	}
-	jsonPathGetter, err := httpmetrics.NewJSONPathMetricsGetter(httpmetrics.DefaultMetricsHTTPClient(), aggFunc, jsonPath)
+	jsonPathGetter, err := httpmetrics.NewJSONPathMetricsGetter(httpClient, aggFunc, jsonPath)
 	if err != nil {
 		return nil, err
 	}
+	jsonPathGetter.SetMaxResponseSize(maxResponseSize)
 	collector.metricsGetter = jsonPathGetter
 	return collector, nil
 }
@@ -74,7 +92,6 @@ type HTTPCollector struct {
 	endpoint      *url.URL
 	interval      time.Duration
 	namespace     string
-	metricType    autoscalingv2.MetricSourceType
 	metricsGetter *httpmetrics.JSONPathMetricsGetter
 	metric        autoscalingv2.MetricIdentifier
 }
@@ -85,18 +102,14 @@ func (c *HTTPCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, erro
 		return nil, err
 	}
 
-	value := CollectedMetric{
-		Namespace: c.namespace,
-		Type:      c.metricType,
-		External: external_metrics.ExternalMetricValue{
-			MetricName:   c.metric.Name,
-			MetricLabels: c.metric.Selector.MatchLabels,
-			Timestamp: metav1.Time{
-				Time: time.Now(),
-			},
-			Value: *resource.NewMilliQuantity(int64(metric*1000), resource.DecimalSI),
+	value := NewExternalMetric(c.namespace, external_metrics.ExternalMetricValue{
+		MetricName:   c.metric.Name,
+		MetricLabels: c.metric.Selector.MatchLabels,
+		Timestamp: metav1.Time{
+			Time: time.Now(),
 		},
-	}
+		Value: *resource.NewMilliQuantity(int64(metric*1000), resource.DecimalSI),
+	})
 	return []CollectedMetric{value}, nil
 }
 
@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector/httpmetrics"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -59,7 +61,7 @@ func TestHTTPCollector(t *testing.T) {
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			testServer := makeHTTPTestServer(t, tc.values)
-			plugin, err := NewHTTPCollectorPlugin()
+			plugin, err := NewHTTPCollectorPlugin(nil)
 			require.NoError(t, err)
 			testConfig := makeTestHTTPCollectorConfig(testServer, tc.aggregator)
 			hpa := &autoscalingv2.HorizontalPodAutoscaler{
@@ -99,3 +101,87 @@ func makeTestHTTPCollectorConfig(endpoint, aggregator string) *MetricConfig {
 	}
 	return config
 }
+
+func TestHTTPCollectorEndpointPolicy(t *testing.T) {
+	testServer := makeHTTPTestServer(t, []int64{3})
+
+	for _, tc := range []struct {
+		name           string
+		endpoint       string
+		allowed        []string
+		denied         []string
+		schemes        []string
+		newCollectorOK bool
+		collectOK      bool
+	}{
+		{
+			name:           "allowed by default policy",
+			endpoint:       testServer,
+			denied:         httpmetrics.DefaultDeniedCIDRs,
+			schemes:        httpmetrics.DefaultAllowedSchemes,
+			newCollectorOK: true,
+			collectOK:      true,
+		},
+		{
+			name:           "denied IP literal",
+			endpoint:       testServer,
+			denied:         []string{"127.0.0.0/8"},
+			schemes:        httpmetrics.DefaultAllowedSchemes,
+			newCollectorOK: false,
+		},
+		{
+			name:           "denied resolved hostname",
+			endpoint:       strings.Replace(testServer, "127.0.0.1", "localhost", 1),
+			denied:         []string{"127.0.0.0/8", "::1/128"},
+			schemes:        httpmetrics.DefaultAllowedSchemes,
+			newCollectorOK: true,
+			collectOK:      false,
+		},
+		{
+			name:           "not in allowed networks",
+			endpoint:       testServer,
+			allowed:        []string{"10.0.0.0/8"},
+			schemes:        httpmetrics.DefaultAllowedSchemes,
+			newCollectorOK: false,
+		},
+		{
+			name:           "in allowed networks",
+			endpoint:       testServer,
+			allowed:        []string{"127.0.0.0/8"},
+			schemes:        httpmetrics.DefaultAllowedSchemes,
+			newCollectorOK: true,
+			collectOK:      true,
+		},
+		{
+			name:           "scheme not allowed",
+			endpoint:       strings.Replace(testServer, "http://", "file://", 1),
+			schemes:        []string{"https"},
+			newCollectorOK: false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			policy, err := httpmetrics.NewEndpointPolicy(tc.allowed, tc.denied, tc.schemes)
+			require.NoError(t, err)
+			plugin, err := NewHTTPCollectorPlugin(policy)
+			require.NoError(t, err)
+			hpa := &autoscalingv2.HorizontalPodAutoscaler{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "default",
+				},
+			}
+			collector, err := plugin.NewCollector(context.Background(), hpa, makeTestHTTPCollectorConfig(tc.endpoint, "sum"), testInterval)
+			if !tc.newCollectorOK {
+				require.ErrorIs(t, err, &httpmetrics.EndpointNotAllowedError{})
+				return
+			}
+			require.NoError(t, err)
+
+			_, err = collector.GetMetrics(context.Background())
+			if !tc.collectOK {
+				require.ErrorIs(t, err, &httpmetrics.EndpointNotAllowedError{})
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
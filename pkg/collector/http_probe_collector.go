@@ -0,0 +1,222 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector/httpmetrics"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/httperrors"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+)
+
+const (
+	// HTTPProbeMetricType defines the metric type for metrics based on
+	// probing an HTTP endpoint.
+	HTTPProbeMetricType         = "http-probe"
+	httpProbeURLKey             = "url"
+	httpProbeMethodKey          = "method"
+	httpProbeMeasurementKey     = "measurement"
+	httpProbeSamplesKey         = "samples"
+	httpProbeMeasurementLatency = "latency-ms"
+	httpProbeMeasurementStatus  = "status-ok-ratio"
+	defaultHTTPProbeSamples     = 3
+	maxHTTPProbeSamples         = 10
+	defaultHTTPProbeTimeout     = 5 * time.Second
+)
+
+// HTTPProbeCollectorPlugin defines a plugin for creating collectors that
+// probe an HTTP endpoint. All collectors share a single client, so the
+// connections to the endpoints are reused.
+type HTTPProbeCollectorPlugin struct {
+	policy *httpmetrics.EndpointPolicy
+	client *http.Client
+}
+
+// NewHTTPProbeCollectorPlugin initializes a new HTTPProbeCollectorPlugin. If
+// a policy is specified, only endpoints allowed by the policy are probed.
+func NewHTTPProbeCollectorPlugin(policy *httpmetrics.EndpointPolicy) *HTTPProbeCollectorPlugin {
+	// the probes are limited by the timeout of the metric instead of the
+	// timeout of the client.
+	client := httpmetrics.CustomMetricsHTTPClient(0, httpmetrics.DefaultConnectTimeout)
+	if policy != nil {
+		client = httpmetrics.PolicyMetricsHTTPClient(policy, 0, httpmetrics.DefaultConnectTimeout)
+	}
+
+	return &HTTPProbeCollectorPlugin{
+		policy: policy,
+		client: client,
+	}
+}
+
+// NewCollector initializes a new HTTP probe collector from the specified
+// HPA.
+func (p *HTTPProbeCollectorPlugin) NewCollector(_ context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
+	if config.Metric.Selector == nil {
+		return nil, NewPermanentConfigError(fmt.Errorf("selector for http-probe is not specified"))
+	}
+
+	c := &HTTPProbeCollector{
+		client:    p.client,
+		method:    http.MethodGet,
+		samples:   defaultHTTPProbeSamples,
+		timeout:   defaultHTTPProbeTimeout,
+		interval:  interval,
+		metric:    config.Metric,
+		namespace: hpa.Namespace,
+	}
+
+	var endpoint string
+	b := config.binder()
+	if b.RequiredString(httpProbeURLKey, &endpoint) {
+		var err error
+		c.url, err = url.Parse(endpoint)
+		if err != nil || c.url.Host == "" {
+			b.Invalid(httpProbeURLKey, "must be an absolute URL")
+		}
+	}
+	b.Enum(httpProbeMethodKey, &c.method, http.MethodGet, http.MethodHead)
+	if b.Has(httpProbeMeasurementKey) {
+		b.Enum(httpProbeMeasurementKey, &c.measurement, httpProbeMeasurementLatency, httpProbeMeasurementStatus)
+	} else {
+		b.Missing(httpProbeMeasurementKey)
+	}
+	b.Int(httpProbeSamplesKey, &c.samples, 1, maxHTTPProbeSamples)
+	b.PositiveDuration(requestTimeoutKey, &c.timeout)
+	if err := finishBinding(b, hpa); err != nil {
+		return nil, err
+	}
+
+	if p.policy != nil {
+		if err := p.policy.CheckURL(c.url); err != nil {
+			return nil, NewPermanentConfigError(err)
+		}
+	}
+
+	return c, nil
+}
+
+// HTTPProbeCollector defines a collector that probes an HTTP endpoint and
+// returns the median latency or the ratio of successful probes.
+type HTTPProbeCollector struct {
+	client      *http.Client
+	url         *url.URL
+	method      string
+	measurement string
+	samples     int
+	timeout     time.Duration
+	interval    time.Duration
+	metric      autoscalingv2.MetricIdentifier
+	namespace   string
+}
+
+// probeResult is the result of a single probe.
+type probeResult struct {
+	latency time.Duration
+	ok      bool
+	err     error
+}
+
+// GetMetrics probes the endpoint and returns the measurement as external
+// metric.
+func (c *HTTPProbeCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, error) {
+	results := make([]probeResult, 0, c.samples)
+	for i := 0; i < c.samples; i++ {
+		result := c.probe(ctx)
+		if result.err != nil && errors.Is(result.err, &httpmetrics.EndpointNotAllowedError{}) {
+			return nil, result.err
+		}
+		if ctx.Err() != nil {
+			return nil, NewTransientError(ctx.Err())
+		}
+		results = append(results, result)
+	}
+
+	var value float64
+	switch c.measurement {
+	case httpProbeMeasurementLatency:
+		latencies := make([]float64, 0, len(results))
+		var err error
+		for _, result := range results {
+			if result.err != nil {
+				err = result.err
+				continue
+			}
+			latencies = append(latencies, float64(result.latency)/float64(time.Millisecond))
+		}
+		if len(latencies) == 0 {
+			return nil, NewTransientError(fmt.Errorf("all %d probes of %s failed: %w", len(results), c.url, err))
+		}
+		value = median(latencies)
+	case httpProbeMeasurementStatus:
+		ok := 0
+		for _, result := range results {
+			if result.ok {
+				ok++
+			}
+		}
+		value = float64(ok) / float64(len(results))
+	}
+
+	metricValue := NewExternalMetric(c.namespace, external_metrics.ExternalMetricValue{
+		MetricName:   c.metric.Name,
+		MetricLabels: c.metric.Selector.MatchLabels,
+		Timestamp:    metav1.Now(),
+		Value:        *resource.NewMilliQuantity(int64(value*1000), resource.DecimalSI),
+	})
+
+	return []CollectedMetric{metricValue}, nil
+}
+
+// probe requests the endpoint once. The latency includes reading the
+// response body. A probe is ok if the endpoint responds with a 2xx status.
+func (c *HTTPProbeCollector) probe(ctx context.Context) probeResult {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, c.method, c.url.String(), nil)
+	if err != nil {
+		return probeResult{err: err}
+	}
+
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return probeResult{err: err}
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(io.Discard, httperrors.LimitReader(resp.Body, httpmetrics.DefaultMaxResponseSize))
+	if err != nil {
+		return probeResult{err: err}
+	}
+
+	return probeResult{
+		latency: time.Since(start),
+		ok:      resp.StatusCode >= 200 && resp.StatusCode < 300,
+	}
+}
+
+// median returns the median of the values.
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// Interval returns the interval at which the collector should run.
+func (c *HTTPProbeCollector) Interval() time.Duration {
+	return c.interval
+}
@@ -0,0 +1,203 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector/httpmetrics"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newHTTPProbeMetricConfig(config map[string]string) *MetricConfig {
+	return &MetricConfig{
+		MetricTypeName: MetricTypeName{
+			Type:   autoscalingv2.ExternalMetricSourceType,
+			Metric: newMetricIdentifier("upstream-latency", HTTPProbeMetricType),
+		},
+		Config: config,
+	}
+}
+
+// newHTTPProbeTestServer returns a server responding to the requests with
+// the delays and statuses returned by respond for the nth request.
+func newHTTPProbeTestServer(t *testing.T, respond func(n int64) (time.Duration, int)) *httptest.Server {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delay, status := respond(requests.Add(1))
+		time.Sleep(delay)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHTTPProbeCollector(t *testing.T) {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "egress"}}
+
+	for _, tc := range []struct {
+		msg         string
+		respond     func(n int64) (time.Duration, int)
+		config      map[string]string
+		minExpected float64
+		maxExpected float64
+		err         bool
+	}{
+		{
+			msg: "median latency",
+			respond: func(n int64) (time.Duration, int) {
+				// a single slow probe doesn't affect the median.
+				if n == 2 {
+					return 500 * time.Millisecond, http.StatusOK
+				}
+				return 50 * time.Millisecond, http.StatusOK
+			},
+			config:      map[string]string{"measurement": "latency-ms"},
+			minExpected: 50,
+			maxExpected: 400,
+		},
+		{
+			msg: "latency excludes timed out probes",
+			respond: func(n int64) (time.Duration, int) {
+				if n == 1 {
+					return 500 * time.Millisecond, http.StatusOK
+				}
+				return 20 * time.Millisecond, http.StatusOK
+			},
+			config:      map[string]string{"measurement": "latency-ms", "timeout": "200ms", "samples": "2"},
+			minExpected: 20,
+			maxExpected: 200,
+		},
+		{
+			msg: "all probes timed out",
+			respond: func(n int64) (time.Duration, int) {
+				return 200 * time.Millisecond, http.StatusOK
+			},
+			config: map[string]string{"measurement": "latency-ms", "timeout": "50ms", "samples": "2"},
+			err:    true,
+		},
+		{
+			msg: "status ok ratio",
+			respond: func(n int64) (time.Duration, int) {
+				if n%2 == 0 {
+					return 0, http.StatusBadGateway
+				}
+				return 0, http.StatusOK
+			},
+			config:      map[string]string{"measurement": "status-ok-ratio", "samples": "4"},
+			minExpected: 0.5,
+			maxExpected: 0.5,
+		},
+		{
+			msg: "timed out probes are not ok",
+			respond: func(n int64) (time.Duration, int) {
+				if n == 1 {
+					return 200 * time.Millisecond, http.StatusOK
+				}
+				return 0, http.StatusNoContent
+			},
+			config:      map[string]string{"measurement": "status-ok-ratio", "timeout": "50ms", "method": "HEAD", "samples": "4"},
+			minExpected: 0.75,
+			maxExpected: 0.75,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			server := newHTTPProbeTestServer(t, tc.respond)
+			tc.config["url"] = server.URL + "/health"
+
+			plugin := NewHTTPProbeCollectorPlugin(nil)
+			c, err := plugin.NewCollector(context.Background(), hpa, newHTTPProbeMetricConfig(tc.config), time.Minute)
+			require.NoError(t, err)
+
+			metrics, err := c.GetMetrics(context.Background())
+			if tc.err {
+				require.ErrorIs(t, err, ErrTransient)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, metrics, 1)
+			require.Equal(t, "upstream-latency", metrics[0].External.MetricName)
+			value := float64(metrics[0].External.Value.MilliValue()) / 1000
+			require.GreaterOrEqual(t, value, tc.minExpected)
+			require.LessOrEqual(t, value, tc.maxExpected)
+		})
+	}
+}
+
+func TestHTTPProbeCollectorInvalidConfig(t *testing.T) {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "egress"}}
+
+	for _, tc := range []struct {
+		msg    string
+		config map[string]string
+	}{
+		{msg: "missing url", config: map[string]string{"measurement": "latency-ms"}},
+		{msg: "relative url", config: map[string]string{"url": "/health", "measurement": "latency-ms"}},
+		{msg: "missing measurement", config: map[string]string{"url": "http://upstream/health"}},
+		{msg: "unknown measurement", config: map[string]string{"url": "http://upstream/health", "measurement": "throughput"}},
+		{msg: "unsupported method", config: map[string]string{"url": "http://upstream/health", "measurement": "latency-ms", "method": "POST"}},
+		{msg: "too many samples", config: map[string]string{"url": "http://upstream/health", "measurement": "latency-ms", "samples": "100"}},
+		{msg: "invalid timeout", config: map[string]string{"url": "http://upstream/health", "measurement": "latency-ms", "timeout": "0s"}},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			plugin := NewHTTPProbeCollectorPlugin(nil)
+			_, err := plugin.NewCollector(context.Background(), hpa, newHTTPProbeMetricConfig(tc.config), time.Minute)
+			require.ErrorIs(t, err, ErrPermanentConfig)
+		})
+	}
+}
+
+func TestHTTPProbeCollectorEndpointPolicy(t *testing.T) {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "egress"}}
+	server := newHTTPProbeTestServer(t, func(int64) (time.Duration, int) { return 0, http.StatusOK })
+
+	policy, err := httpmetrics.NewEndpointPolicy(nil, []string{"127.0.0.0/8", "::1/128"}, httpmetrics.DefaultAllowedSchemes)
+	require.NoError(t, err)
+	plugin := NewHTTPProbeCollectorPlugin(policy)
+
+	// IP literals are rejected when the collector is created.
+	_, err = plugin.NewCollector(context.Background(), hpa, newHTTPProbeMetricConfig(map[string]string{
+		"url":         server.URL,
+		"measurement": "status-ok-ratio",
+	}), time.Minute)
+	require.ErrorIs(t, err, &httpmetrics.EndpointNotAllowedError{})
+	require.ErrorIs(t, err, ErrPermanentConfig)
+
+	// resolved hostnames are rejected when probing instead of counting
+	// as failed probes.
+	c, err := plugin.NewCollector(context.Background(), hpa, newHTTPProbeMetricConfig(map[string]string{
+		"url":         strings.Replace(server.URL, "127.0.0.1", "localhost", 1),
+		"measurement": "status-ok-ratio",
+	}), time.Minute)
+	require.NoError(t, err)
+	_, err = c.GetMetrics(context.Background())
+	require.ErrorIs(t, err, &httpmetrics.EndpointNotAllowedError{})
+}
+
+func TestHTTPProbeCollectorSharesClient(t *testing.T) {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "egress"}}
+	plugin := NewHTTPProbeCollectorPlugin(nil)
+
+	var clients []*http.Client
+	for _, endpoint := range []string{"http://upstream-a/health", "http://upstream-b/health"} {
+		c, err := plugin.NewCollector(context.Background(), hpa, newHTTPProbeMetricConfig(map[string]string{
+			"url":         endpoint,
+			"measurement": "latency-ms",
+		}), time.Minute)
+		require.NoError(t, err)
+		clients = append(clients, c.(*HTTPProbeCollector).client)
+	}
+	require.Same(t, clients[0], clients[1])
+}
+
+func TestMedian(t *testing.T) {
+	require.Equal(t, 2.0, median([]float64{3, 1, 2}))
+	require.Equal(t, 2.5, median([]float64{4, 1, 3, 2}))
+	require.Equal(t, 7.0, median([]float64{7}))
+}
@@ -0,0 +1,174 @@
+package httpmetrics
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// DefaultDeniedCIDRs are the networks denied by default for user defined
+// metric endpoints. They cover the link-local range including the cloud
+// provider metadata endpoints.
+var DefaultDeniedCIDRs = []string{
+	"169.254.0.0/16",
+	"fd00:ec2::254/128",
+}
+
+// DefaultAllowedSchemes are the URL schemes allowed by default for user
+// defined metric endpoints.
+var DefaultAllowedSchemes = []string{"http", "https"}
+
+// EndpointNotAllowedError is returned when a metrics endpoint is rejected by
+// an EndpointPolicy.
+type EndpointNotAllowedError struct {
+	Endpoint string
+	Reason   string
+}
+
+func (e *EndpointNotAllowedError) Error() string {
+	return fmt.Sprintf("endpoint %s is not allowed: %s", e.Endpoint, e.Reason)
+}
+
+func (e *EndpointNotAllowedError) Is(target error) bool {
+	_, ok := target.(*EndpointNotAllowedError)
+	return ok
+}
+
+// EndpointPolicy restricts which endpoints can be queried for metrics. An IP
+// is allowed if it's not part of any denied network and, in case allowed
+// networks are defined, part of at least one of them.
+type EndpointPolicy struct {
+	allowedCIDRs   []*net.IPNet
+	deniedCIDRs    []*net.IPNet
+	allowedSchemes map[string]struct{}
+}
+
+// NewEndpointPolicy initializes a new EndpointPolicy from lists of CIDRs and
+// URL schemes.
+func NewEndpointPolicy(allowedCIDRs, deniedCIDRs, allowedSchemes []string) (*EndpointPolicy, error) {
+	allowed, err := parseCIDRs(allowedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	denied, err := parseCIDRs(deniedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+
+	schemes := make(map[string]struct{}, len(allowedSchemes))
+	for _, scheme := range allowedSchemes {
+		schemes[scheme] = struct{}{}
+	}
+
+	return &EndpointPolicy{
+		allowedCIDRs:   allowed,
+		deniedCIDRs:    denied,
+		allowedSchemes: schemes,
+	}, nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CIDR %s: %w", cidr, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// CheckURL checks the scheme of the URL and, if the host is an IP address,
+// the IP against the policy. Hostnames are validated once they are resolved
+// when dialing.
+func (p *EndpointPolicy) CheckURL(endpoint *url.URL) error {
+	if _, ok := p.allowedSchemes[endpoint.Scheme]; !ok {
+		return &EndpointNotAllowedError{
+			Endpoint: endpoint.String(),
+			Reason:   fmt.Sprintf("scheme '%s' is not allowed", endpoint.Scheme),
+		}
+	}
+
+	if ip := net.ParseIP(endpoint.Hostname()); ip != nil {
+		return p.CheckIP(ip)
+	}
+
+	return nil
+}
+
+// CheckIP checks an IP against the allowed and denied networks of the
+// policy.
+func (p *EndpointPolicy) CheckIP(ip net.IP) error {
+	for _, ipNet := range p.deniedCIDRs {
+		if ipNet.Contains(ip) {
+			return &EndpointNotAllowedError{
+				Endpoint: ip.String(),
+				Reason:   fmt.Sprintf("IP is part of denied network %s", ipNet),
+			}
+		}
+	}
+
+	if len(p.allowedCIDRs) == 0 {
+		return nil
+	}
+
+	for _, ipNet := range p.allowedCIDRs {
+		if ipNet.Contains(ip) {
+			return nil
+		}
+	}
+
+	return &EndpointNotAllowedError{
+		Endpoint: ip.String(),
+		Reason:   "IP is not part of any allowed network",
+	}
+}
+
+// control validates the resolved address right before a connection is
+// established. This way the check can't be circumvented by DNS names
+// resolving to denied IPs.
+func (p *EndpointPolicy) control(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return &EndpointNotAllowedError{
+			Endpoint: address,
+			Reason:   "failed to parse resolved IP",
+		}
+	}
+
+	return p.CheckIP(ip)
+}
+
+// PolicyMetricsHTTPClient returns an HTTP client which only connects to
+// endpoints allowed by the policy.
+func PolicyMetricsHTTPClient(policy *EndpointPolicy, requestTimeout time.Duration, connectTimeout time.Duration) *http.Client {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: connectTimeout,
+				Control: policy.control,
+			}).DialContext,
+			MaxIdleConns:          50,
+			IdleConnTimeout:       90 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+		Timeout: requestTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			return policy.CheckURL(req.URL)
+		},
+	}
+	return client
+}
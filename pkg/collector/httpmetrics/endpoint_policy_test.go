@@ -0,0 +1,69 @@
+package httpmetrics
+
+import (
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndpointPolicyCheckIP(t *testing.T) {
+	policy, err := NewEndpointPolicy([]string{"10.0.0.0/8", "169.254.0.0/16"}, []string{"10.2.0.0/16", "169.254.169.254/32"}, DefaultAllowedSchemes)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		ip      string
+		allowed bool
+	}{
+		{ip: "10.1.2.3", allowed: true},
+		{ip: "10.2.0.1", allowed: false},
+		{ip: "169.254.1.1", allowed: true},
+		{ip: "169.254.169.254", allowed: false},
+		{ip: "192.168.1.1", allowed: false},
+	} {
+		t.Run(tc.ip, func(t *testing.T) {
+			err := policy.CheckIP(net.ParseIP(tc.ip))
+			if tc.allowed {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, &EndpointNotAllowedError{})
+			}
+		})
+	}
+}
+
+func TestEndpointPolicyDefaults(t *testing.T) {
+	policy, err := NewEndpointPolicy(nil, DefaultDeniedCIDRs, DefaultAllowedSchemes)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		endpoint string
+		allowed  bool
+	}{
+		{endpoint: "http://metric-source.app-namespace:8080/metrics", allowed: true},
+		{endpoint: "https://10.3.0.1/metrics", allowed: true},
+		{endpoint: "http://169.254.169.254/latest/meta-data", allowed: false},
+		{endpoint: "http://[fd00:ec2::254]/latest/meta-data", allowed: false},
+		{endpoint: "file:///etc/passwd", allowed: false},
+		{endpoint: "gopher://10.3.0.1/", allowed: false},
+	} {
+		t.Run(tc.endpoint, func(t *testing.T) {
+			u, err := url.Parse(tc.endpoint)
+			require.NoError(t, err)
+			err = policy.CheckURL(u)
+			if tc.allowed {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, &EndpointNotAllowedError{})
+			}
+		})
+	}
+}
+
+func TestNewEndpointPolicyInvalidCIDR(t *testing.T) {
+	_, err := NewEndpointPolicy([]string{"10.0.0.0"}, nil, nil)
+	require.Error(t, err)
+	_, err = NewEndpointPolicy(nil, []string{"invalid"}, nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,261 @@
+package httpmetrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/annotations"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/httperrors"
+	v1 "k8s.io/api/core/v1"
+)
+
+const defaultExpositionPath = "/metrics"
+
+// counterSample is the value of a counter of a pod at the time of a scrape.
+type counterSample struct {
+	value     float64
+	timestamp time.Time
+}
+
+// PodMetricsExpositionGetter is a metrics getter which scrapes the metrics
+// endpoint of a pod exposing the Prometheus text format and returns the sum
+// of the series of a metric matching the configured labels. For counters it
+// can return the per-second rate since the previous scrape of the pod
+// instead.
+type PodMetricsExpositionGetter struct {
+	scheme     string
+	path       string
+	rawQuery   string
+	port       int
+	metricName string
+	labels     map[string]string
+	rateOver   time.Duration
+	client     *http.Client
+	now        func() time.Time
+	// maxResponseSize is the maximum number of bytes read from the
+	// metrics endpoint.
+	maxResponseSize int64
+
+	samplesMu sync.Mutex
+	samples   map[string]counterSample
+	lastPrune time.Time
+}
+
+// NewPodMetricsExpositionGetter initializes a new
+// PodMetricsExpositionGetter from the metric config.
+func NewPodMetricsExpositionGetter(config map[string]string) (*PodMetricsExpositionGetter, error) {
+	getter := &PodMetricsExpositionGetter{
+		path:    defaultExpositionPath,
+		labels:  map[string]string{},
+		samples: map[string]counterSample{},
+		now:     time.Now,
+	}
+
+	getter.metricName = config["metric-name"]
+	if getter.metricName == "" {
+		return nil, fmt.Errorf("metric-name must be specified")
+	}
+
+	if v, ok := config["match-labels"]; ok {
+		for _, matcher := range strings.Split(v, ",") {
+			matcher = strings.TrimSpace(matcher)
+			if matcher == "" {
+				continue
+			}
+			name, value, ok := strings.Cut(matcher, "=")
+			if !ok || strings.TrimSpace(name) == "" {
+				return nil, fmt.Errorf("invalid label matcher '%s', expected <label>=<value>", matcher)
+			}
+			getter.labels[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+
+	if v, ok := config["rate-over"]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("Invalid rate-over config value: %s", v)
+		}
+		getter.rateOver = d
+	}
+
+	if v, ok := config["scheme"]; ok {
+		getter.scheme = v
+	}
+
+	if v, ok := config["path"]; ok {
+		getter.path = v
+	}
+
+	if v, ok := config["raw-query"]; ok {
+		getter.rawQuery = v
+	}
+
+	v, ok := config["port"]
+	if !ok {
+		return nil, fmt.Errorf("port must be specified")
+	}
+	port, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, err
+	}
+	getter.port = port
+
+	requestTimeout, connectTimeout, err := parseTimeouts(config)
+	if err != nil {
+		return nil, err
+	}
+	getter.client = CustomMetricsHTTPClient(requestTimeout, connectTimeout)
+
+	b := annotations.NewConfigBinder(config, nil)
+	getter.maxResponseSize = BindMaxResponseSize(b)
+	if err := b.Err(); err != nil {
+		return nil, err
+	}
+
+	return getter, nil
+}
+
+// GetMetric scrapes the metrics endpoint of the pod and returns the value of
+// the metric.
+func (g *PodMetricsExpositionGetter) GetMetric(pod *v1.Pod) (float64, error) {
+	if pod.Status.PodIP == "" {
+		return 0, fmt.Errorf("pod %s/%s does not have a pod IP", pod.Namespace, pod.Name)
+	}
+
+	metricsURL := buildPodMetricsURL(pod.Status.PodIP, g.scheme, g.port, g.path, g.rawQuery)
+	request, err := http.NewRequest(http.MethodGet, metricsURL.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Accept", string(expfmt.NewFormat(expfmt.TypeTextPlain)))
+
+	resp, err := g.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("metrics endpoint %s returned status %d", metricsURL.String(), resp.StatusCode)
+	}
+
+	value, metricType, err := ExpositionMetric(httperrors.LimitReader(resp.Body, g.maxResponseSize), g.metricName, g.labels)
+	if err != nil {
+		return 0, err
+	}
+
+	if g.rateOver == 0 {
+		return value, nil
+	}
+
+	if metricType != dto.MetricType_COUNTER {
+		return 0, fmt.Errorf("rate-over is only supported for counters, metric %s is a %s", g.metricName, strings.ToLower(metricType.String()))
+	}
+
+	return g.rate(string(pod.UID)+"/"+pod.Namespace+"/"+pod.Name, value, g.now())
+}
+
+// rate returns the per-second rate of the counter since the previous sample
+// of the pod. It fails if there is no previous sample within the rate-over
+// window, e.g. on the first scrape of a pod. Counter resets are handled like
+// Prometheus does.
+func (g *PodMetricsExpositionGetter) rate(key string, value float64, now time.Time) (float64, error) {
+	g.samplesMu.Lock()
+	defer g.samplesMu.Unlock()
+
+	// forget the samples of pods which are gone.
+	if now.Sub(g.lastPrune) > g.rateOver {
+		for k, sample := range g.samples {
+			if now.Sub(sample.timestamp) > g.rateOver {
+				delete(g.samples, k)
+			}
+		}
+		g.lastPrune = now
+	}
+
+	previous, ok := g.samples[key]
+	g.samples[key] = counterSample{value: value, timestamp: now}
+
+	elapsed := now.Sub(previous.timestamp)
+	if !ok || elapsed > g.rateOver || elapsed <= 0 {
+		return 0, fmt.Errorf("no previous sample of metric %s within %s, the rate is available after the next scrape", g.metricName, g.rateOver)
+	}
+
+	increase := value - previous.value
+	if increase < 0 {
+		// the counter was reset.
+		increase = value
+	}
+
+	return increase / elapsed.Seconds(), nil
+}
+
+// ExpositionMetric parses metrics in the Prometheus text format and returns
+// the sum of the series of the metric matching all labels and the type of
+// the metric. Gauges, counters and untyped metrics are supported.
+func ExpositionMetric(in io.Reader, metricName string, labels map[string]string) (float64, dto.MetricType, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(in)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse metrics: %w", err)
+	}
+
+	family, ok := families[metricName]
+	if !ok {
+		return 0, 0, fmt.Errorf("metric %s not found", metricName)
+	}
+
+	var sum float64
+	matched := false
+	for _, metric := range family.GetMetric() {
+		if !matchesLabels(metric, labels) {
+			continue
+		}
+
+		switch family.GetType() {
+		case dto.MetricType_GAUGE:
+			sum += metric.GetGauge().GetValue()
+		case dto.MetricType_COUNTER:
+			sum += metric.GetCounter().GetValue()
+		case dto.MetricType_UNTYPED:
+			sum += metric.GetUntyped().GetValue()
+		default:
+			return 0, 0, fmt.Errorf("metric %s has unsupported type %s", metricName, strings.ToLower(family.GetType().String()))
+		}
+		matched = true
+	}
+
+	if !matched {
+		return 0, 0, fmt.Errorf("no series of metric %s matches the labels %v", metricName, labels)
+	}
+
+	return sum, family.GetType(), nil
+}
+
+// matchesLabels returns true if the metric has all labels with the same
+// values.
+func matchesLabels(metric *dto.Metric, labels map[string]string) bool {
+	for name, value := range labels {
+		found := false
+		for _, pair := range metric.GetLabel() {
+			if pair.GetName() == name {
+				found = pair.GetValue() == value
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,272 @@
+package httpmetrics
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/httperrors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testExposition = `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="GET",code="200"} 1027
+http_requests_total{method="GET",code="500"} 3
+http_requests_total{method="POST",code="200"} 20
+# HELP queue_length The number of queued jobs.
+# TYPE queue_length gauge
+queue_length{queue="high"} 5
+queue_length{queue="low"} 12.5
+# HELP request_duration_seconds The request durations.
+# TYPE request_duration_seconds summary
+request_duration_seconds{quantile="0.5"} 0.05
+request_duration_seconds_sum 12.5
+request_duration_seconds_count 250
+untyped_metric 7
+`
+
+func TestExpositionMetric(t *testing.T) {
+	for _, tc := range []struct {
+		msg          string
+		metricName   string
+		labels       map[string]string
+		expected     float64
+		expectedType dto.MetricType
+		expectError  bool
+	}{
+		{
+			msg:          "gauge series are summed up",
+			metricName:   "queue_length",
+			expected:     17.5,
+			expectedType: dto.MetricType_GAUGE,
+		},
+		{
+			msg:          "gauge series matching the labels",
+			metricName:   "queue_length",
+			labels:       map[string]string{"queue": "low"},
+			expected:     12.5,
+			expectedType: dto.MetricType_GAUGE,
+		},
+		{
+			msg:          "counter series matching the labels",
+			metricName:   "http_requests_total",
+			labels:       map[string]string{"method": "GET"},
+			expected:     1030,
+			expectedType: dto.MetricType_COUNTER,
+		},
+		{
+			msg:          "counter series matching multiple labels",
+			metricName:   "http_requests_total",
+			labels:       map[string]string{"method": "GET", "code": "200"},
+			expected:     1027,
+			expectedType: dto.MetricType_COUNTER,
+		},
+		{
+			msg:          "untyped metric",
+			metricName:   "untyped_metric",
+			expected:     7,
+			expectedType: dto.MetricType_UNTYPED,
+		},
+		{
+			msg:         "missing metric",
+			metricName:  "missing_metric",
+			expectError: true,
+		},
+		{
+			msg:         "no series matching the labels",
+			metricName:  "queue_length",
+			labels:      map[string]string{"queue": "medium"},
+			expectError: true,
+		},
+		{
+			msg:         "unknown label",
+			metricName:  "queue_length",
+			labels:      map[string]string{"priority": "high"},
+			expectError: true,
+		},
+		{
+			msg:         "unsupported summary",
+			metricName:  "request_duration_seconds",
+			expectError: true,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			value, metricType, err := ExpositionMetric(strings.NewReader(testExposition), tc.metricName, tc.labels)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, value)
+			require.Equal(t, tc.expectedType, metricType)
+		})
+	}
+
+	_, _, err := ExpositionMetric(strings.NewReader("invalid metric line"), "queue_length", nil)
+	require.Error(t, err)
+}
+
+func TestNewPodMetricsExpositionGetter(t *testing.T) {
+	getter, err := NewPodMetricsExpositionGetter(map[string]string{
+		"metric-name":  "http_requests_total",
+		"match-labels": "method=GET, code=200",
+		"rate-over":    "2m",
+		"port":         "9090",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "http_requests_total", getter.metricName)
+	require.Equal(t, map[string]string{"method": "GET", "code": "200"}, getter.labels)
+	require.Equal(t, 2*time.Minute, getter.rateOver)
+	require.Equal(t, "/metrics", getter.path)
+	require.Equal(t, 9090, getter.port)
+
+	for _, config := range []map[string]string{
+		{"port": "9090"},
+		{"metric-name": "queue_length"},
+		{"metric-name": "queue_length", "port": "http"},
+		{"metric-name": "queue_length", "port": "9090", "match-labels": "queue"},
+		{"metric-name": "queue_length", "port": "9090", "rate-over": "0s"},
+		{"metric-name": "queue_length", "port": "9090", "request-timeout": "-1s"},
+	} {
+		_, err := NewPodMetricsExpositionGetter(config)
+		require.Error(t, err, config)
+	}
+}
+
+func newExpositionTestPod(t *testing.T, exposition *string) (*v1.Pod, string) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/metrics", r.URL.Path)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprint(w, *exposition)
+	}))
+	t.Cleanup(server.Close)
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "default"},
+		Status:     v1.PodStatus{PodIP: serverURL.Hostname()},
+	}
+	return pod, serverURL.Port()
+}
+
+func TestPodMetricsExpositionGetterGauge(t *testing.T) {
+	exposition := testExposition
+	pod, port := newExpositionTestPod(t, &exposition)
+
+	getter, err := NewPodMetricsExpositionGetter(map[string]string{
+		"metric-name":  "queue_length",
+		"match-labels": "queue=high",
+		"port":         port,
+	})
+	require.NoError(t, err)
+
+	value, err := getter.GetMetric(pod)
+	require.NoError(t, err)
+	require.Equal(t, float64(5), value)
+
+	_, err = getter.GetMetric(&v1.Pod{})
+	require.Error(t, err)
+}
+
+func TestPodMetricsExpositionGetterCounterRate(t *testing.T) {
+	exposition := testExposition
+	pod, port := newExpositionTestPod(t, &exposition)
+
+	getter, err := NewPodMetricsExpositionGetter(map[string]string{
+		"metric-name":  "http_requests_total",
+		"match-labels": "method=POST",
+		"rate-over":    "2m",
+		"port":         port,
+	})
+	require.NoError(t, err)
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	getter.now = func() time.Time { return now }
+
+	// the rate requires a previous scrape.
+	_, err = getter.GetMetric(pod)
+	require.Error(t, err)
+
+	now = now.Add(time.Minute)
+	exposition = strings.Replace(testExposition, `method="POST",code="200"} 20`, `method="POST",code="200"} 140`, 1)
+	value, err := getter.GetMetric(pod)
+	require.NoError(t, err)
+	require.Equal(t, float64(2), value) // (140-20)/60s
+
+	// a counter reset counts the new value as increase.
+	now = now.Add(time.Minute)
+	exposition = strings.Replace(testExposition, `method="POST",code="200"} 20`, `method="POST",code="200"} 30`, 1)
+	value, err = getter.GetMetric(pod)
+	require.NoError(t, err)
+	require.Equal(t, 0.5, value) // 30/60s
+
+	// the previous scrape is outside the window.
+	now = now.Add(3 * time.Minute)
+	_, err = getter.GetMetric(pod)
+	require.Error(t, err)
+	require.Len(t, getter.samples, 1)
+
+	// the rate is only supported for counters.
+	gaugeGetter, err := NewPodMetricsExpositionGetter(map[string]string{
+		"metric-name": "queue_length",
+		"rate-over":   "2m",
+		"port":        port,
+	})
+	require.NoError(t, err)
+	_, err = gaugeGetter.GetMetric(pod)
+	require.Error(t, err)
+}
+
+func TestPodMetricsExpositionGetterMissingMetric(t *testing.T) {
+	exposition := testExposition
+	pod, port := newExpositionTestPod(t, &exposition)
+
+	getter, err := NewPodMetricsExpositionGetter(map[string]string{
+		"metric-name": "missing_metric",
+		"port":        port,
+	})
+	require.NoError(t, err)
+
+	_, err = getter.GetMetric(pod)
+	require.ErrorContains(t, err, "metric missing_metric not found")
+}
+
+func TestPodMetricsExpositionGetterMaxResponseSize(t *testing.T) {
+	server, written := makeOversizedTestHTTPServer(t, "", "queue_length{queue=\"high\"} 5\n")
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	pod := &v1.Pod{Status: v1.PodStatus{PodIP: serverURL.Hostname()}}
+
+	_, err = NewPodMetricsExpositionGetter(map[string]string{
+		"metric-name":       "queue_length",
+		"port":              serverURL.Port(),
+		"max-response-size": "0",
+	})
+	require.Error(t, err)
+
+	getter, err := NewPodMetricsExpositionGetter(map[string]string{
+		"metric-name":       "queue_length",
+		"port":              serverURL.Port(),
+		"max-response-size": "65536",
+	})
+	require.NoError(t, err)
+
+	_, err = getter.GetMetric(pod)
+	var tooLarge *httperrors.TooLargeError
+	require.True(t, errors.As(err, &tooLarge), "unexpected error: %v", err)
+	require.Equal(t, int64(65536), tooLarge.Limit)
+
+	server.Close()
+	require.Less(t, written.Load(), int64(oversizedResponseSize/2))
+}
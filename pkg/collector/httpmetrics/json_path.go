@@ -2,22 +2,23 @@ package httpmetrics
 
 import (
 	"fmt"
-	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"time"
 
 	"github.com/spyzhov/ajson"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/httperrors"
 )
 
 // JSONPathMetricsGetter is a metrics getter which looks up pod metrics by
 // querying the pods metrics endpoint and lookup the metric value as defined by
 // the json path query.
 type JSONPathMetricsGetter struct {
-	jsonPath   string
-	aggregator AggregatorFunc
-	client     *http.Client
+	jsonPath        string
+	aggregator      AggregatorFunc
+	client          *http.Client
+	maxResponseSize int64
 }
 
 // NewJSONPathMetricsGetter initializes a new JSONPathMetricsGetter.
@@ -27,12 +28,23 @@ func NewJSONPathMetricsGetter(httpClient *http.Client, aggregatorFunc Aggregator
 	if err != nil {
 		return nil, err
 	}
-	return &JSONPathMetricsGetter{client: httpClient, aggregator: aggregatorFunc, jsonPath: jsonPath}, nil
+	return &JSONPathMetricsGetter{client: httpClient, aggregator: aggregatorFunc, jsonPath: jsonPath, maxResponseSize: DefaultMaxResponseSize}, nil
+}
+
+// SetMaxResponseSize sets the maximum number of bytes read from the metrics
+// endpoint. Larger responses fail with a httperrors.TooLargeError. A size
+// <= 0 disables the limit.
+func (g *JSONPathMetricsGetter) SetMaxResponseSize(size int64) {
+	g.maxResponseSize = size
 }
 
 var DefaultRequestTimeout = 15 * time.Second
 var DefaultConnectTimeout = 15 * time.Second
 
+// DefaultMaxResponseSize is the maximum number of bytes read from a metrics
+// endpoint unless overridden by the max-response-size config.
+var DefaultMaxResponseSize = httperrors.DefaultMaxResponseSize
+
 func CustomMetricsHTTPClient(requestTimeout time.Duration, connectTimeout time.Duration) *http.Client {
 	client := &http.Client{
 		Transport: &http.Transport{
@@ -61,13 +73,19 @@ func (g *JSONPathMetricsGetter) GetMetric(metricsURL url.URL) (float64, error) {
 		return 0, err
 	}
 
+	return JSONPathMetric(data, g.jsonPath, g.aggregator)
+}
+
+// JSONPathMetric extracts the metric value from the json data using the
+// json path query. Multiple values are combined using the aggregator.
+func JSONPathMetric(data []byte, jsonPath string, aggregator AggregatorFunc) (float64, error) {
 	// parse data
 	root, err := ajson.Unmarshal(data)
 	if err != nil {
 		return 0, err
 	}
 
-	nodes, err := root.JSONPath(g.jsonPath)
+	nodes, err := root.JSONPath(jsonPath)
 	if err != nil {
 		return 0, err
 	}
@@ -77,7 +95,7 @@ func (g *JSONPathMetricsGetter) GetMetric(metricsURL url.URL) (float64, error) {
 	}
 
 	if len(nodes) > 1 {
-		if g.aggregator == nil {
+		if aggregator == nil {
 			return 0, fmt.Errorf("no aggregator function has been specified")
 		}
 		values := make([]float64, 0, len(nodes))
@@ -88,12 +106,12 @@ func (g *JSONPathMetricsGetter) GetMetric(metricsURL url.URL) (float64, error) {
 			}
 			values = append(values, v)
 		}
-		return g.aggregator(values...), nil
+		return aggregator(values...), nil
 	}
 
 	node := nodes[0]
 	if node.IsArray() {
-		if g.aggregator == nil {
+		if aggregator == nil {
 			return 0, fmt.Errorf("no aggregator function has been specified")
 		}
 		values := make([]float64, 0, len(nodes))
@@ -105,7 +123,7 @@ func (g *JSONPathMetricsGetter) GetMetric(metricsURL url.URL) (float64, error) {
 			}
 			values = append(values, value)
 		}
-		return g.aggregator(values...), nil
+		return aggregator(values...), nil
 	} else if node.IsNumeric() {
 		res, _ := node.GetNumeric()
 		return res, nil
@@ -130,7 +148,7 @@ func (g *JSONPathMetricsGetter) fetchMetrics(metricsURL url.URL) ([]byte, error)
 	}
 	defer resp.Body.Close()
 
-	data, err := io.ReadAll(resp.Body)
+	data, err := httperrors.ReadAll(resp.Body, g.maxResponseSize)
 	if err != nil {
 		return nil, err
 	}
@@ -6,9 +6,12 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/httperrors"
 )
 
 func makeTestHTTPServer(t *testing.T, response []byte) *httptest.Server {
@@ -89,3 +92,65 @@ func TestJSONPathMetricsGetter(t *testing.T) {
 		})
 	}
 }
+
+// oversizedResponseSize is the size of the responses streamed by
+// makeOversizedTestHTTPServer.
+const oversizedResponseSize = 64 << 20
+
+// makeOversizedTestHTTPServer returns a server streaming a response of
+// oversizedResponseSize bytes starting with the prefix until the client
+// disconnects. The bytes written are counted in written.
+func makeOversizedTestHTTPServer(t *testing.T, prefix, filler string) (*httptest.Server, *atomic.Int64) {
+	written := &atomic.Int64{}
+	chunk := []byte(strings.Repeat(filler, 32*1024/len(filler)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := w.Write([]byte(prefix))
+		written.Add(int64(n))
+		for err == nil && written.Load() < oversizedResponseSize {
+			n, err = w.Write(chunk)
+			written.Add(int64(n))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, written
+}
+
+func TestJSONPathMetricsGetterMaxResponseSize(t *testing.T) {
+	server, written := makeOversizedTestHTTPServer(t, `{"value":[`, "1,")
+	metricsURL, err := url.Parse(server.URL + "/metrics")
+	require.NoError(t, err)
+
+	getter, err := NewJSONPathMetricsGetter(DefaultMetricsHTTPClient(), Average, "$.value")
+	require.NoError(t, err)
+	getter.SetMaxResponseSize(1 << 20)
+
+	_, err = getter.GetMetric(*metricsURL)
+	var tooLarge *httperrors.TooLargeError
+	require.True(t, errors.As(err, &tooLarge), "unexpected error: %v", err)
+	require.Equal(t, int64(1<<20), tooLarge.Limit)
+
+	// the client stops reading at the limit, so the server can't write
+	// the whole response.
+	server.Close()
+	require.Less(t, written.Load(), int64(oversizedResponseSize/2))
+}
+
+func TestJSONPathMetricsGetterMaxResponseSizeOverride(t *testing.T) {
+	response := []byte(`{"value":[` + strings.Repeat("1,", 1024) + `1]}`)
+	server := makeTestHTTPServer(t, response)
+	defer server.Close()
+	metricsURL, err := url.Parse(server.URL + "/metrics")
+	require.NoError(t, err)
+
+	getter, err := NewJSONPathMetricsGetter(DefaultMetricsHTTPClient(), Average, "$.value")
+	require.NoError(t, err)
+
+	getter.SetMaxResponseSize(1024)
+	_, err = getter.GetMetric(*metricsURL)
+	require.IsType(t, &httperrors.TooLargeError{}, err)
+
+	getter.SetMaxResponseSize(int64(len(response)))
+	metric, err := getter.GetMetric(*metricsURL)
+	require.NoError(t, err)
+	require.Equal(t, float64(1), metric)
+}
@@ -2,13 +2,19 @@ package httpmetrics
 
 import (
 	"fmt"
+	"math"
 	"net/url"
-	"strconv"
 	"time"
 
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/annotations"
+
 	v1 "k8s.io/api/core/v1"
 )
 
+// MaxResponseSizeConfigKey is the config key overriding the maximum number
+// of bytes read from a metrics endpoint, e.g. for known large responses.
+const MaxResponseSizeConfigKey = "max-response-size"
+
 type PodMetricsGetter interface {
 	GetMetric(pod *v1.Pod) (float64, error)
 }
@@ -30,89 +36,90 @@ func (g PodMetricsJSONPathGetter) GetMetric(pod *v1.Pod) (float64, error) {
 }
 
 func NewPodMetricsJSONPathGetter(config map[string]string) (*PodMetricsJSONPathGetter, error) {
+	return BindPodMetricsJSONPathGetter(annotations.NewConfigBinder(config, nil))
+}
+
+// BindPodMetricsJSONPathGetter initializes a new PodMetricsJSONPathGetter
+// from the config values of the binder.
+func BindPodMetricsJSONPathGetter(b *annotations.ConfigBinder) (*PodMetricsJSONPathGetter, error) {
 	getter := PodMetricsJSONPathGetter{}
 	var (
-		jsonPath   string
-		aggregator AggregatorFunc
-		err        error
+		jsonPath       string
+		aggregatorName string
+		aggregator     AggregatorFunc
+		err            error
 	)
 
-	if v, ok := config["json-key"]; ok {
-		jsonPath = v
-	}
-
-	if v, ok := config["scheme"]; ok {
-		getter.scheme = v
-	}
-
-	if v, ok := config["path"]; ok {
-		getter.path = v
-	}
-
-	if v, ok := config["raw-query"]; ok {
-		getter.rawQuery = v
-	}
-
-	if v, ok := config["port"]; ok {
-		n, err := strconv.Atoi(v)
-		if err != nil {
-			return nil, err
-		}
-		getter.port = n
-	}
-
-	if v, ok := config["aggregator"]; ok {
-		aggregator, err = ParseAggregator(v)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	requestTimeout := DefaultRequestTimeout
-	connectTimeout := DefaultConnectTimeout
-
-	if v, ok := config["request-timeout"]; ok {
-		d, err := time.ParseDuration(v)
-		if err != nil {
-			return nil, err
-		}
-		if d < 0 {
-			return nil, fmt.Errorf("Invalid request-timeout config value: %s", v)
-		}
-		requestTimeout = d
+	b.String("json-key", &jsonPath)
+	b.String("scheme", &getter.scheme)
+	b.String("path", &getter.path)
+	b.String("raw-query", &getter.rawQuery)
+	b.Int("port", &getter.port, 1, 65535)
+	b.Enum("aggregator", &aggregatorName, "avg", "min", "max", "sum")
+	requestTimeout, connectTimeout := bindTimeouts(b)
+	maxResponseSize := BindMaxResponseSize(b)
+	if err := b.Err(); err != nil {
+		return nil, err
 	}
 
-	if v, ok := config["connect-timeout"]; ok {
-		d, err := time.ParseDuration(v)
+	if aggregatorName != "" {
+		aggregator, err = ParseAggregator(aggregatorName)
 		if err != nil {
 			return nil, err
 		}
-		if d < 0 {
-			return nil, fmt.Errorf("Invalid connect-timeout config value: %s", v)
-		}
-		connectTimeout = d
 	}
 
 	jsonPathGetter, err := NewJSONPathMetricsGetter(CustomMetricsHTTPClient(requestTimeout, connectTimeout), aggregator, jsonPath)
 	if err != nil {
 		return nil, err
 	}
+	jsonPathGetter.SetMaxResponseSize(maxResponseSize)
 	getter.metricGetter = jsonPathGetter
 	return &getter, nil
 }
 
+// parseTimeouts parses the request-timeout and connect-timeout config
+// falling back to the default timeouts.
+func parseTimeouts(config map[string]string) (time.Duration, time.Duration, error) {
+	b := annotations.NewConfigBinder(config, nil)
+	requestTimeout, connectTimeout := bindTimeouts(b)
+	return requestTimeout, connectTimeout, b.Err()
+}
+
+// bindTimeouts binds the request-timeout and connect-timeout config falling
+// back to the default timeouts.
+func bindTimeouts(b *annotations.ConfigBinder) (time.Duration, time.Duration) {
+	requestTimeout := DefaultRequestTimeout
+	connectTimeout := DefaultConnectTimeout
+	b.Duration("request-timeout", &requestTimeout)
+	b.Duration("connect-timeout", &connectTimeout)
+	return requestTimeout, connectTimeout
+}
+
+// BindMaxResponseSize binds the max-response-size config falling back to
+// the default max response size.
+func BindMaxResponseSize(b *annotations.ConfigBinder) int64 {
+	size := int(DefaultMaxResponseSize)
+	b.Int(MaxResponseSizeConfigKey, &size, 1, math.MaxInt)
+	return int64(size)
+}
+
 // buildMetricsURL will build the full URL needed to hit the pod metric endpoint.
 func (g *PodMetricsJSONPathGetter) buildMetricsURL(podIP string) url.URL {
-	var scheme = g.scheme
+	return buildPodMetricsURL(podIP, g.scheme, g.port, g.path, g.rawQuery)
+}
 
+// buildPodMetricsURL builds the URL of a pod metrics endpoint. The scheme
+// defaults to http.
+func buildPodMetricsURL(podIP, scheme string, port int, path, rawQuery string) url.URL {
 	if scheme == "" {
 		scheme = "http"
 	}
 
 	return url.URL{
 		Scheme:   scheme,
-		Host:     fmt.Sprintf("%s:%d", podIP, g.port),
-		Path:     g.path,
-		RawQuery: g.rawQuery,
+		Host:     fmt.Sprintf("%s:%d", podIP, port),
+		Path:     path,
+		RawQuery: rawQuery,
 	}
 }
@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"time"
 
+	argoRolloutsClient "github.com/argoproj/argo-rollouts/pkg/client/clientset/versioned"
 	influxdb "github.com/influxdata/influxdb-client-go"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/metrics/pkg/apis/custom_metrics"
 	"k8s.io/metrics/pkg/apis/external_metrics"
 )
 
@@ -20,26 +22,35 @@ const (
 	influxDBTokenKey          = "token"
 	influxDBOrgKey            = "org"
 	influxDBQueryNameLabelKey = "query-name"
+	influxDBMetricValueKey    = "metricvalue"
 )
 
 type InfluxDBCollectorPlugin struct {
-	kubeClient kubernetes.Interface
-	address    string
-	token      string
-	org        string
+	kubeClient         kubernetes.Interface
+	argoRolloutsClient argoRolloutsClient.Interface
+	address            string
+	token              string
+	org                string
 }
 
-func NewInfluxDBCollectorPlugin(client kubernetes.Interface, address, token, org string) (*InfluxDBCollectorPlugin, error) {
+func NewInfluxDBCollectorPlugin(client kubernetes.Interface, argoRolloutsClient argoRolloutsClient.Interface, address, token, org string) (*InfluxDBCollectorPlugin, error) {
 	return &InfluxDBCollectorPlugin{
-		kubeClient: client,
-		address:    address,
-		token:      token,
-		org:        org,
+		kubeClient:         client,
+		argoRolloutsClient: argoRolloutsClient,
+		address:            address,
+		token:              token,
+		org:                org,
 	}, nil
 }
 
 func (p *InfluxDBCollectorPlugin) NewCollector(ctx context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
-	return NewInfluxDBCollector(ctx, hpa, p.address, p.token, p.org, config, interval)
+	c, err := NewInfluxDBCollector(ctx, hpa, p.address, p.token, p.org, config, interval)
+	if err != nil {
+		return nil, err
+	}
+	c.client = p.kubeClient
+	c.argoRolloutsClient = p.argoRolloutsClient
+	return c, nil
 }
 
 type InfluxDBCollector struct {
@@ -47,12 +58,17 @@ type InfluxDBCollector struct {
 	token   string
 	org     string
 
-	influxDBClient influxdb.Client
-	interval       time.Duration
-	metric         autoscalingv2.MetricIdentifier
-	metricType     autoscalingv2.MetricSourceType
-	query          string
-	namespace      string
+	client             kubernetes.Interface
+	argoRolloutsClient argoRolloutsClient.Interface
+	influxDBClient     influxdb.Client
+	interval           time.Duration
+	metric             autoscalingv2.MetricIdentifier
+	metricType         autoscalingv2.MetricSourceType
+	objectReference    custom_metrics.ObjectReference
+	perReplica         bool
+	query              string
+	hpa                *autoscalingv2.HorizontalPodAutoscaler
+	namespace          string
 }
 
 func NewInfluxDBCollector(_ context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, address string, token string, org string, config *MetricConfig, interval time.Duration) (*InfluxDBCollector, error) {
@@ -60,37 +76,41 @@ func NewInfluxDBCollector(_ context.Context, hpa *autoscalingv2.HorizontalPodAut
 		interval:   interval,
 		metric:     config.Metric,
 		metricType: config.Type,
+		perReplica: config.PerReplica,
+		hpa:        hpa,
 		namespace:  hpa.Namespace,
 	}
+	b := config.binder()
 	switch configType := config.Type; configType {
-	case autoscalingv2.ObjectMetricSourceType:
-		return nil, fmt.Errorf("InfluxDB does not support object, but only external custom metrics")
-	case autoscalingv2.ExternalMetricSourceType:
+	case autoscalingv2.ObjectMetricSourceType, autoscalingv2.ExternalMetricSourceType:
+		if configType == autoscalingv2.ObjectMetricSourceType {
+			collector.objectReference = config.ObjectReference
+		}
 		// `metricSelector` is flattened into the MetricConfig.Config.
-		queryName, ok := config.Config[influxDBQueryNameLabelKey]
-		if !ok {
-			return nil, fmt.Errorf("selector for Flux query is not specified, "+
-				"please add metricSelector.matchLabels.%s: <...> to .yml description", influxDBQueryNameLabelKey)
+		var queryName string
+		if !b.RequiredString(influxDBQueryNameLabelKey, &queryName) {
+			break
 		}
-		if query, ok := config.Config[queryName]; ok {
-			// TODO(affo): validate the query once this is done:
-			//  https://github.com/influxdata/influxdb-client-go/issues/73.
-			collector.query = query
-		} else {
-			return nil, fmt.Errorf("no Flux query defined for metric \"%s\"", config.Metric.Name)
+		// the other named queries are used by other metrics.
+		b.IgnoreUnbound()
+		// TODO(affo): validate the query once this is done:
+		//  https://github.com/influxdata/influxdb-client-go/issues/73.
+		if !b.String(queryName, &collector.query) {
+			b.Invalid(influxDBQueryNameLabelKey, "no Flux query defined for the query name")
 		}
 	default:
 		return nil, fmt.Errorf("unknown metric type: %v", configType)
 	}
-	// Use custom InfluxDB config if defined in HPA annotation.
-	if v, ok := config.Config[influxDBAddressKey]; ok {
-		address = v
-	}
-	if v, ok := config.Config[influxDBTokenKey]; ok {
-		token = v
+	// Use custom InfluxDB config if defined in HPA annotation. The
+	// default token is never sent to an overridden address.
+	defaultAddress := address
+	b.String(influxDBAddressKey, &address)
+	if !b.String(influxDBTokenKey, &token) && address != defaultAddress {
+		token = ""
 	}
-	if v, ok := config.Config[influxDBOrgKey]; ok {
-		org = v
+	b.String(influxDBOrgKey, &org)
+	if err := finishBinding(b, hpa); err != nil {
+		return nil, err
 	}
 	influxDbClient := influxdb.NewClient(address, token)
 	collector.address = address
@@ -106,42 +126,77 @@ type queryResult struct {
 	MetricValue float64
 }
 
-// getValue returns the first result gathered from an InfluxDB instance.
-func (c *InfluxDBCollector) getValue(ctx context.Context) (resource.Quantity, error) {
+// getValue returns the value of the first record of the query result and
+// its time. The current time is returned if the record has no time.
+func (c *InfluxDBCollector) getValue(ctx context.Context) (float64, time.Time, error) {
 	queryAPI := c.influxDBClient.QueryAPI(c.org)
 	res, err := queryAPI.Query(ctx, c.query)
 	if err != nil {
-		return resource.Quantity{}, err
+		return 0, time.Time{}, err
 	}
 	defer res.Close()
 	// Keeping just the first result.
 	if res.Next() {
 		qr := queryResult{}
-		return *resource.NewMilliQuantity(int64(qr.MetricValue*1000), resource.DecimalSI), nil
+		switch v := res.Record().ValueByKey(influxDBMetricValueKey).(type) {
+		case float64:
+			qr.MetricValue = v
+		case int64:
+			qr.MetricValue = float64(v)
+		case uint64:
+			qr.MetricValue = float64(v)
+		default:
+			return 0, time.Time{}, fmt.Errorf("unexpected type %T for column \"%s\" in query result", v, influxDBMetricValueKey)
+		}
+		timestamp := time.Now()
+		if recordTime, ok := res.Record().ValueByKey("_time").(time.Time); ok && !recordTime.IsZero() {
+			timestamp = recordTime
+		}
+		return qr.MetricValue, timestamp.UTC(), nil
 	}
 	if err := res.Err(); err != nil {
-		return resource.Quantity{}, fmt.Errorf("error in query result: %v", err)
+		return 0, time.Time{}, fmt.Errorf("error in query result: %v", err)
 	}
-	return resource.Quantity{}, fmt.Errorf("empty result returned")
+	return 0, time.Time{}, fmt.Errorf("empty result returned")
 }
 
 func (c *InfluxDBCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, error) {
-	v, err := c.getValue(ctx)
+	v, timestamp, err := c.getValue(ctx)
 	if err != nil {
 		return nil, err
 	}
-	cm := CollectedMetric{
-		Namespace: c.namespace,
-		Type:      c.metricType,
-		External: external_metrics.ExternalMetricValue{
+
+	if c.perReplica {
+		// get current replicas for the targeted scale object. This is used to
+		// calculate an average metric instead of total.
+		replicas, err := targetRefReplicas(ctx, c.client, c.argoRolloutsClient, c.hpa)
+		if err != nil {
+			return nil, err
+		}
+		if replicas < 1 {
+			return nil, fmt.Errorf("unable to get average value for %d replicas", replicas)
+		}
+		v = v / float64(replicas)
+	}
+
+	var cm CollectedMetric
+	switch c.metricType {
+	case autoscalingv2.ObjectMetricSourceType:
+		cm = NewCustomMetric(c.metricType, custom_metrics.MetricValue{
+			DescribedObject: c.objectReference,
+			Metric:          custom_metrics.MetricIdentifier{Name: c.metric.Name, Selector: c.metric.Selector},
+			Timestamp:       metav1.Time{Time: timestamp},
+			Value:           *resource.NewMilliQuantity(int64(v*1000), resource.DecimalSI),
+		})
+	case autoscalingv2.ExternalMetricSourceType:
+		cm = NewExternalMetric(c.namespace, external_metrics.ExternalMetricValue{
 			MetricName:   c.metric.Name,
 			MetricLabels: c.metric.Selector.MatchLabels,
-			Timestamp: metav1.Time{
-				Time: time.Now().UTC(),
-			},
-			Value: v,
-		},
+			Timestamp:    metav1.Time{Time: timestamp},
+			Value:        *resource.NewMilliQuantity(int64(v*1000), resource.DecimalSI),
+		})
 	}
+	cm.Query = c.query
 	return []CollectedMetric{cm}, nil
 }
 
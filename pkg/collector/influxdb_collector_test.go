@@ -2,13 +2,20 @@ package collector
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/metrics/pkg/apis/custom_metrics"
 )
 
 func TestInfluxDBCollector_New(t *testing.T) {
@@ -97,6 +104,25 @@ func TestInfluxDBCollector_New(t *testing.T) {
 			t.Errorf("unexpected value -want/+got:\n\t-%s\n\t+%s", want, got)
 		}
 	})
+	t.Run("overridden address without token", func(t *testing.T) {
+		m := &MetricConfig{
+			MetricTypeName: MetricTypeName{
+				Type:   autoscalingv2.ExternalMetricSourceType,
+				Metric: autoscalingv2.MetricIdentifier{Name: "flux-query"},
+			},
+			CollectorType: "influxdb",
+			Config: map[string]string{
+				"range1m":    `from(bucket: "?") |> range(start: -1m)`,
+				"address":    "http://localhost:9999",
+				"query-name": "range1m",
+			},
+		}
+		c, err := NewInfluxDBCollector(context.Background(), hpa, "http://localhost:8888", "secret", "deadbeef", m, time.Second)
+		require.NoError(t, err)
+		require.Equal(t, "http://localhost:9999", c.address)
+		// the default token is only sent to the default address.
+		require.Empty(t, c.token)
+	})
 	// Errors.
 	for _, tc := range []struct {
 		name            string
@@ -105,11 +131,11 @@ func TestInfluxDBCollector_New(t *testing.T) {
 		errorStartsWith string
 	}{
 		{
-			name: "object metric",
+			name: "object metric without selector",
 			mTypeName: MetricTypeName{
 				Type: autoscalingv2.ObjectMetricSourceType,
 			},
-			errorStartsWith: "InfluxDB does not support object",
+			errorStartsWith: "missing config key 'query-name'",
 		},
 		{
 			name: "no selector",
@@ -125,7 +151,7 @@ func TestInfluxDBCollector_New(t *testing.T) {
 				"range2m": `from(bucket: "?") |> range(start: -2m)`,
 				"range3m": `from(bucket: "?") |> range(start: -3m)`,
 			},
-			errorStartsWith: "selector for Flux query is not specified",
+			errorStartsWith: "missing config key 'query-name'",
 		},
 		{
 			name: "referencing non-existing query",
@@ -141,7 +167,7 @@ func TestInfluxDBCollector_New(t *testing.T) {
 				"range3m":    `from(bucket: "?") |> range(start: -3m)`,
 				"query-name": "rangeXm",
 			},
-			errorStartsWith: "no Flux query defined for metric",
+			errorStartsWith: "invalid value 'rangeXm' of config key 'query-name'",
 		},
 	} {
 		t.Run("error - "+tc.name, func(t *testing.T) {
@@ -160,3 +186,210 @@ func TestInfluxDBCollector_New(t *testing.T) {
 		})
 	}
 }
+
+func makeInfluxDBTestServer(t *testing.T, value float64) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v2/query", r.URL.Path)
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		fmt.Fprintf(w, "#datatype,string,long,double\r\n#group,false,false,false\r\n#default,_result,,\r\n,result,table,metricvalue\r\n,,0,%g\r\n\r\n", value)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestInfluxDBCollector_GetMetrics(t *testing.T) {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "hpa",
+			Namespace: "default",
+		},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
+				Kind:       "Deployment",
+				Name:       "app",
+				APIVersion: "apps/v1",
+			},
+		},
+	}
+
+	client := fake.NewSimpleClientset(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app",
+			Namespace: "default",
+		},
+		Status: appsv1.DeploymentStatus{
+			Replicas: 4,
+		},
+	})
+
+	selector := &v1.LabelSelector{
+		MatchLabels: map[string]string{
+			"query-name": "rps",
+		},
+	}
+
+	for _, tc := range []struct {
+		name       string
+		metricType autoscalingv2.MetricSourceType
+		perReplica bool
+		expected   int64
+	}{
+		{
+			name:       "external",
+			metricType: autoscalingv2.ExternalMetricSourceType,
+			expected:   20000,
+		},
+		{
+			name:       "external per replica",
+			metricType: autoscalingv2.ExternalMetricSourceType,
+			perReplica: true,
+			expected:   5000,
+		},
+		{
+			name:       "object",
+			metricType: autoscalingv2.ObjectMetricSourceType,
+			expected:   20000,
+		},
+		{
+			name:       "object per replica",
+			metricType: autoscalingv2.ObjectMetricSourceType,
+			perReplica: true,
+			expected:   5000,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			plugin, err := NewInfluxDBCollectorPlugin(client, nil, makeInfluxDBTestServer(t, 20), "secret", "deadbeef")
+			require.NoError(t, err)
+
+			m := &MetricConfig{
+				MetricTypeName: MetricTypeName{
+					Type: tc.metricType,
+					Metric: autoscalingv2.MetricIdentifier{
+						Name:     "rps",
+						Selector: selector,
+					},
+				},
+				CollectorType: "influxdb",
+				ObjectReference: custom_metrics.ObjectReference{
+					APIVersion: "networking.k8s.io/v1",
+					Kind:       "Ingress",
+					Name:       "app",
+					Namespace:  "default",
+				},
+				PerReplica: tc.perReplica,
+				Config: map[string]string{
+					"rps":        `from(bucket: "?") |> range(start: -1m)`,
+					"query-name": "rps",
+				},
+			}
+
+			c, err := plugin.NewCollector(context.Background(), hpa, m, time.Second)
+			require.NoError(t, err)
+
+			metrics, err := c.GetMetrics(context.Background())
+			require.NoError(t, err)
+			require.Len(t, metrics, 1)
+			require.Equal(t, tc.metricType, metrics[0].Type)
+			require.Equal(t, "default", metrics[0].Namespace)
+			require.Equal(t, m.Config["rps"], metrics[0].Query)
+
+			switch tc.metricType {
+			case autoscalingv2.ExternalMetricSourceType:
+				require.Equal(t, "rps", metrics[0].External.MetricName)
+				require.Equal(t, selector.MatchLabels, metrics[0].External.MetricLabels)
+				require.Equal(t, tc.expected, metrics[0].External.Value.MilliValue())
+			case autoscalingv2.ObjectMetricSourceType:
+				require.Equal(t, "rps", metrics[0].Custom.Metric.Name)
+				require.Equal(t, m.ObjectReference, metrics[0].Custom.DescribedObject)
+				require.Equal(t, tc.expected, metrics[0].Custom.Value.MilliValue())
+			}
+		})
+	}
+}
+
+func TestInfluxDBCollectorRecordTime(t *testing.T) {
+	recordTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		fmt.Fprintf(w, "#datatype,string,long,dateTime:RFC3339,double\r\n#group,false,false,false,false\r\n#default,_result,,,\r\n,result,table,_time,metricvalue\r\n,,0,%s,20\r\n\r\n", recordTime.Format(time.RFC3339))
+	}))
+	defer server.Close()
+
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Name: "hpa", Namespace: "default"}}
+	m := &MetricConfig{
+		MetricTypeName: MetricTypeName{
+			Type:   autoscalingv2.ExternalMetricSourceType,
+			Metric: autoscalingv2.MetricIdentifier{Name: "rps", Selector: &v1.LabelSelector{MatchLabels: map[string]string{"query-name": "rps"}}},
+		},
+		CollectorType: "influxdb",
+		Config: map[string]string{
+			"query-name": "rps",
+			"rps":        "from(bucket: \"apps\")",
+		},
+	}
+
+	collector, err := NewInfluxDBCollector(context.Background(), hpa, server.URL, "secret", "deadbeef", m, time.Second)
+	require.NoError(t, err)
+
+	metrics, err := collector.GetMetrics(context.Background())
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	require.Equal(t, recordTime, metrics[0].External.Timestamp.Time)
+	require.Equal(t, int64(20000), metrics[0].External.Value.MilliValue())
+}
+
+func TestInfluxDBCollectorPerReplica(t *testing.T) {
+	for _, tc := range []struct {
+		msg      string
+		kind     string
+		replicas int32
+		expected int64
+		err      bool
+	}{
+		{msg: "single deployment replica", kind: "Deployment", replicas: 1, expected: 20000},
+		{msg: "multiple deployment replicas", kind: "Deployment", replicas: 8, expected: 2500},
+		{msg: "uneven deployment replicas", kind: "Deployment", replicas: 3, expected: 6666},
+		{msg: "statefulset replicas", kind: "StatefulSet", replicas: 5, expected: 4000},
+		{msg: "zero replicas", kind: "Deployment", replicas: 0, err: true},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			meta := metav1.ObjectMeta{Name: "app", Namespace: "default"}
+			client := fake.NewSimpleClientset(
+				&appsv1.Deployment{ObjectMeta: meta, Status: appsv1.DeploymentStatus{Replicas: tc.replicas}},
+				&appsv1.StatefulSet{ObjectMeta: meta, Status: appsv1.StatefulSetStatus{Replicas: tc.replicas}},
+			)
+			hpa := &autoscalingv2.HorizontalPodAutoscaler{
+				ObjectMeta: metav1.ObjectMeta{Name: "hpa", Namespace: "default"},
+				Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+					ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{Kind: tc.kind, Name: "app", APIVersion: "apps/v1"},
+				},
+			}
+			m := &MetricConfig{
+				MetricTypeName: MetricTypeName{
+					Type:   autoscalingv2.ExternalMetricSourceType,
+					Metric: autoscalingv2.MetricIdentifier{Name: "rps", Selector: &v1.LabelSelector{MatchLabels: map[string]string{"query-name": "rps"}}},
+				},
+				CollectorType: "influxdb",
+				PerReplica:    true,
+				Config: map[string]string{
+					"query-name": "rps",
+					"rps":        "from(bucket: \"apps\")",
+				},
+			}
+
+			plugin, err := NewInfluxDBCollectorPlugin(client, nil, makeInfluxDBTestServer(t, 20), "secret", "deadbeef")
+			require.NoError(t, err)
+			c, err := plugin.NewCollector(context.Background(), hpa, m, time.Second)
+			require.NoError(t, err)
+
+			metrics, err := c.GetMetrics(context.Background())
+			if tc.err {
+				require.EqualError(t, err, "unable to get average value for 0 replicas")
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, metrics, 1)
+			require.Equal(t, tc.expected, metrics[0].External.Value.MilliValue())
+		})
+	}
+}
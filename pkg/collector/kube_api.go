@@ -0,0 +1,57 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// unattributedCollectorType is the collector type label of Kubernetes API
+// requests not made by a collector.
+const unattributedCollectorType = "none"
+
+var (
+	// KubeAPIRequests is the number of Kubernetes API requests per
+	// collector type.
+	KubeAPIRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kube_metrics_adapter_kube_api_requests",
+		Help: "The total number of Kubernetes API requests by collector type",
+	}, []string{"collector_type"})
+)
+
+type collectorTypeKey struct{}
+
+// WithCollectorType returns a context attributing the Kubernetes API
+// requests made with it to the collector type.
+func WithCollectorType(ctx context.Context, collectorType string) context.Context {
+	return context.WithValue(ctx, collectorTypeKey{}, collectorType)
+}
+
+// CollectorTypeFromContext returns the collector type attributed to the
+// context or an empty string if there is none.
+func CollectorTypeFromContext(ctx context.Context) string {
+	collectorType, _ := ctx.Value(collectorTypeKey{}).(string)
+	return collectorType
+}
+
+type kubeAPIRoundTripper struct {
+	next http.RoundTripper
+}
+
+// NewKubeAPIRoundTripper wraps a round tripper counting the requests by the
+// collector type of the request context. It's meant to be used with
+// rest.Config.Wrap.
+func NewKubeAPIRoundTripper(next http.RoundTripper) http.RoundTripper {
+	return &kubeAPIRoundTripper{next: next}
+}
+
+func (t *kubeAPIRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	collectorType := CollectorTypeFromContext(req.Context())
+	if collectorType == "" {
+		collectorType = unattributedCollectorType
+	}
+	KubeAPIRequests.WithLabelValues(collectorType).Inc()
+	return t.next.RoundTrip(req)
+}
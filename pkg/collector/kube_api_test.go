@@ -0,0 +1,41 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestKubeAPIRoundTripper(t *testing.T) {
+	transport := NewKubeAPIRoundTripper(roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}))
+
+	before := testutil.ToFloat64(KubeAPIRequests.WithLabelValues("test-collector"))
+	beforeNone := testutil.ToFloat64(KubeAPIRequests.WithLabelValues(unattributedCollectorType))
+
+	ctx := WithCollectorType(context.Background(), "test-collector")
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://kubernetes/api/v1/pods", nil)
+		require.NoError(t, err)
+		_, err = transport.RoundTrip(req)
+		require.NoError(t, err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://kubernetes/api/v1/pods", nil)
+	require.NoError(t, err)
+	_, err = transport.RoundTrip(req)
+	require.NoError(t, err)
+
+	require.Equal(t, before+2, testutil.ToFloat64(KubeAPIRequests.WithLabelValues("test-collector")))
+	require.Equal(t, beforeNone+1, testutil.ToFloat64(KubeAPIRequests.WithLabelValues(unattributedCollectorType)))
+}
@@ -0,0 +1,212 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/spyzhov/ajson"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector/httpmetrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// KubeletCollectorType is the pods collector type getting the metric
+	// from the kubelet stats summary.
+	KubeletCollectorType = "kubelet"
+
+	kubeletContainerConfigKey = "container"
+	kubeletJSONKeyConfigKey   = "json-key"
+)
+
+var (
+	// KubeletSummaryErrors is the number of pods skipped because the
+	// kubelet stats summary of their node couldn't be retrieved.
+	KubeletSummaryErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kube_metrics_adapter_kubelet_summary_errors",
+		Help: "The total number of pods skipped because the kubelet stats summary could not be retrieved",
+	})
+)
+
+// kubeletSummary is the part of the kubelet stats summary needed to find the
+// stats of a pod.
+type kubeletSummary struct {
+	Pods []json.RawMessage `json:"pods"`
+}
+
+type kubeletPodStats struct {
+	PodRef struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"podRef"`
+	Containers []json.RawMessage `json:"containers"`
+}
+
+type kubeletContainerStats struct {
+	Name string `json:"name"`
+}
+
+// KubeletSummaryGetter gets pod metrics from the kubelet stats summary of the
+// pod's node. The summary is retrieved via the API server node proxy to not
+// require direct access to the kubelet.
+type KubeletSummaryGetter struct {
+	client     rest.Interface
+	container  string
+	jsonPath   string
+	aggregator httpmetrics.AggregatorFunc
+}
+
+// NewKubeletSummaryGetter initializes a new KubeletSummaryGetter. The
+// json-key config is a json path query evaluated against the stats of the
+// pod or, if the container config is defined, the stats of the named
+// container of the pod.
+func NewKubeletSummaryGetter(client rest.Interface, config map[string]string) (*KubeletSummaryGetter, error) {
+	jsonPath, ok := config[kubeletJSONKeyConfigKey]
+	if !ok {
+		return nil, fmt.Errorf("no %s defined for kubelet metric", kubeletJSONKeyConfigKey)
+	}
+
+	_, err := ajson.ParseJSONPath(jsonPath)
+	if err != nil {
+		return nil, err
+	}
+
+	getter := &KubeletSummaryGetter{
+		client:    client,
+		container: config[kubeletContainerConfigKey],
+		jsonPath:  jsonPath,
+	}
+
+	if v, ok := config["aggregator"]; ok {
+		getter.aggregator, err = httpmetrics.ParseAggregator(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return getter, nil
+}
+
+// GetMetric gets the metric of the pod from the kubelet stats summary.
+func (g *KubeletSummaryGetter) GetMetric(pod *corev1.Pod) (float64, error) {
+	return g.metricOf(pod, g.summary)
+}
+
+// ForCollection returns a getter for the pods of a single collection, which
+// gets the stats summary of each node only once.
+func (g *KubeletSummaryGetter) ForCollection() httpmetrics.PodMetricsGetter {
+	return &kubeletCollectionGetter{
+		getter:    g,
+		summaries: map[string]*nodeSummary{},
+	}
+}
+
+// metricOf gets the metric of the pod from the stats summary of its node
+// returned by summary.
+func (g *KubeletSummaryGetter) metricOf(pod *corev1.Pod, summary func(node string) ([]byte, error)) (float64, error) {
+	if pod.Spec.NodeName == "" {
+		return 0, fmt.Errorf("pod %s/%s is not scheduled to a node", pod.Namespace, pod.Name)
+	}
+
+	data, err := summary(pod.Spec.NodeName)
+	if err != nil {
+		KubeletSummaryErrors.Inc()
+		return 0, fmt.Errorf("failed to get kubelet stats summary of node %s: %w", pod.Spec.NodeName, err)
+	}
+
+	stats, err := g.statsOf(data, pod)
+	if err != nil {
+		return 0, err
+	}
+
+	return httpmetrics.JSONPathMetric(stats, g.jsonPath, g.aggregator)
+}
+
+// summary gets the stats summary of the node via the API server node proxy.
+func (g *KubeletSummaryGetter) summary(node string) ([]byte, error) {
+	return g.client.Get().
+		AbsPath("/api/v1/nodes", node, "proxy", "stats", "summary").
+		DoRaw(context.TODO())
+}
+
+// nodeSummary is the stats summary of a node got once per collection.
+type nodeSummary struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// kubeletCollectionGetter gets the metrics of the pods of a single
+// collection. The pods are collected concurrently, so the stats summary of a
+// node is got by the first pod on the node and shared with the others.
+type kubeletCollectionGetter struct {
+	getter    *KubeletSummaryGetter
+	summaries map[string]*nodeSummary
+	sync.Mutex
+}
+
+// GetMetric gets the metric of the pod from the kubelet stats summary.
+func (g *kubeletCollectionGetter) GetMetric(pod *corev1.Pod) (float64, error) {
+	return g.getter.metricOf(pod, g.summary)
+}
+
+func (g *kubeletCollectionGetter) summary(node string) ([]byte, error) {
+	g.Lock()
+	summary, ok := g.summaries[node]
+	if !ok {
+		summary = &nodeSummary{}
+		g.summaries[node] = summary
+	}
+	g.Unlock()
+
+	summary.once.Do(func() {
+		summary.data, summary.err = g.getter.summary(node)
+	})
+	return summary.data, summary.err
+}
+
+// statsOf returns the stats of the pod or of the configured container of the
+// pod from the kubelet stats summary.
+func (g *KubeletSummaryGetter) statsOf(data []byte, pod *corev1.Pod) ([]byte, error) {
+	var summary kubeletSummary
+	err := json.Unmarshal(data, &summary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode kubelet stats summary of node %s: %w", pod.Spec.NodeName, err)
+	}
+
+	for _, rawPod := range summary.Pods {
+		var podStats kubeletPodStats
+		err := json.Unmarshal(rawPod, &podStats)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode kubelet pod stats: %w", err)
+		}
+
+		if podStats.PodRef.Namespace != pod.Namespace || podStats.PodRef.Name != pod.Name {
+			continue
+		}
+
+		if g.container == "" {
+			return rawPod, nil
+		}
+
+		for _, rawContainer := range podStats.Containers {
+			var containerStats kubeletContainerStats
+			err := json.Unmarshal(rawContainer, &containerStats)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode kubelet container stats: %w", err)
+			}
+
+			if containerStats.Name == g.container {
+				return rawContainer, nil
+			}
+		}
+
+		return nil, fmt.Errorf("container %s of pod %s/%s not found in kubelet stats summary", g.container, pod.Namespace, pod.Name)
+	}
+
+	return nil, fmt.Errorf("pod %s/%s not found in kubelet stats summary of node %s", pod.Namespace, pod.Name, pod.Spec.NodeName)
+}
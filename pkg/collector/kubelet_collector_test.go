@@ -0,0 +1,211 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	restfake "k8s.io/client-go/rest/fake"
+)
+
+const testKubeletSummary = `{
+  "node": {"nodeName": "node-a"},
+  "pods": [
+    {
+      "podRef": {"name": "test-pod-0", "namespace": "test-namespace"},
+      "containers": [
+        {"name": "sidecar", "memory": {"workingSetBytes": 100}},
+        {"name": "app", "memory": {"workingSetBytes": 2048}}
+      ],
+      "memory": {"workingSetBytes": 2148}
+    },
+    {
+      "podRef": {"name": "test-pod-1", "namespace": "test-namespace"},
+      "containers": [
+        {"name": "app", "memory": {"workingSetBytes": 4096}}
+      ],
+      "memory": {"workingSetBytes": 4096}
+    }
+  ]
+}`
+
+// newKubeletProxyClient returns a REST client responding to node proxy
+// requests for the kubelet stats summary. Requests for nodes without a
+// summary fail.
+func newKubeletProxyClient(summaries map[string]string) *restfake.RESTClient {
+	return newCountingKubeletProxyClient(summaries, nil)
+}
+
+// newCountingKubeletProxyClient returns a REST client like
+// newKubeletProxyClient counting the requests per node if requests is set.
+func newCountingKubeletProxyClient(summaries map[string]string, requests *sync.Map) *restfake.RESTClient {
+	return &restfake.RESTClient{
+		Client: restfake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+			if requests != nil {
+				count, _ := requests.LoadOrStore(req.URL.Path, &atomic.Int32{})
+				count.(*atomic.Int32).Add(1)
+			}
+			for node, summary := range summaries {
+				if req.URL.Path == fmt.Sprintf("/api/v1/nodes/%s/proxy/stats/summary", node) {
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Header:     http.Header{"Content-Type": []string{"application/json"}},
+						Body:       io.NopCloser(bytes.NewBufferString(summary)),
+					}, nil
+				}
+			}
+			return &http.Response{
+				StatusCode: http.StatusServiceUnavailable,
+				Body:       io.NopCloser(bytes.NewBufferString("node unavailable")),
+			}, nil
+		}),
+	}
+}
+
+func TestKubeletSummaryGetter(t *testing.T) {
+	client := newKubeletProxyClient(map[string]string{"node-a": testKubeletSummary})
+
+	for _, tc := range []struct {
+		msg      string
+		config   map[string]string
+		pod      string
+		expected float64
+		err      bool
+	}{
+		{
+			msg:      "container stats",
+			config:   map[string]string{"json-key": "$.memory.workingSetBytes", "container": "app"},
+			pod:      "test-pod-0",
+			expected: 2048,
+		},
+		{
+			msg:      "pod stats",
+			config:   map[string]string{"json-key": "$.memory.workingSetBytes"},
+			pod:      "test-pod-0",
+			expected: 2148,
+		},
+		{
+			msg:      "aggregated container stats",
+			config:   map[string]string{"json-key": "$.containers[*].memory.workingSetBytes", "aggregator": "max"},
+			pod:      "test-pod-0",
+			expected: 2048,
+		},
+		{
+			msg:    "unknown container",
+			config: map[string]string{"json-key": "$.memory.workingSetBytes", "container": "other"},
+			pod:    "test-pod-1",
+			err:    true,
+		},
+		{
+			msg:    "unknown pod",
+			config: map[string]string{"json-key": "$.memory.workingSetBytes"},
+			pod:    "test-pod-2",
+			err:    true,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			getter, err := NewKubeletSummaryGetter(client, tc.config)
+			require.NoError(t, err)
+
+			value, err := getter.GetMetric(&corev1.Pod{
+				ObjectMeta: v1.ObjectMeta{Name: tc.pod, Namespace: testNamespace},
+				Spec:       corev1.PodSpec{NodeName: "node-a"},
+			})
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, value)
+		})
+	}
+}
+
+func TestNewKubeletSummaryGetterInvalidConfig(t *testing.T) {
+	_, err := NewKubeletSummaryGetter(nil, map[string]string{})
+	require.Error(t, err)
+
+	_, err = NewKubeletSummaryGetter(nil, map[string]string{"json-key": "$[invalid"})
+	require.Error(t, err)
+}
+
+func TestPodCollectorKubelet(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	makeTestDeployment(t, client)
+	testHPA := makeTestHPA(t, client)
+
+	podCondition := corev1.PodCondition{Type: corev1.PodReady, Status: corev1.ConditionTrue, LastTransitionTime: v1.NewTime(time.Now().Add(-time.Minute))}
+	for i, node := range []string{"node-a", "node-a", "node-b"} {
+		_, err := client.CoreV1().Pods(testNamespace).Create(context.Background(), &corev1.Pod{
+			ObjectMeta: v1.ObjectMeta{
+				Name:   fmt.Sprintf("test-pod-%d", i),
+				Labels: map[string]string{applicationLabelName: applicationLabelValue},
+			},
+			Spec:   corev1.PodSpec{NodeName: node},
+			Status: corev1.PodStatus{Conditions: []corev1.PodCondition{podCondition}},
+		}, v1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	config := &MetricConfig{
+		MetricTypeName: MetricTypeName{Type: autoscalingv2.PodsMetricSourceType, Metric: autoscalingv2.MetricIdentifier{Name: "memory-working-set"}},
+		CollectorType:  KubeletCollectorType,
+		Config:         map[string]string{"json-key": "$.memory.workingSetBytes", "container": "app"},
+	}
+
+	plugin := NewPodCollectorPlugin(client, nil)
+	collector, err := plugin.NewCollector(context.Background(), testHPA, config, testInterval)
+	require.NoError(t, err)
+
+	// node-b is unavailable, its pod is skipped
+	podCollector := collector.(*PodCollector)
+	var requests sync.Map
+	podCollector.Getter, err = NewKubeletSummaryGetter(newCountingKubeletProxyClient(map[string]string{"node-a": testKubeletSummary}, &requests), config.Config)
+	require.NoError(t, err)
+
+	skipped := testutil.ToFloat64(KubeletSummaryErrors)
+
+	metrics, err := collector.GetMetrics(context.Background())
+	require.NoError(t, err)
+
+	values := map[string]int64{}
+	for _, m := range metrics {
+		require.Equal(t, "Pod", m.Custom.DescribedObject.Kind)
+		values[m.Custom.DescribedObject.Name] = m.Custom.Value.Value()
+	}
+	require.Equal(t, map[string]int64{"test-pod-0": 2048, "test-pod-1": 4096}, values)
+	require.Equal(t, skipped+1, testutil.ToFloat64(KubeletSummaryErrors))
+
+	// the summary of each node is requested once per collection.
+	counts := func() map[string]int32 {
+		counts := map[string]int32{}
+		requests.Range(func(path, count any) bool {
+			counts[path.(string)] = count.(*atomic.Int32).Load()
+			return true
+		})
+		return counts
+	}
+	require.Equal(t, map[string]int32{
+		"/api/v1/nodes/node-a/proxy/stats/summary": 1,
+		"/api/v1/nodes/node-b/proxy/stats/summary": 1,
+	}, counts())
+
+	_, err = collector.GetMetrics(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, map[string]int32{
+		"/api/v1/nodes/node-a/proxy/stats/summary": 2,
+		"/api/v1/nodes/node-b/proxy/stats/summary": 2,
+	}, counts())
+}
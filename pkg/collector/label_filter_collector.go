@@ -0,0 +1,130 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	dropLabelsConfigKey = "drop-labels"
+	keepLabelsConfigKey = "keep-labels"
+)
+
+// LabelFilterCollector wraps a collector removing labels from the collected
+// external metrics before they are stored. Either the listed labels are
+// dropped or only the listed labels are kept.
+type LabelFilterCollector struct {
+	collector Collector
+	labels    map[string]struct{}
+	keep      bool
+}
+
+// NewLabelFilterCollector initializes a new LabelFilterCollector from the
+// drop-labels or keep-labels config. The labels are matched against the
+// selector of the metric, as the HPA only finds the stored metrics if all
+// labels of its selector are kept.
+func NewLabelFilterCollector(collector Collector, config *MetricConfig) (*LabelFilterCollector, error) {
+	drop, dropOK := config.Config[dropLabelsConfigKey]
+	keep, keepOK := config.Config[keepLabelsConfigKey]
+	if dropOK && keepOK {
+		return nil, NewPermanentConfigError(fmt.Errorf("only one of %s and %s can be specified", dropLabelsConfigKey, keepLabelsConfigKey))
+	}
+
+	key, value := dropLabelsConfigKey, drop
+	if keepOK {
+		key, value = keepLabelsConfigKey, keep
+	}
+
+	if config.Type != autoscalingv2.ExternalMetricSourceType {
+		return nil, NewPermanentConfigError(fmt.Errorf("%s is only supported for %s metrics", key, autoscalingv2.ExternalMetricSourceType))
+	}
+
+	labels := parseLabelList(value)
+	if len(labels) == 0 {
+		return nil, NewPermanentConfigError(fmt.Errorf("%s must list at least one label", key))
+	}
+
+	for _, label := range selectorLabels(config.Metric.Selector) {
+		_, listed := labels[label]
+		if listed != keepOK {
+			return nil, NewPermanentConfigError(fmt.Errorf("%s would remove the label '%s' of the metric selector, the metric would never match", key, label))
+		}
+	}
+
+	return &LabelFilterCollector{
+		collector: collector,
+		labels:    labels,
+		keep:      keepOK,
+	}, nil
+}
+
+// GetMetrics collects the metrics of the wrapped collector and filters the
+// labels of the external metrics.
+func (c *LabelFilterCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, error) {
+	metrics, err := c.collector.GetMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the metrics and labels may be shared with the wrapped collector or
+	// the metric config, so they are copied instead of modified in place.
+	filtered := make([]CollectedMetric, 0, len(metrics))
+	for _, metric := range metrics {
+		if metric.Type == autoscalingv2.ExternalMetricSourceType {
+			metricLabels := make(map[string]string, len(metric.External.MetricLabels))
+			for k, v := range metric.External.MetricLabels {
+				if _, listed := c.labels[k]; listed == c.keep {
+					metricLabels[k] = v
+				}
+			}
+			metric.External.MetricLabels = metricLabels
+		}
+		filtered = append(filtered, metric)
+	}
+
+	return filtered, nil
+}
+
+// Interval returns the interval of the wrapped collector.
+func (c *LabelFilterCollector) Interval() time.Duration {
+	return c.collector.Interval()
+}
+
+// parseLabelList parses a comma separated list of labels.
+func parseLabelList(value string) map[string]struct{} {
+	labels := make(map[string]struct{})
+	for _, label := range strings.Split(value, ",") {
+		label = strings.TrimSpace(label)
+		if label != "" {
+			labels[label] = struct{}{}
+		}
+	}
+	return labels
+}
+
+// selectorLabels returns the sorted labels which must be present for a
+// metric to match the selector.
+func selectorLabels(selector *metav1.LabelSelector) []string {
+	if selector == nil {
+		return nil
+	}
+
+	labels := make([]string, 0, len(selector.MatchLabels)+len(selector.MatchExpressions))
+	for label := range selector.MatchLabels {
+		labels = append(labels, label)
+	}
+	for _, expression := range selector.MatchExpressions {
+		switch expression.Operator {
+		case metav1.LabelSelectorOpIn, metav1.LabelSelectorOpExists:
+			labels = append(labels, expression.Key)
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
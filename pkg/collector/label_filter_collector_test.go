@@ -0,0 +1,151 @@
+package collector
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+)
+
+func labeledExternalMetric(metricLabels map[string]string) CollectedMetric {
+	return CollectedMetric{
+		Type: autoscalingv2.ExternalMetricSourceType,
+		External: external_metrics.ExternalMetricValue{
+			MetricName:   "requests",
+			MetricLabels: metricLabels,
+			Value:        *resource.NewQuantity(10, resource.DecimalSI),
+		},
+	}
+}
+
+func labelFilterConfig(config map[string]string) *MetricConfig {
+	return &MetricConfig{
+		MetricTypeName: MetricTypeName{
+			Type:   autoscalingv2.ExternalMetricSourceType,
+			Metric: newMetricIdentifier("requests", "prometheus"),
+		},
+		Config: config,
+	}
+}
+
+func TestLabelFilterCollector(t *testing.T) {
+	for _, tc := range []struct {
+		msg      string
+		config   map[string]string
+		expected map[string]string
+	}{
+		{
+			msg:      "listed labels are dropped",
+			config:   map[string]string{"drop-labels": "pod_uid, request_id"},
+			expected: map[string]string{"type": "prometheus", "app": "foo"},
+		},
+		{
+			msg:      "only listed labels are kept",
+			config:   map[string]string{"keep-labels": "type,app"},
+			expected: map[string]string{"type": "prometheus", "app": "foo"},
+		},
+		{
+			msg:    "dropping unknown labels keeps all labels",
+			config: map[string]string{"drop-labels": "unknown"},
+			expected: map[string]string{
+				"type": "prometheus", "app": "foo", "pod_uid": "1234", "request_id": "abcd",
+			},
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			metricLabels := map[string]string{"type": "prometheus", "app": "foo", "pod_uid": "1234", "request_id": "abcd"}
+			collected := []CollectedMetric{labeledExternalMetric(metricLabels)}
+			config := labelFilterConfig(tc.config)
+
+			c, err := NewLabelFilterCollector(&FakeCollector{metrics: collected}, config)
+			require.NoError(t, err)
+
+			metrics, err := c.GetMetrics(context.Background())
+			require.NoError(t, err)
+			require.Len(t, metrics, 1)
+			require.Equal(t, tc.expected, metrics[0].External.MetricLabels)
+
+			// the selector of the HPA matches the filtered labels.
+			selector, err := metav1.LabelSelectorAsSelector(config.Metric.Selector)
+			require.NoError(t, err)
+			require.True(t, selector.Matches(labels.Set(metrics[0].External.MetricLabels)))
+
+			// the collected metrics are not modified.
+			require.Len(t, metricLabels, 4)
+			require.Equal(t, metricLabels, collected[0].External.MetricLabels)
+		})
+	}
+}
+
+func TestNewLabelFilterCollectorInvalidConfig(t *testing.T) {
+	for _, tc := range []struct {
+		msg    string
+		config *MetricConfig
+	}{
+		{
+			msg:    "drop-labels and keep-labels",
+			config: labelFilterConfig(map[string]string{"drop-labels": "pod_uid", "keep-labels": "app"}),
+		},
+		{
+			msg:    "empty label list",
+			config: labelFilterConfig(map[string]string{"drop-labels": " , "}),
+		},
+		{
+			msg:    "dropping a label of the selector",
+			config: labelFilterConfig(map[string]string{"drop-labels": "type"}),
+		},
+		{
+			msg:    "not keeping a label of the selector",
+			config: labelFilterConfig(map[string]string{"keep-labels": "app"}),
+		},
+		{
+			msg: "dropping a label required by a selector expression",
+			config: func() *MetricConfig {
+				config := labelFilterConfig(map[string]string{"drop-labels": "app"})
+				config.Metric.Selector.MatchExpressions = []metav1.LabelSelectorRequirement{
+					{Key: "app", Operator: metav1.LabelSelectorOpExists},
+				}
+				return config
+			}(),
+		},
+		{
+			msg: "non-external metric",
+			config: func() *MetricConfig {
+				config := labelFilterConfig(map[string]string{"drop-labels": "pod_uid"})
+				config.Type = autoscalingv2.PodsMetricSourceType
+				return config
+			}(),
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			_, err := NewLabelFilterCollector(&FakeCollector{}, tc.config)
+			require.ErrorIs(t, err, ErrPermanentConfig)
+		})
+	}
+}
+
+func TestNewCollectorLabelFilter(t *testing.T) {
+	factory := NewCollectorFactory()
+	factory.RegisterExternalCollector([]string{"prometheus"}, &FakeCollectorPlugin{
+		metrics: []CollectedMetric{
+			labeledExternalMetric(map[string]string{"type": "prometheus", "pod_uid": "1234"}),
+		},
+	})
+
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{}
+	c, err := factory.NewCollector(context.Background(), hpa, labelFilterConfig(map[string]string{"drop-labels": "pod_uid"}), 0)
+	require.NoError(t, err)
+	require.IsType(t, &LabelFilterCollector{}, c)
+
+	metrics, err := c.GetMetrics(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"type": "prometheus"}, metrics[0].External.MetricLabels)
+
+	_, err = factory.NewCollector(context.Background(), hpa, labelFilterConfig(map[string]string{"drop-labels": "pod_uid", "keep-labels": "type"}), 0)
+	require.ErrorIs(t, err, ErrPermanentConfig)
+}
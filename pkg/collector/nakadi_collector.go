@@ -18,6 +18,7 @@ const (
 	NakadiMetricType                   = "nakadi"
 	nakadiSubscriptionIDKey            = "subscription-id"
 	nakadiMetricTypeKey                = "metric-type"
+	nakadiUnassignedPartitionsKey      = "unassigned-partitions"
 	nakadiMetricTypeConsumerLagSeconds = "consumer-lag-seconds"
 	nakadiMetricTypeUnconsumedEvents   = "unconsumed-events"
 )
@@ -47,69 +48,77 @@ type NakadiCollector struct {
 	interval         time.Duration
 	subscriptionID   string
 	nakadiMetricType string
+	unassigned       nakadi.UnassignedPartitions
 	metric           autoscalingv2.MetricIdentifier
-	metricType       autoscalingv2.MetricSourceType
 	namespace        string
+	timeout          time.Duration
 }
 
 // NewNakadiCollector initializes a new NakadiCollector.
-func NewNakadiCollector(_ context.Context, nakadi nakadi.Nakadi, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (*NakadiCollector, error) {
+func NewNakadiCollector(_ context.Context, nakadiClient nakadi.Nakadi, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (*NakadiCollector, error) {
 	if config.Metric.Selector == nil {
-		return nil, fmt.Errorf("selector for nakadi is not specified")
+		return nil, NewPermanentConfigError(fmt.Errorf("selector for nakadi is not specified"))
 	}
 
-	subscriptionID, ok := config.Config[nakadiSubscriptionIDKey]
-	if !ok {
-		return nil, fmt.Errorf("subscription-id not specified on metric")
+	var subscriptionID, metricType, unassignedPolicy string
+	b := config.binder()
+	b.RequiredString(nakadiSubscriptionIDKey, &subscriptionID)
+	if b.Has(nakadiMetricTypeKey) {
+		b.Enum(nakadiMetricTypeKey, &metricType, nakadiMetricTypeConsumerLagSeconds, nakadiMetricTypeUnconsumedEvents)
+	} else {
+		b.Missing(nakadiMetricTypeKey)
 	}
 
-	metricType, ok := config.Config[nakadiMetricTypeKey]
-	if !ok {
-		return nil, fmt.Errorf("metric-type not specified on metric")
+	unassignedPolicy = string(nakadi.UnassignedPartitionsMax)
+	b.Enum(nakadiUnassignedPartitionsKey, &unassignedPolicy, string(nakadi.UnassignedPartitionsIgnore), string(nakadi.UnassignedPartitionsMax), string(nakadi.UnassignedPartitionsError))
+	if err := finishBinding(b, hpa); err != nil {
+		return nil, err
 	}
+	unassigned := nakadi.UnassignedPartitions(unassignedPolicy)
 
-	if metricType != nakadiMetricTypeConsumerLagSeconds && metricType != nakadiMetricTypeUnconsumedEvents {
-		return nil, fmt.Errorf("metric-type must be either '%s' or '%s', was '%s'", nakadiMetricTypeConsumerLagSeconds, nakadiMetricTypeUnconsumedEvents, metricType)
+	timeout, err := parseRequestTimeout(config)
+	if err != nil {
+		return nil, err
 	}
 
 	return &NakadiCollector{
-		nakadi:           nakadi,
+		nakadi:           nakadiClient,
+		unassigned:       unassigned,
 		interval:         interval,
 		subscriptionID:   subscriptionID,
 		nakadiMetricType: metricType,
 		metric:           config.Metric,
-		metricType:       config.Type,
 		namespace:        hpa.Namespace,
+		timeout:          timeout,
 	}, nil
 }
 
 // GetMetrics returns a list of collected metrics for the Nakadi subscription ID.
 func (c *NakadiCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, error) {
+	ctx, cancel := withRequestTimeout(ctx, c.timeout)
+	defer cancel()
+
 	var value int64
 	var err error
 	switch c.nakadiMetricType {
 	case nakadiMetricTypeConsumerLagSeconds:
-		value, err = c.nakadi.ConsumerLagSeconds(ctx, c.subscriptionID)
+		value, err = c.nakadi.ConsumerLagSeconds(ctx, c.subscriptionID, c.unassigned)
 		if err != nil {
-			return nil, err
+			return nil, NewTransientError(err)
 		}
 	case nakadiMetricTypeUnconsumedEvents:
 		value, err = c.nakadi.UnconsumedEvents(ctx, c.subscriptionID)
 		if err != nil {
-			return nil, err
+			return nil, NewTransientError(err)
 		}
 	}
 
-	metricValue := CollectedMetric{
-		Namespace: c.namespace,
-		Type:      c.metricType,
-		External: external_metrics.ExternalMetricValue{
-			MetricName:   c.metric.Name,
-			MetricLabels: c.metric.Selector.MatchLabels,
-			Timestamp:    metav1.Now(),
-			Value:        *resource.NewQuantity(value, resource.DecimalSI),
-		},
-	}
+	metricValue := NewExternalMetric(c.namespace, external_metrics.ExternalMetricValue{
+		MetricName:   c.metric.Name,
+		MetricLabels: c.metric.Selector.MatchLabels,
+		Timestamp:    metav1.Now(),
+		Value:        *resource.NewQuantity(value, resource.DecimalSI),
+	})
 
 	return []CollectedMetric{metricValue}, nil
 }
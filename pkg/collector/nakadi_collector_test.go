@@ -0,0 +1,41 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/nakadi"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+)
+
+func TestNakadiCollectorTimeout(t *testing.T) {
+	server := newStalledServer(t)
+
+	config := &MetricConfig{
+		MetricTypeName: MetricTypeName{
+			Metric: newMetricIdentifier("lag", NakadiMetricType),
+		},
+		Config: map[string]string{
+			nakadiSubscriptionIDKey: "subscription",
+			nakadiMetricTypeKey:     nakadiMetricTypeUnconsumedEvents,
+			requestTimeoutKey:       "100ms",
+		},
+	}
+
+	nakadiClient := nakadi.NewNakadiClient(server.URL, http.DefaultClient)
+	nakadiCollector, err := NewNakadiCollector(context.Background(), nakadiClient, &autoscalingv2.HorizontalPodAutoscaler{}, config, 1*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, 100*time.Millisecond, nakadiCollector.timeout)
+
+	start := time.Now()
+	_, err = nakadiCollector.GetMetrics(context.Background())
+	require.ErrorIs(t, err, ErrTransient)
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	config.Config[requestTimeoutKey] = "-1s"
+	_, err = NewNakadiCollector(context.Background(), nakadiClient, &autoscalingv2.HorizontalPodAutoscaler{}, config, 1*time.Minute)
+	require.ErrorIs(t, err, ErrPermanentConfig)
+}
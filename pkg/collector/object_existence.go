@@ -0,0 +1,51 @@
+package collector
+
+import (
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/metrics/pkg/apis/custom_metrics"
+
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/annotations"
+)
+
+// skipExistenceCheckConfigKey disables checking that the object described
+// by an Object metric exists when the collector is created, e.g. for
+// objects created after the HPA.
+const skipExistenceCheckConfigKey = "skip-existence-check"
+
+// ObjectNotFoundError is returned when the object described by an Object
+// metric doesn't exist in the namespace of the HPA when the collector is
+// created.
+type ObjectNotFoundError struct {
+	Kind      string
+	Name      string
+	Namespace string
+}
+
+func (e *ObjectNotFoundError) Error() string {
+	return fmt.Sprintf("object '%s' of kind %s not found in namespace %s, set %s: \"true\" if it's created later", e.Name, e.Kind, e.Namespace, skipExistenceCheckConfigKey)
+}
+
+// bindSkipExistenceCheck binds the skip-existence-check config key.
+func bindSkipExistenceCheck(b *annotations.ConfigBinder) bool {
+	var skip bool
+	b.Bool(skipExistenceCheckConfigKey, &skip)
+	return skip
+}
+
+// newObjectNotFoundError returns the ObjectNotFoundError of the object as
+// permanent config error. The collector is created again with the next
+// update of the HPAs, so it's picked up once the object exists.
+func newObjectNotFoundError(ref custom_metrics.ObjectReference) error {
+	return NewPermanentConfigError(&ObjectNotFoundError{Kind: ref.Kind, Name: ref.Name, Namespace: ref.Namespace})
+}
+
+// objectLookupError classifies the error of getting the object described by
+// an Object metric from the Kubernetes API.
+func objectLookupError(err error, ref custom_metrics.ObjectReference) error {
+	if apierrors.IsNotFound(err) {
+		return newObjectNotFoundError(ref)
+	}
+	return NewTransientError(err)
+}
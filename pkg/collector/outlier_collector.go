@@ -0,0 +1,144 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	outlierFilterConfigKey    = "outlier-filter"
+	outlierWindowConfigKey    = "outlier-window"
+	outlierThresholdConfigKey = "outlier-threshold"
+
+	// OutlierFilterMAD rejects values deviating from the median of the
+	// previous values by more than the threshold times their median
+	// absolute deviation.
+	OutlierFilterMAD = "mad"
+
+	defaultOutlierWindow    = 10
+	defaultOutlierThreshold = 6
+	maxOutlierWindow        = 1000
+
+	// minMADFraction is the lower bound of the MAD as fraction of the
+	// absolute median, so a flat history still accepts small changes.
+	minMADFraction = 0.05
+	// minMAD is the lower bound of the MAD for a median of 0. It's the
+	// resolution of the milli quantities values are stored as.
+	minMAD = 0.001
+)
+
+var (
+	// OutlierRejections is the number of collections rejected because a
+	// collected value was an outlier.
+	OutlierRejections = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kube_metrics_adapter_outlier_rejections_total",
+		Help: "The total number of collections rejected because of an outlier value",
+	})
+)
+
+// OutlierCollector wraps a collector rejecting collections with values
+// which deviate too much from the values of the previous collections. The
+// previous values are kept in memory per metric, so the filter starts over
+// when the collector is recreated, e.g. because the HPA changed.
+type OutlierCollector struct {
+	collector Collector
+	window    int
+	threshold float64
+	previous  map[string][]float64
+	sync.Mutex
+}
+
+// NewOutlierCollector initializes a new OutlierCollector from the
+// outlier-filter, outlier-window and outlier-threshold config.
+func NewOutlierCollector(collector Collector, config *MetricConfig) (*OutlierCollector, error) {
+	c := &OutlierCollector{
+		collector: collector,
+		window:    defaultOutlierWindow,
+		threshold: defaultOutlierThreshold,
+		previous:  map[string][]float64{},
+	}
+
+	var filter string
+	b := config.binder()
+	b.Enum(outlierFilterConfigKey, &filter, OutlierFilterMAD)
+	b.Int(outlierWindowConfigKey, &c.window, 3, maxOutlierWindow)
+	if b.Float(outlierThresholdConfigKey, &c.threshold) && c.threshold <= 0 {
+		b.Invalid(outlierThresholdConfigKey, "must be greater than 0")
+	}
+	if err := b.Err(); err != nil {
+		return nil, NewPermanentConfigError(err)
+	}
+
+	return c, nil
+}
+
+// GetMetrics collects the metrics of the wrapped collector. It returns an
+// outlier error if any of the values deviates from the median of the
+// previous values of its metric by more than threshold MADs. Values are only
+// rejected once a full window of previous values is known. Rejected values
+// are part of the window of the following collections, so a lasting change
+// of the level is accepted once it makes up half of the window. The previous
+// values of metrics which are no longer collected are dropped.
+func (c *OutlierCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, error) {
+	metrics, err := c.collector.GetMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	previousValues := c.previous
+	c.previous = make(map[string][]float64, len(metrics))
+	var outlier error
+	for _, metric := range metrics {
+		key := deriveKey(metric)
+		value := collectedSample(metric).value
+		previous := previousValues[key]
+		if outlier == nil && len(previous) == c.window {
+			if deviations := madDeviations(previous, value); deviations > c.threshold {
+				outlier = fmt.Errorf("value %v of metric %s deviates %.1f MADs from the median of the last %d values, more than the %s of %v", value, key, deviations, c.window, outlierThresholdConfigKey, c.threshold)
+			}
+		}
+
+		previous = append(previous, value)
+		if len(previous) > c.window {
+			previous = previous[len(previous)-c.window:]
+		}
+		c.previous[key] = previous
+	}
+
+	if outlier != nil {
+		OutlierRejections.Inc()
+		return nil, NewOutlierError(outlier)
+	}
+
+	return metrics, nil
+}
+
+// madDeviations returns the number of median absolute deviations the value
+// is away from the median of the previous values. The MAD is at least
+// minMADFraction of the absolute median and at least minMAD, otherwise any
+// change of previous values which don't deviate at all would be infinitely
+// many deviations away.
+func madDeviations(previous []float64, value float64) float64 {
+	m := median(previous)
+	deviations := make([]float64, 0, len(previous))
+	for _, v := range previous {
+		deviations = append(deviations, math.Abs(v-m))
+	}
+
+	mad := math.Max(median(deviations), math.Max(math.Abs(m)*minMADFraction, minMAD))
+	return math.Abs(value-m) / mad
+}
+
+// Interval returns the interval of the wrapped collector.
+func (c *OutlierCollector) Interval() time.Duration {
+	return c.collector.Interval()
+}
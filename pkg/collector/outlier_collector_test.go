@@ -0,0 +1,186 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutlierCollector(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		msg      string
+		config   map[string]string
+		values   []int64
+		rejected []bool
+	}{
+		{
+			msg:      "spike is rejected and the filter recovers",
+			config:   map[string]string{"outlier-window": "5"},
+			values:   []int64{10, 11, 9, 10, 12, 10000, 10, 11},
+			rejected: []bool{false, false, false, false, false, true, false, false},
+		},
+		{
+			msg:      "values aren't filtered before the window is full",
+			config:   map[string]string{"outlier-window": "5"},
+			values:   []int64{10, 10000, 10},
+			rejected: []bool{false, false, false},
+		},
+		{
+			msg:      "lasting change of the level is accepted",
+			config:   map[string]string{"outlier-window": "5"},
+			values:   []int64{10, 11, 9, 10, 12, 1000, 1000, 1000, 1000, 1010},
+			rejected: []bool{false, false, false, false, false, true, true, true, false, false},
+		},
+		{
+			msg:      "constant values accept small changes",
+			config:   map[string]string{"outlier-window": "3"},
+			values:   []int64{100, 100, 100, 120},
+			rejected: []bool{false, false, false, false},
+		},
+		{
+			msg:      "constant values reject large changes",
+			config:   map[string]string{"outlier-window": "3"},
+			values:   []int64{100, 100, 100, 200},
+			rejected: []bool{false, false, false, true},
+		},
+		{
+			msg:      "constant zero rejects changes",
+			config:   map[string]string{"outlier-window": "3"},
+			values:   []int64{0, 0, 0, 1},
+			rejected: []bool{false, false, false, true},
+		},
+		{
+			msg:      "deviation within the threshold",
+			config:   map[string]string{"outlier-window": "5"},
+			values:   []int64{10, 12, 8, 10, 12, 20},
+			rejected: []bool{false, false, false, false, false, false},
+		},
+		{
+			msg:      "deviation beyond a lower threshold",
+			config:   map[string]string{"outlier-window": "5", "outlier-threshold": "4"},
+			values:   []int64{10, 12, 8, 10, 12, 20},
+			rejected: []bool{false, false, false, false, false, true},
+		},
+		{
+			msg:      "default window",
+			config:   map[string]string{},
+			values:   []int64{10, 11, 9, 10, 12, 10000, 10, 11, 9, 10, 12, 10000},
+			rejected: []bool{false, false, false, false, false, false, false, false, false, false, false, true},
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			values := tc.values
+			inner := makeCollectorWithStub(func() ([]CollectedMetric, error) {
+				value := values[0]
+				values = values[1:]
+				return externalSample(value, start), nil
+			})
+
+			config := map[string]string{"outlier-filter": "mad"}
+			for k, v := range tc.config {
+				config[k] = v
+			}
+			collector, err := NewOutlierCollector(inner, &MetricConfig{Config: config})
+			require.NoError(t, err)
+
+			for i, rejected := range tc.rejected {
+				before := testutil.ToFloat64(OutlierRejections)
+				metrics, err := collector.GetMetrics(context.Background())
+				if rejected {
+					require.ErrorIs(t, err, ErrOutlier, "collection %d", i)
+					require.ErrorIs(t, err, ErrTransient)
+					require.Equal(t, before+1, testutil.ToFloat64(OutlierRejections))
+					continue
+				}
+				require.NoError(t, err, "collection %d", i)
+				require.Len(t, metrics, 1)
+				require.Equal(t, tc.values[i], metrics[0].External.Value.Value())
+				require.Equal(t, before, testutil.ToFloat64(OutlierRejections))
+			}
+		})
+	}
+}
+
+func TestOutlierCollectorTracksMetricsSeparately(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	external := []int64{10, 11, 9, 1000}
+	custom := []int64{1000, 1100, 900, 1000}
+	inner := makeCollectorWithStub(func() ([]CollectedMetric, error) {
+		metrics := append(externalSample(external[0], start), customSample("a", custom[0])...)
+		external, custom = external[1:], custom[1:]
+		return metrics, nil
+	})
+
+	collector, err := NewOutlierCollector(inner, &MetricConfig{Config: map[string]string{"outlier-filter": "mad", "outlier-window": "3"}})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err = collector.GetMetrics(context.Background())
+		require.NoError(t, err)
+	}
+
+	// the external value is an outlier, while the same value of the
+	// custom metric isn't.
+	_, err = collector.GetMetrics(context.Background())
+	require.ErrorIs(t, err, ErrOutlier)
+}
+
+func TestOutlierCollectorDropsVanishedMetrics(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	samples := [][]CollectedMetric{
+		append(externalSample(10, start), customSample("a", 10)...),
+		customSample("a", 11),
+	}
+	inner := makeCollectorWithStub(func() ([]CollectedMetric, error) {
+		s := samples[0]
+		samples = samples[1:]
+		return s, nil
+	})
+
+	collector, err := NewOutlierCollector(inner, &MetricConfig{Config: map[string]string{"outlier-filter": "mad", "outlier-window": "3"}})
+	require.NoError(t, err)
+
+	_, err = collector.GetMetrics(context.Background())
+	require.NoError(t, err)
+	require.Len(t, collector.previous, 2)
+
+	_, err = collector.GetMetrics(context.Background())
+	require.NoError(t, err)
+	require.Len(t, collector.previous, 1)
+	require.Equal(t, []float64{10, 11}, collector.previous[deriveKey(customSample("a", 0)[0])])
+}
+
+func TestNewOutlierCollectorInvalidConfig(t *testing.T) {
+	for _, tc := range []struct {
+		msg    string
+		config map[string]string
+		err    string
+	}{
+		{
+			msg:    "unknown filter",
+			config: map[string]string{"outlier-filter": "zscore"},
+			err:    "invalid value 'zscore' of config key 'outlier-filter': must be one of mad",
+		},
+		{
+			msg:    "window too small",
+			config: map[string]string{"outlier-filter": "mad", "outlier-window": "2"},
+			err:    "invalid value '2' of config key 'outlier-window': must be between 3 and 1000",
+		},
+		{
+			msg:    "non positive threshold",
+			config: map[string]string{"outlier-filter": "mad", "outlier-threshold": "0"},
+			err:    "invalid value '0' of config key 'outlier-threshold': must be greater than 0",
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			_, err := NewOutlierCollector(&FakeCollector{}, &MetricConfig{Config: tc.config})
+			require.ErrorIs(t, err, ErrPermanentConfig)
+			require.EqualError(t, err, tc.err)
+		})
+	}
+}
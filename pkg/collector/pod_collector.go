@@ -2,6 +2,7 @@ package collector
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -9,18 +10,42 @@ import (
 	log "github.com/sirupsen/logrus"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/metrics/pkg/apis/custom_metrics"
 
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/annotations"
 	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector/httpmetrics"
 )
 
+const (
+	// PrometheusExpositionCollectorType is the collector type of pod
+	// metrics scraped from an endpoint exposing the Prometheus text format.
+	PrometheusExpositionCollectorType = "prometheus-exposition"
+
+	// emitAggregateConfigKey is the pod collector config key enabling an
+	// Object metric of the HPA scale target aggregating the pod values.
+	emitAggregateConfigKey = "emit-aggregate"
+	// aggregateOnlyConfigKey is the pod collector config key disabling
+	// the per-pod metrics if the aggregate is emitted.
+	aggregateOnlyConfigKey = "aggregate-only"
+
+	aggregateSum = "sum"
+	aggregateMax = "max"
+	aggregateAvg = "avg"
+)
+
+// ErrArgoRolloutsNotAvailable is returned for HPAs targeting an Argo Rollout
+// if the support for Argo Rollouts is disabled or its CRD is not installed.
+var ErrArgoRolloutsNotAvailable = NewPermanentConfigError(errors.New("Argo Rollouts support not available in this cluster"))
+
 type PodCollectorPlugin struct {
 	client             kubernetes.Interface
 	argoRolloutsClient argoRolloutsClient.Interface
+	pushBuffer         *PushBuffer
 }
 
 func NewPodCollectorPlugin(client kubernetes.Interface, argoRolloutsClient argoRolloutsClient.Interface) *PodCollectorPlugin {
@@ -30,8 +55,20 @@ func NewPodCollectorPlugin(client kubernetes.Interface, argoRolloutsClient argoR
 	}
 }
 
+// SetPushBuffer sets the buffer of the values pushed by pods read by the
+// pushed collector type. Without a buffer the pushed type isn't supported.
+func (p *PodCollectorPlugin) SetPushBuffer(buffer *PushBuffer) {
+	p.pushBuffer = buffer
+}
+
 func (p *PodCollectorPlugin) NewCollector(ctx context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
-	return NewPodCollector(ctx, p.client, p.argoRolloutsClient, hpa, config, interval)
+	return newPodCollector(ctx, p.client, p.argoRolloutsClient, p.pushBuffer, hpa, config, interval)
+}
+
+// collectionScopedGetter is implemented by pod metrics getters which
+// return a getter for the pods of a single collection.
+type collectionScopedGetter interface {
+	ForCollection() httpmetrics.PodMetricsGetter
 }
 
 type PodCollector struct {
@@ -44,13 +81,20 @@ type PodCollector struct {
 	minPodReadyAge   time.Duration
 	interval         time.Duration
 	logger           *log.Entry
+	scaleTarget      autoscalingv2.CrossVersionObjectReference
+	aggregate        string
+	aggregateOnly    bool
 }
 
 func NewPodCollector(ctx context.Context, client kubernetes.Interface, argoRolloutsClient argoRolloutsClient.Interface, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (*PodCollector, error) {
+	return newPodCollector(ctx, client, argoRolloutsClient, nil, hpa, config, interval)
+}
+
+func newPodCollector(ctx context.Context, client kubernetes.Interface, argoRolloutsClient argoRolloutsClient.Interface, pushBuffer *PushBuffer, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (*PodCollector, error) {
 	// get pod selector based on HPA scale target ref
 	selector, err := getPodLabelSelector(ctx, client, argoRolloutsClient, hpa)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get pod label selector: %v", err)
+		return nil, fmt.Errorf("failed to get pod label selector: %w", err)
 	}
 
 	c := &PodCollector{
@@ -62,18 +106,49 @@ func NewPodCollector(ctx context.Context, client kubernetes.Interface, argoRollo
 		interval:         interval,
 		podLabelSelector: selector,
 		logger:           log.WithFields(log.Fields{"Collector": "Pod"}),
+		scaleTarget:      hpa.Spec.ScaleTargetRef,
+	}
+
+	b := config.binder()
+	err = c.parseAggregateConfig(config, b)
+	if err != nil {
+		return nil, NewPermanentConfigError(err)
 	}
 
 	var getter httpmetrics.PodMetricsGetter
 	switch config.CollectorType {
 	case "json-path":
 		var err error
-		getter, err = httpmetrics.NewPodMetricsJSONPathGetter(config.Config)
+		getter, err = httpmetrics.BindPodMetricsJSONPathGetter(b)
 		if err != nil {
+			return nil, NewPermanentConfigError(err)
+		}
+		if err := finishBinding(b, hpa); err != nil {
 			return nil, err
 		}
+	case PrometheusExpositionCollectorType:
+		var err error
+		getter, err = httpmetrics.NewPodMetricsExpositionGetter(config.Config)
+		if err != nil {
+			return nil, NewPermanentConfigError(err)
+		}
+	case KubeletCollectorType:
+		var err error
+		getter, err = NewKubeletSummaryGetter(client.CoreV1().RESTClient(), config.Config)
+		if err != nil {
+			return nil, NewPermanentConfigError(err)
+		}
+	case PushedCollectorType:
+		if pushBuffer == nil {
+			return nil, NewPermanentConfigError(fmt.Errorf("collector type '%s' requires the push receiver to be enabled", PushedCollectorType))
+		}
+		var err error
+		getter, err = NewPushedGetter(pushBuffer, config.Metric.Name, config.Config)
+		if err != nil {
+			return nil, NewPermanentConfigError(err)
+		}
 	default:
-		return nil, fmt.Errorf("format '%s' not supported", config.CollectorType)
+		return nil, NewPermanentConfigError(fmt.Errorf("format '%s' not supported", config.CollectorType))
 	}
 
 	c.Getter = getter
@@ -81,6 +156,30 @@ func NewPodCollector(ctx context.Context, client kubernetes.Interface, argoRollo
 	return c, nil
 }
 
+// parseAggregateConfig parses the emit-aggregate and aggregate-only config.
+// Object metrics must describe the scale target of the HPA and only emit the
+// aggregate.
+func (c *PodCollector) parseAggregateConfig(config *MetricConfig, b *annotations.ConfigBinder) error {
+	b.Enum(emitAggregateConfigKey, &c.aggregate, aggregateSum, aggregateMax, aggregateAvg)
+	b.Bool(aggregateOnlyConfigKey, &c.aggregateOnly)
+	if err := b.Err(); err != nil {
+		return err
+	}
+
+	if config.Type == autoscalingv2.ObjectMetricSourceType {
+		if config.ObjectReference.Kind != c.scaleTarget.Kind || config.ObjectReference.Name != c.scaleTarget.Name {
+			return fmt.Errorf("object metric must describe the scale target %s/%s of the HPA", c.scaleTarget.Kind, c.scaleTarget.Name)
+		}
+		c.aggregateOnly = true
+	}
+
+	if c.aggregateOnly && c.aggregate == "" {
+		return fmt.Errorf("%s must be set to emit only the aggregate", emitAggregateConfigKey)
+	}
+
+	return nil
+}
+
 func (c *PodCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, error) {
 	opts := metav1.ListOptions{
 		LabelSelector: labels.Set(c.podLabelSelector.MatchLabels).String(),
@@ -88,7 +187,14 @@ func (c *PodCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, error
 
 	pods, err := c.client.CoreV1().Pods(c.namespace).List(ctx, opts)
 	if err != nil {
-		return nil, err
+		return nil, NewTransientError(err)
+	}
+
+	// getters sharing requests between the pods of a collection, like
+	// the kubelet stats summary of a node, are scoped to the collection.
+	getter := c.Getter
+	if scoped, ok := getter.(collectionScopedGetter); ok {
+		getter = scoped.ForCollection()
 	}
 
 	ch := make(chan CollectedMetric)
@@ -107,7 +213,7 @@ func (c *PodCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, error
 				skippedPodsCount++
 				c.logger.Warnf("Skipping metrics collection for pod %s/%s because it's ready age is %s and min-pod-ready-age is set to %s", pod.Namespace, pod.Name, podReadyAge, c.minPodReadyAge)
 			} else {
-				go c.getPodMetric(pod, ch, errCh)
+				go c.getPodMetric(getter, pod, ch, errCh)
 			}
 		} else {
 			skippedPodsCount++
@@ -125,35 +231,82 @@ func (c *PodCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, error
 		}
 	}
 
-	return values, nil
+	if c.aggregate == "" {
+		return values, nil
+	}
+
+	// don't emit an aggregate of zero if no pod value could be
+	// collected.
+	if len(values) == 0 {
+		if c.aggregateOnly {
+			return nil, nil
+		}
+		return values, nil
+	}
+
+	aggregate := c.aggregateMetric(values)
+	if c.aggregateOnly {
+		return []CollectedMetric{aggregate}, nil
+	}
+
+	return append(values, aggregate), nil
+}
+
+// aggregateMetric aggregates the pod values into an Object metric describing
+// the scale target of the HPA.
+func (c *PodCollector) aggregateMetric(values []CollectedMetric) CollectedMetric {
+	var aggregate int64
+	for i, value := range values {
+		milli := value.Custom.Value.MilliValue()
+		switch c.aggregate {
+		case aggregateMax:
+			if i == 0 || milli > aggregate {
+				aggregate = milli
+			}
+		default:
+			aggregate += milli
+		}
+	}
+
+	if c.aggregate == aggregateAvg {
+		aggregate /= int64(len(values))
+	}
+
+	return NewCustomMetric(autoscalingv2.ObjectMetricSourceType, custom_metrics.MetricValue{
+		DescribedObject: custom_metrics.ObjectReference{
+			APIVersion: c.scaleTarget.APIVersion,
+			Kind:       c.scaleTarget.Kind,
+			Name:       c.scaleTarget.Name,
+			Namespace:  c.namespace,
+		},
+		Metric:    custom_metrics.MetricIdentifier{Name: c.metric.Name, Selector: c.metric.Selector},
+		Timestamp: metav1.Time{Time: time.Now().UTC()},
+		Value:     *resource.NewMilliQuantity(aggregate, resource.DecimalSI),
+	})
 }
 
 func (c *PodCollector) Interval() time.Duration {
 	return c.interval
 }
 
-func (c *PodCollector) getPodMetric(pod corev1.Pod, ch chan CollectedMetric, errCh chan error) {
-	value, err := c.Getter.GetMetric(&pod)
+func (c *PodCollector) getPodMetric(getter httpmetrics.PodMetricsGetter, pod corev1.Pod, ch chan CollectedMetric, errCh chan error) {
+	value, err := getter.GetMetric(&pod)
 	if err != nil {
 		errCh <- fmt.Errorf("Failed to get metrics from pod '%s/%s': %v", pod.Namespace, pod.Name, err)
 		return
 	}
 
-	ch <- CollectedMetric{
-		Namespace: c.namespace,
-		Type:      c.metricType,
-		Custom: custom_metrics.MetricValue{
-			DescribedObject: custom_metrics.ObjectReference{
-				APIVersion: "v1",
-				Kind:       "Pod",
-				Name:       pod.Name,
-				Namespace:  pod.Namespace,
-			},
-			Metric:    custom_metrics.MetricIdentifier{Name: c.metric.Name, Selector: c.podLabelSelector},
-			Timestamp: metav1.Time{Time: time.Now().UTC()},
-			Value:     *resource.NewMilliQuantity(int64(value*1000), resource.DecimalSI),
+	ch <- NewCustomMetric(c.metricType, custom_metrics.MetricValue{
+		DescribedObject: custom_metrics.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Pod",
+			Name:       pod.Name,
+			Namespace:  pod.Namespace,
 		},
-	}
+		Metric:    custom_metrics.MetricIdentifier{Name: c.metric.Name, Selector: c.podLabelSelector},
+		Timestamp: metav1.Time{Time: time.Now().UTC()},
+		Value:     *resource.NewMilliQuantity(int64(value*1000), resource.DecimalSI),
+	})
 }
 
 func getPodLabelSelector(ctx context.Context, client kubernetes.Interface, argoRolloutsClient argoRolloutsClient.Interface, hpa *autoscalingv2.HorizontalPodAutoscaler) (*metav1.LabelSelector, error) {
@@ -161,24 +314,32 @@ func getPodLabelSelector(ctx context.Context, client kubernetes.Interface, argoR
 	case "Deployment":
 		deployment, err := client.AppsV1().Deployments(hpa.Namespace).Get(ctx, hpa.Spec.ScaleTargetRef.Name, metav1.GetOptions{})
 		if err != nil {
-			return nil, err
+			return nil, NewTransientError(err)
 		}
 		return deployment.Spec.Selector, nil
 	case "StatefulSet":
 		sts, err := client.AppsV1().StatefulSets(hpa.Namespace).Get(ctx, hpa.Spec.ScaleTargetRef.Name, metav1.GetOptions{})
 		if err != nil {
-			return nil, err
+			return nil, NewTransientError(err)
 		}
 		return sts.Spec.Selector, nil
 	case "Rollout":
+		if argoRolloutsClient == nil {
+			return nil, ErrArgoRolloutsNotAvailable
+		}
 		rollout, err := argoRolloutsClient.ArgoprojV1alpha1().Rollouts(hpa.Namespace).Get(ctx, hpa.Spec.ScaleTargetRef.Name, metav1.GetOptions{})
 		if err != nil {
-			return nil, err
+			// a missing resource type, unlike a missing rollout, is
+			// reported without the name of the object.
+			if status, ok := err.(apierrors.APIStatus); ok && apierrors.IsNotFound(err) && (status.Status().Details == nil || status.Status().Details.Name == "") {
+				return nil, ErrArgoRolloutsNotAvailable
+			}
+			return nil, NewTransientError(err)
 		}
 		return rollout.Spec.Selector, nil
 	}
 
-	return nil, fmt.Errorf("unable to get pod label selector for scale target ref '%s'", hpa.Spec.ScaleTargetRef.Kind)
+	return nil, NewPermanentConfigError(fmt.Errorf("unable to get pod label selector for scale target ref '%s'", hpa.Spec.ScaleTargetRef.Kind))
 }
 
 // GetPodReadyAge extracts corev1.PodReady condition from the given pod object and
@@ -12,14 +12,19 @@ import (
 	"time"
 
 	argorolloutsv1alpha1 "github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	argoRolloutsClient "github.com/argoproj/argo-rollouts/pkg/client/clientset/versioned"
 	argorolloutsfake "github.com/argoproj/argo-rollouts/pkg/client/clientset/versioned/fake"
 	"github.com/stretchr/testify/require"
 	appsv1 "k8s.io/api/apps/v1"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
 )
 
 const (
@@ -70,6 +75,138 @@ func TestPodCollector(t *testing.T) {
 	}
 }
 
+func TestPodCollectorAggregate(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		config        map[string]string
+		metricType    autoscalingv2.MetricSourceType
+		podMetrics    int
+		expectedMilli int64
+	}{
+		{
+			name:          "sum",
+			config:        map[string]string{"emit-aggregate": "sum"},
+			podMetrics:    5,
+			expectedMilli: 19000,
+		},
+		{
+			name:          "max",
+			config:        map[string]string{"emit-aggregate": "max"},
+			podMetrics:    5,
+			expectedMilli: 8000,
+		},
+		{
+			name:          "avg",
+			config:        map[string]string{"emit-aggregate": "avg"},
+			podMetrics:    5,
+			expectedMilli: 3800,
+		},
+		{
+			name:          "aggregate only",
+			config:        map[string]string{"emit-aggregate": "sum", "aggregate-only": "true"},
+			expectedMilli: 19000,
+		},
+		{
+			name:          "object metric of the scale target",
+			config:        map[string]string{"emit-aggregate": "max"},
+			metricType:    autoscalingv2.ObjectMetricSourceType,
+			expectedMilli: 8000,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			plugin := NewPodCollectorPlugin(client, argorolloutsfake.NewSimpleClientset())
+			makeTestDeployment(t, client)
+			host, port, _ := makeTestHTTPServer(t, [][]int64{{1}, {3}, {8}, {5}, {2}})
+			podCondition := corev1.PodCondition{Type: corev1.PodReady, Status: corev1.ConditionTrue, LastTransitionTime: v1.NewTime(time.Now().Add(-30 * time.Second))}
+			makeTestPods(t, host, port, "test-metric", client, 5, podCondition, time.Time{})
+			testHPA := makeTestHPA(t, client)
+
+			testConfig := makeTestConfig(port, 0)
+			testConfig.Type = autoscalingv2.PodsMetricSourceType
+			testConfig.Metric = autoscalingv2.MetricIdentifier{Name: "test-metric"}
+			if tc.metricType != "" {
+				testConfig.Type = tc.metricType
+				testConfig.ObjectReference.Kind = "Deployment"
+				testConfig.ObjectReference.Name = testDeploymentName
+			}
+			for k, v := range tc.config {
+				testConfig.Config[k] = v
+			}
+
+			collector, err := plugin.NewCollector(context.Background(), testHPA, testConfig, testInterval)
+			require.NoError(t, err)
+			metrics, err := collector.GetMetrics(context.Background())
+			require.NoError(t, err)
+			require.Len(t, metrics, tc.podMetrics+1)
+
+			for _, m := range metrics[:tc.podMetrics] {
+				require.Equal(t, autoscalingv2.PodsMetricSourceType, m.Type)
+				require.Equal(t, "Pod", m.Custom.DescribedObject.Kind)
+			}
+
+			aggregate := metrics[tc.podMetrics]
+			require.Equal(t, autoscalingv2.ObjectMetricSourceType, aggregate.Type)
+			require.Equal(t, "Deployment", aggregate.Custom.DescribedObject.Kind)
+			require.Equal(t, "apps/v1", aggregate.Custom.DescribedObject.APIVersion)
+			require.Equal(t, testDeploymentName, aggregate.Custom.DescribedObject.Name)
+			require.Equal(t, testNamespace, aggregate.Custom.DescribedObject.Namespace)
+			require.Equal(t, "test-metric", aggregate.Custom.Metric.Name)
+			require.Equal(t, tc.expectedMilli, aggregate.Custom.Value.MilliValue())
+		})
+	}
+}
+
+func TestPodCollectorAggregateInvalidConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config map[string]string
+		object string
+	}{
+		{
+			name:   "unknown aggregate",
+			config: map[string]string{"emit-aggregate": "median"},
+		},
+		{
+			name:   "aggregate only without aggregate",
+			config: map[string]string{"aggregate-only": "true"},
+		},
+		{
+			name:   "invalid aggregate only",
+			config: map[string]string{"emit-aggregate": "sum", "aggregate-only": "yes please"},
+		},
+		{
+			name:   "object metric without aggregate",
+			object: testDeploymentName,
+		},
+		{
+			name:   "object metric of another object",
+			config: map[string]string{"emit-aggregate": "sum"},
+			object: "other",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			plugin := NewPodCollectorPlugin(client, argorolloutsfake.NewSimpleClientset())
+			makeTestDeployment(t, client)
+			testHPA := makeTestHPA(t, client)
+
+			testConfig := makeTestConfig("8080", 0)
+			if tc.object != "" {
+				testConfig.Type = autoscalingv2.ObjectMetricSourceType
+				testConfig.ObjectReference.Kind = "Deployment"
+				testConfig.ObjectReference.Name = tc.object
+			}
+			for k, v := range tc.config {
+				testConfig.Config[k] = v
+			}
+
+			_, err := plugin.NewCollector(context.Background(), testHPA, testConfig, testInterval)
+			require.ErrorIs(t, err, ErrPermanentConfig)
+		})
+	}
+}
+
 func TestPodCollectorWithMinPodReadyAge(t *testing.T) {
 	for _, tc := range []struct {
 		name    string
@@ -231,6 +368,47 @@ func TestPodCollectorWithRollout(t *testing.T) {
 	}
 }
 
+func TestPodCollectorRolloutNotAvailable(t *testing.T) {
+	missingCRD := argorolloutsfake.NewSimpleClientset()
+	missingCRD.PrependReactor("get", "rollouts", func(_ clienttesting.Action) (bool, runtime.Object, error) {
+		// the API server reports a missing resource type without details.
+		return true, nil, apierrors.NewGenericServerResponse(http.StatusNotFound, "get", schema.GroupResource{}, "", "", 0, false)
+	})
+
+	for _, tc := range []struct {
+		name               string
+		argoRolloutsClient argoRolloutsClient.Interface
+		expectedErr        error
+	}{
+		{
+			name:        "support disabled",
+			expectedErr: ErrArgoRolloutsNotAvailable,
+		},
+		{
+			name:               "CRD not installed",
+			argoRolloutsClient: missingCRD,
+			expectedErr:        ErrArgoRolloutsNotAvailable,
+		},
+		{
+			name:               "rollout not found",
+			argoRolloutsClient: argorolloutsfake.NewSimpleClientset(),
+			expectedErr:        ErrTransient,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			plugin := NewPodCollectorPlugin(client, tc.argoRolloutsClient)
+			testHPA := makeTestHPAForRollout(t, client)
+			_, err := plugin.NewCollector(context.Background(), testHPA, makeTestConfig("9090", 0), testInterval)
+			require.ErrorIs(t, err, tc.expectedErr)
+			if tc.expectedErr == ErrArgoRolloutsNotAvailable {
+				require.ErrorIs(t, err, ErrPermanentConfig)
+				require.Contains(t, err.Error(), "Argo Rollouts support not available in this cluster")
+			}
+		})
+	}
+}
+
 type testMetricResponse struct {
 	Values []int64 `json:"values"`
 }
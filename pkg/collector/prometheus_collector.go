@@ -5,14 +5,22 @@ import (
 	"fmt"
 	"math"
 	"net/http"
+	"regexp"
+	"strings"
+	"text/template"
 	"time"
 
+	argoRolloutsClient "github.com/argoproj/argo-rollouts/pkg/client/clientset/versioned"
 	"github.com/prometheus/client_golang/api"
 	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/model"
+	log "github.com/sirupsen/logrus"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/metrics/pkg/apis/custom_metrics"
 	"k8s.io/metrics/pkg/apis/external_metrics"
@@ -23,8 +31,131 @@ const (
 	PrometheusMetricNameLegacy    = "prometheus-query"
 	prometheusQueryNameLabelKey   = "query-name"
 	prometheusServerAnnotationKey = "prometheus-server"
+	prometheusPodLabel            = "pod"
+	prometheusPodPlaceholder      = "{pod}"
 )
 
+var (
+	// PrometheusPodsMissing is the number of pods of a Pods metric which
+	// were not part of the prometheus query result.
+	PrometheusPodsMissing = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kube_metrics_adapter_prometheus_pods_missing",
+		Help: "The total number of pods skipped because they were missing in the prometheus query result",
+	})
+	// PrometheusResultSamples is the number of samples in the result of
+	// the prometheus queries of an HPA.
+	PrometheusResultSamples = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kube_metrics_adapter_prometheus_result_samples",
+		Help:    "The number of samples in the result of a prometheus query by HPA",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+	}, []string{"namespace", "hpa"})
+	// PrometheusResultBytes is the size of the response of the prometheus
+	// queries of an HPA.
+	PrometheusResultBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kube_metrics_adapter_prometheus_result_bytes",
+		Help:    "The size in bytes of the response of a prometheus query by HPA",
+		Buckets: prometheus.ExponentialBuckets(256, 4, 10),
+	}, []string{"namespace", "hpa"})
+)
+
+// ForgetPrometheusResultMetrics removes the result metrics of a deleted HPA.
+func ForgetPrometheusResultMetrics(namespace, hpa string) {
+	PrometheusResultSamples.DeleteLabelValues(namespace, hpa)
+	PrometheusResultBytes.DeleteLabelValues(namespace, hpa)
+}
+
+// responseSizeKey is the context key of the response size recorded by the
+// responseSizeClient.
+type responseSizeKey struct{}
+
+// responseSizeClient records the size of the response body in the *int of
+// the request context, if any.
+type responseSizeClient struct {
+	api.Client
+}
+
+func (c responseSizeClient) Do(ctx context.Context, req *http.Request) (*http.Response, []byte, error) {
+	resp, body, err := c.Client.Do(ctx, req)
+	if size, ok := ctx.Value(responseSizeKey{}).(*int); ok {
+		*size = len(body)
+	}
+	return resp, body, err
+}
+
+// newPrometheusAPI returns the API of the prometheus server at the address.
+// The size of the responses is recorded for the result metrics.
+func newPrometheusAPI(address string) (promv1.API, error) {
+	promClient, err := api.NewClient(api.Config{
+		Address:      address,
+		RoundTripper: http.DefaultTransport,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return promv1.NewAPI(responseSizeClient{promClient}), nil
+}
+
+// prometheusQuerier runs the prometheus queries of an HPA and records the
+// size of their results.
+type prometheusQuerier struct {
+	promAPI          promv1.API
+	namespace        string
+	hpa              string
+	samplesThreshold int
+}
+
+// query runs the query and observes the number of samples of the result and
+// the size of the response. A warning is logged if the number of samples
+// exceeds the threshold, as such queries put a high load on prometheus.
+func (q *prometheusQuerier) query(ctx context.Context, query string) (model.Value, error) {
+	size := -1
+	value, _, err := q.promAPI.Query(context.WithValue(ctx, responseSizeKey{}, &size), query, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	samples := resultSamples(value)
+	PrometheusResultSamples.WithLabelValues(q.namespace, q.hpa).Observe(float64(samples))
+	if size >= 0 {
+		PrometheusResultBytes.WithLabelValues(q.namespace, q.hpa).Observe(float64(size))
+	}
+
+	if q.samplesThreshold > 0 && samples > q.samplesThreshold {
+		log.Warnf("Prometheus query of HPA %s/%s returned %d samples, more than the threshold of %d: %s", q.namespace, q.hpa, samples, q.samplesThreshold, query)
+	}
+
+	return value, nil
+}
+
+// sampleTime returns the timestamp of a prometheus sample, or the current
+// time if the sample has none. The samples of instant queries are stamped
+// with the evaluation time of the query, not the time of the underlying raw
+// samples, so the timestamp doesn't show a lagging source.
+func sampleTime(timestamp model.Time) metav1.Time {
+	if timestamp == 0 {
+		return metav1.Time{Time: time.Now().UTC()}
+	}
+	return metav1.Time{Time: timestamp.Time().UTC()}
+}
+
+// resultSamples returns the number of samples of a query result.
+func resultSamples(value model.Value) int {
+	switch v := value.(type) {
+	case model.Vector:
+		return len(v)
+	case model.Matrix:
+		samples := 0
+		for _, stream := range v {
+			samples += len(stream.Values) + len(stream.Histograms)
+		}
+		return samples
+	case *model.Scalar, *model.String:
+		return 1
+	default:
+		return 0
+	}
+}
+
 type NoResultError struct {
 	query string
 }
@@ -34,47 +165,67 @@ func (r NoResultError) Error() string {
 }
 
 type PrometheusCollectorPlugin struct {
-	promAPI promv1.API
-	client  kubernetes.Interface
+	promAPI            promv1.API
+	client             kubernetes.Interface
+	argoRolloutsClient argoRolloutsClient.Interface
+	samplesThreshold   int
 }
 
-func NewPrometheusCollectorPlugin(client kubernetes.Interface, prometheusServer string) (*PrometheusCollectorPlugin, error) {
-	cfg := api.Config{
-		Address:      prometheusServer,
-		RoundTripper: http.DefaultTransport,
-	}
-
-	promClient, err := api.NewClient(cfg)
+func NewPrometheusCollectorPlugin(client kubernetes.Interface, argoRolloutsClient argoRolloutsClient.Interface, prometheusServer string) (*PrometheusCollectorPlugin, error) {
+	promAPI, err := newPrometheusAPI(prometheusServer)
 	if err != nil {
 		return nil, err
 	}
 
 	return &PrometheusCollectorPlugin{
-		client:  client,
-		promAPI: promv1.NewAPI(promClient),
+		client:             client,
+		argoRolloutsClient: argoRolloutsClient,
+		promAPI:            promAPI,
 	}, nil
 }
 
-func (p *PrometheusCollectorPlugin) NewCollector(_ context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
-	return NewPrometheusCollector(p.client, p.promAPI, hpa, config, interval)
+// SetSamplesWarningThreshold sets the number of samples in a query result
+// above which a warning is logged. Zero disables the warning.
+func (p *PrometheusCollectorPlugin) SetSamplesWarningThreshold(threshold int) {
+	p.samplesThreshold = threshold
+}
+
+func (p *PrometheusCollectorPlugin) NewCollector(ctx context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
+	if config.Type == autoscalingv2.PodsMetricSourceType {
+		c, err := NewPrometheusPodsCollector(ctx, p.client, p.argoRolloutsClient, p.promAPI, hpa, config, interval)
+		if err != nil {
+			return nil, err
+		}
+		c.querier.samplesThreshold = p.samplesThreshold
+		return c, nil
+	}
+
+	c, err := NewPrometheusCollector(p.client, p.promAPI, hpa, config, interval)
+	if err != nil {
+		return nil, err
+	}
+	c.argoRolloutsClient = p.argoRolloutsClient
+	c.querier.samplesThreshold = p.samplesThreshold
+	return c, nil
 }
 
 type PrometheusCollector struct {
-	client          kubernetes.Interface
-	promAPI         promv1.API
-	query           string
-	metric          autoscalingv2.MetricIdentifier
-	metricType      autoscalingv2.MetricSourceType
-	objectReference custom_metrics.ObjectReference
-	interval        time.Duration
-	perReplica      bool
-	hpa             *autoscalingv2.HorizontalPodAutoscaler
+	client             kubernetes.Interface
+	argoRolloutsClient argoRolloutsClient.Interface
+	querier            *prometheusQuerier
+	query              string
+	metric             autoscalingv2.MetricIdentifier
+	metricType         autoscalingv2.MetricSourceType
+	objectReference    custom_metrics.ObjectReference
+	interval           time.Duration
+	perReplica         bool
+	hpa                *autoscalingv2.HorizontalPodAutoscaler
 }
 
 func NewPrometheusCollector(client kubernetes.Interface, promAPI promv1.API, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (*PrometheusCollector, error) {
 	c := &PrometheusCollector{
 		client:     client,
-		promAPI:    promAPI,
+		querier:    &prometheusQuerier{promAPI: promAPI, namespace: hpa.Namespace, hpa: hpa.Name},
 		interval:   interval,
 		hpa:        hpa,
 		metric:     config.Metric,
@@ -86,76 +237,109 @@ func NewPrometheusCollector(client kubernetes.Interface, promAPI promv1.API, hpa
 		c.objectReference = config.ObjectReference
 		c.perReplica = config.PerReplica
 
-		if v, ok := config.Config["query"]; ok {
-			// TODO: validate query
-			c.query = v
-		} else {
-			return nil, fmt.Errorf("no prometheus query defined")
+		b := config.binder()
+		// TODO: validate query
+		b.RequiredString("query", &c.query)
+		if err := finishBinding(b, hpa); err != nil {
+			return nil, err
 		}
 	case autoscalingv2.ExternalMetricSourceType:
 		if config.Metric.Selector == nil {
-			return nil, fmt.Errorf("selector for prometheus query is not specified")
+			return nil, NewPermanentConfigError(fmt.Errorf("selector for prometheus query is not specified"))
 		}
 
-		if v, ok := config.Config["query"]; ok {
-			// TODO: validate query
-			c.query = v
-		} else {
+		b := config.binder()
+		// TODO: validate query
+		queryKey := "query"
+		if !b.String(queryKey, &c.query) {
 			// support legacy behavior of mapping query name to metric
-			queryName, ok := config.Config[prometheusQueryNameLabelKey]
-			if !ok {
-				return nil, fmt.Errorf("query or query name not specified on metric")
+			var queryName string
+			if b.String(prometheusQueryNameLabelKey, &queryName) {
+				// the other named queries are used by other metrics.
+				b.IgnoreUnbound()
+				queryKey = queryName
+				if !b.String(queryName, &c.query) {
+					b.Invalid(prometheusQueryNameLabelKey, "no prometheus query defined for the query name")
+				}
+			} else {
+				b.Missing("query", prometheusQueryNameLabelKey)
 			}
+		}
 
-			if v, ok := config.Config[queryName]; ok {
-				// TODO: validate query
-				c.query = v
-			} else {
-				return nil, fmt.Errorf("no prometheus query defined for metric")
+		if c.query != "" {
+			query, err := resolveQueryTemplate(c.query, config.Metric.Selector.MatchLabels)
+			if err != nil {
+				b.Invalid(queryKey, err.Error())
 			}
+			c.query = query
 		}
 
 		// Use custom Prometheus URL if defined in HPA annotation.
-		if promServer, ok := config.Config[prometheusServerAnnotationKey]; ok {
-			cfg := api.Config{
-				Address:      promServer,
-				RoundTripper: http.DefaultTransport,
-			}
+		var promServer string
+		hasPromServer := b.String(prometheusServerAnnotationKey, &promServer)
+		if err := finishBinding(b, hpa); err != nil {
+			return nil, err
+		}
 
-			promClient, err := api.NewClient(cfg)
+		if hasPromServer {
+			promAPI, err := newPrometheusAPI(promServer)
 			if err != nil {
 				return nil, err
 			}
-			c.promAPI = promv1.NewAPI(promClient)
+			c.querier.promAPI = promAPI
 		}
 	}
 
 	return c, nil
 }
 
+// resolveQueryTemplate resolves the {{ .Labels.<name> }} placeholders of
+// the query from the labels of the metric selector, so HPAs can use the same
+// query for different label values. Referencing a label missing in the
+// selector fails. Queries without placeholders are returned unchanged.
+func resolveQueryTemplate(query string, labels map[string]string) (string, error) {
+	if !strings.Contains(query, "{{") {
+		return query, nil
+	}
+
+	tmpl, err := template.New("query").Option("missingkey=error").Parse(query)
+	if err != nil {
+		return "", fmt.Errorf("invalid query template: %w", err)
+	}
+
+	var resolved strings.Builder
+	err = tmpl.Execute(&resolved, struct{ Labels map[string]string }{Labels: labels})
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve query template from the metric selector: %w", err)
+	}
+	return resolved.String(), nil
+}
+
 func (c *PrometheusCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, error) {
-	// TODO: use real context
-	value, _, err := c.promAPI.Query(ctx, c.query, time.Now().UTC())
+	value, err := c.querier.query(ctx, c.query)
 	if err != nil {
-		return nil, err
+		return nil, NewTransientError(err)
 	}
 
 	var sampleValue model.SampleValue
+	var timestamp model.Time
 	switch value.Type() {
 	case model.ValVector:
 		samples := value.(model.Vector)
 		if len(samples) == 0 {
-			return nil, &NoResultError{query: c.query}
+			return nil, NewTransientError(&NoResultError{query: c.query})
 		}
 
 		sampleValue = samples[0].Value
+		timestamp = samples[0].Timestamp
 	case model.ValScalar:
 		scalar := value.(*model.Scalar)
 		sampleValue = scalar.Value
+		timestamp = scalar.Timestamp
 	}
 
 	if math.IsNaN(float64(sampleValue)) {
-		return nil, &NoResultError{query: c.query}
+		return nil, NewTransientError(&NoResultError{query: c.query})
 	}
 
 	if c.perReplica {
@@ -163,7 +347,7 @@ func (c *PrometheusCollector) GetMetrics(ctx context.Context) ([]CollectedMetric
 		// calculate an average metric instead of total.
 		// targetAverageValue will be available in Kubernetes v1.12
 		// https://github.com/kubernetes/kubernetes/pull/64097
-		replicas, err := targetRefReplicas(ctx, c.client, c.hpa)
+		replicas, err := targetRefReplicas(ctx, c.client, c.argoRolloutsClient, c.hpa)
 		if err != nil {
 			return nil, err
 		}
@@ -173,28 +357,21 @@ func (c *PrometheusCollector) GetMetrics(ctx context.Context) ([]CollectedMetric
 	var metricValue CollectedMetric
 	switch c.metricType {
 	case autoscalingv2.ObjectMetricSourceType:
-		metricValue = CollectedMetric{
-			Namespace: c.hpa.Namespace,
-			Type:      c.metricType,
-			Custom: custom_metrics.MetricValue{
-				DescribedObject: c.objectReference,
-				Metric:          custom_metrics.MetricIdentifier{Name: c.metric.Name, Selector: c.metric.Selector},
-				Timestamp:       metav1.Time{Time: time.Now().UTC()},
-				Value:           *resource.NewMilliQuantity(int64(sampleValue*1000), resource.DecimalSI),
-			},
-		}
+		metricValue = NewCustomMetric(c.metricType, custom_metrics.MetricValue{
+			DescribedObject: c.objectReference,
+			Metric:          custom_metrics.MetricIdentifier{Name: c.metric.Name, Selector: c.metric.Selector},
+			Timestamp:       sampleTime(timestamp),
+			Value:           *resource.NewMilliQuantity(int64(sampleValue*1000), resource.DecimalSI),
+		})
 	case autoscalingv2.ExternalMetricSourceType:
-		metricValue = CollectedMetric{
-			Namespace: c.hpa.Namespace,
-			Type:      c.metricType,
-			External: external_metrics.ExternalMetricValue{
-				MetricName:   c.metric.Name,
-				MetricLabels: c.metric.Selector.MatchLabels,
-				Timestamp:    metav1.Time{Time: time.Now().UTC()},
-				Value:        *resource.NewMilliQuantity(int64(sampleValue*1000), resource.DecimalSI),
-			},
-		}
+		metricValue = NewExternalMetric(c.hpa.Namespace, external_metrics.ExternalMetricValue{
+			MetricName:   c.metric.Name,
+			MetricLabels: c.metric.Selector.MatchLabels,
+			Timestamp:    sampleTime(timestamp),
+			Value:        *resource.NewMilliQuantity(int64(sampleValue*1000), resource.DecimalSI),
+		})
 	}
+	metricValue.Query = c.query
 
 	return []CollectedMetric{metricValue}, nil
 }
@@ -202,3 +379,126 @@ func (c *PrometheusCollector) GetMetrics(ctx context.Context) ([]CollectedMetric
 func (c *PrometheusCollector) Interval() time.Duration {
 	return c.interval
 }
+
+// PrometheusPodsCollector collects a Pods metric from a prometheus query
+// returning one series per pod identified by the pod label.
+type PrometheusPodsCollector struct {
+	client           kubernetes.Interface
+	querier          *prometheusQuerier
+	query            string
+	namespace        string
+	metric           autoscalingv2.MetricIdentifier
+	podLabelSelector *metav1.LabelSelector
+	interval         time.Duration
+}
+
+// NewPrometheusPodsCollector initializes a new PrometheusPodsCollector. The
+// query may contain a {pod} placeholder which is replaced by a regular
+// expression matching the names of the pods targeted by the HPA.
+func NewPrometheusPodsCollector(ctx context.Context, client kubernetes.Interface, argoRolloutsClient argoRolloutsClient.Interface, promAPI promv1.API, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (*PrometheusPodsCollector, error) {
+	var query string
+	b := config.binder()
+	b.RequiredString("query", &query)
+	if err := finishBinding(b, hpa); err != nil {
+		return nil, err
+	}
+
+	selector, err := getPodLabelSelector(ctx, client, argoRolloutsClient, hpa)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod label selector: %w", err)
+	}
+
+	return &PrometheusPodsCollector{
+		client:           client,
+		querier:          &prometheusQuerier{promAPI: promAPI, namespace: hpa.Namespace, hpa: hpa.Name},
+		query:            query,
+		namespace:        hpa.Namespace,
+		metric:           config.Metric,
+		podLabelSelector: selector,
+		interval:         interval,
+	}, nil
+}
+
+func (c *PrometheusPodsCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, error) {
+	opts := metav1.ListOptions{
+		LabelSelector: labels.Set(c.podLabelSelector.MatchLabels).String(),
+	}
+
+	pods, err := c.client.CoreV1().Pods(c.namespace).List(ctx, opts)
+	if err != nil {
+		return nil, NewTransientError(err)
+	}
+
+	podNames := make([]string, 0, len(pods.Items))
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		podNames = append(podNames, pod.Name)
+	}
+
+	if len(podNames) == 0 {
+		return nil, nil
+	}
+
+	query := c.query
+	if strings.Contains(query, prometheusPodPlaceholder) {
+		quoted := make([]string, 0, len(podNames))
+		for _, name := range podNames {
+			quoted = append(quoted, regexp.QuoteMeta(name))
+		}
+		query = strings.ReplaceAll(query, prometheusPodPlaceholder, strings.Join(quoted, "|"))
+	}
+
+	value, err := c.querier.query(ctx, query)
+	if err != nil {
+		return nil, NewTransientError(err)
+	}
+
+	samples, ok := value.(model.Vector)
+	if !ok {
+		return nil, NewPermanentConfigError(fmt.Errorf("query '%s' returned %s, expected a vector with one series per pod", query, value.Type()))
+	}
+
+	podSamples := make(map[string]*model.Sample, len(samples))
+	for _, sample := range samples {
+		pod, ok := sample.Metric[prometheusPodLabel]
+		if !ok || math.IsNaN(float64(sample.Value)) {
+			continue
+		}
+		podSamples[string(pod)] = sample
+	}
+
+	values := make([]CollectedMetric, 0, len(podNames))
+	for _, name := range podNames {
+		sample, ok := podSamples[name]
+		if !ok {
+			PrometheusPodsMissing.Inc()
+			continue
+		}
+
+		value := NewCustomMetric(autoscalingv2.PodsMetricSourceType, custom_metrics.MetricValue{
+			DescribedObject: custom_metrics.ObjectReference{
+				APIVersion: "v1",
+				Kind:       "Pod",
+				Name:       name,
+				Namespace:  c.namespace,
+			},
+			Metric:    custom_metrics.MetricIdentifier{Name: c.metric.Name, Selector: c.podLabelSelector},
+			Timestamp: sampleTime(sample.Timestamp),
+			Value:     *resource.NewMilliQuantity(int64(sample.Value*1000), resource.DecimalSI),
+		})
+		value.Query = query
+		values = append(values, value)
+	}
+
+	if len(values) == 0 {
+		return nil, NewTransientError(&NoResultError{query: query})
+	}
+
+	return values, nil
+}
+
+func (c *PrometheusPodsCollector) Interval() time.Duration {
+	return c.interval
+}
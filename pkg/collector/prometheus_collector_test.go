@@ -2,11 +2,24 @@ package collector
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestNewPrometheusCollector(t *testing.T) {
@@ -173,7 +186,7 @@ func TestNewPrometheusCollector(t *testing.T) {
 	} {
 		t.Run(tc.msg, func(t *testing.T) {
 			collectorFactory := NewCollectorFactory()
-			promPlugin, err := NewPrometheusCollectorPlugin(nil, "http://prometheus")
+			promPlugin, err := NewPrometheusCollectorPlugin(nil, nil, "http://prometheus")
 			require.NoError(t, err)
 			collectorFactory.RegisterExternalCollector([]string{PrometheusMetricType, PrometheusMetricNameLegacy}, promPlugin)
 			configs, err := ParseHPAMetrics(tc.hpa)
@@ -192,3 +205,299 @@ func TestNewPrometheusCollector(t *testing.T) {
 		})
 	}
 }
+
+type mockPromAPI struct {
+	promv1.API
+	query string
+	value model.Value
+}
+
+func (m *mockPromAPI) Query(_ context.Context, query string, _ time.Time, _ ...promv1.Option) (model.Value, promv1.Warnings, error) {
+	m.query = query
+	return m.value, nil, nil
+}
+
+func TestPrometheusPodsCollector(t *testing.T) {
+	for _, tc := range []struct {
+		msg           string
+		query         string
+		value         model.Value
+		expectedQuery string
+		expected      map[string]int64
+		err           bool
+	}{
+		{
+			msg:           "pod placeholder is replaced by the target pods",
+			query:         `sum by (pod) (rate(requests_total{pod=~"{pod}"}[1m]))`,
+			expectedQuery: `sum by (pod) (rate(requests_total{pod=~"app-1|app-2"}[1m]))`,
+			value: model.Vector{
+				{Metric: model.Metric{"pod": "app-1"}, Value: 1.5},
+				{Metric: model.Metric{"pod": "app-2"}, Value: 3},
+			},
+			expected: map[string]int64{"app-1": 1500, "app-2": 3000},
+		},
+		{
+			msg:           "series of unknown pods and pods missing in the result are skipped",
+			query:         `sum by (pod) (rate(requests_total[1m]))`,
+			expectedQuery: `sum by (pod) (rate(requests_total[1m]))`,
+			value: model.Vector{
+				{Metric: model.Metric{"pod": "app-1"}, Value: 2},
+				{Metric: model.Metric{"pod": "other"}, Value: 5},
+			},
+			expected: map[string]int64{"app-1": 2000},
+		},
+		{
+			msg:   "no matching series returns an error",
+			query: `sum by (pod) (rate(requests_total[1m]))`,
+			value: model.Vector{},
+			err:   true,
+		},
+		{
+			msg:   "scalar result returns an error",
+			query: `scalar(sum(rate(requests_total[1m])))`,
+			value: &model.Scalar{Value: 1},
+			err:   true,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			selector := &metav1.LabelSelector{MatchLabels: map[string]string{"application": "app"}}
+			_, err := client.AppsV1().Deployments("default").Create(context.Background(), &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+				Spec:       appsv1.DeploymentSpec{Selector: selector},
+			}, metav1.CreateOptions{})
+			require.NoError(t, err)
+
+			for _, name := range []string{"app-1", "app-2"} {
+				_, err := client.CoreV1().Pods("default").Create(context.Background(), &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: selector.MatchLabels},
+				}, metav1.CreateOptions{})
+				require.NoError(t, err)
+			}
+
+			hpa := newHPA("default", "app", "Deployment")
+			hpa.Namespace = "default"
+			config := &MetricConfig{
+				MetricTypeName: MetricTypeName{
+					Type:   autoscalingv2.PodsMetricSourceType,
+					Metric: autoscalingv2.MetricIdentifier{Name: "requests-per-second"},
+				},
+				CollectorType: PrometheusMetricType,
+				Config:        map[string]string{"query": tc.query},
+			}
+
+			promAPI := &mockPromAPI{value: tc.value}
+			collector, err := NewPrometheusPodsCollector(context.Background(), client, nil, promAPI, hpa, config, time.Minute)
+			require.NoError(t, err)
+
+			metrics, err := collector.GetMetrics(context.Background())
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedQuery, promAPI.query)
+
+			values := make(map[string]int64, len(metrics))
+			for _, metric := range metrics {
+				require.Equal(t, autoscalingv2.PodsMetricSourceType, metric.Type)
+				require.Equal(t, "Pod", metric.Custom.DescribedObject.Kind)
+				require.Equal(t, "requests-per-second", metric.Custom.Metric.Name)
+				values[metric.Custom.DescribedObject.Name] = metric.Custom.Value.MilliValue()
+			}
+			require.Equal(t, tc.expected, values)
+		})
+	}
+}
+
+func histogramValues(t *testing.T, histogram *prometheus.HistogramVec, namespace, hpa string) (uint64, float64) {
+	metric := &dto.Metric{}
+	require.NoError(t, histogram.WithLabelValues(namespace, hpa).(prometheus.Metric).Write(metric))
+	return metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum()
+}
+
+func largeVector(series int) model.Vector {
+	vector := make(model.Vector, 0, series)
+	for i := 0; i < series; i++ {
+		vector = append(vector, &model.Sample{Metric: model.Metric{"instance": model.LabelValue(fmt.Sprintf("instance-%d", i))}, Value: 1})
+	}
+	return vector
+}
+
+func TestPrometheusCollectorResultMetrics(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	for _, tc := range []struct {
+		msg             string
+		hpa             string
+		series          int
+		threshold       int
+		expectedWarning bool
+	}{
+		{
+			msg:       "result below the threshold",
+			hpa:       "below",
+			series:    10,
+			threshold: 100,
+		},
+		{
+			msg:             "result above the threshold",
+			hpa:             "above",
+			series:          500,
+			threshold:       100,
+			expectedWarning: true,
+		},
+		{
+			msg:       "threshold disabled",
+			hpa:       "disabled",
+			series:    500,
+			threshold: 0,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			hook.Reset()
+			hpa := newHPA("default", "app", "Deployment")
+			hpa.Name = tc.hpa
+			hpa.Namespace = "prometheus-result"
+			config := &MetricConfig{
+				MetricTypeName: MetricTypeName{
+					Type:   autoscalingv2.ExternalMetricSourceType,
+					Metric: autoscalingv2.MetricIdentifier{Name: "requests", Selector: &metav1.LabelSelector{}},
+				},
+				CollectorType: PrometheusMetricType,
+				Config:        map[string]string{"query": "requests_total"},
+			}
+
+			plugin := &PrometheusCollectorPlugin{promAPI: &mockPromAPI{value: largeVector(tc.series)}}
+			plugin.SetSamplesWarningThreshold(tc.threshold)
+			collector, err := plugin.NewCollector(context.Background(), hpa, config, time.Minute)
+			require.NoError(t, err)
+
+			for i := 0; i < 2; i++ {
+				_, err = collector.GetMetrics(context.Background())
+				require.NoError(t, err)
+			}
+
+			count, sum := histogramValues(t, PrometheusResultSamples, hpa.Namespace, hpa.Name)
+			require.EqualValues(t, 2, count)
+			require.EqualValues(t, 2*tc.series, sum)
+
+			// the mocked API doesn't report the size of the response.
+			count, _ = histogramValues(t, PrometheusResultBytes, hpa.Namespace, hpa.Name)
+			require.EqualValues(t, 0, count)
+
+			var warnings []string
+			for _, entry := range hook.AllEntries() {
+				if entry.Level == log.WarnLevel {
+					warnings = append(warnings, entry.Message)
+				}
+			}
+			if tc.expectedWarning {
+				require.Len(t, warnings, 2)
+				require.Contains(t, warnings[0], "prometheus-result/above")
+				require.Contains(t, warnings[0], fmt.Sprintf("%d samples", tc.series))
+			} else {
+				require.Empty(t, warnings)
+			}
+
+			ForgetPrometheusResultMetrics(hpa.Namespace, hpa.Name)
+			count, _ = histogramValues(t, PrometheusResultSamples, hpa.Namespace, hpa.Name)
+			require.EqualValues(t, 0, count)
+		})
+	}
+}
+
+func TestPrometheusResultBytes(t *testing.T) {
+	response := `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"1"]}]}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	promAPI, err := newPrometheusAPI(server.URL)
+	require.NoError(t, err)
+
+	querier := &prometheusQuerier{promAPI: promAPI, namespace: "prometheus-bytes", hpa: "hpa"}
+	value, err := querier.query(context.Background(), "requests_total")
+	require.NoError(t, err)
+	require.Len(t, value.(model.Vector), 1)
+
+	count, sum := histogramValues(t, PrometheusResultBytes, "prometheus-bytes", "hpa")
+	require.EqualValues(t, 1, count)
+	require.EqualValues(t, len(response), sum)
+}
+
+func TestPrometheusCollectorQueryTemplate(t *testing.T) {
+	newTemplatedHPA := func(name, query string, labels map[string]string) *autoscalingv2.HorizontalPodAutoscaler {
+		return &autoscalingv2.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				Annotations: map[string]string{
+					"metric-config.external.queue-length.prometheus/query": query,
+				},
+			},
+			Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+				Metrics: []autoscalingv2.MetricSpec{
+					{
+						Type: autoscalingv2.ExternalMetricSourceType,
+						External: &autoscalingv2.ExternalMetricSource{
+							Metric: autoscalingv2.MetricIdentifier{
+								Name:     "queue-length",
+								Selector: &metav1.LabelSelector{MatchLabels: labels},
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+	const query = `sum(queue_length{queue="{{ .Labels.queue }}"})`
+
+	newCollector := func(hpa *autoscalingv2.HorizontalPodAutoscaler, promAPI promv1.API) (Collector, error) {
+		configs, err := ParseHPAMetrics(hpa)
+		require.NoError(t, err)
+		require.Len(t, configs, 1)
+		plugin := &PrometheusCollectorPlugin{promAPI: promAPI}
+		return plugin.NewCollector(context.Background(), hpa, configs[0], time.Minute)
+	}
+
+	// two HPAs using the same template with different label values
+	// collect different queries into different series.
+	var series []map[string]string
+	for _, tc := range []struct {
+		hpa           string
+		queue         string
+		expectedQuery string
+	}{
+		{hpa: "payments", queue: "payments", expectedQuery: `sum(queue_length{queue="payments"})`},
+		{hpa: "orders", queue: "orders", expectedQuery: `sum(queue_length{queue="orders"})`},
+	} {
+		promAPI := &mockPromAPI{value: &model.Scalar{Value: 10}}
+		c, err := newCollector(newTemplatedHPA(tc.hpa, query, map[string]string{"type": PrometheusMetricType, "queue": tc.queue}), promAPI)
+		require.NoError(t, err)
+
+		metrics, err := c.GetMetrics(context.Background())
+		require.NoError(t, err)
+		require.Len(t, metrics, 1)
+		require.Equal(t, tc.expectedQuery, promAPI.query)
+		require.Equal(t, tc.expectedQuery, metrics[0].Query)
+		series = append(series, metrics[0].External.MetricLabels)
+	}
+	require.NotEqual(t, series[0], series[1])
+
+	for _, tc := range []struct {
+		msg   string
+		query string
+	}{
+		{msg: "missing label", query: `sum(queue_length{queue="{{ .Labels.topic }}"})`},
+		{msg: "invalid template", query: `sum(queue_length{queue="{{ .Labels.queue "})`},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			_, err := newCollector(newTemplatedHPA("payments", tc.query, map[string]string{"type": PrometheusMetricType, "queue": "payments"}), &mockPromAPI{})
+			require.ErrorIs(t, err, ErrPermanentConfig)
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// PushedCollectorType is the pods collector type reading the values
+	// pushed by the pods to the push receiver of the adapter.
+	PushedCollectorType = "pushed"
+
+	// PushPathPrefix is the path prefix of the push HTTP handler. Values
+	// are pushed to /push/<namespace>/<pod>/<metric>.
+	PushPathPrefix = "/push/"
+
+	pushedMetricNameConfigKey   = "metric-name"
+	pushedMaxStalenessConfigKey = "max-staleness"
+
+	defaultPushedMaxStaleness = time.Minute
+	maxPushBodySize           = 64
+	maxPushPacketSize         = 65507
+)
+
+// pushKey identifies a value pushed by a pod. The source is the IP the
+// value was pushed from, so a value is only read for the pod with that IP.
+type pushKey struct {
+	namespace string
+	pod       string
+	metric    string
+	source    string
+}
+
+// pushedValue is the latest value pushed for a key.
+type pushedValue struct {
+	value     float64
+	timestamp time.Time
+}
+
+// PushBuffer keeps the latest value pushed by each pod per metric for
+// workloads which can't serve their metrics via HTTP. Values are kept per
+// source IP and only read for a pod with that IP, so pods can't push values
+// of other pods. Values older than the TTL are dropped.
+type PushBuffer struct {
+	mu        sync.Mutex
+	values    map[pushKey]pushedValue
+	ttl       time.Duration
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// NewPushBuffer initializes a new PushBuffer keeping values for the TTL.
+func NewPushBuffer(ttl time.Duration) *PushBuffer {
+	return &PushBuffer{
+		values: map[pushKey]pushedValue{},
+		ttl:    ttl,
+		now:    time.Now,
+	}
+}
+
+// Push records the current value of the metric of the pod pushed from the
+// source IP.
+func (b *PushBuffer) Push(namespace, pod, metric, source string, value float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.values[pushKey{namespace: namespace, pod: pod, metric: metric, source: normalizeIP(source)}] = pushedValue{value: value, timestamp: now}
+
+	// expired values are pruned at most once per TTL.
+	if now.Sub(b.lastPrune) < b.ttl {
+		return
+	}
+	for key, value := range b.values {
+		if now.Sub(value.timestamp) > b.ttl {
+			delete(b.values, key)
+		}
+	}
+	b.lastPrune = now
+}
+
+// Get returns the latest value of the metric of the pod pushed from one of
+// the IPs of the pod and the time it was pushed. It returns false if no
+// value was pushed within the TTL.
+func (b *PushBuffer) Get(namespace, pod, metric string, ips []string) (float64, time.Time, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var latest pushedValue
+	found := false
+	for _, ip := range ips {
+		value, ok := b.values[pushKey{namespace: namespace, pod: pod, metric: metric, source: normalizeIP(ip)}]
+		if !ok || b.now().Sub(value.timestamp) > b.ttl {
+			continue
+		}
+		if !found || value.timestamp.After(latest.timestamp) {
+			latest, found = value, true
+		}
+	}
+	if !found {
+		return 0, time.Time{}, false
+	}
+	return latest.value, latest.timestamp, true
+}
+
+// normalizeIP returns the canonical form of the IP, e.g. of IPv6 addresses,
+// or the string itself if it isn't an IP.
+func normalizeIP(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		return parsed.String()
+	}
+	return ip
+}
+
+// sourceIP returns the IP of a host:port address.
+func sourceIP(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
+
+// podIPs returns the IPs of the pod.
+func podIPs(pod *corev1.Pod) []string {
+	ips := make([]string, 0, len(pod.Status.PodIPs)+1)
+	if pod.Status.PodIP != "" {
+		ips = append(ips, pod.Status.PodIP)
+	}
+	for _, ip := range pod.Status.PodIPs {
+		if ip.IP != pod.Status.PodIP {
+			ips = append(ips, ip.IP)
+		}
+	}
+	return ips
+}
+
+// ServeHTTP records a value pushed with a POST request to
+// /push/<namespace>/<pod>/<metric>. The body is the value as plain text,
+// larger bodies are rejected.
+func (b *PushBuffer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	namespace, pod, metric, ok := parsePushKey(strings.TrimPrefix(r.URL.Path, PushPathPrefix))
+	if !ok {
+		http.Error(w, "expected "+PushPathPrefix+"<namespace>/<pod>/<metric>", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPushBodySize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, fmt.Sprintf("value exceeds %d bytes", maxPushBodySize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(string(body)), 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid value: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	b.Push(namespace, pod, metric, sourceIP(r.RemoteAddr), value)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ServePackets records the values of statsd-style gauges received on the
+// connection until it's closed. Each line of a packet has the format
+// <namespace>/<pod>/<metric>:<value>|g.
+func (b *PushBuffer) ServePackets(conn net.PacketConn) error {
+	buf := make([]byte, maxPushPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(buf[:n]))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			if err := b.pushLine(line, sourceIP(addr.String())); err != nil {
+				log.Debugf("Ignoring pushed value '%s': %v", line, err)
+			}
+		}
+	}
+}
+
+// pushLine records the value of a statsd-style gauge line pushed from the
+// source IP.
+func (b *PushBuffer) pushLine(line, source string) error {
+	name, rest, found := cutLast(line, ":")
+	if !found {
+		return fmt.Errorf("missing value")
+	}
+
+	value, typ, found := strings.Cut(rest, "|")
+	if !found || typ != "g" {
+		return fmt.Errorf("only gauges are supported")
+	}
+
+	namespace, pod, metric, ok := parsePushKey(name)
+	if !ok {
+		return fmt.Errorf("expected <namespace>/<pod>/<metric>")
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+
+	b.Push(namespace, pod, metric, source, v)
+	return nil
+}
+
+// parsePushKey parses <namespace>/<pod>/<metric>.
+func parsePushKey(key string) (string, string, string, bool) {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", "", "", false
+	}
+	return parts[0], parts[1], parts[2], true
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s, sep string) (string, string, bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
+
+// PushedGetter gets the metric of a pod from the values pushed by the pod.
+type PushedGetter struct {
+	buffer       *PushBuffer
+	metric       string
+	maxStaleness time.Duration
+}
+
+// NewPushedGetter initializes a new PushedGetter reading the metric, or the
+// metric defined by the metric-name config, from the buffer. Values older
+// than the max-staleness config, one minute by default, are ignored.
+func NewPushedGetter(buffer *PushBuffer, metric string, config map[string]string) (*PushedGetter, error) {
+	getter := &PushedGetter{
+		buffer:       buffer,
+		metric:       metric,
+		maxStaleness: defaultPushedMaxStaleness,
+	}
+
+	if v, ok := config[pushedMetricNameConfigKey]; ok {
+		getter.metric = v
+	}
+
+	if v, ok := config[pushedMaxStalenessConfigKey]; ok {
+		maxStaleness, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", pushedMaxStalenessConfigKey, err)
+		}
+		if maxStaleness <= 0 {
+			return nil, fmt.Errorf("%s must be positive, got %s", pushedMaxStalenessConfigKey, maxStaleness)
+		}
+		getter.maxStaleness = maxStaleness
+	}
+
+	return getter, nil
+}
+
+// GetMetric returns the latest value pushed by the pod from one of its IPs.
+// It fails if the pod didn't push a value within the max staleness, so the
+// pod is skipped.
+func (g *PushedGetter) GetMetric(pod *corev1.Pod) (float64, error) {
+	value, timestamp, ok := g.buffer.Get(pod.Namespace, pod.Name, g.metric, podIPs(pod))
+	if !ok {
+		return 0, fmt.Errorf("no value of metric %s pushed", g.metric)
+	}
+
+	if age := g.buffer.now().Sub(timestamp); age > g.maxStaleness {
+		return 0, fmt.Errorf("pushed value of metric %s is %s old, more than the %s of %s", g.metric, age.Truncate(time.Second), pushedMaxStalenessConfigKey, g.maxStaleness)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,193 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPushBufferTTL(t *testing.T) {
+	now := time.Date(2024, time.January, 1, 9, 0, 0, 0, time.UTC)
+	buffer := NewPushBuffer(5 * time.Minute)
+	buffer.now = func() time.Time { return now }
+
+	buffer.Push("default", "pod-a", "queue-size", "10.0.0.1", 10)
+	value, timestamp, ok := buffer.Get("default", "pod-a", "queue-size", []string{"10.0.0.1"})
+	require.True(t, ok)
+	require.Equal(t, 10.0, value)
+	require.Equal(t, now, timestamp)
+
+	_, _, ok = buffer.Get("default", "pod-b", "queue-size", []string{"10.0.0.1"})
+	require.False(t, ok)
+
+	// expired values are neither returned nor kept.
+	now = now.Add(6 * time.Minute)
+	_, _, ok = buffer.Get("default", "pod-a", "queue-size", []string{"10.0.0.1"})
+	require.False(t, ok)
+
+	buffer.Push("default", "pod-b", "queue-size", "10.0.0.2", 20)
+	require.Len(t, buffer.values, 1)
+}
+
+func TestPushBufferSource(t *testing.T) {
+	buffer := NewPushBuffer(time.Minute)
+
+	// another pod pushing a value of pod-a.
+	buffer.Push("default", "pod-a", "queue-size", "10.0.0.2", 1000)
+	_, _, ok := buffer.Get("default", "pod-a", "queue-size", []string{"10.0.0.1"})
+	require.False(t, ok)
+
+	// the value of pod-a isn't overwritten by other sources.
+	buffer.Push("default", "pod-a", "queue-size", "10.0.0.1", 10)
+	buffer.Push("default", "pod-a", "queue-size", "10.0.0.2", 2000)
+	value, _, ok := buffer.Get("default", "pod-a", "queue-size", []string{"10.0.0.1"})
+	require.True(t, ok)
+	require.Equal(t, 10.0, value)
+
+	// IPv6 addresses are compared in their canonical form.
+	buffer.Push("default", "pod-b", "queue-size", "fd00:0::1", 20)
+	value, _, ok = buffer.Get("default", "pod-b", "queue-size", []string{"10.0.0.3", "fd00::1"})
+	require.True(t, ok)
+	require.Equal(t, 20.0, value)
+}
+
+func TestPushBufferServeHTTP(t *testing.T) {
+	buffer := NewPushBuffer(time.Minute)
+	server := httptest.NewServer(buffer)
+	defer server.Close()
+
+	for _, tc := range []struct {
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{method: http.MethodPost, path: "/push/default/pod-a/queue-size", body: "42.5\n", expectedStatus: http.StatusNoContent},
+		{method: http.MethodPost, path: "/push/default/pod-a", body: "1", expectedStatus: http.StatusNotFound},
+		{method: http.MethodPost, path: "/push/default/pod-a/queue-size", body: "many", expectedStatus: http.StatusBadRequest},
+		{method: http.MethodPost, path: "/push/default/pod-a/queue-size", body: "1" + strings.Repeat("0", maxPushBodySize), expectedStatus: http.StatusRequestEntityTooLarge},
+		{method: http.MethodGet, path: "/push/default/pod-a/queue-size", expectedStatus: http.StatusMethodNotAllowed},
+	} {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, server.URL+tc.path, strings.NewReader(tc.body))
+			require.NoError(t, err)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, tc.expectedStatus, resp.StatusCode)
+		})
+	}
+
+	value, _, ok := buffer.Get("default", "pod-a", "queue-size", []string{"127.0.0.1"})
+	require.True(t, ok)
+	require.Equal(t, 42.5, value)
+}
+
+func TestPushBufferServePackets(t *testing.T) {
+	buffer := NewPushBuffer(time.Minute)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	done := make(chan error)
+	go func() {
+		done <- buffer.ServePackets(conn)
+	}()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.Write([]byte("default/pod-a/queue-size:10|g\ndefault/pod-b/queue-size:20|c\ninvalid\ndefault/pod-c/http:rps:30|g\n"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		_, _, ok := buffer.Get("default", "pod-c", "http:rps", []string{"127.0.0.1"})
+		return ok
+	}, time.Second, 5*time.Millisecond)
+
+	value, _, ok := buffer.Get("default", "pod-a", "queue-size", []string{"127.0.0.1"})
+	require.True(t, ok)
+	require.Equal(t, 10.0, value)
+
+	// only gauges are supported.
+	_, _, ok = buffer.Get("default", "pod-b", "queue-size", []string{"127.0.0.1"})
+	require.False(t, ok)
+
+	conn.Close()
+	require.NoError(t, <-done)
+}
+
+func TestNewPushedGetterInvalidConfig(t *testing.T) {
+	_, err := NewPushedGetter(NewPushBuffer(time.Minute), "queue-size", map[string]string{"max-staleness": "30"})
+	require.Error(t, err)
+
+	_, err = NewPushedGetter(NewPushBuffer(time.Minute), "queue-size", map[string]string{"max-staleness": "-1s"})
+	require.Error(t, err)
+}
+
+func TestPodCollectorPushed(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	makeTestDeployment(t, client)
+	testHPA := makeTestHPA(t, client)
+
+	podCondition := corev1.PodCondition{Type: corev1.PodReady, Status: corev1.ConditionTrue, LastTransitionTime: v1.NewTime(time.Now().Add(-time.Minute))}
+	for i := 0; i < 4; i++ {
+		_, err := client.CoreV1().Pods(testNamespace).Create(context.Background(), &corev1.Pod{
+			ObjectMeta: v1.ObjectMeta{
+				Name:      fmt.Sprintf("test-pod-%d", i),
+				Namespace: testNamespace,
+				Labels:    map[string]string{applicationLabelName: applicationLabelValue},
+			},
+			Status: corev1.PodStatus{Conditions: []corev1.PodCondition{podCondition}, PodIP: fmt.Sprintf("10.0.0.%d", i)},
+		}, v1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	now := time.Now()
+	buffer := NewPushBuffer(10 * time.Minute)
+	buffer.now = func() time.Time { return now.Add(-2 * time.Minute) }
+	// stale value of test-pod-2
+	buffer.Push(testNamespace, "test-pod-2", "queue-size", "10.0.0.2", 30)
+	buffer.now = func() time.Time { return now }
+	buffer.Push(testNamespace, "test-pod-0", "queue-size", "10.0.0.0", 10)
+	buffer.Push(testNamespace, "test-pod-1", "queue-size", "10.0.0.1", 20.5)
+	// value of another metric
+	buffer.Push(testNamespace, "test-pod-3", "other", "10.0.0.3", 40)
+	// value of test-pod-3 pushed by another pod
+	buffer.Push(testNamespace, "test-pod-3", "queue-size", "10.0.0.1", 50)
+
+	config := &MetricConfig{
+		MetricTypeName: MetricTypeName{Type: autoscalingv2.PodsMetricSourceType, Metric: autoscalingv2.MetricIdentifier{Name: "queue-size"}},
+		CollectorType:  PushedCollectorType,
+		Config:         map[string]string{"max-staleness": "1m"},
+	}
+
+	plugin := NewPodCollectorPlugin(client, nil)
+	_, err := plugin.NewCollector(context.Background(), testHPA, config, testInterval)
+	require.ErrorIs(t, err, ErrPermanentConfig)
+
+	plugin.SetPushBuffer(buffer)
+	collector, err := plugin.NewCollector(context.Background(), testHPA, config, testInterval)
+	require.NoError(t, err)
+
+	metrics, err := collector.GetMetrics(context.Background())
+	require.NoError(t, err)
+
+	values := map[string]int64{}
+	for _, m := range metrics {
+		require.Equal(t, "Pod", m.Custom.DescribedObject.Kind)
+		values[m.Custom.DescribedObject.Name] = m.Custom.Value.MilliValue()
+	}
+	require.Equal(t, map[string]int64{"test-pod-0": 10000, "test-pod-1": 20500}, values)
+}
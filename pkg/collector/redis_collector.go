@@ -0,0 +1,194 @@
+package collector
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/redis"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+)
+
+const (
+	// RedisMetricType defines the metric type for metrics based on the
+	// length of a Redis list, stream or set.
+	RedisMetricType = "redis"
+	redisKeyKey     = "key"
+	redisKindKey    = "kind"
+	redisAddressKey = "address"
+)
+
+// ReadRedisPassword reads the password of the Redis server from the file.
+// The password is a secret and therefore never part of the returned errors.
+func ReadRedisPassword(passwordFile string) (string, error) {
+	data, err := os.ReadFile(passwordFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read Redis password file: %w", err)
+	}
+
+	password := strings.TrimSpace(string(data))
+	if password == "" {
+		return "", fmt.Errorf("redis password file %s is empty", passwordFile)
+	}
+	return password, nil
+}
+
+// NewRedisTLSConfig returns the TLS config of the connections to Redis. The
+// server certificate is verified with the CAs of caFile or the system CAs
+// if caFile is empty.
+func NewRedisTLSConfig(caFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Redis CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in Redis CA file %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
+
+// RedisCollectorPlugin defines a plugin for creating collectors that can get
+// the length of Redis lists, streams and sets. All collectors of a server
+// share a single client.
+type RedisCollectorPlugin struct {
+	address          string
+	allowedAddresses map[string]struct{}
+	options          redis.Options
+
+	mu      sync.Mutex
+	clients map[string]redis.Redis
+}
+
+// NewRedisCollectorPlugin initializes a new RedisCollectorPlugin querying
+// the server at address. Metrics can override the address with one of the
+// allowed addresses.
+func NewRedisCollectorPlugin(address string, allowedAddresses []string, options redis.Options) (*RedisCollectorPlugin, error) {
+	if address == "" {
+		return nil, fmt.Errorf("redis address is not configured")
+	}
+
+	allowed := map[string]struct{}{address: {}}
+	for _, allowedAddress := range allowedAddresses {
+		allowed[allowedAddress] = struct{}{}
+	}
+
+	return &RedisCollectorPlugin{
+		address:          address,
+		allowedAddresses: allowed,
+		options:          options,
+		clients:          map[string]redis.Redis{},
+	}, nil
+}
+
+// NewCollector initializes a new Redis collector from the specified HPA.
+func (p *RedisCollectorPlugin) NewCollector(ctx context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
+	return NewRedisCollector(ctx, p, hpa, config, interval)
+}
+
+// client returns the client of the server at the address.
+func (p *RedisCollectorPlugin) client(address string) redis.Redis {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	client, ok := p.clients[address]
+	if !ok {
+		client = redis.NewClient(address, p.options)
+		p.clients[address] = client
+	}
+	return client
+}
+
+// RedisCollector defines a collector that is able to collect the length of
+// a Redis list, stream or set.
+type RedisCollector struct {
+	client    redis.Redis
+	key       string
+	kind      redis.Kind
+	interval  time.Duration
+	metric    autoscalingv2.MetricIdentifier
+	namespace string
+	timeout   time.Duration
+}
+
+// NewRedisCollector initializes a new RedisCollector.
+func NewRedisCollector(_ context.Context, plugin *RedisCollectorPlugin, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (*RedisCollector, error) {
+	if config.Metric.Selector == nil {
+		return nil, NewPermanentConfigError(fmt.Errorf("selector for redis is not specified"))
+	}
+
+	var key, kind string
+	address := plugin.address
+	b := config.binder()
+	b.RequiredString(redisKeyKey, &key)
+	if b.Has(redisKindKey) {
+		b.Enum(redisKindKey, &kind, string(redis.KindList), string(redis.KindStream), string(redis.KindSet))
+	} else {
+		b.Missing(redisKindKey)
+	}
+	if b.String(redisAddressKey, &address) {
+		if _, ok := plugin.allowedAddresses[address]; !ok {
+			b.Invalid(redisAddressKey, "the address is not allowed by --redis-allowed-addresses")
+		}
+	}
+	if err := finishBinding(b, hpa); err != nil {
+		return nil, err
+	}
+
+	timeout, err := parseRequestTimeout(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RedisCollector{
+		client:    plugin.client(address),
+		key:       key,
+		kind:      redis.Kind(kind),
+		interval:  interval,
+		metric:    config.Metric,
+		namespace: hpa.Namespace,
+		timeout:   timeout,
+	}, nil
+}
+
+// GetMetrics returns the length of the key as external metric.
+func (c *RedisCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, error) {
+	ctx, cancel := withRequestTimeout(ctx, c.timeout)
+	defer cancel()
+
+	length, err := c.client.Length(ctx, c.kind, c.key)
+	if err != nil {
+		return nil, NewTransientError(err)
+	}
+
+	metricValue := NewExternalMetric(c.namespace, external_metrics.ExternalMetricValue{
+		MetricName:   c.metric.Name,
+		MetricLabels: c.metric.Selector.MatchLabels,
+		Timestamp:    metav1.Now(),
+		Value:        *resource.NewQuantity(length, resource.DecimalSI),
+	})
+
+	return []CollectedMetric{metricValue}, nil
+}
+
+// Interval returns the interval at which the collector should run.
+func (c *RedisCollector) Interval() time.Duration {
+	return c.interval
+}
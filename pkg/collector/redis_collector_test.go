@@ -0,0 +1,164 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/redis"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newMiniredis starts a miniredis server with a list, a stream and a set.
+func newMiniredis(t *testing.T) *miniredis.Miniredis {
+	server := miniredis.RunT(t)
+	_, err := server.Push("jobs", "a", "b", "c")
+	require.NoError(t, err)
+	for _, id := range []string{"1-1", "1-2", "1-3", "1-4", "1-5"} {
+		_, err = server.XAdd("events", id, []string{"field", "value"})
+		require.NoError(t, err)
+	}
+	_, err = server.SAdd("workers", "a", "b")
+	require.NoError(t, err)
+	return server
+}
+
+func newRedisMetricConfig(config map[string]string) *MetricConfig {
+	return &MetricConfig{
+		MetricTypeName: MetricTypeName{
+			Type:   autoscalingv2.ExternalMetricSourceType,
+			Metric: newMetricIdentifier("queue-length", RedisMetricType),
+		},
+		Config: config,
+	}
+}
+
+func TestRedisCollector(t *testing.T) {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "worker"}}
+
+	for _, tc := range []struct {
+		kind     string
+		key      string
+		expected int64
+	}{
+		{kind: "list", key: "jobs", expected: 3},
+		{kind: "stream", key: "events", expected: 5},
+		{kind: "set", key: "workers", expected: 2},
+		{kind: "list", key: "missing", expected: 0},
+	} {
+		t.Run(tc.kind+"/"+tc.key, func(t *testing.T) {
+			server := newMiniredis(t)
+			plugin, err := NewRedisCollectorPlugin(server.Addr(), nil, redis.Options{})
+			require.NoError(t, err)
+			c, err := plugin.NewCollector(context.Background(), hpa, newRedisMetricConfig(map[string]string{"key": tc.key, "kind": tc.kind}), time.Minute)
+			require.NoError(t, err)
+
+			metrics, err := c.GetMetrics(context.Background())
+			require.NoError(t, err)
+			require.Len(t, metrics, 1)
+			require.Equal(t, "default", metrics[0].Namespace)
+			require.Equal(t, "queue-length", metrics[0].External.MetricName)
+			require.Equal(t, map[string]string{"type": RedisMetricType}, metrics[0].External.MetricLabels)
+			require.Equal(t, tc.expected, metrics[0].External.Value.Value())
+		})
+	}
+}
+
+func TestRedisCollectorSharesClients(t *testing.T) {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "worker"}}
+	server, other := newMiniredis(t), newMiniredis(t)
+	plugin, err := NewRedisCollectorPlugin(server.Addr(), []string{other.Addr()}, redis.Options{})
+	require.NoError(t, err)
+
+	for _, config := range []map[string]string{
+		{"key": "jobs", "kind": "list"},
+		{"key": "events", "kind": "stream"},
+		{"key": "jobs", "kind": "list", "address": other.Addr()},
+		{"key": "workers", "kind": "set", "address": other.Addr()},
+	} {
+		c, err := plugin.NewCollector(context.Background(), hpa, newRedisMetricConfig(config), time.Minute)
+		require.NoError(t, err)
+		_, err = c.GetMetrics(context.Background())
+		require.NoError(t, err)
+	}
+	require.Len(t, plugin.clients, 2)
+
+	// the collectors of a server share the connection of its client.
+	require.Equal(t, 1, server.TotalConnectionCount())
+	require.Equal(t, 1, other.TotalConnectionCount())
+}
+
+func TestRedisCollectorInvalidConfig(t *testing.T) {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "worker"}}
+
+	for _, tc := range []struct {
+		msg    string
+		config map[string]string
+	}{
+		{msg: "missing key", config: map[string]string{"kind": "list"}},
+		{msg: "missing kind", config: map[string]string{"key": "jobs"}},
+		{msg: "unknown kind", config: map[string]string{"key": "jobs", "kind": "hash"}},
+		{msg: "address not allowed", config: map[string]string{"key": "jobs", "kind": "list", "address": "10.0.0.1:6379"}},
+		{msg: "invalid timeout", config: map[string]string{"key": "jobs", "kind": "list", "timeout": "-1s"}},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			plugin, err := NewRedisCollectorPlugin(newMiniredis(t).Addr(), nil, redis.Options{})
+			require.NoError(t, err)
+			_, err = plugin.NewCollector(context.Background(), hpa, newRedisMetricConfig(tc.config), time.Minute)
+			require.ErrorIs(t, err, ErrPermanentConfig)
+			require.Empty(t, plugin.clients)
+		})
+	}
+}
+
+func TestRedisCollectorAuthenticationError(t *testing.T) {
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "worker"}}
+	server := newMiniredis(t)
+	server.RequireAuth("secret")
+	plugin, err := NewRedisCollectorPlugin(server.Addr(), nil, redis.Options{Password: "wrong"})
+	require.NoError(t, err)
+
+	c, err := plugin.NewCollector(context.Background(), hpa, newRedisMetricConfig(map[string]string{"key": "jobs", "kind": "list"}), time.Minute)
+	require.NoError(t, err)
+
+	_, err = c.GetMetrics(context.Background())
+	require.ErrorIs(t, err, ErrTransient)
+	require.True(t, errors.Is(err, redis.ErrAuthentication))
+	require.NotContains(t, err.Error(), "wrong")
+}
+
+func TestReadRedisPassword(t *testing.T) {
+	dir := t.TempDir()
+
+	passwordFile := filepath.Join(dir, "password")
+	require.NoError(t, os.WriteFile(passwordFile, []byte("secret\n"), 0600))
+	password, err := ReadRedisPassword(passwordFile)
+	require.NoError(t, err)
+	require.Equal(t, "secret", password)
+
+	emptyFile := filepath.Join(dir, "empty")
+	require.NoError(t, os.WriteFile(emptyFile, []byte("\n"), 0600))
+	_, err = ReadRedisPassword(emptyFile)
+	require.Error(t, err)
+
+	_, err = ReadRedisPassword(filepath.Join(dir, "missing"))
+	require.Error(t, err)
+}
+
+func TestNewRedisTLSConfig(t *testing.T) {
+	config, err := NewRedisTLSConfig("", false)
+	require.NoError(t, err)
+	require.Nil(t, config.RootCAs)
+	require.False(t, config.InsecureSkipVerify)
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, []byte("not a certificate"), 0600))
+	_, err = NewRedisTLSConfig(caFile, false)
+	require.Error(t, err)
+}
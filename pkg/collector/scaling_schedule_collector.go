@@ -5,8 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"strings"
+	"sync"
 	"time"
 
+	log "github.com/sirupsen/logrus"
 	v1 "github.com/zalando-incubator/kube-metrics-adapter/pkg/apis/zalando.org/v1"
 	scheduledscaling "github.com/zalando-incubator/kube-metrics-adapter/pkg/controller/scheduledscaling"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
@@ -15,22 +18,24 @@ import (
 	"k8s.io/metrics/pkg/apis/custom_metrics"
 )
 
+// The errors below are transient as the referenced schedules are watched
+// and might be created or fixed without changing the HPA.
 var (
 	// ErrScalingScheduleNotFound is returned when a item referenced in
 	// the HPA config is not in the ScalingScheduleCollectorPlugin.store.
-	ErrScalingScheduleNotFound = errors.New("referenced ScalingSchedule not found")
+	ErrScalingScheduleNotFound = NewTransientError(errors.New("referenced ScalingSchedule not found"))
 	// ErrNotScalingScheduleFound is returned when a item returned from
 	// the ScalingScheduleCollectorPlugin.store was expected to
 	// be an ScalingSchedule but the type assertion failed.
-	ErrNotScalingScheduleFound = errors.New("error converting returned object to ScalingSchedule")
+	ErrNotScalingScheduleFound = NewTransientError(errors.New("error converting returned object to ScalingSchedule"))
 	// ErrClusterScalingScheduleNotFound is returned when a item referenced in
 	// the HPA config is not in the ClusterScalingScheduleCollectorPlugin.store.
-	ErrClusterScalingScheduleNotFound = errors.New("referenced ClusterScalingSchedule not found")
+	ErrClusterScalingScheduleNotFound = NewTransientError(errors.New("referenced ClusterScalingSchedule not found"))
 	// ErrNotClusterScalingScheduleFound is returned when a item returned from
 	// the ClusterScalingScheduleCollectorPlugin.store was expected to
 	// be an ClusterScalingSchedule but the type assertion failed. When
 	// returned the type assertion to ScalingSchedule failed too.
-	ErrNotClusterScalingScheduleFound = errors.New("error converting returned object to ClusterScalingSchedule")
+	ErrNotClusterScalingScheduleFound = NewTransientError(errors.New("error converting returned object to ClusterScalingSchedule"))
 )
 
 // Now is the function that returns a time.Time object representing the
@@ -53,10 +58,12 @@ type Store interface {
 // collectors for getting ScalingSchedule configured metrics.
 type ScalingScheduleCollectorPlugin struct {
 	store                Store
+	hasSynced            func() bool
 	now                  Now
 	defaultScalingWindow time.Duration
 	defaultTimeZone      string
 	rampSteps            int
+	maxScheduleDuration  time.Duration
 }
 
 // ClusterScalingScheduleCollectorPlugin is a collector plugin for initializing metrics
@@ -67,6 +74,69 @@ type ClusterScalingScheduleCollectorPlugin struct {
 	defaultScalingWindow time.Duration
 	defaultTimeZone      string
 	rampSteps            int
+	maxScheduleDuration  time.Duration
+	evaluations          *scheduleEvaluationCache
+}
+
+// scheduleEvaluator evaluates the schedules of a scaling schedule spec at
+// the given time.
+type scheduleEvaluator func(spec v1.ScalingScheduleSpec, scheduleNames []string, now time.Time, objectReference custom_metrics.ObjectReference) (int64, error)
+
+// scheduleEvaluationKey identifies the evaluation of a
+// ClusterScalingSchedule in a tick of the collection interval.
+type scheduleEvaluationKey struct {
+	name            string
+	resourceVersion string
+	scheduleNames   string
+	interval        time.Duration
+	tick            time.Time
+}
+
+// scheduleEvaluation is the cached result of an evaluation.
+type scheduleEvaluation struct {
+	value     int64
+	timestamp time.Time
+	err       error
+}
+
+// scheduleEvaluationCache shares the evaluations of ClusterScalingSchedules
+// between the collectors of all HPAs referencing them, so a schedule
+// referenced from many namespaces is only evaluated once per interval.
+type scheduleEvaluationCache struct {
+	sync.Mutex
+	evaluate    scheduleEvaluator
+	evaluations map[scheduleEvaluationKey]scheduleEvaluation
+}
+
+// get returns the evaluation of the schedule in the tick of the interval
+// the time is in. The schedule is evaluated if it wasn't yet in this tick
+// or it was changed since.
+func (e *scheduleEvaluationCache) get(schedule *v1.ClusterScalingSchedule, scheduleNames []string, interval time.Duration, now time.Time, objectReference custom_metrics.ObjectReference) (int64, time.Time, error) {
+	key := scheduleEvaluationKey{
+		name:            schedule.Name,
+		resourceVersion: schedule.ResourceVersion,
+		scheduleNames:   strings.Join(scheduleNames, ","),
+		interval:        interval,
+		tick:            now.Truncate(interval),
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	if evaluation, ok := e.evaluations[key]; ok {
+		return evaluation.value, evaluation.timestamp, evaluation.err
+	}
+
+	// forget the evaluations of past ticks.
+	for k := range e.evaluations {
+		if !now.Before(k.tick.Add(k.interval)) {
+			delete(e.evaluations, k)
+		}
+	}
+
+	value, err := e.evaluate(schedule.Spec, scheduleNames, now, objectReference)
+	e.evaluations[key] = scheduleEvaluation{value: value, timestamp: now, err: err}
+	return value, now, err
 }
 
 // NewScalingScheduleCollectorPlugin initializes a new ScalingScheduleCollectorPlugin.
@@ -88,21 +158,99 @@ func NewClusterScalingScheduleCollectorPlugin(store Store, now Now, defaultScali
 		defaultScalingWindow: defaultScalingWindow,
 		defaultTimeZone:      defaultTimeZone,
 		rampSteps:            rampSteps,
+		evaluations: &scheduleEvaluationCache{
+			evaluate: func(spec v1.ScalingScheduleSpec, scheduleNames []string, now time.Time, objectReference custom_metrics.ObjectReference) (int64, error) {
+				return scheduleValue(spec, scheduleNames, defaultScalingWindow, defaultTimeZone, rampSteps, now, objectReference)
+			},
+			evaluations: map[scheduleEvaluationKey]scheduleEvaluation{},
+		},
 	}, nil
 }
 
+// SetStoreSynced sets the function reporting if the store is synced. Until
+// it's synced, collectors are created without checking that the referenced
+// ScalingSchedule exists.
+func (c *ScalingScheduleCollectorPlugin) SetStoreSynced(hasSynced func() bool) {
+	c.hasSynced = hasSynced
+}
+
+// SetMaxScheduleDuration sets the max duration of the schedules above which
+// a warning is logged when a collector is created. Zero uses the defaults
+// per schedule type.
+func (c *ScalingScheduleCollectorPlugin) SetMaxScheduleDuration(maxDuration time.Duration) {
+	c.maxScheduleDuration = maxDuration
+}
+
+// SetMaxScheduleDuration sets the max duration of the schedules above which
+// a warning is logged when a collector is created. Zero uses the defaults
+// per schedule type.
+func (c *ClusterScalingScheduleCollectorPlugin) SetMaxScheduleDuration(maxDuration time.Duration) {
+	c.maxScheduleDuration = maxDuration
+}
+
+// warnInvalidSchedules logs a warning for each schedule of the referenced
+// scaling schedule which ends before it starts or is longer than the max
+// duration. The collector is still created as such schedules are evaluated
+// anyway, but they might keep the target scaled up far longer than intended.
+func warnInvalidSchedules(store Store, key string, hpa *autoscalingv2.HorizontalPodAutoscaler, maxDuration time.Duration) {
+	item, exists, err := store.GetByKey(key)
+	if err != nil || !exists {
+		return
+	}
+
+	var kind string
+	var spec v1.ScalingScheduleSpec
+	switch schedule := item.(type) {
+	case *v1.ScalingSchedule:
+		kind, spec = "ScalingSchedule", schedule.Spec
+	case *v1.ClusterScalingSchedule:
+		kind, spec = "ClusterScalingSchedule", schedule.Spec
+	default:
+		return
+	}
+
+	for _, problem := range scheduledscaling.CheckSchedules(spec.Schedules, maxDuration) {
+		log.Warnf("%s %s referenced by HPA %s/%s: %s", kind, key, hpa.Namespace, hpa.Name, problem)
+	}
+}
+
 // NewCollector initializes a new scaling schedule collector from the
 // specified HPA. It's the only required method to implement the
 // collector.CollectorPlugin interface.
 func (c *ScalingScheduleCollectorPlugin) NewCollector(_ context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
-	return NewScalingScheduleCollector(c.store, c.defaultScalingWindow, c.defaultTimeZone, c.rampSteps, c.now, hpa, config, interval)
+	key := fmt.Sprintf("%s/%s", config.ObjectReference.Namespace, config.ObjectReference.Name)
+	collector, err := NewScalingScheduleCollector(c.store, c.defaultScalingWindow, c.defaultTimeZone, c.rampSteps, c.now, hpa, config, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	// the store of an informer which isn't synced yet lacks schedules
+	// which exist.
+	if !collector.skipExistenceCheck && (c.hasSynced == nil || c.hasSynced()) {
+		_, exists, err := c.store.GetByKey(key)
+		if err != nil {
+			return nil, NewTransientError(err)
+		}
+		if !exists {
+			return nil, newObjectNotFoundError(config.ObjectReference)
+		}
+	}
+
+	warnInvalidSchedules(c.store, key, hpa, c.maxScheduleDuration)
+	return collector, nil
 }
 
 // NewCollector initializes a new cluster wide scaling schedule
 // collector from the specified HPA. It's the only required method to
 // implement the collector.CollectorPlugin interface.
 func (c *ClusterScalingScheduleCollectorPlugin) NewCollector(_ context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
-	return NewClusterScalingScheduleCollector(c.store, c.defaultScalingWindow, c.defaultTimeZone, c.rampSteps, c.now, hpa, config, interval)
+	warnInvalidSchedules(c.store, config.ObjectReference.Name, hpa, c.maxScheduleDuration)
+	collector, err := NewClusterScalingScheduleCollector(c.store, c.defaultScalingWindow, c.defaultTimeZone, c.rampSteps, c.now, hpa, config, interval)
+	if err != nil {
+		return nil, err
+	}
+	collector.evaluations = c.evaluations
+	return collector, nil
 }
 
 // ScalingScheduleCollector is a metrics collector for time based
@@ -115,6 +263,9 @@ type ScalingScheduleCollector struct {
 // scaling metrics.
 type ClusterScalingScheduleCollector struct {
 	scalingScheduleCollector
+	// evaluations is shared by the collectors of a plugin. Without it
+	// the schedule is evaluated on every collection.
+	evaluations *scheduleEvaluationCache
 }
 
 // scalingScheduleCollector is a representation of the internal data
@@ -131,10 +282,19 @@ type scalingScheduleCollector struct {
 	defaultScalingWindow time.Duration
 	defaultTimeZone      string
 	rampSteps            int
+	scheduleNames        []string
+	// skipExistenceCheck disables checking that the referenced
+	// ScalingSchedule exists when the collector is created.
+	skipExistenceCheck bool
 }
 
 // NewScalingScheduleCollector initializes a new ScalingScheduleCollector.
 func NewScalingScheduleCollector(store Store, defaultScalingWindow time.Duration, defaultTimeZone string, rampSteps int, now Now, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (*ScalingScheduleCollector, error) {
+	scheduleNames, skipExistenceCheck, err := bindScheduleConfig(hpa, config)
+	if err != nil {
+		return nil, err
+	}
+
 	return &ScalingScheduleCollector{
 		scalingScheduleCollector{
 			store:                store,
@@ -147,14 +307,21 @@ func NewScalingScheduleCollector(store Store, defaultScalingWindow time.Duration
 			defaultScalingWindow: defaultScalingWindow,
 			defaultTimeZone:      defaultTimeZone,
 			rampSteps:            rampSteps,
+			scheduleNames:        scheduleNames,
+			skipExistenceCheck:   skipExistenceCheck,
 		},
 	}, nil
 }
 
 // NewClusterScalingScheduleCollector initializes a new ScalingScheduleCollector.
 func NewClusterScalingScheduleCollector(store Store, defaultScalingWindow time.Duration, defaultTimeZone string, rampSteps int, now Now, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (*ClusterScalingScheduleCollector, error) {
+	scheduleNames, skipExistenceCheck, err := bindScheduleConfig(hpa, config)
+	if err != nil {
+		return nil, err
+	}
+
 	return &ClusterScalingScheduleCollector{
-		scalingScheduleCollector{
+		scalingScheduleCollector: scalingScheduleCollector{
 			store:                store,
 			now:                  now,
 			objectReference:      config.ObjectReference,
@@ -165,10 +332,26 @@ func NewClusterScalingScheduleCollector(store Store, defaultScalingWindow time.D
 			defaultScalingWindow: defaultScalingWindow,
 			defaultTimeZone:      defaultTimeZone,
 			rampSteps:            rampSteps,
+			scheduleNames:        scheduleNames,
+			skipExistenceCheck:   skipExistenceCheck,
 		},
 	}, nil
 }
 
+// bindScheduleConfig binds the optional schedule-names config restricting
+// the schedules considered for the metric and the skip-existence-check
+// config.
+func bindScheduleConfig(hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig) ([]string, bool, error) {
+	var scheduleNames []string
+	b := config.binder()
+	b.List(scheduledscaling.ScheduleNamesConfigKey, &scheduleNames)
+	skipExistenceCheck := bindSkipExistenceCheck(b)
+	if err := finishBinding(b, hpa); err != nil {
+		return nil, false, err
+	}
+	return scheduleNames, skipExistenceCheck, nil
+}
+
 // GetMetrics is the main implementation for collector.Collector interface
 func (c *ScalingScheduleCollector) GetMetrics(_ context.Context) ([]CollectedMetric, error) {
 	scalingScheduleInterface, exists, err := c.store.GetByKey(fmt.Sprintf("%s/%s", c.objectReference.Namespace, c.objectReference.Name))
@@ -176,14 +359,21 @@ func (c *ScalingScheduleCollector) GetMetrics(_ context.Context) ([]CollectedMet
 		return nil, ErrScalingScheduleNotFound
 	}
 	if err != nil {
-		return nil, fmt.Errorf("unexpected error retrieving the ScalingSchedule: %s", err.Error())
+		return nil, NewTransientError(fmt.Errorf("unexpected error retrieving the ScalingSchedule: %s", err.Error()))
 	}
 
 	scalingSchedule, ok := scalingScheduleInterface.(*v1.ScalingSchedule)
 	if !ok {
 		return nil, ErrNotScalingScheduleFound
 	}
-	return calculateMetrics(scalingSchedule.Spec, c.defaultScalingWindow, c.defaultTimeZone, c.rampSteps, c.now(), c.objectReference, c.metric)
+
+	// a ScalingSchedule which is being deleted might still be in the
+	// store, e.g. after a resync, but must no longer scale the target.
+	if scalingSchedule.DeletionTimestamp != nil {
+		return nil, ErrScalingScheduleNotFound
+	}
+
+	return calculateMetrics(scalingSchedule.Spec, c.scheduleNames, c.defaultScalingWindow, c.defaultTimeZone, c.rampSteps, c.now(), c.objectReference, c.metric)
 }
 
 // GetMetrics is the main implementation for collector.Collector interface
@@ -193,7 +383,7 @@ func (c *ClusterScalingScheduleCollector) GetMetrics(_ context.Context) ([]Colle
 		return nil, ErrClusterScalingScheduleNotFound
 	}
 	if err != nil {
-		return nil, fmt.Errorf("unexpected error retrieving the ClusterScalingSchedule: %s", err.Error())
+		return nil, NewTransientError(fmt.Errorf("unexpected error retrieving the ClusterScalingSchedule: %s", err.Error()))
 	}
 
 	// The [cache.Store][0] returns the v1.ClusterScalingSchedule items as
@@ -216,7 +406,24 @@ func (c *ClusterScalingScheduleCollector) GetMetrics(_ context.Context) ([]Colle
 		clusterScalingSchedule = v1.ClusterScalingSchedule(*scalingSchedule)
 	}
 
-	return calculateMetrics(clusterScalingSchedule.Spec, c.defaultScalingWindow, c.defaultTimeZone, c.rampSteps, c.now(), c.objectReference, c.metric)
+	if clusterScalingSchedule.DeletionTimestamp != nil {
+		return nil, ErrClusterScalingScheduleNotFound
+	}
+
+	// the ClusterScalingSchedule is cluster scoped, the metric is stored
+	// in the namespace of the HPA referencing it.
+	objectReference := c.objectReference
+	objectReference.Namespace = c.hpa.Namespace
+
+	if c.evaluations == nil || c.interval <= 0 {
+		return calculateMetrics(clusterScalingSchedule.Spec, c.scheduleNames, c.defaultScalingWindow, c.defaultTimeZone, c.rampSteps, c.now(), objectReference, c.metric)
+	}
+
+	value, timestamp, err := c.evaluations.get(&clusterScalingSchedule, c.scheduleNames, c.interval, c.now(), objectReference)
+	if err != nil {
+		return nil, err
+	}
+	return scheduleMetrics(value, timestamp, objectReference, c.metric), nil
 }
 
 // Interval returns the interval at which the collector should run.
@@ -229,52 +436,69 @@ func (c *ClusterScalingScheduleCollector) Interval() time.Duration {
 	return c.interval
 }
 
-func calculateMetrics(spec v1.ScalingScheduleSpec, defaultScalingWindow time.Duration, defaultTimeZone string, rampSteps int, now time.Time, objectReference custom_metrics.ObjectReference, metric autoscalingv2.MetricIdentifier) ([]CollectedMetric, error) {
+func calculateMetrics(spec v1.ScalingScheduleSpec, scheduleNames []string, defaultScalingWindow time.Duration, defaultTimeZone string, rampSteps int, now time.Time, objectReference custom_metrics.ObjectReference, metric autoscalingv2.MetricIdentifier) ([]CollectedMetric, error) {
+	value, err := scheduleValue(spec, scheduleNames, defaultScalingWindow, defaultTimeZone, rampSteps, now, objectReference)
+	if err != nil {
+		return nil, err
+	}
+	return scheduleMetrics(value, now, objectReference, metric), nil
+}
+
+// scheduleValue returns the value of the schedules of the spec at the
+// given time.
+func scheduleValue(spec v1.ScalingScheduleSpec, scheduleNames []string, defaultScalingWindow time.Duration, defaultTimeZone string, rampSteps int, now time.Time, objectReference custom_metrics.ObjectReference) (int64, error) {
+	schedules, err := scheduledscaling.FilterSchedules(spec.Schedules, scheduleNames)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s config for %s '%s': %w", scheduledscaling.ScheduleNamesConfigKey, objectReference.Kind, objectReference.Name, err)
+	}
+
 	scalingWindowDuration := defaultScalingWindow
 	if spec.ScalingWindowDurationMinutes != nil {
 		scalingWindowDuration = time.Duration(*spec.ScalingWindowDurationMinutes) * time.Minute
 	}
 	if scalingWindowDuration < 0 {
-		return nil, fmt.Errorf("scaling window duration cannot be negative")
+		return 0, fmt.Errorf("scaling window duration cannot be negative")
 	}
 
 	value := int64(0)
-	for _, schedule := range spec.Schedules {
-		startTime, endTime, err := scheduledscaling.ScheduleStartEnd(now, schedule, defaultTimeZone)
+	for _, schedule := range schedules {
+		startTime, endTime, scheduleValue, err := scheduledscaling.ScheduleWindow(now, schedule, defaultTimeZone)
 		if err != nil {
-			return nil, err
+			return 0, err
 		}
-		value = maxInt64(value, valueForEntry(now, startTime, endTime, scalingWindowDuration, rampSteps, schedule.Value))
+		value = maxInt64(value, valueForEntry(now, startTime, endTime, scalingWindowDuration, rampSteps, scheduleValue))
 	}
 
+	return value, nil
+}
+
+// scheduleMetrics returns the collected metric of a schedule value.
+func scheduleMetrics(value int64, now time.Time, objectReference custom_metrics.ObjectReference, metric autoscalingv2.MetricIdentifier) []CollectedMetric {
 	return []CollectedMetric{
-		{
-			Type:      autoscalingv2.ObjectMetricSourceType,
-			Namespace: objectReference.Namespace,
-			Custom: custom_metrics.MetricValue{
-				DescribedObject: objectReference,
-				Timestamp:       metav1.Time{Time: now},
-				Value:           *resource.NewMilliQuantity(value*1000, resource.DecimalSI),
-				Metric:          custom_metrics.MetricIdentifier(metric),
-			},
-		},
-	}, nil
+		NewCustomMetric(autoscalingv2.ObjectMetricSourceType, custom_metrics.MetricValue{
+			DescribedObject: objectReference,
+			Timestamp:       metav1.Time{Time: now},
+			Value:           *resource.NewMilliQuantity(value*1000, resource.DecimalSI),
+			Metric:          custom_metrics.MetricIdentifier(metric),
+		}),
+	}
 }
 
+// valueForEntry returns the value of a schedule at the timestamp. The
+// schedule is active within the scaling window as decided by
+// scheduledscaling.WithinScalingWindow, ramping up before the start and down
+// after the end.
 func valueForEntry(timestamp time.Time, startTime time.Time, endTime time.Time, scalingWindowDuration time.Duration, rampSteps int, value int64) int64 {
-	scaleUpStart := startTime.Add(-scalingWindowDuration)
-	scaleUpEnd := endTime.Add(scalingWindowDuration)
-
+	if !scheduledscaling.WithinScalingWindow(timestamp, startTime, endTime, scalingWindowDuration) {
+		return 0
+	}
 	if scheduledscaling.Between(timestamp, startTime, endTime) {
 		return value
 	}
-	if scheduledscaling.Between(timestamp, scaleUpStart, startTime) {
-		return scaledValue(timestamp, scaleUpStart, scalingWindowDuration, rampSteps, value)
-	}
-	if scheduledscaling.Between(timestamp, endTime, scaleUpEnd) {
-		return scaledValue(scaleUpEnd, timestamp, scalingWindowDuration, rampSteps, value)
+	if timestamp.Before(startTime) {
+		return scaledValue(timestamp, startTime.Add(-scalingWindowDuration), scalingWindowDuration, rampSteps, value)
 	}
-	return 0
+	return scaledValue(endTime.Add(scalingWindowDuration), timestamp, scalingWindowDuration, rampSteps, value)
 }
 
 // The HPA has a rule to do not scale up or down if the change in the
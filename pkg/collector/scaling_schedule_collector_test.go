@@ -12,6 +12,7 @@ import (
 	scheduledscaling "github.com/zalando-incubator/kube-metrics-adapter/pkg/controller/scheduledscaling"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/metrics/pkg/apis/custom_metrics"
 )
 
 const (
@@ -692,6 +693,8 @@ func TestScalingScheduleObjectNotPresentReturnsError(t *testing.T) {
 	configs, err := ParseHPAMetrics(hpa)
 	require.NoError(t, err)
 	require.Len(t, configs, 2)
+	// the ScalingSchedule is only created later.
+	configs[0].Config["skip-existence-check"] = "true"
 
 	collectorFactory := NewCollectorFactory()
 	err = collectorFactory.RegisterObjectCollector("ScalingSchedule", "", plugin)
@@ -731,6 +734,92 @@ func TestScalingScheduleObjectNotPresentReturnsError(t *testing.T) {
 	require.Equal(t, ErrNotClusterScalingScheduleFound, err)
 }
 
+func TestScalingScheduleExistenceCheck(t *testing.T) {
+	schedules := getSchedules([]schedule{{
+		kind:     "OneTime",
+		date:     time.Now().Add(-time.Minute).Format(time.RFC3339),
+		duration: 60,
+		value:    100,
+	}})
+
+	for _, tc := range []struct {
+		msg       string
+		schedule  string
+		config    map[string]string
+		hasSynced func() bool
+		exists    bool
+	}{
+		{msg: "present schedule", schedule: "scalingScheduleName", exists: true},
+		{msg: "missing schedule", schedule: "other"},
+		{msg: "schedule in other namespace", schedule: "elsewhere"},
+		{msg: "skipped check", schedule: "other", config: map[string]string{"skip-existence-check": "true"}, exists: true},
+		{msg: "store not synced", schedule: "other", hasSynced: func() bool { return false }, exists: true},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			store := newMockStore("scalingScheduleName", "namespace", nil, schedules)
+			store.d["other-namespace/elsewhere"] = store.d["namespace/scalingScheduleName"]
+			plugin, err := NewScalingScheduleCollectorPlugin(store, time.Now, defaultScalingWindowDuration, defaultTimeZone, defaultRampSteps)
+			require.NoError(t, err)
+			plugin.SetStoreSynced(tc.hasSynced)
+
+			hpa := makeScalingScheduleHPA("namespace", tc.schedule)
+			configs, err := ParseHPAMetrics(hpa)
+			require.NoError(t, err)
+			for key, value := range tc.config {
+				configs[0].Config[key] = value
+			}
+
+			_, err = plugin.NewCollector(context.Background(), hpa, configs[0], 0)
+			if tc.exists {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, ErrPermanentConfig)
+			var notFound *ObjectNotFoundError
+			require.ErrorAs(t, err, &notFound)
+			require.Equal(t, ObjectNotFoundError{Kind: "ScalingSchedule", Name: tc.schedule, Namespace: "namespace"}, *notFound)
+			require.Contains(t, err.Error(), "object '"+tc.schedule+"' of kind ScalingSchedule not found in namespace namespace")
+		})
+	}
+}
+
+func TestScalingScheduleBeingDeletedReturnsError(t *testing.T) {
+	deletionTimestamp := metav1.Now()
+	schedules := getSchedules([]schedule{{
+		kind:     "OneTime",
+		date:     time.Now().Add(-time.Minute).Format(time.RFC3339),
+		duration: 60,
+		value:    100,
+	}})
+
+	store := newMockStore("scalingScheduleName", "namespace", nil, schedules)
+	store.d["namespace/scalingScheduleName"].(*v1.ScalingSchedule).DeletionTimestamp = &deletionTimestamp
+	plugin, err := NewScalingScheduleCollectorPlugin(store, time.Now, defaultScalingWindowDuration, defaultTimeZone, defaultRampSteps)
+	require.NoError(t, err)
+
+	clusterStore := newClusterMockStore("scalingScheduleName", nil, schedules)
+	clusterStore.d["scalingScheduleName"].(*v1.ClusterScalingSchedule).DeletionTimestamp = &deletionTimestamp
+	clusterPlugin, err := NewClusterScalingScheduleCollectorPlugin(clusterStore, time.Now, defaultScalingWindowDuration, defaultTimeZone, defaultRampSteps)
+	require.NoError(t, err)
+
+	hpa := makeScalingScheduleHPA("namespace", "scalingScheduleName")
+	configs, err := ParseHPAMetrics(hpa)
+	require.NoError(t, err)
+	require.Len(t, configs, 2)
+
+	collector, err := plugin.NewCollector(context.Background(), hpa, configs[0], 0)
+	require.NoError(t, err)
+
+	clusterCollector, err := clusterPlugin.NewCollector(context.Background(), hpa, configs[1], 0)
+	require.NoError(t, err)
+
+	_, err = collector.GetMetrics(context.Background())
+	require.Equal(t, ErrScalingScheduleNotFound, err)
+
+	_, err = clusterCollector.GetMetrics(context.Background())
+	require.Equal(t, ErrClusterScalingScheduleNotFound, err)
+}
+
 func TestReturnsErrorWhenStoreDoes(t *testing.T) {
 	store := mockStore{
 		make(map[string]interface{}),
@@ -756,6 +845,11 @@ func TestReturnsErrorWhenStoreDoes(t *testing.T) {
 	err = collectorFactory.RegisterObjectCollector("ClusterScalingSchedule", "", clusterPlugin)
 	require.NoError(t, err)
 
+	// the existence of the ScalingSchedule is checked with the store.
+	_, err = collectorFactory.NewCollector(context.Background(), hpa, configs[0], 0)
+	require.ErrorIs(t, err, ErrTransient)
+
+	configs[0].Config["skip-existence-check"] = "true"
 	collector, err := collectorFactory.NewCollector(context.Background(), hpa, configs[0], 0)
 	require.NoError(t, err)
 	collector, ok := collector.(*ScalingScheduleCollector)
@@ -914,3 +1008,177 @@ func makeScalingScheduleHPA(namespace, scalingScheduleName string) *autoscalingv
 		},
 	}
 }
+
+func TestScalingScheduleCollectorScheduleNames(t *testing.T) {
+	date := v1.ScheduleDate(time.Now().Add(-time.Minute).Format(time.RFC3339))
+	schedules := []v1.Schedule{
+		{Name: "morning", Type: v1.OneTimeSchedule, Date: &date, DurationMinutes: 60, Value: 100},
+		{Name: "evening", Type: v1.OneTimeSchedule, Date: &date, DurationMinutes: 60, Value: 300},
+		{Type: v1.OneTimeSchedule, Date: &date, DurationMinutes: 60, Value: 500},
+	}
+	store := newMockStore("shared", "namespace", nil, schedules)
+	plugin, err := NewScalingScheduleCollectorPlugin(store, time.Now, defaultScalingWindowDuration, defaultTimeZone, defaultRampSteps)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		msg           string
+		scheduleNames string
+		expected      int64
+		err           bool
+	}{
+		{
+			msg:      "all schedules are considered without schedule names",
+			expected: 500,
+		},
+		{
+			msg:           "a single named schedule",
+			scheduleNames: "morning",
+			expected:      100,
+		},
+		{
+			msg:           "a subset of named schedules",
+			scheduleNames: "morning, evening",
+			expected:      300,
+		},
+		{
+			msg:           "unknown schedule names",
+			scheduleNames: "morning,night",
+			err:           true,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			hpa := makeScalingScheduleHPA("namespace", "shared")
+			hpa.Spec.Metrics = hpa.Spec.Metrics[:1]
+			if tc.scheduleNames != "" {
+				hpa.Annotations = map[string]string{
+					"metric-config.object.shared.scaling-schedule/schedule-names": tc.scheduleNames,
+				}
+			}
+
+			configs, err := ParseHPAMetrics(hpa)
+			require.NoError(t, err)
+			require.Len(t, configs, 1)
+
+			collector, err := plugin.NewCollector(context.Background(), hpa, configs[0], 0)
+			require.NoError(t, err)
+
+			metrics, err := collector.GetMetrics(context.Background())
+			if tc.err {
+				require.ErrorIs(t, err, scheduledscaling.ErrUnknownScheduleNames)
+				require.Contains(t, err.Error(), "night")
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, metrics, 1)
+			require.Equal(t, tc.expected, metrics[0].Custom.Value.Value())
+		})
+	}
+}
+
+func TestClusterScalingScheduleCollectorSharedEvaluation(t *testing.T) {
+	scheduleName := "cluster-schedule"
+	date := v1.ScheduleDate(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC).Format(time.RFC3339))
+	store := newClusterMockStore(scheduleName, nil, []v1.Schedule{
+		{Type: v1.OneTimeSchedule, Date: &date, DurationMinutes: 60, Value: 100},
+	})
+
+	now := time.Date(2024, 1, 1, 12, 10, 0, 0, time.UTC)
+	plugin, err := NewClusterScalingScheduleCollectorPlugin(store, func() time.Time { return now }, defaultScalingWindowDuration, defaultTimeZone, defaultRampSteps)
+	require.NoError(t, err)
+
+	evaluations := 0
+	evaluate := plugin.evaluations.evaluate
+	plugin.evaluations.evaluate = func(spec v1.ScalingScheduleSpec, scheduleNames []string, now time.Time, objectReference custom_metrics.ObjectReference) (int64, error) {
+		evaluations++
+		return evaluate(spec, scheduleNames, now, objectReference)
+	}
+
+	var collectors []Collector
+	for _, namespace := range []string{"team-a", "team-b"} {
+		hpa := makeScalingScheduleHPA(namespace, scheduleName)
+		configs, err := ParseHPAMetrics(hpa)
+		require.NoError(t, err)
+		c, err := plugin.NewCollector(context.Background(), hpa, configs[1], time.Minute)
+		require.NoError(t, err)
+		collectors = append(collectors, c)
+	}
+
+	collect := func() {
+		for i, namespace := range []string{"team-a", "team-b"} {
+			collected, err := collectors[i].GetMetrics(context.Background())
+			require.NoError(t, err)
+			require.Len(t, collected, 1)
+			require.EqualValues(t, 100, collected[0].Custom.Value.Value())
+			require.Equal(t, namespace, collected[0].Namespace)
+			require.Equal(t, namespace, collected[0].Custom.DescribedObject.Namespace)
+		}
+	}
+
+	collect()
+	require.Equal(t, 1, evaluations)
+
+	// the second collector runs later in the same tick.
+	now = now.Add(30 * time.Second)
+	collect()
+	require.Equal(t, 1, evaluations)
+
+	now = now.Add(time.Minute)
+	collect()
+	require.Equal(t, 2, evaluations)
+	require.Len(t, plugin.evaluations.evaluations, 1)
+}
+
+func TestScalingScheduleCollectorBoundaries(t *testing.T) {
+	start := time.Date(2024, time.January, 1, 9, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	date := v1.ScheduleDate(start.Format(time.RFC3339))
+
+	// the same instants as in the controller's TestActiveSchedulesBoundaries.
+	for _, tc := range []struct {
+		msg            string
+		now            time.Time
+		scalingWindow  int64
+		expectedActive bool
+		expectedValue  int64
+	}{
+		{msg: "exactly start", now: start, scalingWindow: 10, expectedActive: true, expectedValue: 100},
+		{msg: "exactly end", now: end, scalingWindow: 10, expectedActive: true, expectedValue: 100},
+		{msg: "exactly end plus ramp", now: end.Add(10 * time.Minute), scalingWindow: 10, expectedActive: false, expectedValue: 0},
+		{msg: "exactly end without ramp", now: end, scalingWindow: 0, expectedActive: false, expectedValue: 0},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			spec := v1.ScalingScheduleSpec{
+				ScalingWindowDurationMinutes: &tc.scalingWindow,
+				Schedules: []v1.Schedule{
+					{Type: v1.OneTimeSchedule, Date: &date, DurationMinutes: 60, Value: 100},
+				},
+			}
+
+			value, err := scheduleValue(spec, nil, defaultScalingWindowDuration, defaultTimeZone, defaultRampSteps, tc.now, custom_metrics.ObjectReference{})
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedValue, value)
+			require.Equal(t, tc.expectedActive, scheduledscaling.WithinScalingWindow(tc.now, start, end, time.Duration(tc.scalingWindow)*time.Minute))
+		})
+	}
+}
+
+func TestScalingScheduleCollectorInvertedOneTimeSchedule(t *testing.T) {
+	start := time.Date(2024, time.January, 5, 9, 0, 0, 0, time.UTC)
+	date := v1.ScheduleDate(start.Format(time.RFC3339))
+	endDate := v1.ScheduleDate(start.Add(-time.Hour).Format(time.RFC3339))
+
+	spec := v1.ScalingScheduleSpec{
+		Schedules: []v1.Schedule{
+			{Type: v1.OneTimeSchedule, Date: &date, EndDate: &endDate, Value: 100},
+		},
+	}
+
+	_, err := scheduleValue(spec, nil, defaultScalingWindowDuration, defaultTimeZone, defaultRampSteps, start, custom_metrics.ObjectReference{})
+	require.ErrorIs(t, err, scheduledscaling.ErrInvertedSchedule)
+
+	// with a duration the schedule falls back to the duration.
+	spec.Schedules[0].DurationMinutes = 60
+	value, err := scheduleValue(spec, nil, defaultScalingWindowDuration, defaultTimeZone, defaultRampSteps, start, custom_metrics.ObjectReference{})
+	require.NoError(t, err)
+	require.Equal(t, int64(100), value)
+}
@@ -0,0 +1,80 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+)
+
+const (
+	// SelfMetricType defines the metric type for metrics describing the
+	// state of the kube-metrics-adapter itself.
+	SelfMetricType = "kube-metrics-adapter-self"
+)
+
+// CollectionLagSource is the source of the adapter's own collection lag.
+type CollectionLagSource interface {
+	// LaggingCollectorsRatio returns the fraction of scheduled
+	// collectors whose last collection is overdue.
+	LaggingCollectorsRatio() float64
+}
+
+// SelfCollectorPlugin defines a plugin for creating collectors that expose
+// the collection lag of the adapter itself.
+type SelfCollectorPlugin struct {
+	source CollectionLagSource
+}
+
+// NewSelfCollectorPlugin initializes a new SelfCollectorPlugin.
+func NewSelfCollectorPlugin(source CollectionLagSource) (*SelfCollectorPlugin, error) {
+	return &SelfCollectorPlugin{
+		source: source,
+	}, nil
+}
+
+// NewCollector initializes a new self collector from the specified HPA.
+func (p *SelfCollectorPlugin) NewCollector(_ context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
+	if config.Metric.Selector == nil {
+		return nil, fmt.Errorf("selector for %s is not specified", SelfMetricType)
+	}
+
+	return &SelfCollector{
+		source:    p.source,
+		interval:  interval,
+		metric:    config.Metric,
+		namespace: hpa.Namespace,
+	}, nil
+}
+
+// SelfCollector defines a collector exposing the fraction of lagging
+// collectors of the adapter.
+type SelfCollector struct {
+	source    CollectionLagSource
+	interval  time.Duration
+	metric    autoscalingv2.MetricIdentifier
+	namespace string
+}
+
+// GetMetrics returns the current fraction of lagging collectors.
+func (c *SelfCollector) GetMetrics(_ context.Context) ([]CollectedMetric, error) {
+	ratio := c.source.LaggingCollectorsRatio()
+
+	metricValue := NewExternalMetric(c.namespace, external_metrics.ExternalMetricValue{
+		MetricName:   c.metric.Name,
+		MetricLabels: c.metric.Selector.MatchLabels,
+		Timestamp:    metav1.Now(),
+		Value:        *resource.NewMilliQuantity(int64(ratio*1000), resource.DecimalSI),
+	})
+
+	return []CollectedMetric{metricValue}, nil
+}
+
+// Interval returns the interval at which the collector should run.
+func (c *SelfCollector) Interval() time.Duration {
+	return c.interval
+}
@@ -0,0 +1,65 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type fakeCollectionLagSource struct {
+	ratio float64
+}
+
+func (s fakeCollectionLagSource) LaggingCollectorsRatio() float64 {
+	return s.ratio
+}
+
+func TestSelfCollector(t *testing.T) {
+	plugin, err := NewSelfCollectorPlugin(fakeCollectionLagSource{ratio: 0.25})
+	require.NoError(t, err)
+
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default"},
+	}
+	config := &MetricConfig{
+		MetricTypeName: MetricTypeName{
+			Type: autoscalingv2.ExternalMetricSourceType,
+			Metric: autoscalingv2.MetricIdentifier{
+				Name: "collection-lag",
+				Selector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"type": SelfMetricType},
+				},
+			},
+		},
+	}
+
+	c, err := plugin.NewCollector(context.Background(), hpa, config, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, c.Interval())
+
+	metrics, err := c.GetMetrics(context.Background())
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	require.Equal(t, "default", metrics[0].Namespace)
+	require.Equal(t, "collection-lag", metrics[0].External.MetricName)
+	require.Equal(t, int64(250), metrics[0].External.Value.MilliValue())
+}
+
+func TestSelfCollectorMissingSelector(t *testing.T) {
+	plugin, err := NewSelfCollectorPlugin(fakeCollectionLagSource{})
+	require.NoError(t, err)
+
+	config := &MetricConfig{
+		MetricTypeName: MetricTypeName{
+			Type:   autoscalingv2.ExternalMetricSourceType,
+			Metric: autoscalingv2.MetricIdentifier{Name: "collection-lag"},
+		},
+	}
+
+	_, err = plugin.NewCollector(context.Background(), &autoscalingv2.HorizontalPodAutoscaler{}, config, time.Minute)
+	require.Error(t, err)
+}
@@ -6,27 +6,63 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"path"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	argoRolloutsClient "github.com/argoproj/argo-rollouts/pkg/client/clientset/versioned"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
 	rgv1 "github.com/szuecs/routegroup-client/apis/zalando.org/v1"
 	rginterface "github.com/szuecs/routegroup-client/client/clientset/versioned"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/metrics/pkg/apis/custom_metrics"
+
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/annotations"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/sleep"
 )
 
 const (
 	rpsQuery                  = `scalar(sum(rate(skipper_serve_host_duration_seconds_count{host=~"%s"}[1m])) * %.4f)`
 	rpsMetricName             = "requests-per-second"
 	rpsMetricBackendSeparator = ","
+	skipperBackendConfigKey   = "backend"
+	// excludeHostsConfigKey is the config key of the skipper and external
+	// RPS collectors listing hosts excluded from the query.
+	excludeHostsConfigKey = "exclude-hosts"
+	// notFoundRetriesConfigKey is the config key of the number of times
+	// the lookup of the Ingress or RouteGroup is retried if it's not
+	// found.
+	notFoundRetriesConfigKey = "not-found-retries"
+	// notFoundRetryBackoffConfigKey is the config key of the backoff
+	// before the first retry, doubled for each further retry.
+	notFoundRetryBackoffConfigKey = "not-found-retry-backoff"
+	// tolerateMissingObjectForConfigKey is the config key of the grace
+	// period the last successfully built query is reused for while the
+	// Ingress or RouteGroup is missing.
+	tolerateMissingObjectForConfigKey = "tolerate-missing-object-for"
+
+	defaultNotFoundRetries      = 2
+	defaultNotFoundRetryBackoff = 500 * time.Millisecond
+	maxNotFoundRetries          = 10
 )
 
 var (
 	errBackendNameMissing = errors.New("backend name must be specified for requests-per-second when traffic switching is used")
+
+	// SkipperInvalidBackendWeights is the total number of backend weights
+	// annotations skipped because they couldn't be parsed.
+	SkipperInvalidBackendWeights = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kube_metrics_adapter_skipper_invalid_backend_weights",
+		Help: "The total number of backend weights annotations skipped because they couldn't be parsed",
+	}, []string{"annotation"})
 )
 
 // SkipperCollectorPlugin is a collector plugin for initializing metrics
@@ -34,6 +70,7 @@ var (
 type SkipperCollectorPlugin struct {
 	client             kubernetes.Interface
 	rgClient           rginterface.Interface
+	argoRolloutsClient argoRolloutsClient.Interface
 	plugin             CollectorPlugin
 	backendAnnotations []string
 }
@@ -43,16 +80,17 @@ func NewSkipperCollectorPlugin(client kubernetes.Interface, rgClient rginterface
 	return &SkipperCollectorPlugin{
 		client:             client,
 		rgClient:           rgClient,
+		argoRolloutsClient: prometheusPlugin.argoRolloutsClient,
 		plugin:             prometheusPlugin,
 		backendAnnotations: backendAnnotations,
 	}, nil
 }
 
 // NewCollector initializes a new skipper collector from the specified HPA.
-func (c *SkipperCollectorPlugin) NewCollector(_ context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
+func (c *SkipperCollectorPlugin) NewCollector(ctx context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
 	if strings.HasPrefix(config.Metric.Name, rpsMetricName) {
-		backend, ok := config.Config["backend"]
-		if !ok {
+		var backend string
+		if !config.binder().String(skipperBackendConfigKey, &backend) {
 			// TODO: remove the deprecated way of specifying
 			// optional backend at a later point in time.
 			if len(config.Metric.Name) > len(rpsMetricName) {
@@ -62,9 +100,17 @@ func (c *SkipperCollectorPlugin) NewCollector(_ context.Context, hpa *autoscalin
 				}
 			}
 		}
-		return NewSkipperCollector(c.client, c.rgClient, c.plugin, hpa, config, interval, c.backendAnnotations, backend)
+		collector, err := NewSkipperCollector(c.client, c.rgClient, c.plugin, hpa, config, interval, c.backendAnnotations, backend)
+		if err != nil {
+			return nil, err
+		}
+		if err := collector.checkObjectExists(ctx); err != nil {
+			return nil, err
+		}
+		collector.argoRolloutsClient = c.argoRolloutsClient
+		return collector, nil
 	}
-	return nil, fmt.Errorf("metric '%s' not supported", config.Metric.Name)
+	return nil, NewPermanentConfigError(fmt.Errorf("metric '%s' not supported", config.Metric.Name))
 }
 
 // SkipperCollector is a metrics collector for getting skipper ingress metrics.
@@ -72,6 +118,7 @@ func (c *SkipperCollectorPlugin) NewCollector(_ context.Context, hpa *autoscalin
 type SkipperCollector struct {
 	client             kubernetes.Interface
 	rgClient           rginterface.Interface
+	argoRolloutsClient argoRolloutsClient.Interface
 	metric             autoscalingv2.MetricIdentifier
 	objectReference    custom_metrics.ObjectReference
 	hpa                *autoscalingv2.HorizontalPodAutoscaler
@@ -80,27 +127,160 @@ type SkipperCollector struct {
 	config             MetricConfig
 	backend            string
 	backendAnnotations []string
+	excludedHosts      []string
+	skipExistenceCheck bool
+	// notFoundRetries and notFoundRetryBackoff configure the retries of
+	// lookups of the object failing with NotFound.
+	notFoundRetries      int
+	notFoundRetryBackoff time.Duration
+	// tolerateMissingFor is the grace period lastCollector is reused for
+	// after lastResolved while the object is missing, zero disables it.
+	tolerateMissingFor time.Duration
+	lastCollector      Collector
+	lastResolved       time.Time
+	now                func() time.Time
+	sleep              func(ctx context.Context, d time.Duration) error
 }
 
 // NewSkipperCollector initializes a new SkipperCollector.
 func NewSkipperCollector(client kubernetes.Interface, rgClient rginterface.Interface, plugin CollectorPlugin, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration, backendAnnotations []string, backend string) (*SkipperCollector, error) {
+	// Ingresses and RouteGroups are namespaced. Without a namespace the
+	// metrics of same named resources in different namespaces would
+	// overwrite each other in the metric store.
+	collectorConfig := *config
+	if collectorConfig.ObjectReference.Namespace == "" {
+		collectorConfig.ObjectReference.Namespace = hpa.Namespace
+	}
+
+	b := config.binder()
+	// the backend is bound by the plugin falling back to the deprecated
+	// backend suffix of the metric name.
+	b.Used(skipperBackendConfigKey)
+	excludedHosts := bindExcludedHosts(b)
+	skipExistenceCheck := bindSkipExistenceCheck(b)
+	notFoundRetries := defaultNotFoundRetries
+	b.Int(notFoundRetriesConfigKey, &notFoundRetries, 0, maxNotFoundRetries)
+	notFoundRetryBackoff := defaultNotFoundRetryBackoff
+	b.PositiveDuration(notFoundRetryBackoffConfigKey, &notFoundRetryBackoff)
+	var tolerateMissingFor time.Duration
+	b.PositiveDuration(tolerateMissingObjectForConfigKey, &tolerateMissingFor)
+	if err := finishBinding(b, hpa); err != nil {
+		return nil, err
+	}
+
 	return &SkipperCollector{
-		client:             client,
-		rgClient:           rgClient,
-		objectReference:    config.ObjectReference,
-		hpa:                hpa,
-		metric:             config.Metric,
-		interval:           interval,
-		plugin:             plugin,
-		config:             *config,
-		backend:            backend,
-		backendAnnotations: backendAnnotations,
+		client:               client,
+		rgClient:             rgClient,
+		objectReference:      collectorConfig.ObjectReference,
+		hpa:                  hpa,
+		metric:               config.Metric,
+		interval:             interval,
+		plugin:               plugin,
+		config:               collectorConfig,
+		backend:              backend,
+		backendAnnotations:   backendAnnotations,
+		excludedHosts:        excludedHosts,
+		skipExistenceCheck:   skipExistenceCheck,
+		notFoundRetries:      notFoundRetries,
+		notFoundRetryBackoff: notFoundRetryBackoff,
+		tolerateMissingFor:   tolerateMissingFor,
+		now:                  time.Now,
+		sleep:                sleep.Context,
 	}, nil
 }
 
+// checkObjectExists returns an ObjectNotFoundError if the Ingress or
+// RouteGroup doesn't exist in the namespace of the HPA, unless the check is
+// skipped by the metric config.
+func (c *SkipperCollector) checkObjectExists(ctx context.Context) error {
+	if c.skipExistenceCheck {
+		return nil
+	}
+
+	var err error
+	switch c.objectReference.Kind {
+	case "Ingress":
+		_, err = c.client.NetworkingV1().Ingresses(c.objectReference.Namespace).Get(ctx, c.objectReference.Name, metav1.GetOptions{})
+	case "RouteGroup":
+		_, err = c.rgClient.ZalandoV1().RouteGroups(c.objectReference.Namespace).Get(ctx, c.objectReference.Name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return objectLookupError(err, c.objectReference)
+	}
+	return nil
+}
+
+// bindExcludedHosts binds the comma separated exclude-hosts config. The
+// hosts are exact names or glob patterns as supported by path.Match, e.g.
+// *.example.org.
+func bindExcludedHosts(b *annotations.ConfigBinder) []string {
+	var patterns []string
+	if !b.List(excludeHostsConfigKey, &patterns) {
+		return nil
+	}
+
+	if len(patterns) == 0 {
+		b.Invalid(excludeHostsConfigKey, "must list at least one host")
+		return nil
+	}
+
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			b.Invalid(excludeHostsConfigKey, fmt.Sprintf("invalid pattern '%s': %v", pattern, err))
+			return nil
+		}
+	}
+
+	return patterns
+}
+
+// excludeHosts returns the hosts not matching any of the excluded host
+// patterns.
+func excludeHosts(hosts, excludedHosts []string) []string {
+	if len(excludedHosts) == 0 {
+		return hosts
+	}
+
+	included := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		excluded := false
+		for _, pattern := range excludedHosts {
+			// the patterns are validated when parsed.
+			if match, _ := path.Match(pattern, host); match {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			included = append(included, host)
+		}
+	}
+
+	return included
+}
+
+// backendWeight is a weight in a backend weights annotation. Some
+// controllers write the weights as strings, so both numbers and numeric
+// strings are accepted. NaN and infinite weights are rejected.
+type backendWeight float64
+
+func (w *backendWeight) UnmarshalJSON(data []byte) error {
+	value := strings.TrimSpace(string(data))
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = strings.TrimSpace(unquoted)
+	}
+
+	weight, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(weight) || math.IsInf(weight, 0) {
+		return fmt.Errorf("invalid backend weight %s", data)
+	}
+	*w = backendWeight(weight)
+	return nil
+}
+
 func getAnnotationWeight(backendWeights string, backend string) (float64, error) {
-	var weightsMap map[string]float64
-	err := json.Unmarshal([]byte(backendWeights), &weightsMap)
+	var weightsMap map[string]backendWeight
+	err := json.Unmarshal([]byte(strings.TrimSpace(backendWeights)), &weightsMap)
 	if err != nil {
 		return 0, err
 	}
@@ -110,21 +290,32 @@ func getAnnotationWeight(backendWeights string, backend string) (float64, error)
 	return 0, nil
 }
 
+// getIngressWeight returns the highest weight of the backend defined by the
+// backend annotations of the ingress. Annotations which can't be parsed are
+// skipped, it only fails if none of the present annotations can be parsed.
 func getIngressWeight(ingressAnnotations map[string]string, backendAnnotations []string, backend string) (float64, error) {
 	maxWeight := 0.0
 	annotationsPresent := false
+	var parseErr error
 
 	for _, anno := range backendAnnotations {
 		if weightsMap, ok := ingressAnnotations[anno]; ok {
-			annotationsPresent = true
 			weight, err := getAnnotationWeight(weightsMap, backend)
 			if err != nil {
-				return 0.0, err
+				log.Warnf("Skipping invalid backend weights annotation %s: %v", anno, err)
+				SkipperInvalidBackendWeights.WithLabelValues(anno).Inc()
+				parseErr = fmt.Errorf("failed to parse backend weights annotation %s: %w", anno, err)
+				continue
 			}
+			annotationsPresent = true
 			maxWeight = math.Max(maxWeight, weight)
 		}
 	}
 
+	if !annotationsPresent && parseErr != nil {
+		return 0.0, parseErr
+	}
+
 	// Fallback for ingresses that don't use traffic switching
 	if !annotationsPresent {
 		return 1.0, nil
@@ -138,6 +329,10 @@ func getIngressWeight(ingressAnnotations map[string]string, backendAnnotations [
 	return 0.0, errBackendNameMissing
 }
 
+// getRouteGroupWeight returns the fraction of the traffic of the default
+// backends of a RouteGroup sent to the backend. Like skipper, the weights
+// are relative to the sum of all weights and backends are weighted equally
+// if all weights are zero.
 func getRouteGroupWeight(backends []rgv1.RouteGroupBackendReference, backendName string) (float64, error) {
 	if len(backends) <= 1 {
 		return 1.0, nil
@@ -147,9 +342,17 @@ func getRouteGroupWeight(backends []rgv1.RouteGroupBackendReference, backendName
 		return 0.0, errBackendNameMissing
 	}
 
+	total := 0
+	for _, backend := range backends {
+		total += backend.Weight
+	}
+
 	for _, backend := range backends {
 		if backend.BackendName == backendName {
-			return float64(backend.Weight) / 100.0, nil
+			if total == 0 {
+				return 1.0 / float64(len(backends)), nil
+			}
+			return float64(backend.Weight) / float64(total), nil
 		}
 	}
 
@@ -158,13 +361,13 @@ func getRouteGroupWeight(backends []rgv1.RouteGroupBackendReference, backendName
 
 // getCollector returns a collector for getting the metrics.
 func (c *SkipperCollector) getCollector(ctx context.Context) (Collector, error) {
-	var escapedHostnames []string
+	var hostnames []string
 	var backendWeight float64
 	switch c.objectReference.Kind {
 	case "Ingress":
 		ingress, err := c.client.NetworkingV1().Ingresses(c.objectReference.Namespace).Get(ctx, c.objectReference.Name, metav1.GetOptions{})
 		if err != nil {
-			return nil, err
+			return nil, NewTransientError(err)
 		}
 
 		backendWeight, err = getIngressWeight(ingress.Annotations, c.backendAnnotations, c.backend)
@@ -173,12 +376,12 @@ func (c *SkipperCollector) getCollector(ctx context.Context) (Collector, error)
 		}
 
 		for _, rule := range ingress.Spec.Rules {
-			escapedHostnames = append(escapedHostnames, regexp.QuoteMeta(strings.Replace(rule.Host, ".", "_", -1)))
+			hostnames = append(hostnames, rule.Host)
 		}
 	case "RouteGroup":
 		routegroup, err := c.rgClient.ZalandoV1().RouteGroups(c.objectReference.Namespace).Get(ctx, c.objectReference.Name, metav1.GetOptions{})
 		if err != nil {
-			return nil, err
+			return nil, NewTransientError(err)
 		}
 
 		backendWeight, err = getRouteGroupWeight(routegroup.Spec.DefaultBackends, c.backend)
@@ -186,22 +389,32 @@ func (c *SkipperCollector) getCollector(ctx context.Context) (Collector, error)
 			return nil, err
 		}
 
-		for _, host := range routegroup.Spec.Hosts {
-			escapedHostnames = append(escapedHostnames, regexp.QuoteMeta(strings.Replace(host, ".", "_", -1)))
-		}
+		hostnames = append(hostnames, routegroup.Spec.Hosts...)
 	default:
-		return nil, fmt.Errorf("unknown skipper resource kind %s for resource %s/%s", c.objectReference.Kind, c.objectReference.Namespace, c.objectReference.Name)
+		return nil, NewPermanentConfigError(fmt.Errorf("unknown skipper resource kind %s for resource %s/%s", c.objectReference.Kind, c.objectReference.Namespace, c.objectReference.Name))
 	}
 
 	config := c.config
 
-	if len(escapedHostnames) == 0 {
+	if len(hostnames) == 0 {
 		return nil, fmt.Errorf("no hosts defined on %s %s/%s, unable to create collector", c.objectReference.Kind, c.objectReference.Namespace, c.objectReference.Name)
 	}
 
+	hostnames = excludeHosts(hostnames, c.excludedHosts)
+	if len(hostnames) == 0 {
+		log.Warnf("All hosts of %s %s/%s are excluded by %s", c.objectReference.Kind, c.objectReference.Namespace, c.objectReference.Name, excludeHostsConfigKey)
+		return nil, fmt.Errorf("all hosts of %s %s/%s are excluded, unable to create collector", c.objectReference.Kind, c.objectReference.Namespace, c.objectReference.Name)
+	}
+
+	escapedHostnames := make([]string, 0, len(hostnames))
+	for _, host := range hostnames {
+		escapedHostnames = append(escapedHostnames, regexp.QuoteMeta(strings.Replace(host, ".", "_", -1)))
+	}
+
 	config.Config = map[string]string{
 		"query": fmt.Sprintf(rpsQuery, strings.Join(escapedHostnames, "|"), backendWeight),
 	}
+	config.ConfigSources = nil
 
 	config.PerReplica = false // per replica is handled outside of the prometheus collector
 	collector, err := c.plugin.NewCollector(ctx, c.hpa, &config, c.interval)
@@ -212,9 +425,39 @@ func (c *SkipperCollector) getCollector(ctx context.Context) (Collector, error)
 	return collector, nil
 }
 
+// resolveCollector returns the collector for the current hosts and backend
+// weight of the Ingress or RouteGroup. Lookups failing with NotFound, e.g.
+// right after the object was recreated, are retried with backoff. If the
+// object is still missing, the last collector is reused within the
+// tolerate-missing-object-for grace period after it was last resolved.
+func (c *SkipperCollector) resolveCollector(ctx context.Context) (Collector, error) {
+	collector, err := c.getCollector(ctx)
+
+	backoff := c.notFoundRetryBackoff
+	for retry := 0; retry < c.notFoundRetries && apierrors.IsNotFound(err); retry++ {
+		if sleepErr := c.sleep(ctx, backoff); sleepErr != nil {
+			return nil, NewTransientError(sleepErr)
+		}
+		backoff *= 2
+		collector, err = c.getCollector(ctx)
+	}
+
+	now := c.now()
+	if err == nil {
+		c.lastCollector, c.lastResolved = collector, now
+		return collector, nil
+	}
+
+	if apierrors.IsNotFound(err) && c.lastCollector != nil && now.Sub(c.lastResolved) < c.tolerateMissingFor {
+		log.Warnf("%s %s/%s not found, reusing the query resolved %s ago: %v", c.objectReference.Kind, c.objectReference.Namespace, c.objectReference.Name, now.Sub(c.lastResolved).Round(time.Second), err)
+		return c.lastCollector, nil
+	}
+	return nil, err
+}
+
 // GetMetrics gets skipper metrics from prometheus.
 func (c *SkipperCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, error) {
-	collector, err := c.getCollector(ctx)
+	collector, err := c.resolveCollector(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -229,6 +472,8 @@ func (c *SkipperCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, e
 	}
 
 	value := values[0]
+	value.Custom.DescribedObject.Namespace = c.objectReference.Namespace
+	value.Namespace = c.objectReference.Namespace
 
 	// For Kubernetes <v1.14 we have to fall back to manual average
 	if c.config.MetricSpec.Object.Target.AverageValue == nil {
@@ -236,7 +481,7 @@ func (c *SkipperCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, e
 		// calculate an average metric instead of total.
 		// targetAverageValue will be available in Kubernetes v1.12
 		// https://github.com/kubernetes/kubernetes/pull/64097
-		replicas, err := targetRefReplicas(ctx, c.client, c.hpa)
+		replicas, err := targetRefReplicas(ctx, c.client, c.argoRolloutsClient, c.hpa)
 		if err != nil {
 			return nil, err
 		}
@@ -257,22 +502,45 @@ func (c *SkipperCollector) Interval() time.Duration {
 	return c.interval
 }
 
-func targetRefReplicas(ctx context.Context, client kubernetes.Interface, hpa *autoscalingv2.HorizontalPodAutoscaler) (int32, error) {
-	var replicas int32
+// UnsupportedScaleTargetError is returned if the replicas of the scale
+// target of an HPA can't be looked up because of its kind.
+type UnsupportedScaleTargetError struct {
+	Kind string
+}
+
+func (e *UnsupportedScaleTargetError) Error() string {
+	return fmt.Sprintf("unable to get replicas of scale target ref kind '%s', supported kinds are Deployment, StatefulSet and Rollout", e.Kind)
+}
+
+// targetRefReplicas returns the current replicas of the scale target of the
+// HPA. The returned errors are classified.
+func targetRefReplicas(ctx context.Context, client kubernetes.Interface, argoRolloutsClient argoRolloutsClient.Interface, hpa *autoscalingv2.HorizontalPodAutoscaler) (int32, error) {
 	switch hpa.Spec.ScaleTargetRef.Kind {
 	case "Deployment":
 		deployment, err := client.AppsV1().Deployments(hpa.Namespace).Get(ctx, hpa.Spec.ScaleTargetRef.Name, metav1.GetOptions{})
 		if err != nil {
-			return 0, err
+			return 0, NewTransientError(err)
 		}
-		replicas = deployment.Status.Replicas
+		return deployment.Status.Replicas, nil
 	case "StatefulSet":
 		sts, err := client.AppsV1().StatefulSets(hpa.Namespace).Get(ctx, hpa.Spec.ScaleTargetRef.Name, metav1.GetOptions{})
 		if err != nil {
-			return 0, err
+			return 0, NewTransientError(err)
+		}
+		return sts.Status.Replicas, nil
+	case "Rollout":
+		if argoRolloutsClient == nil {
+			return 0, ErrArgoRolloutsNotAvailable
+		}
+		rollout, err := argoRolloutsClient.ArgoprojV1alpha1().Rollouts(hpa.Namespace).Get(ctx, hpa.Spec.ScaleTargetRef.Name, metav1.GetOptions{})
+		if err != nil {
+			if status, ok := err.(apierrors.APIStatus); ok && apierrors.IsNotFound(err) && (status.Status().Details == nil || status.Status().Details.Name == "") {
+				return 0, ErrArgoRolloutsNotAvailable
+			}
+			return 0, NewTransientError(err)
 		}
-		replicas = sts.Status.Replicas
+		return rollout.Status.Replicas, nil
 	}
 
-	return replicas, nil
+	return 0, NewPermanentConfigError(&UnsupportedScaleTargetError{Kind: hpa.Spec.ScaleTargetRef.Kind})
 }
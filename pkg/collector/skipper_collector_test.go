@@ -4,9 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"testing"
 	"time"
 
+	argorolloutsv1alpha1 "github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	argorolloutsfake "github.com/argoproj/argo-rollouts/pkg/client/clientset/versioned/fake"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	rgv1 "github.com/szuecs/routegroup-client/apis/zalando.org/v1"
 	rginterface "github.com/szuecs/routegroup-client/client/clientset/versioned"
@@ -14,6 +18,7 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	netv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -38,7 +43,7 @@ func TestTargetRefReplicasDeployments(t *testing.T) {
 		Create(context.TODO(), newHPA(defaultNamespace, name, "Deployment"), metav1.CreateOptions{})
 	require.NoError(t, err)
 
-	replicas, err := targetRefReplicas(context.Background(), client, hpa)
+	replicas, err := targetRefReplicas(context.Background(), client, nil, hpa)
 	require.NoError(t, err)
 	require.Equal(t, deployment.Status.Replicas, replicas)
 }
@@ -55,11 +60,57 @@ func TestTargetRefReplicasStatefulSets(t *testing.T) {
 		Create(context.TODO(), newHPA(defaultNamespace, name, "StatefulSet"), metav1.CreateOptions{})
 	require.NoError(t, err)
 
-	replicas, err := targetRefReplicas(context.Background(), client, hpa)
+	replicas, err := targetRefReplicas(context.Background(), client, nil, hpa)
 	require.NoError(t, err)
 	require.Equal(t, statefulSet.Status.Replicas, replicas)
 }
 
+func TestTargetRefReplicasRollouts(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	argoRolloutsClient := argorolloutsfake.NewSimpleClientset()
+	name := "some-app"
+	defaultNamespace := "default"
+	rollout, err := argoRolloutsClient.ArgoprojV1alpha1().Rollouts(defaultNamespace).Create(context.TODO(), &argorolloutsv1alpha1.Rollout{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: defaultNamespace,
+		},
+		Status: argorolloutsv1alpha1.RolloutStatus{
+			Replicas: 3,
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	// Create an HPA with the rollout as ref
+	hpa, err := client.AutoscalingV2().HorizontalPodAutoscalers(defaultNamespace).
+		Create(context.TODO(), newHPA(defaultNamespace, name, "Rollout"), metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	replicas, err := targetRefReplicas(context.Background(), client, argoRolloutsClient, hpa)
+	require.NoError(t, err)
+	require.Equal(t, rollout.Status.Replicas, replicas)
+
+	// without Argo Rollouts support the replicas are unknown.
+	_, err = targetRefReplicas(context.Background(), client, nil, hpa)
+	require.ErrorIs(t, err, ErrArgoRolloutsNotAvailable)
+
+	hpa.Spec.ScaleTargetRef.Name = "missing"
+	_, err = targetRefReplicas(context.Background(), client, argoRolloutsClient, hpa)
+	require.ErrorIs(t, err, ErrTransient)
+}
+
+func TestTargetRefReplicasUnsupportedKind(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	hpa := newHPA("default", "some-app", "ReplicaSet")
+
+	_, err := targetRefReplicas(context.Background(), client, argorolloutsfake.NewSimpleClientset(), hpa)
+	require.ErrorIs(t, err, ErrPermanentConfig)
+	var unsupportedErr *UnsupportedScaleTargetError
+	require.ErrorAs(t, err, &unsupportedErr)
+	require.Equal(t, "ReplicaSet", unsupportedErr.Kind)
+	require.Contains(t, err.Error(), "Deployment, StatefulSet and Rollout")
+}
+
 func newHPA(namespace string, refName string, refKind string) *autoscalingv2.HorizontalPodAutoscaler {
 	return &autoscalingv2.HorizontalPodAutoscaler{
 		ObjectMeta: metav1.ObjectMeta{
@@ -102,6 +153,200 @@ func newStatefulSet(client *fake.Clientset, namespace string, name string) (*app
 	}, metav1.CreateOptions{})
 }
 
+func TestGetIngressWeight(t *testing.T) {
+	backendAnnotations := []string{testBackendWeightsAnnotation, testStacksetWeightsAnnotation}
+
+	for _, tc := range []struct {
+		msg                string
+		annotations        map[string]string
+		backend            string
+		expectedWeight     float64
+		expectError        bool
+		expectedSkipped    float64
+		skippedAnnotations string
+	}{
+		{
+			msg:            "no annotations",
+			annotations:    map[string]string{},
+			expectedWeight: 1.0,
+		},
+		{
+			msg: "valid annotations",
+			annotations: map[string]string{
+				testBackendWeightsAnnotation:  `{"backend1": 40, "backend2": 60}`,
+				testStacksetWeightsAnnotation: `{"backend1": 20, "backend2": 80}`,
+			},
+			backend:        "backend2",
+			expectedWeight: 0.8,
+		},
+		{
+			msg: "invalid annotation is skipped",
+			annotations: map[string]string{
+				testBackendWeightsAnnotation:  `{"backend1": 40, "backend2": 60,}`,
+				testStacksetWeightsAnnotation: `{"backend1": 70, "backend2": 30}`,
+			},
+			backend:            "backend2",
+			expectedWeight:     0.3,
+			expectedSkipped:    1,
+			skippedAnnotations: testBackendWeightsAnnotation,
+		},
+		{
+			msg: "all annotations invalid",
+			annotations: map[string]string{
+				testBackendWeightsAnnotation:  `{"backend1": 40, "backend2": 60,}`,
+				testStacksetWeightsAnnotation: `{"backend2": "sixty"}`,
+			},
+			backend:            "backend2",
+			expectError:        true,
+			expectedSkipped:    1,
+			skippedAnnotations: testStacksetWeightsAnnotation,
+		},
+		{
+			msg: "string weights and whitespace",
+			annotations: map[string]string{
+				testBackendWeightsAnnotation: ` {"backend1": "40", "backend2": " 60 "}
+`,
+			},
+			backend:        "backend2",
+			expectedWeight: 0.6,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			var before float64
+			if tc.skippedAnnotations != "" {
+				before = testutil.ToFloat64(SkipperInvalidBackendWeights.WithLabelValues(tc.skippedAnnotations))
+			}
+
+			weight, err := getIngressWeight(tc.annotations, backendAnnotations, tc.backend)
+			if tc.expectError {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.InDelta(t, tc.expectedWeight, weight, 0.0001)
+			}
+
+			if tc.skippedAnnotations != "" {
+				require.Equal(t, before+tc.expectedSkipped, testutil.ToFloat64(SkipperInvalidBackendWeights.WithLabelValues(tc.skippedAnnotations)))
+			}
+		})
+	}
+}
+
+func TestGetRouteGroupWeight(t *testing.T) {
+	for _, tc := range []struct {
+		msg            string
+		backends       []rgv1.RouteGroupBackendReference
+		backend        string
+		expectedWeight float64
+		expectError    bool
+	}{
+		{
+			msg:            "no default backends",
+			expectedWeight: 1.0,
+		},
+		{
+			msg:            "single backend",
+			backends:       []rgv1.RouteGroupBackendReference{{BackendName: "backend1"}},
+			expectedWeight: 1.0,
+		},
+		{
+			msg: "percentages",
+			backends: []rgv1.RouteGroupBackendReference{
+				{BackendName: "backend1", Weight: 40},
+				{BackendName: "backend2", Weight: 60},
+			},
+			backend:        "backend2",
+			expectedWeight: 0.6,
+		},
+		{
+			msg: "weights are normalized",
+			backends: []rgv1.RouteGroupBackendReference{
+				{BackendName: "backend1", Weight: 1},
+				{BackendName: "backend2", Weight: 3},
+			},
+			backend:        "backend1",
+			expectedWeight: 0.25,
+		},
+		{
+			msg: "zero weight",
+			backends: []rgv1.RouteGroupBackendReference{
+				{BackendName: "backend1", Weight: 0},
+				{BackendName: "backend2", Weight: 100},
+			},
+			backend:        "backend1",
+			expectedWeight: 0,
+		},
+		{
+			msg: "all weights zero",
+			backends: []rgv1.RouteGroupBackendReference{
+				{BackendName: "backend1"},
+				{BackendName: "backend2"},
+			},
+			backend:        "backend1",
+			expectedWeight: 0.5,
+		},
+		{
+			msg: "backend not referenced",
+			backends: []rgv1.RouteGroupBackendReference{
+				{BackendName: "backend1", Weight: 50},
+				{BackendName: "backend2", Weight: 50},
+			},
+			backend:        "backend3",
+			expectedWeight: 0,
+		},
+		{
+			msg: "backend name missing",
+			backends: []rgv1.RouteGroupBackendReference{
+				{BackendName: "backend1", Weight: 50},
+				{BackendName: "backend2", Weight: 50},
+			},
+			expectError: true,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			weight, err := getRouteGroupWeight(tc.backends, tc.backend)
+			if tc.expectError {
+				require.ErrorIs(t, err, errBackendNameMissing)
+			} else {
+				require.NoError(t, err)
+				require.InDelta(t, tc.expectedWeight, weight, 0.0001)
+			}
+		})
+	}
+}
+
+func TestSkipperCollectorRouteGroupWeights(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rgClient := rgfake.NewSimpleClientset()
+	err := makeRoutegroup(rgClient, "default", "app", []string{"example.org"}, map[string]float64{"backend1": 1, "backend2": 3})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		backend       string
+		expectedQuery string
+	}{
+		{
+			backend:       "backend1",
+			expectedQuery: `scalar(sum(rate(skipper_serve_host_duration_seconds_count{host=~"example_org"}[1m])) * 0.2500)`,
+		},
+		{
+			backend:       "backend2",
+			expectedQuery: `scalar(sum(rate(skipper_serve_host_duration_seconds_count{host=~"example_org"}[1m])) * 0.7500)`,
+		},
+	} {
+		t.Run(tc.backend, func(t *testing.T) {
+			plugin := makePlugin(1000)
+			config := makeConfig("app", "default", "RouteGroup", tc.backend, false)
+			collector, err := NewSkipperCollector(client, rgClient, plugin, makeRGHPA("default", "app", tc.backend), config, time.Minute, []string{testBackendWeightsAnnotation}, tc.backend)
+			require.NoError(t, err)
+
+			_, err = collector.GetMetrics(context.Background())
+			require.NoError(t, err)
+			require.Equal(t, map[string]string{"query": tc.expectedQuery}, plugin.config)
+		})
+	}
+}
+
 func TestSkipperCollectorIngress(t *testing.T) {
 	for _, tc := range []struct {
 		msg                string
@@ -537,6 +782,34 @@ func TestSkipperCollector(t *testing.T) {
 	}
 }
 
+func TestSkipperCollectorRolloutAverage(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rgClient := rgfake.NewSimpleClientset()
+	argoRolloutsClient := argorolloutsfake.NewSimpleClientset()
+	require.NoError(t, makeIngress(client, "default", "app", "backend1", []string{"example.org"}, nil))
+	_, err := argoRolloutsClient.ArgoprojV1alpha1().Rollouts("default").Create(context.TODO(), &argorolloutsv1alpha1.Rollout{
+		ObjectMeta: metav1.ObjectMeta{Name: "backend1", Namespace: "default"},
+		Status:     argorolloutsv1alpha1.RolloutStatus{Replicas: 4},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	promPlugin, err := NewPrometheusCollectorPlugin(client, argoRolloutsClient, "http://prometheus")
+	require.NoError(t, err)
+	skipperPlugin, err := NewSkipperCollectorPlugin(client, rgClient, promPlugin, nil)
+	require.NoError(t, err)
+	skipperPlugin.plugin = makePlugin(1000)
+
+	hpa := makeIngressHPA("default", "app", "backend1")
+	hpa.Spec.ScaleTargetRef.Kind = "Rollout"
+	collector, err := skipperPlugin.NewCollector(context.Background(), hpa, makeConfig("app", "default", "Ingress", "backend1", true), time.Minute)
+	require.NoError(t, err)
+
+	collected, err := collector.GetMetrics(context.Background())
+	require.NoError(t, err)
+	require.Len(t, collected, 1)
+	require.EqualValues(t, 250, collected[0].Custom.Value.Value())
+}
+
 func makeIngress(client kubernetes.Interface, namespace, resourceName, backend string, hostnames []string, backendWeights map[string]map[string]float64) error {
 	annotations := make(map[string]string)
 	for anno, weights := range backendWeights {
@@ -657,3 +930,285 @@ func makeConfig(resourceName, namespace, kind, backend string, fakedAverage bool
 	}
 	return config
 }
+
+func TestSkipperCollectorDefaultsNamespace(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	rgClient := rgfake.NewSimpleClientset()
+
+	for _, namespace := range []string{"team-a", "team-b"} {
+		err := makeIngress(client, namespace, "app", "backend1", []string{"example.org"}, nil)
+		require.NoError(t, err)
+		err = makeRoutegroup(rgClient, namespace, "app", []string{"example.org"}, nil)
+		require.NoError(t, err)
+	}
+
+	for _, namespace := range []string{"team-a", "team-b"} {
+		for _, hpa := range []*autoscalingv2.HorizontalPodAutoscaler{makeIngressHPA(namespace, "app", "backend1"), makeRGHPA(namespace, "app", "backend1")} {
+			kind := hpa.Spec.Metrics[0].Object.DescribedObject.Kind
+			// the config of the object metric doesn't define a
+			// namespace.
+			config := makeConfig("app", "", kind, "backend1", false)
+
+			collector, err := NewSkipperCollector(client, rgClient, makePlugin(1000), hpa, config, time.Minute, []string{testBackendWeightsAnnotation}, "backend1")
+			require.NoError(t, err)
+			require.Equal(t, namespace, collector.objectReference.Namespace, kind)
+
+			collected, err := collector.GetMetrics(context.Background())
+			require.NoError(t, err, kind)
+			require.Len(t, collected, 1)
+			require.Equal(t, namespace, collected[0].Custom.DescribedObject.Namespace, kind)
+		}
+	}
+}
+
+func TestSkipperCollectorExcludeHosts(t *testing.T) {
+	for _, tc := range []struct {
+		msg           string
+		excludeHosts  string
+		expectedQuery string
+		expectError   bool
+	}{
+		{
+			msg:           "exact host excluded",
+			excludeHosts:  "www.example.org",
+			expectedQuery: `scalar(sum(rate(skipper_serve_host_duration_seconds_count{host=~"example_org|foo_example_com"}[1m])) * 1.0000)`,
+		},
+		{
+			msg:           "hosts excluded by glob",
+			excludeHosts:  "*.example.com, www.*",
+			expectedQuery: `scalar(sum(rate(skipper_serve_host_duration_seconds_count{host=~"example_org"}[1m])) * 1.0000)`,
+		},
+		{
+			msg:          "all hosts excluded",
+			excludeHosts: "*.example.com,*example.org",
+			expectError:  true,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			rgClient := rgfake.NewSimpleClientset()
+			hostnames := []string{"example.org", "www.example.org", "foo.example.com"}
+
+			err := makeIngress(client, "default", "app", "backend1", hostnames, nil)
+			require.NoError(t, err)
+			err = makeRoutegroup(rgClient, "default", "app", hostnames, nil)
+			require.NoError(t, err)
+
+			for _, hpa := range []*autoscalingv2.HorizontalPodAutoscaler{makeIngressHPA("default", "app", "backend1"), makeRGHPA("default", "app", "backend1")} {
+				kind := hpa.Spec.Metrics[0].Object.DescribedObject.Kind
+				config := makeConfig("app", "default", kind, "backend1", false)
+				config.Config = map[string]string{"exclude-hosts": tc.excludeHosts}
+
+				plugin := makePlugin(1000)
+				collector, err := NewSkipperCollector(client, rgClient, plugin, hpa, config, time.Minute, nil, "backend1")
+				require.NoError(t, err, kind)
+
+				_, err = collector.GetMetrics(context.Background())
+				if tc.expectError {
+					require.Error(t, err, kind)
+				} else {
+					require.NoError(t, err, kind)
+					require.Equal(t, map[string]string{"query": tc.expectedQuery}, plugin.config, kind)
+				}
+			}
+		})
+	}
+}
+
+func TestSkipperCollectorExistenceCheck(t *testing.T) {
+	for _, tc := range []struct {
+		msg    string
+		kind   string
+		name   string
+		config map[string]string
+		exists bool
+	}{
+		{msg: "present ingress", kind: "Ingress", name: "app", exists: true},
+		{msg: "missing ingress", kind: "Ingress", name: "other"},
+		{msg: "ingress in other namespace", kind: "Ingress", name: "elsewhere"},
+		{msg: "skipped ingress check", kind: "Ingress", name: "other", config: map[string]string{"skip-existence-check": "true"}, exists: true},
+		{msg: "present routegroup", kind: "RouteGroup", name: "app", exists: true},
+		{msg: "missing routegroup", kind: "RouteGroup", name: "other"},
+		{msg: "skipped routegroup check", kind: "RouteGroup", name: "other", config: map[string]string{"skip-existence-check": "true"}, exists: true},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			rgClient := rgfake.NewSimpleClientset()
+			require.NoError(t, makeIngress(client, "default", "app", "backend1", []string{"example.org"}, nil))
+			require.NoError(t, makeIngress(client, "other-namespace", "elsewhere", "backend1", []string{"example.org"}, nil))
+			require.NoError(t, makeRoutegroup(rgClient, "default", "app", []string{"example.org"}, nil))
+
+			promPlugin, err := NewPrometheusCollectorPlugin(client, nil, "http://prometheus")
+			require.NoError(t, err)
+			skipperPlugin, err := NewSkipperCollectorPlugin(client, rgClient, promPlugin, nil)
+			require.NoError(t, err)
+
+			hpa := makeIngressHPA("default", tc.name, "backend1")
+			config := makeConfig(tc.name, "default", tc.kind, "backend1", false)
+			if tc.config != nil {
+				config.Config = tc.config
+			}
+
+			_, err = skipperPlugin.NewCollector(context.Background(), hpa, config, time.Minute)
+			if tc.exists {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, ErrPermanentConfig)
+			var notFound *ObjectNotFoundError
+			require.ErrorAs(t, err, &notFound)
+			require.Equal(t, ObjectNotFoundError{Kind: tc.kind, Name: tc.name, Namespace: "default"}, *notFound)
+			require.Contains(t, err.Error(), fmt.Sprintf("object '%s' of kind %s not found in namespace default", tc.name, tc.kind))
+		})
+	}
+}
+
+func TestNewSkipperCollectorInvalidExcludeHosts(t *testing.T) {
+	for _, excludeHosts := range []string{"[example.org", " , "} {
+		hpa := makeIngressHPA("default", "app", "backend1")
+		config := makeConfig("app", "default", "Ingress", "backend1", false)
+		config.Config = map[string]string{"exclude-hosts": excludeHosts}
+
+		_, err := NewSkipperCollector(fake.NewSimpleClientset(), nil, makePlugin(1000), hpa, config, time.Minute, nil, "backend1")
+		require.ErrorIs(t, err, ErrPermanentConfig, excludeHosts)
+	}
+}
+
+func TestSkipperCollectorRecreatedIngress(t *testing.T) {
+	for _, tc := range []struct {
+		msg             string
+		config          map[string]string
+		recreateAfter   int
+		expectedSleeps  []time.Duration
+		expectCollected bool
+	}{
+		{
+			msg:             "recreated within the default retries",
+			recreateAfter:   2,
+			expectedSleeps:  []time.Duration{500 * time.Millisecond, time.Second},
+			expectCollected: true,
+		},
+		{
+			msg:            "not recreated within the default retries",
+			recreateAfter:  3,
+			expectedSleeps: []time.Duration{500 * time.Millisecond, time.Second},
+		},
+		{
+			msg:             "configured retries",
+			config:          map[string]string{"not-found-retries": "3", "not-found-retry-backoff": "1s"},
+			recreateAfter:   3,
+			expectedSleeps:  []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+			expectCollected: true,
+		},
+		{
+			msg:            "retries disabled",
+			config:         map[string]string{"not-found-retries": "0"},
+			recreateAfter:  1,
+			expectedSleeps: nil,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			hpa := makeIngressHPA("default", "app", "backend1")
+			config := makeConfig("app", "default", "Ingress", "backend1", false)
+			config.Config = tc.config
+
+			collector, err := NewSkipperCollector(client, nil, makePlugin(1000), hpa, config, time.Minute, nil, "backend1")
+			require.NoError(t, err)
+
+			var sleeps []time.Duration
+			collector.sleep = func(_ context.Context, d time.Duration) error {
+				sleeps = append(sleeps, d)
+				if len(sleeps) == tc.recreateAfter {
+					require.NoError(t, makeIngress(client, "default", "app", "backend1", []string{"example.org"}, nil))
+				}
+				return nil
+			}
+
+			collected, err := collector.GetMetrics(context.Background())
+			require.Equal(t, tc.expectedSleeps, sleeps)
+			if !tc.expectCollected {
+				require.True(t, apierrors.IsNotFound(err), err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, collected, 1)
+		})
+	}
+}
+
+func TestSkipperCollectorTolerateMissingObject(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	require.NoError(t, makeIngress(client, "default", "app", "backend1", []string{"example.org"}, nil))
+
+	hpa := makeIngressHPA("default", "app", "backend1")
+	config := makeConfig("app", "default", "Ingress", "backend1", false)
+	config.Config = map[string]string{"not-found-retries": "0", "tolerate-missing-object-for": "2m"}
+
+	plugin := makePlugin(1000)
+	collector, err := NewSkipperCollector(client, nil, plugin, hpa, config, time.Minute, nil, "backend1")
+	require.NoError(t, err)
+
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	collector.now = func() time.Time { return now }
+
+	collect := func() error {
+		collected, err := collector.GetMetrics(context.Background())
+		if err != nil {
+			return err
+		}
+		require.Len(t, collected, 1)
+		require.Equal(t, int64(1000), collected[0].Custom.Value.Value())
+		return nil
+	}
+	deleteIngress := func() {
+		err := client.NetworkingV1().Ingresses("default").Delete(context.Background(), "app", metav1.DeleteOptions{})
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, collect())
+
+	// blue/green tooling deletes and recreates the ingress, the metrics
+	// are collected with the last query meanwhile.
+	deleteIngress()
+	for i := 0; i < 3; i++ {
+		now = now.Add(30 * time.Second)
+		require.NoError(t, collect())
+	}
+
+	now = now.Add(10 * time.Second)
+	require.NoError(t, makeIngress(client, "default", "app", "backend1", []string{"example.com"}, nil))
+	require.NoError(t, collect())
+	require.Equal(t, `scalar(sum(rate(skipper_serve_host_duration_seconds_count{host=~"example_com"}[1m])) * 1.0000)`, plugin.config["query"])
+
+	// after the grace period the collection fails as without it.
+	deleteIngress()
+	now = now.Add(time.Minute)
+	require.NoError(t, collect())
+	now = now.Add(time.Minute)
+	err = collect()
+	require.True(t, apierrors.IsNotFound(err), err)
+}
+
+func FuzzGetAnnotationWeight(f *testing.F) {
+	f.Add(`{"backend1": 60, "backend2": 40}`, "backend1")
+	f.Add(`{"backend1": "60", "backend2": "40"}`, "backend2")
+	f.Add(`{"backend1": " 60 "}`, "backend1")
+	f.Add(`{"backend1": "NaN"}`, "backend1")
+	f.Add(`{"backend1": "-Inf"}`, "backend1")
+	f.Add(`{"backend1": 1e400}`, "backend1")
+	f.Add(`{"backend1": "0x1p-2"}`, "backend1")
+	f.Add(`{"backend1": null}`, "backend1")
+	f.Add(`{"backend1": [60]}`, "backend1")
+	f.Add(`{"backend1": 60,}`, "backend1")
+	f.Add(`[]`, "")
+	f.Add(``, "")
+
+	f.Fuzz(func(t *testing.T, weights, backend string) {
+		weight, err := getAnnotationWeight(weights, backend)
+		if err != nil {
+			return
+		}
+		require.False(t, math.IsNaN(weight) || math.IsInf(weight, 0), "weight %v", weight)
+	})
+}
@@ -0,0 +1,151 @@
+package collector
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	smoothingConfigKey        = "smoothing"
+	ewmaAlphaConfigKey        = "ewma-alpha"
+	maxChangePercentConfigKey = "max-change-percent"
+
+	// SmoothingEWMA emits the exponentially weighted moving average of
+	// the collected values.
+	SmoothingEWMA = "ewma"
+	// SmoothingMaxChange limits the change of the emitted value between
+	// two collections to a percentage of the previous value.
+	SmoothingMaxChange = "max-change"
+
+	defaultEWMAAlpha = 0.5
+)
+
+// scalingScheduleKinds are the kinds of objects whose metrics aren't
+// smoothed unless configured in the annotations of the HPA, as the steps of
+// a schedule are meant to be exact.
+var scalingScheduleKinds = map[string]struct{}{
+	"ScalingSchedule":        {},
+	"ClusterScalingSchedule": {},
+}
+
+// SmoothingCollector wraps a collector dampening spikes of the collected
+// values. The previous emitted values are kept in memory per metric and
+// the collected values are attached as Raw to the emitted metrics.
+type SmoothingCollector struct {
+	collector        Collector
+	smoothing        string
+	alpha            float64
+	maxChangePercent float64
+	previous         map[string]float64
+	sync.Mutex
+}
+
+// NewSmoothingCollector initializes a new SmoothingCollector from the
+// smoothing, ewma-alpha and max-change-percent config.
+func NewSmoothingCollector(collector Collector, config *MetricConfig) (*SmoothingCollector, error) {
+	c := &SmoothingCollector{
+		collector: collector,
+		alpha:     defaultEWMAAlpha,
+		previous:  map[string]float64{},
+	}
+
+	b := config.binder()
+	b.Enum(smoothingConfigKey, &c.smoothing, SmoothingEWMA, SmoothingMaxChange)
+	switch c.smoothing {
+	case SmoothingEWMA:
+		if b.Float(ewmaAlphaConfigKey, &c.alpha) && (c.alpha <= 0 || c.alpha > 1) {
+			b.Invalid(ewmaAlphaConfigKey, "must be greater than 0 and at most 1")
+		}
+	case SmoothingMaxChange:
+		if !b.Has(maxChangePercentConfigKey) {
+			b.Missing(maxChangePercentConfigKey)
+		} else if b.Float(maxChangePercentConfigKey, &c.maxChangePercent) && c.maxChangePercent <= 0 {
+			b.Invalid(maxChangePercentConfigKey, "must be greater than 0")
+		}
+	}
+	if err := b.Err(); err != nil {
+		return nil, NewPermanentConfigError(err)
+	}
+
+	return c, nil
+}
+
+// GetMetrics collects the metrics of the wrapped collector and smooths the
+// values based on the previous emitted values. The first value of a metric
+// is emitted as collected. Previous values of metrics which are no longer
+// collected are dropped.
+func (c *SmoothingCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, error) {
+	metrics, err := c.collector.GetMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	previousValues := c.previous
+	c.previous = make(map[string]float64, len(metrics))
+	for i, metric := range metrics {
+		key := deriveKey(metric)
+		raw := collectedSample(metric).value
+		value := raw
+		if previous, ok := previousValues[key]; ok {
+			value = c.smooth(previous, raw)
+		}
+		c.previous[key] = value
+
+		quantity := milliQuantity(value)
+		switch metric.Type {
+		case autoscalingv2.ObjectMetricSourceType, autoscalingv2.PodsMetricSourceType:
+			rawQuantity := metric.Custom.Value.DeepCopy()
+			metrics[i].Raw = &rawQuantity
+			metrics[i].Custom.Value = quantity
+		case autoscalingv2.ExternalMetricSourceType:
+			rawQuantity := metric.External.Value.DeepCopy()
+			metrics[i].Raw = &rawQuantity
+			metrics[i].External.Value = quantity
+		}
+	}
+
+	return metrics, nil
+}
+
+func (c *SmoothingCollector) smooth(previous, current float64) float64 {
+	if c.smoothing == SmoothingEWMA {
+		return c.alpha*current + (1-c.alpha)*previous
+	}
+
+	// a change relative to zero is always zero, so the value could never
+	// leave zero if it was limited.
+	if previous == 0 {
+		return current
+	}
+
+	limit := math.Abs(previous) * c.maxChangePercent / 100
+	return math.Max(previous-limit, math.Min(previous+limit, current))
+}
+
+// milliQuantity returns the value as milli quantity. Values exceeding the
+// range of a milli quantity are clamped to it and NaN is returned as 0.
+func milliQuantity(value float64) resource.Quantity {
+	milli := value * 1000
+	switch {
+	case math.IsNaN(milli):
+		milli = 0
+	case milli >= math.MaxInt64:
+		return *resource.NewMilliQuantity(math.MaxInt64, resource.DecimalSI)
+	case milli <= math.MinInt64:
+		return *resource.NewMilliQuantity(math.MinInt64, resource.DecimalSI)
+	}
+	return *resource.NewMilliQuantity(int64(milli), resource.DecimalSI)
+}
+
+// Interval returns the interval of the wrapped collector.
+func (c *SmoothingCollector) Interval() time.Duration {
+	return c.collector.Interval()
+}
@@ -0,0 +1,250 @@
+package collector
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/metrics/pkg/apis/custom_metrics"
+)
+
+func TestSmoothingCollector(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		msg      string
+		config   map[string]string
+		values   []int64
+		expected []int64 // milli values
+	}{
+		{
+			msg:      "ewma converges to a constant value",
+			config:   map[string]string{"smoothing": "ewma", "ewma-alpha": "0.5"},
+			values:   []int64{0, 100, 100, 100, 100, 100},
+			expected: []int64{0, 50000, 75000, 87500, 93750, 96875},
+		},
+		{
+			msg:      "ewma dampens a single spike",
+			config:   map[string]string{"smoothing": "ewma", "ewma-alpha": "0.2"},
+			values:   []int64{10, 110, 10, 10},
+			expected: []int64{10000, 30000, 26000, 22800},
+		},
+		{
+			msg:      "ewma with alpha 1 emits the collected values",
+			config:   map[string]string{"smoothing": "ewma", "ewma-alpha": "1"},
+			values:   []int64{10, 110, 10},
+			expected: []int64{10000, 110000, 10000},
+		},
+		{
+			msg:      "max-change clamps increases and decreases",
+			config:   map[string]string{"smoothing": "max-change", "max-change-percent": "50"},
+			values:   []int64{100, 1000, 1000, 1000, 0},
+			expected: []int64{100000, 150000, 225000, 337500, 168750},
+		},
+		{
+			msg:      "max-change passes changes within the limit",
+			config:   map[string]string{"smoothing": "max-change", "max-change-percent": "20"},
+			values:   []int64{100, 110, 95},
+			expected: []int64{100000, 110000, 95000},
+		},
+		{
+			msg:      "max-change doesn't limit a change from zero",
+			config:   map[string]string{"smoothing": "max-change", "max-change-percent": "10"},
+			values:   []int64{0, 100, 200},
+			expected: []int64{0, 100000, 110000},
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			values := tc.values
+			inner := makeCollectorWithStub(func() ([]CollectedMetric, error) {
+				value := values[0]
+				values = values[1:]
+				return externalSample(value, start), nil
+			})
+
+			collector, err := NewSmoothingCollector(inner, &MetricConfig{Config: tc.config})
+			require.NoError(t, err)
+
+			for i, expected := range tc.expected {
+				metrics, err := collector.GetMetrics(context.Background())
+				require.NoError(t, err)
+				require.Len(t, metrics, 1)
+				require.Equal(t, expected, metrics[0].External.Value.MilliValue())
+				require.NotNil(t, metrics[0].Raw)
+				require.Equal(t, tc.values[i], metrics[0].Raw.Value())
+			}
+		})
+	}
+}
+
+func TestSmoothingCollectorTracksMetricsSeparately(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	samples := [][]CollectedMetric{
+		append(externalSample(100, start), customSample("a", 10)...),
+		append(externalSample(200, start), customSample("a", 30)...),
+	}
+	inner := makeCollectorWithStub(func() ([]CollectedMetric, error) {
+		s := samples[0]
+		samples = samples[1:]
+		return s, nil
+	})
+
+	collector, err := NewSmoothingCollector(inner, &MetricConfig{Config: map[string]string{"smoothing": "ewma"}})
+	require.NoError(t, err)
+
+	_, err = collector.GetMetrics(context.Background())
+	require.NoError(t, err)
+	metrics, err := collector.GetMetrics(context.Background())
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	require.Equal(t, int64(150000), metrics[0].External.Value.MilliValue())
+	require.Equal(t, int64(20000), metrics[1].Custom.Value.MilliValue())
+}
+
+func TestSmoothingCollectorDropsVanishedMetrics(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	samples := [][]CollectedMetric{
+		append(externalSample(100, start), customSample("a", 10)...),
+		customSample("a", 30),
+		externalSample(200, start),
+	}
+	inner := makeCollectorWithStub(func() ([]CollectedMetric, error) {
+		s := samples[0]
+		samples = samples[1:]
+		return s, nil
+	})
+
+	collector, err := NewSmoothingCollector(inner, &MetricConfig{Config: map[string]string{"smoothing": "ewma"}})
+	require.NoError(t, err)
+
+	_, err = collector.GetMetrics(context.Background())
+	require.NoError(t, err)
+	require.Len(t, collector.previous, 2)
+
+	_, err = collector.GetMetrics(context.Background())
+	require.NoError(t, err)
+	require.Len(t, collector.previous, 1)
+
+	// the external metric starts over when it's collected again.
+	metrics, err := collector.GetMetrics(context.Background())
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	require.Equal(t, int64(200000), metrics[0].External.Value.MilliValue())
+}
+
+func TestMilliQuantity(t *testing.T) {
+	for _, tc := range []struct {
+		value    float64
+		expected int64
+	}{
+		{value: 1.5, expected: 1500},
+		{value: -0.25, expected: -250},
+		{value: 1e300, expected: math.MaxInt64},
+		{value: -1e300, expected: math.MinInt64},
+		{value: math.Inf(1), expected: math.MaxInt64},
+		{value: math.NaN(), expected: 0},
+	} {
+		quantity := milliQuantity(tc.value)
+		require.Equal(t, tc.expected, quantity.MilliValue(), "value %v", tc.value)
+	}
+}
+
+func customSample(name string, value int64) []CollectedMetric {
+	metric := CollectedMetric{
+		Type: autoscalingv2.ObjectMetricSourceType,
+		Custom: custom_metrics.MetricValue{
+			DescribedObject: custom_metrics.ObjectReference{Kind: "Ingress", Namespace: "default", Name: name},
+			Metric:          custom_metrics.MetricIdentifier{Name: "requests-per-second"},
+			Timestamp:       metav1.Now(),
+		},
+	}
+	metric.Custom.Value.Set(value)
+	return []CollectedMetric{metric}
+}
+
+func TestNewSmoothingCollectorInvalidConfig(t *testing.T) {
+	for _, tc := range []struct {
+		msg    string
+		config map[string]string
+		err    string
+	}{
+		{
+			msg:    "unknown smoothing",
+			config: map[string]string{"smoothing": "median"},
+			err:    "invalid value 'median' of config key 'smoothing': must be one of ewma, max-change",
+		},
+		{
+			msg:    "alpha out of range",
+			config: map[string]string{"smoothing": "ewma", "ewma-alpha": "1.5"},
+			err:    "invalid value '1.5' of config key 'ewma-alpha': must be greater than 0 and at most 1",
+		},
+		{
+			msg:    "missing max change",
+			config: map[string]string{"smoothing": "max-change"},
+			err:    "missing config key 'max-change-percent'",
+		},
+		{
+			msg:    "non positive max change",
+			config: map[string]string{"smoothing": "max-change", "max-change-percent": "0"},
+			err:    "invalid value '0' of config key 'max-change-percent': must be greater than 0",
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			_, err := NewSmoothingCollector(&FakeCollector{}, &MetricConfig{Config: tc.config})
+			require.ErrorIs(t, err, ErrPermanentConfig)
+			require.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func TestNewCollectorSmoothingScalingSchedules(t *testing.T) {
+	factory := NewCollectorFactory()
+	require.NoError(t, factory.RegisterObjectCollector("", "", &FakeCollectorPlugin{}))
+
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "hpa", Namespace: "default"},
+	}
+
+	for _, tc := range []struct {
+		msg      string
+		kind     string
+		source   string
+		smoothed bool
+	}{
+		{
+			msg:      "metrics are smoothed",
+			kind:     "Ingress",
+			source:   "metric-config-default.object.ingress/smoothing",
+			smoothed: true,
+		},
+		{
+			msg:    "scaling schedules aren't smoothed by namespace defaults",
+			kind:   "ScalingSchedule",
+			source: "metric-config-default.object.scaling-schedule/smoothing",
+		},
+		{
+			msg:      "scaling schedules are smoothed if configured explicitly",
+			kind:     "ClusterScalingSchedule",
+			source:   "metric-config.object.schedule.scaling-schedule/smoothing",
+			smoothed: true,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			config := &MetricConfig{
+				MetricTypeName:  MetricTypeName{Type: autoscalingv2.ObjectMetricSourceType},
+				ObjectReference: custom_metrics.ObjectReference{Kind: tc.kind, Name: "object"},
+				Config:          map[string]string{"smoothing": "ewma"},
+				ConfigSources:   map[string]string{"smoothing": tc.source},
+			}
+
+			c, err := factory.NewCollector(context.Background(), hpa, config, time.Minute)
+			require.NoError(t, err)
+			_, smoothed := c.(*SmoothingCollector)
+			require.Equal(t, tc.smoothed, smoothed)
+		})
+	}
+}
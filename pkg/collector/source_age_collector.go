@@ -0,0 +1,66 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// maxSourceAgeConfigKey is the metric config key defining the max age of the
+// timestamp of the collected metrics.
+const maxSourceAgeConfigKey = "max-source-age"
+
+// SourceAgeCollector wraps a collector failing collections whose metrics
+// have a timestamp older than the max source age. Collectors stamp the
+// metrics with the timestamp of the upstream sample where the source
+// provides one, so a lagging source, e.g. a ZMON check which stopped
+// running, is detected instead of serving outdated values as current.
+// Instant Prometheus queries return the evaluation time instead, so a stale
+// Prometheus source isn't detected.
+type SourceAgeCollector struct {
+	collector Collector
+	maxAge    time.Duration
+	now       func() time.Time
+}
+
+// NewSourceAgeCollector initializes a new SourceAgeCollector from the
+// max-source-age config.
+func NewSourceAgeCollector(collector Collector, config map[string]string) (*SourceAgeCollector, error) {
+	maxAge, err := time.ParseDuration(config[maxSourceAgeConfigKey])
+	if err != nil {
+		return nil, NewPermanentConfigError(fmt.Errorf("failed to parse %s: %w", maxSourceAgeConfigKey, err))
+	}
+	if maxAge <= 0 {
+		return nil, NewPermanentConfigError(fmt.Errorf("%s must be positive, got %s", maxSourceAgeConfigKey, maxAge))
+	}
+
+	return &SourceAgeCollector{
+		collector: collector,
+		maxAge:    maxAge,
+		now:       time.Now,
+	}, nil
+}
+
+// GetMetrics collects the metrics of the wrapped collector. It returns a
+// transient error if any of the metrics is older than the max source age.
+func (c *SourceAgeCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, error) {
+	metrics, err := c.collector.GetMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := c.now()
+	for _, metric := range metrics {
+		timestamp := collectedSample(metric).timestamp
+		if age := now.Sub(timestamp); age > c.maxAge {
+			return nil, NewTransientError(fmt.Errorf("metric %s is %s old, more than the %s of %s", deriveKey(metric), age.Truncate(time.Second), maxSourceAgeConfigKey, c.maxAge))
+		}
+	}
+
+	return metrics, nil
+}
+
+// Interval returns the interval of the wrapped collector.
+func (c *SourceAgeCollector) Interval() time.Duration {
+	return c.collector.Interval()
+}
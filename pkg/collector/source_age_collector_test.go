@@ -0,0 +1,97 @@
+package collector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSourceAgeCollector(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		msg         string
+		config      map[string]string
+		timestamp   time.Time
+		expectedErr error
+		invalid     bool
+	}{
+		{
+			msg:       "recent metric",
+			config:    map[string]string{"max-source-age": "5m"},
+			timestamp: now.Add(-time.Minute),
+		},
+		{
+			msg:         "outdated metric",
+			config:      map[string]string{"max-source-age": "5m"},
+			timestamp:   now.Add(-10 * time.Minute),
+			expectedErr: ErrTransient,
+		},
+		{
+			msg:     "invalid max source age",
+			config:  map[string]string{"max-source-age": "five minutes"},
+			invalid: true,
+		},
+		{
+			msg:     "negative max source age",
+			config:  map[string]string{"max-source-age": "-5m"},
+			invalid: true,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			collector, err := NewSourceAgeCollector(&FakeCollector{metrics: externalSample(1, tc.timestamp)}, tc.config)
+			if tc.invalid {
+				require.ErrorIs(t, err, ErrPermanentConfig)
+				return
+			}
+			require.NoError(t, err)
+			collector.now = func() time.Time { return now }
+
+			metrics, err := collector.GetMetrics(context.Background())
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+				require.Contains(t, err.Error(), "10m0s old")
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, metrics, 1)
+		})
+	}
+}
+
+func TestSourceAgeCollectorPrometheus(t *testing.T) {
+	old := time.Now().Add(-10 * time.Minute).Truncate(time.Millisecond).UTC()
+	promAPI := &mockPromAPI{value: model.Vector{{Metric: model.Metric{}, Value: 5, Timestamp: model.TimeFromUnixNano(old.UnixNano())}}}
+
+	hpa := newHPA("default", "app", "Deployment")
+	config := &MetricConfig{
+		MetricTypeName: MetricTypeName{
+			Type:   autoscalingv2.ExternalMetricSourceType,
+			Metric: autoscalingv2.MetricIdentifier{Name: "requests", Selector: &metav1.LabelSelector{}},
+		},
+		CollectorType: PrometheusMetricType,
+		Config:        map[string]string{"query": "requests_total", "max-source-age": "5m"},
+	}
+
+	collector, err := NewPrometheusCollector(nil, promAPI, hpa, config, time.Minute)
+	require.NoError(t, err)
+
+	// the timestamp of the sample is propagated. Prometheus stamps instant
+	// query results with the evaluation time, only the mock returns an
+	// older one.
+	metrics, err := collector.GetMetrics(context.Background())
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	require.Equal(t, old, metrics[0].External.Timestamp.Time)
+
+	sourceAgeCollector, err := NewSourceAgeCollector(collector, config.Config)
+	require.NoError(t, err)
+	_, err = sourceAgeCollector.GetMetrics(context.Background())
+	require.ErrorIs(t, err, ErrTransient)
+	require.Contains(t, err.Error(), "max-source-age")
+}
@@ -0,0 +1,326 @@
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq" // registers the postgres driver
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+)
+
+const (
+	// SQLMetricType defines the metric type for metrics based on the
+	// result of a SQL query.
+	SQLMetricType  = "sql"
+	sqlQueryKey    = "query"
+	sqlMaxOpenConn = 10
+
+	// SQLDriverPostgres selects the PostgreSQL driver.
+	SQLDriverPostgres = "postgres"
+	// SQLDriverMySQL selects the MySQL driver.
+	SQLDriverMySQL = "mysql"
+)
+
+// OpenSQLDB opens a connection pool to the database with the DSN read from
+// dsnFile. The DSN is a secret and therefore never part of the returned
+// errors. The returned redact function removes the DSN and its password
+// from error messages of the pool.
+func OpenSQLDB(driver, dsnFile string) (*sql.DB, func(error) error, error) {
+	if driver != SQLDriverPostgres && driver != SQLDriverMySQL {
+		return nil, nil, fmt.Errorf("unknown SQL driver '%s', must be one of %s, %s", driver, SQLDriverPostgres, SQLDriverMySQL)
+	}
+
+	data, err := os.ReadFile(dsnFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read SQL DSN file: %w", err)
+	}
+
+	dsn := strings.TrimSpace(string(data))
+	if dsn == "" {
+		return nil, nil, fmt.Errorf("SQL DSN file %s is empty", dsnFile)
+	}
+
+	redact := newSQLRedactor(driver, dsn)
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s connection pool: %w", driver, redact(err))
+	}
+	db.SetMaxOpenConns(sqlMaxOpenConn)
+	db.SetMaxIdleConns(sqlMaxOpenConn)
+
+	return db, redact, nil
+}
+
+// newSQLRedactor returns a function replacing the DSN and the password it
+// contains in error messages.
+func newSQLRedactor(driver, dsn string) func(error) error {
+	secrets := []string{dsn}
+	if password := dsnPassword(driver, dsn); password != "" {
+		secrets = append(secrets, password)
+	}
+
+	return func(err error) error {
+		if err == nil {
+			return nil
+		}
+
+		msg := err.Error()
+		redacted := msg
+		for _, secret := range secrets {
+			redacted = strings.ReplaceAll(redacted, secret, "[redacted]")
+		}
+		if redacted == msg {
+			return err
+		}
+		return errors.New(redacted)
+	}
+}
+
+// dsnPassword extracts the password from a DSN in one of the formats
+// supported by the drivers.
+func dsnPassword(driver, dsn string) string {
+	if driver == SQLDriverMySQL {
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return ""
+		}
+		return cfg.Passwd
+	}
+
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		password, _ := u.User.Password()
+		return password
+	}
+
+	// key/value connection string, e.g. "host=db password=secret"
+	for _, field := range strings.Fields(dsn) {
+		if password, ok := strings.CutPrefix(field, "password="); ok {
+			return strings.Trim(password, "'")
+		}
+	}
+	return ""
+}
+
+// SQLCollectorPlugin defines a plugin for creating collectors that can get
+// metrics from SQL queries. All collectors share the connection pool of the
+// plugin.
+type SQLCollectorPlugin struct {
+	db               *sql.DB
+	redact           func(error) error
+	queryTimeout     time.Duration
+	minQueryInterval time.Duration
+}
+
+// NewSQLCollectorPlugin initializes a new SQLCollectorPlugin. Queries are
+// canceled after queryTimeout and each collector queries the database at
+// most once per minQueryInterval.
+func NewSQLCollectorPlugin(db *sql.DB, redact func(error) error, queryTimeout, minQueryInterval time.Duration) (*SQLCollectorPlugin, error) {
+	if db == nil {
+		return nil, fmt.Errorf("SQL connection pool is not configured")
+	}
+
+	if redact == nil {
+		redact = func(err error) error { return err }
+	}
+
+	return &SQLCollectorPlugin{
+		db:               db,
+		redact:           redact,
+		queryTimeout:     queryTimeout,
+		minQueryInterval: minQueryInterval,
+	}, nil
+}
+
+// NewCollector initializes a new SQL collector from the specified HPA.
+func (p *SQLCollectorPlugin) NewCollector(ctx context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
+	return NewSQLCollector(ctx, p, hpa, config, interval)
+}
+
+// SQLCollector defines a collector that is able to collect a metric from a
+// SQL query returning a single numeric value.
+type SQLCollector struct {
+	plugin    *SQLCollectorPlugin
+	query     string
+	interval  time.Duration
+	metric    autoscalingv2.MetricIdentifier
+	namespace string
+	now       func() time.Time
+
+	mu        sync.Mutex
+	lastQuery time.Time
+	last      []CollectedMetric
+}
+
+// NewSQLCollector initializes a new SQLCollector.
+func NewSQLCollector(_ context.Context, plugin *SQLCollectorPlugin, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (*SQLCollector, error) {
+	if config.Metric.Selector == nil {
+		return nil, NewPermanentConfigError(fmt.Errorf("selector for sql is not specified"))
+	}
+
+	query, ok := config.Config[sqlQueryKey]
+	if !ok {
+		return nil, NewPermanentConfigError(fmt.Errorf("query not specified on metric"))
+	}
+
+	query, err := validateSQLQuery(query)
+	if err != nil {
+		return nil, NewPermanentConfigError(err)
+	}
+
+	return &SQLCollector{
+		plugin:    plugin,
+		query:     query,
+		interval:  interval,
+		metric:    config.Metric,
+		namespace: hpa.Namespace,
+		now:       time.Now,
+	}, nil
+}
+
+// validateSQLQuery makes sure the query is a single SELECT statement. This
+// is a basic safeguard, the database user should only have read
+// permissions in the first place.
+func validateSQLQuery(query string) (string, error) {
+	query = strings.TrimSpace(query)
+	query = strings.TrimSpace(strings.TrimSuffix(query, ";"))
+
+	if strings.Contains(query, ";") {
+		return "", fmt.Errorf("query must be a single statement")
+	}
+
+	fields := strings.Fields(query)
+	if len(fields) == 0 || !strings.EqualFold(fields[0], "select") {
+		return "", fmt.Errorf("query must be a SELECT statement")
+	}
+
+	return query, nil
+}
+
+// GetMetrics runs the query and returns its result as an external metric.
+// If the previous query was less than the minimum query interval ago, the
+// previous result is returned instead.
+func (c *SQLCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if c.last != nil && now.Sub(c.lastQuery) < c.plugin.minQueryInterval {
+		return c.last, nil
+	}
+
+	value, err := c.queryValue(ctx)
+	if err != nil {
+		return nil, NewTransientError(c.plugin.redact(err))
+	}
+
+	c.lastQuery = now
+	c.last = []CollectedMetric{
+		NewExternalMetric(c.namespace, external_metrics.ExternalMetricValue{
+			MetricName:   c.metric.Name,
+			MetricLabels: c.metric.Selector.MatchLabels,
+			Timestamp:    metav1.NewTime(now),
+			Value:        *resource.NewMilliQuantity(int64(value*1000), resource.DecimalSI),
+		}),
+	}
+
+	return c.last, nil
+}
+
+// queryValue runs the query in a read-only transaction and parses the
+// single value it returns.
+func (c *SQLCollector) queryValue(ctx context.Context) (float64, error) {
+	if c.plugin.queryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.plugin.queryTimeout)
+		defer cancel()
+	}
+
+	tx, err := c.plugin.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin read-only transaction: %w", err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	rows, err := tx.QueryContext(ctx, c.query)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return 0, fmt.Errorf("query timed out after %s: %w", c.plugin.queryTimeout, ctx.Err())
+		}
+		return 0, fmt.Errorf("failed to run query: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read query columns: %w", err)
+	}
+	if len(columns) != 1 {
+		return 0, fmt.Errorf("query must return a single column, got %d", len(columns))
+	}
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, fmt.Errorf("failed to read query result: %w", err)
+		}
+		return 0, fmt.Errorf("query returned no rows")
+	}
+
+	var raw any
+	err = rows.Scan(&raw)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read query result: %w", err)
+	}
+
+	if rows.Next() {
+		return 0, fmt.Errorf("query must return a single row")
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read query result: %w", err)
+	}
+
+	return parseSQLValue(raw)
+}
+
+// parseSQLValue converts the value returned by a driver to a float.
+func parseSQLValue(raw any) (float64, error) {
+	switch v := raw.(type) {
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case []byte:
+		return parseSQLString(string(v))
+	case string:
+		return parseSQLString(v)
+	case nil:
+		return 0, fmt.Errorf("query returned NULL")
+	default:
+		return 0, fmt.Errorf("query returned non-numeric value of type %T", raw)
+	}
+}
+
+func parseSQLString(s string) (float64, error) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, fmt.Errorf("query returned non-numeric value '%s'", s)
+	}
+	return value, nil
+}
+
+// Interval returns the interval at which the collector should run.
+func (c *SQLCollector) Interval() time.Duration {
+	return c.interval
+}
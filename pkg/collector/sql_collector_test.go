@@ -0,0 +1,172 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func sqlMetricConfig(query string) *MetricConfig {
+	return &MetricConfig{
+		MetricTypeName: MetricTypeName{
+			Type: autoscalingv2.ExternalMetricSourceType,
+			Metric: autoscalingv2.MetricIdentifier{
+				Name:     "jobs-backlog",
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"type": SQLMetricType}},
+			},
+		},
+		Config: map[string]string{sqlQueryKey: query},
+	}
+}
+
+var sqlTestHPA = &autoscalingv2.HorizontalPodAutoscaler{
+	ObjectMeta: metav1.ObjectMeta{Name: "hpa", Namespace: "default"},
+}
+
+func newTestSQLCollector(t *testing.T, query string, queryTimeout, minQueryInterval time.Duration) (*SQLCollector, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	plugin, err := NewSQLCollectorPlugin(db, nil, queryTimeout, minQueryInterval)
+	require.NoError(t, err)
+
+	c, err := plugin.NewCollector(context.Background(), sqlTestHPA, sqlMetricConfig(query), time.Minute)
+	require.NoError(t, err)
+	return c.(*SQLCollector), mock
+}
+
+func TestSQLCollectorValueParsing(t *testing.T) {
+	for _, tc := range []struct {
+		msg           string
+		value         any
+		expectedMilli int64
+		expectedErr   bool
+	}{
+		{msg: "integer", value: int64(42), expectedMilli: 42000},
+		{msg: "float", value: 1.5, expectedMilli: 1500},
+		{msg: "numeric string", value: []byte("12.25"), expectedMilli: 12250},
+		{msg: "non-numeric string", value: "many", expectedErr: true},
+		{msg: "NULL", value: nil, expectedErr: true},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			c, mock := newTestSQLCollector(t, "SELECT count(*) FROM jobs", time.Second, 0)
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tc.value))
+			mock.ExpectRollback()
+
+			metrics, err := c.GetMetrics(context.Background())
+			if tc.expectedErr {
+				require.ErrorIs(t, err, ErrTransient)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, metrics, 1)
+			require.Equal(t, "jobs-backlog", metrics[0].External.MetricName)
+			require.Equal(t, tc.expectedMilli, metrics[0].External.Value.MilliValue())
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestSQLCollectorResultShape(t *testing.T) {
+	c, mock := newTestSQLCollector(t, "SELECT id, state FROM jobs", time.Second, 0)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id").WillReturnRows(sqlmock.NewRows([]string{"id", "state"}).AddRow(1, "new"))
+	mock.ExpectRollback()
+
+	_, err := c.GetMetrics(context.Background())
+	require.ErrorIs(t, err, ErrTransient)
+
+	c, mock = newTestSQLCollector(t, "SELECT count(*) FROM jobs GROUP BY state", time.Second, 0)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1).AddRow(2))
+	mock.ExpectRollback()
+
+	_, err = c.GetMetrics(context.Background())
+	require.ErrorIs(t, err, ErrTransient)
+}
+
+func TestSQLCollectorRejectsNonSelect(t *testing.T) {
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	plugin, err := NewSQLCollectorPlugin(db, nil, time.Second, 0)
+	require.NoError(t, err)
+
+	for _, query := range []string{
+		"DELETE FROM jobs",
+		"UPDATE jobs SET state = 'done'",
+		"SELECT 1; DROP TABLE jobs",
+		"",
+	} {
+		_, err := plugin.NewCollector(context.Background(), sqlTestHPA, sqlMetricConfig(query), time.Minute)
+		require.ErrorIs(t, err, ErrPermanentConfig, query)
+	}
+
+	_, err = plugin.NewCollector(context.Background(), sqlTestHPA, sqlMetricConfig(" select count(*) from jobs; "), time.Minute)
+	require.NoError(t, err)
+}
+
+func TestSQLCollectorTimeout(t *testing.T) {
+	c, mock := newTestSQLCollector(t, "SELECT count(*) FROM jobs", 10*time.Millisecond, 0)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT count").
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	_, err := c.GetMetrics(context.Background())
+	require.ErrorIs(t, err, ErrTransient)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestSQLCollectorRateLimit(t *testing.T) {
+	c, mock := newTestSQLCollector(t, "SELECT count(*) FROM jobs", time.Second, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	for _, value := range []int64{1, 2} {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(value))
+		mock.ExpectRollback()
+	}
+
+	metrics, err := c.GetMetrics(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), metrics[0].External.Value.Value())
+
+	// the previous result is served within the minimum query interval
+	now = now.Add(30 * time.Second)
+	metrics, err = c.GetMetrics(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), metrics[0].External.Value.Value())
+
+	now = now.Add(time.Minute)
+	metrics, err = c.GetMetrics(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), metrics[0].External.Value.Value())
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLRedactor(t *testing.T) {
+	for _, tc := range []struct {
+		driver string
+		dsn    string
+	}{
+		{driver: SQLDriverPostgres, dsn: "postgres://user:s3cret@db:5432/jobs"},
+		{driver: SQLDriverPostgres, dsn: "host=db user=user password=s3cret dbname=jobs"},
+		{driver: SQLDriverMySQL, dsn: "user:s3cret@tcp(db:3306)/jobs"},
+	} {
+		redact := newSQLRedactor(tc.driver, tc.dsn)
+		err := redact(errors.New("failed to connect to " + tc.dsn + " with s3cret"))
+		require.NotContains(t, err.Error(), "s3cret", tc.dsn)
+		require.NotContains(t, err.Error(), tc.dsn)
+	}
+}
@@ -0,0 +1,207 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+)
+
+const (
+	// TimeToDrainMetricType defines the metric type of the time in seconds
+	// needed to drain the backlog of a queue at the current processing
+	// rate.
+	TimeToDrainMetricType = "time-to-drain"
+
+	timeToDrainBacklogTypeKey = "backlog-type"
+	timeToDrainBacklogPrefix  = "backlog."
+	timeToDrainRatePrefix     = "rate."
+	timeToDrainZeroRateKey    = "zero-rate"
+	timeToDrainMaxKey         = "max-time-to-drain"
+	timeToDrainZeroRateError  = "error"
+	timeToDrainZeroRateMax    = "max"
+	timeToDrainRateCollector  = PrometheusMetricType
+)
+
+// TimeToDrainCollectorPlugin creates collectors dividing the backlog of a
+// queue, collected from SQS or Nakadi, by its processing rate, collected from
+// Prometheus. The child collectors are created by the collector factory
+// from the backlog.* and rate.* config keys.
+type TimeToDrainCollectorPlugin struct {
+	factory *CollectorFactory
+}
+
+// NewTimeToDrainCollectorPlugin initializes a new TimeToDrainCollectorPlugin
+// creating the child collectors with the factory.
+func NewTimeToDrainCollectorPlugin(factory *CollectorFactory) *TimeToDrainCollectorPlugin {
+	return &TimeToDrainCollectorPlugin{factory: factory}
+}
+
+// NewCollector initializes a new time-to-drain collector from the specified
+// HPA.
+func (p *TimeToDrainCollectorPlugin) NewCollector(ctx context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
+	if config.Metric.Selector == nil {
+		return nil, NewPermanentConfigError(fmt.Errorf("selector for %s is not specified", TimeToDrainMetricType))
+	}
+
+	b := config.binder()
+	var backlogType string
+	if b.Has(timeToDrainBacklogTypeKey) {
+		b.Enum(timeToDrainBacklogTypeKey, &backlogType, AWSSQSQueueLengthMetric, NakadiMetricType)
+	} else {
+		b.Missing(timeToDrainBacklogTypeKey)
+	}
+
+	zeroRate := timeToDrainZeroRateError
+	b.Enum(timeToDrainZeroRateKey, &zeroRate, timeToDrainZeroRateError, timeToDrainZeroRateMax)
+
+	var maxTimeToDrain time.Duration
+	b.PositiveDuration(timeToDrainMaxKey, &maxTimeToDrain)
+	if zeroRate == timeToDrainZeroRateMax && maxTimeToDrain == 0 {
+		b.Invalid(timeToDrainZeroRateKey, fmt.Sprintf("requires %s", timeToDrainMaxKey))
+	}
+
+	backlogConfig := childConfig(config, backlogType, b.Prefix(timeToDrainBacklogPrefix), timeToDrainBacklogPrefix)
+	rateConfig := childConfig(config, timeToDrainRateCollector, b.Prefix(timeToDrainRatePrefix), timeToDrainRatePrefix)
+	if err := finishBinding(b, hpa); err != nil {
+		return nil, err
+	}
+
+	backlog, err := p.factory.NewCollector(ctx, hpa, backlogConfig, interval)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s backlog collector: %w", backlogType, err)
+	}
+
+	rate, err := p.factory.NewCollector(ctx, hpa, rateConfig, interval)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s rate collector: %w", timeToDrainRateCollector, err)
+	}
+
+	return &TimeToDrainCollector{
+		backlog:        backlog,
+		rate:           rate,
+		zeroRate:       zeroRate,
+		maxTimeToDrain: maxTimeToDrain,
+		interval:       interval,
+		metric:         config.Metric,
+		namespace:      hpa.Namespace,
+	}, nil
+}
+
+// childConfig returns the config of a child collector of the type from the
+// config values with the prefix removed. The child keeps the metric name of
+// the parent, its type label is replaced by the type of the child.
+func childConfig(config *MetricConfig, typ string, values map[string]string, prefix string) *MetricConfig {
+	matchLabels := make(map[string]string, len(config.Metric.Selector.MatchLabels))
+	for k, v := range config.Metric.Selector.MatchLabels {
+		matchLabels[k] = v
+	}
+	matchLabels[typeLabelKey] = typ
+
+	sources := map[string]string{}
+	for key, source := range config.ConfigSources {
+		if strings.HasPrefix(key, prefix) {
+			sources[strings.TrimPrefix(key, prefix)] = source
+		}
+	}
+
+	return &MetricConfig{
+		MetricTypeName: MetricTypeName{
+			Type: config.Type,
+			Metric: autoscalingv2.MetricIdentifier{
+				Name:     config.Metric.Name,
+				Selector: &metav1.LabelSelector{MatchLabels: matchLabels},
+			},
+		},
+		CollectorType: typ,
+		Config:        values,
+		ConfigSources: sources,
+		Interval:      config.Interval,
+		MetricSpec:    config.MetricSpec,
+	}
+}
+
+// TimeToDrainCollector collects the time in seconds needed to drain the
+// backlog of a queue at the current processing rate.
+type TimeToDrainCollector struct {
+	backlog        Collector
+	rate           Collector
+	zeroRate       string
+	maxTimeToDrain time.Duration
+	interval       time.Duration
+	metric         autoscalingv2.MetricIdentifier
+	namespace      string
+}
+
+// GetMetrics collects the backlog and the processing rate and returns the
+// backlog divided by the rate. An empty backlog takes no time to drain
+// independent of the rate, a backlog without processing fails the
+// collection or emits the max time to drain, depending on the zero-rate
+// config. The timestamp is the older one of the two values.
+func (c *TimeToDrainCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, error) {
+	backlog, err := collectSingleSample(ctx, c.backlog)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect backlog: %w", err)
+	}
+
+	rate, err := collectSingleSample(ctx, c.rate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect processing rate: %w", err)
+	}
+
+	timestamp := backlog.timestamp
+	if rate.timestamp.Before(timestamp) {
+		timestamp = rate.timestamp
+	}
+
+	var seconds float64
+	switch {
+	case backlog.value <= 0:
+		seconds = 0
+	case rate.value <= 0:
+		if c.zeroRate == timeToDrainZeroRateError {
+			return nil, fmt.Errorf("processing rate is %v, the backlog of %v can't be drained", rate.value, backlog.value)
+		}
+		seconds = c.maxTimeToDrain.Seconds()
+	default:
+		seconds = backlog.value / rate.value
+	}
+
+	if c.maxTimeToDrain > 0 {
+		seconds = math.Min(seconds, c.maxTimeToDrain.Seconds())
+	}
+
+	metricValue := NewExternalMetric(c.namespace, external_metrics.ExternalMetricValue{
+		MetricName:   c.metric.Name,
+		MetricLabels: c.metric.Selector.MatchLabels,
+		Timestamp:    metav1.Time{Time: timestamp},
+		Value:        *resource.NewMilliQuantity(int64(seconds*1000), resource.DecimalSI),
+	})
+
+	return []CollectedMetric{metricValue}, nil
+}
+
+// Interval returns the interval at which the collector should run.
+func (c *TimeToDrainCollector) Interval() time.Duration {
+	return c.interval
+}
+
+// collectSingleSample collects the value of a collector expected to collect
+// a single metric.
+func collectSingleSample(ctx context.Context, collector Collector) (sample, error) {
+	metrics, err := collector.GetMetrics(ctx)
+	if err != nil {
+		return sample{}, err
+	}
+
+	if len(metrics) != 1 {
+		return sample{}, NewTransientError(fmt.Errorf("expected to only get one metric value, got %d", len(metrics)))
+	}
+	return collectedSample(metrics[0]), nil
+}
@@ -0,0 +1,220 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+)
+
+// childCollectorPlugin creates collectors collecting a single external
+// value or failing. It records the config of the last created collector.
+type childCollectorPlugin struct {
+	value     float64
+	timestamp time.Time
+	err       error
+	config    *MetricConfig
+}
+
+func (p *childCollectorPlugin) NewCollector(_ context.Context, _ *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, _ time.Duration) (Collector, error) {
+	p.config = config
+	return makeCollectorWithStub(func() ([]CollectedMetric, error) {
+		if p.err != nil {
+			return nil, p.err
+		}
+		return []CollectedMetric{{
+			Type: autoscalingv2.ExternalMetricSourceType,
+			External: external_metrics.ExternalMetricValue{
+				Timestamp: metav1.Time{Time: p.timestamp},
+				Value:     *resource.NewMilliQuantity(int64(p.value*1000), resource.DecimalSI),
+			},
+		}}, nil
+	}), nil
+}
+
+func newTimeToDrainConfig(config map[string]string) *MetricConfig {
+	return &MetricConfig{
+		MetricTypeName: MetricTypeName{
+			Type: autoscalingv2.ExternalMetricSourceType,
+			Metric: autoscalingv2.MetricIdentifier{
+				Name:     "queue-drain",
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{typeLabelKey: TimeToDrainMetricType, "queue": "orders"}},
+			},
+		},
+		Config: config,
+	}
+}
+
+func TestTimeToDrainCollector(t *testing.T) {
+	now := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		msg        string
+		config     map[string]string
+		backlog    float64
+		backlogErr error
+		rate       float64
+		rateErr    error
+		expected   int64
+		err        error
+	}{
+		{
+			msg:      "backlog divided by rate",
+			backlog:  1200,
+			rate:     20,
+			expected: 60000,
+		},
+		{
+			msg:      "fractional rate",
+			backlog:  3,
+			rate:     0.5,
+			expected: 6000,
+		},
+		{
+			msg:      "empty backlog with zero rate",
+			backlog:  0,
+			rate:     0,
+			expected: 0,
+		},
+		{
+			msg:     "zero rate fails",
+			backlog: 100,
+			rate:    0,
+			err:     fmt.Errorf("processing rate is 0, the backlog of 100 can't be drained"),
+		},
+		{
+			msg:      "zero rate emits the max",
+			config:   map[string]string{timeToDrainZeroRateKey: timeToDrainZeroRateMax, timeToDrainMaxKey: "1h"},
+			backlog:  100,
+			rate:     0,
+			expected: 3600000,
+		},
+		{
+			msg:      "capped by the max",
+			config:   map[string]string{timeToDrainMaxKey: "10m"},
+			backlog:  100000,
+			rate:     1,
+			expected: 600000,
+		},
+		{
+			msg:        "backlog collection failing",
+			backlogErr: NewTransientError(fmt.Errorf("queue not found")),
+			rate:       10,
+			err:        fmt.Errorf("failed to collect backlog: queue not found"),
+		},
+		{
+			msg:     "rate collection failing",
+			backlog: 100,
+			rateErr: NewTransientError(fmt.Errorf("prometheus unavailable")),
+			err:     fmt.Errorf("failed to collect processing rate: prometheus unavailable"),
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			backlog := &childCollectorPlugin{value: tc.backlog, timestamp: now, err: tc.backlogErr}
+			rate := &childCollectorPlugin{value: tc.rate, timestamp: now.Add(-time.Minute), err: tc.rateErr}
+			factory := NewCollectorFactory()
+			factory.RegisterExternalCollector([]string{AWSSQSQueueLengthMetric}, backlog)
+			factory.RegisterExternalCollector([]string{PrometheusMetricType}, rate)
+
+			config := map[string]string{
+				timeToDrainBacklogTypeKey:         AWSSQSQueueLengthMetric,
+				timeToDrainBacklogPrefix + "name": "orders",
+				timeToDrainRatePrefix + "query":   "sum(rate(processed_total[1m]))",
+			}
+			for k, v := range tc.config {
+				config[k] = v
+			}
+
+			plugin := NewTimeToDrainCollectorPlugin(factory)
+			c, err := plugin.NewCollector(context.Background(), &autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Namespace: "default"}}, newTimeToDrainConfig(config), time.Minute)
+			require.NoError(t, err)
+
+			metrics, err := c.GetMetrics(context.Background())
+			if tc.err != nil {
+				require.EqualError(t, err, tc.err.Error())
+				if tc.backlogErr != nil || tc.rateErr != nil {
+					require.ErrorIs(t, err, ErrTransient)
+				}
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, metrics, 1)
+			require.Equal(t, "default", metrics[0].Namespace)
+			require.Equal(t, "queue-drain", metrics[0].External.MetricName)
+			require.Equal(t, tc.expected, metrics[0].External.Value.MilliValue())
+			// the timestamp of the older value.
+			require.Equal(t, now.Add(-time.Minute), metrics[0].External.Timestamp.Time)
+		})
+	}
+}
+
+func TestTimeToDrainChildConfigs(t *testing.T) {
+	backlog := &childCollectorPlugin{}
+	rate := &childCollectorPlugin{}
+	factory := NewCollectorFactory()
+	factory.RegisterExternalCollector([]string{NakadiMetricType}, backlog)
+	factory.RegisterExternalCollector([]string{PrometheusMetricType}, rate)
+
+	config := newTimeToDrainConfig(map[string]string{
+		timeToDrainBacklogTypeKey:                    NakadiMetricType,
+		timeToDrainBacklogPrefix + "subscription-id": "abc",
+		timeToDrainBacklogPrefix + "metric-type":     "unconsumed-events",
+		timeToDrainRatePrefix + "query":              "sum(rate(processed_total[1m]))",
+	})
+	config.ConfigSources = map[string]string{
+		timeToDrainRatePrefix + "query": "metric-config.external.queue-drain.time-to-drain/rate.query",
+	}
+
+	_, err := NewTimeToDrainCollectorPlugin(factory).NewCollector(context.Background(), &autoscalingv2.HorizontalPodAutoscaler{}, config, time.Minute)
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]string{"subscription-id": "abc", "metric-type": "unconsumed-events"}, backlog.config.Config)
+	require.Equal(t, map[string]string{typeLabelKey: NakadiMetricType, "queue": "orders"}, backlog.config.Metric.Selector.MatchLabels)
+	require.Equal(t, map[string]string{"query": "sum(rate(processed_total[1m]))"}, rate.config.Config)
+	require.Equal(t, map[string]string{"query": "metric-config.external.queue-drain.time-to-drain/rate.query"}, rate.config.ConfigSources)
+	require.Equal(t, map[string]string{typeLabelKey: PrometheusMetricType, "queue": "orders"}, rate.config.Metric.Selector.MatchLabels)
+	// the config of the parent is unchanged.
+	require.Equal(t, TimeToDrainMetricType, config.Metric.Selector.MatchLabels[typeLabelKey])
+}
+
+func TestTimeToDrainInvalidConfig(t *testing.T) {
+	factory := NewCollectorFactory()
+	factory.RegisterExternalCollector([]string{PrometheusMetricType}, &childCollectorPlugin{})
+
+	for _, tc := range []struct {
+		msg    string
+		config map[string]string
+	}{
+		{
+			msg:    "missing backlog type",
+			config: map[string]string{},
+		},
+		{
+			msg:    "invalid backlog type",
+			config: map[string]string{timeToDrainBacklogTypeKey: "kafka"},
+		},
+		{
+			msg:    "zero rate max without max time to drain",
+			config: map[string]string{timeToDrainBacklogTypeKey: NakadiMetricType, timeToDrainZeroRateKey: timeToDrainZeroRateMax},
+		},
+		{
+			msg:    "invalid zero rate policy",
+			config: map[string]string{timeToDrainBacklogTypeKey: NakadiMetricType, timeToDrainZeroRateKey: "ignore"},
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			_, err := NewTimeToDrainCollectorPlugin(factory).NewCollector(context.Background(), &autoscalingv2.HorizontalPodAutoscaler{}, newTimeToDrainConfig(tc.config), time.Minute)
+			require.ErrorIs(t, err, ErrPermanentConfig)
+		})
+	}
+
+	// the backlog plugin isn't registered.
+	_, err := NewTimeToDrainCollectorPlugin(factory).NewCollector(context.Background(), &autoscalingv2.HorizontalPodAutoscaler{}, newTimeToDrainConfig(map[string]string{timeToDrainBacklogTypeKey: NakadiMetricType}), time.Minute)
+	require.ErrorIs(t, err, &PluginNotFoundError{})
+}
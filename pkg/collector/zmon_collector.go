@@ -1,7 +1,9 @@
 package collector
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -20,136 +22,226 @@ const (
 	ZMONMetricType          = "zmon"
 	ZMONCheckMetricLegacy   = "zmon-check"
 	zmonCheckIDLabelKey     = "check-id"
+	zmonCheckAliasLabelKey  = "check-alias"
 	zmonKeyLabelKey         = "key"
 	zmonDurationLabelKey    = "duration"
 	zmonAggregatorsLabelKey = "aggregators"
 	zmonTagPrefixLabelKey   = "tag-"
+	zmonCheckAggregatorKey  = "aggregator-across-checks"
+	zmonPartialKey          = "partial"
 	defaultQueryDuration    = 10 * time.Minute
 )
 
+// Policies for checks without a result when multiple checks are
+// aggregated.
+const (
+	zmonPartialError = "error"
+	zmonPartialSkip  = "skip"
+)
+
 // ZMONCollectorPlugin defines a plugin for creating collectors that can get
 // metrics from ZMON.
 type ZMONCollectorPlugin struct {
-	zmon zmon.ZMON
+	zmon    zmon.ZMON
+	aliases *zmon.CheckAliases
 }
 
-// NewZMONCollectorPlugin initializes a new ZMONCollectorPlugin.
-func NewZMONCollectorPlugin(zmon zmon.ZMON) (*ZMONCollectorPlugin, error) {
+// NewZMONCollectorPlugin initializes a new ZMONCollectorPlugin. The optional
+// check aliases are used to resolve checks referenced by alias instead of ID.
+func NewZMONCollectorPlugin(zmon zmon.ZMON, aliases *zmon.CheckAliases) (*ZMONCollectorPlugin, error) {
 	return &ZMONCollectorPlugin{
-		zmon: zmon,
+		zmon:    zmon,
+		aliases: aliases,
 	}, nil
 }
 
 // NewCollector initializes a new ZMON collector from the specified HPA.
 func (c *ZMONCollectorPlugin) NewCollector(_ context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (Collector, error) {
-	return NewZMONCollector(c.zmon, hpa, config, interval)
+	return NewZMONCollector(c.zmon, c.aliases, hpa, config, interval)
 }
 
 // ZMONCollector defines a collector that is able to collect metrics from ZMON.
 type ZMONCollector struct {
 	zmon        zmon.ZMON
 	interval    time.Duration
-	checkID     int
+	checkIDs    []int
+	aggregator  string
+	partial     string
 	key         string
 	tags        map[string]string
 	duration    time.Duration
 	aggregators []string
 	metric      autoscalingv2.MetricIdentifier
-	metricType  autoscalingv2.MetricSourceType
 	namespace   string
+	timeout     time.Duration
 }
 
 // NewZMONCollector initializes a new ZMONCollector.
-func NewZMONCollector(zmon zmon.ZMON, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (*ZMONCollector, error) {
+// A check can be referenced either by ID or by an alias. If both are
+// specified the check ID takes precedence. Multiple comma separated check
+// IDs are aggregated into one value.
+func NewZMONCollector(client zmon.ZMON, aliases *zmon.CheckAliases, hpa *autoscalingv2.HorizontalPodAutoscaler, config *MetricConfig, interval time.Duration) (*ZMONCollector, error) {
 	if config.Metric.Selector == nil {
-		return nil, fmt.Errorf("selector for zmon-check is not specified")
+		return nil, NewPermanentConfigError(fmt.Errorf("selector for zmon-check is not specified"))
 	}
 
-	checkIDStr, ok := config.Config[zmonCheckIDLabelKey]
-	if !ok {
-		return nil, fmt.Errorf("ZMON check ID not specified on metric")
-	}
+	b := config.binder()
+	var checkIDs []int
+	var aliasAggregators []string
+	var ids, aliasName string
+	if b.String(zmonCheckIDLabelKey, &ids) {
+		for _, id := range strings.Split(ids, ",") {
+			checkID, err := strconv.Atoi(strings.TrimSpace(id))
+			if err != nil {
+				b.Invalid(zmonCheckIDLabelKey, fmt.Sprintf("invalid ZMON check ID '%s'", id))
+				break
+			}
+			checkIDs = append(checkIDs, checkID)
+		}
+	} else if b.String(zmonCheckAliasLabelKey, &aliasName) {
+		if aliases == nil {
+			return nil, NewPermanentConfigError(fmt.Errorf("ZMON check alias '%s' specified but no check aliases are configured", aliasName))
+		}
 
-	checkID, err := strconv.Atoi(checkIDStr)
-	if err != nil {
-		return nil, err
+		alias, err := aliases.Resolve(aliasName)
+		if err != nil {
+			return nil, NewPermanentConfigError(err)
+		}
+		checkIDs = []int{alias.CheckID}
+		aliasAggregators = alias.Aggregators
+	} else {
+		b.Missing(zmonCheckIDLabelKey, zmonCheckAliasLabelKey)
 	}
 
-	key := ""
-
 	// get optional key
-	if k, ok := config.Config[zmonKeyLabelKey]; ok {
-		key = k
-	}
+	key := ""
+	b.String(zmonKeyLabelKey, &key)
 
+	// parse optional duration value
 	duration := defaultQueryDuration
+	b.Duration(zmonDurationLabelKey, &duration)
 
-	// parse optional duration value
-	if d, ok := config.Config[zmonDurationLabelKey]; ok {
-		duration, err = time.ParseDuration(d)
-		if err != nil {
-			return nil, err
+	// parse tags
+	tags := b.Prefix(zmonTagPrefixLabelKey)
+
+	// default aggregator is last unless defined by the check alias
+	aggregators := []string{"last"}
+	if len(aliasAggregators) > 0 {
+		aggregators = aliasAggregators
+	}
+	if b.List(zmonAggregatorsLabelKey, &aggregators) {
+		// fail at creation instead of on every query.
+		if err := zmon.ValidateAggregators(aggregators); err != nil {
+			b.Invalid(zmonAggregatorsLabelKey, err.Error())
 		}
 	}
 
-	// parse tags
-	tags := make(map[string]string)
-	for k, v := range config.Config {
-		if strings.HasPrefix(k, zmonTagPrefixLabelKey) {
-			key := strings.TrimPrefix(k, zmonTagPrefixLabelKey)
-			tags[key] = v
-		}
+	aggregator := "sum"
+	b.Enum(zmonCheckAggregatorKey, &aggregator, "sum", "max", "min", "avg")
+
+	partial := zmonPartialError
+	b.Enum(zmonPartialKey, &partial, zmonPartialError, zmonPartialSkip)
+
+	if err := finishBinding(b, hpa); err != nil {
+		return nil, err
 	}
 
-	// default aggregator is last
-	aggregators := []string{"last"}
-	if k, ok := config.Config[zmonAggregatorsLabelKey]; ok {
-		aggregators = strings.Split(k, ",")
+	timeout, err := parseRequestTimeout(config)
+	if err != nil {
+		return nil, err
 	}
 
 	return &ZMONCollector{
-		zmon:        zmon,
+		zmon:        client,
 		interval:    interval,
-		checkID:     checkID,
+		checkIDs:    checkIDs,
+		aggregator:  aggregator,
+		partial:     partial,
 		key:         key,
 		tags:        tags,
 		duration:    duration,
 		aggregators: aggregators,
 		metric:      config.Metric,
-		metricType:  config.Type,
 		namespace:   hpa.Namespace,
+		timeout:     timeout,
 	}, nil
 }
 
-// GetMetrics returns a list of collected metrics for the ZMON check.
+// GetMetrics returns a list of collected metrics for the ZMON check. The
+// last data points of multiple checks are aggregated into one value. Checks
+// failing or without data points fail the collection unless the partial
+// policy is skip.
 func (c *ZMONCollector) GetMetrics(ctx context.Context) ([]CollectedMetric, error) {
-	dataPoints, err := c.zmon.Query(c.checkID, c.key, c.tags, c.aggregators, c.duration)
-	if err != nil {
-		return nil, err
+	ctx, cancel := withRequestTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var points []zmon.DataPoint
+	var errs []error
+	for _, checkID := range c.checkIDs {
+		dataPoints, err := c.zmon.Query(ctx, checkID, c.key, c.tags, c.aggregators, c.duration)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("check %d: %w", checkID, err))
+			continue
+		}
+
+		if len(dataPoints) < 1 {
+			if len(c.checkIDs) > 1 && c.partial == zmonPartialError {
+				return nil, NewTransientError(fmt.Errorf("no data points for ZMON check %d", checkID))
+			}
+			continue
+		}
+
+		// pick the last data point
+		// TODO: do more fancy aggregations here (or in the query function)
+		points = append(points, dataPoints[len(dataPoints)-1])
 	}
 
-	if len(dataPoints) < 1 {
-		return nil, nil
+	if len(errs) > 0 && (c.partial == zmonPartialError || len(errs) == len(c.checkIDs)) {
+		return nil, NewTransientError(errors.Join(errs...))
 	}
 
-	// pick the last data point
-	// TODO: do more fancy aggregations here (or in the query function)
-	point := dataPoints[len(dataPoints)-1]
-
-	metricValue := CollectedMetric{
-		Namespace: c.namespace,
-		Type:      c.metricType,
-		External: external_metrics.ExternalMetricValue{
-			MetricName:   c.metric.Name,
-			MetricLabels: c.metric.Selector.MatchLabels,
-			Timestamp:    metav1.Time{Time: point.Time},
-			Value:        *resource.NewMilliQuantity(int64(point.Value*1000), resource.DecimalSI),
-		},
+	if len(points) < 1 {
+		return nil, nil
 	}
 
+	point := aggregateDataPoints(points, c.aggregator)
+
+	metricValue := NewExternalMetric(c.namespace, external_metrics.ExternalMetricValue{
+		MetricName:   c.metric.Name,
+		MetricLabels: c.metric.Selector.MatchLabels,
+		Timestamp:    metav1.Time{Time: point.Time},
+		Value:        *resource.NewMilliQuantity(int64(point.Value*1000), resource.DecimalSI),
+	})
+
 	return []CollectedMetric{metricValue}, nil
 }
 
+// aggregateDataPoints aggregates the data points of multiple checks. The
+// time of the aggregate is the latest time of the data points.
+func aggregateDataPoints(points []zmon.DataPoint, aggregator string) zmon.DataPoint {
+	result := points[0]
+	for _, point := range points[1:] {
+		if point.Time.After(result.Time) {
+			result.Time = point.Time
+		}
+
+		switch aggregator {
+		case "max":
+			result.Value = math.Max(result.Value, point.Value)
+		case "min":
+			result.Value = math.Min(result.Value, point.Value)
+		default:
+			result.Value += point.Value
+		}
+	}
+
+	if aggregator == "avg" {
+		result.Value /= float64(len(points))
+	}
+
+	return result
+}
+
 // Interval returns the interval at which the collector should run.
 func (c *ZMONCollector) Interval() time.Duration {
 	return c.interval
@@ -2,6 +2,8 @@ package collector
 
 import (
 	"context"
+	"errors"
+	"net/http"
 	"testing"
 	"time"
 
@@ -17,12 +19,25 @@ type zmonMock struct {
 	dataPoints []zmon.DataPoint
 }
 
-func (m zmonMock) Query(checkID int, key string, tags map[string]string, aggregators []string, duration time.Duration) ([]zmon.DataPoint, error) {
+func (m zmonMock) Query(_ context.Context, checkID int, key string, tags map[string]string, aggregators []string, duration time.Duration) ([]zmon.DataPoint, error) {
 	return m.dataPoints, nil
 }
 
+// zmonChecksMock returns the data points or the error of each check.
+type zmonChecksMock struct {
+	dataPoints map[int][]zmon.DataPoint
+	errors     map[int]error
+}
+
+func (m zmonChecksMock) Query(_ context.Context, checkID int, key string, tags map[string]string, aggregators []string, duration time.Duration) ([]zmon.DataPoint, error) {
+	if err, ok := m.errors[checkID]; ok {
+		return nil, err
+	}
+	return m.dataPoints[checkID], nil
+}
+
 func TestZMONCollectorNewCollector(t *testing.T) {
-	collectPlugin, _ := NewZMONCollectorPlugin(zmonMock{})
+	collectPlugin, _ := NewZMONCollectorPlugin(zmonMock{}, nil)
 
 	config := &MetricConfig{
 		MetricTypeName: MetricTypeName{
@@ -44,7 +59,9 @@ func TestZMONCollectorNewCollector(t *testing.T) {
 	require.NotNil(t, collector)
 	zmonCollector := collector.(*ZMONCollector)
 	require.Equal(t, "key", zmonCollector.key)
-	require.Equal(t, 1234, zmonCollector.checkID)
+	require.Equal(t, []int{1234}, zmonCollector.checkIDs)
+	require.Equal(t, "sum", zmonCollector.aggregator)
+	require.Equal(t, zmonPartialError, zmonCollector.partial)
 	require.Equal(t, 1*time.Second, zmonCollector.interval)
 	require.Equal(t, 5*time.Minute, zmonCollector.duration)
 	require.Equal(t, []string{"max"}, zmonCollector.aggregators)
@@ -57,6 +74,89 @@ func TestZMONCollectorNewCollector(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestZMONCollectorNewCollectorCheckAlias(t *testing.T) {
+	aliases := zmon.NewCheckAliases(map[string]zmon.CheckAlias{
+		"my-service-latency": {CheckID: 1234, Aggregators: []string{"max"}},
+		"my-service-errors":  {CheckID: 5678},
+	})
+	collectPlugin, _ := NewZMONCollectorPlugin(zmonMock{}, aliases)
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{}
+
+	for _, tc := range []struct {
+		name        string
+		config      map[string]string
+		checkID     int
+		aggregators []string
+		err         string
+	}{
+		{
+			name:        "alias with default aggregators",
+			config:      map[string]string{zmonCheckAliasLabelKey: "my-service-latency"},
+			checkID:     1234,
+			aggregators: []string{"max"},
+		},
+		{
+			name:        "alias without aggregators",
+			config:      map[string]string{zmonCheckAliasLabelKey: "my-service-errors"},
+			checkID:     5678,
+			aggregators: []string{"last"},
+		},
+		{
+			name: "aggregators override alias aggregators",
+			config: map[string]string{
+				zmonCheckAliasLabelKey:  "my-service-latency",
+				zmonAggregatorsLabelKey: "avg",
+			},
+			checkID:     1234,
+			aggregators: []string{"avg"},
+		},
+		{
+			name: "check ID takes precedence over alias",
+			config: map[string]string{
+				zmonCheckAliasLabelKey: "my-service-latency",
+				zmonCheckIDLabelKey:    "42",
+			},
+			checkID:     42,
+			aggregators: []string{"last"},
+		},
+		{
+			name:   "unknown alias",
+			config: map[string]string{zmonCheckAliasLabelKey: "unknown"},
+			err:    "unknown ZMON check alias 'unknown', available aliases: my-service-errors, my-service-latency",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config := &MetricConfig{
+				MetricTypeName: MetricTypeName{
+					Metric: newMetricIdentifier("foo-check", ZMONMetricType),
+				},
+				Config: tc.config,
+			}
+
+			collector, err := collectPlugin.NewCollector(context.Background(), hpa, config, 1*time.Second)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			zmonCollector := collector.(*ZMONCollector)
+			require.Equal(t, []int{tc.checkID}, zmonCollector.checkIDs)
+			require.Equal(t, tc.aggregators, zmonCollector.aggregators)
+		})
+	}
+
+	// aliases are not supported if not configured.
+	collectPlugin, _ = NewZMONCollectorPlugin(zmonMock{}, nil)
+	config := &MetricConfig{
+		MetricTypeName: MetricTypeName{
+			Metric: newMetricIdentifier("foo-check", ZMONMetricType),
+		},
+		Config: map[string]string{zmonCheckAliasLabelKey: "my-service-latency"},
+	}
+	_, err := collectPlugin.NewCollector(context.Background(), hpa, config, 1*time.Second)
+	require.Error(t, err)
+}
+
 func newMetricIdentifier(metricName, metricType string) autoscalingv2.MetricIdentifier {
 	selector := metav1.LabelSelector{
 		MatchLabels: map[string]string{
@@ -97,7 +197,7 @@ func TestZMONCollectorGetMetrics(tt *testing.T) {
 			collectedMetrics: []CollectedMetric{
 				{
 					Namespace: "default",
-					Type:      config.Type,
+					Type:      autoscalingv2.ExternalMetricSourceType,
 					External: external_metrics.ExternalMetricValue{
 						MetricName:   config.Metric.Name,
 						MetricLabels: config.Metric.Selector.MatchLabels,
@@ -122,7 +222,7 @@ func TestZMONCollectorGetMetrics(tt *testing.T) {
 				},
 			}
 
-			zmonCollector, err := NewZMONCollector(z, hpa, config, 1*time.Second)
+			zmonCollector, err := NewZMONCollector(z, nil, hpa, config, 1*time.Second)
 			require.NoError(t, err)
 
 			metrics, _ := zmonCollector.GetMetrics(context.Background())
@@ -131,7 +231,192 @@ func TestZMONCollectorGetMetrics(tt *testing.T) {
 	}
 }
 
+func TestZMONCollectorMultipleChecks(t *testing.T) {
+	t1 := time.Date(2026, time.October, 15, 12, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Minute)
+	z := zmonChecksMock{
+		dataPoints: map[int][]zmon.DataPoint{
+			1234: {{Time: t1, Value: 1.0}, {Time: t1, Value: 3.0}},
+			5678: {{Time: t2, Value: 5.0}},
+			4321: nil,
+		},
+		errors: map[int]error{
+			8765: errors.New("internal server error"),
+		},
+	}
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+		},
+	}
+
+	for _, tc := range []struct {
+		msg         string
+		config      map[string]string
+		value       int64
+		timestamp   time.Time
+		noMetrics   bool
+		expectError bool
+	}{
+		{
+			msg:       "sum by default",
+			config:    map[string]string{zmonCheckIDLabelKey: "1234,5678"},
+			value:     8000,
+			timestamp: t2,
+		},
+		{
+			msg:       "max",
+			config:    map[string]string{zmonCheckIDLabelKey: "1234, 5678", zmonCheckAggregatorKey: "max"},
+			value:     5000,
+			timestamp: t2,
+		},
+		{
+			msg:       "min",
+			config:    map[string]string{zmonCheckIDLabelKey: "1234,5678", zmonCheckAggregatorKey: "min"},
+			value:     3000,
+			timestamp: t2,
+		},
+		{
+			msg:       "avg",
+			config:    map[string]string{zmonCheckIDLabelKey: "1234,5678", zmonCheckAggregatorKey: "avg"},
+			value:     4000,
+			timestamp: t2,
+		},
+		{
+			msg:         "check without data points fails by default",
+			config:      map[string]string{zmonCheckIDLabelKey: "1234,4321"},
+			expectError: true,
+		},
+		{
+			msg:         "failing check fails by default",
+			config:      map[string]string{zmonCheckIDLabelKey: "1234,8765"},
+			expectError: true,
+		},
+		{
+			msg:         "failing check fails with partial error",
+			config:      map[string]string{zmonCheckIDLabelKey: "1234,8765", zmonPartialKey: zmonPartialError},
+			expectError: true,
+		},
+		{
+			msg:       "check without data points is skipped with partial skip",
+			config:    map[string]string{zmonCheckIDLabelKey: "1234,4321", zmonPartialKey: zmonPartialSkip},
+			value:     3000,
+			timestamp: t1,
+		},
+		{
+			msg:       "failing check is skipped with partial skip",
+			config:    map[string]string{zmonCheckIDLabelKey: "8765,5678", zmonPartialKey: zmonPartialSkip},
+			value:     5000,
+			timestamp: t2,
+		},
+		{
+			msg:         "all checks failing fail with partial skip",
+			config:      map[string]string{zmonCheckIDLabelKey: "8765,8765", zmonPartialKey: zmonPartialSkip},
+			expectError: true,
+		},
+		{
+			msg:       "no data points with partial skip",
+			config:    map[string]string{zmonCheckIDLabelKey: "4321,4321", zmonPartialKey: zmonPartialSkip},
+			noMetrics: true,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			config := &MetricConfig{
+				MetricTypeName: MetricTypeName{
+					Metric: newMetricIdentifier("foo-check", ZMONMetricType),
+					Type:   autoscalingv2.ExternalMetricSourceType,
+				},
+				Config: tc.config,
+			}
+
+			zmonCollector, err := NewZMONCollector(z, nil, hpa, config, 1*time.Second)
+			require.NoError(t, err)
+
+			metrics, err := zmonCollector.GetMetrics(context.Background())
+			if tc.expectError {
+				require.ErrorIs(t, err, ErrTransient)
+				return
+			}
+			require.NoError(t, err)
+			if tc.noMetrics {
+				require.Empty(t, metrics)
+				return
+			}
+			require.Len(t, metrics, 1)
+			require.Equal(t, tc.value, metrics[0].External.Value.MilliValue())
+			require.Equal(t, tc.timestamp, metrics[0].External.Timestamp.Time)
+		})
+	}
+}
+
+func TestZMONCollectorPercentileAggregators(t *testing.T) {
+	config := &MetricConfig{
+		MetricTypeName: MetricTypeName{
+			Metric: newMetricIdentifier("foo-check", ZMONMetricType),
+		},
+		Config: map[string]string{
+			zmonCheckIDLabelKey:     "1234",
+			zmonAggregatorsLabelKey: "p95",
+		},
+	}
+
+	collector, err := NewZMONCollector(zmonMock{}, nil, &autoscalingv2.HorizontalPodAutoscaler{}, config, 1*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []string{"p95"}, collector.aggregators)
+
+	// invalid percentiles fail at creation instead of on every query.
+	config.Config[zmonAggregatorsLabelKey] = "max,p90"
+	_, err = NewZMONCollector(zmonMock{}, nil, &autoscalingv2.HorizontalPodAutoscaler{}, config, 1*time.Minute)
+	require.ErrorIs(t, err, ErrPermanentConfig)
+	require.Contains(t, err.Error(), "unsupported percentile aggregator 'p90'")
+}
+
+func TestZMONCollectorMultipleChecksInvalidConfig(t *testing.T) {
+	for _, cfg := range []map[string]string{
+		{zmonCheckIDLabelKey: "1234,"},
+		{zmonCheckIDLabelKey: "1234,abc"},
+		{zmonCheckIDLabelKey: "1234,5678", zmonCheckAggregatorKey: "median"},
+		{zmonCheckIDLabelKey: "1234,5678", zmonPartialKey: "ignore"},
+	} {
+		config := &MetricConfig{
+			MetricTypeName: MetricTypeName{
+				Metric: newMetricIdentifier("foo-check", ZMONMetricType),
+			},
+			Config: cfg,
+		}
+		_, err := NewZMONCollector(zmonMock{}, nil, &autoscalingv2.HorizontalPodAutoscaler{}, config, 1*time.Minute)
+		require.ErrorIs(t, err, ErrPermanentConfig, cfg)
+	}
+}
+
 func TestZMONCollectorInterval(t *testing.T) {
 	collector := ZMONCollector{interval: 1 * time.Second}
 	require.Equal(t, 1*time.Second, collector.Interval())
 }
+
+func TestZMONCollectorTimeout(t *testing.T) {
+	server := newStalledServer(t)
+
+	config := &MetricConfig{
+		MetricTypeName: MetricTypeName{
+			Metric: newMetricIdentifier("foo-check", ZMONMetricType),
+		},
+		Config: map[string]string{
+			zmonCheckIDLabelKey: "1234",
+			requestTimeoutKey:   "100ms",
+		},
+	}
+
+	zmonCollector, err := NewZMONCollector(zmon.NewZMONClient(server.URL, http.DefaultClient), nil, &autoscalingv2.HorizontalPodAutoscaler{}, config, 1*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, 100*time.Millisecond, zmonCollector.timeout)
+
+	start := time.Now()
+	_, err = zmonCollector.GetMetrics(context.Background())
+	require.ErrorIs(t, err, ErrTransient)
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	config.Config[requestTimeoutKey] = "invalid"
+	_, err = NewZMONCollector(zmonMock{}, nil, &autoscalingv2.HorizontalPodAutoscaler{}, config, 1*time.Minute)
+	require.ErrorIs(t, err, ErrPermanentConfig)
+}
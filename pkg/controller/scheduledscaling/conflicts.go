@@ -0,0 +1,167 @@
+package scheduledscaling
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "github.com/zalando-incubator/kube-metrics-adapter/pkg/apis/zalando.org/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// DefaultScheduleConflictFactor is the default factor by which the
+	// values of overlapping schedules have to differ to be reported as
+	// conflict.
+	DefaultScheduleConflictFactor = 5.0
+
+	// scheduleConflictHorizon is how far ahead overlapping schedules are
+	// looked for.
+	scheduleConflictHorizon = 7 * 24 * time.Hour
+)
+
+// scheduleWindow is a window of a schedule and its value.
+type scheduleWindow struct {
+	start time.Time
+	end   time.Time
+	value int64
+}
+
+// ScheduleConflict describes two schedules of a [Cluster]ScalingSchedule
+// whose windows overlap while their values differ by more than the conflict
+// factor.
+type ScheduleConflict struct {
+	// First and Second are the indices of the schedules.
+	First, Second int
+	// FirstValue and SecondValue are the values of the schedules in the
+	// overlap.
+	FirstValue, SecondValue int64
+	// Start and End are the bounds of the first overlap.
+	Start, End time.Time
+}
+
+func (c ScheduleConflict) String() string {
+	return fmt.Sprintf("schedules %d and %d with values %d and %d overlap from %s to %s",
+		c.First, c.Second, c.FirstValue, c.SecondValue,
+		c.Start.UTC().Format(time.RFC3339), c.End.UTC().Format(time.RFC3339))
+}
+
+// windowsWithin returns the windows of the schedule ending after now and
+// starting before the horizon. The windows are computed with ScheduleWindow
+// for every day up to the horizon, so DayValues are taken into account.
+func windowsWithin(now time.Time, horizon time.Duration, schedule v1.Schedule, defaultTimeZone string) ([]scheduleWindow, error) {
+	until := now.Add(horizon)
+
+	var windows []scheduleWindow
+	for day := now; !day.After(until.AddDate(0, 0, 1)); day = day.AddDate(0, 0, 1) {
+		start, end, value, err := ScheduleWindow(day, schedule, defaultTimeZone)
+		if err != nil {
+			return nil, err
+		}
+		if start.IsZero() || !end.After(now) || !start.Before(until) {
+			continue
+		}
+
+		window := scheduleWindow{start: start, end: end, value: value}
+		if len(windows) > 0 && windows[len(windows)-1] == window {
+			continue
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// FindScheduleConflicts returns the first overlap within the horizon of each
+// pair of schedules whose values differ by more than the factor. Schedules
+// which can't be evaluated are skipped, they're reported as invalid.
+func FindScheduleConflicts(now time.Time, horizon time.Duration, schedules []v1.Schedule, factor float64, defaultTimeZone string) []ScheduleConflict {
+	windows := make([][]scheduleWindow, len(schedules))
+	for i, schedule := range schedules {
+		// invalid schedules don't have windows.
+		windows[i], _ = windowsWithin(now, horizon, schedule, defaultTimeZone)
+	}
+
+	var conflicts []ScheduleConflict
+	for i := range schedules {
+		for j := i + 1; j < len(schedules); j++ {
+			if conflict, ok := firstConflict(windows[i], windows[j], factor); ok {
+				conflict.First, conflict.Second = i, j
+				conflicts = append(conflicts, conflict)
+			}
+		}
+	}
+	return conflicts
+}
+
+// firstConflict returns the earliest overlap of the windows of two
+// schedules whose values differ by more than the factor.
+func firstConflict(first, second []scheduleWindow, factor float64) (ScheduleConflict, bool) {
+	var conflict ScheduleConflict
+	found := false
+	for _, a := range first {
+		for _, b := range second {
+			start, end := a.start, a.end
+			if b.start.After(start) {
+				start = b.start
+			}
+			if b.end.Before(end) {
+				end = b.end
+			}
+			if !start.Before(end) || !valuesConflict(a.value, b.value, factor) {
+				continue
+			}
+			if !found || start.Before(conflict.Start) {
+				conflict = ScheduleConflict{FirstValue: a.value, SecondValue: b.value, Start: start, End: end}
+				found = true
+			}
+		}
+	}
+	return conflict, found
+}
+
+// valuesConflict returns true if the larger value is more than factor times
+// the smaller one.
+func valuesConflict(a, b int64, factor float64) bool {
+	low, high := min(a, b), max(a, b)
+	return float64(high) > factor*float64(low)
+}
+
+// updateConflictsCondition sets the Conflicts condition of the status if
+// schedules with values differing by more than the conflict factor overlap
+// within the next 7 days and removes it otherwise. A warning event is
+// recorded when the condition is set, not when the conflicts change while
+// it's set. It returns true if the conditions changed.
+func (c *Controller) updateConflictsCondition(object runtime.Object, status *v1.ScalingScheduleStatus, spec v1.ScalingScheduleSpec, generation int64) bool {
+	if c.conflictFactor <= 0 {
+		return meta.RemoveStatusCondition(&status.Conditions, v1.ScalingScheduleConflictsCondition)
+	}
+
+	conflicts := FindScheduleConflicts(c.now(), scheduleConflictHorizon, spec.Schedules, c.conflictFactor, c.defaultTimeZone)
+	if len(conflicts) == 0 {
+		return meta.RemoveStatusCondition(&status.Conditions, v1.ScalingScheduleConflictsCondition)
+	}
+
+	descriptions := make([]string, 0, len(conflicts))
+	for _, conflict := range conflicts {
+		descriptions = append(descriptions, conflict.String())
+	}
+	message := strings.Join(descriptions, "; ")
+
+	set := meta.FindStatusCondition(status.Conditions, v1.ScalingScheduleConflictsCondition) == nil
+	changed := meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               v1.ScalingScheduleConflictsCondition,
+		Status:             metav1.ConditionTrue,
+		Reason:             "OverlappingSchedules",
+		Message:            message,
+		ObservedGeneration: generation,
+		LastTransitionTime: metav1.NewTime(c.now()),
+	})
+
+	if set {
+		c.recorder.Eventf(object, corev1.EventTypeWarning, "ScheduleConflicts", "Overlapping schedules with values differing by more than %gx: %s", c.conflictFactor, message)
+	}
+	return changed
+}
@@ -0,0 +1,208 @@
+package scheduledscaling
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	v1 "github.com/zalando-incubator/kube-metrics-adapter/pkg/apis/zalando.org/v1"
+	zfake "github.com/zalando-incubator/kube-metrics-adapter/pkg/client/clientset/versioned/fake"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func oneTimeSchedule(date string, minutes int, value int64) v1.Schedule {
+	return v1.Schedule{
+		Type:            v1.OneTimeSchedule,
+		Date:            scheduleDate(date),
+		DurationMinutes: minutes,
+		Value:           value,
+	}
+}
+
+func TestFindScheduleConflicts(t *testing.T) {
+	// a Monday.
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	daily := v1.Schedule{
+		Type: v1.RepeatingSchedule,
+		Period: &v1.SchedulePeriod{
+			StartTime: "09:00",
+			EndTime:   "10:00",
+			Days:      []v1.ScheduleDay{v1.MondaySchedule, v1.TuesdaySchedule, v1.WednesdaySchedule, v1.ThursdaySchedule, v1.FridaySchedule},
+			Timezone:  "UTC",
+		},
+		Value: 10,
+	}
+
+	for _, tc := range []struct {
+		msg       string
+		schedules []v1.Schedule
+		expected  []ScheduleConflict
+	}{
+		{
+			msg: "overlapping schedules",
+			schedules: []v1.Schedule{
+				oneTimeSchedule("2024-01-02T10:00:00Z", 60, 100),
+				oneTimeSchedule("2024-01-02T10:50:00Z", 30, 5000),
+			},
+			expected: []ScheduleConflict{{
+				First: 0, Second: 1, FirstValue: 100, SecondValue: 5000,
+				Start: time.Date(2024, 1, 2, 10, 50, 0, 0, time.UTC),
+				End:   time.Date(2024, 1, 2, 11, 0, 0, 0, time.UTC),
+			}},
+		},
+		{
+			msg: "adjacent schedules don't overlap",
+			schedules: []v1.Schedule{
+				oneTimeSchedule("2024-01-02T10:00:00Z", 60, 100),
+				oneTimeSchedule("2024-01-02T11:00:00Z", 30, 5000),
+			},
+		},
+		{
+			msg: "values just below the factor",
+			schedules: []v1.Schedule{
+				oneTimeSchedule("2024-01-02T10:00:00Z", 60, 100),
+				oneTimeSchedule("2024-01-02T10:50:00Z", 30, 500),
+			},
+		},
+		{
+			msg: "values just above the factor",
+			schedules: []v1.Schedule{
+				oneTimeSchedule("2024-01-02T10:50:00Z", 30, 501),
+				oneTimeSchedule("2024-01-02T10:00:00Z", 60, 100),
+			},
+			expected: []ScheduleConflict{{
+				First: 0, Second: 1, FirstValue: 501, SecondValue: 100,
+				Start: time.Date(2024, 1, 2, 10, 50, 0, 0, time.UTC),
+				End:   time.Date(2024, 1, 2, 11, 0, 0, 0, time.UTC),
+			}},
+		},
+		{
+			msg: "overlap beyond the horizon",
+			schedules: []v1.Schedule{
+				oneTimeSchedule("2024-01-10T10:00:00Z", 60, 100),
+				oneTimeSchedule("2024-01-10T10:50:00Z", 30, 5000),
+			},
+		},
+		{
+			msg: "one-time schedule overlapping a repeating schedule",
+			schedules: []v1.Schedule{
+				daily,
+				oneTimeSchedule("2024-01-03T09:30:00Z", 60, 1000),
+			},
+			expected: []ScheduleConflict{{
+				First: 0, Second: 1, FirstValue: 10, SecondValue: 1000,
+				Start: time.Date(2024, 1, 3, 9, 30, 0, 0, time.UTC),
+				End:   time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC),
+			}},
+		},
+		{
+			msg: "day values of repeating schedules",
+			schedules: []v1.Schedule{
+				daily,
+				func() v1.Schedule {
+					schedule := *daily.DeepCopy()
+					schedule.Value = 20
+					schedule.DayValues = map[v1.ScheduleDay]int64{v1.ThursdaySchedule: 200}
+					return schedule
+				}(),
+			},
+			expected: []ScheduleConflict{{
+				First: 0, Second: 1, FirstValue: 10, SecondValue: 200,
+				Start: time.Date(2024, 1, 4, 9, 0, 0, 0, time.UTC),
+				End:   time.Date(2024, 1, 4, 10, 0, 0, 0, time.UTC),
+			}},
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			conflicts := FindScheduleConflicts(now, scheduleConflictHorizon, tc.schedules, DefaultScheduleConflictFactor, "Europe/Berlin")
+			require.Equal(t, tc.expected, conflicts)
+		})
+	}
+}
+
+func TestScheduleConflictsCondition(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		msg       string
+		schedules []v1.Schedule
+		conflicts bool
+	}{
+		{
+			msg: "overlapping schedules",
+			schedules: []v1.Schedule{
+				oneTimeSchedule("2024-01-02T10:00:00Z", 60, 100),
+				oneTimeSchedule("2024-01-02T10:50:00Z", 30, 5000),
+			},
+			conflicts: true,
+		},
+		{
+			msg: "non-overlapping schedules",
+			schedules: []v1.Schedule{
+				oneTimeSchedule("2024-01-02T10:00:00Z", 60, 100),
+				oneTimeSchedule("2024-01-03T10:00:00Z", 60, 5000),
+			},
+		},
+		{
+			msg: "values just below the factor",
+			schedules: []v1.Schedule{
+				oneTimeSchedule("2024-01-02T10:00:00Z", 60, 100),
+				oneTimeSchedule("2024-01-02T10:50:00Z", 30, 500),
+			},
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			client := zfake.NewSimpleClientset()
+			kubeClient := fake.NewSimpleClientset()
+			controller := NewController(client.ZalandoV1(), kubeClient, &mockScaler{client: kubeClient}, nil, nil, func() time.Time { return now }, time.Hour, "Europe/Berlin", 0.10)
+			recorder := record.NewFakeRecorder(10)
+			controller.recorder = recorder
+
+			schedule := &v1.ScalingSchedule{
+				ObjectMeta: metav1.ObjectMeta{Name: "schedule-1", Namespace: "default", Generation: 1},
+				Spec:       v1.ScalingScheduleSpec{Schedules: tc.schedules},
+			}
+			_, err := client.ZalandoV1().ScalingSchedules("default").Create(context.Background(), schedule, metav1.CreateOptions{})
+			require.NoError(t, err)
+
+			get := func() *v1.ScalingSchedule {
+				current, err := client.ZalandoV1().ScalingSchedules("default").Get(context.Background(), "schedule-1", metav1.GetOptions{})
+				require.NoError(t, err)
+				return current
+			}
+
+			// the event must only be emitted when the condition is
+			// set.
+			for i := 0; i < 3; i++ {
+				err = controller.updateStatus(context.Background(), []*v1.ScalingSchedule{get()}, nil)
+				require.NoError(t, err)
+			}
+
+			condition := meta.FindStatusCondition(get().Status.Conditions, v1.ScalingScheduleConflictsCondition)
+			if !tc.conflicts {
+				require.Nil(t, condition)
+				require.Empty(t, recorder.Events)
+				return
+			}
+
+			require.NotNil(t, condition)
+			require.Equal(t, metav1.ConditionTrue, condition.Status)
+			require.Equal(t, "schedules 0 and 1 with values 100 and 5000 overlap from 2024-01-02T10:50:00Z to 2024-01-02T11:00:00Z", condition.Message)
+			require.Len(t, recorder.Events, 1)
+			require.Contains(t, <-recorder.Events, "ScheduleConflicts")
+
+			// resolving the conflict removes the condition.
+			current := get()
+			current.Spec.Schedules[1].Value = 200
+			_, err = client.ZalandoV1().ScalingSchedules("default").Update(context.Background(), current, metav1.UpdateOptions{})
+			require.NoError(t, err)
+			err = controller.updateStatus(context.Background(), []*v1.ScalingSchedule{get()}, nil)
+			require.NoError(t, err)
+			require.Nil(t, meta.FindStatusCondition(get().Status.Conditions, v1.ScalingScheduleConflictsCondition))
+		})
+	}
+}
@@ -1,13 +1,17 @@
 package scheduledscaling
 
 import (
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"golang.org/x/net/context"
 	autoscalingv1 "k8s.io/api/autoscaling/v1"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/validation/path"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -20,6 +24,10 @@ import (
 	scaleclient "k8s.io/client-go/scale"
 )
 
+// ErrScaleTargetRejected is returned by the TargetScaler for HPAs whose scale
+// target fails the safety checks.
+var ErrScaleTargetRejected = errors.New("scale target rejected")
+
 // TargetScaler is an interface for scaling a target referenced resource in an
 // HPA to the desired replicas.
 type TargetScaler interface {
@@ -54,13 +62,21 @@ func NewHPATargetScaler(ctx context.Context, kubeClient kubernetes.Interface, cf
 }
 
 // Scale scales the target resource of the given HPA to the desired number of
-// replicas.
+// replicas. The scale target is rejected with ErrScaleTargetRejected unless
+// the HPA has a ScalingSchedule or ClusterScalingSchedule metric, the name of
+// the target is a valid object name and the target resolves to the kind and
+// API version of the reference in the namespace of the HPA.
 func (s *hpaTargetScaler) Scale(ctx context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, replicas int32) error {
 	reference := fmt.Sprintf("%s/%s/%s", hpa.Spec.ScaleTargetRef.Kind, hpa.Namespace, hpa.Spec.ScaleTargetRef.Name)
 
+	err := validateScaleTarget(hpa)
+	if err != nil {
+		return err
+	}
+
 	targetGV, err := schema.ParseGroupVersion(hpa.Spec.ScaleTargetRef.APIVersion)
 	if err != nil {
-		return fmt.Errorf("invalid API version '%s' in scale target reference: %w", hpa.Spec.ScaleTargetRef.APIVersion, err)
+		return fmt.Errorf("%w: invalid API version '%s' in scale target reference: %w", ErrScaleTargetRejected, hpa.Spec.ScaleTargetRef.APIVersion, err)
 	}
 
 	targetGK := schema.GroupKind{
@@ -68,16 +84,31 @@ func (s *hpaTargetScaler) Scale(ctx context.Context, hpa *autoscalingv2.Horizont
 		Kind:  hpa.Spec.ScaleTargetRef.Kind,
 	}
 
-	mappings, err := s.mapper.RESTMappings(targetGK)
+	mappings, err := s.mapper.RESTMappings(targetGK, targetGV.Version)
+	if apimeta.IsNoMatchError(err) {
+		return fmt.Errorf("%w: %s is not served in API version %s", ErrScaleTargetRejected, targetGK.Kind, targetGV)
+	}
 	if err != nil {
 		return fmt.Errorf("unable to determine resource for scale target reference: %w", err)
 	}
 
+	// only the exact kind and API version of the reference are scaled.
+	mappings = slices.DeleteFunc(mappings, func(mapping *apimeta.RESTMapping) bool {
+		return mapping.GroupVersionKind.GroupVersion() != targetGV || mapping.GroupVersionKind.Kind != targetGK.Kind
+	})
+	if len(mappings) == 0 {
+		return fmt.Errorf("%w: %s is not served in API version %s", ErrScaleTargetRejected, targetGK.Kind, targetGV)
+	}
+
 	scale, targetGR, err := s.scaleForResourceMappings(ctx, hpa.Namespace, hpa.Spec.ScaleTargetRef.Name, mappings)
 	if err != nil {
 		return fmt.Errorf("failed to get scale subresource for %s: %w", reference, err)
 	}
 
+	if scale.Namespace != hpa.Namespace || scale.Name != hpa.Spec.ScaleTargetRef.Name {
+		return fmt.Errorf("%w: scale subresource of %s resolved to %s/%s", ErrScaleTargetRejected, reference, scale.Namespace, scale.Name)
+	}
+
 	scale.Spec.Replicas = replicas
 	_, err = s.scaleClient.Scales(hpa.Namespace).Update(ctx, targetGR, scale, metav1.UpdateOptions{})
 	if err != nil {
@@ -87,6 +118,30 @@ func (s *hpaTargetScaler) Scale(ctx context.Context, hpa *autoscalingv2.Horizont
 	return nil
 }
 
+// validateScaleTarget checks that the HPA is scaled by scaling schedules and
+// that the name of its scale target can't address an object in another
+// namespace or another resource.
+func validateScaleTarget(hpa *autoscalingv2.HorizontalPodAutoscaler) error {
+	name := hpa.Spec.ScaleTargetRef.Name
+	if name == "" {
+		return fmt.Errorf("%w: scale target name is empty", ErrScaleTargetRejected)
+	}
+	if errs := path.IsValidPathSegmentName(name); len(errs) > 0 {
+		return fmt.Errorf("%w: invalid scale target name '%s': %s", ErrScaleTargetRejected, name, strings.Join(errs, ", "))
+	}
+
+	for _, metric := range hpa.Spec.Metrics {
+		if metric.Type != autoscalingv2.ObjectMetricSourceType || metric.Object == nil {
+			continue
+		}
+		switch metric.Object.DescribedObject.Kind {
+		case "ScalingSchedule", "ClusterScalingSchedule":
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: HPA has no ScalingSchedule or ClusterScalingSchedule metric", ErrScaleTargetRejected)
+}
+
 // scaleForResourceMappings attempts to fetch the scale for the
 // resource with the given name and namespace, trying each RESTMapping
 // in turn until a working one is found.  If none work, the first error
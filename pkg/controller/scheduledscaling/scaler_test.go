@@ -0,0 +1,142 @@
+package scheduledscaling
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	scalefake "k8s.io/client-go/scale/fake"
+	core "k8s.io/client-go/testing"
+)
+
+func newScalerTestHPA(apiVersion, name string, metrics ...autoscalingv2.MetricSpec) *autoscalingv2.HorizontalPodAutoscaler {
+	return &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "hpa",
+			Namespace: "default",
+		},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
+				APIVersion: apiVersion,
+				Kind:       "Deployment",
+				Name:       name,
+			},
+			Metrics: metrics,
+		},
+	}
+}
+
+func TestHPATargetScalerScale(t *testing.T) {
+	scheduleMetric := autoscalingv2.MetricSpec{
+		Type: autoscalingv2.ObjectMetricSourceType,
+		Object: &autoscalingv2.ObjectMetricSource{
+			DescribedObject: autoscalingv2.CrossVersionObjectReference{
+				APIVersion: "zalando.org/v1",
+				Kind:       "ClusterScalingSchedule",
+				Name:       "schedule",
+			},
+		},
+	}
+	cpuMetric := autoscalingv2.MetricSpec{
+		Type: autoscalingv2.ResourceMetricSourceType,
+		Resource: &autoscalingv2.ResourceMetricSource{
+			Name: "cpu",
+		},
+	}
+
+	for _, tc := range []struct {
+		msg            string
+		hpa            *autoscalingv2.HorizontalPodAutoscaler
+		scaleNamespace string
+		rejected       bool
+	}{
+		{
+			msg:            "scale target of a scaling schedule HPA is scaled",
+			hpa:            newScalerTestHPA("apps/v1", "app", cpuMetric, scheduleMetric),
+			scaleNamespace: "default",
+		},
+		{
+			msg:            "HPA without a scaling schedule metric is rejected",
+			hpa:            newScalerTestHPA("apps/v1", "app", cpuMetric),
+			scaleNamespace: "default",
+			rejected:       true,
+		},
+		{
+			msg:            "empty scale target name is rejected",
+			hpa:            newScalerTestHPA("apps/v1", "", scheduleMetric),
+			scaleNamespace: "default",
+			rejected:       true,
+		},
+		{
+			msg:            "scale target name with a path separator is rejected",
+			hpa:            newScalerTestHPA("apps/v1", "other/app", scheduleMetric),
+			scaleNamespace: "default",
+			rejected:       true,
+		},
+		{
+			msg:            "scale target in an API version which isn't served is rejected",
+			hpa:            newScalerTestHPA("apps/v2", "app", scheduleMetric),
+			scaleNamespace: "default",
+			rejected:       true,
+		},
+		{
+			msg:            "invalid API version is rejected",
+			hpa:            newScalerTestHPA("apps/v1/v2", "app", scheduleMetric),
+			scaleNamespace: "default",
+			rejected:       true,
+		},
+		{
+			msg:            "scale subresource of another namespace is rejected",
+			hpa:            newScalerTestHPA("apps/v1", "app", scheduleMetric),
+			scaleNamespace: "other",
+			rejected:       true,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			mapper := apimeta.NewDefaultRESTMapper([]schema.GroupVersion{{Group: "apps", Version: "v1"}})
+			mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, apimeta.RESTScopeNamespace)
+
+			scaleClient := &scalefake.FakeScaleClient{}
+			scaleClient.AddReactor("get", "deployments", func(action core.Action) (bool, runtime.Object, error) {
+				get := action.(core.GetAction)
+				return true, &autoscalingv1.Scale{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      get.GetName(),
+						Namespace: tc.scaleNamespace,
+					},
+					Spec: autoscalingv1.ScaleSpec{Replicas: 1},
+				}, nil
+			})
+
+			var updated *autoscalingv1.Scale
+			scaleClient.AddReactor("update", "deployments", func(action core.Action) (bool, runtime.Object, error) {
+				updated = action.(core.UpdateAction).GetObject().(*autoscalingv1.Scale)
+				return true, updated, nil
+			})
+
+			scaler := &hpaTargetScaler{
+				mapper:      mapper,
+				scaleClient: scaleClient,
+			}
+
+			err := scaler.Scale(context.Background(), tc.hpa, 5)
+			if tc.rejected {
+				require.Error(t, err)
+				require.True(t, errors.Is(err, ErrScaleTargetRejected), "unexpected error: %v", err)
+				require.Nil(t, updated)
+				return
+			}
+
+			require.NoError(t, err)
+			require.NotNil(t, updated)
+			require.Equal(t, int32(5), updated.Spec.Replicas)
+		})
+	}
+}
@@ -4,9 +4,17 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	log "github.com/sirupsen/logrus"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/annotations"
 	v1 "github.com/zalando-incubator/kube-metrics-adapter/pkg/apis/zalando.org/v1"
 	zalandov1 "github.com/zalando-incubator/kube-metrics-adapter/pkg/client/clientset/versioned/typed/zalando.org/v1"
 	"github.com/zalando-incubator/kube-metrics-adapter/pkg/recorder"
@@ -14,7 +22,9 @@ import (
 	"golang.org/x/sync/errgroup"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	kube_record "k8s.io/client-go/tools/record"
 )
@@ -23,6 +33,27 @@ const (
 	// The format used by v1.SchedulePeriod.StartTime. 15:04 are
 	// the defined reference time in time.Format.
 	hourColonMinuteLayout = "15:04"
+
+	// ScheduleNamesConfigKey is the metric config key restricting the
+	// schedules of a [Cluster]ScalingSchedule considered for a metric to
+	// a comma separated list of schedule names.
+	ScheduleNamesConfigKey = "schedule-names"
+
+	// SkipPreScalingAnnotation is the HPA annotation excluding the HPA
+	// from pre-scaling by the controller when set to "true". The
+	// scheduled metrics are still served for the HPA.
+	SkipPreScalingAnnotation = "zalando.org/skip-schedule-prescaling"
+
+	// DefaultMaxRepeatingScheduleDuration is the default maximum
+	// duration of the window of a Repeating schedule.
+	DefaultMaxRepeatingScheduleDuration = 24 * time.Hour
+	// DefaultMaxOneTimeScheduleDuration is the default maximum duration
+	// of the window of a OneTime schedule.
+	DefaultMaxOneTimeScheduleDuration = 7 * 24 * time.Hour
+
+	// DefaultMaxScaleUpFactor is the default maximum factor of the
+	// current replicas a target is pre-scaled to.
+	DefaultMaxScaleUpFactor = 10.0
 )
 
 var days = map[v1.ScheduleDay]time.Weekday{
@@ -35,6 +66,16 @@ var days = map[v1.ScheduleDay]time.Weekday{
 	v1.SaturdaySchedule:  time.Saturday,
 }
 
+var weekdays = map[time.Weekday]v1.ScheduleDay{
+	time.Sunday:    v1.SundaySchedule,
+	time.Monday:    v1.MondaySchedule,
+	time.Tuesday:   v1.TuesdaySchedule,
+	time.Wednesday: v1.WednesdaySchedule,
+	time.Thursday:  v1.ThursdaySchedule,
+	time.Friday:    v1.FridaySchedule,
+	time.Saturday:  v1.SaturdaySchedule,
+}
+
 var (
 	// ErrNotScalingScheduleFound is returned when a item returned from
 	// the ScalingScheduleCollectorPlugin.store was expected to
@@ -49,6 +90,46 @@ var (
 	// hourColonMinuteLayout. It shouldn't happen since the validation
 	// is done by the CRD.
 	ErrInvalidScheduleStartTime = errors.New("could not parse the specified schedule period start time, format is not HH:MM")
+	// ErrInvalidScheduleDayValues is returned when day values are
+	// defined for days which are not part of the schedule period.
+	ErrInvalidScheduleDayValues = errors.New("day values can only be defined for the days of a Repeating schedule period")
+	// ErrUnknownScheduleNames is returned when the schedule names
+	// configured for a metric are not defined in the referenced
+	// [Cluster]ScalingSchedule.
+	ErrUnknownScheduleNames = errors.New("schedule names not found")
+	// ErrInvertedSchedule is returned when a schedule ends before it
+	// starts. The window of such a schedule falls back to its duration,
+	// OneTime schedules without a duration are rejected.
+	ErrInvertedSchedule = errors.New("schedule ends before it starts")
+	// ErrInconsistentScheduleDuration is returned when both the end date
+	// and the duration of a OneTime schedule are defined but don't match.
+	// The longer window is used.
+	ErrInconsistentScheduleDuration = errors.New("schedule end date and duration don't match")
+	// ErrScheduleTooLong is returned when the window of a schedule
+	// exceeds the maximum duration.
+	ErrScheduleTooLong = errors.New("schedule window exceeds the maximum duration")
+
+	// ErrMissingSchedulePeriod is returned when a Repeating schedule has
+	// no period.
+	ErrMissingSchedulePeriod = errors.New("repeating schedule without period")
+	// ErrMissingScheduleDate is returned when a OneTime schedule has no
+	// date.
+	ErrMissingScheduleDate = errors.New("one-time schedule without date")
+)
+
+var (
+	// MisconfiguredHPAs is the number of HPAs referencing an active
+	// scaling schedule without a usable target AverageValue.
+	MisconfiguredHPAs = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "kube_metrics_adapter_scheduled_scaling_misconfigured_hpas",
+		Help: "The number of HPAs referencing an active scaling schedule without a valid target average value",
+	})
+	// ScheduleClock is the time, including the configured offset, the
+	// scaling schedules were last evaluated at.
+	ScheduleClock = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "kube_metrics_adapter_schedule_clock_seconds",
+		Help: "The time in seconds since the epoch, including the time offset, used to evaluate scaling schedules",
+	})
 )
 
 // Now is the function that returns a time.Time object representing the
@@ -56,6 +137,18 @@ var (
 // std lib. It's used mainly for test/mock purposes.
 type now func() time.Time
 
+// OffsetNow returns the function providing the current time for the
+// evaluation of scaling schedules. The offset is added to the local clock to
+// compensate a known clock skew relative to the rest of the platform. The
+// returned time is exposed by the ScheduleClock metric.
+func OffsetNow(offset time.Duration) func() time.Time {
+	return func() time.Time {
+		now := time.Now().Add(offset)
+		ScheduleClock.Set(float64(now.UnixNano()) / float64(time.Second))
+		return now
+	}
+}
+
 type scalingScheduleStore interface {
 	List() []interface{}
 }
@@ -71,6 +164,30 @@ type Controller struct {
 	defaultScalingWindow        time.Duration
 	defaultTimeZone             string
 	hpaTolerance                float64
+	// misconfiguredHPAs maps HPAs skipped due to an invalid target to
+	// the description of the skipped metrics last reported for them.
+	misconfiguredHPAs    map[string]string
+	misconfiguredHPAsMtx sync.Mutex
+	pauseAnnotation      string
+	// maxScheduleDuration is the maximum duration of a schedule window,
+	// zero selects the defaults of the schedule types.
+	maxScheduleDuration time.Duration
+	// maxScalesPerRun is the maximum number of scale operations per
+	// controller loop, zero means unlimited.
+	maxScalesPerRun int
+	// conflictFactor is the factor by which the values of overlapping
+	// schedules have to differ to be reported as conflict, zero disables
+	// the check.
+	conflictFactor float64
+	// maxScaleUpFactor is the maximum factor of the current replicas a
+	// target is pre-scaled to, zero disables the guard.
+	maxScaleUpFactor float64
+	// replicaCeiling is the maximum number of replicas a target is
+	// pre-scaled to, zero disables the ceiling.
+	replicaCeiling int64
+	// valueMetrics are the schedules whose value metrics were exported
+	// by the last loop, nil if the metrics are disabled.
+	valueMetrics map[scheduleKey]struct{}
 }
 
 func NewController(zclient zalandov1.ZalandoV1Interface, kubeClient kubernetes.Interface, scaler TargetScaler, scalingScheduleStore, clusterScalingScheduleStore scalingScheduleStore, now now, defaultScalingWindow time.Duration, defaultTimeZone string, hpaThreshold float64) *Controller {
@@ -85,9 +202,61 @@ func NewController(zclient zalandov1.ZalandoV1Interface, kubeClient kubernetes.I
 		defaultScalingWindow:        defaultScalingWindow,
 		defaultTimeZone:             defaultTimeZone,
 		hpaTolerance:                hpaThreshold,
+		misconfiguredHPAs:           make(map[string]string),
+		pauseAnnotation:             annotations.DefaultPauseAnnotation,
+		conflictFactor:              DefaultScheduleConflictFactor,
+		maxScaleUpFactor:            DefaultMaxScaleUpFactor,
 	}
 }
 
+// SetPauseAnnotation sets the annotation pausing the scheduled scaling of
+// an HPA when set to "true". An empty annotation disables pausing.
+func (c *Controller) SetPauseAnnotation(annotation string) {
+	c.pauseAnnotation = annotation
+}
+
+// SetMaxScheduleDuration sets the maximum duration of a schedule window.
+// Schedules exceeding it are reported as invalid. Zero selects the defaults
+// of the schedule types, see MaxScheduleDuration.
+func (c *Controller) SetMaxScheduleDuration(maxDuration time.Duration) {
+	c.maxScheduleDuration = maxDuration
+}
+
+// SetMaxScalesPerRun bounds the number of scale operations of a single
+// controller loop to limit the blast radius of a bad schedule. HPAs exceeding
+// the limit are adjusted in one of the next loops. Zero disables the limit.
+func (c *Controller) SetMaxScalesPerRun(maxScales int) {
+	c.maxScalesPerRun = maxScales
+}
+
+// SetConflictFactor sets the factor by which the values of schedules
+// overlapping within the next 7 days have to differ to be reported by the
+// Conflicts condition. Zero disables the check.
+func (c *Controller) SetConflictFactor(factor float64) {
+	c.conflictFactor = factor
+}
+
+// SetMaxScaleUpFactor sets the maximum factor of the current replicas of
+// a target it's pre-scaled to. Higher values expected by the schedules, e.g.
+// because of a typo, are clamped and reported by an event. Zero disables
+// the guard.
+func (c *Controller) SetMaxScaleUpFactor(factor float64) {
+	c.maxScaleUpFactor = factor
+}
+
+// SetReplicaCeiling sets the maximum number of replicas of any target it's
+// pre-scaled to, independent of the max replicas of its HPA. Zero disables
+// the ceiling.
+func (c *Controller) SetReplicaCeiling(replicas int64) {
+	c.replicaCeiling = replicas
+}
+
+// EnableEventDeduplication records identical events at most once per
+// window.
+func (c *Controller) EnableEventDeduplication(window time.Duration) {
+	c.recorder = recorder.NewDeduplicatingRecorder(c.recorder, window)
+}
+
 func (c *Controller) Run(ctx context.Context) {
 	log.Info("Running Scaling Schedule Controller")
 
@@ -116,15 +285,25 @@ func (c *Controller) updateStatus(ctx context.Context, schedules []*v1.ScalingSc
 		schedule = schedule.DeepCopy()
 
 		scalingGroup.Go(func() error {
+			// the Valid condition is updated even if the schedules
+			// can't be evaluated, so the reason is visible on the
+			// resource.
+			active := schedule.Status.Active
 			activeSchedules, err := c.activeSchedules(schedule.Spec)
 			if err != nil {
 				log.Errorf("Failed to check for active schedules in ScalingSchedule %s/%s: %v", schedule.Namespace, schedule.Name, err)
-				return nil
+			} else {
+				active = len(activeSchedules) > 0
 			}
 
-			active := len(activeSchedules) > 0
+			schedule.TypeMeta = metav1.TypeMeta{APIVersion: v1.SchemeGroupVersion.String(), Kind: "ScalingSchedule"}
+			conditionChanged := c.updateValidCondition(schedule, &schedule.Status, schedule.Spec, schedule.Generation)
+			if c.updateConflictsCondition(schedule, &schedule.Status, schedule.Spec, schedule.Generation) {
+				conditionChanged = true
+			}
 
-			if active != schedule.Status.Active {
+			activeChanged := active != schedule.Status.Active
+			if activeChanged || conditionChanged {
 				schedule.Status.Active = active
 				_, err := c.client.ScalingSchedules(schedule.Namespace).UpdateStatus(ctx, schedule, metav1.UpdateOptions{})
 				if err != nil {
@@ -132,12 +311,14 @@ func (c *Controller) updateStatus(ctx context.Context, schedules []*v1.ScalingSc
 					return nil
 				}
 
-				status := "inactive"
-				if active {
-					status = "active"
-				}
+				if activeChanged {
+					status := "inactive"
+					if active {
+						status = "active"
+					}
 
-				log.Infof("Marked Scaling Schedule %s/%s as %s", schedule.Namespace, schedule.Name, status)
+					log.Infof("Marked Scaling Schedule %s/%s as %s", schedule.Namespace, schedule.Name, status)
+				}
 			}
 			return nil
 		})
@@ -156,15 +337,25 @@ func (c *Controller) updateStatus(ctx context.Context, schedules []*v1.ScalingSc
 		schedule = schedule.DeepCopy()
 
 		clusterScalingGroup.Go(func() error {
+			// the Valid condition is updated even if the schedules
+			// can't be evaluated, so the reason is visible on the
+			// resource.
+			active := schedule.Status.Active
 			activeSchedules, err := c.activeSchedules(schedule.Spec)
 			if err != nil {
 				log.Errorf("Failed to check for active schedules in ClusterScalingSchedule %s: %v", schedule.Name, err)
-				return nil
+			} else {
+				active = len(activeSchedules) > 0
 			}
 
-			active := len(activeSchedules) > 0
+			schedule.TypeMeta = metav1.TypeMeta{APIVersion: v1.SchemeGroupVersion.String(), Kind: "ClusterScalingSchedule"}
+			conditionChanged := c.updateValidCondition(schedule, &schedule.Status, schedule.Spec, schedule.Generation)
+			if c.updateConflictsCondition(schedule, &schedule.Status, schedule.Spec, schedule.Generation) {
+				conditionChanged = true
+			}
 
-			if active != schedule.Status.Active {
+			activeChanged := active != schedule.Status.Active
+			if activeChanged || conditionChanged {
 				schedule.Status.Active = active
 				_, err := c.client.ClusterScalingSchedules().UpdateStatus(ctx, schedule, metav1.UpdateOptions{})
 				if err != nil {
@@ -172,12 +363,14 @@ func (c *Controller) updateStatus(ctx context.Context, schedules []*v1.ScalingSc
 					return nil
 				}
 
-				status := "inactive"
-				if active {
-					status = "active"
-				}
+				if activeChanged {
+					status := "inactive"
+					if active {
+						status = "active"
+					}
 
-				log.Infof("Marked Cluster Scaling Schedule %s as %s", schedule.Name, status)
+					log.Infof("Marked Cluster Scaling Schedule %s as %s", schedule.Name, status)
+				}
 			}
 			return nil
 		})
@@ -191,6 +384,37 @@ func (c *Controller) updateStatus(ctx context.Context, schedules []*v1.ScalingSc
 	return nil
 }
 
+// updateValidCondition sets the Valid condition of the status from the
+// checks of the schedules. A warning event naming the schedule is recorded
+// for each invalid schedule when the condition changes, not on every loop.
+// It returns true if the condition changed.
+func (c *Controller) updateValidCondition(object runtime.Object, status *v1.ScalingScheduleStatus, spec v1.ScalingScheduleSpec, generation int64) bool {
+	problems := CheckSchedules(spec.Schedules, c.maxScheduleDuration)
+
+	condition := metav1.Condition{
+		Type:               v1.ScalingScheduleValidCondition,
+		Status:             metav1.ConditionTrue,
+		Reason:             "SchedulesValid",
+		Message:            "All schedules are valid",
+		ObservedGeneration: generation,
+		LastTransitionTime: metav1.NewTime(c.now()),
+	}
+	if len(problems) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "InvalidSchedule"
+		condition.Message = strings.Join(problems, "; ")
+	}
+
+	if !meta.SetStatusCondition(&status.Conditions, condition) {
+		return false
+	}
+
+	for _, problem := range problems {
+		c.recorder.Event(object, corev1.EventTypeWarning, "InvalidSchedule", problem)
+	}
+	return true
+}
+
 func (c *Controller) runOnce(ctx context.Context) error {
 	schedulesInterface := c.scalingScheduleStore.List()
 	namespacedSchedules := make([]*v1.ScalingSchedule, 0, len(schedulesInterface))
@@ -220,6 +444,8 @@ func (c *Controller) runOnce(ctx context.Context) error {
 		return fmt.Errorf("failed to update status: %w", err)
 	}
 
+	c.updateValueMetrics(schedules)
+
 	log.Info("Adjusting scaling")
 	err = c.adjustScaling(ctx, schedules)
 	if err != nil {
@@ -229,12 +455,24 @@ func (c *Controller) runOnce(ctx context.Context) error {
 	return nil
 }
 
-// activeScheduledScaling returns a map of the current active schedules and the
-// max value per schedule.
-func (c *Controller) activeScheduledScaling(schedules []v1.ScalingScheduler) map[string]int64 {
-	currentActiveSchedules := make(map[string]int64)
+// activeSchedule is the value of an active schedule of a
+// [Cluster]ScalingSchedule.
+type activeSchedule struct {
+	name  string
+	value int64
+}
+
+// activeScheduledScaling returns a map of the [Cluster]ScalingSchedules with
+// active schedules and the values of their active schedules.
+// [Cluster]ScalingSchedules with disabled pre-scaling are left out.
+func (c *Controller) activeScheduledScaling(schedules []v1.ScalingScheduler) map[string][]activeSchedule {
+	currentActiveSchedules := make(map[string][]activeSchedule)
 
 	for _, schedule := range schedules {
+		if schedule.ResourceSpec().DisablePreScaling {
+			continue
+		}
+
 		activeSchedules, err := c.activeSchedules(schedule.ResourceSpec())
 		if err != nil {
 			log.Errorf("Failed to check for active schedules in ScalingSchedule %s: %v", schedule.Identifier(), err)
@@ -245,30 +483,73 @@ func (c *Controller) activeScheduledScaling(schedules []v1.ScalingScheduler) map
 			continue
 		}
 
-		maxValue := int64(0)
-		for _, activeSchedule := range activeSchedules {
-			if activeSchedule.Value > maxValue {
-				maxValue = activeSchedule.Value
-			}
-		}
-		currentActiveSchedules[schedule.Identifier()] = maxValue
+		currentActiveSchedules[schedule.Identifier()] = activeSchedules
 	}
 
 	return currentActiveSchedules
 }
 
+// maxActiveValue returns the highest value of the active schedules
+// restricted to the given schedule names, if any.
+func maxActiveValue(activeSchedules []activeSchedule, names []string) (int64, bool) {
+	var maxValue int64
+	active := false
+	for _, schedule := range activeSchedules {
+		if len(names) > 0 && !slices.Contains(names, schedule.name) {
+			continue
+		}
+		active = true
+		if schedule.value > maxValue {
+			maxValue = schedule.value
+		}
+	}
+	return maxValue, active
+}
+
+// scaleBudget bounds the number of scale operations of a controller loop.
+type scaleBudget struct {
+	remaining atomic.Int64
+	limited   bool
+}
+
+func newScaleBudget(maxScales int) *scaleBudget {
+	budget := &scaleBudget{limited: maxScales > 0}
+	budget.remaining.Store(int64(maxScales))
+	return budget
+}
+
+// take takes a scale operation from the budget. It returns false if the
+// budget is exhausted.
+func (b *scaleBudget) take() bool {
+	return !b.limited || b.remaining.Add(-1) >= 0
+}
+
 // adjustHPAScaling adjusts the scaling for a single HPA based on the active
-// scaling schedules. An adjustment is made if the current HPA scale is below
-// the desired and the change is within the HPA tolerance.
-func (c *Controller) adjustHPAScaling(ctx context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, activeSchedules map[string]int64) error {
+// scaling schedules. The desired scale is clamped to the max replicas of the
+// HPA, the max scale-up factor and the replica ceiling. An adjustment is
+// made if the current HPA scale is below the desired and the change is
+// within the HPA tolerance. HPAs excluded
+// from pre-scaling by the SkipPreScalingAnnotation are left untouched.
+// Scale targets rejected by the scaler are reported as events. No
+// adjustment is made once the budget of the loop is exhausted.
+func (c *Controller) adjustHPAScaling(ctx context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, activeSchedules map[string][]activeSchedule, budget *scaleBudget) error {
+	if hpa.Annotations[SkipPreScalingAnnotation] == "true" {
+		// no events are emitted for excluded HPAs, so a previously
+		// reported misconfiguration is cleared silently.
+		c.reportMisconfiguredHPA(hpa, nil)
+		return nil
+	}
+
+	highestExpected, highestObject, misconfigured := highestActiveSchedule(hpa, activeSchedules)
+	c.reportMisconfiguredHPA(hpa, misconfigured)
+
 	current := int64(hpa.Status.CurrentReplicas)
 	if current == 0 {
 		return nil
 	}
 
-	highestExpected, highestObject := highestActiveSchedule(hpa, activeSchedules)
-
 	highestExpected = int64(math.Min(float64(highestExpected), float64(hpa.Spec.MaxReplicas)))
+	highestExpected = c.clampScaleUp(hpa, current, highestExpected)
 
 	var change float64
 	if highestExpected > current {
@@ -276,9 +557,18 @@ func (c *Controller) adjustHPAScaling(ctx context.Context, hpa *autoscalingv2.Ho
 	}
 
 	if change > 0 && change <= c.hpaTolerance {
+		reference := fmt.Sprintf("%s/%s/%s", hpa.Spec.ScaleTargetRef.Kind, hpa.Namespace, hpa.Spec.ScaleTargetRef.Name)
+		if !budget.take() {
+			log.Warnf("Deferring scaling of target %s for HPA %s/%s: the limit of %d scale operations per run is reached", reference, hpa.Namespace, hpa.Name, c.maxScalesPerRun)
+			return nil
+		}
+
 		err := c.scaler.Scale(ctx, hpa, int32(highestExpected))
 		if err != nil {
-			reference := fmt.Sprintf("%s/%s/%s", hpa.Spec.ScaleTargetRef.Kind, hpa.Namespace, hpa.Spec.ScaleTargetRef.Name)
+			if errors.Is(err, ErrScaleTargetRejected) {
+				c.recorder.Eventf(hpa, corev1.EventTypeWarning, "ScalingRejected", "Scaling schedule adjustment of %s to %d replicas rejected: %v", reference, highestExpected, err)
+				return nil
+			}
 			log.Errorf("Failed to scale target %s for HPA %s/%s: %v", reference, hpa.Namespace, hpa.Name, err)
 			return nil
 		}
@@ -302,41 +592,78 @@ func (c *Controller) adjustHPAScaling(ctx context.Context, hpa *autoscalingv2.Ho
 	return nil
 }
 
+// clampScaleUp clamps the replicas expected by the schedules to the max
+// scale-up factor of the current replicas and to the replica ceiling. A
+// warning event is recorded when the expected replicas are clamped, as it
+// likely indicates a misconfigured schedule value or target.
+func (c *Controller) clampScaleUp(hpa *autoscalingv2.HorizontalPodAutoscaler, current, expected int64) int64 {
+	clamped := expected
+	if limit := math.Ceil(float64(current) * c.maxScaleUpFactor); c.maxScaleUpFactor > 0 && float64(clamped) > limit {
+		clamped = int64(limit)
+	}
+	if c.replicaCeiling > 0 {
+		clamped = min(clamped, c.replicaCeiling)
+	}
+
+	if clamped < expected {
+		c.recorder.Eventf(hpa, corev1.EventTypeWarning, "ScalingClamped", "Scaling schedules expect %d replicas, clamped to %d (current replicas %d, max scale-up factor %g, replica ceiling %d)", expected, clamped, current, c.maxScaleUpFactor, c.replicaCeiling)
+	}
+	return clamped
+}
+
 // highestActiveSchedule returns the highest active schedule value and
-// corresponding object.
-func highestActiveSchedule(hpa *autoscalingv2.HorizontalPodAutoscaler, activeSchedules map[string]int64) (int64, autoscalingv2.CrossVersionObjectReference) {
+// corresponding object. Only the schedules named in the schedule-names
+// metric config are considered, if defined. Additionally it returns a
+// description of each active schedule metric skipped because of a missing
+// or zero target AverageValue.
+func highestActiveSchedule(hpa *autoscalingv2.HorizontalPodAutoscaler, activeSchedules map[string][]activeSchedule) (int64, autoscalingv2.CrossVersionObjectReference, []string) {
 	var highestExpected int64
 	var highestObject autoscalingv2.CrossVersionObjectReference
+	var misconfigured []string
+
+	// invalid annotations are reported by the HPA provider.
+	metricConfigs := annotations.AnnotationConfigMap{}
+	_ = metricConfigs.Parse(hpa.Annotations)
+
 	for _, metric := range hpa.Spec.Metrics {
 		if metric.Type != autoscalingv2.ObjectMetricSourceType {
 			continue
 		}
 
+		scheduleName := metric.Object.DescribedObject.Name
+
+		var scheduleRef string
 		switch metric.Object.DescribedObject.Kind {
-		case "ClusterScalingSchedule", "ScalingSchedule":
+		case "ScalingSchedule":
+			scheduleRef = hpa.Namespace + "/" + scheduleName
+		case "ClusterScalingSchedule":
+			scheduleRef = scheduleName
 		default:
 			continue
 		}
 
-		scheduleName := metric.Object.DescribedObject.Name
+		var names []string
+		if config, ok := metricConfigs[annotations.MetricConfigKey{Type: metric.Type, MetricName: metric.Object.Metric.Name}]; ok {
+			names = ParseScheduleNames(config.Configs[ScheduleNamesConfigKey])
+		}
+
+		value, active := maxActiveValue(activeSchedules[scheduleRef], names)
 
 		if metric.Object.Target.AverageValue == nil {
+			if active {
+				misconfigured = append(misconfigured, fmt.Sprintf("%s '%s' (metric '%s'): target averageValue is missing", metric.Object.DescribedObject.Kind, scheduleRef, metric.Object.Metric.Name))
+			}
 			continue
 		}
 
 		target := int64(metric.Object.Target.AverageValue.MilliValue() / 1000)
 		if target == 0 {
+			if active {
+				misconfigured = append(misconfigured, fmt.Sprintf("%s '%s' (metric '%s'): target averageValue is zero", metric.Object.DescribedObject.Kind, scheduleRef, metric.Object.Metric.Name))
+			}
 			continue
 		}
 
-		var value int64
-		switch metric.Object.DescribedObject.Kind {
-		case "ScalingSchedule":
-			value = activeSchedules[hpa.Namespace+"/"+scheduleName]
-		case "ClusterScalingSchedule":
-			value = activeSchedules[scheduleName]
-		}
-
 		expected := int64(math.Ceil(float64(value) / float64(target)))
 		if expected > highestExpected {
 			highestExpected = expected
@@ -344,7 +671,57 @@ func highestActiveSchedule(hpa *autoscalingv2.HorizontalPodAutoscaler, activeSch
 		}
 	}
 
-	return highestExpected, highestObject
+	return highestExpected, highestObject, misconfigured
+}
+
+// reportMisconfiguredHPA emits a warning event for an HPA whose active
+// scaling schedule metrics were skipped. The event is only emitted when the
+// set of skipped metrics changes, not on every loop.
+func (c *Controller) reportMisconfiguredHPA(hpa *autoscalingv2.HorizontalPodAutoscaler, misconfigured []string) {
+	key := hpa.Namespace + "/" + hpa.Name
+	sort.Strings(misconfigured)
+	description := strings.Join(misconfigured, "; ")
+
+	c.misconfiguredHPAsMtx.Lock()
+	defer c.misconfiguredHPAsMtx.Unlock()
+
+	if description == "" {
+		delete(c.misconfiguredHPAs, key)
+		return
+	}
+
+	if c.misconfiguredHPAs[key] == description {
+		return
+	}
+	c.misconfiguredHPAs[key] = description
+
+	c.recorder.Eventf(
+		hpa,
+		corev1.EventTypeWarning,
+		"ScalingScheduleTargetInvalid",
+		"Skipped active scaling schedule: %s",
+		description,
+	)
+}
+
+// pruneMisconfiguredHPAs forgets HPAs which no longer exist and updates the
+// misconfigured HPAs gauge.
+func (c *Controller) pruneMisconfiguredHPAs(hpas []autoscalingv2.HorizontalPodAutoscaler) {
+	existing := make(map[string]struct{}, len(hpas))
+	for _, hpa := range hpas {
+		existing[hpa.Namespace+"/"+hpa.Name] = struct{}{}
+	}
+
+	c.misconfiguredHPAsMtx.Lock()
+	defer c.misconfiguredHPAsMtx.Unlock()
+
+	for key := range c.misconfiguredHPAs {
+		if _, ok := existing[key]; !ok {
+			delete(c.misconfiguredHPAs, key)
+		}
+	}
+
+	MisconfiguredHPAs.Set(float64(len(c.misconfiguredHPAs)))
 }
 
 func (c *Controller) adjustScaling(ctx context.Context, schedules []v1.ScalingScheduler) error {
@@ -358,11 +735,19 @@ func (c *Controller) adjustScaling(ctx context.Context, schedules []v1.ScalingSc
 	var hpaGroup errgroup.Group
 	hpaGroup.SetLimit(10)
 
+	budget := newScaleBudget(c.maxScalesPerRun)
+
 	for _, hpa := range hpas.Items {
+		// don't resurrect replicas of HPAs being torn down or
+		// paused by deployment tooling.
+		if hpa.DeletionTimestamp != nil || annotations.IsPaused(hpa.Annotations, c.pauseAnnotation) {
+			continue
+		}
+
 		hpa := hpa.DeepCopy()
 
 		hpaGroup.Go(func() error {
-			return c.adjustHPAScaling(ctx, hpa, currentActiveSchedules)
+			return c.adjustHPAScaling(ctx, hpa, currentActiveSchedules, budget)
 		})
 	}
 
@@ -371,10 +756,14 @@ func (c *Controller) adjustScaling(ctx context.Context, schedules []v1.ScalingSc
 		return fmt.Errorf("failed to wait for handling of HPAs: %w", err)
 	}
 
+	c.pruneMisconfiguredHPAs(hpas.Items)
+
 	return nil
 }
 
-func (c *Controller) activeSchedules(spec v1.ScalingScheduleSpec) ([]v1.Schedule, error) {
+// activeSchedules returns the names and values of the currently active
+// schedules.
+func (c *Controller) activeSchedules(spec v1.ScalingScheduleSpec) ([]activeSchedule, error) {
 	scalingWindowDuration := c.defaultScalingWindow
 	if spec.ScalingWindowDurationMinutes != nil {
 		scalingWindowDuration = time.Duration(*spec.ScalingWindowDurationMinutes) * time.Minute
@@ -383,91 +772,84 @@ func (c *Controller) activeSchedules(spec v1.ScalingScheduleSpec) ([]v1.Schedule
 		return nil, fmt.Errorf("scaling window duration cannot be negative: %d", scalingWindowDuration)
 	}
 
-	activeSchedules := make([]v1.Schedule, 0, len(spec.Schedules))
+	// the same moment is used for all schedules and comparisons.
+	now := c.now()
+
+	activeSchedules := make([]activeSchedule, 0, len(spec.Schedules))
 	for _, schedule := range spec.Schedules {
-		startTime, endTime, err := ScheduleStartEnd(c.now(), schedule, c.defaultTimeZone)
+		startTime, endTime, value, err := ScheduleWindow(now, schedule, c.defaultTimeZone)
 		if err != nil {
 			return nil, err
 		}
 
-		scalingStart := startTime.Add(-scalingWindowDuration)
-		scalingEnd := endTime.Add(scalingWindowDuration)
-
-		if Between(c.now(), scalingStart, scalingEnd) {
-			activeSchedules = append(activeSchedules, schedule)
+		if WithinScalingWindow(now, startTime, endTime, scalingWindowDuration) {
+			activeSchedules = append(activeSchedules, activeSchedule{name: schedule.Name, value: value})
 		}
 	}
 
 	return activeSchedules, nil
 }
 
+// ScheduleStartEnd returns the start and end time of the schedule window
+// relevant for the given moment.
 func ScheduleStartEnd(now time.Time, schedule v1.Schedule, defaultTimeZone string) (time.Time, time.Time, error) {
+	startTime, endTime, _, err := ScheduleWindow(now, schedule, defaultTimeZone)
+	return startTime, endTime, err
+}
+
+// ScheduleWindow returns the start and end time of the schedule window
+// relevant for the given moment and the value of the schedule for this
+// window. For Repeating schedules the window of the previous day is
+// considered as well, as it might cross midnight. The value is looked up in
+// the DayValues by the weekday of the window start in the schedule's
+// timezone, falling back to the schedule's value.
+func ScheduleWindow(now time.Time, schedule v1.Schedule, defaultTimeZone string) (time.Time, time.Time, int64, error) {
+	err := ValidateSchedule(schedule)
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, err
+	}
+
 	var startTime, endTime time.Time
+	value := schedule.Value
 	switch schedule.Type {
 	case v1.RepeatingSchedule:
 		location, err := time.LoadLocation(schedule.Period.Timezone)
 		if schedule.Period.Timezone == "" || err != nil {
 			location, err = time.LoadLocation(defaultTimeZone)
 			if err != nil {
-				return time.Time{}, time.Time{}, fmt.Errorf("unexpected error loading default location: %s", err.Error())
+				return time.Time{}, time.Time{}, 0, fmt.Errorf("unexpected error loading default location: %s", err.Error())
 			}
 		}
 		nowInLocation := now.In(location)
-		weekday := nowInLocation.Weekday()
-		for _, day := range schedule.Period.Days {
-			if days[day] == weekday {
-				parsedStartTime, err := time.Parse(hourColonMinuteLayout, schedule.Period.StartTime)
-				if err != nil {
-					return time.Time{}, time.Time{}, ErrInvalidScheduleStartTime
-				}
-				startTime = time.Date(
-					// v1.SchedulePeriod.StartTime can't define the
-					// year, month or day, so we compute it as the
-					// current date in the configured location.
-					nowInLocation.Year(),
-					nowInLocation.Month(),
-					nowInLocation.Day(),
-					// Hours and minute are configured in the
-					// v1.SchedulePeriod.StartTime.
-					parsedStartTime.Hour(),
-					parsedStartTime.Minute(),
-					parsedStartTime.Second(),
-					parsedStartTime.Nanosecond(),
-					location,
-				)
-
-				// If no end time was provided, set it to equal the start time
-				if schedule.Period.EndTime == "" {
-					endTime = startTime
-				} else {
-					parsedEndTime, err := time.Parse(hourColonMinuteLayout, schedule.Period.EndTime)
-					if err != nil {
-						return time.Time{}, time.Time{}, ErrInvalidScheduleDate
-					}
-					endTime = time.Date(
-						// v1.SchedulePeriod.StartTime can't define the
-						// year, month or day, so we compute it as the
-						// current date in the configured location.
-						nowInLocation.Year(),
-						nowInLocation.Month(),
-						nowInLocation.Day(),
-						// Hours and minute are configured in the
-						// v1.SchedulePeriod.StartTime.
-						parsedEndTime.Hour(),
-						parsedEndTime.Minute(),
-						parsedEndTime.Second(),
-						parsedEndTime.Nanosecond(),
-						location,
-					)
 
-				}
+		found := false
+		for _, day := range []time.Time{nowInLocation.AddDate(0, 0, -1), nowInLocation} {
+			start, end, ok, err := periodWindow(day, schedule, location)
+			if err != nil {
+				return time.Time{}, time.Time{}, 0, err
+			}
+			if !ok {
+				continue
+			}
+
+			// pick the window closest to now, preferring the
+			// current day.
+			if !found || distance(now, start, end) <= distance(now, startTime, endTime) {
+				startTime, endTime = start, end
+				found = true
+			}
+		}
+
+		if found {
+			if dayValue, ok := schedule.DayValues[weekdays[startTime.Weekday()]]; ok {
+				value = dayValue
 			}
 		}
 	case v1.OneTimeSchedule:
 		var err error
 		startTime, err = time.Parse(time.RFC3339, string(*schedule.Date))
 		if err != nil {
-			return time.Time{}, time.Time{}, ErrInvalidScheduleDate
+			return time.Time{}, time.Time{}, 0, ErrInvalidScheduleDate
 		}
 
 		// If no end time was provided, set it to equal the start time
@@ -476,23 +858,293 @@ func ScheduleStartEnd(now time.Time, schedule v1.Schedule, defaultTimeZone strin
 		} else {
 			endTime, err = time.Parse(time.RFC3339, string(*schedule.EndDate))
 			if err != nil {
-				return time.Time{}, time.Time{}, ErrInvalidScheduleDate
+				return time.Time{}, time.Time{}, 0, ErrInvalidScheduleDate
 			}
 		}
+
+		// without a duration the window would be empty, so the
+		// schedule would silently never be active.
+		if endTime.Before(startTime) && schedule.DurationMinutes == 0 {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("%w: the end date %s is before the date %s and no duration is defined", ErrInvertedSchedule, *schedule.EndDate, *schedule.Date)
+		}
+	}
+
+	return startTime, extendedEnd(startTime, endTime, schedule), value, nil
+}
+
+// periodWindow returns the window of a Repeating schedule starting on the
+// day of the given time. It returns false if the schedule isn't active on
+// that weekday.
+func periodWindow(day time.Time, schedule v1.Schedule, location *time.Location) (time.Time, time.Time, bool, error) {
+	scheduled := false
+	for _, scheduleDay := range schedule.Period.Days {
+		if days[scheduleDay] == day.Weekday() {
+			scheduled = true
+			break
+		}
+	}
+	if !scheduled {
+		return time.Time{}, time.Time{}, false, nil
+	}
+
+	parsedStartTime, err := time.Parse(hourColonMinuteLayout, schedule.Period.StartTime)
+	if err != nil {
+		return time.Time{}, time.Time{}, false, ErrInvalidScheduleStartTime
+	}
+	startTime := time.Date(
+		// v1.SchedulePeriod.StartTime can't define the
+		// year, month or day, so we compute it as the
+		// date of the day in the configured location.
+		day.Year(),
+		day.Month(),
+		day.Day(),
+		// Hours and minute are configured in the
+		// v1.SchedulePeriod.StartTime.
+		parsedStartTime.Hour(),
+		parsedStartTime.Minute(),
+		parsedStartTime.Second(),
+		parsedStartTime.Nanosecond(),
+		location,
+	)
+
+	// If no end time was provided, set it to equal the start time
+	endTime := startTime
+	if schedule.Period.EndTime != "" {
+		parsedEndTime, err := time.Parse(hourColonMinuteLayout, schedule.Period.EndTime)
+		if err != nil {
+			return time.Time{}, time.Time{}, false, ErrInvalidScheduleDate
+		}
+		endTime = time.Date(
+			day.Year(),
+			day.Month(),
+			day.Day(),
+			parsedEndTime.Hour(),
+			parsedEndTime.Minute(),
+			parsedEndTime.Second(),
+			parsedEndTime.Nanosecond(),
+			location,
+		)
 	}
 
-	// Use either the defined end time/date or the start time/date + the
-	// duration, whichever is longer.
+	return startTime, extendedEnd(startTime, endTime, schedule), true, nil
+}
+
+// extendedEnd returns either the defined end time/date or the start
+// time/date + the duration, whichever is longer.
+func extendedEnd(startTime, endTime time.Time, schedule v1.Schedule) time.Time {
 	if startTime.Add(schedule.Duration()).After(endTime) {
-		endTime = startTime.Add(schedule.Duration())
+		return startTime.Add(schedule.Duration())
+	}
+	return endTime
+}
+
+// distance returns how far the timestamp is from the window, zero if it's
+// within the window.
+func distance(timestamp, start, end time.Time) time.Duration {
+	if timestamp.Before(start) {
+		return start.Sub(timestamp)
+	}
+	if timestamp.After(end) {
+		return timestamp.Sub(end)
+	}
+	return 0
+}
+
+// ValidateSchedule validates the parts of a schedule which can't be fully
+// validated by the CRD.
+func ValidateSchedule(schedule v1.Schedule) error {
+	switch {
+	case schedule.Type == v1.RepeatingSchedule && schedule.Period == nil:
+		return ErrMissingSchedulePeriod
+	case schedule.Type == v1.OneTimeSchedule && schedule.Date == nil:
+		return ErrMissingScheduleDate
+	}
+
+	if len(schedule.DayValues) == 0 {
+		return nil
+	}
+
+	if schedule.Type != v1.RepeatingSchedule || schedule.Period == nil {
+		return ErrInvalidScheduleDayValues
+	}
+
+	for day := range schedule.DayValues {
+		found := false
+		for _, scheduleDay := range schedule.Period.Days {
+			if day == scheduleDay {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: day '%s' is not part of the period", ErrInvalidScheduleDayValues, day)
+		}
+	}
+
+	return nil
+}
+
+// MaxScheduleDuration returns the maximum duration of the window of a
+// schedule of the type. The maxDuration applies to all types, the default of
+// the type is used if it's zero.
+func MaxScheduleDuration(scheduleType v1.ScheduleType, maxDuration time.Duration) time.Duration {
+	if maxDuration > 0 {
+		return maxDuration
+	}
+	if scheduleType == v1.OneTimeSchedule {
+		return DefaultMaxOneTimeScheduleDuration
+	}
+	return DefaultMaxRepeatingScheduleDuration
+}
+
+// CheckScheduleWindow returns an error if the schedule ends before it
+// starts, if the end date and duration of a OneTime schedule don't match or
+// if its window exceeds the maximum duration of its type as returned by
+// MaxScheduleDuration. Such schedules are still evaluated, the error is only
+// meant to warn about surprising, e.g. always-on, scaling. Only OneTime
+// schedules ending before they start without a duration are rejected by the
+// evaluation.
+func CheckScheduleWindow(schedule v1.Schedule, maxDuration time.Duration) error {
+	var start, end time.Time
+	var err error
+	switch schedule.Type {
+	case v1.RepeatingSchedule:
+		if schedule.Period == nil {
+			return nil
+		}
+		start, err = time.Parse(hourColonMinuteLayout, schedule.Period.StartTime)
+		if err != nil {
+			return ErrInvalidScheduleStartTime
+		}
+		end = start
+		if schedule.Period.EndTime != "" {
+			end, err = time.Parse(hourColonMinuteLayout, schedule.Period.EndTime)
+			if err != nil {
+				return ErrInvalidScheduleDate
+			}
+		}
+	case v1.OneTimeSchedule:
+		if schedule.Date == nil {
+			return nil
+		}
+		start, err = time.Parse(time.RFC3339, string(*schedule.Date))
+		if err != nil {
+			return ErrInvalidScheduleDate
+		}
+		end = start
+		if schedule.EndDate != nil && string(*schedule.EndDate) != "" {
+			end, err = time.Parse(time.RFC3339, string(*schedule.EndDate))
+			if err != nil {
+				return ErrInvalidScheduleDate
+			}
+		}
+	default:
+		return nil
+	}
+
+	var errs []error
+	switch {
+	case end.Before(start) && schedule.Type == v1.OneTimeSchedule && schedule.DurationMinutes == 0:
+		errs = append(errs, fmt.Errorf("%w: the end is %s before the start and no duration is defined, the schedule is rejected", ErrInvertedSchedule, start.Sub(end)))
+	case end.Before(start):
+		errs = append(errs, fmt.Errorf("%w: the end is %s before the start, the duration of %d minutes is used instead", ErrInvertedSchedule, start.Sub(end), schedule.DurationMinutes))
+	case schedule.Type == v1.OneTimeSchedule && schedule.DurationMinutes > 0 && end.After(start) && !start.Add(schedule.Duration()).Equal(end):
+		errs = append(errs, fmt.Errorf("%w: the end date is %s after the date but the duration is %d minutes, the window of %s is used", ErrInconsistentScheduleDuration, end.Sub(start), schedule.DurationMinutes, extendedEnd(start, end, schedule).Sub(start)))
+	}
+
+	window := extendedEnd(start, end, schedule).Sub(start)
+	maxDuration = MaxScheduleDuration(schedule.Type, maxDuration)
+	if window > maxDuration {
+		errs = append(errs, fmt.Errorf("%w: the window of %s exceeds %s", ErrScheduleTooLong, window, maxDuration))
+	}
+
+	return errors.Join(errs...)
+}
+
+// CheckSchedules checks the windows of the schedules with
+// CheckScheduleWindow. It returns a message per invalid schedule naming its
+// index.
+func CheckSchedules(schedules []v1.Schedule, maxDuration time.Duration) []string {
+	var problems []string
+	for i, schedule := range schedules {
+		err := CheckScheduleWindow(schedule, maxDuration)
+		if err == nil {
+			continue
+		}
+
+		name := ""
+		if schedule.Name != "" {
+			name = fmt.Sprintf(" (%s)", schedule.Name)
+		}
+		problems = append(problems, fmt.Sprintf("schedule %d%s: %s", i, name, strings.ReplaceAll(err.Error(), "\n", "; ")))
+	}
+	return problems
+}
+
+// ParseScheduleNames parses the comma separated schedule-names metric
+// config. It returns nil if no names are defined.
+func ParseScheduleNames(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// FilterSchedules returns the schedules with the given names. All the
+// schedules are returned if no names are given. An error is returned if
+// any of the names doesn't match a schedule.
+func FilterSchedules(schedules []v1.Schedule, names []string) ([]v1.Schedule, error) {
+	if len(names) == 0 {
+		return schedules, nil
+	}
+
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = false
+	}
+
+	filtered := make([]v1.Schedule, 0, len(names))
+	for _, schedule := range schedules {
+		if _, ok := wanted[schedule.Name]; ok {
+			wanted[schedule.Name] = true
+			filtered = append(filtered, schedule)
+		}
 	}
 
-	return startTime, endTime, nil
+	var unknown []string
+	for name, found := range wanted {
+		if !found {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("%w: %s", ErrUnknownScheduleNames, strings.Join(unknown, ", "))
+	}
+
+	return filtered, nil
 }
 
+// Between returns true if the timestamp is in the interval [start, end). It's
+// start-inclusive and end-exclusive: a schedule from 09:00 with a duration of
+// 60 minutes is active at exactly 09:00 but no longer at exactly 10:00.
 func Between(timestamp, start, end time.Time) bool {
 	if timestamp.Before(start) {
 		return false
 	}
 	return timestamp.Before(end)
 }
+
+// WithinScalingWindow returns true if the timestamp is in the window of a
+// schedule from start to end extended by the scaling window on both sides,
+// i.e. in [start-scalingWindow, end+scalingWindow). The inclusivity is the
+// same as of Between. It decides whether a schedule is active for both the
+// ScalingSchedule collectors and the controller, so they agree on every
+// instant, including the boundaries.
+func WithinScalingWindow(timestamp, start, end time.Time, scalingWindow time.Duration) bool {
+	return Between(timestamp, start.Add(-scalingWindow), end.Add(scalingWindow))
+}
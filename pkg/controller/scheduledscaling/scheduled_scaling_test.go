@@ -3,9 +3,11 @@ package scheduledscaling
 import (
 	"context"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	v1 "github.com/zalando-incubator/kube-metrics-adapter/pkg/apis/zalando.org/v1"
 	scalingschedulefake "github.com/zalando-incubator/kube-metrics-adapter/pkg/client/clientset/versioned/fake"
@@ -19,6 +21,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/ptr"
 )
 
@@ -333,16 +336,21 @@ func (s *mockScaler) Scale(ctx context.Context, hpa *autoscalingv2.HorizontalPod
 
 func TestAdjustScaling(t *testing.T) {
 	for _, tc := range []struct {
-		msg             string
-		currentReplicas int32
-		desiredReplicas int32
-		targetValue     int64
+		msg               string
+		currentReplicas   int32
+		desiredReplicas   int32
+		targetValue       int64
+		annotations       map[string]string
+		deleting          bool
+		disablePreScaling bool
+		expectedEvents    int
 	}{
 		{
 			msg:             "current less than 10%% below desired",
 			currentReplicas: 95, // 5.3% increase to desired
 			desiredReplicas: 100,
 			targetValue:     10, // 1000/10 = 100
+			expectedEvents:  1,
 		},
 		{
 			msg:             "current more than 10%% below desired, no adjustment",
@@ -355,6 +363,42 @@ func TestAdjustScaling(t *testing.T) {
 			currentReplicas: 95,
 			desiredReplicas: 95,
 			targetValue:     0, // this is treated as invalid in the test, thus the HPA is ingored and no adjustment happens.
+			expectedEvents:  1,
+		},
+		{
+			msg:             "paused HPA should not do any adjustment",
+			currentReplicas: 95,
+			desiredReplicas: 95,
+			targetValue:     10,
+			annotations:     map[string]string{"autoscaling.zalando.org/paused": "true"},
+		},
+		{
+			msg:             "HPA being deleted should not do any adjustment",
+			currentReplicas: 95,
+			desiredReplicas: 95,
+			targetValue:     10,
+			deleting:        true,
+		},
+		{
+			msg:             "HPA skipping pre-scaling should not do any adjustment",
+			currentReplicas: 95,
+			desiredReplicas: 95,
+			targetValue:     10,
+			annotations:     map[string]string{SkipPreScalingAnnotation: "true"},
+		},
+		{
+			msg:             "HPA skipping pre-scaling should not report an invalid target",
+			currentReplicas: 95,
+			desiredReplicas: 95,
+			targetValue:     0,
+			annotations:     map[string]string{SkipPreScalingAnnotation: "true"},
+		},
+		{
+			msg:               "schedule with disabled pre-scaling should not do any adjustment",
+			currentReplicas:   95,
+			desiredReplicas:   95,
+			targetValue:       10,
+			disablePreScaling: true,
 		},
 	} {
 		t.Run(tc.msg, func(t *testing.T) {
@@ -371,6 +415,8 @@ func TestAdjustScaling(t *testing.T) {
 				"Europe/Berlin",
 				0.10,
 			)
+			recorder := record.NewFakeRecorder(10)
+			controller.recorder = recorder
 
 			scheduleDate := v1.ScheduleDate(time.Now().Add(-10 * time.Minute).Format(time.RFC3339))
 			clusterScalingSchedules := []v1.ScalingScheduler{
@@ -379,6 +425,7 @@ func TestAdjustScaling(t *testing.T) {
 						Name: "schedule-1",
 					},
 					Spec: v1.ScalingScheduleSpec{
+						DisablePreScaling: tc.disablePreScaling,
 						Schedules: []v1.Schedule{
 							{
 								Type:            v1.OneTimeSchedule,
@@ -405,7 +452,8 @@ func TestAdjustScaling(t *testing.T) {
 
 			hpa := &autoscalingv2.HorizontalPodAutoscaler{
 				ObjectMeta: metav1.ObjectMeta{
-					Name: "hpa-1",
+					Name:        "hpa-1",
+					Annotations: tc.annotations,
 				},
 				Spec: v2.HorizontalPodAutoscalerSpec{
 					ScaleTargetRef: v2.CrossVersionObjectReference{
@@ -441,9 +489,16 @@ func TestAdjustScaling(t *testing.T) {
 			require.NoError(t, err)
 
 			hpa.Status.CurrentReplicas = tc.currentReplicas
-			_, err = kubeClient.AutoscalingV2().HorizontalPodAutoscalers("default").UpdateStatus(context.Background(), hpa, metav1.UpdateOptions{})
+			hpa, err = kubeClient.AutoscalingV2().HorizontalPodAutoscalers("default").UpdateStatus(context.Background(), hpa, metav1.UpdateOptions{})
 			require.NoError(t, err)
 
+			if tc.deleting {
+				hpa.DeletionTimestamp = ptr.To(metav1.Now())
+				hpa.Finalizers = []string{"example.org/finalizer"}
+				_, err = kubeClient.AutoscalingV2().HorizontalPodAutoscalers("default").Update(context.Background(), hpa, metav1.UpdateOptions{})
+				require.NoError(t, err)
+			}
+
 			err = controller.adjustScaling(context.Background(), clusterScalingSchedules)
 			require.NoError(t, err)
 
@@ -451,6 +506,961 @@ func TestAdjustScaling(t *testing.T) {
 			require.NoError(t, err)
 
 			require.Equal(t, tc.desiredReplicas, ptr.Deref(deployment.Spec.Replicas, 0))
+			require.Len(t, recorder.Events, tc.expectedEvents)
 		})
 	}
 }
+
+func TestAdjustScalingScheduleNames(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset()
+	controller := NewController(
+		zfake.NewSimpleClientset().ZalandoV1(),
+		kubeClient,
+		&mockScaler{client: kubeClient},
+		nil,
+		nil,
+		time.Now,
+		time.Hour,
+		"Europe/Berlin",
+		0.10,
+	)
+
+	scheduleDate := v1.ScheduleDate(time.Now().Add(-10 * time.Minute).Format(time.RFC3339))
+	clusterScalingSchedules := []v1.ScalingScheduler{
+		&v1.ClusterScalingSchedule{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "shared",
+			},
+			Spec: v1.ScalingScheduleSpec{
+				Schedules: []v1.Schedule{
+					{
+						Name:            "small",
+						Type:            v1.OneTimeSchedule,
+						Date:            &scheduleDate,
+						DurationMinutes: 15,
+						Value:           1000,
+					},
+					{
+						Name:            "large",
+						Type:            v1.OneTimeSchedule,
+						Date:            &scheduleDate,
+						DurationMinutes: 15,
+						Value:           1050,
+					},
+				},
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		name          string
+		scheduleNames string
+		current       int32
+	}{
+		{name: "small", scheduleNames: "small", current: 95},
+		{name: "large", scheduleNames: "large", current: 100},
+	} {
+		_, err := kubeClient.AppsV1().Deployments("default").Create(context.Background(), &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: tc.name},
+			Spec:       appsv1.DeploymentSpec{Replicas: ptr.To(tc.current)},
+		}, metav1.CreateOptions{})
+		require.NoError(t, err)
+
+		hpa := &autoscalingv2.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: tc.name,
+				Annotations: map[string]string{
+					"metric-config.object.shared.cluster-scaling-schedule/schedule-names": tc.scheduleNames,
+				},
+			},
+			Spec: v2.HorizontalPodAutoscalerSpec{
+				ScaleTargetRef: v2.CrossVersionObjectReference{
+					APIVersion: "apps/v1",
+					Kind:       "Deployment",
+					Name:       tc.name,
+				},
+				MaxReplicas: 1000,
+				Metrics: []v2.MetricSpec{
+					{
+						Type: v2.ObjectMetricSourceType,
+						Object: &v2.ObjectMetricSource{
+							DescribedObject: v2.CrossVersionObjectReference{
+								APIVersion: "zalando.org/v1",
+								Kind:       "ClusterScalingSchedule",
+								Name:       "shared",
+							},
+							Metric: v2.MetricIdentifier{Name: "shared"},
+							Target: v2.MetricTarget{
+								Type:         v2.AverageValueMetricType,
+								AverageValue: resource.NewQuantity(10, resource.DecimalSI),
+							},
+						},
+					},
+				},
+			},
+		}
+
+		hpa, err = kubeClient.AutoscalingV2().HorizontalPodAutoscalers("default").Create(context.Background(), hpa, metav1.CreateOptions{})
+		require.NoError(t, err)
+
+		hpa.Status.CurrentReplicas = tc.current
+		_, err = kubeClient.AutoscalingV2().HorizontalPodAutoscalers("default").UpdateStatus(context.Background(), hpa, metav1.UpdateOptions{})
+		require.NoError(t, err)
+	}
+
+	err := controller.adjustScaling(context.Background(), clusterScalingSchedules)
+	require.NoError(t, err)
+
+	// 1000/10 based on the small schedule only
+	deployment, err := kubeClient.AppsV1().Deployments("default").Get(context.Background(), "small", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, int32(100), ptr.Deref(deployment.Spec.Replicas, 0))
+
+	// 1050/10 based on the large schedule only
+	deployment, err = kubeClient.AppsV1().Deployments("default").Get(context.Background(), "large", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, int32(105), ptr.Deref(deployment.Spec.Replicas, 0))
+}
+
+func TestFilterSchedules(t *testing.T) {
+	schedules := []v1.Schedule{{Name: "a"}, {Name: "b"}, {}}
+
+	filtered, err := FilterSchedules(schedules, nil)
+	require.NoError(t, err)
+	require.Equal(t, schedules, filtered)
+
+	filtered, err = FilterSchedules(schedules, ParseScheduleNames("b, a"))
+	require.NoError(t, err)
+	require.Equal(t, []v1.Schedule{{Name: "a"}, {Name: "b"}}, filtered)
+
+	_, err = FilterSchedules(schedules, ParseScheduleNames("a,c"))
+	require.ErrorIs(t, err, ErrUnknownScheduleNames)
+	require.Contains(t, err.Error(), "c")
+}
+
+func TestMisconfiguredScheduleTarget(t *testing.T) {
+	for _, tc := range []struct {
+		msg            string
+		target         *resource.Quantity
+		expectedEvents int
+	}{
+		{
+			msg:            "missing target produces an event",
+			target:         nil,
+			expectedEvents: 1,
+		},
+		{
+			msg:            "zero target produces an event",
+			target:         resource.NewQuantity(0, resource.DecimalSI),
+			expectedEvents: 1,
+		},
+		{
+			msg:            "valid target produces no event",
+			target:         resource.NewQuantity(10, resource.DecimalSI),
+			expectedEvents: 0,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			kubeClient := fake.NewSimpleClientset()
+			controller := NewController(
+				zfake.NewSimpleClientset().ZalandoV1(),
+				kubeClient,
+				&mockScaler{client: kubeClient},
+				nil,
+				nil,
+				time.Now,
+				time.Hour,
+				"Europe/Berlin",
+				0.10,
+			)
+			recorder := record.NewFakeRecorder(10)
+			controller.recorder = recorder
+
+			scheduleDate := v1.ScheduleDate(time.Now().Add(-10 * time.Minute).Format(time.RFC3339))
+			schedules := []v1.ScalingScheduler{
+				&v1.ScalingSchedule{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "schedule-1",
+						Namespace: "default",
+					},
+					Spec: v1.ScalingScheduleSpec{
+						Schedules: []v1.Schedule{
+							{
+								Type:            v1.OneTimeSchedule,
+								Date:            &scheduleDate,
+								DurationMinutes: 15,
+								Value:           10,
+							},
+						},
+					},
+				},
+			}
+
+			hpa := &autoscalingv2.HorizontalPodAutoscaler{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "hpa-1",
+					Namespace: "default",
+				},
+				Spec: v2.HorizontalPodAutoscalerSpec{
+					ScaleTargetRef: v2.CrossVersionObjectReference{
+						APIVersion: "apps/v1",
+						Kind:       "Deployment",
+						Name:       "deployment-1",
+					},
+					MaxReplicas: 10,
+					Metrics: []v2.MetricSpec{
+						{
+							Type: v2.ObjectMetricSourceType,
+							Object: &v2.ObjectMetricSource{
+								DescribedObject: v2.CrossVersionObjectReference{
+									APIVersion: "zalando.org/v1",
+									Kind:       "ScalingSchedule",
+									Name:       "schedule-1",
+								},
+								Metric: v2.MetricIdentifier{
+									Name: "schedule-1",
+								},
+								Target: v2.MetricTarget{
+									Type:         v2.AverageValueMetricType,
+									AverageValue: tc.target,
+								},
+							},
+						},
+					},
+				},
+			}
+
+			_, err := kubeClient.AutoscalingV2().HorizontalPodAutoscalers("default").Create(context.Background(), hpa, metav1.CreateOptions{})
+			require.NoError(t, err)
+
+			// the event must only be emitted once for repeated loops.
+			for i := 0; i < 3; i++ {
+				err = controller.adjustScaling(context.Background(), schedules)
+				require.NoError(t, err)
+			}
+
+			require.Len(t, recorder.Events, tc.expectedEvents)
+			if tc.expectedEvents > 0 {
+				event := <-recorder.Events
+				require.Contains(t, event, "ScalingScheduleTargetInvalid")
+				require.Contains(t, event, "default/schedule-1")
+				require.Contains(t, event, "metric 'schedule-1'")
+			}
+			require.Len(t, controller.misconfiguredHPAs, tc.expectedEvents)
+		})
+	}
+}
+
+func TestScheduleWindowDayValues(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	schedule := v1.Schedule{
+		Type: v1.RepeatingSchedule,
+		Period: &v1.SchedulePeriod{
+			StartTime: "22:00",
+			Days:      []v1.ScheduleDay{v1.MondaySchedule, v1.TuesdaySchedule, v1.WednesdaySchedule},
+			Timezone:  "Europe/Berlin",
+		},
+		DurationMinutes: 240,
+		Value:           50,
+		DayValues: map[v1.ScheduleDay]int64{
+			v1.MondaySchedule:  100,
+			v1.TuesdaySchedule: 200,
+		},
+	}
+
+	for _, tc := range []struct {
+		msg           string
+		now           time.Time
+		expectedStart time.Time
+		expectedValue int64
+	}{
+		{
+			msg:           "value of the start day",
+			now:           time.Date(2024, time.January, 1, 23, 0, 0, 0, berlin), // Monday
+			expectedStart: time.Date(2024, time.January, 1, 22, 0, 0, 0, berlin),
+			expectedValue: 100,
+		},
+		{
+			msg:           "window crossing midnight uses the value of the start day",
+			now:           time.Date(2024, time.January, 2, 1, 0, 0, 0, berlin), // Tuesday
+			expectedStart: time.Date(2024, time.January, 1, 22, 0, 0, 0, berlin),
+			expectedValue: 100,
+		},
+		{
+			msg:           "next window on the following day",
+			now:           time.Date(2024, time.January, 2, 21, 0, 0, 0, berlin), // Tuesday
+			expectedStart: time.Date(2024, time.January, 2, 22, 0, 0, 0, berlin),
+			expectedValue: 200,
+		},
+		{
+			msg:           "window crossing midnight into a day which is not scheduled",
+			now:           time.Date(2024, time.January, 4, 1, 0, 0, 0, berlin), // Thursday
+			expectedStart: time.Date(2024, time.January, 3, 22, 0, 0, 0, berlin),
+			expectedValue: 50,
+		},
+		{
+			msg:           "window start in the schedule's timezone",
+			now:           time.Date(2024, time.January, 1, 23, 30, 0, 0, time.UTC), // Tuesday 00:30 in Berlin
+			expectedStart: time.Date(2024, time.January, 1, 22, 0, 0, 0, berlin),
+			expectedValue: 100,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			start, end, value, err := ScheduleWindow(tc.now, schedule, "Europe/Berlin")
+			require.NoError(t, err)
+			require.True(t, tc.expectedStart.Equal(start), "expected start %s, got %s", tc.expectedStart, start)
+			require.True(t, tc.expectedStart.Add(4*time.Hour).Equal(end))
+			require.Equal(t, tc.expectedValue, value)
+		})
+	}
+}
+
+func TestValidateScheduleDayValues(t *testing.T) {
+	period := &v1.SchedulePeriod{
+		StartTime: "10:00",
+		Days:      []v1.ScheduleDay{v1.MondaySchedule},
+	}
+
+	require.NoError(t, ValidateSchedule(v1.Schedule{
+		Type:      v1.RepeatingSchedule,
+		Period:    period,
+		DayValues: map[v1.ScheduleDay]int64{v1.MondaySchedule: 10},
+	}))
+
+	err := ValidateSchedule(v1.Schedule{
+		Type:      v1.RepeatingSchedule,
+		Period:    period,
+		DayValues: map[v1.ScheduleDay]int64{v1.TuesdaySchedule: 10},
+	})
+	require.ErrorIs(t, err, ErrInvalidScheduleDayValues)
+
+	date := v1.ScheduleDate("2024-01-01T10:00:00Z")
+	err = ValidateSchedule(v1.Schedule{
+		Type:      v1.OneTimeSchedule,
+		Date:      &date,
+		DayValues: map[v1.ScheduleDay]int64{v1.MondaySchedule: 10},
+	})
+	require.ErrorIs(t, err, ErrInvalidScheduleDayValues)
+
+	_, _, _, err = ScheduleWindow(time.Now(), v1.Schedule{
+		Type:      v1.RepeatingSchedule,
+		Period:    period,
+		DayValues: map[v1.ScheduleDay]int64{v1.TuesdaySchedule: 10},
+	}, "Europe/Berlin")
+	require.ErrorIs(t, err, ErrInvalidScheduleDayValues)
+}
+
+func TestActiveSchedulesBoundaries(t *testing.T) {
+	start := time.Date(2024, time.January, 1, 9, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+
+	for _, tc := range []struct {
+		msg            string
+		now            time.Time
+		scalingWindow  int64
+		expectedActive bool
+	}{
+		{msg: "exactly start", now: start, scalingWindow: 10, expectedActive: true},
+		{msg: "exactly end", now: end, scalingWindow: 10, expectedActive: true},
+		{msg: "just before end plus ramp", now: end.Add(10*time.Minute - time.Nanosecond), scalingWindow: 10, expectedActive: true},
+		{msg: "exactly end plus ramp", now: end.Add(10 * time.Minute), scalingWindow: 10, expectedActive: false},
+		{msg: "exactly start minus ramp", now: start.Add(-10 * time.Minute), scalingWindow: 10, expectedActive: true},
+		{msg: "exactly end without ramp", now: end, scalingWindow: 0, expectedActive: false},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			controller := NewController(nil, fake.NewSimpleClientset(), nil, nil, nil, func() time.Time { return tc.now }, 0, "Europe/Berlin", 0.10)
+
+			active, err := controller.activeSchedules(v1.ScalingScheduleSpec{
+				ScalingWindowDurationMinutes: ptr.To(tc.scalingWindow),
+				Schedules: []v1.Schedule{
+					{Type: v1.OneTimeSchedule, Date: scheduleDate(start.Format(time.RFC3339)), DurationMinutes: 60, Value: 100},
+				},
+			})
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedActive, len(active) == 1)
+			require.Equal(t, tc.expectedActive, WithinScalingWindow(tc.now, start, end, time.Duration(tc.scalingWindow)*time.Minute))
+		})
+	}
+}
+
+func TestOffsetNowShiftsActivation(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	for _, tc := range []struct {
+		msg            string
+		start          time.Time
+		offset         time.Duration
+		expectedActive bool
+	}{
+		{msg: "future window without offset", start: now.Add(30 * time.Minute), offset: 0, expectedActive: false},
+		{msg: "positive offset reaching the window", start: now.Add(30 * time.Minute), offset: 45 * time.Minute, expectedActive: true},
+		{msg: "positive offset passing the window", start: now.Add(30 * time.Minute), offset: 2 * time.Hour, expectedActive: false},
+		{msg: "past window without offset", start: now.Add(-90 * time.Minute), offset: 0, expectedActive: false},
+		{msg: "negative offset reaching the window", start: now.Add(-90 * time.Minute), offset: -45 * time.Minute, expectedActive: true},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			controller := NewController(nil, fake.NewSimpleClientset(), nil, nil, nil, OffsetNow(tc.offset), 0, "Europe/Berlin", 0.10)
+
+			active, err := controller.activeSchedules(v1.ScalingScheduleSpec{
+				ScalingWindowDurationMinutes: ptr.To(int64(0)),
+				Schedules: []v1.Schedule{
+					{Type: v1.OneTimeSchedule, Date: scheduleDate(tc.start.Format(time.RFC3339)), DurationMinutes: 60, Value: 100},
+				},
+			})
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedActive, len(active) == 1)
+
+			clock := time.Unix(0, int64(testutil.ToFloat64(ScheduleClock)*float64(time.Second)))
+			require.WithinDuration(t, time.Now().Add(tc.offset), clock, time.Second)
+		})
+	}
+}
+
+func TestCheckScheduleWindow(t *testing.T) {
+	for _, tc := range []struct {
+		msg         string
+		schedule    v1.Schedule
+		maxDuration time.Duration
+		expected    []error
+	}{
+		{
+			msg: "repeating schedule within a day",
+			schedule: v1.Schedule{
+				Type:            v1.RepeatingSchedule,
+				Period:          &v1.SchedulePeriod{StartTime: "08:00", Days: []v1.ScheduleDay{v1.MondaySchedule}},
+				DurationMinutes: 600,
+			},
+		},
+		{
+			msg: "repeating schedule longer than a day",
+			schedule: v1.Schedule{
+				Type:            v1.RepeatingSchedule,
+				Period:          &v1.SchedulePeriod{StartTime: "08:00", Days: []v1.ScheduleDay{v1.MondaySchedule}},
+				DurationMinutes: 25 * 60,
+			},
+			expected: []error{ErrScheduleTooLong},
+		},
+		{
+			msg: "repeating schedule within a configured max duration",
+			schedule: v1.Schedule{
+				Type:            v1.RepeatingSchedule,
+				Period:          &v1.SchedulePeriod{StartTime: "08:00", Days: []v1.ScheduleDay{v1.MondaySchedule}},
+				DurationMinutes: 25 * 60,
+			},
+			maxDuration: 48 * time.Hour,
+		},
+		{
+			msg: "repeating schedule ending before it starts",
+			schedule: v1.Schedule{
+				Type:            v1.RepeatingSchedule,
+				Period:          &v1.SchedulePeriod{StartTime: "18:00", EndTime: "08:00", Days: []v1.ScheduleDay{v1.MondaySchedule}},
+				DurationMinutes: 60,
+			},
+			expected: []error{ErrInvertedSchedule},
+		},
+		{
+			msg: "one-time schedule within a week",
+			schedule: v1.Schedule{
+				Type:    v1.OneTimeSchedule,
+				Date:    scheduleDate("2024-01-01T08:00:00Z"),
+				EndDate: scheduleDate("2024-01-05T08:00:00Z"),
+			},
+		},
+		{
+			msg: "one-time schedule longer than a week",
+			schedule: v1.Schedule{
+				Type:    v1.OneTimeSchedule,
+				Date:    scheduleDate("2024-01-01T08:00:00Z"),
+				EndDate: scheduleDate("2024-03-01T08:00:00Z"),
+			},
+			expected: []error{ErrScheduleTooLong},
+		},
+		{
+			msg: "one-time schedule ending before it starts with a long duration",
+			schedule: v1.Schedule{
+				Type:            v1.OneTimeSchedule,
+				Date:            scheduleDate("2024-01-05T08:00:00Z"),
+				EndDate:         scheduleDate("2024-01-01T08:00:00Z"),
+				DurationMinutes: 30 * 24 * 60,
+			},
+			expected: []error{ErrInvertedSchedule, ErrScheduleTooLong},
+		},
+		{
+			msg: "one-time schedule ending before it starts without a duration",
+			schedule: v1.Schedule{
+				Type:    v1.OneTimeSchedule,
+				Date:    scheduleDate("2024-01-05T08:00:00Z"),
+				EndDate: scheduleDate("2024-01-01T08:00:00Z"),
+			},
+			expected: []error{ErrInvertedSchedule},
+		},
+		{
+			msg: "one-time schedule with an end date and a shorter duration",
+			schedule: v1.Schedule{
+				Type:            v1.OneTimeSchedule,
+				Date:            scheduleDate("2024-01-01T08:00:00Z"),
+				EndDate:         scheduleDate("2024-01-01T10:00:00Z"),
+				DurationMinutes: 60,
+			},
+			expected: []error{ErrInconsistentScheduleDuration},
+		},
+		{
+			msg: "one-time schedule with a matching end date and duration",
+			schedule: v1.Schedule{
+				Type:            v1.OneTimeSchedule,
+				Date:            scheduleDate("2024-01-01T08:00:00Z"),
+				EndDate:         scheduleDate("2024-01-01T10:00:00Z"),
+				DurationMinutes: 120,
+			},
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			err := CheckScheduleWindow(tc.schedule, tc.maxDuration)
+			if len(tc.expected) == 0 {
+				require.NoError(t, err)
+				return
+			}
+			for _, expected := range tc.expected {
+				require.ErrorIs(t, err, expected)
+			}
+		})
+	}
+}
+
+func TestCheckSchedules(t *testing.T) {
+	problems := CheckSchedules([]v1.Schedule{
+		{
+			Type:            v1.RepeatingSchedule,
+			Period:          &v1.SchedulePeriod{StartTime: "08:00", Days: []v1.ScheduleDay{v1.MondaySchedule}},
+			DurationMinutes: 60,
+		},
+		{
+			Name:            "always-on",
+			Type:            v1.RepeatingSchedule,
+			Period:          &v1.SchedulePeriod{StartTime: "08:00", Days: []v1.ScheduleDay{v1.MondaySchedule}},
+			DurationMinutes: 2 * 24 * 60,
+		},
+	}, 0)
+	require.Len(t, problems, 1)
+	require.Contains(t, problems[0], "schedule 1 (always-on)")
+	require.Contains(t, problems[0], ErrScheduleTooLong.Error())
+}
+
+func TestInvalidScheduleCondition(t *testing.T) {
+	client := zfake.NewSimpleClientset()
+	kubeClient := fake.NewSimpleClientset()
+	controller := NewController(client.ZalandoV1(), kubeClient, &mockScaler{client: kubeClient}, nil, nil, time.Now, time.Hour, "Europe/Berlin", 0.10)
+	recorder := record.NewFakeRecorder(10)
+	controller.recorder = recorder
+
+	schedule := &v1.ScalingSchedule{
+		ObjectMeta: metav1.ObjectMeta{Name: "schedule-1", Namespace: "default", Generation: 1},
+		Spec: v1.ScalingScheduleSpec{
+			Schedules: []v1.Schedule{
+				{
+					Type:            v1.RepeatingSchedule,
+					Period:          &v1.SchedulePeriod{StartTime: "18:00", EndTime: "08:00", Days: []v1.ScheduleDay{v1.MondaySchedule}},
+					DurationMinutes: 60,
+					Value:           10,
+				},
+			},
+		},
+	}
+	_, err := client.ZalandoV1().ScalingSchedules("default").Create(context.Background(), schedule, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	// the event must only be emitted when the condition changes.
+	for i := 0; i < 3; i++ {
+		current, err := client.ZalandoV1().ScalingSchedules("default").Get(context.Background(), "schedule-1", metav1.GetOptions{})
+		require.NoError(t, err)
+		err = controller.updateStatus(context.Background(), []*v1.ScalingSchedule{current}, nil)
+		require.NoError(t, err)
+	}
+
+	require.Len(t, recorder.Events, 1)
+	event := <-recorder.Events
+	require.Contains(t, event, "InvalidSchedule")
+	require.Contains(t, event, "schedule 0")
+
+	updated, err := client.ZalandoV1().ScalingSchedules("default").Get(context.Background(), "schedule-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, updated.Status.Conditions, 1)
+	require.Equal(t, v1.ScalingScheduleValidCondition, updated.Status.Conditions[0].Type)
+	require.Equal(t, metav1.ConditionFalse, updated.Status.Conditions[0].Status)
+	require.Equal(t, "InvalidSchedule", updated.Status.Conditions[0].Reason)
+	require.Equal(t, int64(1), updated.Status.Conditions[0].ObservedGeneration)
+}
+
+func TestScheduleWindowInvertedOneTime(t *testing.T) {
+	now := time.Date(2024, 1, 3, 8, 0, 0, 0, time.UTC)
+	schedule := v1.Schedule{
+		Type:    v1.OneTimeSchedule,
+		Date:    scheduleDate("2024-01-05T08:00:00Z"),
+		EndDate: scheduleDate("2024-01-01T08:00:00Z"),
+		Value:   10,
+	}
+
+	_, _, _, err := ScheduleWindow(now, schedule, "Europe/Berlin")
+	require.ErrorIs(t, err, ErrInvertedSchedule)
+
+	// with a duration the window falls back to the duration.
+	schedule.DurationMinutes = 60
+	start, end, _, err := ScheduleWindow(now, schedule, "Europe/Berlin")
+	require.NoError(t, err)
+	require.Equal(t, time.Hour, end.Sub(start))
+
+	// if the duration is shorter than the end date, the end date is
+	// used.
+	schedule.EndDate = scheduleDate("2024-01-05T10:00:00Z")
+	start, end, _, err = ScheduleWindow(now, schedule, "Europe/Berlin")
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Hour, end.Sub(start))
+}
+
+func TestInvertedScheduleConditionWithoutEvaluation(t *testing.T) {
+	client := zfake.NewSimpleClientset()
+	kubeClient := fake.NewSimpleClientset()
+	controller := NewController(client.ZalandoV1(), kubeClient, &mockScaler{client: kubeClient}, nil, nil, time.Now, time.Hour, "Europe/Berlin", 0.10)
+	recorder := record.NewFakeRecorder(10)
+	controller.recorder = recorder
+
+	schedule := &v1.ClusterScalingSchedule{
+		ObjectMeta: metav1.ObjectMeta{Name: "schedule-1", Generation: 1},
+		Spec: v1.ScalingScheduleSpec{
+			Schedules: []v1.Schedule{
+				{
+					Type:    v1.OneTimeSchedule,
+					Date:    scheduleDate("2024-01-05T08:00:00Z"),
+					EndDate: scheduleDate("2024-01-01T08:00:00Z"),
+					Value:   10,
+				},
+			},
+		},
+	}
+	_, err := client.ZalandoV1().ClusterScalingSchedules().Create(context.Background(), schedule, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	// the schedules can't be evaluated, but the condition is still
+	// updated.
+	err = controller.updateStatus(context.Background(), nil, []*v1.ClusterScalingSchedule{schedule})
+	require.NoError(t, err)
+
+	require.Len(t, recorder.Events, 1)
+	event := <-recorder.Events
+	require.Contains(t, event, "InvalidSchedule")
+	require.Contains(t, event, "no duration is defined")
+
+	updated, err := client.ZalandoV1().ClusterScalingSchedules().Get(context.Background(), "schedule-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, updated.Status.Conditions, 1)
+	require.Equal(t, metav1.ConditionFalse, updated.Status.Conditions[0].Status)
+	require.False(t, updated.Status.Active)
+}
+
+type rejectingScaler struct{}
+
+func (s *rejectingScaler) Scale(_ context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, _ int32) error {
+	return fmt.Errorf("%w: scale subresource of %s resolved to another object", ErrScaleTargetRejected, hpa.Spec.ScaleTargetRef.Name)
+}
+
+type countingScaler struct {
+	mu       sync.Mutex
+	scaled   []string
+	replicas []int32
+}
+
+func (s *countingScaler) Scale(_ context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, replicas int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scaled = append(s.scaled, hpa.Name)
+	s.replicas = append(s.replicas, replicas)
+	return nil
+}
+
+// createScheduledHPAs creates HPAs at 95 current replicas scaled by the
+// ClusterScalingSchedule returned, which requires 100 replicas.
+func createScheduledHPAs(t *testing.T, kubeClient kubernetes.Interface, names ...string) []v1.ScalingScheduler {
+	scheduleDate := v1.ScheduleDate(time.Now().Add(-10 * time.Minute).Format(time.RFC3339))
+	clusterScalingSchedules := []v1.ScalingScheduler{
+		&v1.ClusterScalingSchedule{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "schedule",
+			},
+			Spec: v1.ScalingScheduleSpec{
+				Schedules: []v1.Schedule{
+					{
+						Type:            v1.OneTimeSchedule,
+						Date:            &scheduleDate,
+						DurationMinutes: 15,
+						Value:           1000,
+					},
+				},
+			},
+		},
+	}
+
+	for _, name := range names {
+		hpa := &autoscalingv2.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+			Spec: v2.HorizontalPodAutoscalerSpec{
+				ScaleTargetRef: v2.CrossVersionObjectReference{
+					APIVersion: "apps/v1",
+					Kind:       "Deployment",
+					Name:       name,
+				},
+				MaxReplicas: 1000,
+				Metrics: []v2.MetricSpec{
+					{
+						Type: v2.ObjectMetricSourceType,
+						Object: &v2.ObjectMetricSource{
+							DescribedObject: v2.CrossVersionObjectReference{
+								APIVersion: "zalando.org/v1",
+								Kind:       "ClusterScalingSchedule",
+								Name:       "schedule",
+							},
+							Metric: v2.MetricIdentifier{Name: "schedule"},
+							Target: v2.MetricTarget{
+								Type:         v2.AverageValueMetricType,
+								AverageValue: resource.NewQuantity(10, resource.DecimalSI),
+							},
+						},
+					},
+				},
+			},
+		}
+
+		hpa, err := kubeClient.AutoscalingV2().HorizontalPodAutoscalers("default").Create(context.Background(), hpa, metav1.CreateOptions{})
+		require.NoError(t, err)
+
+		hpa.Status.CurrentReplicas = 95
+		_, err = kubeClient.AutoscalingV2().HorizontalPodAutoscalers("default").UpdateStatus(context.Background(), hpa, metav1.UpdateOptions{})
+		require.NoError(t, err)
+	}
+
+	return clusterScalingSchedules
+}
+
+func TestAdjustScalingRejectedScaleTarget(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset()
+	controller := NewController(
+		zfake.NewSimpleClientset().ZalandoV1(),
+		kubeClient,
+		&rejectingScaler{},
+		nil,
+		nil,
+		time.Now,
+		time.Hour,
+		"Europe/Berlin",
+		0.10,
+	)
+	recorder := record.NewFakeRecorder(10)
+	controller.recorder = recorder
+
+	clusterScalingSchedules := createScheduledHPAs(t, kubeClient, "app")
+
+	err := controller.adjustScaling(context.Background(), clusterScalingSchedules)
+	require.NoError(t, err)
+
+	require.Len(t, recorder.Events, 1)
+	event := <-recorder.Events
+	require.Contains(t, event, "Warning ScalingRejected")
+	require.Contains(t, event, ErrScaleTargetRejected.Error())
+}
+
+func TestAdjustScalingMaxScalesPerRun(t *testing.T) {
+	for _, tc := range []struct {
+		msg       string
+		maxScales int
+		expected  int
+	}{
+		{msg: "unlimited", maxScales: 0, expected: 3},
+		{msg: "limited", maxScales: 2, expected: 2},
+		{msg: "limit above the HPAs", maxScales: 5, expected: 3},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			kubeClient := fake.NewSimpleClientset()
+			scaler := &countingScaler{}
+			controller := NewController(
+				zfake.NewSimpleClientset().ZalandoV1(),
+				kubeClient,
+				scaler,
+				nil,
+				nil,
+				time.Now,
+				time.Hour,
+				"Europe/Berlin",
+				0.10,
+			)
+			controller.recorder = record.NewFakeRecorder(10)
+			controller.SetMaxScalesPerRun(tc.maxScales)
+
+			clusterScalingSchedules := createScheduledHPAs(t, kubeClient, "a", "b", "c")
+
+			err := controller.adjustScaling(context.Background(), clusterScalingSchedules)
+			require.NoError(t, err)
+			require.Len(t, scaler.scaled, tc.expected)
+
+			// the budget is renewed on every run.
+			scaler.scaled = nil
+			err = controller.adjustScaling(context.Background(), clusterScalingSchedules)
+			require.NoError(t, err)
+			require.Len(t, scaler.scaled, tc.expected)
+		})
+	}
+}
+
+func TestAdjustScalingScaleUpGuard(t *testing.T) {
+	for _, tc := range []struct {
+		msg              string
+		maxScaleUpFactor float64
+		replicaCeiling   int64
+		expectedReplicas []int32
+		expectedEvents   []string
+	}{
+		{
+			msg:              "no clamping under the factor",
+			maxScaleUpFactor: DefaultMaxScaleUpFactor,
+			expectedReplicas: []int32{100},
+			expectedEvents:   []string{"Normal ScalingAdjusted"},
+		},
+		{
+			msg:              "no clamping with disabled guards",
+			expectedReplicas: []int32{100},
+			expectedEvents:   []string{"Normal ScalingAdjusted"},
+		},
+		{
+			msg:              "clamped by the factor",
+			maxScaleUpFactor: 1.02, // 95*1.02 = 96.9
+			expectedReplicas: []int32{97},
+			expectedEvents:   []string{"Warning ScalingClamped Scaling schedules expect 100 replicas, clamped to 97", "Normal ScalingAdjusted"},
+		},
+		{
+			msg:              "clamped by the ceiling",
+			maxScaleUpFactor: DefaultMaxScaleUpFactor,
+			replicaCeiling:   98,
+			expectedReplicas: []int32{98},
+			expectedEvents:   []string{"Warning ScalingClamped Scaling schedules expect 100 replicas, clamped to 98", "Normal ScalingAdjusted"},
+		},
+		{
+			msg:              "ceiling below the current replicas",
+			maxScaleUpFactor: DefaultMaxScaleUpFactor,
+			replicaCeiling:   50,
+			expectedEvents:   []string{"Warning ScalingClamped Scaling schedules expect 100 replicas, clamped to 50"},
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			kubeClient := fake.NewSimpleClientset()
+			scaler := &countingScaler{}
+			controller := NewController(
+				zfake.NewSimpleClientset().ZalandoV1(),
+				kubeClient,
+				scaler,
+				nil,
+				nil,
+				time.Now,
+				time.Hour,
+				"Europe/Berlin",
+				0.10,
+			)
+			recorder := record.NewFakeRecorder(10)
+			controller.recorder = recorder
+			controller.SetMaxScaleUpFactor(tc.maxScaleUpFactor)
+			controller.SetReplicaCeiling(tc.replicaCeiling)
+
+			clusterScalingSchedules := createScheduledHPAs(t, kubeClient, "app")
+
+			err := controller.adjustScaling(context.Background(), clusterScalingSchedules)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedReplicas, scaler.replicas)
+
+			require.Len(t, recorder.Events, len(tc.expectedEvents))
+			for _, expected := range tc.expectedEvents {
+				require.Contains(t, <-recorder.Events, expected)
+			}
+		})
+	}
+}
+
+func TestAdjustScalingScaleUpGuardRunaway(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset()
+	scaler := &countingScaler{}
+	controller := NewController(
+		zfake.NewSimpleClientset().ZalandoV1(),
+		kubeClient,
+		scaler,
+		nil,
+		nil,
+		time.Now,
+		time.Hour,
+		"Europe/Berlin",
+		0.10,
+	)
+	recorder := record.NewFakeRecorder(10)
+	controller.recorder = recorder
+
+	clusterScalingSchedules := createScheduledHPAs(t, kubeClient, "app")
+	// a typo'd value asks for 12000 replicas.
+	clusterScalingSchedules[0].(*v1.ClusterScalingSchedule).Spec.Schedules[0].Value = 120000
+	hpa, err := kubeClient.AutoscalingV2().HorizontalPodAutoscalers("default").Get(context.Background(), "app", metav1.GetOptions{})
+	require.NoError(t, err)
+	hpa.Spec.MaxReplicas = 20000
+	_, err = kubeClient.AutoscalingV2().HorizontalPodAutoscalers("default").Update(context.Background(), hpa, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	err = controller.adjustScaling(context.Background(), clusterScalingSchedules)
+	require.NoError(t, err)
+
+	// the clamped 950 replicas are still beyond the tolerance, so the
+	// target is left to the HPA.
+	require.Empty(t, scaler.replicas)
+	require.Len(t, recorder.Events, 1)
+	require.Contains(t, <-recorder.Events, "Warning ScalingClamped Scaling schedules expect 12000 replicas, clamped to 950")
+}
+
+func FuzzScheduleStartEnd(f *testing.F) {
+	f.Add("Repeating", "09:00", "10:00", "Europe/Berlin", "", "", 0, "Europe/Berlin")
+	f.Add("Repeating", "23:30", "00:30", "UTC", "", "", 0, "Europe/Berlin")
+	f.Add("Repeating", "24:00", "", "", "", "", 60, "Europe/Berlin")
+	f.Add("Repeating", "9:00", "10:00", "../../../etc/localtime", "", "", 0, "Invalid/Zone")
+	f.Add("Repeating", "", "", "", "", "", -60, "")
+	f.Add("OneTime", "", "", "", "2024-01-02T10:00:00+01:00", "2024-01-02T12:00:00+01:00", 0, "Europe/Berlin")
+	f.Add("OneTime", "", "", "", "2024-01-02T10:00:00Z", "2024-01-01T10:00:00Z", 0, "Europe/Berlin")
+	f.Add("OneTime", "", "", "", "2024-01-02T10:00:00Z", "", 1<<40, "Europe/Berlin")
+	f.Add("OneTime", "", "", "", "0000-01-01T00:00:00-23:59", "9999-12-31T23:59:59+23:59", 0, "UTC")
+	f.Add("OneTime", "", "", "", "2024-13-45T25:61:61Z", "", 0, "UTC")
+	f.Add("OneTime", "", "", "", "", "", 60, "UTC")
+	f.Add("Daily", "09:00", "10:00", "", "2024-01-02T10:00:00Z", "", 0, "UTC")
+
+	now := time.Date(2024, 1, 2, 10, 30, 0, 0, time.UTC)
+	f.Fuzz(func(t *testing.T, scheduleType, startTime, endTime, timezone, date, endDate string, durationMinutes int, defaultTimeZone string) {
+		schedule := v1.Schedule{
+			Type:            v1.ScheduleType(scheduleType),
+			DurationMinutes: durationMinutes,
+			Value:           1,
+		}
+		// the period and dates are only set if any of their fields is
+		// set, so schedules missing them are covered as well.
+		if startTime != "" || endTime != "" || timezone != "" {
+			schedule.Period = &v1.SchedulePeriod{
+				StartTime: startTime,
+				EndTime:   endTime,
+				Days:      []v1.ScheduleDay{v1.MondaySchedule, v1.TuesdaySchedule},
+				Timezone:  timezone,
+			}
+		}
+		if date != "" {
+			schedule.Date = scheduleDate(date)
+		}
+		if endDate != "" {
+			schedule.EndDate = scheduleDate(endDate)
+		}
+
+		// invalid inputs must return errors, not panic.
+		_, _, _ = ScheduleStartEnd(now, schedule, defaultTimeZone)
+		_ = CheckScheduleWindow(schedule, 0)
+	})
+}
@@ -0,0 +1,83 @@
+package scheduledscaling
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	v1 "github.com/zalando-incubator/kube-metrics-adapter/pkg/apis/zalando.org/v1"
+)
+
+var (
+	// ScheduleValue is the highest value of the active schedules of each
+	// [Cluster]ScalingSchedule, 0 if none is active. It's only exported
+	// if enabled by EnableValueMetrics.
+	ScheduleValue = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kube_metrics_adapter_schedule_value",
+		Help: "The highest value of the active schedules of a ScalingSchedule or ClusterScalingSchedule, 0 if none is active",
+	}, []string{"schedule", "kind"})
+	// ScheduleActive is 1 for [Cluster]ScalingSchedules with an active
+	// schedule and 0 otherwise. It's only exported if enabled by
+	// EnableValueMetrics.
+	ScheduleActive = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kube_metrics_adapter_schedule_active",
+		Help: "Whether a schedule of a ScalingSchedule or ClusterScalingSchedule is active",
+	}, []string{"schedule", "kind"})
+)
+
+// scheduleKey identifies a [Cluster]ScalingSchedule by the labels of the
+// value metrics.
+type scheduleKey struct {
+	schedule string
+	kind     string
+}
+
+func newScheduleKey(schedule v1.ScalingScheduler) scheduleKey {
+	kind := "ScalingSchedule"
+	if _, ok := schedule.(*v1.ClusterScalingSchedule); ok {
+		kind = "ClusterScalingSchedule"
+	}
+	return scheduleKey{schedule: schedule.Identifier(), kind: kind}
+}
+
+// EnableValueMetrics exports the current value of every
+// [Cluster]ScalingSchedule, whether it's referenced by an HPA or not, as the
+// ScheduleValue and ScheduleActive metrics, updated every controller loop.
+func (c *Controller) EnableValueMetrics() {
+	c.valueMetrics = map[scheduleKey]struct{}{}
+}
+
+// updateValueMetrics sets the value metrics of the schedules and deletes
+// the metrics of schedules which were deleted or can't be evaluated
+// anymore.
+func (c *Controller) updateValueMetrics(schedules []v1.ScalingScheduler) {
+	if c.valueMetrics == nil {
+		return
+	}
+
+	exported := make(map[scheduleKey]struct{}, len(schedules))
+	for _, schedule := range schedules {
+		// failures are already logged when updating the status.
+		activeSchedules, err := c.activeSchedules(schedule.ResourceSpec())
+		if err != nil {
+			continue
+		}
+
+		value, active := maxActiveValue(activeSchedules, nil)
+		activeValue := 0.0
+		if active {
+			activeValue = 1
+		}
+
+		key := newScheduleKey(schedule)
+		ScheduleValue.WithLabelValues(key.schedule, key.kind).Set(float64(value))
+		ScheduleActive.WithLabelValues(key.schedule, key.kind).Set(activeValue)
+		exported[key] = struct{}{}
+	}
+
+	for key := range c.valueMetrics {
+		if _, ok := exported[key]; !ok {
+			ScheduleValue.DeleteLabelValues(key.schedule, key.kind)
+			ScheduleActive.DeleteLabelValues(key.schedule, key.kind)
+		}
+	}
+	c.valueMetrics = exported
+}
@@ -0,0 +1,84 @@
+package scheduledscaling
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	v1 "github.com/zalando-incubator/kube-metrics-adapter/pkg/apis/zalando.org/v1"
+	zfake "github.com/zalando-incubator/kube-metrics-adapter/pkg/client/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValueMetrics(t *testing.T) {
+	now := time.Date(2024, 1, 2, 10, 30, 0, 0, time.UTC)
+	client := zfake.NewSimpleClientset()
+
+	_, err := client.ZalandoV1().ScalingSchedules("default").Create(context.Background(), &v1.ScalingSchedule{
+		ObjectMeta: metav1.ObjectMeta{Name: "morning", Namespace: "default"},
+		Spec: v1.ScalingScheduleSpec{Schedules: []v1.Schedule{
+			oneTimeSchedule("2024-01-02T10:00:00Z", 60, 50),
+			oneTimeSchedule("2024-01-02T10:15:00Z", 30, 80),
+		}},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	_, err = client.ZalandoV1().ClusterScalingSchedules().Create(context.Background(), &v1.ClusterScalingSchedule{
+		ObjectMeta: metav1.ObjectMeta{Name: "evening"},
+		Spec: v1.ScalingScheduleSpec{
+			// not referenced by any HPA and not pre-scaled.
+			DisablePreScaling: true,
+			Schedules:         []v1.Schedule{oneTimeSchedule("2024-01-02T18:00:00Z", 60, 200)},
+		},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	controller := NewController(client.ZalandoV1(), fake.NewSimpleClientset(), nil, fakeScalingScheduleStore{client: client.ZalandoV1()}, fakeClusterScalingScheduleStore{client: client.ZalandoV1()}, func() time.Time { return now }, 0, "Europe/Berlin", 0.10)
+	controller.EnableValueMetrics()
+
+	requireMetrics := func(schedule, kind string, value, active float64) {
+		t.Helper()
+		require.Equal(t, value, testutil.ToFloat64(ScheduleValue.WithLabelValues(schedule, kind)))
+		require.Equal(t, active, testutil.ToFloat64(ScheduleActive.WithLabelValues(schedule, kind)))
+	}
+
+	err = controller.runOnce(context.Background())
+	require.NoError(t, err)
+	requireMetrics("default/morning", "ScalingSchedule", 80, 1)
+	requireMetrics("evening", "ClusterScalingSchedule", 0, 0)
+
+	now = time.Date(2024, 1, 2, 18, 30, 0, 0, time.UTC)
+	err = controller.runOnce(context.Background())
+	require.NoError(t, err)
+	requireMetrics("default/morning", "ScalingSchedule", 0, 0)
+	requireMetrics("evening", "ClusterScalingSchedule", 200, 1)
+
+	// the metrics of deleted schedules are deleted.
+	err = client.ZalandoV1().ClusterScalingSchedules().Delete(context.Background(), "evening", metav1.DeleteOptions{})
+	require.NoError(t, err)
+	err = controller.runOnce(context.Background())
+	require.NoError(t, err)
+	require.False(t, ScheduleValue.DeleteLabelValues("evening", "ClusterScalingSchedule"))
+	require.False(t, ScheduleActive.DeleteLabelValues("evening", "ClusterScalingSchedule"))
+	requireMetrics("default/morning", "ScalingSchedule", 0, 0)
+}
+
+func TestValueMetricsDisabled(t *testing.T) {
+	now := time.Date(2024, 1, 2, 10, 30, 0, 0, time.UTC)
+	client := zfake.NewSimpleClientset()
+
+	_, err := client.ZalandoV1().ScalingSchedules("default").Create(context.Background(), &v1.ScalingSchedule{
+		ObjectMeta: metav1.ObjectMeta{Name: "disabled", Namespace: "default"},
+		Spec:       v1.ScalingScheduleSpec{Schedules: []v1.Schedule{oneTimeSchedule("2024-01-02T10:00:00Z", 60, 50)}},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	controller := NewController(client.ZalandoV1(), fake.NewSimpleClientset(), nil, fakeScalingScheduleStore{client: client.ZalandoV1()}, fakeClusterScalingScheduleStore{client: client.ZalandoV1()}, func() time.Time { return now }, 0, "Europe/Berlin", 0.10)
+
+	err = controller.runOnce(context.Background())
+	require.NoError(t, err)
+	require.False(t, ScheduleValue.DeleteLabelValues("default/disabled", "ScalingSchedule"))
+}
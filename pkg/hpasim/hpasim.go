@@ -0,0 +1,184 @@
+// Package hpasim simulates the replica calculation of the HPA controller. It
+// computes the replicas an HPA would scale to for given metric values, so the
+// effect of the collected metrics can be asserted in tests and shown to
+// users.
+//
+// Only a single reconciliation is simulated: scaling behaviors, the
+// stabilization window and the readiness of pods are not taken into account.
+package hpasim
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// DefaultTolerance is the default tolerance of the HPA controller.
+const DefaultTolerance = 0.1
+
+// ErrNoValue is returned by a MetricsSource for a metric without a value.
+var ErrNoValue = errors.New("no value")
+
+// MetricValue is the value of a metric of an HPA as the HPA controller gets
+// it from the metrics APIs.
+type MetricValue struct {
+	// Value is the value of an Object metric or the sum of the series of
+	// an External metric.
+	Value resource.Quantity
+	// PodValues are the values of a Pods metric or the resource usage of
+	// the pods of a Resource metric.
+	PodValues []resource.Quantity
+	// PodRequests are the resource requests of the pods of a Resource
+	// metric with a Utilization target.
+	PodRequests []resource.Quantity
+}
+
+// MetricsSource returns the values of the metrics of HPAs.
+type MetricsSource interface {
+	MetricValue(namespace string, metric autoscalingv2.MetricSpec) (MetricValue, error)
+}
+
+// DesiredReplicas computes the replicas of an HPA with the current replicas
+// from the metric values of the source. Like in the HPA controller the
+// replicas are computed per metric, the highest replicas win and the result
+// is clamped to the min and max replicas of the HPA. Metrics without a value
+// or with an unsupported target are skipped. It returns ErrNoValue if none of
+// the metrics could be evaluated.
+func DesiredReplicas(hpa *autoscalingv2.HorizontalPodAutoscaler, currentReplicas int32, source MetricsSource, tolerance float64) (int32, error) {
+	var desired int32
+	found := false
+	for _, metric := range hpa.Spec.Metrics {
+		value, err := source.MetricValue(hpa.Namespace, metric)
+		if err != nil {
+			continue
+		}
+
+		replicas, err := ReplicasForMetric(metric, value, currentReplicas, tolerance)
+		if err != nil {
+			continue
+		}
+
+		if !found || replicas > desired {
+			desired = replicas
+		}
+		found = true
+	}
+
+	if !found {
+		return 0, ErrNoValue
+	}
+
+	minReplicas := int32(1)
+	if hpa.Spec.MinReplicas != nil {
+		minReplicas = *hpa.Spec.MinReplicas
+	}
+
+	return max(minReplicas, min(desired, hpa.Spec.MaxReplicas)), nil
+}
+
+// ReplicasForMetric computes the replicas needed to reach the target of the
+// metric for its value. The current replicas are kept if the usage ratio is
+// within the tolerance.
+func ReplicasForMetric(metric autoscalingv2.MetricSpec, value MetricValue, currentReplicas int32, tolerance float64) (int32, error) {
+	switch {
+	case metric.Type == autoscalingv2.ExternalMetricSourceType && metric.External != nil:
+		return replicasForValue(value.Value, metric.External.Target, currentReplicas, tolerance)
+	case metric.Type == autoscalingv2.ObjectMetricSourceType && metric.Object != nil:
+		return replicasForValue(value.Value, metric.Object.Target, currentReplicas, tolerance)
+	case metric.Type == autoscalingv2.PodsMetricSourceType && metric.Pods != nil:
+		return replicasForPodValues(value.PodValues, metric.Pods.Target, currentReplicas, tolerance)
+	case metric.Type == autoscalingv2.ResourceMetricSourceType && metric.Resource != nil:
+		if metric.Resource.Target.Type == autoscalingv2.UtilizationMetricType {
+			return replicasForUtilization(value.PodValues, value.PodRequests, metric.Resource.Target, currentReplicas, tolerance)
+		}
+		return replicasForPodValues(value.PodValues, metric.Resource.Target, currentReplicas, tolerance)
+	default:
+		return 0, fmt.Errorf("unsupported metric type %s", metric.Type)
+	}
+}
+
+// replicasForValue computes the replicas of an Object or External metric.
+func replicasForValue(value resource.Quantity, target autoscalingv2.MetricTarget, currentReplicas int32, tolerance float64) (int32, error) {
+	switch {
+	case target.Type == autoscalingv2.ValueMetricType && target.Value != nil && target.Value.MilliValue() > 0:
+		// an HPA scaled to zero replicas is considered as one replica.
+		currentReplicas = max(currentReplicas, 1)
+		usageRatio := float64(value.MilliValue()) / float64(target.Value.MilliValue())
+		return scaledReplicas(usageRatio, currentReplicas, tolerance), nil
+	case target.Type == autoscalingv2.AverageValueMetricType && target.AverageValue != nil && target.AverageValue.MilliValue() > 0:
+		replicas := int32(math.Ceil(float64(value.MilliValue()) / float64(target.AverageValue.MilliValue())))
+		if currentReplicas == 0 {
+			return replicas, nil
+		}
+		usageRatio := float64(value.MilliValue()) / (float64(target.AverageValue.MilliValue()) * float64(currentReplicas))
+		if math.Abs(1.0-usageRatio) <= tolerance {
+			return currentReplicas, nil
+		}
+		return replicas, nil
+	default:
+		return 0, fmt.Errorf("unsupported target type %s", target.Type)
+	}
+}
+
+// replicasForPodValues computes the replicas of a Pods metric or a Resource
+// metric with an AverageValue target from the average of the pod values.
+func replicasForPodValues(podValues []resource.Quantity, target autoscalingv2.MetricTarget, currentReplicas int32, tolerance float64) (int32, error) {
+	if target.Type != autoscalingv2.AverageValueMetricType || target.AverageValue == nil || target.AverageValue.MilliValue() <= 0 {
+		return 0, fmt.Errorf("unsupported target type %s", target.Type)
+	}
+	if len(podValues) == 0 {
+		return 0, ErrNoValue
+	}
+
+	usageRatio := float64(sumMilliValues(podValues)) / float64(len(podValues)) / float64(target.AverageValue.MilliValue())
+	return scaledPodReplicas(usageRatio, int32(len(podValues)), currentReplicas, tolerance), nil
+}
+
+// replicasForUtilization computes the replicas of a Resource metric with a
+// Utilization target from the usage and the requests of the pods.
+func replicasForUtilization(podValues, podRequests []resource.Quantity, target autoscalingv2.MetricTarget, currentReplicas int32, tolerance float64) (int32, error) {
+	if target.AverageUtilization == nil || *target.AverageUtilization <= 0 {
+		return 0, fmt.Errorf("missing average utilization")
+	}
+	if len(podValues) == 0 {
+		return 0, ErrNoValue
+	}
+
+	requests := sumMilliValues(podRequests)
+	if requests <= 0 {
+		return 0, fmt.Errorf("missing resource requests of the pods")
+	}
+
+	utilization := float64(sumMilliValues(podValues)) * 100 / float64(requests)
+	usageRatio := utilization / float64(*target.AverageUtilization)
+	return scaledPodReplicas(usageRatio, int32(len(podValues)), currentReplicas, tolerance), nil
+}
+
+// scaledReplicas scales the current replicas by the usage ratio unless it's
+// within the tolerance.
+func scaledReplicas(usageRatio float64, currentReplicas int32, tolerance float64) int32 {
+	if math.Abs(1.0-usageRatio) <= tolerance {
+		return currentReplicas
+	}
+	return int32(math.Ceil(usageRatio * float64(currentReplicas)))
+}
+
+// scaledPodReplicas scales the pods with a value by the usage ratio unless
+// it's within the tolerance.
+func scaledPodReplicas(usageRatio float64, pods, currentReplicas int32, tolerance float64) int32 {
+	if math.Abs(1.0-usageRatio) <= tolerance {
+		return currentReplicas
+	}
+	return int32(math.Ceil(usageRatio * float64(pods)))
+}
+
+func sumMilliValues(values []resource.Quantity) int64 {
+	var sum int64
+	for _, value := range values {
+		sum += value.MilliValue()
+	}
+	return sum
+}
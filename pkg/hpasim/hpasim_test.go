@@ -0,0 +1,295 @@
+package hpasim
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type staticSource map[string]MetricValue
+
+func (s staticSource) MetricValue(_ string, metric autoscalingv2.MetricSpec) (MetricValue, error) {
+	var name string
+	switch metric.Type {
+	case autoscalingv2.ExternalMetricSourceType:
+		name = metric.External.Metric.Name
+	case autoscalingv2.ObjectMetricSourceType:
+		name = metric.Object.Metric.Name
+	case autoscalingv2.PodsMetricSourceType:
+		name = metric.Pods.Metric.Name
+	case autoscalingv2.ResourceMetricSourceType:
+		name = string(metric.Resource.Name)
+	}
+
+	value, ok := s[name]
+	if !ok {
+		return MetricValue{}, ErrNoValue
+	}
+	return value, nil
+}
+
+func quantities(values ...string) []resource.Quantity {
+	result := make([]resource.Quantity, 0, len(values))
+	for _, value := range values {
+		result = append(result, resource.MustParse(value))
+	}
+	return result
+}
+
+func valueTarget(value string) autoscalingv2.MetricTarget {
+	q := resource.MustParse(value)
+	return autoscalingv2.MetricTarget{Type: autoscalingv2.ValueMetricType, Value: &q}
+}
+
+func averageValueTarget(value string) autoscalingv2.MetricTarget {
+	q := resource.MustParse(value)
+	return autoscalingv2.MetricTarget{Type: autoscalingv2.AverageValueMetricType, AverageValue: &q}
+}
+
+func utilizationTarget(utilization int32) autoscalingv2.MetricTarget {
+	return autoscalingv2.MetricTarget{Type: autoscalingv2.UtilizationMetricType, AverageUtilization: &utilization}
+}
+
+func externalMetric(name string, target autoscalingv2.MetricTarget) autoscalingv2.MetricSpec {
+	return autoscalingv2.MetricSpec{
+		Type: autoscalingv2.ExternalMetricSourceType,
+		External: &autoscalingv2.ExternalMetricSource{
+			Metric: autoscalingv2.MetricIdentifier{Name: name},
+			Target: target,
+		},
+	}
+}
+
+func objectMetric(name string, target autoscalingv2.MetricTarget) autoscalingv2.MetricSpec {
+	return autoscalingv2.MetricSpec{
+		Type: autoscalingv2.ObjectMetricSourceType,
+		Object: &autoscalingv2.ObjectMetricSource{
+			Metric: autoscalingv2.MetricIdentifier{Name: name},
+			Target: target,
+		},
+	}
+}
+
+func podsMetric(name string, target autoscalingv2.MetricTarget) autoscalingv2.MetricSpec {
+	return autoscalingv2.MetricSpec{
+		Type: autoscalingv2.PodsMetricSourceType,
+		Pods: &autoscalingv2.PodsMetricSource{
+			Metric: autoscalingv2.MetricIdentifier{Name: name},
+			Target: target,
+		},
+	}
+}
+
+func resourceMetric(name corev1.ResourceName, target autoscalingv2.MetricTarget) autoscalingv2.MetricSpec {
+	return autoscalingv2.MetricSpec{
+		Type: autoscalingv2.ResourceMetricSourceType,
+		Resource: &autoscalingv2.ResourceMetricSource{
+			Name:   name,
+			Target: target,
+		},
+	}
+}
+
+func TestReplicasForMetric(t *testing.T) {
+	for _, tc := range []struct {
+		msg             string
+		metric          autoscalingv2.MetricSpec
+		value           MetricValue
+		currentReplicas int32
+		expected        int32
+		expectError     bool
+	}{
+		{
+			msg:             "Value scales the current replicas by value/target",
+			metric:          objectMetric("rps", valueTarget("100")),
+			value:           MetricValue{Value: resource.MustParse("250")},
+			currentReplicas: 3,
+			expected:        8, // ceil(3 * 250 / 100)
+		},
+		{
+			msg:             "Value of an HPA scaled to zero",
+			metric:          externalMetric("lag", valueTarget("100")),
+			value:           MetricValue{Value: resource.MustParse("250")},
+			currentReplicas: 0,
+			expected:        3,
+		},
+		{
+			msg:             "AverageValue divides the value by the target",
+			metric:          externalMetric("jobs", averageValueTarget("10")),
+			value:           MetricValue{Value: resource.MustParse("55")},
+			currentReplicas: 2,
+			expected:        6,
+		},
+		{
+			msg:             "AverageValue without current replicas",
+			metric:          objectMetric("jobs", averageValueTarget("10")),
+			value:           MetricValue{Value: resource.MustParse("55")},
+			currentReplicas: 0,
+			expected:        6,
+		},
+		{
+			msg:             "Value within the tolerance keeps the current replicas",
+			metric:          objectMetric("rps", valueTarget("100")),
+			value:           MetricValue{Value: resource.MustParse("109")},
+			currentReplicas: 4,
+			expected:        4,
+		},
+		{
+			msg:             "AverageValue within the tolerance keeps the current replicas",
+			metric:          externalMetric("jobs", averageValueTarget("10")),
+			value:           MetricValue{Value: resource.MustParse("42")},
+			currentReplicas: 4,
+			expected:        4,
+		},
+		{
+			msg:             "Pods metric scales the pods by the average pod value",
+			metric:          podsMetric("queue", averageValueTarget("10")),
+			value:           MetricValue{PodValues: quantities("20", "30", "40")},
+			currentReplicas: 3,
+			expected:        9, // ceil(3 * 30 / 10)
+		},
+		{
+			msg:             "Pods metric without pod values",
+			metric:          podsMetric("queue", averageValueTarget("10")),
+			currentReplicas: 3,
+			expectError:     true,
+		},
+		{
+			msg:    "Resource metric with Utilization target",
+			metric: resourceMetric(corev1.ResourceCPU, utilizationTarget(50)),
+			value: MetricValue{
+				PodValues:   quantities("400m", "500m"),
+				PodRequests: quantities("500m", "500m"),
+			},
+			currentReplicas: 2,
+			expected:        4, // ceil(2 * 90% / 50%)
+		},
+		{
+			msg:             "Resource metric with Utilization target without requests",
+			metric:          resourceMetric(corev1.ResourceCPU, utilizationTarget(50)),
+			value:           MetricValue{PodValues: quantities("400m", "500m")},
+			currentReplicas: 2,
+			expectError:     true,
+		},
+		{
+			msg:             "Resource metric with AverageValue target",
+			metric:          resourceMetric(corev1.ResourceMemory, averageValueTarget("1Gi")),
+			value:           MetricValue{PodValues: quantities("2Gi", "3Gi")},
+			currentReplicas: 2,
+			expected:        5, // ceil(2 * 2.5Gi / 1Gi)
+		},
+		{
+			msg:             "unsupported target",
+			metric:          externalMetric("jobs", utilizationTarget(50)),
+			value:           MetricValue{Value: resource.MustParse("55")},
+			currentReplicas: 2,
+			expectError:     true,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			replicas, err := ReplicasForMetric(tc.metric, tc.value, tc.currentReplicas, DefaultTolerance)
+			if tc.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, replicas)
+		})
+	}
+}
+
+func TestDesiredReplicas(t *testing.T) {
+	minReplicas := int32(3)
+	for _, tc := range []struct {
+		msg             string
+		metrics         []autoscalingv2.MetricSpec
+		source          staticSource
+		currentReplicas int32
+		minReplicas     *int32
+		maxReplicas     int32
+		expected        int32
+		expectError     bool
+	}{
+		{
+			msg: "highest replicas over all metrics win",
+			metrics: []autoscalingv2.MetricSpec{
+				externalMetric("jobs", averageValueTarget("10")),
+				objectMetric("rps", valueTarget("100")),
+			},
+			source: staticSource{
+				"jobs": {Value: resource.MustParse("30")},
+				"rps":  {Value: resource.MustParse("300")},
+			},
+			currentReplicas: 2,
+			maxReplicas:     20,
+			expected:        6, // max(ceil(30 / 10), ceil(2 * 300 / 100))
+		},
+		{
+			msg:             "clamped to max replicas",
+			metrics:         []autoscalingv2.MetricSpec{externalMetric("jobs", averageValueTarget("10"))},
+			source:          staticSource{"jobs": {Value: resource.MustParse("1000")}},
+			currentReplicas: 2,
+			maxReplicas:     10,
+			expected:        10,
+		},
+		{
+			msg:             "clamped to min replicas",
+			metrics:         []autoscalingv2.MetricSpec{externalMetric("jobs", averageValueTarget("10"))},
+			source:          staticSource{"jobs": {Value: resource.MustParse("1")}},
+			currentReplicas: 5,
+			minReplicas:     &minReplicas,
+			maxReplicas:     10,
+			expected:        3,
+		},
+		{
+			msg:             "min replicas default to one",
+			metrics:         []autoscalingv2.MetricSpec{externalMetric("jobs", averageValueTarget("10"))},
+			source:          staticSource{"jobs": {Value: resource.MustParse("0")}},
+			currentReplicas: 5,
+			maxReplicas:     10,
+			expected:        1,
+		},
+		{
+			msg: "metrics without values are skipped",
+			metrics: []autoscalingv2.MetricSpec{
+				externalMetric("jobs", averageValueTarget("10")),
+				objectMetric("rps", valueTarget("100")),
+			},
+			source:          staticSource{"rps": {Value: resource.MustParse("300")}},
+			currentReplicas: 2,
+			maxReplicas:     20,
+			expected:        6,
+		},
+		{
+			msg:             "no metric with a value",
+			metrics:         []autoscalingv2.MetricSpec{externalMetric("jobs", averageValueTarget("10"))},
+			source:          staticSource{},
+			currentReplicas: 2,
+			maxReplicas:     20,
+			expectError:     true,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			hpa := &autoscalingv2.HorizontalPodAutoscaler{
+				ObjectMeta: metav1.ObjectMeta{Name: "hpa1", Namespace: "default"},
+				Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+					MinReplicas: tc.minReplicas,
+					MaxReplicas: tc.maxReplicas,
+					Metrics:     tc.metrics,
+				},
+			}
+
+			replicas, err := DesiredReplicas(hpa, tc.currentReplicas, tc.source, DefaultTolerance)
+			if tc.expectError {
+				require.ErrorIs(t, err, ErrNoValue)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, replicas)
+		})
+	}
+}
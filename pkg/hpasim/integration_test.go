@@ -0,0 +1,327 @@
+package hpasim_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	rgv1 "github.com/szuecs/routegroup-client/apis/zalando.org/v1"
+	rgfake "github.com/szuecs/routegroup-client/client/clientset/versioned/fake"
+	v1 "github.com/zalando-incubator/kube-metrics-adapter/pkg/apis/zalando.org/v1"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/hpasim"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/provider"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	netv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/metrics/pkg/apis/custom_metrics"
+)
+
+const backendWeightsAnnotation = "zalando.org/backend-weights"
+
+// weightedQuery matches the backend weight the skipper collector multiplies
+// the requests per second with.
+var weightedQuery = regexp.MustCompile(`\* ([0-9.]+)\)$`)
+
+// prometheusPlugin fakes the prometheus collector plugin used by the
+// skipper collector. The collected value is the requests per second of all
+// backends multiplied by the weight of the query.
+type prometheusPlugin struct {
+	rps float64
+}
+
+func (p *prometheusPlugin) NewCollector(_ context.Context, _ *autoscalingv2.HorizontalPodAutoscaler, config *collector.MetricConfig, interval time.Duration) (collector.Collector, error) {
+	match := weightedQuery.FindStringSubmatch(config.Config["query"])
+	if match == nil {
+		return nil, fmt.Errorf("unexpected query %s", config.Config["query"])
+	}
+	weight, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return nil, err
+	}
+
+	return &prometheusCollector{
+		value: collector.CollectedMetric{
+			Type: autoscalingv2.ObjectMetricSourceType,
+			Custom: custom_metrics.MetricValue{
+				DescribedObject: config.ObjectReference,
+				Metric:          custom_metrics.MetricIdentifier{Name: config.Metric.Name},
+				Timestamp:       metav1.Now(),
+				Value:           *resource.NewMilliQuantity(int64(p.rps*weight*1000), resource.DecimalSI),
+			},
+		},
+		interval: interval,
+	}, nil
+}
+
+type prometheusCollector struct {
+	value    collector.CollectedMetric
+	interval time.Duration
+}
+
+func (c *prometheusCollector) GetMetrics(_ context.Context) ([]collector.CollectedMetric, error) {
+	return []collector.CollectedMetric{c.value}, nil
+}
+
+func (c *prometheusCollector) Interval() time.Duration {
+	return c.interval
+}
+
+func newMetricStore() *provider.MetricStore {
+	return provider.NewMetricStore(func() time.Time { return time.Now().Add(time.Hour) })
+}
+
+func rpsHPA(kind, apiVersion, backend string, target autoscalingv2.MetricTarget) *autoscalingv2.HorizontalPodAutoscaler {
+	return &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
+				Kind: "Deployment",
+				Name: backend,
+			},
+			MaxReplicas: 100,
+			Metrics: []autoscalingv2.MetricSpec{
+				{
+					Type: autoscalingv2.ObjectMetricSourceType,
+					Object: &autoscalingv2.ObjectMetricSource{
+						DescribedObject: autoscalingv2.CrossVersionObjectReference{Name: "app", APIVersion: apiVersion, Kind: kind},
+						Metric:          autoscalingv2.MetricIdentifier{Name: "requests-per-second," + backend},
+						Target:          target,
+					},
+				},
+			},
+		},
+	}
+}
+
+// TestSkipperReplicas asserts the replicas of HPAs scaling a backend on the
+// weighted requests per second of an Ingress or RouteGroup. The faked
+// average of a Value target must result in the same replicas as an
+// AverageValue target.
+func TestSkipperReplicas(t *testing.T) {
+	averageValue := resource.MustParse("10")
+	value := resource.MustParse("10")
+
+	for _, tc := range []struct {
+		msg             string
+		kind            string
+		weights         map[string]float64
+		target          autoscalingv2.MetricTarget
+		currentReplicas int32
+		expected        int32
+	}{
+		{
+			msg:             "Ingress without weights",
+			kind:            "Ingress",
+			target:          autoscalingv2.MetricTarget{Type: autoscalingv2.AverageValueMetricType, AverageValue: &averageValue},
+			currentReplicas: 5,
+			expected:        100,
+		},
+		{
+			msg:             "weighted Ingress",
+			kind:            "Ingress",
+			weights:         map[string]float64{"app-v1": 40, "app-v2": 60},
+			target:          autoscalingv2.MetricTarget{Type: autoscalingv2.AverageValueMetricType, AverageValue: &averageValue},
+			currentReplicas: 5,
+			expected:        40,
+		},
+		{
+			msg:             "weighted Ingress with faked average",
+			kind:            "Ingress",
+			weights:         map[string]float64{"app-v1": 40, "app-v2": 60},
+			target:          autoscalingv2.MetricTarget{Type: autoscalingv2.ValueMetricType, Value: &value},
+			currentReplicas: 5,
+			expected:        40,
+		},
+		{
+			msg:             "weighted Ingress within the tolerance",
+			kind:            "Ingress",
+			weights:         map[string]float64{"app-v1": 40, "app-v2": 60},
+			target:          autoscalingv2.MetricTarget{Type: autoscalingv2.AverageValueMetricType, AverageValue: &averageValue},
+			currentReplicas: 38,
+			expected:        38,
+		},
+		{
+			msg:             "Ingress without traffic to the backend",
+			kind:            "Ingress",
+			weights:         map[string]float64{"app-v1": 0, "app-v2": 100},
+			target:          autoscalingv2.MetricTarget{Type: autoscalingv2.AverageValueMetricType, AverageValue: &averageValue},
+			currentReplicas: 5,
+			expected:        1,
+		},
+		{
+			msg:             "weighted RouteGroup",
+			kind:            "RouteGroup",
+			weights:         map[string]float64{"app-v1": 25, "app-v2": 75},
+			target:          autoscalingv2.MetricTarget{Type: autoscalingv2.AverageValueMetricType, AverageValue: &averageValue},
+			currentReplicas: 5,
+			expected:        25,
+		},
+		{
+			msg:             "weighted RouteGroup with faked average",
+			kind:            "RouteGroup",
+			weights:         map[string]float64{"app-v1": 25, "app-v2": 75},
+			target:          autoscalingv2.MetricTarget{Type: autoscalingv2.ValueMetricType, Value: &value},
+			currentReplicas: 5,
+			expected:        25,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			rgClient := rgfake.NewSimpleClientset()
+
+			_, err := client.AppsV1().Deployments("default").Create(context.Background(), &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "app-v1", Namespace: "default"},
+				Status:     appsv1.DeploymentStatus{Replicas: tc.currentReplicas},
+			}, metav1.CreateOptions{})
+			require.NoError(t, err)
+
+			apiVersion := "networking.k8s.io/v1"
+			if tc.kind == "Ingress" {
+				annotations := map[string]string{}
+				if tc.weights != nil {
+					weights, err := json.Marshal(tc.weights)
+					require.NoError(t, err)
+					annotations[backendWeightsAnnotation] = string(weights)
+				}
+				_, err = client.NetworkingV1().Ingresses("default").Create(context.Background(), &netv1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: annotations},
+					Spec:       netv1.IngressSpec{Rules: []netv1.IngressRule{{Host: "app.example.org"}}},
+				}, metav1.CreateOptions{})
+				require.NoError(t, err)
+			} else {
+				apiVersion = "zalando.org/v1"
+				var backends []rgv1.RouteGroupBackendReference
+				for backend, weight := range tc.weights {
+					backends = append(backends, rgv1.RouteGroupBackendReference{BackendName: backend, Weight: int(weight)})
+				}
+				_, err = rgClient.ZalandoV1().RouteGroups("default").Create(context.Background(), &rgv1.RouteGroup{
+					ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+					Spec: rgv1.RouteGroupSpec{
+						Hosts:           []string{"app.example.org"},
+						DefaultBackends: backends,
+					},
+				}, metav1.CreateOptions{})
+				require.NoError(t, err)
+			}
+
+			hpa := rpsHPA(tc.kind, apiVersion, "app-v1", tc.target)
+			configs, err := collector.ParseHPAMetrics(hpa)
+			require.NoError(t, err)
+			require.Len(t, configs, 1)
+
+			c, err := collector.NewSkipperCollector(client, rgClient, &prometheusPlugin{rps: 1000}, hpa, configs[0], time.Minute, []string{backendWeightsAnnotation}, "app-v1")
+			require.NoError(t, err)
+
+			values, err := c.GetMetrics(context.Background())
+			require.NoError(t, err)
+
+			store := newMetricStore()
+			for _, value := range values {
+				store.Insert(value)
+			}
+
+			replicas, err := hpasim.DesiredReplicas(hpa, tc.currentReplicas, store, hpasim.DefaultTolerance)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, replicas)
+		})
+	}
+}
+
+// TestScalingScheduleReplicas asserts the replicas of an HPA while a
+// ScalingSchedule ramps up and down. The replicas of each step are the
+// current replicas of the next one, like in consecutive reconciliations of
+// the HPA controller.
+func TestScalingScheduleReplicas(t *testing.T) {
+	date := v1.ScheduleDate("2026-10-15T12:00:00Z")
+	scalingWindowMinutes := int64(10)
+	schedule := &v1.ScalingSchedule{
+		ObjectMeta: metav1.ObjectMeta{Name: "campaign", Namespace: "default"},
+		Spec: v1.ScalingScheduleSpec{
+			ScalingWindowDurationMinutes: &scalingWindowMinutes,
+			Schedules: []v1.Schedule{
+				{
+					Type:            v1.OneTimeSchedule,
+					Date:            &date,
+					DurationMinutes: 60,
+					Value:           100,
+				},
+			},
+		},
+	}
+
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	require.NoError(t, store.Add(schedule))
+
+	averageValue := resource.MustParse("10")
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{Kind: "Deployment", Name: "app"},
+			MaxReplicas:    20,
+			Metrics: []autoscalingv2.MetricSpec{
+				{
+					Type: autoscalingv2.ObjectMetricSourceType,
+					Object: &autoscalingv2.ObjectMetricSource{
+						DescribedObject: autoscalingv2.CrossVersionObjectReference{
+							Name:       "campaign",
+							APIVersion: "zalando.org/v1",
+							Kind:       "ScalingSchedule",
+						},
+						Metric: autoscalingv2.MetricIdentifier{Name: "campaign"},
+						Target: autoscalingv2.MetricTarget{Type: autoscalingv2.AverageValueMetricType, AverageValue: &averageValue},
+					},
+				},
+			},
+		},
+	}
+
+	configs, err := collector.ParseHPAMetrics(hpa)
+	require.NoError(t, err)
+	require.Len(t, configs, 1)
+
+	start := time.Date(2026, time.October, 15, 12, 0, 0, 0, time.UTC)
+	currentReplicas := int32(1)
+	for _, step := range []struct {
+		offset   time.Duration
+		expected int32
+	}{
+		{offset: -15 * time.Minute, expected: 1},
+		{offset: -10 * time.Minute, expected: 1},
+		{offset: -7 * time.Minute, expected: 3},
+		{offset: -5 * time.Minute, expected: 5},
+		{offset: -1 * time.Minute, expected: 9},
+		{offset: 0, expected: 10},
+		{offset: 30 * time.Minute, expected: 10},
+		{offset: 60 * time.Minute, expected: 10},
+		{offset: 65 * time.Minute, expected: 5},
+		{offset: 69 * time.Minute, expected: 1},
+		{offset: 70 * time.Minute, expected: 1},
+	} {
+		now := start.Add(step.offset)
+		c, err := collector.NewScalingScheduleCollector(store, 10*time.Minute, "UTC", 10, func() time.Time { return now }, hpa, configs[0], time.Minute)
+		require.NoError(t, err)
+
+		values, err := c.GetMetrics(context.Background())
+		require.NoError(t, err)
+
+		metrics := newMetricStore()
+		for _, value := range values {
+			metrics.Insert(value)
+		}
+
+		replicas, err := hpasim.DesiredReplicas(hpa, currentReplicas, metrics, hpasim.DefaultTolerance)
+		require.NoError(t, err)
+		require.Equal(t, step.expected, replicas, "replicas at %s", now)
+		currentReplicas = replicas
+	}
+}
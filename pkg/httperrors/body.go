@@ -0,0 +1,39 @@
+package httperrors
+
+import (
+	"io"
+)
+
+const (
+	// DefaultMaxBodyLength is the default maximum number of bytes of a
+	// response body included in errors.
+	DefaultMaxBodyLength = 1024
+
+	truncatedMarker = "... (truncated)"
+)
+
+// Truncate returns the body as a string of at most maxLength bytes. A
+// truncated body is marked with an ellipsis. A maxLength <= 0 disables the
+// truncation.
+func Truncate(body []byte, maxLength int) string {
+	if maxLength <= 0 || len(body) <= maxLength {
+		return string(body)
+	}
+	return string(body[:maxLength]) + truncatedMarker
+}
+
+// ReadBody reads the body for including it in an error. At most maxLength
+// bytes are read so oversized error pages are not loaded into memory. A
+// maxLength <= 0 reads the full body.
+func ReadBody(body io.Reader, maxLength int) (string, error) {
+	if maxLength > 0 {
+		body = io.LimitReader(body, int64(maxLength)+1)
+	}
+
+	d, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+
+	return Truncate(d, maxLength), nil
+}
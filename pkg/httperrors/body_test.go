@@ -0,0 +1,24 @@
+package httperrors
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncate(t *testing.T) {
+	require.Equal(t, "abc", Truncate([]byte("abc"), 3))
+	require.Equal(t, "ab... (truncated)", Truncate([]byte("abc"), 2))
+	require.Equal(t, "abc", Truncate([]byte("abc"), 0))
+}
+
+func TestReadBody(t *testing.T) {
+	body, err := ReadBody(strings.NewReader(strings.Repeat("x", 2*1024*1024)), DefaultMaxBodyLength)
+	require.NoError(t, err)
+	require.Equal(t, strings.Repeat("x", DefaultMaxBodyLength)+"... (truncated)", body)
+
+	body, err = ReadBody(strings.NewReader("error"), DefaultMaxBodyLength)
+	require.NoError(t, err)
+	require.Equal(t, "error", body)
+}
@@ -0,0 +1,63 @@
+package httperrors
+
+import (
+	"fmt"
+	"io"
+)
+
+// DefaultMaxResponseSize is the default maximum number of bytes read from a
+// response body.
+const DefaultMaxResponseSize int64 = 1 << 20
+
+// TooLargeError is returned when a response body exceeds the maximum
+// response size.
+type TooLargeError struct {
+	Limit int64
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("response body exceeds the maximum size of %d bytes", e.Limit)
+}
+
+// limitedReader reads at most limit bytes and fails with a TooLargeError
+// once more are available.
+type limitedReader struct {
+	r         io.Reader
+	limit     int64
+	remaining int64
+}
+
+// LimitReader returns a reader reading at most limit bytes of the body.
+// Reading beyond the limit fails with a TooLargeError, so unlike
+// io.LimitReader an oversized body isn't silently truncated. A limit <= 0
+// disables the limit.
+func LimitReader(body io.Reader, limit int64) io.Reader {
+	if limit <= 0 {
+		return body
+	}
+	return &limitedReader{r: body, limit: limit, remaining: limit}
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, &TooLargeError{Limit: l.limit}
+	}
+
+	// read one byte more than remaining to detect an oversized body.
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n - 1, &TooLargeError{Limit: l.limit}
+	}
+	return n, err
+}
+
+// ReadAll reads the body of at most limit bytes. A larger body fails with a
+// TooLargeError without reading more than limit+1 bytes. A limit <= 0 reads
+// the full body.
+func ReadAll(body io.Reader, limit int64) ([]byte, error) {
+	return io.ReadAll(LimitReader(body, limit))
+}
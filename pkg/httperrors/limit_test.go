@@ -0,0 +1,71 @@
+package httperrors
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// endlessReader returns an endless body and counts the bytes read.
+type endlessReader struct {
+	read int64
+}
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	r.read += int64(len(p))
+	return len(p), nil
+}
+
+func TestReadAll(t *testing.T) {
+	data, err := ReadAll(strings.NewReader("abcd"), 4)
+	require.NoError(t, err)
+	require.Equal(t, "abcd", string(data))
+
+	_, err = ReadAll(strings.NewReader("abcde"), 4)
+	var tooLarge *TooLargeError
+	require.True(t, errors.As(err, &tooLarge))
+	require.Equal(t, int64(4), tooLarge.Limit)
+	require.EqualError(t, err, "response body exceeds the maximum size of 4 bytes")
+
+	data, err = ReadAll(strings.NewReader("abcde"), 0)
+	require.NoError(t, err)
+	require.Equal(t, "abcde", string(data))
+}
+
+func TestReadAllBoundedRead(t *testing.T) {
+	body := &endlessReader{}
+	_, err := ReadAll(body, DefaultMaxResponseSize)
+	var tooLarge *TooLargeError
+	require.True(t, errors.As(err, &tooLarge))
+	require.Equal(t, DefaultMaxResponseSize+1, body.read)
+}
+
+func TestLimitReader(t *testing.T) {
+	reader := LimitReader(strings.NewReader("abcdef"), 4)
+	buf := make([]byte, 3)
+
+	n, err := reader.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+
+	// the bytes within the limit are returned with the error.
+	n, err = reader.Read(buf)
+	require.Equal(t, 1, n)
+	require.Equal(t, "d", string(buf[:n]))
+	require.IsType(t, &TooLargeError{}, err)
+
+	n, err = reader.Read(buf)
+	require.Equal(t, 0, n)
+	require.IsType(t, &TooLargeError{}, err)
+
+	// a body of exactly the limit ends with io.EOF.
+	data, err := io.ReadAll(LimitReader(strings.NewReader("abcd"), 4))
+	require.NoError(t, err)
+	require.Equal(t, "abcd", string(data))
+}
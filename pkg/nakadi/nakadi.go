@@ -8,44 +8,157 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/httperrors"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/sleep"
+)
+
+// UnassignedPartitions defines how partitions without an assigned stream
+// are considered when computing the consumer lag.
+type UnassignedPartitions string
+
+const (
+	// UnassignedPartitionsIgnore ignores unassigned partitions.
+	UnassignedPartitionsIgnore UnassignedPartitions = "ignore"
+	// UnassignedPartitionsMax considers the lag of unassigned partitions
+	// for the max consumer lag like the lag of assigned partitions.
+	// Unassigned partitions without lag get the max lag of the assigned
+	// partitions.
+	UnassignedPartitionsMax UnassignedPartitions = "max"
+	// UnassignedPartitionsError fails if any partition is unassigned.
+	UnassignedPartitionsError UnassignedPartitions = "error"
+)
+
+// ParseUnassignedPartitions parses an UnassignedPartitions policy.
+func ParseUnassignedPartitions(value string) (UnassignedPartitions, error) {
+	switch policy := UnassignedPartitions(value); policy {
+	case UnassignedPartitionsIgnore, UnassignedPartitionsMax, UnassignedPartitionsError:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown unassigned partitions policy '%s', must be one of %s, %s, %s", value, UnassignedPartitionsIgnore, UnassignedPartitionsMax, UnassignedPartitionsError)
+	}
+}
+
+const (
+	defaultMaxRetries     = 3
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 5 * time.Second
 )
 
 // Nakadi defines an interface for talking to the Nakadi API.
 type Nakadi interface {
-	ConsumerLagSeconds(ctx context.Context, subscriptionID string) (int64, error)
+	ConsumerLagSeconds(ctx context.Context, subscriptionID string, unassigned UnassignedPartitions) (int64, error)
 	UnconsumedEvents(ctx context.Context, subscriptionID string) (int64, error)
 }
 
 // Client defines client for interfacing with the Nakadi API.
 type Client struct {
-	nakadiEndpoint string
-	http           *http.Client
+	nakadiEndpoint     string
+	http               *http.Client
+	maxErrorBodyLength int
+	maxResponseSize    int64
+	maxRetries         int
+	initialBackoff     time.Duration
+	maxBackoff         time.Duration
+	sleep              func(ctx context.Context, d time.Duration) error
 }
 
 // NewNakadiClient initializes a new Nakadi Client.
 func NewNakadiClient(nakadiEndpoint string, client *http.Client) *Client {
 	return &Client{
-		nakadiEndpoint: nakadiEndpoint,
-		http:           client,
+		nakadiEndpoint:     nakadiEndpoint,
+		http:               client,
+		maxErrorBodyLength: httperrors.DefaultMaxBodyLength,
+		maxResponseSize:    httperrors.DefaultMaxResponseSize,
+		maxRetries:         defaultMaxRetries,
+		initialBackoff:     defaultInitialBackoff,
+		maxBackoff:         defaultMaxBackoff,
+		sleep:              sleep.Context,
 	}
 }
 
-func (c *Client) ConsumerLagSeconds(ctx context.Context, subscriptionID string) (int64, error) {
+// SetMaxErrorBodyLength sets the maximum number of bytes of a response body
+// included in errors. A length <= 0 includes the full body.
+func (c *Client) SetMaxErrorBodyLength(length int) {
+	c.maxErrorBodyLength = length
+}
+
+// SetMaxResponseSize sets the maximum number of bytes read from a response
+// body. Larger responses fail with a httperrors.TooLargeError. A size <= 0
+// disables the limit.
+func (c *Client) SetMaxResponseSize(size int64) {
+	c.maxResponseSize = size
+}
+
+// SetRetries configures the retries of requests failing with 429 or 5xx
+// responses. The backoff starts at initialBackoff and doubles with each
+// retry up to maxBackoff. A Retry-After header of the response takes
+// precedence but is also bounded by maxBackoff. maxRetries <= 0 disables
+// retries.
+func (c *Client) SetRetries(maxRetries int, initialBackoff, maxBackoff time.Duration) {
+	c.maxRetries = maxRetries
+	c.initialBackoff = initialBackoff
+	c.maxBackoff = maxBackoff
+}
+
+// ConsumerLagSeconds returns the max consumer lag over all partitions of
+// the subscription. Partitions not reporting a lag are skipped, unassigned
+// partitions are handled according to the unassigned policy. With the max
+// policy unassigned partitions not reporting a lag, which nobody consumes,
+// are considered to lag as much as the max lag of the assigned partitions.
+func (c *Client) ConsumerLagSeconds(ctx context.Context, subscriptionID string, unassigned UnassignedPartitions) (int64, error) {
 	stats, err := c.stats(ctx, subscriptionID)
 	if err != nil {
 		return 0, err
 	}
 
-	var maxConsumerLagSeconds int64
+	var maxAssignedLagSeconds, maxUnassignedLagSeconds int64
+	considered, reported, assignedReported, unassignedWithoutLag := 0, 0, 0, 0
 	for _, eventType := range stats {
 		for _, partition := range eventType.Partitions {
-			maxConsumerLagSeconds = max(maxConsumerLagSeconds, partition.ConsumerLagSeconds)
+			assigned := partition.assigned()
+			if !assigned {
+				switch unassigned {
+				case UnassignedPartitionsIgnore:
+					continue
+				case UnassignedPartitionsError:
+					return 0, fmt.Errorf("partition %s of event type %s is not assigned to a stream", partition.Partition, eventType.EventType)
+				}
+			}
+
+			considered++
+			if partition.ConsumerLagSeconds == nil {
+				if !assigned {
+					unassignedWithoutLag++
+				}
+				continue
+			}
+			reported++
+			if assigned {
+				assignedReported++
+				maxAssignedLagSeconds = max(maxAssignedLagSeconds, *partition.ConsumerLagSeconds)
+			} else {
+				maxUnassignedLagSeconds = max(maxUnassignedLagSeconds, *partition.ConsumerLagSeconds)
+			}
 		}
 	}
 
-	return maxConsumerLagSeconds, nil
+	if considered > 0 && reported == 0 {
+		return 0, errors.New("no partition reported consumer_lag_seconds")
+	}
+
+	if unassignedWithoutLag > 0 && assignedReported == 0 {
+		return 0, fmt.Errorf("no assigned partition reported consumer_lag_seconds for the %d unassigned partition(s) without lag", unassignedWithoutLag)
+	}
+
+	return max(maxAssignedLagSeconds, maxUnassignedLagSeconds), nil
 }
 
+// UnconsumedEvents returns the sum of unconsumed events over all partitions
+// of the subscription. Partitions not reporting unconsumed events are
+// skipped.
 func (c *Client) UnconsumedEvents(ctx context.Context, subscriptionID string) (int64, error) {
 	stats, err := c.stats(ctx, subscriptionID)
 	if err != nil {
@@ -53,12 +166,22 @@ func (c *Client) UnconsumedEvents(ctx context.Context, subscriptionID string) (i
 	}
 
 	var unconsumedEvents int64
+	considered, reported := 0, 0
 	for _, eventType := range stats {
 		for _, partition := range eventType.Partitions {
-			unconsumedEvents += partition.UnconsumedEvents
+			considered++
+			if partition.UnconsumedEvents == nil {
+				continue
+			}
+			reported++
+			unconsumedEvents += *partition.UnconsumedEvents
 		}
 	}
 
+	if considered > 0 && reported == 0 {
+		return 0, errors.New("no partition reported unconsumed_events")
+	}
+
 	return unconsumedEvents, nil
 }
 
@@ -71,15 +194,23 @@ type statsEventType struct {
 	Partitions []statsPartition `json:"partitions"`
 }
 
+// statsPartition are the stats of a partition. Nakadi omits the stats it
+// doesn't know, e.g. the consumer lag of unassigned partitions, so they
+// are pointers to distinguish missing values from zero.
 type statsPartition struct {
-	Partiton           string `json:"partition"`
+	Partition          string `json:"partition"`
 	State              string `json:"state"`
-	UnconsumedEvents   int64  `json:"unconsumed_events"`
-	ConsumerLagSeconds int64  `json:"consumer_lag_seconds"`
+	UnconsumedEvents   *int64 `json:"unconsumed_events"`
+	ConsumerLagSeconds *int64 `json:"consumer_lag_seconds"`
 	StreamID           string `json:"stream_id"`
 	AssignmentType     string `json:"assignment_type"`
 }
 
+// assigned returns true if a stream consumes the partition.
+func (p statsPartition) assigned() bool {
+	return p.State != "unassigned" && p.StreamID != ""
+}
+
 // stats returns the Nakadi stats for a given subscription ID.
 //
 // https://nakadi.io/manual.html#/subscriptions/subscription_id/stats_get
@@ -95,21 +226,17 @@ func (c *Client) stats(ctx context.Context, subscriptionID string) ([]statsEvent
 	q.Set("show_time_lag", "true")
 	endpoint.RawQuery = q.Encode()
 
-	resp, err := c.http.Get(endpoint.String())
+	resp, err := c.get(ctx, endpoint.String())
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	d, err := io.ReadAll(resp.Body)
+	d, err := httperrors.ReadAll(resp.Body, c.maxResponseSize)
 	if err != nil {
 		return nil, err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("[nakadi stats] unexpected response code: %d (%s)", resp.StatusCode, string(d))
-	}
-
 	var result statsResp
 	err = json.Unmarshal(d, &result)
 	if err != nil {
@@ -122,3 +249,74 @@ func (c *Client) stats(ctx context.Context, subscriptionID string) ([]statsEvent
 
 	return result.Items, nil
 }
+
+// get requests the endpoint retrying 429 and 5xx responses with exponential
+// backoff. The response of the last attempt is returned if all attempts
+// fail, non-OK responses are turned into errors.
+func (c *Client) get(ctx context.Context, endpoint string) (*http.Response, error) {
+	backoff := c.initialBackoff
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+
+		if attempt >= c.maxRetries || !retryable(resp.StatusCode) {
+			defer resp.Body.Close()
+			body, err := httperrors.ReadBody(resp.Body, c.maxErrorBodyLength)
+			if err != nil {
+				return nil, fmt.Errorf("[nakadi stats] unexpected response code: %d", resp.StatusCode)
+			}
+			return nil, fmt.Errorf("[nakadi stats] unexpected response code: %d (%s)", resp.StatusCode, body)
+		}
+
+		wait := backoff
+		if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			wait = retryAfter
+		}
+		wait = min(wait, c.maxBackoff)
+
+		// drain the body so the connection can be reused.
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		err = c.sleep(ctx, wait)
+		if err != nil {
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
+// retryable returns true for responses indicating an overloaded or
+// temporarily failing Nakadi.
+func retryable(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as
+// an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+
+	return 0, false
+}
@@ -5,9 +5,12 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/httperrors"
 )
 
 func TestQuery(tt *testing.T) {
@@ -129,7 +132,7 @@ func TestQuery(tt *testing.T) {
 			defer ts.Close()
 
 			nakadiClient := NewNakadiClient(ts.URL, client)
-			consumerLagSeconds, err := nakadiClient.ConsumerLagSeconds(context.Background(), "id")
+			consumerLagSeconds, err := nakadiClient.ConsumerLagSeconds(context.Background(), "id", UnassignedPartitionsMax)
 			assert.Equal(t, ti.err, err)
 			assert.Equal(t, ti.consumerLagSeconds, consumerLagSeconds)
 			unconsumedEvents, err := nakadiClient.UnconsumedEvents(context.Background(), "id")
@@ -139,3 +142,260 @@ func TestQuery(tt *testing.T) {
 	}
 
 }
+
+func TestStatsErrorBodyTruncation(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte(strings.Repeat("x", 2*1024*1024)))
+	}))
+	defer ts.Close()
+
+	nakadiClient := NewNakadiClient(ts.URL, &http.Client{})
+	nakadiClient.SetMaxErrorBodyLength(4)
+	_, err := nakadiClient.UnconsumedEvents(context.Background(), "id")
+	assert.EqualError(t, err, "[nakadi stats] unexpected response code: 502 (xxxx... (truncated))")
+}
+
+func newTestServer(t *testing.T, responseBody string) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte(responseBody))
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestQueryMissingFields(t *testing.T) {
+	ts := newTestServer(t, `{
+		"items": [
+		  {
+		    "event_type": "example-event",
+		    "partitions": [
+		      {"partition": "0", "state": "assigned", "unconsumed_events": 4, "stream_id": "example-id"},
+		      {"partition": "1", "state": "assigned", "consumer_lag_seconds": 3, "stream_id": "example-id"}
+		    ]
+		  }
+		]
+	}`)
+
+	nakadiClient := NewNakadiClient(ts.URL, &http.Client{})
+	consumerLagSeconds, err := nakadiClient.ConsumerLagSeconds(context.Background(), "id", UnassignedPartitionsMax)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), consumerLagSeconds)
+	unconsumedEvents, err := nakadiClient.UnconsumedEvents(context.Background(), "id")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), unconsumedEvents)
+
+	ts = newTestServer(t, `{"items": [{"event_type": "example-event", "partitions": [{"partition": "0", "state": "assigned", "stream_id": "example-id"}]}]}`)
+
+	nakadiClient = NewNakadiClient(ts.URL, &http.Client{})
+	_, err = nakadiClient.ConsumerLagSeconds(context.Background(), "id", UnassignedPartitionsMax)
+	assert.EqualError(t, err, "no partition reported consumer_lag_seconds")
+	_, err = nakadiClient.UnconsumedEvents(context.Background(), "id")
+	assert.EqualError(t, err, "no partition reported unconsumed_events")
+}
+
+func TestQueryUnassignedPartitions(t *testing.T) {
+	ts := newTestServer(t, `{
+		"items": [
+		  {
+		    "event_type": "example-event",
+		    "partitions": [
+		      {"partition": "0", "state": "assigned", "unconsumed_events": 4, "consumer_lag_seconds": 2, "stream_id": "example-id"},
+		      {"partition": "1", "state": "unassigned", "unconsumed_events": 10, "consumer_lag_seconds": 60}
+		    ]
+		  }
+		]
+	}`)
+
+	for _, tc := range []struct {
+		policy   UnassignedPartitions
+		expected int64
+		err      string
+	}{
+		{policy: UnassignedPartitionsIgnore, expected: 2},
+		{policy: UnassignedPartitionsMax, expected: 60},
+		{policy: UnassignedPartitionsError, err: "partition 1 of event type example-event is not assigned to a stream"},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			nakadiClient := NewNakadiClient(ts.URL, &http.Client{})
+			consumerLagSeconds, err := nakadiClient.ConsumerLagSeconds(context.Background(), "id", tc.policy)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, consumerLagSeconds)
+
+			// unconsumed events of unassigned partitions are
+			// always counted.
+			unconsumedEvents, err := nakadiClient.UnconsumedEvents(context.Background(), "id")
+			assert.NoError(t, err)
+			assert.Equal(t, int64(14), unconsumedEvents)
+		})
+	}
+}
+
+func TestQueryUnassignedPartitionsWithoutLag(t *testing.T) {
+	for _, tc := range []struct {
+		msg      string
+		stats    string
+		policy   UnassignedPartitions
+		expected int64
+		err      string
+	}{
+		{
+			msg: "max uses the max lag of the assigned partitions",
+			stats: `{"items": [{"event_type": "example-event", "partitions": [
+				{"partition": "0", "state": "assigned", "consumer_lag_seconds": 2, "stream_id": "example-id"},
+				{"partition": "1", "state": "assigned", "consumer_lag_seconds": 5, "stream_id": "example-id"},
+				{"partition": "2", "state": "unassigned"}
+			]}]}`,
+			policy:   UnassignedPartitionsMax,
+			expected: 5,
+		},
+		{
+			msg: "ignore skips the unassigned partitions",
+			stats: `{"items": [{"event_type": "example-event", "partitions": [
+				{"partition": "0", "state": "assigned", "consumer_lag_seconds": 2, "stream_id": "example-id"},
+				{"partition": "1", "state": "unassigned"}
+			]}]}`,
+			policy:   UnassignedPartitionsIgnore,
+			expected: 2,
+		},
+		{
+			msg: "max fails without a lag of an assigned partition",
+			stats: `{"items": [{"event_type": "example-event", "partitions": [
+				{"partition": "0", "state": "assigned", "stream_id": "example-id"},
+				{"partition": "1", "state": "unassigned", "consumer_lag_seconds": 60},
+				{"partition": "2", "state": "unassigned"}
+			]}]}`,
+			policy: UnassignedPartitionsMax,
+			err:    "no assigned partition reported consumer_lag_seconds for the 1 unassigned partition(s) without lag",
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			ts := newTestServer(t, tc.stats)
+			nakadiClient := NewNakadiClient(ts.URL, &http.Client{})
+			consumerLagSeconds, err := nakadiClient.ConsumerLagSeconds(context.Background(), "id", tc.policy)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, consumerLagSeconds)
+		})
+	}
+}
+
+func TestParseUnassignedPartitions(t *testing.T) {
+	policy, err := ParseUnassignedPartitions("ignore")
+	assert.NoError(t, err)
+	assert.Equal(t, UnassignedPartitionsIgnore, policy)
+
+	_, err = ParseUnassignedPartitions("min")
+	assert.Error(t, err)
+}
+
+func TestQueryRetries(t *testing.T) {
+	for _, tc := range []struct {
+		msg           string
+		statuses      []int
+		retryAfter    string
+		expectedWaits []time.Duration
+		err           string
+	}{
+		{
+			msg:           "429 with Retry-After in seconds",
+			statuses:      []int{http.StatusTooManyRequests, http.StatusOK},
+			retryAfter:    "2",
+			expectedWaits: []time.Duration{2 * time.Second},
+		},
+		{
+			msg:           "Retry-After is bounded by the max backoff",
+			statuses:      []int{http.StatusTooManyRequests, http.StatusOK},
+			retryAfter:    "120",
+			expectedWaits: []time.Duration{5 * time.Second},
+		},
+		{
+			msg:           "5xx with exponential backoff",
+			statuses:      []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK},
+			expectedWaits: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond},
+		},
+		{
+			msg:           "retries are bounded",
+			statuses:      []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			expectedWaits: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond},
+			err:           "[nakadi stats] unexpected response code: 503 (unavailable)",
+		},
+		{
+			msg:      "4xx is not retried",
+			statuses: []int{http.StatusNotFound},
+			err:      "[nakadi stats] unexpected response code: 404 (unavailable)",
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			requests := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tc.statuses[requests]
+				requests++
+				if status != http.StatusOK {
+					if tc.retryAfter != "" {
+						w.Header().Set("Retry-After", tc.retryAfter)
+					}
+					w.WriteHeader(status)
+					_, _ = w.Write([]byte("unavailable"))
+					return
+				}
+				_, _ = w.Write([]byte(`{"items": [{"event_type": "example-event", "partitions": [{"partition": "0", "state": "assigned", "unconsumed_events": 4, "stream_id": "example-id"}]}]}`))
+			}))
+			defer ts.Close()
+
+			var waits []time.Duration
+			nakadiClient := NewNakadiClient(ts.URL, &http.Client{})
+			nakadiClient.sleep = func(_ context.Context, d time.Duration) error {
+				waits = append(waits, d)
+				return nil
+			}
+
+			unconsumedEvents, err := nakadiClient.UnconsumedEvents(context.Background(), "id")
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, int64(4), unconsumedEvents)
+			}
+			assert.Equal(t, tc.expectedWaits, waits)
+			assert.Equal(t, len(tc.statuses), requests)
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	d, ok := parseRetryAfter("3", now)
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, d)
+
+	d, ok = parseRetryAfter(now.Add(10*time.Second).Format(http.TimeFormat), now)
+	assert.True(t, ok)
+	assert.Equal(t, 10*time.Second, d)
+
+	_, ok = parseRetryAfter("", now)
+	assert.False(t, ok)
+
+	_, ok = parseRetryAfter("soon", now)
+	assert.False(t, ok)
+}
+
+func TestStatsMaxResponseSize(t *testing.T) {
+	ts := newTestServer(t, `{"items": [{"partitions": [`+strings.Repeat(`{"partition": "0", "state": "assigned", "unconsumed_events": 1},`, 64)+`{}]}]}`)
+
+	nakadiClient := NewNakadiClient(ts.URL, &http.Client{})
+	nakadiClient.SetMaxResponseSize(1024)
+	_, err := nakadiClient.UnconsumedEvents(context.Background(), "id")
+	var tooLarge *httperrors.TooLargeError
+	assert.True(t, errors.As(err, &tooLarge))
+	assert.Equal(t, int64(1024), tooLarge.Limit)
+}
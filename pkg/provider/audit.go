@@ -0,0 +1,164 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+)
+
+// AuditLogDropped is the total number of audit log entries dropped because
+// the buffer was full or the entry couldn't be written.
+var AuditLogDropped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "kube_metrics_adapter_audit_log_dropped_total",
+	Help: "The total number of audit log entries dropped because the buffer was full or the entry couldn't be written",
+})
+
+// auditLogBufferSize is the number of audit log entries buffered before
+// new entries are dropped.
+const auditLogBufferSize = 1024
+
+// Audit log actions.
+const (
+	auditActionAdd    = "add"
+	auditActionUpdate = "update"
+	auditActionRemove = "remove"
+	auditActionStop   = "stop"
+)
+
+// Audit log reasons.
+const (
+	// auditReasonNew is used for collectors of HPAs seen for the first
+	// time, or again after their collectors couldn't be created.
+	auditReasonNew = "new"
+	// auditReasonSpecChanged is used for collectors recreated because the
+	// HPA or the namespace defaults changed.
+	auditReasonSpecChanged = "spec-changed"
+	// auditReasonIntervalChanged is used for running collectors whose
+	// collection interval was updated.
+	auditReasonIntervalChanged = "interval-changed"
+	// auditReasonHPADeleted is used for collectors of HPAs which are gone
+	// or no longer handled, e.g. because they are being deleted.
+	auditReasonHPADeleted = "hpa-deleted"
+	// auditReasonHPAPaused is used for collectors of paused HPAs.
+	auditReasonHPAPaused = "hpa-paused"
+	// auditReasonError is used for collectors stopped after a permanent
+	// error.
+	auditReasonError = "error"
+)
+
+// auditEntry is a single line of the audit log.
+type auditEntry struct {
+	Timestamp     time.Time `json:"timestamp"`
+	Action        string    `json:"action"`
+	Reason        string    `json:"reason"`
+	Namespace     string    `json:"namespace"`
+	HPA           string    `json:"hpa"`
+	MetricType    string    `json:"metricType"`
+	Metric        string    `json:"metric"`
+	Selector      string    `json:"selector,omitempty"`
+	CollectorType string    `json:"collectorType,omitempty"`
+}
+
+// auditLog writes the decisions to start, update or stop collectors as JSON
+// lines. Recording never blocks, entries are dropped if the writer doesn't
+// keep up.
+type auditLog struct {
+	entries chan auditEntry
+	writer  io.Writer
+	now     func() time.Time
+}
+
+func newAuditLog(writer io.Writer, bufferSize int) *auditLog {
+	return &auditLog{
+		entries: make(chan auditEntry, bufferSize),
+		writer:  writer,
+		now:     time.Now,
+	}
+}
+
+// EnableAuditLog appends an entry for every collector started, updated or
+// stopped to the file. The file is not rotated by the adapter.
+func (p *HPAProvider) EnableAuditLog(file string) error {
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	p.auditLog = newAuditLog(f, auditLogBufferSize)
+	return nil
+}
+
+// record adds an entry for the collector of the HPA to the audit log. It's a
+// no-op if the audit log is disabled.
+func (a *auditLog) record(action, reason string, ref resourceReference, typeName collector.MetricTypeName, collectorType string) {
+	if a == nil {
+		return
+	}
+
+	var selector string
+	if typeName.Metric.Selector != nil {
+		selector = metav1.FormatLabelSelector(typeName.Metric.Selector)
+	}
+
+	entry := auditEntry{
+		Timestamp:     a.now().UTC(),
+		Action:        action,
+		Reason:        reason,
+		Namespace:     ref.Namespace,
+		HPA:           ref.Name,
+		MetricType:    string(typeName.Type),
+		Metric:        typeName.Metric.Name,
+		Selector:      selector,
+		CollectorType: collectorType,
+	}
+
+	select {
+	case a.entries <- entry:
+	default:
+		AuditLogDropped.Inc()
+	}
+}
+
+// run writes the recorded entries until the context is canceled. Entries
+// buffered at that point are still written.
+func (a *auditLog) run(ctx context.Context) {
+	encoder := json.NewEncoder(a.writer)
+	failing := false
+	write := func(entry auditEntry) {
+		if err := encoder.Encode(entry); err != nil {
+			AuditLogDropped.Inc()
+			// only log the first of consecutive failures.
+			if !failing {
+				log.Errorf("Failed to write audit log: %v", err)
+			}
+			failing = true
+			return
+		}
+		failing = false
+	}
+
+	for {
+		select {
+		case entry := <-a.entries:
+			write(entry)
+		case <-ctx.Done():
+			for {
+				select {
+				case entry := <-a.entries:
+					write(entry)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscaling "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var auditTestTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func newTestAuditLog() (*auditLog, *bytes.Buffer) {
+	var buf bytes.Buffer
+	audit := newAuditLog(&buf, 100)
+	audit.now = func() time.Time { return auditTestTime }
+	return audit, &buf
+}
+
+// readAuditLog writes the recorded entries of the audit log and returns
+// them decoded.
+func readAuditLog(t *testing.T, audit *auditLog, buf *bytes.Buffer) []auditEntry {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	audit.run(ctx)
+
+	var entries []auditEntry
+	decoder := json.NewDecoder(buf)
+	for decoder.More() {
+		var entry auditEntry
+		require.NoError(t, decoder.Decode(&entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// summarizeAuditEntries returns the action, reason and HPA of the entries.
+func summarizeAuditEntries(entries []auditEntry) []string {
+	summary := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		summary = append(summary, fmt.Sprintf("%s %s %s/%s", entry.Action, entry.Reason, entry.Namespace, entry.HPA))
+	}
+	return summary
+}
+
+func TestAuditLogUpdateHPAs(t *testing.T) {
+	value := resource.MustParse("1k")
+
+	hpa := &autoscaling.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "hpa1",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"metric-config.pods.requests-per-second.json-path/json-key": "$.http_server.rps",
+				"metric-config.pods.requests-per-second.json-path/interval": "1h",
+			},
+		},
+		Spec: autoscaling.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscaling.CrossVersionObjectReference{
+				Kind:       "Deployment",
+				Name:       "app",
+				APIVersion: "apps/v1",
+			},
+			MaxReplicas: 10,
+			Metrics: []autoscaling.MetricSpec{
+				{
+					Type: autoscaling.PodsMetricSourceType,
+					Pods: &autoscaling.PodsMetricSource{
+						Metric: autoscaling.MetricIdentifier{
+							Name: "requests-per-second",
+						},
+						Target: autoscaling.MetricTarget{
+							Type:         autoscaling.AverageValueMetricType,
+							AverageValue: &value,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	fakeClient := fake.NewSimpleClientset()
+	hpas := fakeClient.AutoscalingV2().HorizontalPodAutoscalers("default")
+
+	var err error
+	hpa, err = hpas.Create(context.TODO(), hpa, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	collectorFactory := collector.NewCollectorFactory()
+	err = collectorFactory.RegisterPodsCollector("", countingCollectorPlugin{calls: &atomic.Int64{}})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	audit, buf := newTestAuditLog()
+	provider := NewHPAProvider(fakeClient, 1*time.Second, 1*time.Second, collectorFactory, false, 1*time.Second, 1*time.Second)
+	provider.SetRemovalThreshold(1)
+	provider.collectorScheduler = NewCollectorScheduler(ctx, provider.metricSink)
+	provider.collectorScheduler.audit = audit
+	go func() {
+		for {
+			select {
+			case <-provider.metricSink:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	update := func(modify func(hpa *autoscaling.HorizontalPodAutoscaler)) {
+		modify(hpa)
+		hpa, err = hpas.Update(context.TODO(), hpa, metav1.UpdateOptions{})
+		require.NoError(t, err)
+		require.NoError(t, provider.updateHPAs())
+	}
+
+	// add the collector of the new HPA.
+	require.NoError(t, provider.updateHPAs())
+	// unchanged HPAs are not audited.
+	require.NoError(t, provider.updateHPAs())
+	update(func(hpa *autoscaling.HorizontalPodAutoscaler) {
+		hpa.Annotations["metric-config.pods.requests-per-second.json-path/interval"] = "2h"
+	})
+	update(func(hpa *autoscaling.HorizontalPodAutoscaler) {
+		hpa.Annotations["metric-config.pods.requests-per-second.json-path/json-key"] = "$.http_server.qps"
+	})
+	update(func(hpa *autoscaling.HorizontalPodAutoscaler) {
+		hpa.Annotations["autoscaling.zalando.org/paused"] = "true"
+	})
+	update(func(hpa *autoscaling.HorizontalPodAutoscaler) {
+		delete(hpa.Annotations, "autoscaling.zalando.org/paused")
+	})
+	require.NoError(t, hpas.Delete(context.TODO(), hpa.Name, metav1.DeleteOptions{}))
+	require.NoError(t, provider.updateHPAs())
+
+	entries := readAuditLog(t, audit, buf)
+	require.Equal(t, []string{
+		"add new default/hpa1",
+		"update interval-changed default/hpa1",
+		"remove spec-changed default/hpa1",
+		"add spec-changed default/hpa1",
+		"remove hpa-paused default/hpa1",
+		"add new default/hpa1",
+		"remove hpa-deleted default/hpa1",
+	}, summarizeAuditEntries(entries))
+
+	require.Equal(t, auditEntry{
+		Timestamp:     auditTestTime,
+		Action:        auditActionAdd,
+		Reason:        auditReasonNew,
+		Namespace:     "default",
+		HPA:           "hpa1",
+		MetricType:    "Pods",
+		Metric:        "requests-per-second",
+		CollectorType: "json-path",
+	}, entries[0])
+}
+
+func TestAuditLogStoppedCollector(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	audit, buf := newTestAuditLog()
+	metricsc := make(chan metricCollection, 1)
+	scheduler := NewCollectorScheduler(ctx, metricsc)
+	scheduler.audit = audit
+
+	hpa := &autoscaling.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Name: "hpa1", Namespace: "default"}}
+	typeName := collector.MetricTypeName{
+		Type: autoscaling.ExternalMetricSourceType,
+		Metric: autoscaling.MetricIdentifier{
+			Name:     "queue-length",
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"queue": "a"}},
+		},
+	}
+	c := failingCollector{calls: &atomic.Int64{}, err: collector.NewPermanentConfigError(errors.New("invalid config")), interval: time.Minute}
+	scheduler.Add(hpa, typeName, "prometheus", c, auditReasonNew)
+
+	<-metricsc
+	// wait for the runner to record the stop.
+	require.Eventually(t, func() bool { return len(audit.entries) == 2 }, time.Second, time.Millisecond)
+	scheduler.Remove(resourceReference{Name: "hpa1", Namespace: "default"}, auditReasonHPADeleted)
+
+	entries := readAuditLog(t, audit, buf)
+	require.Equal(t, []string{
+		"add new default/hpa1",
+		"stop error default/hpa1",
+		"remove hpa-deleted default/hpa1",
+	}, summarizeAuditEntries(entries))
+	for _, entry := range entries {
+		require.Equal(t, "External", entry.MetricType)
+		require.Equal(t, "queue=a", entry.Selector)
+		require.Equal(t, "prometheus", entry.CollectorType)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestAuditLogDropsEntries(t *testing.T) {
+	ref := resourceReference{Name: "hpa1", Namespace: "default"}
+	typeName := collector.MetricTypeName{Type: autoscaling.PodsMetricSourceType, Metric: autoscaling.MetricIdentifier{Name: "rps"}}
+
+	// recording doesn't block if the buffer is full.
+	dropped := testutil.ToFloat64(AuditLogDropped)
+	audit := newAuditLog(&bytes.Buffer{}, 1)
+	audit.record(auditActionAdd, auditReasonNew, ref, typeName, "json-path")
+	audit.record(auditActionRemove, auditReasonHPADeleted, ref, typeName, "json-path")
+	require.Equal(t, dropped+1, testutil.ToFloat64(AuditLogDropped))
+
+	// entries which can't be written are dropped.
+	audit = newAuditLog(failingWriter{}, 2)
+	audit.record(auditActionAdd, auditReasonNew, ref, typeName, "json-path")
+	audit.record(auditActionRemove, auditReasonHPADeleted, ref, typeName, "json-path")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	audit.run(ctx)
+	require.Equal(t, dropped+3, testutil.ToFloat64(AuditLogDropped))
+
+	// a disabled audit log records nothing.
+	var disabled *auditLog
+	disabled.record(auditActionAdd, auditReasonNew, ref, typeName, "json-path")
+}
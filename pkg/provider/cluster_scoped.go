@@ -0,0 +1,62 @@
+package provider
+
+import (
+	"context"
+
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/annotations"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+// ClusterScopedConfigKey is the metric config key storing the series of an
+// external metric without namespace when set to "true". Cluster scoped
+// series are served for queries of any namespace next to the series stored
+// for the namespace.
+const ClusterScopedConfigKey = "cluster-scoped"
+
+// clusterScopedCollector stores the external metrics collected by the
+// wrapped collector without namespace.
+type clusterScopedCollector struct {
+	collector.Collector
+}
+
+func (c *clusterScopedCollector) GetMetrics(ctx context.Context) ([]collector.CollectedMetric, error) {
+	values, err := c.Collector.GetMetrics(ctx)
+	for i := range values {
+		if values[i].Type == autoscalingv2.ExternalMetricSourceType {
+			values[i].Namespace = ""
+		}
+	}
+	return values, err
+}
+
+// scopeCollector wraps the collector of the metric config to store its
+// series cluster scoped if configured by the cluster-scoped config key.
+// Configs which can't be applied are reported as events on the HPA and the
+// series are stored for the namespace of the HPA.
+func (p *HPAProvider) scopeCollector(hpa *autoscalingv2.HorizontalPodAutoscaler, config *collector.MetricConfig, c collector.Collector) collector.Collector {
+	var clusterScoped bool
+	b := annotations.NewConfigBinder(config.Config, config.ConfigSources)
+	b.Bool(ClusterScopedConfigKey, &clusterScoped)
+	if err := b.Err(); err != nil {
+		p.recorder.Eventf(hpa, apiv1.EventTypeWarning, ReasonInvalidConfig, "Failed to configure %s, storing the metric for the namespace: %v", ClusterScopedConfigKey, err)
+		return c
+	}
+
+	if !clusterScoped {
+		return c
+	}
+
+	if config.Type != autoscalingv2.ExternalMetricSourceType {
+		p.recorder.Eventf(hpa, apiv1.EventTypeWarning, ReasonInvalidConfig, "Ignoring %s of %s metric %s, only external metrics can be cluster scoped", ClusterScopedConfigKey, config.Type, config.Metric.Name)
+		return c
+	}
+
+	if !p.clusterScopedExternalMetrics {
+		p.recorder.Eventf(hpa, apiv1.EventTypeWarning, ReasonInvalidConfig, "Ignoring %s of external metric %s, cluster scoped external metrics are not allowed by the adapter", ClusterScopedConfigKey, config.Metric.Name)
+		return c
+	}
+
+	return &clusterScopedCollector{Collector: c}
+}
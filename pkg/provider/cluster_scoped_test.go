@@ -0,0 +1,165 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscaling "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+	"sigs.k8s.io/custom-metrics-apiserver/pkg/provider"
+)
+
+type externalCollector struct {
+	namespace string
+	value     int64
+}
+
+func (c externalCollector) GetMetrics(_ context.Context) ([]collector.CollectedMetric, error) {
+	return []collector.CollectedMetric{
+		{
+			Type:      autoscaling.ExternalMetricSourceType,
+			Namespace: c.namespace,
+			External: external_metrics.ExternalMetricValue{
+				MetricName:   "queue-length",
+				MetricLabels: map[string]string{"topic": "orders"},
+				Value:        *resource.NewQuantity(c.value, resource.DecimalSI),
+			},
+		},
+	}, nil
+}
+
+func (c externalCollector) Interval() time.Duration {
+	return time.Second
+}
+
+func TestScopeCollector(t *testing.T) {
+	hpa := &autoscaling.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "hpa1", Namespace: "team"},
+	}
+
+	for _, tc := range []struct {
+		msg               string
+		allowed           bool
+		metricType        autoscaling.MetricSourceType
+		config            map[string]string
+		expectedNamespace string
+		expectedEvents    int
+	}{
+		{
+			msg:               "namespaced by default",
+			allowed:           true,
+			metricType:        autoscaling.ExternalMetricSourceType,
+			expectedNamespace: "team",
+		},
+		{
+			msg:               "cluster scoped",
+			allowed:           true,
+			metricType:        autoscaling.ExternalMetricSourceType,
+			config:            map[string]string{ClusterScopedConfigKey: "true"},
+			expectedNamespace: "",
+		},
+		{
+			msg:               "explicitly namespaced",
+			allowed:           true,
+			metricType:        autoscaling.ExternalMetricSourceType,
+			config:            map[string]string{ClusterScopedConfigKey: "false"},
+			expectedNamespace: "team",
+		},
+		{
+			msg:               "cluster scoped not allowed",
+			metricType:        autoscaling.ExternalMetricSourceType,
+			config:            map[string]string{ClusterScopedConfigKey: "true"},
+			expectedNamespace: "team",
+			expectedEvents:    1,
+		},
+		{
+			msg:               "invalid config",
+			allowed:           true,
+			metricType:        autoscaling.ExternalMetricSourceType,
+			config:            map[string]string{ClusterScopedConfigKey: "yes"},
+			expectedNamespace: "team",
+			expectedEvents:    1,
+		},
+		{
+			msg:               "only external metrics are cluster scoped",
+			allowed:           true,
+			metricType:        autoscaling.PodsMetricSourceType,
+			config:            map[string]string{ClusterScopedConfigKey: "true"},
+			expectedNamespace: "team",
+			expectedEvents:    1,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			recorder := &mockEventRecorder{}
+			p := NewHPAProvider(fake.NewSimpleClientset(), time.Second, time.Second, collector.NewCollectorFactory(), false, time.Minute, time.Minute)
+			p.recorder = recorder
+			if tc.allowed {
+				p.AllowClusterScopedExternalMetrics()
+			}
+
+			config := &collector.MetricConfig{
+				MetricTypeName: collector.MetricTypeName{
+					Type:   tc.metricType,
+					Metric: autoscaling.MetricIdentifier{Name: "queue-length"},
+				},
+				Config: tc.config,
+			}
+
+			c := p.scopeCollector(hpa, config, externalCollector{namespace: "team", value: 1})
+			metrics, err := c.GetMetrics(context.Background())
+			require.NoError(t, err)
+			require.Len(t, metrics, 1)
+			require.Equal(t, tc.expectedNamespace, metrics[0].Namespace)
+			require.Len(t, recorder.Events, tc.expectedEvents)
+		})
+	}
+}
+
+func TestGetExternalMetricClusterScoped(t *testing.T) {
+	p := NewHPAProvider(fake.NewSimpleClientset(), time.Second, time.Second, collector.NewCollectorFactory(), false, time.Minute, time.Minute)
+	p.AllowClusterScopedExternalMetrics()
+
+	hpa := &autoscaling.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "hpa1", Namespace: "team"},
+	}
+	config := &collector.MetricConfig{
+		MetricTypeName: collector.MetricTypeName{
+			Type:   autoscaling.ExternalMetricSourceType,
+			Metric: autoscaling.MetricIdentifier{Name: "queue-length"},
+		},
+		Config: map[string]string{ClusterScopedConfigKey: "true"},
+	}
+
+	for _, c := range []collector.Collector{
+		p.scopeCollector(hpa, config, externalCollector{namespace: "team", value: 1}),
+		externalCollector{namespace: "team", value: 2},
+	} {
+		metrics, err := c.GetMetrics(context.Background())
+		require.NoError(t, err)
+		for _, metric := range metrics {
+			p.metricStore.Insert(metric)
+		}
+	}
+
+	values := func(namespace string) []int64 {
+		metrics, err := p.GetExternalMetric(context.Background(), namespace, labels.Everything(), provider.ExternalMetricInfo{Metric: "queue-length"})
+		require.NoError(t, err)
+		var values []int64
+		for _, item := range metrics.Items {
+			values = append(values, item.Value.Value())
+		}
+		return values
+	}
+
+	// the namespaced series of the same name and labels doesn't shadow
+	// the cluster scoped one.
+	require.Equal(t, []int64{2, 1}, values("team"))
+	require.Equal(t, []int64{1}, values("capacity"))
+}
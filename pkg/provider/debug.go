@@ -0,0 +1,200 @@
+package provider
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// collectorStatus describes a scheduled collector.
+type collectorStatus struct {
+	Namespace      string    `json:"namespace"`
+	HPA            string    `json:"hpa"`
+	MetricType     string    `json:"metricType"`
+	Metric         string    `json:"metric"`
+	Selector       string    `json:"selector,omitempty"`
+	Interval       string    `json:"interval"`
+	LastCollection time.Time `json:"lastCollection"`
+	Stopped        bool      `json:"stopped,omitempty"`
+	// Shared is set if the collector is shared with other HPAs.
+	Shared bool `json:"shared,omitempty"`
+}
+
+// collectorsDebugInfo is the response of the collectors debug endpoint.
+type collectorsDebugInfo struct {
+	Collectors []collectorStatus          `json:"collectors"`
+	Queries    map[string][]RecordedQuery `json:"queries,omitempty"`
+	// Smoothed are the last raw and smoothed values of the metrics
+	// configured with smoothing.
+	Smoothed map[string]SmoothedValue `json:"smoothed,omitempty"`
+	// ExternalTypes are the supported values of the type label of
+	// External metrics.
+	ExternalTypes []string `json:"externalTypes,omitempty"`
+	// Continue is the token to request the next page of collectors, if
+	// there are more.
+	Continue string `json:"continue,omitempty"`
+}
+
+// collectorKey identifies a collector in the sorted collector status.
+type collectorKey struct {
+	Namespace  string `json:"namespace"`
+	HPA        string `json:"hpa"`
+	MetricType string `json:"metricType"`
+	Metric     string `json:"metric"`
+	Selector   string `json:"selector,omitempty"`
+}
+
+func (s collectorStatus) key() collectorKey {
+	return collectorKey{Namespace: s.Namespace, HPA: s.HPA, MetricType: s.MetricType, Metric: s.Metric, Selector: s.Selector}
+}
+
+// less orders the keys by namespace, HPA, metric type, metric and selector.
+func (k collectorKey) less(other collectorKey) bool {
+	if k.Namespace != other.Namespace {
+		return k.Namespace < other.Namespace
+	}
+	if k.HPA != other.HPA {
+		return k.HPA < other.HPA
+	}
+	if k.MetricType != other.MetricType {
+		return k.MetricType < other.MetricType
+	}
+	if k.Metric != other.Metric {
+		return k.Metric < other.Metric
+	}
+	return k.Selector < other.Selector
+}
+
+// Status returns the status of all scheduled collectors sorted by HPA and
+// metric, so the order is stable across calls.
+func (t *CollectorScheduler) Status() []collectorStatus {
+	t.RLock()
+	defer t.RUnlock()
+
+	status := make([]collectorStatus, 0, len(t.table))
+	for ref, collectors := range t.table {
+		for typeName, scheduled := range collectors {
+			var selector string
+			if typeName.Metric.Selector != nil {
+				selector = metav1.FormatLabelSelector(typeName.Metric.Selector)
+			}
+			status = append(status, collectorStatus{
+				Namespace:      ref.Namespace,
+				HPA:            ref.Name,
+				MetricType:     string(typeName.Type),
+				Metric:         typeName.Metric.Name,
+				Selector:       selector,
+				Interval:       time.Duration(scheduled.interval.Load()).String(),
+				LastCollection: time.Unix(0, scheduled.lastCollection.Load()).UTC(),
+				Stopped:        scheduled.stopped.Load(),
+				Shared:         scheduled.shared != nil,
+			})
+		}
+	}
+
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].key().less(status[j].key())
+	})
+
+	return status
+}
+
+// DebugCollectorsHandler returns an HTTP handler exposing the state of the
+// scheduled collectors, the raw and smoothed values of smoothed metrics, the
+// supported external metric types and, if enabled, the recorded queries.
+//
+// The collectors can be paginated with the limit query parameter and the
+// continue token of the previous page. The other fields are only part of the
+// first page.
+func (p *HPAProvider) DebugCollectorsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit, after, err := parsePagination(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		info := collectorsDebugInfo{
+			Collectors: []collectorStatus{},
+		}
+
+		if p.collectorScheduler != nil {
+			info.Collectors, info.Continue = paginateCollectors(p.collectorScheduler.Status(), limit, after)
+		}
+
+		if after == nil {
+			if p.queryRecorder != nil {
+				info.Queries = p.queryRecorder.Queries()
+			}
+
+			if p.smoothedValues != nil {
+				info.Smoothed = p.smoothedValues.Values()
+			}
+
+			if p.collectorFactory != nil {
+				info.ExternalTypes = p.collectorFactory.ListRegisteredExternalTypes()
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(info)
+		if err != nil {
+			p.logger.Errorf("Failed to encode collectors debug info: %v", err)
+		}
+	})
+}
+
+// parsePagination parses the limit and continue query parameters. A limit
+// of 0 disables the pagination, the key is nil for the first page.
+func parsePagination(r *http.Request) (int, *collectorKey, error) {
+	var limit int
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return 0, nil, fmt.Errorf("invalid limit '%s', must be a positive integer", value)
+		}
+	}
+
+	token := r.URL.Query().Get("continue")
+	if token == "" {
+		return limit, nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid continue token")
+	}
+	var after collectorKey
+	if err := json.Unmarshal(data, &after); err != nil {
+		return 0, nil, fmt.Errorf("invalid continue token")
+	}
+	return limit, &after, nil
+}
+
+// paginateCollectors returns the page of the sorted collectors following
+// the key and the continue token of the next page if there are more. The
+// token encodes the key of the last collector of the page, so collectors
+// added or removed between two requests don't shift the pages.
+func paginateCollectors(status []collectorStatus, limit int, after *collectorKey) ([]collectorStatus, string) {
+	if after != nil {
+		start := sort.Search(len(status), func(i int) bool {
+			return after.less(status[i].key())
+		})
+		status = status[start:]
+	}
+
+	if limit == 0 || len(status) <= limit {
+		return status, ""
+	}
+
+	status = status[:limit]
+	data, _ := json.Marshal(status[limit-1].key())
+	return status, base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,135 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscaling "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newDebugProvider() *HPAProvider {
+	p := NewHPAProvider(fake.NewSimpleClientset(), time.Second, time.Second, collector.NewCollectorFactory(), false, time.Minute, time.Minute)
+	p.EnableQueryRecording(2)
+	p.queryRecorder.Record(externalMetricWithQuery(1, "query"))
+
+	scheduler := NewCollectorScheduler(context.Background(), nil)
+	for i := 0; i < 3; i++ {
+		collectors := map[collector.MetricTypeName]*scheduledCollector{}
+		for _, queue := range []string{"a", "b", "c"} {
+			typeName := collector.MetricTypeName{
+				Type: autoscaling.ExternalMetricSourceType,
+				Metric: autoscaling.MetricIdentifier{
+					Name:     "queue-length",
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"queue": queue}},
+				},
+			}
+			collectors[typeName] = newScheduledCollector(func() {}, time.Minute)
+		}
+		scheduler.table[resourceReference{Namespace: "default", Name: fmt.Sprintf("hpa%d", i)}] = collectors
+	}
+	p.collectorScheduler = scheduler
+	return p
+}
+
+func getCollectorsDebugInfo(t *testing.T, p *HPAProvider, query url.Values) collectorsDebugInfo {
+	rec := httptest.NewRecorder()
+	p.DebugCollectorsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/collectors?"+query.Encode(), nil))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var info collectorsDebugInfo
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&info))
+	return info
+}
+
+func TestCollectorSchedulerStatusStableOrder(t *testing.T) {
+	p := newDebugProvider()
+
+	status := p.collectorScheduler.Status()
+	require.Len(t, status, 9)
+	require.Equal(t, "hpa0", status[0].HPA)
+	require.Equal(t, "queue=a", status[0].Selector)
+	require.Equal(t, "queue=c", status[2].Selector)
+	require.Equal(t, "hpa2", status[8].HPA)
+
+	for i := 0; i < 10; i++ {
+		require.Equal(t, status, p.collectorScheduler.Status())
+	}
+}
+
+func TestDebugCollectorsPagination(t *testing.T) {
+	p := newDebugProvider()
+
+	// without limit all collectors are returned.
+	info := getCollectorsDebugInfo(t, p, nil)
+	require.Len(t, info.Collectors, 9)
+	require.Empty(t, info.Continue)
+	all := info.Collectors
+
+	var pages [][]collectorStatus
+	query := url.Values{"limit": {"4"}}
+	for {
+		info := getCollectorsDebugInfo(t, p, query)
+		if len(pages) == 0 {
+			require.NotEmpty(t, info.Queries)
+		} else {
+			// the other fields are only part of the first page.
+			require.Empty(t, info.Queries)
+		}
+
+		pages = append(pages, info.Collectors)
+		if info.Continue == "" {
+			break
+		}
+		query.Set("continue", info.Continue)
+	}
+
+	require.Len(t, pages, 3)
+	var paginated []collectorStatus
+	for _, page := range pages {
+		paginated = append(paginated, page...)
+	}
+	require.Equal(t, all, paginated)
+}
+
+func TestDebugCollectorsPaginationWithChanges(t *testing.T) {
+	p := newDebugProvider()
+
+	first := getCollectorsDebugInfo(t, p, url.Values{"limit": {"3"}})
+	require.Len(t, first.Collectors, 3)
+	require.Equal(t, "hpa0", first.Collectors[2].HPA)
+
+	// removing collectors of the returned page doesn't shift the next
+	// page.
+	delete(p.collectorScheduler.table, resourceReference{Namespace: "default", Name: "hpa0"})
+	second := getCollectorsDebugInfo(t, p, url.Values{"limit": {"3"}, "continue": {first.Continue}})
+	require.Len(t, second.Collectors, 3)
+	for _, status := range second.Collectors {
+		require.Equal(t, "hpa1", status.HPA)
+	}
+}
+
+func TestDebugCollectorsInvalidPagination(t *testing.T) {
+	p := newDebugProvider()
+
+	for _, query := range []url.Values{
+		{"limit": {"0"}},
+		{"limit": {"-1"}},
+		{"limit": {"ten"}},
+		{"continue": {"not base64!"}},
+		{"continue": {"bm90IGpzb24"}},
+	} {
+		rec := httptest.NewRecorder()
+		p.DebugCollectorsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/collectors?"+query.Encode(), nil))
+		require.Equal(t, http.StatusBadRequest, rec.Code, query.Encode())
+	}
+}
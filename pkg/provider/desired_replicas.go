@@ -0,0 +1,147 @@
+package provider
+
+import (
+	"context"
+	"time"
+
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/hpasim"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+	"sigs.k8s.io/custom-metrics-apiserver/pkg/provider"
+)
+
+const (
+	// DesiredReplicasMetricName is the name of the external metric
+	// exposing the replicas the adapter computes for an HPA.
+	DesiredReplicasMetricName = "kma_desired_replicas"
+	// DesiredReplicasHPALabel is the label of the desired replicas metric
+	// holding the name of the HPA.
+	DesiredReplicasHPALabel = "hpa"
+	// DesiredReplicasNamespaceLabel is the label of the desired replicas
+	// metric holding the namespace of the HPA.
+	DesiredReplicasNamespaceLabel = "namespace"
+)
+
+// desiredReplicas computes the replicas of an HPA from the metric values in
+// the store following the semantics of the HPA controller as simulated by
+// hpasim.DesiredReplicas. Pods and Resource metrics are not stored by object
+// and therefore ignored. It returns false if none of the metrics of the HPA
+// has a value in the store.
+func (s *MetricStore) desiredReplicas(hpa *autoscalingv2.HorizontalPodAutoscaler, tolerance float64) (int32, bool) {
+	replicas, err := hpasim.DesiredReplicas(hpa, hpa.Status.CurrentReplicas, s, tolerance)
+	if err != nil {
+		return 0, false
+	}
+	return replicas, true
+}
+
+// MetricValue returns the value of an External or Object metric of an HPA
+// in the namespace as served by the adapter. It implements
+// hpasim.MetricsSource so the replicas of an HPA can be simulated from the
+// stored metrics.
+func (s *MetricStore) MetricValue(namespace string, metric autoscalingv2.MetricSpec) (hpasim.MetricValue, error) {
+	var value resource.Quantity
+	var ok bool
+	switch {
+	case metric.Type == autoscalingv2.ExternalMetricSourceType && metric.External != nil:
+		value, ok = s.externalMetricSum(namespace, metric.External.Metric)
+	case metric.Type == autoscalingv2.ObjectMetricSourceType && metric.Object != nil:
+		value, ok = s.objectMetricValue(namespace, metric.Object)
+	}
+	if !ok {
+		return hpasim.MetricValue{}, hpasim.ErrNoValue
+	}
+	return hpasim.MetricValue{Value: value}, nil
+}
+
+// externalMetricSum returns the sum of all series of an external metric
+// matching the selector, like the HPA controller does.
+func (s *MetricStore) externalMetricSum(namespace string, metric autoscalingv2.MetricIdentifier) (resource.Quantity, bool) {
+	selector := labels.Everything()
+	if metric.Selector != nil {
+		var err error
+		selector, err = metav1.LabelSelectorAsSelector(metric.Selector)
+		if err != nil {
+			return resource.Quantity{}, false
+		}
+	}
+
+	metrics, err := s.GetExternalMetric(context.Background(), objectNamespace(namespace), selector, provider.ExternalMetricInfo{Metric: metric.Name})
+	if err != nil || len(metrics.Items) == 0 {
+		return resource.Quantity{}, false
+	}
+
+	var sum int64
+	for _, item := range metrics.Items {
+		sum += item.Value.MilliValue()
+	}
+	return *resource.NewMilliQuantity(sum, resource.DecimalSI), true
+}
+
+// objectMetricValue returns the value of an object metric.
+func (s *MetricStore) objectMetricValue(namespace string, metric *autoscalingv2.ObjectMetricSource) (resource.Quantity, bool) {
+	selector := labels.Everything()
+	if metric.Metric.Selector != nil {
+		var err error
+		selector, err = metav1.LabelSelectorAsSelector(metric.Metric.Selector)
+		if err != nil {
+			return resource.Quantity{}, false
+		}
+	}
+
+	object := metric.DescribedObject
+	info := provider.CustomMetricInfo{
+		GroupResource: describedObjectGroupResource(object.Kind, object.APIVersion),
+		Namespaced:    object.Kind != "ClusterScalingSchedule",
+		Metric:        metric.Metric.Name,
+	}
+
+	value := s.GetMetricsByName(context.Background(), types.NamespacedName{Namespace: namespace, Name: object.Name}, info, selector)
+	if value == nil {
+		return resource.Quantity{}, false
+	}
+	return value.Value, true
+}
+
+// EnableDesiredReplicasMetric enables the external metric
+// kma_desired_replicas exposing the replicas computed for each HPA from the
+// stored metrics. The metric is refreshed whenever metrics of the HPA are
+// collected. tolerance should match the tolerance of the HPA controller.
+func (p *HPAProvider) EnableDesiredReplicasMetric(tolerance float64) {
+	p.desiredReplicasMetric = true
+	p.hpaTolerance = tolerance
+}
+
+// updateDesiredReplicas refreshes the desired replicas metric of the HPA.
+// The latest version of the HPA is taken from the HPA cache to account for
+// the current replicas.
+func (p *HPAProvider) updateDesiredReplicas(hpa *autoscalingv2.HorizontalPodAutoscaler) {
+	resourceRef := resourceReference{Name: hpa.Name, Namespace: hpa.Namespace}
+
+	p.hpaCacheMu.RLock()
+	cached, ok := p.hpaCache[resourceRef]
+	p.hpaCacheMu.RUnlock()
+	if ok {
+		hpa = &cached
+	}
+
+	replicas, ok := p.metricStore.desiredReplicas(hpa, p.hpaTolerance)
+	if !ok {
+		return
+	}
+
+	p.metricStore.Insert(collector.NewExternalMetric(hpa.Namespace, external_metrics.ExternalMetricValue{
+		MetricName: DesiredReplicasMetricName,
+		MetricLabels: map[string]string{
+			DesiredReplicasHPALabel:       hpa.Name,
+			DesiredReplicasNamespaceLabel: hpa.Namespace,
+		},
+		Timestamp: metav1.NewTime(time.Now()),
+		Value:     *resource.NewQuantity(int64(replicas), resource.DecimalSI),
+	}))
+}
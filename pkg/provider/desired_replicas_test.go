@@ -0,0 +1,279 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscaling "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/metrics/pkg/apis/custom_metrics"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+	"sigs.k8s.io/custom-metrics-apiserver/pkg/provider"
+)
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func externalMetricSpec(name string, target autoscaling.MetricTarget) autoscaling.MetricSpec {
+	return autoscaling.MetricSpec{
+		Type: autoscaling.ExternalMetricSourceType,
+		External: &autoscaling.ExternalMetricSource{
+			Metric: autoscaling.MetricIdentifier{
+				Name: name,
+				Selector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"queue": name},
+				},
+			},
+			Target: target,
+		},
+	}
+}
+
+func valueTarget(value string) autoscaling.MetricTarget {
+	q := resource.MustParse(value)
+	return autoscaling.MetricTarget{Type: autoscaling.ValueMetricType, Value: &q}
+}
+
+func averageValueTarget(value string) autoscaling.MetricTarget {
+	q := resource.MustParse(value)
+	return autoscaling.MetricTarget{Type: autoscaling.AverageValueMetricType, AverageValue: &q}
+}
+
+func insertExternalValue(store *MetricStore, name, partition string, value string) {
+	store.Insert(collector.CollectedMetric{
+		Type:      autoscaling.ExternalMetricSourceType,
+		Namespace: "default",
+		External: external_metrics.ExternalMetricValue{
+			MetricName:   name,
+			MetricLabels: map[string]string{"queue": name, "partition": partition},
+			Value:        resource.MustParse(value),
+		},
+	})
+}
+
+func TestDesiredReplicas(t *testing.T) {
+	for _, tc := range []struct {
+		msg             string
+		metrics         []autoscaling.MetricSpec
+		values          map[string][]string
+		currentReplicas int32
+		minReplicas     *int32
+		maxReplicas     int32
+		expected        int32
+		expectedFound   bool
+	}{
+		{
+			msg:             "AverageValue divides the sum of all series by the target",
+			metrics:         []autoscaling.MetricSpec{externalMetricSpec("jobs", averageValueTarget("10"))},
+			values:          map[string][]string{"jobs": {"30", "25"}},
+			currentReplicas: 2,
+			maxReplicas:     10,
+			expected:        6, // ceil(55 / 10)
+			expectedFound:   true,
+		},
+		{
+			msg:             "AverageValue without current replicas",
+			metrics:         []autoscaling.MetricSpec{externalMetricSpec("jobs", averageValueTarget("10"))},
+			values:          map[string][]string{"jobs": {"55"}},
+			currentReplicas: 0,
+			maxReplicas:     10,
+			expected:        6,
+			expectedFound:   true,
+		},
+		{
+			msg:             "Value scales the current replicas by value/target",
+			metrics:         []autoscaling.MetricSpec{externalMetricSpec("lag", valueTarget("100"))},
+			values:          map[string][]string{"lag": {"250"}},
+			currentReplicas: 3,
+			maxReplicas:     20,
+			expected:        8, // ceil(3 * 250 / 100)
+			expectedFound:   true,
+		},
+		{
+			msg:             "usage within the tolerance keeps the current replicas",
+			metrics:         []autoscaling.MetricSpec{externalMetricSpec("lag", valueTarget("100"))},
+			values:          map[string][]string{"lag": {"105"}},
+			currentReplicas: 4,
+			maxReplicas:     20,
+			expected:        4,
+			expectedFound:   true,
+		},
+		{
+			msg: "highest replicas over all metrics win",
+			metrics: []autoscaling.MetricSpec{
+				externalMetricSpec("jobs", averageValueTarget("10")),
+				externalMetricSpec("lag", valueTarget("100")),
+			},
+			values:          map[string][]string{"jobs": {"30"}, "lag": {"300"}},
+			currentReplicas: 2,
+			maxReplicas:     20,
+			expected:        6, // max(ceil(30 / 10), ceil(2 * 300 / 100))
+			expectedFound:   true,
+		},
+		{
+			msg:             "clamped to max replicas",
+			metrics:         []autoscaling.MetricSpec{externalMetricSpec("jobs", averageValueTarget("10"))},
+			values:          map[string][]string{"jobs": {"1000"}},
+			currentReplicas: 2,
+			maxReplicas:     10,
+			expected:        10,
+			expectedFound:   true,
+		},
+		{
+			msg:             "clamped to min replicas",
+			metrics:         []autoscaling.MetricSpec{externalMetricSpec("jobs", averageValueTarget("10"))},
+			values:          map[string][]string{"jobs": {"1"}},
+			currentReplicas: 2,
+			minReplicas:     int32Ptr(3),
+			maxReplicas:     10,
+			expected:        3,
+			expectedFound:   true,
+		},
+		{
+			msg:             "min replicas default to one",
+			metrics:         []autoscaling.MetricSpec{externalMetricSpec("jobs", averageValueTarget("10"))},
+			values:          map[string][]string{"jobs": {"0"}},
+			currentReplicas: 2,
+			maxReplicas:     10,
+			expected:        1,
+			expectedFound:   true,
+		},
+		{
+			msg:             "metrics without values are skipped",
+			metrics:         []autoscaling.MetricSpec{externalMetricSpec("jobs", averageValueTarget("10"))},
+			currentReplicas: 2,
+			maxReplicas:     10,
+			expectedFound:   false,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			store := NewMetricStore(func() time.Time { return time.Now().Add(time.Hour) })
+			for name, values := range tc.values {
+				for i, value := range values {
+					insertExternalValue(store, name, string(rune('0'+i)), value)
+				}
+			}
+
+			hpa := &autoscaling.HorizontalPodAutoscaler{
+				ObjectMeta: metav1.ObjectMeta{Name: "hpa1", Namespace: "default"},
+				Spec: autoscaling.HorizontalPodAutoscalerSpec{
+					MinReplicas: tc.minReplicas,
+					MaxReplicas: tc.maxReplicas,
+					Metrics:     tc.metrics,
+				},
+				Status: autoscaling.HorizontalPodAutoscalerStatus{CurrentReplicas: tc.currentReplicas},
+			}
+
+			replicas, found := store.desiredReplicas(hpa, 0.1)
+			require.Equal(t, tc.expectedFound, found)
+			require.Equal(t, tc.expected, replicas)
+		})
+	}
+}
+
+func TestDesiredReplicasObjectMetric(t *testing.T) {
+	store := NewMetricStore(func() time.Time { return time.Now().Add(time.Hour) })
+	store.Insert(collector.CollectedMetric{
+		Type: autoscaling.ObjectMetricSourceType,
+		Custom: custom_metrics.MetricValue{
+			DescribedObject: custom_metrics.ObjectReference{
+				Name:       "schedule",
+				Namespace:  "default",
+				Kind:       "ScalingSchedule",
+				APIVersion: "zalando.org/v1",
+			},
+			Metric: custom_metrics.MetricIdentifier{Name: "schedule"},
+			Value:  resource.MustParse("70"),
+		},
+	})
+
+	hpa := &autoscaling.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "hpa1", Namespace: "default"},
+		Spec: autoscaling.HorizontalPodAutoscalerSpec{
+			MaxReplicas: 20,
+			Metrics: []autoscaling.MetricSpec{
+				{
+					Type: autoscaling.ObjectMetricSourceType,
+					Object: &autoscaling.ObjectMetricSource{
+						DescribedObject: autoscaling.CrossVersionObjectReference{
+							Name:       "schedule",
+							Kind:       "ScalingSchedule",
+							APIVersion: "zalando.org/v1",
+						},
+						Metric: autoscaling.MetricIdentifier{Name: "schedule"},
+						Target: averageValueTarget("10"),
+					},
+				},
+			},
+		},
+		Status: autoscaling.HorizontalPodAutoscalerStatus{CurrentReplicas: 2},
+	}
+
+	replicas, found := store.desiredReplicas(hpa, 0.1)
+	require.True(t, found)
+	require.Equal(t, int32(7), replicas)
+}
+
+func TestCollectMetricsDesiredReplicasMetric(t *testing.T) {
+	hpa := &autoscaling.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "hpa1", Namespace: "default"},
+		Spec: autoscaling.HorizontalPodAutoscalerSpec{
+			MaxReplicas: 10,
+			Metrics:     []autoscaling.MetricSpec{externalMetricSpec("jobs", averageValueTarget("10"))},
+		},
+	}
+
+	p := NewHPAProvider(fake.NewSimpleClientset(), 1*time.Second, 1*time.Second, collector.NewCollectorFactory(), false, 1*time.Hour, 1*time.Hour)
+	p.EnableDesiredReplicasMetric(0.1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.collectMetrics(ctx)
+
+	collect := func(value string) {
+		p.metricSink <- metricCollection{
+			HPA: hpa,
+			Values: []collector.CollectedMetric{
+				{
+					Type:      autoscaling.ExternalMetricSourceType,
+					Namespace: "default",
+					External: external_metrics.ExternalMetricValue{
+						MetricName:   "jobs",
+						MetricLabels: map[string]string{"queue": "jobs"},
+						Value:        resource.MustParse(value),
+					},
+				},
+			},
+		}
+		// the next collection is only received once the previous one
+		// is processed.
+		p.metricSink <- metricCollection{}
+	}
+
+	selector := labels.SelectorFromSet(labels.Set{DesiredReplicasHPALabel: "hpa1", DesiredReplicasNamespaceLabel: "default"})
+	info := provider.ExternalMetricInfo{Metric: DesiredReplicasMetricName}
+
+	collect("42")
+	metrics, err := p.GetExternalMetric(context.Background(), "default", selector, info)
+	require.NoError(t, err)
+	require.Len(t, metrics.Items, 1)
+	require.Equal(t, int64(5), metrics.Items[0].Value.Value())
+
+	// the metric is refreshed with the next collection
+	collect("81")
+	metrics, err = p.GetExternalMetric(context.Background(), "default", selector, info)
+	require.NoError(t, err)
+	require.Len(t, metrics.Items, 1)
+	require.Equal(t, int64(9), metrics.Items[0].Value.Value())
+
+	metrics, err = p.GetExternalMetric(context.Background(), "default", labels.SelectorFromSet(labels.Set{DesiredReplicasHPALabel: "other"}), info)
+	require.NoError(t, err)
+	require.Empty(t, metrics.Items)
+}
@@ -0,0 +1,275 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/annotations"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/metrics/pkg/apis/custom_metrics"
+)
+
+// DryRunPattern is the pattern of the dry-run query endpoint served by
+// DryRunHandler.
+const DryRunPattern = "POST /debug/query"
+
+// maxDryRunRequestBytes limits the size of the body of a dry-run request.
+const maxDryRunRequestBytes = 1 << 20
+
+// dryRunRequest describes a metric config like the metric spec and the
+// metric config annotations of an HPA.
+type dryRunRequest struct {
+	Type          autoscalingv2.MetricSourceType `json:"type"`
+	Metric        string                         `json:"metric"`
+	Selector      map[string]string              `json:"selector,omitempty"`
+	CollectorType string                         `json:"collectorType,omitempty"`
+	Config        map[string]string              `json:"config,omitempty"`
+	Namespace     string                         `json:"namespace,omitempty"`
+	// Object is the object described by an Object metric or the scale
+	// target of the pods of a Pods metric.
+	Object *autoscalingv2.CrossVersionObjectReference `json:"object,omitempty"`
+}
+
+// dryRunValue is a value collected by a dry-run query.
+type dryRunValue struct {
+	Type      autoscalingv2.MetricSourceType `json:"type"`
+	Namespace string                         `json:"namespace,omitempty"`
+	Object    string                         `json:"object,omitempty"`
+	Metric    string                         `json:"metric"`
+	Labels    map[string]string              `json:"labels,omitempty"`
+	Value     string                         `json:"value"`
+	Timestamp time.Time                      `json:"timestamp"`
+	Query     string                         `json:"query,omitempty"`
+}
+
+// dryRunResponse is the response of the dry-run query endpoint.
+type dryRunResponse struct {
+	Collector string        `json:"collector,omitempty"`
+	Values    []dryRunValue `json:"values"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// DryRunHandler returns an HTTP handler creating the collector of the metric
+// config in the request body through the collector factory and collecting
+// it once. Nothing is scheduled or stored, so the endpoint allows iterating
+// on a query without changing an HPA. Config keys carrying credentials or
+// overriding the queried server are rejected. The collection is canceled after the timeout. It must be
+// registered with DryRunPattern.
+func (p *HPAProvider) DryRunHandler(timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req dryRunRequest
+		decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxDryRunRequestBytes))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		hpa, config, err := req.metricConfig()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		status, response := p.dryRun(ctx, hpa, config)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		err = json.NewEncoder(w).Encode(response)
+		if err != nil {
+			p.logger.Errorf("Failed to encode dry-run response: %v", err)
+		}
+	})
+}
+
+// dryRun creates the collector of the metric config and collects it once.
+func (p *HPAProvider) dryRun(ctx context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, config *collector.MetricConfig) (int, dryRunResponse) {
+	response := dryRunResponse{Values: []dryRunValue{}}
+
+	interval := config.Interval
+	if interval == 0 {
+		interval = p.collectorInterval
+	}
+
+	c, err := p.collectorFactory.NewCollector(collector.WithCollectorType(ctx, collectorTypeLabel(config)), hpa, config, interval)
+	if err != nil {
+		response.Error = fmt.Sprintf("failed to create collector: %v", err)
+		return dryRunStatus(err), response
+	}
+	response.Collector = fmt.Sprintf("%T", c)
+
+	values, err := c.GetMetrics(ctx)
+	if err != nil {
+		response.Error = fmt.Sprintf("failed to collect metrics: %v", err)
+		return dryRunStatus(err), response
+	}
+
+	for _, value := range values {
+		response.Values = append(response.Values, newDryRunValue(value))
+	}
+	return http.StatusOK, response
+}
+
+// dryRunStatus maps the error of a dry-run to the status of the response.
+func dryRunStatus(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// metricConfig returns the metric config of the request and the HPA it's
+// collected for.
+func (req *dryRunRequest) metricConfig() (*autoscalingv2.HorizontalPodAutoscaler, *collector.MetricConfig, error) {
+	if req.Metric == "" {
+		return nil, nil, errors.New("metric is required")
+	}
+
+	namespace := req.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+
+	identifier := autoscalingv2.MetricIdentifier{Name: req.Metric}
+	if len(req.Selector) > 0 {
+		identifier.Selector = &metav1.LabelSelector{MatchLabels: req.Selector}
+	}
+
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "dry-run", Namespace: namespace},
+	}
+
+	config := &collector.MetricConfig{
+		MetricTypeName: collector.MetricTypeName{Type: req.Type, Metric: identifier},
+		CollectorType:  req.CollectorType,
+		Config:         map[string]string{},
+	}
+
+	switch req.Type {
+	case autoscalingv2.ExternalMetricSourceType:
+		config.MetricSpec = autoscalingv2.MetricSpec{
+			Type:     req.Type,
+			External: &autoscalingv2.ExternalMetricSource{Metric: identifier},
+		}
+	case autoscalingv2.ObjectMetricSourceType:
+		if req.Object == nil || req.Object.Kind == "" || req.Object.Name == "" {
+			return nil, nil, fmt.Errorf("the kind and name of the object are required for %s metrics", req.Type)
+		}
+		config.ObjectReference = custom_metrics.ObjectReference{
+			APIVersion: req.Object.APIVersion,
+			Kind:       req.Object.Kind,
+			Name:       req.Object.Name,
+			Namespace:  namespace,
+		}
+		config.MetricSpec = autoscalingv2.MetricSpec{
+			Type:   req.Type,
+			Object: &autoscalingv2.ObjectMetricSource{Metric: identifier, DescribedObject: *req.Object},
+		}
+	case autoscalingv2.PodsMetricSourceType:
+		if req.Object == nil || req.Object.Kind == "" || req.Object.Name == "" {
+			return nil, nil, fmt.Errorf("the kind and name of the scale target are required for %s metrics", req.Type)
+		}
+		hpa.Spec.ScaleTargetRef = *req.Object
+		config.MetricSpec = autoscalingv2.MetricSpec{
+			Type: req.Type,
+			Pods: &autoscalingv2.PodsMetricSource{Metric: identifier},
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported metric type '%s', must be one of %s, %s, %s", req.Type, autoscalingv2.ExternalMetricSourceType, autoscalingv2.ObjectMetricSourceType, autoscalingv2.PodsMetricSourceType)
+	}
+	hpa.Spec.Metrics = []autoscalingv2.MetricSpec{config.MetricSpec}
+
+	// like for HPAs the labels of the selector are part of the config of
+	// External and Object metrics, the explicit config takes precedence.
+	if req.Type != autoscalingv2.PodsMetricSourceType {
+		for k, v := range req.Selector {
+			config.Config[k] = v
+		}
+	}
+	for k, v := range req.Config {
+		config.Config[k] = v
+	}
+
+	if err := checkDryRunConfig(req.CollectorType, config.Config); err != nil {
+		return nil, nil, err
+	}
+
+	return hpa, config, nil
+}
+
+// isEndpointConfigKey returns true if the config key overrides the server of
+// any of the collector types. The collector type of a request can't be
+// relied on, as collectors may also be chosen by the type label or the
+// legacy metric names.
+func isEndpointConfigKey(key string) bool {
+	for _, collectorType := range annotations.RegisteredCollectorTypes() {
+		if annotations.IsEndpointConfigKey(collectorType, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDryRunConfig returns an error if the config carries credentials or
+// overrides the server queried by the collector. The endpoint is
+// unauthenticated, so overriding the server would let any caller send the
+// queries, and the credentials of the adapter for the server, to their own
+// host.
+func checkDryRunConfig(collectorType string, config map[string]string) error {
+	var sensitive, endpoints []string
+	for key := range config {
+		if annotations.IsSensitiveConfigKey(collectorType, key) {
+			sensitive = append(sensitive, key)
+		}
+		if isEndpointConfigKey(key) {
+			endpoints = append(endpoints, key)
+		}
+	}
+
+	if len(sensitive) > 0 {
+		sort.Strings(sensitive)
+		return fmt.Errorf("config keys carrying credentials are not accepted: %s", strings.Join(sensitive, ", "))
+	}
+	if len(endpoints) > 0 {
+		sort.Strings(endpoints)
+		return fmt.Errorf("config keys overriding the server are not accepted: %s", strings.Join(endpoints, ", "))
+	}
+	return nil
+}
+
+// newDryRunValue returns the response value of a collected metric.
+func newDryRunValue(value collector.CollectedMetric) dryRunValue {
+	switch value.Type {
+	case autoscalingv2.ObjectMetricSourceType, autoscalingv2.PodsMetricSourceType:
+		return dryRunValue{
+			Type:      value.Type,
+			Namespace: value.Custom.DescribedObject.Namespace,
+			Object:    fmt.Sprintf("%s/%s", value.Custom.DescribedObject.Kind, value.Custom.DescribedObject.Name),
+			Metric:    value.Custom.Metric.Name,
+			Value:     value.Custom.Value.String(),
+			Timestamp: value.Custom.Timestamp.Time,
+			Query:     value.Query,
+		}
+	default:
+		return dryRunValue{
+			Type:      value.Type,
+			Namespace: value.Namespace,
+			Metric:    value.External.MetricName,
+			Labels:    value.External.MetricLabels,
+			Value:     value.External.Value.String(),
+			Timestamp: value.External.Timestamp.Time,
+			Query:     value.Query,
+		}
+	}
+}
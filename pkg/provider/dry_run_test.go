@@ -0,0 +1,224 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscaling "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+	"sigs.k8s.io/custom-metrics-apiserver/pkg/provider"
+)
+
+// fakePrometheusPlugin creates collectors returning the length of the query
+// of the metric config as value.
+type fakePrometheusPlugin struct{}
+
+func (fakePrometheusPlugin) NewCollector(_ context.Context, hpa *autoscaling.HorizontalPodAutoscaler, config *collector.MetricConfig, interval time.Duration) (collector.Collector, error) {
+	query, ok := config.Config["query"]
+	if !ok {
+		return nil, collector.NewPermanentConfigError(errors.New("no prometheus query defined"))
+	}
+	return &fakePrometheusCollector{namespace: hpa.Namespace, config: config, query: query, interval: interval}, nil
+}
+
+type fakePrometheusCollector struct {
+	namespace string
+	config    *collector.MetricConfig
+	query     string
+	interval  time.Duration
+}
+
+func (c *fakePrometheusCollector) GetMetrics(ctx context.Context) ([]collector.CollectedMetric, error) {
+	if c.query == "block" {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	return []collector.CollectedMetric{
+		{
+			Type:      c.config.Type,
+			Namespace: c.namespace,
+			External: external_metrics.ExternalMetricValue{
+				MetricName:   c.config.Metric.Name,
+				MetricLabels: c.config.Metric.Selector.MatchLabels,
+				Timestamp:    metav1.NewTime(time.Unix(10, 0).UTC()),
+				Value:        *resource.NewQuantity(int64(len(c.query)), resource.DecimalSI),
+			},
+			Query: c.query,
+		},
+	}, nil
+}
+
+func (c *fakePrometheusCollector) Interval() time.Duration {
+	return c.interval
+}
+
+func newDryRunServer(t *testing.T) (*HPAProvider, *httptest.Server) {
+	collectorFactory := collector.NewCollectorFactory()
+	collectorFactory.RegisterExternalCollector([]string{"prometheus"}, fakePrometheusPlugin{})
+
+	p := NewHPAProvider(fake.NewSimpleClientset(), time.Second, time.Second, collectorFactory, false, time.Minute, time.Minute)
+	p.collectorScheduler = NewCollectorScheduler(context.Background(), p.metricSink)
+
+	mux := http.NewServeMux()
+	mux.Handle(DryRunPattern, p.DryRunHandler(100*time.Millisecond))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return p, server
+}
+
+func TestDryRunHandler(t *testing.T) {
+	for _, tc := range []struct {
+		msg            string
+		body           string
+		expectedStatus int
+		expectedValues []dryRunValue
+		expectedError  string
+	}{
+		{
+			msg:            "query is collected",
+			body:           `{"type": "External", "metric": "queue-length", "selector": {"type": "prometheus"}, "config": {"query": "sum(up)"}, "namespace": "team"}`,
+			expectedStatus: http.StatusOK,
+			expectedValues: []dryRunValue{
+				{
+					Type:      autoscaling.ExternalMetricSourceType,
+					Namespace: "team",
+					Metric:    "queue-length",
+					Labels:    map[string]string{"type": "prometheus"},
+					Value:     "7",
+					Timestamp: time.Unix(10, 0).UTC(),
+					Query:     "sum(up)",
+				},
+			},
+		},
+		{
+			msg:            "failing config",
+			body:           `{"type": "External", "metric": "queue-length", "selector": {"type": "prometheus"}}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedValues: []dryRunValue{},
+			expectedError:  "failed to create collector: no prometheus query defined",
+		},
+		{
+			msg:            "unknown collector",
+			body:           `{"type": "External", "metric": "queue-length", "selector": {"type": "zmon"}}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedValues: []dryRunValue{},
+			expectedError:  "failed to create collector: ",
+		},
+		{
+			msg:            "collection is canceled after the timeout",
+			body:           `{"type": "External", "metric": "queue-length", "selector": {"type": "prometheus"}, "config": {"query": "block"}}`,
+			expectedStatus: http.StatusGatewayTimeout,
+			expectedValues: []dryRunValue{},
+			expectedError:  "failed to collect metrics: context deadline exceeded",
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			p, server := newDryRunServer(t)
+
+			resp, err := http.Post(server.URL+"/debug/query", "application/json", strings.NewReader(tc.body))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			var response dryRunResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&response))
+			require.Equal(t, tc.expectedValues, response.Values)
+			if tc.expectedError == "" {
+				require.Empty(t, response.Error)
+			} else {
+				require.True(t, strings.HasPrefix(response.Error, tc.expectedError), response.Error)
+			}
+
+			// nothing is scheduled or stored.
+			require.Equal(t, 0, p.collectorScheduler.count())
+			metrics, err := p.GetExternalMetric(context.Background(), "team", labels.Everything(), provider.ExternalMetricInfo{Metric: "queue-length"})
+			require.NoError(t, err)
+			require.Empty(t, metrics.Items)
+		})
+	}
+}
+
+func TestDryRunHandlerInvalidRequest(t *testing.T) {
+	for _, tc := range []struct {
+		msg           string
+		body          string
+		expectedError string
+	}{
+		{
+			msg:           "credentials are rejected",
+			body:          `{"type": "External", "metric": "queue-length", "collectorType": "influxdb", "config": {"token": "secret", "query-name": "q"}}`,
+			expectedError: "config keys carrying credentials are not accepted: token",
+		},
+		{
+			msg:           "credentials of additional keys are rejected",
+			body:          `{"type": "External", "metric": "queue-length", "selector": {"type": "prometheus"}, "config": {"query": "sum(up)", "api-password": "secret"}}`,
+			expectedError: "config keys carrying credentials are not accepted: api-password",
+		},
+		{
+			msg:           "server overrides are rejected",
+			body:          `{"type": "External", "metric": "queue-length", "collectorType": "influxdb", "config": {"address": "http://attacker.example.org", "query-name": "q"}}`,
+			expectedError: "config keys overriding the server are not accepted: address",
+		},
+		{
+			msg:           "server overrides of the selector are rejected",
+			body:          `{"type": "External", "metric": "queue-length", "selector": {"type": "prometheus", "prometheus-server": "http://169.254.169.254"}, "config": {"query": "sum(up)"}}`,
+			expectedError: "config keys overriding the server are not accepted: prometheus-server",
+		},
+		{
+			msg:           "missing metric",
+			body:          `{"type": "External"}`,
+			expectedError: "metric is required",
+		},
+		{
+			msg:           "unsupported type",
+			body:          `{"type": "Resource", "metric": "cpu"}`,
+			expectedError: "unsupported metric type 'Resource'",
+		},
+		{
+			msg:           "object metric without object",
+			body:          `{"type": "Object", "metric": "requests-per-second"}`,
+			expectedError: "the kind and name of the object are required",
+		},
+		{
+			msg:           "unknown field",
+			body:          `{"type": "External", "metric": "queue-length", "hpa": "app"}`,
+			expectedError: "unknown field",
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			_, server := newDryRunServer(t)
+
+			resp, err := http.Post(server.URL+"/debug/query", "application/json", strings.NewReader(tc.body))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Contains(t, string(body), tc.expectedError)
+		})
+	}
+}
+
+func TestDryRunHandlerOnlyAcceptsPost(t *testing.T) {
+	_, server := newDryRunServer(t)
+
+	resp, err := http.Get(server.URL + "/debug/query")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
@@ -3,24 +3,35 @@ package provider
 import (
 	"context"
 	"errors"
+	"fmt"
+	"maps"
 	"reflect"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	kube_record "k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/metrics/pkg/apis/custom_metrics"
 	"k8s.io/metrics/pkg/apis/external_metrics"
 	"sigs.k8s.io/custom-metrics-apiserver/pkg/provider"
 
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/annotations"
 	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
 	"github.com/zalando-incubator/kube-metrics-adapter/pkg/recorder"
 )
@@ -46,8 +57,94 @@ var (
 		Name: "kube_metrics_adapter_updates_error",
 		Help: "The total number of failed HPA update attempts",
 	})
+	// ActiveCollectors is the number of collectors currently scheduled.
+	ActiveCollectors = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "kube_metrics_adapter_active_collectors",
+		Help: "The number of collectors currently scheduled",
+	})
+	// ExpiredMetricsRemoved is the total number of expired metrics removed
+	// from the metric store.
+	ExpiredMetricsRemoved = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kube_metrics_adapter_expired_metrics_removed",
+		Help: "The total number of expired metrics removed from the metric store",
+	})
+	// CollectorCreationFailures is the total number of failures creating
+	// a collector by reason. Failures are counted even if the event for
+	// the reason is suppressed.
+	CollectorCreationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kube_metrics_adapter_collector_creation_failures",
+		Help: "The total number of failures creating a collector by reason",
+	}, []string{"reason"})
+	// MetricCollisions is the total number of inserts overwriting a series
+	// collected for another HPA with a different value by metric type.
+	MetricCollisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kube_metrics_adapter_metric_collisions",
+		Help: "The total number of inserts overwriting a series collected for another HPA with a different value",
+	}, []string{"type"})
+	// StoreRejectedInserts is the total number of invalid collected
+	// metrics dropped by the metric store by reason.
+	StoreRejectedInserts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kube_metrics_adapter_store_rejected_inserts_total",
+		Help: "The total number of invalid collected metrics dropped by the metric store",
+	}, []string{"reason"})
+	// HPARemovalsHeld is the total number of HPA updates which held back
+	// removing more than the removal threshold of the cached HPAs.
+	HPARemovalsHeld = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kube_metrics_adapter_hpa_removals_held",
+		Help: "The total number of HPA updates which held back removing more than the removal threshold of the cached HPAs",
+	})
+	// CollectorSkippedTicks is the total number of collection ticks
+	// skipped because the previous collection was still running.
+	CollectorSkippedTicks = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kube_metrics_adapter_collector_skipped_ticks_total",
+		Help: "The total number of collection ticks skipped because the previous collection was still running",
+	})
+)
+
+const (
+	// DefaultHPARemovalThreshold is the default max fraction of the cached
+	// HPAs removed in a single update.
+	DefaultHPARemovalThreshold = 0.5
+)
+
+// Event reasons for failures creating a collector.
+const (
+	// ReasonPluginNotFound is used when no collector plugin is
+	// registered for the metric.
+	ReasonPluginNotFound = "PluginNotFound"
+	// ReasonInvalidConfig is used when the metric config of the HPA is
+	// invalid.
+	ReasonInvalidConfig = "InvalidConfig"
+	// ReasonUpstreamUnreachable is used when a dependency like the
+	// Kubernetes API or a metrics backend couldn't be reached.
+	ReasonUpstreamUnreachable = "UpstreamUnreachable"
+	// ReasonCreateNewMetricsCollector is used for all other failures.
+	ReasonCreateNewMetricsCollector = "CreateNewMetricsCollector"
 )
 
+// collectorCreationFailureReasons are the reasons which can be suppressed.
+var collectorCreationFailureReasons = []string{
+	ReasonPluginNotFound,
+	ReasonInvalidConfig,
+	ReasonUpstreamUnreachable,
+	ReasonCreateNewMetricsCollector,
+}
+
+// collectorCreationFailureReason maps the error of creating a collector to
+// the reason of the event recorded for the failure.
+func collectorCreationFailureReason(err error) string {
+	switch {
+	case errors.Is(err, &collector.PluginNotFoundError{}):
+		return ReasonPluginNotFound
+	case errors.Is(err, collector.ErrPermanentConfig):
+		return ReasonInvalidConfig
+	case errors.Is(err, collector.ErrTransient):
+		return ReasonUpstreamUnreachable
+	default:
+		return ReasonCreateNewMetricsCollector
+	}
+}
+
 // HPAProvider is a base provider for initializing metric collectors based on
 // HPA resources.
 type HPAProvider struct {
@@ -57,12 +154,56 @@ type HPAProvider struct {
 	collectorInterval         time.Duration
 	metricSink                chan metricCollection
 	hpaCache                  map[resourceReference]autoscalingv2.HorizontalPodAutoscaler
+	hpaCacheMu                sync.RWMutex
 	metricStore               *MetricStore
 	collectorFactory          *collector.CollectorFactory
 	recorder                  kube_record.EventRecorder
 	logger                    *log.Entry
 	disregardIncompatibleHPAs bool
+	metricsTTL                time.Duration
 	gcInterval                time.Duration
+	gcAfter                   func(d time.Duration) <-chan time.Time
+	queryRecorder             *queryRecorder
+	smoothedValues            *smoothedValues
+	suppressedEventReasons    map[string]struct{}
+	serveAggregations         *serveAggregations
+	desiredReplicasMetric     bool
+	hpaTolerance              float64
+	pauseAnnotation           string
+	stateFile                 string
+	stateSaveInterval         time.Duration
+	statusAnnotations         *statusAnnotations
+	namespaceDefaults         *namespaceDefaults
+	// hpasAtMax are the HPAs whose metrics require more than their max
+	// replicas. It's nil if the detection is disabled.
+	hpasAtMax map[resourceReference]struct{}
+	// listBackoff is the backoff of retrying failed HPA lists within an
+	// update.
+	listBackoff wait.Backoff
+	// removalThreshold is the max fraction of the cached HPAs removed in
+	// a single update unless confirmed by the next update.
+	removalThreshold float64
+	// heldRemovals are the HPAs whose removal was held back by the last
+	// update. It's nil if no removal was held.
+	heldRemovals map[resourceReference]struct{}
+	// collectorTypes are the collector types of the metric configs of the
+	// cached HPAs, parsed with the namespace defaults.
+	collectorTypes map[resourceReference][]string
+	// clusterScopedExternalMetrics allows external metrics to be stored
+	// cluster scoped.
+	clusterScopedExternalMetrics bool
+	// sharding limits the collection to the HPAs owned by the shard. It's
+	// nil if sharding is disabled.
+	sharding *Sharding
+	// auditLog records the collectors started, updated and stopped. It's
+	// nil if the audit log is disabled.
+	auditLog *auditLog
+	// maxCollectionTimeout caps the deadline of a single collection. 0
+	// disables the cap.
+	maxCollectionTimeout time.Duration
+	// intervalWarnings are the collection intervals of the metrics of
+	// each HPA warned about for exceeding half the metrics TTL.
+	intervalWarnings map[resourceReference]map[collector.MetricTypeName]time.Duration
 }
 
 // metricCollection is a container for sending collected metrics across a
@@ -70,35 +211,162 @@ type HPAProvider struct {
 type metricCollection struct {
 	Values []collector.CollectedMetric
 	Error  error
+	// HPA is the HPA the collector collects metrics for.
+	HPA *autoscalingv2.HorizontalPodAutoscaler
+	// Metric is the name of the metric of the HPA the collector collects.
+	Metric string
+	// Subscribers are the HPAs subscribing to the shared collector
+	// collecting the metric. It's nil if the collector isn't shared.
+	Subscribers []*autoscalingv2.HorizontalPodAutoscaler
+	// SpanContext is the span context of the collection. It's invalid if
+	// tracing is disabled.
+	SpanContext trace.SpanContext
+}
+
+// hpas returns the HPAs the metrics are collected for.
+func (c metricCollection) hpas() []*autoscalingv2.HorizontalPodAutoscaler {
+	if c.Subscribers != nil {
+		return c.Subscribers
+	}
+	if c.HPA != nil {
+		return []*autoscalingv2.HorizontalPodAutoscaler{c.HPA}
+	}
+	return nil
 }
 
 // NewHPAProvider initializes a new HPAProvider.
 func NewHPAProvider(client kubernetes.Interface, interval, collectorInterval time.Duration, collectorFactory *collector.CollectorFactory, disregardIncompatibleHPAs bool, metricsTTL time.Duration, gcInterval time.Duration) *HPAProvider {
 	metricsc := make(chan metricCollection)
 
+	metricStore := NewMetricStore(func() time.Time {
+		return time.Now().UTC().Add(metricsTTL)
+	})
+	if collectorInterval > 0 {
+		metricStore.collisionWindow = collectorInterval
+	}
+
 	return &HPAProvider{
-		client:            client,
-		interval:          interval,
-		collectorInterval: collectorInterval,
-		metricSink:        metricsc,
-		metricStore: NewMetricStore(func() time.Time {
-			return time.Now().UTC().Add(metricsTTL)
-		}),
+		client:                    client,
+		interval:                  interval,
+		collectorInterval:         collectorInterval,
+		metricSink:                metricsc,
+		metricStore:               metricStore,
 		collectorFactory:          collectorFactory,
 		recorder:                  recorder.CreateEventRecorder(client),
 		logger:                    log.WithFields(log.Fields{"provider": "hpa"}),
 		disregardIncompatibleHPAs: disregardIncompatibleHPAs,
+		metricsTTL:                metricsTTL,
 		gcInterval:                gcInterval,
+		gcAfter:                   time.After,
+		serveAggregations:         newServeAggregations(),
+		smoothedValues:            newSmoothedValues(metricsTTL),
+		pauseAnnotation:           annotations.DefaultPauseAnnotation,
+		listBackoff: wait.Backoff{
+			Duration: time.Second,
+			Factor:   2,
+			Jitter:   0.1,
+			Steps:    4,
+		},
+		removalThreshold: DefaultHPARemovalThreshold,
+		intervalWarnings: map[resourceReference]map[collector.MetricTypeName]time.Duration{},
 	}
 }
 
+// SetPauseAnnotation sets the annotation pausing the metric collection of
+// an HPA when set to "true". An empty annotation disables pausing.
+func (p *HPAProvider) SetPauseAnnotation(annotation string) {
+	p.pauseAnnotation = annotation
+}
+
+// SetMaxCollectionTimeout caps the deadline of a single collection, which
+// is the collection interval by default. 0 disables the cap.
+func (p *HPAProvider) SetMaxCollectionTimeout(timeout time.Duration) {
+	p.maxCollectionTimeout = timeout
+}
+
+// SetRemovalThreshold sets the max fraction of the cached HPAs removed in a
+// single update. If an update would remove more, e.g. because of a
+// truncated list, the removal is held back until the next update confirms
+// it. A threshold of 1 disables the check.
+func (p *HPAProvider) SetRemovalThreshold(threshold float64) {
+	p.removalThreshold = threshold
+}
+
+// AllowClusterScopedExternalMetrics allows external metrics configured with
+// cluster-scoped: "true" to be stored without namespace. Cluster scoped
+// series are served for queries of any namespace, e.g. for controllers
+// reading the metrics of HPAs in other namespaces.
+func (p *HPAProvider) AllowClusterScopedExternalMetrics() {
+	p.clusterScopedExternalMetrics = true
+}
+
+// EnableSharding limits the collection of metrics to the HPAs owned by the
+// shard. HPAs of other shards are handled like HPAs which don't exist.
+func (p *HPAProvider) EnableSharding(sharding *Sharding) {
+	p.sharding = sharding
+}
+
+// EnableQueryRecording enables recording of the last size effective
+// queries per metric. The recorded queries are exposed via the
+// DebugCollectorsHandler.
+func (p *HPAProvider) EnableQueryRecording(size int) {
+	p.queryRecorder = newQueryRecorder(size)
+}
+
+// EnableEventDeduplication records identical events at most once per
+// window to avoid flooding the API server with events for HPAs which
+// stay misconfigured.
+func (p *HPAProvider) EnableEventDeduplication(window time.Duration) {
+	p.recorder = recorder.NewDeduplicatingRecorder(p.recorder, window)
+}
+
+// SuppressEventReasons disables recording events for collector creation
+// failures with one of the reasons. The failures are still counted in the
+// CollectorCreationFailures metric.
+func (p *HPAProvider) SuppressEventReasons(reasons []string) error {
+	suppressed := make(map[string]struct{}, len(reasons))
+	for _, reason := range reasons {
+		if !slices.Contains(collectorCreationFailureReasons, reason) {
+			return fmt.Errorf("unknown event reason '%s', must be one of %s", reason, strings.Join(collectorCreationFailureReasons, ", "))
+		}
+		suppressed[reason] = struct{}{}
+	}
+	p.suppressedEventReasons = suppressed
+	return nil
+}
+
 // Run runs the HPA resource discovery and metric collection.
 func (p *HPAProvider) Run(ctx context.Context) {
 	// initialize collector table
 	p.collectorScheduler = NewCollectorScheduler(ctx, p.metricSink)
+	p.collectorScheduler.audit = p.auditLog
+	p.collectorScheduler.maxCollectionTimeout = p.maxCollectionTimeout
+	if p.auditLog != nil {
+		go p.auditLog.run(ctx)
+	}
+
+	if p.namespaceDefaults != nil && !p.namespaceDefaults.start(ctx) {
+		p.logger.Error("Failed to sync the namespace cache, namespace defaults are ignored until it's synced")
+	}
+
+	if p.stateFile != "" {
+		err := p.loadState()
+		if err != nil {
+			p.logger.Errorf("Failed to load metric store state: %v", err)
+		}
+		go p.runStatePersistence(ctx)
+	}
 
 	go p.collectMetrics(ctx)
 
+	if p.statusAnnotations != nil {
+		go p.runStatusAnnotations(ctx)
+	}
+
+	if p.hpasAtMax != nil {
+		go p.runMaxReplicasDetection(ctx)
+	}
+
 	for {
 		err := p.updateHPAs()
 		if err != nil {
@@ -122,14 +390,25 @@ func (p *HPAProvider) Run(ctx context.Context) {
 func (p *HPAProvider) updateHPAs() error {
 	p.logger.Info("Looking for HPAs")
 
-	hpas, err := p.client.AutoscalingV2().HorizontalPodAutoscalers(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	hpas, err := p.listHPAs()
 	if err != nil {
 		return err
 	}
 
+	if p.sharding != nil {
+		hpas.Items = p.sharding.filter(hpas.Items)
+		OwnedHPAs.Set(float64(len(hpas.Items)))
+	}
+
+	held := p.holdRemovals(hpas.Items)
+
 	newHPACache := make(map[resourceReference]autoscalingv2.HorizontalPodAutoscaler, len(hpas.Items))
+	appliedDefaults := make(map[resourceReference]map[string]string, len(hpas.Items))
+
+	collectorTypes := make(map[resourceReference][]string, len(hpas.Items))
 
 	newHPAs := 0
+	paused := make(map[resourceReference]struct{})
 
 	for _, hpa := range hpas.Items {
 		hpa := *hpa.DeepCopy()
@@ -138,21 +417,61 @@ func (p *HPAProvider) updateHPAs() error {
 			Namespace: hpa.Namespace,
 		}
 
+		// HPAs being deleted or paused are not cached, so their
+		// collectors are removed below.
+		if hpa.DeletionTimestamp != nil {
+			p.logger.Debugf("Skipping HPA being deleted: %s", resourceRef)
+			continue
+		}
+
+		if annotations.IsPaused(hpa.Annotations, p.pauseAnnotation) {
+			p.logger.Debugf("Skipping paused HPA: %s", resourceRef)
+			paused[resourceRef] = struct{}{}
+			continue
+		}
+
 		cachedHPA, ok := p.hpaCache[resourceRef]
-		hpaUpdated := !equalHPA(cachedHPA, hpa)
+		defaults := p.namespaceDefaults.get(hpa.Namespace)
+		defaultsUpdated := p.namespaceDefaults.changed(resourceRef, defaults)
+		hpaUpdated := !equalHPA(cachedHPA, hpa) || defaultsUpdated
+
+		// if only the collection intervals have changed, update the
+		// running collectors instead of recreating them.
+		if ok && hpaUpdated && !defaultsUpdated && equalHPAIgnoringIntervals(cachedHPA, hpa) && p.updateIntervals(resourceRef, &hpa) {
+			p.logger.Infof("Updated collection intervals of metrics collector: %s", resourceRef)
+			newHPAs++
+			newHPACache[resourceRef] = hpa
+			appliedDefaults[resourceRef] = defaults
+			collectorTypes[resourceRef] = p.collectorTypes[resourceRef]
+			continue
+		}
+
 		if !ok || hpaUpdated {
 			// if the hpa has changed then remove the previous
 			// scheduled collector.
 			if hpaUpdated {
 				p.logger.Infof("Removing previously scheduled metrics collector: %s", resourceRef)
-				p.collectorScheduler.Remove(resourceRef)
+				p.collectorScheduler.Remove(resourceRef, auditReasonSpecChanged)
+			}
+
+			reason := auditReasonNew
+			if ok {
+				reason = auditReasonSpecChanged
 			}
 
-			metricConfigs, err := collector.ParseHPAMetrics(&hpa)
+			metricConfigs, warnings, err := collector.ParseHPAMetricsWithDefaults(&hpa, defaults)
+			for _, warning := range warnings {
+				p.recorder.Eventf(&hpa, apiv1.EventTypeWarning, "UnattributedMetricConfig", "Failed to attribute metric config: %s", warning)
+			}
 			if err != nil {
 				p.logger.Errorf("Failed to parse HPA metrics: %v", err)
 				continue
 			}
+			collectorTypes[resourceRef] = metricCollectorTypes(metricConfigs)
+
+			for _, err := range p.serveAggregations.Set(&hpa, metricConfigs) {
+				p.recorder.Eventf(&hpa, apiv1.EventTypeWarning, ReasonInvalidConfig, "Failed to configure %s, serving all series: %v", ServeAggregationConfigKey, err)
+			}
 
 			cache := true
 			for _, config := range metricConfigs {
@@ -160,21 +479,49 @@ func (p *HPAProvider) updateHPAs() error {
 				if interval == 0 {
 					interval = p.collectorInterval
 				}
+				p.checkIntervalTTL(&hpa, config, interval)
 
-				c, err := p.collectorFactory.NewCollector(context.TODO(), &hpa, config, interval)
+				// collectors shared with another HPA are subscribed to
+				// instead of creating a new one.
+				hash, shared := p.sharedConfigHash(&hpa, config, interval)
+				if shared {
+					subscribed, err := p.collectorScheduler.Subscribe(&hpa, config.MetricTypeName, hash, reason)
+					if err != nil {
+						p.recorder.Eventf(&hpa, apiv1.EventTypeWarning, ReasonInvalidConfig, "Failed to share metric %s, collecting the metric for the HPA: %v", config.Metric.Name, err)
+						shared = false
+					}
+					if subscribed {
+						p.logger.Infof("Subscribed to shared metrics collector: %s", resourceRef)
+						continue
+					}
+				}
+
+				collectorCtx := collector.WithCollectorType(context.TODO(), collectorTypeLabel(config))
+				c, err := p.collectorFactory.NewCollector(collectorCtx, &hpa, config, interval)
 				if err != nil {
+					reason := collectorCreationFailureReason(err)
+					CollectorCreationFailures.WithLabelValues(reason).Inc()
 
+					_, suppressed := p.suppressedEventReasons[reason]
 					// Only log when it's not a PluginNotFoundError AND flag disregardIncompatibleHPAs is true
-					if !(errors.Is(err, &collector.PluginNotFoundError{}) && p.disregardIncompatibleHPAs) {
-						p.recorder.Eventf(&hpa, apiv1.EventTypeWarning, "CreateNewMetricsCollector", "Failed to create new metrics collector: %v", err)
+					if !suppressed && !(reason == ReasonPluginNotFound && p.disregardIncompatibleHPAs) {
+						p.recorder.Eventf(&hpa, apiv1.EventTypeWarning, reason, "Failed to create new metrics collector: %v", err)
 					}
 
 					cache = false
 					continue
 				}
 
+				c = p.scopeCollector(&hpa, config, c)
+
+				if shared {
+					p.logger.Infof("Adding new shared metrics collector: %T", c)
+					p.collectorScheduler.AddShared(&hpa, config.MetricTypeName, hash, collectorTypeLabel(config), c, reason)
+					continue
+				}
+
 				p.logger.Infof("Adding new metrics collector: %T", c)
-				p.collectorScheduler.Add(resourceRef, config.MetricTypeName, c)
+				p.collectorScheduler.Add(&hpa, config.MetricTypeName, collectorTypeLabel(config), c, reason)
 			}
 			newHPAs++
 
@@ -183,9 +530,25 @@ func (p *HPAProvider) updateHPAs() error {
 			if !cache {
 				continue
 			}
+		} else {
+			collectorTypes[resourceRef] = p.collectorTypes[resourceRef]
 		}
 
 		newHPACache[resourceRef] = hpa
+		appliedDefaults[resourceRef] = defaults
+	}
+
+	// HPAs whose removal is held back keep their collectors.
+	for ref := range held {
+		newHPACache[ref] = p.hpaCache[ref]
+		collectorTypes[ref] = p.collectorTypes[ref]
+		if p.namespaceDefaults != nil {
+			appliedDefaults[ref] = p.namespaceDefaults.applied[ref]
+		}
+	}
+
+	if p.namespaceDefaults != nil {
+		p.namespaceDefaults.applied = appliedDefaults
 	}
 
 	for ref := range p.hpaCache {
@@ -193,53 +556,212 @@ func (p *HPAProvider) updateHPAs() error {
 			continue
 		}
 
+		reason := auditReasonHPADeleted
+		if _, ok := paused[ref]; ok {
+			reason = auditReasonHPAPaused
+		}
+
 		p.logger.Infof("Removing previously scheduled metrics collector: %s", ref)
-		p.collectorScheduler.Remove(ref)
+		p.collectorScheduler.Remove(ref, reason)
+		p.serveAggregations.Remove(ref)
+		collector.ForgetPrometheusResultMetrics(ref.Namespace, ref.Name)
+		p.forgetMaxReplicas(ref)
+		p.forgetIntervalWarnings(ref)
 	}
 
+	if p.statusAnnotations != nil {
+		p.statusAnnotations.Forget(func(ref resourceReference) bool {
+			_, ok := newHPACache[ref]
+			return ok
+		})
+	}
+
+	p.collectorTypes = collectorTypes
+	updateHPAsByCollector(collectorTypes)
+
 	p.logger.Infof("Found %d new/updated HPA(s)", newHPAs)
+	p.hpaCacheMu.Lock()
 	p.hpaCache = newHPACache
+	p.hpaCacheMu.Unlock()
 
 	return nil
 }
 
+// listHPAs lists all HPAs. Failures are retried with the list backoff, so a
+// short apiserver outage doesn't fail the whole update.
+func (p *HPAProvider) listHPAs() (*autoscalingv2.HorizontalPodAutoscalerList, error) {
+	var hpas *autoscalingv2.HorizontalPodAutoscalerList
+	err := retry.OnError(p.listBackoff, func(error) bool { return true }, func() error {
+		var err error
+		hpas, err = p.client.AutoscalingV2().HorizontalPodAutoscalers(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			p.logger.Warnf("Failed to list HPAs: %v", err)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list HPAs: %w", err)
+	}
+	return hpas, nil
+}
+
+// holdRemovals returns the cached HPAs missing from the listed HPAs if they
+// are more than the removal threshold of the cached HPAs. Such a drop is more
+// likely caused by an incomplete list than by a mass deletion, so the
+// returned HPAs are kept with their collectors for this update, while added
+// and updated HPAs are still applied. If the next update is missing the same
+// HPAs, the drop is confirmed and nil is returned to remove them.
+func (p *HPAProvider) holdRemovals(hpas []autoscalingv2.HorizontalPodAutoscaler) map[resourceReference]struct{} {
+	listed := make(map[resourceReference]struct{}, len(hpas))
+	for _, hpa := range hpas {
+		listed[resourceReference{Name: hpa.Name, Namespace: hpa.Namespace}] = struct{}{}
+	}
+
+	missing := make(map[resourceReference]struct{})
+	for ref := range p.hpaCache {
+		if _, ok := listed[ref]; !ok {
+			missing[ref] = struct{}{}
+		}
+	}
+
+	if float64(len(missing)) <= p.removalThreshold*float64(len(p.hpaCache)) || maps.Equal(p.heldRemovals, missing) {
+		p.heldRemovals = nil
+		return nil
+	}
+
+	p.heldRemovals = missing
+	HPARemovalsHeld.Inc()
+	p.logger.Errorf("Holding back the removal of %d of %d HPAs missing from the list, more than the removal threshold of %.0f%%. They are removed if the next update is missing the same HPAs.", len(missing), len(p.hpaCache), p.removalThreshold*100)
+	return missing
+}
+
+// updateIntervals updates the intervals of all running collectors of an HPA.
+// It returns false if not all collectors could be updated, in which case the
+// collectors must be recreated.
+func (p *HPAProvider) updateIntervals(resourceRef resourceReference, hpa *autoscalingv2.HorizontalPodAutoscaler) bool {
+	metricConfigs, _, err := p.parseHPAMetrics(hpa)
+	if err != nil {
+		return false
+	}
+
+	for _, config := range metricConfigs {
+		interval := config.Interval
+		if interval == 0 {
+			interval = p.collectorInterval
+		}
+		p.checkIntervalTTL(hpa, config, interval)
+
+		if !p.collectorScheduler.UpdateInterval(resourceRef, config.MetricTypeName, interval) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// equalHPAIgnoringIntervals returns true if two HPAs are identical apart
+// from their status and the interval annotations.
+func equalHPAIgnoringIntervals(a, b autoscalingv2.HorizontalPodAutoscaler) bool {
+	a.ObjectMeta.Annotations = withoutIntervalAnnotations(a.ObjectMeta.Annotations)
+	b.ObjectMeta.Annotations = withoutIntervalAnnotations(b.ObjectMeta.Annotations)
+	return equalHPA(a, b)
+}
+
+// withoutIntervalAnnotations returns a copy of the annotations without the
+// metric interval annotations.
+func withoutIntervalAnnotations(hpaAnnotations map[string]string) map[string]string {
+	filtered := make(map[string]string, len(hpaAnnotations))
+	for k, v := range hpaAnnotations {
+		if annotations.IsIntervalAnnotation(k) {
+			continue
+		}
+		filtered[k] = v
+	}
+	return filtered
+}
+
 // equalHPA returns true if two HPAs are identical (apart from their status).
 func equalHPA(a, b autoscalingv2.HorizontalPodAutoscaler) bool {
 	// reset resource version to not compare it since this will change
 	// whenever the status of the object is updated. We only want to
-	// compare the metadata and the spec.
+	// compare the metadata and the spec. The status annotation and the
+	// managed fields change with every status annotation written by the
+	// adapter itself, which mustn't recreate the collectors.
 	a.ObjectMeta.ResourceVersion = ""
 	b.ObjectMeta.ResourceVersion = ""
+	a.ObjectMeta.ManagedFields = nil
+	b.ObjectMeta.ManagedFields = nil
+	a.ObjectMeta.Annotations = withoutStatusAnnotation(a.ObjectMeta.Annotations)
+	b.ObjectMeta.Annotations = withoutStatusAnnotation(b.ObjectMeta.Annotations)
 	return reflect.DeepEqual(a.ObjectMeta, b.ObjectMeta) && reflect.DeepEqual(a.Spec, b.Spec)
 }
 
+// withoutStatusAnnotation returns the annotations without the
+// StatusAnnotation. The annotations are copied if they contain it.
+func withoutStatusAnnotation(hpaAnnotations map[string]string) map[string]string {
+	if _, ok := hpaAnnotations[StatusAnnotation]; !ok {
+		return hpaAnnotations
+	}
+
+	filtered := make(map[string]string, len(hpaAnnotations))
+	for k, v := range hpaAnnotations {
+		if k != StatusAnnotation {
+			filtered[k] = v
+		}
+	}
+	return filtered
+}
+
+// runGarbageCollection removes expired metrics from the metric store and
+// expired smoothed values every gcInterval until the context is canceled.
+func (p *HPAProvider) runGarbageCollection(ctx context.Context) {
+	for {
+		select {
+		case <-p.gcAfter(p.gcInterval):
+			removed := p.metricStore.RemoveExpired()
+			ExpiredMetricsRemoved.Add(float64(removed))
+			p.logger.Infof("Removed %d expired metric(s)", removed)
+			if p.smoothedValues != nil {
+				p.smoothedValues.RemoveExpired()
+			}
+		case <-ctx.Done():
+			p.logger.Info("Stopped metrics store garbage collection.")
+			return
+		}
+	}
+}
+
 // collectMetrics collects all metrics from collectors and manages a central
 // metric store.
 func (p *HPAProvider) collectMetrics(ctx context.Context) {
-	// run garbage collection every 10 minutes
-	go func(ctx context.Context) {
-		for {
-			select {
-			case <-time.After(p.gcInterval):
-				p.metricStore.RemoveExpired()
-			case <-ctx.Done():
-				p.logger.Info("Stopped metrics store garbage collection.")
-				return
-			}
-		}
-	}(ctx)
+	go p.runGarbageCollection(ctx)
 
 	for {
 		select {
 		case collection := <-p.metricSink:
 			if collection.Error != nil {
 				p.logger.Errorf("Failed to collect metrics: %v", collection.Error)
-				CollectionErrors.Inc()
+				incWithExemplar(CollectionErrors, collection.SpanContext)
+
+				// the collector is stopped after a permanent
+				// error, so the event is only emitted once until
+				// the HPA is changed.
+				if errors.Is(collection.Error, collector.ErrPermanentConfig) {
+					for _, hpa := range collection.hpas() {
+						p.recorder.Eventf(hpa, apiv1.EventTypeWarning, "MetricsCollectorStopped", "Stopped metrics collector after permanent error: %v", collection.Error)
+					}
+				}
 			} else {
-				CollectionSuccesses.Inc()
+				incWithExemplar(CollectionSuccesses, collection.SpanContext)
 			}
 
 			p.logger.Infof("Collected %d new metric(s)", len(collection.Values))
+			var source resourceReference
+			if collection.HPA != nil {
+				source = resourceReference{Namespace: collection.HPA.Namespace, Name: collection.HPA.Name}
+			}
+
+			rejected := 0
 			for _, value := range collection.Values {
 				switch value.Type {
 				case autoscalingv2.ObjectMetricSourceType, autoscalingv2.PodsMetricSourceType:
@@ -258,7 +780,32 @@ func (p *HPAProvider) collectMetrics(ctx context.Context) {
 						labels.Set(value.External.MetricLabels).String(),
 					)
 				}
-				p.metricStore.Insert(value)
+				if !p.metricStore.insertFrom(source, value) {
+					rejected++
+					continue
+				}
+				if p.queryRecorder != nil {
+					p.queryRecorder.Record(value)
+				}
+				if p.smoothedValues != nil {
+					p.smoothedValues.Record(value)
+				}
+			}
+
+			if rejected > 0 {
+				p.logger.Infof("Dropped %d invalid metric(s) of %d collected", rejected, len(collection.Values))
+			}
+
+			for _, hpa := range collection.hpas() {
+				if p.statusAnnotations != nil {
+					subscribed := collection
+					subscribed.HPA = hpa
+					p.statusAnnotations.Record(subscribed)
+				}
+
+				if p.desiredReplicasMetric && len(collection.Values) > 0 {
+					p.updateDesiredReplicas(hpa)
+				}
 			}
 		case <-ctx.Done():
 			p.logger.Info("Stopped metrics collection.")
@@ -287,14 +834,39 @@ func (p *HPAProvider) ListAllMetrics() []provider.CustomMetricInfo {
 	return p.metricStore.ListAllMetrics()
 }
 
+// GetExternalMetric gets the external metric from the metric store. If
+// the HPAs requesting the metric configured a serve aggregation the matching
+// series are aggregated into a single item on every request.
 func (p *HPAProvider) GetExternalMetric(ctx context.Context, namespace string, metricSelector labels.Selector, info provider.ExternalMetricInfo) (*external_metrics.ExternalMetricValueList, error) {
-	return p.metricStore.GetExternalMetric(ctx, objectNamespace(namespace), metricSelector, info)
+	metrics, err := p.metricStore.GetExternalMetric(ctx, objectNamespace(namespace), metricSelector, info)
+	if err != nil {
+		return nil, err
+	}
+
+	aggregation := p.serveAggregations.Get(externalMetricIdentity{
+		namespace: objectNamespace(namespace),
+		metric:    metricName(info.Metric),
+		selector:  metricSelector.String(),
+	})
+	metrics.Items = aggregateExternalMetrics(metrics.Items, aggregation)
+
+	return metrics, nil
 }
 
 func (p *HPAProvider) ListAllExternalMetrics() []provider.ExternalMetricInfo {
 	return p.metricStore.ListAllExternalMetrics()
 }
 
+// LaggingCollectorsRatio returns the fraction of scheduled collectors whose
+// last collection is older than twice their interval. It implements the
+// collector.CollectionLagSource interface.
+func (p *HPAProvider) LaggingCollectorsRatio() float64 {
+	if p.collectorScheduler == nil {
+		return 0
+	}
+	return p.collectorScheduler.LaggingCollectorsRatio(time.Now())
+}
+
 type resourceReference struct {
 	Name      string
 	Namespace string
@@ -304,59 +876,314 @@ type resourceReference struct {
 // It keeps track of all running collectors and stops them if they are to be
 // removed.
 type CollectorScheduler struct {
-	ctx        context.Context
-	table      map[resourceReference]map[collector.MetricTypeName]context.CancelFunc
+	ctx   context.Context
+	table map[resourceReference]map[collector.MetricTypeName]*scheduledCollector
+	// shared are the collectors shared by HPAs. The table entries of all
+	// subscribing HPAs point to the scheduled collector.
+	shared     map[sharedCollectorKey]*sharedCollector
 	metricSink chan<- metricCollection
+	// audit records the collectors added, updated and removed. It's nil
+	// if the audit log is disabled.
+	audit *auditLog
+	// maxCollectionTimeout is the max deadline of a single collection. The
+	// deadline is the collection interval if it's shorter or the max is
+	// 0.
+	maxCollectionTimeout time.Duration
 	sync.RWMutex
 }
 
+// scheduledCollector is an entry in the collector table of the
+// CollectorScheduler.
+type scheduledCollector struct {
+	cancel context.CancelFunc
+	// interval is the collection interval of the collector. It can be
+	// updated while the collector is running.
+	interval atomic.Int64
+	// intervalUpdated notifies the runner about an interval update.
+	intervalUpdated chan struct{}
+	// lastCollection is the time (in unix nanoseconds) of the last
+	// finished collection. It's initialized with the time the collector
+	// was added.
+	lastCollection atomic.Int64
+	// maxTimeout is the max deadline of a single collection, 0 if the
+	// deadline is the interval.
+	maxTimeout time.Duration
+	// stopped is set when the runner stopped because of a permanent
+	// error. The collector is restarted once the HPA changes.
+	stopped atomic.Bool
+	// lastError is the error of the last finished collection, empty if
+	// it succeeded. It's nil until the first collection finished.
+	lastError atomic.Pointer[string]
+	// metric is the name of the collected metric.
+	metric string
+	// collectorType is the type the collector is attributed to.
+	collectorType string
+	// shared is the key of the shared collector, nil if the collector
+	// collects for a single HPA.
+	shared *sharedCollectorKey
+	// subscribers are the HPAs subscribing to the shared collector. The
+	// collections are attributed to the first subscriber.
+	subscribers atomic.Pointer[[]*autoscalingv2.HorizontalPodAutoscaler]
+}
+
+func newScheduledCollector(cancel context.CancelFunc, interval time.Duration) *scheduledCollector {
+	scheduled := &scheduledCollector{
+		cancel:          cancel,
+		intervalUpdated: make(chan struct{}, 1),
+	}
+	scheduled.interval.Store(int64(interval))
+	scheduled.lastCollection.Store(time.Now().UnixNano())
+	return scheduled
+}
+
+// setInterval updates the interval of the scheduled collector and notifies
+// the runner about the change.
+func (s *scheduledCollector) setInterval(interval time.Duration) {
+	s.interval.Store(int64(interval))
+	select {
+	case s.intervalUpdated <- struct{}{}:
+	default:
+	}
+}
+
+// collectionContext returns the context of a single collection. Its
+// deadline is the interval capped to the max timeout.
+func (s *scheduledCollector) collectionContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := time.Duration(s.interval.Load())
+	if s.maxTimeout > 0 && (timeout <= 0 || s.maxTimeout < timeout) {
+		timeout = s.maxTimeout
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// nextTick returns the first tick after now of a cadence starting at start
+// and the number of ticks which passed in between.
+func nextTick(start, now time.Time, interval time.Duration) (time.Time, int) {
+	if interval <= 0 {
+		return now, 0
+	}
+	passed := int(now.Sub(start) / interval)
+	if passed < 0 {
+		passed = 0
+	}
+	return start.Add(time.Duration(passed+1) * interval), passed
+}
+
+// wait waits until the next tick after the start of the last collection at
+// the current interval. The ticks are aligned to the start of the
+// collections, so the duration of the collections doesn't shift the
+// cadence. It returns false if the context is canceled.
+func (s *scheduledCollector) wait(ctx context.Context, start time.Time) bool {
+	for {
+		next, _ := nextTick(start, time.Now(), time.Duration(s.interval.Load()))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+			return true
+		case <-s.intervalUpdated:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		}
+	}
+}
+
 // NewCollectorScheudler initializes a new CollectorScheduler.
 func NewCollectorScheduler(ctx context.Context, metricsc chan<- metricCollection) *CollectorScheduler {
 	return &CollectorScheduler{
 		ctx:        ctx,
-		table:      map[resourceReference]map[collector.MetricTypeName]context.CancelFunc{},
+		table:      map[resourceReference]map[collector.MetricTypeName]*scheduledCollector{},
+		shared:     map[sharedCollectorKey]*sharedCollector{},
 		metricSink: metricsc,
 	}
 }
 
-// Add adds a new collector to the collector scheduler. Once the collector is
-// added it will be started to collect metrics.
-func (t *CollectorScheduler) Add(resourceRef resourceReference, typeName collector.MetricTypeName, metricCollector collector.Collector) {
+// collectorTypeLabel returns the collector type used to attribute the
+// Kubernetes API requests of the collector of a metric config.
+func collectorTypeLabel(config *collector.MetricConfig) string {
+	if config.CollectorType != "" {
+		return config.CollectorType
+	}
+	return strings.ToLower(string(config.Type))
+}
+
+// Add adds a new collector for the HPA to the collector scheduler. Once the
+// collector is added it will be started to collect metrics. Kubernetes API
+// requests made by the collector are attributed to the collector type. The
+// reason is recorded in the audit log.
+func (t *CollectorScheduler) Add(hpa *autoscalingv2.HorizontalPodAutoscaler, typeName collector.MetricTypeName, collectorType string, metricCollector collector.Collector, reason string) {
 	t.Lock()
 	defer t.Unlock()
 
+	resourceRef := resourceReference{
+		Name:      hpa.Name,
+		Namespace: hpa.Namespace,
+	}
+
+	// stop old collector
+	t.release(resourceRef, typeName)
+
+	ctx, scheduled := t.schedule(typeName, collectorType, metricCollector.Interval())
+	t.entries(resourceRef)[typeName] = scheduled
+	ActiveCollectors.Set(float64(t.count()))
+	t.audit.record(auditActionAdd, reason, resourceRef, typeName, collectorType)
+
+	t.run(ctx, hpa, typeName, metricCollector, scheduled)
+}
+
+// schedule creates a new scheduled collector and the context of its
+// runner.
+func (t *CollectorScheduler) schedule(typeName collector.MetricTypeName, collectorType string, interval time.Duration) (context.Context, *scheduledCollector) {
+	ctx, cancel := context.WithCancel(collector.WithCollectorType(t.ctx, collectorType))
+	scheduled := newScheduledCollector(cancel, interval)
+	scheduled.metric = typeName.Metric.Name
+	scheduled.collectorType = collectorType
+	scheduled.maxTimeout = t.maxCollectionTimeout
+	return ctx, scheduled
+}
+
+// run starts the runner of the scheduled collector.
+func (t *CollectorScheduler) run(ctx context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, typeName collector.MetricTypeName, metricCollector collector.Collector, scheduled *scheduledCollector) {
+	resourceRef := resourceReference{Name: hpa.Name, Namespace: hpa.Namespace}
+	go func() {
+		collectorRunner(ctx, hpa, metricCollector, scheduled, t.metricSink)
+		if scheduled.stopped.Load() {
+			t.audit.record(auditActionStop, auditReasonError, resourceRef, typeName, scheduled.collectorType)
+		}
+	}()
+}
+
+// entries returns the collectors of the HPA, creating them if the HPA has
+// none. The caller must hold the lock.
+func (t *CollectorScheduler) entries(resourceRef resourceReference) map[collector.MetricTypeName]*scheduledCollector {
 	collectors, ok := t.table[resourceRef]
 	if !ok {
-		collectors = map[collector.MetricTypeName]context.CancelFunc{}
+		collectors = map[collector.MetricTypeName]*scheduledCollector{}
 		t.table[resourceRef] = collectors
 	}
+	return collectors
+}
+
+// release removes the collector of the HPA for the metric, if any. The
+// caller must hold the lock.
+func (t *CollectorScheduler) release(resourceRef resourceReference, typeName collector.MetricTypeName) {
+	if scheduled, ok := t.table[resourceRef][typeName]; ok {
+		delete(t.table[resourceRef], typeName)
+		t.unschedule(resourceRef, scheduled)
+	}
+}
+
+// unschedule stops the collector of the HPA. Shared collectors are only
+// stopped once the last subscribing HPA is unsubscribed. The caller must
+// hold the lock.
+func (t *CollectorScheduler) unschedule(resourceRef resourceReference, scheduled *scheduledCollector) {
+	if scheduled.shared != nil {
+		shared, ok := t.shared[*scheduled.shared]
+		if ok && shared.scheduled == scheduled {
+			if shared.unsubscribe(resourceRef) > 0 {
+				shared.publish()
+				return
+			}
+			delete(t.shared, *scheduled.shared)
+		}
+	}
+	scheduled.cancel()
+}
 
-	if cancelCollector, ok := collectors[typeName]; ok {
-		// stop old collector
-		cancelCollector()
+// UpdateInterval updates the interval of a running collector without
+// restarting it. The new interval is applied relative to the last
+// collection. It returns false if no such collector is scheduled or if it
+// was stopped by a permanent error, so it's recreated instead.
+func (t *CollectorScheduler) UpdateInterval(resourceRef resourceReference, typeName collector.MetricTypeName, interval time.Duration) bool {
+	t.RLock()
+	defer t.RUnlock()
+
+	// the interval of shared collectors is part of the config hash, so
+	// they have to be subscribed again.
+	scheduled, ok := t.table[resourceRef][typeName]
+	if !ok || scheduled.shared != nil || scheduled.stopped.Load() {
+		return false
 	}
 
-	ctx, cancel := context.WithCancel(t.ctx)
-	collectors[typeName] = cancel
+	if time.Duration(scheduled.interval.Load()) != interval {
+		t.audit.record(auditActionUpdate, auditReasonIntervalChanged, resourceRef, typeName, scheduled.collectorType)
+	}
+	scheduled.setInterval(interval)
+	return true
+}
 
-	// start runner for new collector
-	go collectorRunner(ctx, metricCollector, t.metricSink)
+// count returns the number of scheduled collectors. Shared collectors are
+// counted once. The caller must hold the lock.
+func (t *CollectorScheduler) count() int {
+	scheduled := map[*scheduledCollector]struct{}{}
+	for _, collectors := range t.table {
+		for _, c := range collectors {
+			scheduled[c] = struct{}{}
+		}
+	}
+	return len(scheduled)
 }
 
-// collectorRunner runs a collector at the desirec interval. If the passed
-// context is canceled the collection will be stopped.
-func collectorRunner(ctx context.Context, collector collector.Collector, metricsc chan<- metricCollection) {
+// collectorRunner runs a collector at the desired interval. The collections
+// start on a fixed cadence aligned to the start of the first collection and
+// are canceled after the interval or the max timeout, whichever is shorter.
+// Ticks passing while a collection is still running are skipped, so slow
+// collectors fall back to the next tick instead of drifting. If the passed
+// context is canceled the collection will be stopped. Collections failing
+// with a permanent configuration error are not retried as they can't succeed
+// before the HPA is changed, which restarts the collector.
+func collectorRunner(ctx context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, metricCollector collector.Collector, scheduled *scheduledCollector, metricsc chan<- metricCollection) {
 	for {
-		values, err := collector.GetMetrics(ctx)
+		start := time.Now()
+		collectCtx, cancel := scheduled.collectionContext(ctx)
+		collectCtx, span := tracer.Start(collectCtx, "collect "+scheduled.metric, trace.WithAttributes(
+			attribute.String("hpa.namespace", hpa.Namespace),
+			attribute.String("hpa.name", hpa.Name),
+			attribute.String("collector.type", scheduled.collectorType),
+		))
+		values, err := metricCollector.GetMetrics(collectCtx)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		cancel()
 
-		metricsc <- metricCollection{
-			Values: values,
-			Error:  err,
+		collection := metricCollection{
+			Values:      values,
+			Error:       err,
+			HPA:         hpa,
+			Metric:      scheduled.metric,
+			SpanContext: span.SpanContext(),
+		}
+		if subscribers := scheduled.subscribers.Load(); subscribers != nil && len(*subscribers) > 0 {
+			collection.HPA = (*subscribers)[0]
+			collection.Subscribers = *subscribers
+		}
+		metricsc <- collection
+		scheduled.lastCollection.Store(time.Now().UnixNano())
+		lastError := ""
+		if err != nil {
+			lastError = err.Error()
 		}
+		scheduled.lastError.Store(&lastError)
 
-		select {
-		case <-time.After(collector.Interval()):
-		case <-ctx.Done():
+		if errors.Is(err, collector.ErrPermanentConfig) {
+			log.Warnf("stopping collector runner for %s/%s after permanent error: %v", hpa.Namespace, hpa.Name, err)
+			scheduled.stopped.Store(true)
+			return
+		}
+
+		interval := time.Duration(scheduled.interval.Load())
+		if _, skipped := nextTick(start, time.Now(), interval); skipped > 0 {
+			CollectorSkippedTicks.Add(float64(skipped))
+			log.Warnf("collection of metric %s for %s/%s took longer than its interval of %s, skipped %d ticks", scheduled.metric, hpa.Namespace, hpa.Name, interval, skipped)
+		}
+
+		if !scheduled.wait(ctx, start) {
 			log.Info("stopping collector runner...")
 			return
 		}
@@ -364,15 +1191,45 @@ func collectorRunner(ctx context.Context, collector collector.Collector, metrics
 }
 
 // Remove removes a collector from the Collector scheduler. The collector is
-// stopped before it's removed.
-func (t *CollectorScheduler) Remove(resourceRef resourceReference) {
+// stopped before it's removed. The reason is recorded in the audit log.
+func (t *CollectorScheduler) Remove(resourceRef resourceReference, reason string) {
 	t.Lock()
 	defer t.Unlock()
 
 	if collectors, ok := t.table[resourceRef]; ok {
-		for _, cancelCollector := range collectors {
-			cancelCollector()
+		for typeName, scheduled := range collectors {
+			t.unschedule(resourceRef, scheduled)
+			t.audit.record(auditActionRemove, reason, resourceRef, typeName, scheduled.collectorType)
 		}
 		delete(t.table, resourceRef)
+		ActiveCollectors.Set(float64(t.count()))
 	}
 }
+
+// LaggingCollectorsRatio returns the fraction of scheduled collectors whose
+// last collection is older than twice their interval.
+func (t *CollectorScheduler) LaggingCollectorsRatio(now time.Time) float64 {
+	t.RLock()
+	defer t.RUnlock()
+
+	total, lagging := 0, 0
+	for _, collectors := range t.table {
+		for _, scheduled := range collectors {
+			// stopped collectors are not expected to collect
+			if scheduled.stopped.Load() {
+				continue
+			}
+			total++
+			age := now.Sub(time.Unix(0, scheduled.lastCollection.Load()))
+			if age > 2*time.Duration(scheduled.interval.Load()) {
+				lagging++
+			}
+		}
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	return float64(lagging) / float64(total)
+}
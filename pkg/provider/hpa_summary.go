@@ -0,0 +1,195 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// HPASummaryPattern is the pattern of the HPA summary endpoint served by
+// HPASummaryHandler.
+const HPASummaryPattern = "GET /apis/metrics-debug/v1/namespaces/{namespace}/hpas/{name}"
+
+const (
+	// MetricHealthy is the health of a metric whose last collection
+	// succeeded.
+	MetricHealthy = "Healthy"
+	// MetricFailing is the health of a metric whose last collection
+	// failed. The collection is retried.
+	MetricFailing = "Failing"
+	// MetricStopped is the health of a metric whose collector stopped
+	// after a permanent error until the HPA is changed.
+	MetricStopped = "Stopped"
+	// MetricPending is the health of a metric which wasn't collected yet.
+	MetricPending = "Pending"
+	// MetricNotScheduled is the health of a metric without a collector,
+	// e.g. because the collector couldn't be created.
+	MetricNotScheduled = "NotScheduled"
+	// MetricNotCollected is the health of a metric not collected by the
+	// adapter, like Resource metrics.
+	MetricNotCollected = "NotCollected"
+)
+
+// hpaSummary is the response of the HPA summary endpoint.
+type hpaSummary struct {
+	Namespace string             `json:"namespace"`
+	Name      string             `json:"name"`
+	Metrics   []hpaMetricSummary `json:"metrics"`
+	// Error is set if the metric configs of the HPA can't be parsed.
+	Error string `json:"error,omitempty"`
+}
+
+// hpaMetricSummary describes a metric of an HPA.
+type hpaMetricSummary struct {
+	Type          string                     `json:"type"`
+	Metric        string                     `json:"metric"`
+	CollectorType string                     `json:"collectorType,omitempty"`
+	Target        autoscalingv2.MetricTarget `json:"target"`
+	// Value is the stored value of External and Object metrics. The
+	// series of External metrics are summed up like the HPA does.
+	Value          *resource.Quantity `json:"value,omitempty"`
+	LastCollection *time.Time         `json:"lastCollection,omitempty"`
+	LastError      string             `json:"lastError,omitempty"`
+	Health         string             `json:"health"`
+}
+
+// HPASummaryHandler returns an HTTP handler exposing the metrics of an HPA
+// with their targets, stored values and collection health. It must be
+// registered with HPASummaryPattern.
+func (p *HPAProvider) HPASummaryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		namespace, name := r.PathValue("namespace"), r.PathValue("name")
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			http.Error(w, fmt.Sprintf("invalid namespace '%s': %s", namespace, strings.Join(errs, ", ")), http.StatusBadRequest)
+			return
+		}
+
+		p.hpaCacheMu.RLock()
+		hpa, ok := p.hpaCache[resourceReference{Namespace: namespace, Name: name}]
+		p.hpaCacheMu.RUnlock()
+		if !ok {
+			http.Error(w, fmt.Sprintf("HPA %s/%s not found", namespace, name), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(p.hpaSummary(&hpa))
+		if err != nil {
+			p.logger.Errorf("Failed to encode HPA summary: %v", err)
+		}
+	})
+}
+
+// hpaSummary assembles the summary of the HPA from its spec, the collector
+// scheduler and the metric store.
+func (p *HPAProvider) hpaSummary(hpa *autoscalingv2.HorizontalPodAutoscaler) hpaSummary {
+	summary := hpaSummary{
+		Namespace: hpa.Namespace,
+		Name:      hpa.Name,
+		Metrics:   make([]hpaMetricSummary, 0, len(hpa.Spec.Metrics)),
+	}
+
+	// parseHPAMetrics returns a config for each metric of the spec
+	// collected by the adapter in the same order.
+	metricConfigs, _, err := p.parseHPAMetrics(hpa)
+	if err != nil {
+		summary.Error = err.Error()
+	}
+
+	resourceRef := resourceReference{Namespace: hpa.Namespace, Name: hpa.Name}
+	next := 0
+	for _, metric := range hpa.Spec.Metrics {
+		metricSummary := hpaMetricSummary{
+			Type:   string(metric.Type),
+			Health: MetricNotScheduled,
+		}
+
+		switch {
+		case metric.Type == autoscalingv2.ExternalMetricSourceType && metric.External != nil:
+			metricSummary.Metric = metric.External.Metric.Name
+			metricSummary.Target = metric.External.Target
+			if value, ok := p.metricStore.externalMetricSum(hpa.Namespace, metric.External.Metric); ok {
+				metricSummary.Value = &value
+			}
+		case metric.Type == autoscalingv2.ObjectMetricSourceType && metric.Object != nil:
+			metricSummary.Metric = metric.Object.Metric.Name
+			metricSummary.Target = metric.Object.Target
+			if value, ok := p.metricStore.objectMetricValue(hpa.Namespace, metric.Object); ok {
+				metricSummary.Value = &value
+			}
+		case metric.Type == autoscalingv2.PodsMetricSourceType && metric.Pods != nil:
+			metricSummary.Metric = metric.Pods.Metric.Name
+			metricSummary.Target = metric.Pods.Target
+		case metric.Type == autoscalingv2.ResourceMetricSourceType && metric.Resource != nil:
+			metricSummary.Metric = string(metric.Resource.Name)
+			metricSummary.Target = metric.Resource.Target
+			metricSummary.Health = MetricNotCollected
+		case metric.Type == autoscalingv2.ContainerResourceMetricSourceType && metric.ContainerResource != nil:
+			metricSummary.Metric = string(metric.ContainerResource.Name)
+			metricSummary.Target = metric.ContainerResource.Target
+			metricSummary.Health = MetricNotCollected
+		}
+
+		if next < len(metricConfigs) && metricSummary.Health != MetricNotCollected {
+			config := metricConfigs[next]
+			next++
+			metricSummary.CollectorType = inventoryCollectorType(config)
+
+			if p.collectorScheduler != nil {
+				if scheduled := p.collectorScheduler.find(resourceRef, config.MetricTypeName); scheduled != nil {
+					metricSummary.Health = scheduled.health()
+					if lastError := scheduled.lastError.Load(); lastError != nil {
+						lastCollection := time.Unix(0, scheduled.lastCollection.Load()).UTC()
+						metricSummary.LastCollection = &lastCollection
+						metricSummary.LastError = *lastError
+					}
+				}
+			}
+		}
+
+		summary.Metrics = append(summary.Metrics, metricSummary)
+	}
+
+	return summary
+}
+
+// health returns the health of the scheduled collector.
+func (s *scheduledCollector) health() string {
+	lastError := s.lastError.Load()
+	switch {
+	case s.stopped.Load():
+		return MetricStopped
+	case lastError == nil:
+		return MetricPending
+	case *lastError != "":
+		return MetricFailing
+	default:
+		return MetricHealthy
+	}
+}
+
+// find returns the scheduled collector of the HPA for the metric or nil if
+// there is none. The collectors are keyed by the metric identifier including
+// the selector pointer, so the selectors are compared by value.
+func (t *CollectorScheduler) find(resourceRef resourceReference, typeName collector.MetricTypeName) *scheduledCollector {
+	t.RLock()
+	defer t.RUnlock()
+
+	for scheduledTypeName, scheduled := range t.table[resourceRef] {
+		if scheduledTypeName.Type == typeName.Type &&
+			scheduledTypeName.Metric.Name == typeName.Metric.Name &&
+			equality.Semantic.DeepEqual(scheduledTypeName.Metric.Selector, typeName.Metric.Selector) {
+			return scheduled
+		}
+	}
+
+	return nil
+}
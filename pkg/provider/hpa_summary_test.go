@@ -0,0 +1,188 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscaling "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/metrics/pkg/apis/custom_metrics"
+)
+
+func newSummaryProvider(t *testing.T) *HPAProvider {
+	hpa := autoscaling.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: autoscaling.HorizontalPodAutoscalerSpec{
+			MaxReplicas: 10,
+			Metrics: []autoscaling.MetricSpec{
+				cpuMetric(),
+				externalMetricSpec("jobs", averageValueTarget("10")),
+				externalMetricSpec("lag", valueTarget("100")),
+				{
+					Type: autoscaling.ObjectMetricSourceType,
+					Object: &autoscaling.ObjectMetricSource{
+						DescribedObject: autoscaling.CrossVersionObjectReference{Kind: "Ingress", Name: "app", APIVersion: "networking.k8s.io/v1"},
+						Metric:          autoscaling.MetricIdentifier{Name: "requests-per-second"},
+						Target:          averageValueTarget("50"),
+					},
+				},
+				podsMetric("requests"),
+			},
+		},
+	}
+
+	p := NewHPAProvider(fake.NewSimpleClientset(), 1*time.Second, 1*time.Second, collector.NewCollectorFactory(), false, 1*time.Hour, 1*time.Hour)
+	p.hpaCache = map[resourceReference]autoscaling.HorizontalPodAutoscaler{
+		{Namespace: "default", Name: "app"}: hpa,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	p.collectorScheduler = NewCollectorScheduler(ctx, p.metricSink)
+
+	healthy := newScheduledCollector(func() {}, time.Minute)
+	healthy.lastCollection.Store(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC).UnixNano())
+	noError := ""
+	healthy.lastError.Store(&noError)
+
+	failing := newScheduledCollector(func() {}, time.Minute)
+	failing.lastCollection.Store(time.Date(2024, 1, 1, 12, 1, 0, 0, time.UTC).UnixNano())
+	upstreamError := "upstream unavailable"
+	failing.lastError.Store(&upstreamError)
+
+	// the table is keyed by selector pointers differing from the ones in
+	// the HPA spec.
+	p.collectorScheduler.table[resourceReference{Namespace: "default", Name: "app"}] = map[collector.MetricTypeName]*scheduledCollector{
+		{Type: autoscaling.ExternalMetricSourceType, Metric: externalMetricSpec("jobs", autoscaling.MetricTarget{}).External.Metric}: healthy,
+		{Type: autoscaling.ExternalMetricSourceType, Metric: externalMetricSpec("lag", autoscaling.MetricTarget{}).External.Metric}:  failing,
+		{Type: autoscaling.ObjectMetricSourceType, Metric: autoscaling.MetricIdentifier{Name: "requests-per-second"}}:                newScheduledCollector(func() {}, time.Minute),
+	}
+
+	insertExternalValue(p.metricStore, "jobs", "0", "30")
+	insertExternalValue(p.metricStore, "jobs", "1", "12")
+	p.metricStore.Insert(collector.CollectedMetric{
+		Type: autoscaling.ObjectMetricSourceType,
+		Custom: custom_metrics.MetricValue{
+			DescribedObject: custom_metrics.ObjectReference{Kind: "Ingress", Name: "app", Namespace: "default", APIVersion: "networking.k8s.io/v1"},
+			Metric:          custom_metrics.MetricIdentifier{Name: "requests-per-second"},
+			Value:           resource.MustParse("75"),
+		},
+	})
+
+	return p
+}
+
+func getHPASummary(p *HPAProvider, path string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.Handle(HPASummaryPattern, p.HPASummaryHandler())
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestHPASummaryHandler(t *testing.T) {
+	p := newSummaryProvider(t)
+
+	rec := getHPASummary(p, "/apis/metrics-debug/v1/namespaces/default/hpas/app")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var summary hpaSummary
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&summary))
+	require.Equal(t, "default", summary.Namespace)
+	require.Equal(t, "app", summary.Name)
+	require.Empty(t, summary.Error)
+	require.Len(t, summary.Metrics, 5)
+
+	cpu := summary.Metrics[0]
+	require.Equal(t, "cpu", cpu.Metric)
+	require.Equal(t, MetricNotCollected, cpu.Health)
+	require.Empty(t, cpu.CollectorType)
+
+	jobs := summary.Metrics[1]
+	require.Equal(t, "External", jobs.Type)
+	require.Equal(t, "jobs", jobs.Metric)
+	require.Equal(t, "jobs", jobs.CollectorType)
+	require.Equal(t, MetricHealthy, jobs.Health)
+	require.Equal(t, autoscaling.AverageValueMetricType, jobs.Target.Type)
+	require.Equal(t, int64(10), jobs.Target.AverageValue.Value())
+	// the series are summed up like the HPA does
+	require.Equal(t, int64(42), jobs.Value.Value())
+	require.Equal(t, time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), *jobs.LastCollection)
+	require.Empty(t, jobs.LastError)
+
+	lag := summary.Metrics[2]
+	require.Equal(t, "lag", lag.Metric)
+	require.Equal(t, MetricFailing, lag.Health)
+	require.Equal(t, "upstream unavailable", lag.LastError)
+	require.Equal(t, time.Date(2024, 1, 1, 12, 1, 0, 0, time.UTC), *lag.LastCollection)
+	require.Nil(t, lag.Value)
+
+	ingress := summary.Metrics[3]
+	require.Equal(t, "requests-per-second", ingress.Metric)
+	require.Equal(t, "ingress", ingress.CollectorType)
+	require.Equal(t, MetricPending, ingress.Health)
+	require.Equal(t, int64(75), ingress.Value.Value())
+	require.Nil(t, ingress.LastCollection)
+
+	pods := summary.Metrics[4]
+	require.Equal(t, "requests", pods.Metric)
+	require.Equal(t, MetricNotScheduled, pods.Health)
+}
+
+func TestHPASummaryHandlerStoppedCollector(t *testing.T) {
+	p := newSummaryProvider(t)
+	for typeName, scheduled := range p.collectorScheduler.table[resourceReference{Namespace: "default", Name: "app"}] {
+		if typeName.Metric.Name == "lag" {
+			scheduled.stopped.Store(true)
+		}
+	}
+
+	rec := getHPASummary(p, "/apis/metrics-debug/v1/namespaces/default/hpas/app")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var summary hpaSummary
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&summary))
+	require.Equal(t, MetricStopped, summary.Metrics[2].Health)
+	require.Equal(t, "upstream unavailable", summary.Metrics[2].LastError)
+}
+
+func TestHPASummaryHandlerErrors(t *testing.T) {
+	p := newSummaryProvider(t)
+
+	for _, tc := range []struct {
+		msg  string
+		path string
+		code int
+	}{
+		{
+			msg:  "unknown HPA",
+			path: "/apis/metrics-debug/v1/namespaces/default/hpas/unknown",
+			code: http.StatusNotFound,
+		},
+		{
+			msg:  "HPA in another namespace",
+			path: "/apis/metrics-debug/v1/namespaces/other/hpas/app",
+			code: http.StatusNotFound,
+		},
+		{
+			msg:  "invalid namespace",
+			path: "/apis/metrics-debug/v1/namespaces/Invalid_Namespace/hpas/app",
+			code: http.StatusBadRequest,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			rec := getHPASummary(p, tc.path)
+			require.Equal(t, tc.code, rec.Code)
+		})
+	}
+}
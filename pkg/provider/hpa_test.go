@@ -3,16 +3,25 @@ package provider
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
 	autoscaling "k8s.io/api/autoscaling/v2"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/metrics/pkg/apis/custom_metrics"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+	"sigs.k8s.io/custom-metrics-apiserver/pkg/provider"
 )
 
 type mockCollectorPlugin struct{}
@@ -31,6 +40,28 @@ func (c mockCollector) Interval() time.Duration {
 	return 1 * time.Second
 }
 
+type countingCollectorPlugin struct {
+	calls *atomic.Int64
+}
+
+func (p countingCollectorPlugin) NewCollector(_ context.Context, hpa *autoscaling.HorizontalPodAutoscaler, config *collector.MetricConfig, interval time.Duration) (collector.Collector, error) {
+	return countingCollector{calls: p.calls, interval: interval}, nil
+}
+
+type countingCollector struct {
+	calls    *atomic.Int64
+	interval time.Duration
+}
+
+func (c countingCollector) GetMetrics(_ context.Context) ([]collector.CollectedMetric, error) {
+	c.calls.Add(1)
+	return nil, nil
+}
+
+func (c countingCollector) Interval() time.Duration {
+	return c.interval
+}
+
 type event struct {
 	Object    runtime.Object
 	EventType string
@@ -171,7 +202,7 @@ func TestUpdateHPAsDisregardingIncompatibleHPA(t *testing.T) {
 	require.NoError(t, err)
 
 	eventRecorder := &mockEventRecorder{}
-	provider := NewHPAProvider(fakeClient, 1*time.Second, 1*time.Second, collectorFactory, true, 1*time.Second, 1*time.Second)
+	provider := NewHPAProvider(fakeClient, 1*time.Second, 1*time.Second, collectorFactory, true, 1*time.Minute, 1*time.Second)
 	provider.recorder = eventRecorder
 	provider.collectorScheduler = NewCollectorScheduler(context.Background(), provider.metricSink)
 
@@ -183,7 +214,7 @@ func TestUpdateHPAsDisregardingIncompatibleHPA(t *testing.T) {
 
 	// check for events when disregardIncompatibleHPAs=false
 	eventRecorder = &mockEventRecorder{}
-	provider = NewHPAProvider(fakeClient, 1*time.Second, 1*time.Second, collectorFactory, false, 1*time.Second, 1*time.Second)
+	provider = NewHPAProvider(fakeClient, 1*time.Second, 1*time.Second, collectorFactory, false, 1*time.Minute, 1*time.Second)
 	provider.recorder = eventRecorder
 	provider.collectorScheduler = NewCollectorScheduler(context.Background(), provider.metricSink)
 
@@ -193,3 +224,1135 @@ func TestUpdateHPAsDisregardingIncompatibleHPA(t *testing.T) {
 	// we expect an event when disregardIncompatibleHPAs=false
 	require.Len(t, eventRecorder.Events, 1)
 }
+
+type failingCollectorPlugin struct {
+	err error
+}
+
+func (p failingCollectorPlugin) NewCollector(_ context.Context, _ *autoscaling.HorizontalPodAutoscaler, _ *collector.MetricConfig, _ time.Duration) (collector.Collector, error) {
+	return nil, p.err
+}
+
+func TestCollectorCreationFailureReason(t *testing.T) {
+	for _, tc := range []struct {
+		msg    string
+		err    error
+		reason string
+	}{
+		{
+			msg:    "plugin not found",
+			err:    &collector.PluginNotFoundError{},
+			reason: ReasonPluginNotFound,
+		},
+		{
+			msg:    "invalid config",
+			err:    collector.NewPermanentConfigError(fmt.Errorf("no query defined")),
+			reason: ReasonInvalidConfig,
+		},
+		{
+			msg:    "upstream unreachable",
+			err:    fmt.Errorf("failed to get pod label selector: %w", collector.NewTransientError(fmt.Errorf("connection refused"))),
+			reason: ReasonUpstreamUnreachable,
+		},
+		{
+			msg:    "unclassified error",
+			err:    fmt.Errorf("unknown"),
+			reason: ReasonCreateNewMetricsCollector,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			require.Equal(t, tc.reason, collectorCreationFailureReason(tc.err))
+		})
+	}
+}
+
+func TestUpdateHPAsSuppressEventReasons(t *testing.T) {
+	value := resource.MustParse("1k")
+
+	newHPA := func(name, metric string) *autoscaling.HorizontalPodAutoscaler {
+		return &autoscaling.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+			},
+			Spec: autoscaling.HorizontalPodAutoscalerSpec{
+				ScaleTargetRef: autoscaling.CrossVersionObjectReference{
+					Kind:       "Deployment",
+					Name:       "app",
+					APIVersion: "apps/v1",
+				},
+				MaxReplicas: 10,
+				Metrics: []autoscaling.MetricSpec{
+					{
+						Type: autoscaling.ExternalMetricSourceType,
+						External: &autoscaling.ExternalMetricSource{
+							Metric: autoscaling.MetricIdentifier{
+								Name: metric,
+							},
+							Target: autoscaling.MetricTarget{
+								Type:         autoscaling.AverageValueMetricType,
+								AverageValue: &value,
+							},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	fakeClient := fake.NewSimpleClientset()
+	for _, hpa := range []*autoscaling.HorizontalPodAutoscaler{
+		newHPA("unknown", "unknown-metric"),
+		newHPA("invalid", "invalid-metric"),
+	} {
+		_, err := fakeClient.AutoscalingV2().HorizontalPodAutoscalers("default").Create(context.TODO(), hpa, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	collectorFactory := collector.NewCollectorFactory()
+	collectorFactory.RegisterExternalCollector([]string{"invalid-metric"}, failingCollectorPlugin{
+		err: collector.NewPermanentConfigError(fmt.Errorf("no query defined")),
+	})
+
+	for _, tc := range []struct {
+		msg            string
+		suppress       []string
+		expectedEvents []string
+	}{
+		{
+			msg:            "all reasons are recorded by default",
+			expectedEvents: []string{ReasonInvalidConfig, ReasonPluginNotFound},
+		},
+		{
+			msg:            "suppressed reasons are not recorded",
+			suppress:       []string{ReasonPluginNotFound},
+			expectedEvents: []string{ReasonInvalidConfig},
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			eventRecorder := &mockEventRecorder{}
+			provider := NewHPAProvider(fakeClient, 1*time.Second, 1*time.Second, collectorFactory, false, 1*time.Minute, 1*time.Second)
+			provider.recorder = eventRecorder
+			provider.collectorScheduler = NewCollectorScheduler(context.Background(), provider.metricSink)
+			require.NoError(t, provider.SuppressEventReasons(tc.suppress))
+
+			before := testutil.ToFloat64(CollectorCreationFailures.WithLabelValues(ReasonPluginNotFound))
+
+			err := provider.updateHPAs()
+			require.NoError(t, err)
+
+			reasons := make([]string, 0, len(eventRecorder.Events))
+			for _, event := range eventRecorder.Events {
+				reasons = append(reasons, event.Reason)
+			}
+			require.ElementsMatch(t, tc.expectedEvents, reasons)
+
+			// suppressed failures are still counted
+			require.Equal(t, before+1, testutil.ToFloat64(CollectorCreationFailures.WithLabelValues(ReasonPluginNotFound)))
+		})
+	}
+}
+
+func TestSuppressEventReasonsUnknown(t *testing.T) {
+	provider := NewHPAProvider(fake.NewSimpleClientset(), 1*time.Second, 1*time.Second, collector.NewCollectorFactory(), false, 1*time.Second, 1*time.Second)
+	require.Error(t, provider.SuppressEventReasons([]string{"Unknown"}))
+}
+
+func TestUpdateHPAsEventDeduplication(t *testing.T) {
+	value := resource.MustParse("1k")
+
+	hpa := &autoscaling.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "hpa1",
+			Namespace: "default",
+		},
+		Spec: autoscaling.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscaling.CrossVersionObjectReference{
+				Kind:       "Deployment",
+				Name:       "app",
+				APIVersion: "apps/v1",
+			},
+			MaxReplicas: 10,
+			Metrics: []autoscaling.MetricSpec{
+				{
+					Type: autoscaling.ExternalMetricSourceType,
+					External: &autoscaling.ExternalMetricSource{
+						Metric: autoscaling.MetricIdentifier{
+							Name: "some-other-metric",
+						},
+						Target: autoscaling.MetricTarget{
+							Type:         autoscaling.AverageValueMetricType,
+							AverageValue: &value,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	fakeClient := fake.NewSimpleClientset()
+	_, err := fakeClient.AutoscalingV2().HorizontalPodAutoscalers("default").Create(context.TODO(), hpa, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	eventRecorder := &mockEventRecorder{}
+	provider := NewHPAProvider(fakeClient, 1*time.Second, 1*time.Second, collector.NewCollectorFactory(), false, 1*time.Minute, 1*time.Second)
+	provider.recorder = eventRecorder
+	provider.EnableEventDeduplication(time.Hour)
+	provider.collectorScheduler = NewCollectorScheduler(context.Background(), provider.metricSink)
+
+	// the failing HPA is not cached and retried on every update
+	for i := 0; i < 3; i++ {
+		err = provider.updateHPAs()
+		require.NoError(t, err)
+	}
+
+	require.Len(t, eventRecorder.Events, 1)
+}
+
+func TestLaggingCollectorsRatio(t *testing.T) {
+	now := time.Now()
+
+	newScheduled := func(interval, age time.Duration) *scheduledCollector {
+		scheduled := newScheduledCollector(func() {}, interval)
+		scheduled.lastCollection.Store(now.Add(-age).UnixNano())
+		return scheduled
+	}
+
+	scheduler := NewCollectorScheduler(context.Background(), nil)
+	require.Equal(t, float64(0), scheduler.LaggingCollectorsRatio(now))
+
+	scheduler.table = map[resourceReference]map[collector.MetricTypeName]*scheduledCollector{
+		{Name: "hpa1", Namespace: "default"}: {
+			{Type: autoscaling.PodsMetricSourceType, Metric: autoscaling.MetricIdentifier{Name: "a"}}: newScheduled(time.Minute, 30*time.Second),
+			{Type: autoscaling.PodsMetricSourceType, Metric: autoscaling.MetricIdentifier{Name: "b"}}: newScheduled(time.Minute, 3*time.Minute),
+		},
+		{Name: "hpa2", Namespace: "default"}: {
+			{Type: autoscaling.ExternalMetricSourceType, Metric: autoscaling.MetricIdentifier{Name: "c"}}: newScheduled(10*time.Second, 15*time.Second),
+			{Type: autoscaling.ExternalMetricSourceType, Metric: autoscaling.MetricIdentifier{Name: "d"}}: newScheduled(10*time.Second, 21*time.Second),
+		},
+	}
+
+	require.Equal(t, 0.5, scheduler.LaggingCollectorsRatio(now))
+}
+
+func TestHPAProviderLaggingCollectorsRatioNotRunning(t *testing.T) {
+	provider := NewHPAProvider(fake.NewSimpleClientset(), 1*time.Second, 1*time.Second, collector.NewCollectorFactory(), false, 1*time.Second, 1*time.Second)
+	require.Equal(t, float64(0), provider.LaggingCollectorsRatio())
+}
+
+type failingCollector struct {
+	calls    *atomic.Int64
+	err      error
+	interval time.Duration
+}
+
+func (c failingCollector) GetMetrics(_ context.Context) ([]collector.CollectedMetric, error) {
+	c.calls.Add(1)
+	return nil, c.err
+}
+
+func (c failingCollector) Interval() time.Duration {
+	return c.interval
+}
+
+func TestCollectorRunnerErrors(t *testing.T) {
+	hpa := &autoscaling.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "hpa1",
+			Namespace: "default",
+		},
+	}
+
+	for _, tc := range []struct {
+		msg             string
+		err             error
+		expectedRetried bool
+	}{
+		{
+			msg:             "permanent errors stop the runner",
+			err:             collector.NewPermanentConfigError(fmt.Errorf("invalid config")),
+			expectedRetried: false,
+		},
+		{
+			msg:             "transient errors are retried",
+			err:             collector.NewTransientError(fmt.Errorf("connection refused")),
+			expectedRetried: true,
+		},
+		{
+			msg:             "unclassified errors are retried",
+			err:             fmt.Errorf("unknown"),
+			expectedRetried: true,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			calls := &atomic.Int64{}
+			c := failingCollector{calls: calls, err: tc.err, interval: 10 * time.Millisecond}
+			scheduled := newScheduledCollector(cancel, c.Interval())
+			metricsc := make(chan metricCollection)
+			done := make(chan struct{})
+
+			go func() {
+				collectorRunner(ctx, hpa, c, scheduled, metricsc)
+				close(done)
+			}()
+
+			collection := <-metricsc
+			require.ErrorIs(t, collection.Error, tc.err)
+			require.Equal(t, hpa, collection.HPA)
+			require.Eventually(t, func() bool {
+				lastError := scheduled.lastError.Load()
+				return lastError != nil && *lastError == tc.err.Error()
+			}, time.Second, time.Millisecond)
+
+			if tc.expectedRetried {
+				select {
+				case collection = <-metricsc:
+					require.ErrorIs(t, collection.Error, tc.err)
+				case <-time.After(time.Second):
+					t.Fatal("expected the collection to be retried")
+				}
+				require.False(t, scheduled.stopped.Load())
+				cancel()
+				return
+			}
+
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("expected the runner to stop")
+			}
+			require.Equal(t, int64(1), calls.Load())
+			require.True(t, scheduled.stopped.Load())
+		})
+	}
+}
+
+// slowCollector takes duration to collect. Unless it ignores the context,
+// the collection is aborted when the context is done.
+type slowCollector struct {
+	duration      time.Duration
+	ignoreContext bool
+	interval      time.Duration
+	// starts receives the start and the deadline of every collection.
+	starts chan [2]time.Time
+}
+
+func (c slowCollector) GetMetrics(ctx context.Context) ([]collector.CollectedMetric, error) {
+	deadline, _ := ctx.Deadline()
+	c.starts <- [2]time.Time{time.Now(), deadline}
+
+	if c.ignoreContext {
+		time.Sleep(c.duration)
+		return nil, nil
+	}
+
+	select {
+	case <-time.After(c.duration):
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c slowCollector) Interval() time.Duration {
+	return c.interval
+}
+
+// runSlowCollector runs the collector until n collections finished and
+// returns the start and deadline of each and the collections sent.
+func runSlowCollector(t *testing.T, c slowCollector, maxTimeout time.Duration, n int) ([][2]time.Time, []metricCollection) {
+	t.Helper()
+	hpa := &autoscaling.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Name: "hpa1", Namespace: "default"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	scheduled := newScheduledCollector(cancel, c.Interval())
+	scheduled.maxTimeout = maxTimeout
+	metricsc := make(chan metricCollection)
+	done := make(chan struct{})
+	go func() {
+		collectorRunner(ctx, hpa, c, scheduled, metricsc)
+		close(done)
+	}()
+
+	var starts [][2]time.Time
+	var collections []metricCollection
+	for len(collections) < n {
+		select {
+		case start := <-c.starts:
+			starts = append(starts, start)
+		case collection := <-metricsc:
+			collections = append(collections, collection)
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the collector to be run")
+		}
+	}
+	cancel()
+
+	for {
+		select {
+		case collection := <-metricsc:
+			collections = append(collections, collection)
+		case <-c.starts:
+		case <-done:
+			return starts, collections
+		}
+	}
+}
+
+func TestNextTick(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		msg             string
+		now             time.Time
+		interval        time.Duration
+		expectedNext    time.Time
+		expectedSkipped int
+	}{
+		{
+			msg:          "collection finished within the interval",
+			now:          start.Add(20 * time.Second),
+			interval:     time.Minute,
+			expectedNext: start.Add(time.Minute),
+		},
+		{
+			msg:             "collection took longer than the interval",
+			now:             start.Add(70 * time.Second),
+			interval:        time.Minute,
+			expectedNext:    start.Add(2 * time.Minute),
+			expectedSkipped: 1,
+		},
+		{
+			msg:             "collection finished on the tick",
+			now:             start.Add(time.Minute),
+			interval:        time.Minute,
+			expectedNext:    start.Add(2 * time.Minute),
+			expectedSkipped: 1,
+		},
+		{
+			msg:             "collection took several intervals",
+			now:             start.Add(200 * time.Second),
+			interval:        time.Minute,
+			expectedNext:    start.Add(4 * time.Minute),
+			expectedSkipped: 3,
+		},
+		{
+			msg:          "no interval",
+			now:          start.Add(time.Second),
+			expectedNext: start.Add(time.Second),
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			next, skipped := nextTick(start, tc.now, tc.interval)
+			require.Equal(t, tc.expectedNext, next)
+			require.Equal(t, tc.expectedSkipped, skipped)
+		})
+	}
+}
+
+func TestCollectorRunnerCadence(t *testing.T) {
+	interval := 100 * time.Millisecond
+	c := slowCollector{duration: 60 * time.Millisecond, interval: interval, starts: make(chan [2]time.Time)}
+	skipped := testutil.ToFloat64(CollectorSkippedTicks)
+
+	starts, _ := runSlowCollector(t, c, 0, 4)
+
+	// sleeping the interval after each collection would take at least
+	// 3*(interval+duration).
+	elapsed := starts[3][0].Sub(starts[0][0])
+	require.GreaterOrEqual(t, elapsed, 3*interval)
+	require.Less(t, elapsed, 3*interval+c.duration)
+	require.Equal(t, skipped, testutil.ToFloat64(CollectorSkippedTicks))
+}
+
+func TestCollectorRunnerSkipsTicks(t *testing.T) {
+	interval := 100 * time.Millisecond
+	c := slowCollector{duration: 150 * time.Millisecond, ignoreContext: true, interval: interval, starts: make(chan [2]time.Time)}
+	skipped := testutil.ToFloat64(CollectorSkippedTicks)
+
+	starts, _ := runSlowCollector(t, c, 0, 3)
+
+	// the tick passing while the collection is running is skipped, so
+	// collections start every second tick.
+	elapsed := starts[2][0].Sub(starts[0][0])
+	require.GreaterOrEqual(t, elapsed, 4*interval)
+	require.Less(t, elapsed, 6*interval)
+	require.GreaterOrEqual(t, testutil.ToFloat64(CollectorSkippedTicks)-skipped, float64(2))
+}
+
+func TestCollectorRunnerDeadline(t *testing.T) {
+	for _, tc := range []struct {
+		msg              string
+		interval         time.Duration
+		maxTimeout       time.Duration
+		expectedDeadline time.Duration
+	}{
+		{
+			msg:              "the interval is the deadline by default",
+			interval:         50 * time.Millisecond,
+			expectedDeadline: 50 * time.Millisecond,
+		},
+		{
+			msg:              "the max timeout caps the interval",
+			interval:         time.Hour,
+			maxTimeout:       50 * time.Millisecond,
+			expectedDeadline: 50 * time.Millisecond,
+		},
+		{
+			msg:              "a shorter interval is the deadline",
+			interval:         50 * time.Millisecond,
+			maxTimeout:       time.Hour,
+			expectedDeadline: 50 * time.Millisecond,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			c := slowCollector{duration: time.Hour, interval: tc.interval, starts: make(chan [2]time.Time)}
+
+			starts, collections := runSlowCollector(t, c, tc.maxTimeout, 1)
+			require.InDelta(t, tc.expectedDeadline, starts[0][1].Sub(starts[0][0]), float64(10*time.Millisecond))
+			require.NotEmpty(t, collections)
+			require.ErrorIs(t, collections[0].Error, context.DeadlineExceeded)
+		})
+	}
+}
+
+func TestCollectMetricsPermanentErrorEvent(t *testing.T) {
+	hpa := &autoscaling.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "hpa1",
+			Namespace: "default",
+		},
+	}
+
+	eventRecorder := &mockEventRecorder{}
+	provider := NewHPAProvider(fake.NewSimpleClientset(), 1*time.Second, 1*time.Second, collector.NewCollectorFactory(), false, 1*time.Second, 1*time.Hour)
+	provider.recorder = eventRecorder
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go provider.collectMetrics(ctx)
+
+	provider.metricSink <- metricCollection{Error: collector.NewTransientError(fmt.Errorf("connection refused")), HPA: hpa}
+	provider.metricSink <- metricCollection{Error: collector.NewPermanentConfigError(fmt.Errorf("invalid config")), HPA: hpa}
+	// the next collection is only received once the previous ones are
+	// processed.
+	provider.metricSink <- metricCollection{HPA: hpa}
+
+	require.Len(t, eventRecorder.Events, 1)
+	require.Equal(t, "MetricsCollectorStopped", eventRecorder.Events[0].Reason)
+	require.Equal(t, hpa, eventRecorder.Events[0].Object)
+}
+
+func TestCollectMetricsDropsInvalidMetrics(t *testing.T) {
+	hpa := &autoscaling.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "hpa1",
+			Namespace: "default",
+		},
+	}
+
+	provider := NewHPAProvider(fake.NewSimpleClientset(), 1*time.Second, 1*time.Second, collector.NewCollectorFactory(), false, 1*time.Second, 1*time.Hour)
+	provider.EnableQueryRecording(2)
+	rejected := testutil.ToFloat64(StoreRejectedInserts.WithLabelValues(rejectedEmptyMetricName))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go provider.collectMetrics(ctx)
+
+	invalid := externalMetricWithQuery(2, "invalid-query")
+	invalid.External.MetricName = ""
+	provider.metricSink <- metricCollection{Values: []collector.CollectedMetric{externalMetricWithQuery(1, "query"), invalid}, HPA: hpa}
+	// the next collection is only received once the previous ones are
+	// processed.
+	provider.metricSink <- metricCollection{HPA: hpa}
+
+	require.Equal(t, rejected+1, testutil.ToFloat64(StoreRejectedInserts.WithLabelValues(rejectedEmptyMetricName)))
+	require.Len(t, provider.metricStore.ListAllExternalMetrics(), 1)
+	// dropped metrics are not recorded.
+	require.Len(t, provider.queryRecorder.Queries(), 1)
+}
+
+func TestLaggingCollectorsRatioIgnoresStoppedCollectors(t *testing.T) {
+	now := time.Now()
+
+	stopped := newScheduledCollector(func() {}, time.Minute)
+	stopped.lastCollection.Store(now.Add(-time.Hour).UnixNano())
+	stopped.stopped.Store(true)
+
+	scheduler := NewCollectorScheduler(context.Background(), nil)
+	scheduler.table = map[resourceReference]map[collector.MetricTypeName]*scheduledCollector{
+		{Name: "hpa1", Namespace: "default"}: {
+			{Type: autoscaling.PodsMetricSourceType, Metric: autoscaling.MetricIdentifier{Name: "a"}}: stopped,
+			{Type: autoscaling.PodsMetricSourceType, Metric: autoscaling.MetricIdentifier{Name: "b"}}: newScheduledCollector(func() {}, time.Minute),
+		},
+	}
+
+	require.Equal(t, float64(0), scheduler.LaggingCollectorsRatio(now))
+}
+
+func TestRunGarbageCollection(t *testing.T) {
+	provider := NewHPAProvider(fake.NewSimpleClientset(), 1*time.Second, 1*time.Second, collector.NewCollectorFactory(), false, -time.Hour, 42*time.Second)
+
+	intervals := make(chan time.Duration)
+	tick := make(chan time.Time)
+	provider.gcAfter = func(d time.Duration) <-chan time.Time {
+		intervals <- d
+		return tick
+	}
+
+	provider.metricStore.Insert(collector.CollectedMetric{
+		Type: autoscaling.ExternalMetricSourceType,
+		External: external_metrics.ExternalMetricValue{
+			MetricName: "metric",
+			Value:      *resource.NewQuantity(1, ""),
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go provider.runGarbageCollection(ctx)
+
+	require.Equal(t, 42*time.Second, <-intervals)
+
+	before := testutil.ToFloat64(ExpiredMetricsRemoved)
+	tick <- time.Now()
+
+	// the next wait starts once the garbage collection finished
+	require.Equal(t, 42*time.Second, <-intervals)
+	require.Equal(t, before+1, testutil.ToFloat64(ExpiredMetricsRemoved))
+	require.Empty(t, provider.metricStore.ListAllExternalMetrics())
+}
+
+func TestUpdateHPAsIntervalOnlyChange(t *testing.T) {
+	value := resource.MustParse("1k")
+
+	hpa := &autoscaling.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "hpa1",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"metric-config.pods.requests-per-second.json-path/json-key": "$.http_server.rps",
+				"metric-config.pods.requests-per-second.json-path/interval": "1h",
+			},
+		},
+		Spec: autoscaling.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscaling.CrossVersionObjectReference{
+				Kind:       "Deployment",
+				Name:       "app",
+				APIVersion: "apps/v1",
+			},
+			MinReplicas: &[]int32{1}[0],
+			MaxReplicas: 10,
+			Metrics: []autoscaling.MetricSpec{
+				{
+					Type: autoscaling.PodsMetricSourceType,
+					Pods: &autoscaling.PodsMetricSource{
+						Metric: autoscaling.MetricIdentifier{
+							Name: "requests-per-second",
+						},
+						Target: autoscaling.MetricTarget{
+							Type:         autoscaling.AverageValueMetricType,
+							AverageValue: &value,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	fakeClient := fake.NewSimpleClientset()
+
+	var err error
+	hpa, err = fakeClient.AutoscalingV2().HorizontalPodAutoscalers("default").Create(context.TODO(), hpa, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	calls := &atomic.Int64{}
+	collectorFactory := collector.NewCollectorFactory()
+	err = collectorFactory.RegisterPodsCollector("", countingCollectorPlugin{calls: calls})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	provider := NewHPAProvider(fakeClient, 1*time.Second, 1*time.Second, collectorFactory, false, 1*time.Second, 1*time.Second)
+	provider.collectorScheduler = NewCollectorScheduler(ctx, provider.metricSink)
+	go func() {
+		for {
+			select {
+			case <-provider.metricSink:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	err = provider.updateHPAs()
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, 5*time.Millisecond)
+
+	ref := resourceReference{Name: "hpa1", Namespace: "default"}
+	typeName := collector.MetricTypeName{
+		Type:   autoscaling.PodsMetricSourceType,
+		Metric: autoscaling.MetricIdentifier{Name: "requests-per-second"},
+	}
+	scheduled := provider.collectorScheduler.table[ref][typeName]
+	require.NotNil(t, scheduled)
+
+	// update only the interval
+	hpa.Annotations["metric-config.pods.requests-per-second.json-path/interval"] = "2h"
+	hpa, err = fakeClient.AutoscalingV2().HorizontalPodAutoscalers("default").Update(context.TODO(), hpa, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	err = provider.updateHPAs()
+	require.NoError(t, err)
+
+	// the collector must not be recreated and not collect again.
+	require.Same(t, scheduled, provider.collectorScheduler.table[ref][typeName])
+	require.Equal(t, 2*time.Hour, time.Duration(scheduled.interval.Load()))
+	require.Never(t, func() bool { return calls.Load() != 1 }, 50*time.Millisecond, 5*time.Millisecond)
+
+	// reduce the interval, the new interval must be used for the next
+	// collection.
+	hpa.Annotations["metric-config.pods.requests-per-second.json-path/interval"] = "10ms"
+	_, err = fakeClient.AutoscalingV2().HorizontalPodAutoscalers("default").Update(context.TODO(), hpa, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	err = provider.updateHPAs()
+	require.NoError(t, err)
+	require.Same(t, scheduled, provider.collectorScheduler.table[ref][typeName])
+	require.Eventually(t, func() bool { return calls.Load() >= 3 }, time.Second, 5*time.Millisecond)
+}
+
+func TestCollectorSchedulerUpdateIntervalUnknown(t *testing.T) {
+	scheduler := NewCollectorScheduler(context.Background(), nil)
+	require.False(t, scheduler.UpdateInterval(resourceReference{Name: "hpa1", Namespace: "default"}, collector.MetricTypeName{}, time.Minute))
+}
+
+func TestCollectorSchedulerUpdateIntervalStopped(t *testing.T) {
+	ref := resourceReference{Name: "hpa1", Namespace: "default"}
+	typeName := collector.MetricTypeName{Type: autoscaling.PodsMetricSourceType, Metric: autoscaling.MetricIdentifier{Name: "a"}}
+	scheduled := newScheduledCollector(func() {}, time.Minute)
+
+	scheduler := NewCollectorScheduler(context.Background(), nil)
+	scheduler.table = map[resourceReference]map[collector.MetricTypeName]*scheduledCollector{
+		ref: {typeName: scheduled},
+	}
+	require.True(t, scheduler.UpdateInterval(ref, typeName, 2*time.Minute))
+
+	// collectors stopped by a permanent error must be recreated to
+	// collect again.
+	scheduled.stopped.Store(true)
+	require.False(t, scheduler.UpdateInterval(ref, typeName, 3*time.Minute))
+	require.Equal(t, 2*time.Minute, time.Duration(scheduled.interval.Load()))
+}
+
+func TestEqualHPAIgnoringIntervals(t *testing.T) {
+	a := autoscaling.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "hpa1",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"metric-config.pods.requests-per-second.json-path/json-key": "$.http_server.rps",
+				"metric-config.pods.requests-per-second.json-path/interval": "10s",
+			},
+		},
+	}
+
+	b := *a.DeepCopy()
+	b.Annotations["metric-config.pods.requests-per-second.json-path/interval"] = "20s"
+	require.False(t, equalHPA(a, b))
+	require.True(t, equalHPAIgnoringIntervals(a, b))
+
+	b.Annotations["metric-config.pods.requests-per-second.json-path/json-key"] = "$.rps"
+	require.False(t, equalHPAIgnoringIntervals(a, b))
+}
+
+func TestEqualHPAIgnoresStatusAnnotation(t *testing.T) {
+	a := autoscaling.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "hpa1",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"metric-config.pods.requests-per-second.json-path/json-key": "$.http_server.rps",
+			},
+		},
+	}
+
+	// the adapter writes the status annotation with its own field manager.
+	b := *a.DeepCopy()
+	b.Annotations[StatusAnnotation] = `{"requests-per-second":"10"}`
+	b.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: statusAnnotationFieldManager, Operation: metav1.ManagedFieldsOperationApply}}
+	require.True(t, equalHPA(a, b))
+	require.True(t, equalHPAIgnoringIntervals(a, b))
+	// the annotations of the compared HPAs aren't modified.
+	require.Contains(t, b.Annotations, StatusAnnotation)
+
+	b.Annotations["metric-config.pods.requests-per-second.json-path/json-key"] = "$.rps"
+	require.False(t, equalHPA(a, b))
+}
+
+// objectCollectorPlugin creates collectors emitting a metric for the object
+// described by the metric config.
+type objectCollectorPlugin struct{}
+
+func (p objectCollectorPlugin) NewCollector(_ context.Context, hpa *autoscaling.HorizontalPodAutoscaler, config *collector.MetricConfig, interval time.Duration) (collector.Collector, error) {
+	return objectCollector{config: config}, nil
+}
+
+type objectCollector struct {
+	config *collector.MetricConfig
+}
+
+func (c objectCollector) GetMetrics(_ context.Context) ([]collector.CollectedMetric, error) {
+	return []collector.CollectedMetric{
+		{
+			Type:      c.config.Type,
+			Namespace: c.config.ObjectReference.Namespace,
+			Custom: custom_metrics.MetricValue{
+				DescribedObject: c.config.ObjectReference,
+				Metric:          custom_metrics.MetricIdentifier{Name: c.config.Metric.Name},
+				Value:           *resource.NewQuantity(10, resource.DecimalSI),
+			},
+		},
+	}, nil
+}
+
+func (c objectCollector) Interval() time.Duration {
+	return 1 * time.Second
+}
+
+func TestObjectMetricWithoutNamespaceIsFoundInHPANamespace(t *testing.T) {
+	collectorFactory := collector.NewCollectorFactory()
+	err := collectorFactory.RegisterObjectCollector("Ingress", "", objectCollectorPlugin{})
+	require.NoError(t, err)
+
+	hpa := &autoscaling.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "hpa1", Namespace: "default"},
+	}
+
+	// config built by a code path not setting the namespace of the
+	// described object.
+	config := &collector.MetricConfig{
+		MetricTypeName: collector.MetricTypeName{
+			Type:   autoscaling.ObjectMetricSourceType,
+			Metric: autoscaling.MetricIdentifier{Name: "requests-per-second"},
+		},
+		ObjectReference: custom_metrics.ObjectReference{
+			APIVersion: "networking.k8s.io/v1",
+			Kind:       "Ingress",
+			Name:       "app",
+		},
+	}
+
+	c, err := collectorFactory.NewCollector(context.Background(), hpa, config, time.Second)
+	require.NoError(t, err)
+
+	metrics, err := c.GetMetrics(context.Background())
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+
+	store := NewMetricStore(func() time.Time {
+		return time.Now().UTC().Add(15 * time.Minute)
+	})
+	store.Insert(metrics[0])
+
+	metric := store.GetMetricsByName(
+		context.Background(),
+		types.NamespacedName{Namespace: "default", Name: "app"},
+		provider.CustomMetricInfo{
+			GroupResource: schema.GroupResource{Group: "networking.k8s.io", Resource: "ingresses"},
+			Namespaced:    true,
+			Metric:        "requests-per-second",
+		},
+		labels.Everything(),
+	)
+	require.NotNil(t, metric)
+	require.Equal(t, int64(10), metric.Value.Value())
+}
+
+func TestUpdateHPAsSkipsPausedAndDeletingHPAs(t *testing.T) {
+	p := newServeAggregationProvider(t, "")
+	require.Equal(t, 1, p.collectorScheduler.count())
+
+	hpas := p.client.AutoscalingV2().HorizontalPodAutoscalers("default")
+	update := func(modify func(hpa *autoscaling.HorizontalPodAutoscaler)) {
+		hpa, err := hpas.Get(context.TODO(), "hpa1", metav1.GetOptions{})
+		require.NoError(t, err)
+		modify(hpa)
+		_, err = hpas.Update(context.TODO(), hpa, metav1.UpdateOptions{})
+		require.NoError(t, err)
+		err = p.updateHPAs()
+		require.NoError(t, err)
+	}
+
+	// pausing removes the collectors within one update
+	update(func(hpa *autoscaling.HorizontalPodAutoscaler) {
+		hpa.Annotations["autoscaling.zalando.org/paused"] = "true"
+	})
+	require.Equal(t, 0, p.collectorScheduler.count())
+	require.Empty(t, p.hpaCache)
+	// paused HPAs aren't counted as using their collector types
+	require.Empty(t, p.collectorTypes)
+
+	update(func(hpa *autoscaling.HorizontalPodAutoscaler) {
+		hpa.Annotations["autoscaling.zalando.org/paused"] = "false"
+	})
+	require.Equal(t, 1, p.collectorScheduler.count())
+	require.Len(t, p.collectorTypes, 1)
+
+	// a custom pause annotation replaces the default one
+	p.SetPauseAnnotation("example.org/paused")
+	update(func(hpa *autoscaling.HorizontalPodAutoscaler) {
+		hpa.Annotations["autoscaling.zalando.org/paused"] = "true"
+	})
+	require.Equal(t, 1, p.collectorScheduler.count())
+
+	update(func(hpa *autoscaling.HorizontalPodAutoscaler) {
+		hpa.Annotations["example.org/paused"] = "true"
+	})
+	require.Equal(t, 0, p.collectorScheduler.count())
+
+	update(func(hpa *autoscaling.HorizontalPodAutoscaler) {
+		delete(hpa.Annotations, "example.org/paused")
+	})
+	require.Equal(t, 1, p.collectorScheduler.count())
+
+	// HPAs being deleted are skipped
+	update(func(hpa *autoscaling.HorizontalPodAutoscaler) {
+		now := metav1.Now()
+		hpa.DeletionTimestamp = &now
+		hpa.Finalizers = []string{"example.org/finalizer"}
+	})
+	require.Equal(t, 0, p.collectorScheduler.count())
+	require.Empty(t, p.hpaCache)
+}
+
+func newPodsHPA(name string) *autoscaling.HorizontalPodAutoscaler {
+	value := resource.MustParse("1k")
+	return &autoscaling.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+		Spec: autoscaling.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscaling.CrossVersionObjectReference{
+				Kind:       "Deployment",
+				Name:       name,
+				APIVersion: "apps/v1",
+			},
+			MaxReplicas: 10,
+			Metrics: []autoscaling.MetricSpec{
+				{
+					Type: autoscaling.PodsMetricSourceType,
+					Pods: &autoscaling.PodsMetricSource{
+						Metric: autoscaling.MetricIdentifier{
+							Name: "requests-per-second",
+						},
+						Target: autoscaling.MetricTarget{
+							Type:         autoscaling.AverageValueMetricType,
+							AverageValue: &value,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func newListRetryProvider(t *testing.T, names ...string) (*HPAProvider, *fake.Clientset) {
+	fakeClient := fake.NewSimpleClientset()
+	for _, name := range names {
+		_, err := fakeClient.AutoscalingV2().HorizontalPodAutoscalers("default").Create(context.TODO(), newPodsHPA(name), metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	collectorFactory := collector.NewCollectorFactory()
+	err := collectorFactory.RegisterPodsCollector("", mockCollectorPlugin{})
+	require.NoError(t, err)
+
+	p := NewHPAProvider(fakeClient, 1*time.Second, 1*time.Second, collectorFactory, false, 1*time.Second, 1*time.Second)
+	p.collectorScheduler = NewCollectorScheduler(context.Background(), p.metricSink)
+	p.listBackoff.Duration = time.Millisecond
+	return p, fakeClient
+}
+
+func TestUpdateHPAsRetriesListFailures(t *testing.T) {
+	for _, tc := range []struct {
+		msg        string
+		failures   int
+		lists      int
+		collectors int
+		err        bool
+	}{
+		{msg: "list succeeds after transient failures", failures: 3, lists: 4, collectors: 2},
+		{msg: "retries are bounded", failures: 10, lists: 4, collectors: 0, err: true},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			p, fakeClient := newListRetryProvider(t, "hpa1", "hpa2")
+
+			lists := 0
+			fakeClient.PrependReactor("list", "horizontalpodautoscalers", func(clienttesting.Action) (bool, runtime.Object, error) {
+				lists++
+				if lists <= tc.failures {
+					return true, nil, fmt.Errorf("apiserver unavailable")
+				}
+				return false, nil, nil
+			})
+
+			err := p.updateHPAs()
+			if tc.err {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.lists, lists)
+			require.Equal(t, tc.collectors, p.collectorScheduler.count())
+		})
+	}
+}
+
+func TestUpdateHPAsHoldsMassRemoval(t *testing.T) {
+	p, fakeClient := newListRetryProvider(t, "hpa1", "hpa2", "hpa3", "hpa4")
+
+	err := p.updateHPAs()
+	require.NoError(t, err)
+	require.Equal(t, 4, p.collectorScheduler.count())
+
+	// simulate a truncated list only returning the first HPA.
+	truncated := true
+	fakeClient.PrependReactor("list", "horizontalpodautoscalers", func(clienttesting.Action) (bool, runtime.Object, error) {
+		if !truncated {
+			return false, nil, nil
+		}
+		hpa, err := fakeClient.Tracker().Get(schema.GroupVersionResource{Group: "autoscaling", Version: "v2", Resource: "horizontalpodautoscalers"}, "default", "hpa1")
+		if err != nil {
+			return true, nil, err
+		}
+		return true, &autoscaling.HorizontalPodAutoscalerList{
+			Items: []autoscaling.HorizontalPodAutoscaler{*hpa.(*autoscaling.HorizontalPodAutoscaler)},
+		}, nil
+	})
+
+	held := testutil.ToFloat64(HPARemovalsHeld)
+	err = p.updateHPAs()
+	require.NoError(t, err)
+	require.Equal(t, 4, p.collectorScheduler.count())
+	require.Len(t, p.hpaCache, 4)
+	require.Equal(t, held+1, testutil.ToFloat64(HPARemovalsHeld))
+
+	// a complete list clears the held removal.
+	truncated = false
+	err = p.updateHPAs()
+	require.NoError(t, err)
+	require.Equal(t, 4, p.collectorScheduler.count())
+
+	// removals within the threshold are applied immediately.
+	err = fakeClient.AutoscalingV2().HorizontalPodAutoscalers("default").Delete(context.TODO(), "hpa4", metav1.DeleteOptions{})
+	require.NoError(t, err)
+	err = p.updateHPAs()
+	require.NoError(t, err)
+	require.Equal(t, 3, p.collectorScheduler.count())
+
+	// a drop confirmed by the next update is applied.
+	truncated = true
+	err = p.updateHPAs()
+	require.NoError(t, err)
+	require.Equal(t, 3, p.collectorScheduler.count())
+	err = p.updateHPAs()
+	require.NoError(t, err)
+	require.Equal(t, 1, p.collectorScheduler.count())
+	require.Len(t, p.hpaCache, 1)
+	require.Equal(t, held+2, testutil.ToFloat64(HPARemovalsHeld))
+}
+
+func TestUpdateHPAsHeldRemovalsConfirmedBySameHPAs(t *testing.T) {
+	p, fakeClient := newListRetryProvider(t, "hpa1", "hpa2", "hpa3", "hpa4")
+
+	err := p.updateHPAs()
+	require.NoError(t, err)
+	require.Equal(t, 4, p.collectorScheduler.count())
+
+	// the list only returns the named HPAs.
+	var listed []string
+	fakeClient.PrependReactor("list", "horizontalpodautoscalers", func(clienttesting.Action) (bool, runtime.Object, error) {
+		if listed == nil {
+			return false, nil, nil
+		}
+		list := &autoscaling.HorizontalPodAutoscalerList{}
+		for _, name := range listed {
+			hpa, err := fakeClient.Tracker().Get(schema.GroupVersionResource{Group: "autoscaling", Version: "v2", Resource: "horizontalpodautoscalers"}, "default", name)
+			if err != nil {
+				return true, nil, err
+			}
+			list.Items = append(list.Items, *hpa.(*autoscaling.HorizontalPodAutoscaler))
+		}
+		return true, list, nil
+	})
+
+	listed = []string{"hpa1"}
+	err = p.updateHPAs()
+	require.NoError(t, err)
+	require.Equal(t, 4, p.collectorScheduler.count())
+
+	// another set of missing HPAs doesn't confirm the held removals.
+	listed = []string{"hpa4"}
+	err = p.updateHPAs()
+	require.NoError(t, err)
+	require.Equal(t, 4, p.collectorScheduler.count())
+	require.Len(t, p.hpaCache, 4)
+
+	listed = []string{"hpa4"}
+	err = p.updateHPAs()
+	require.NoError(t, err)
+	require.Equal(t, 1, p.collectorScheduler.count())
+	require.Len(t, p.hpaCache, 1)
+}
+
+func TestUpdateHPAsAppliesAddsWhileHoldingRemovals(t *testing.T) {
+	p, fakeClient := newListRetryProvider(t, "hpa1", "hpa2", "hpa3", "hpa4")
+
+	err := p.updateHPAs()
+	require.NoError(t, err)
+	require.Equal(t, 4, p.collectorScheduler.count())
+
+	for _, name := range []string{"hpa2", "hpa3", "hpa4"} {
+		err = fakeClient.AutoscalingV2().HorizontalPodAutoscalers("default").Delete(context.TODO(), name, metav1.DeleteOptions{})
+		require.NoError(t, err)
+	}
+	_, err = fakeClient.AutoscalingV2().HorizontalPodAutoscalers("default").Create(context.TODO(), newPodsHPA("hpa5"), metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	// the removals are held, the new HPA is added.
+	err = p.updateHPAs()
+	require.NoError(t, err)
+	require.Equal(t, 5, p.collectorScheduler.count())
+	require.Contains(t, p.hpaCache, resourceReference{Name: "hpa5", Namespace: "default"})
+
+	err = p.updateHPAs()
+	require.NoError(t, err)
+	require.Equal(t, 2, p.collectorScheduler.count())
+	require.Len(t, p.hpaCache, 2)
+}
+
+func TestUpdateHPAsRemovalThresholdDisabled(t *testing.T) {
+	p, fakeClient := newListRetryProvider(t, "hpa1", "hpa2")
+	p.SetRemovalThreshold(1)
+
+	err := p.updateHPAs()
+	require.NoError(t, err)
+	require.Equal(t, 2, p.collectorScheduler.count())
+
+	for _, name := range []string{"hpa1", "hpa2"} {
+		err = fakeClient.AutoscalingV2().HorizontalPodAutoscalers("default").Delete(context.TODO(), name, metav1.DeleteOptions{})
+		require.NoError(t, err)
+	}
+
+	err = p.updateHPAs()
+	require.NoError(t, err)
+	require.Equal(t, 0, p.collectorScheduler.count())
+}
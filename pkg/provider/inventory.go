@@ -0,0 +1,152 @@
+package provider
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+)
+
+// HPAsByCollector is the number of HPAs using a collector type. An HPA
+// using multiple collector types is counted for each of them.
+var HPAsByCollector = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "kube_metrics_adapter_hpas_by_collector",
+	Help: "The number of HPAs using a collector type",
+}, []string{"collector_type"})
+
+// Inventory summarizes the usage of collector types by the HPAs of a
+// cluster.
+type Inventory struct {
+	// HPAs is the total number of HPAs.
+	HPAs int `json:"hpas"`
+	// InvalidHPAs is the number of HPAs whose metric configs couldn't be
+	// parsed. They are not considered in the other counts.
+	InvalidHPAs int `json:"invalidHPAs"`
+	// CollectorTypes is the number of HPAs per collector type.
+	CollectorTypes map[string]int `json:"collectorTypes"`
+	// TopNamespaces are the namespaces with the most HPAs using the
+	// adapter, sorted descending by the number of HPAs.
+	TopNamespaces []NamespaceCount `json:"topNamespaces"`
+}
+
+// NamespaceCount is the number of HPAs in a namespace.
+type NamespaceCount struct {
+	Namespace string `json:"namespace"`
+	HPAs      int    `json:"hpas"`
+}
+
+// NewInventory builds the inventory of the HPAs. Only the topNamespaces
+// namespaces with the most HPAs using a collector are listed.
+func NewInventory(hpas []autoscalingv2.HorizontalPodAutoscaler, topNamespaces int) Inventory {
+	inventory := Inventory{
+		HPAs:           len(hpas),
+		CollectorTypes: map[string]int{},
+		TopNamespaces:  []NamespaceCount{},
+	}
+
+	namespaces := map[string]int{}
+	for i := range hpas {
+		collectorTypes, err := hpaCollectorTypes(&hpas[i])
+		if err != nil {
+			inventory.InvalidHPAs++
+			continue
+		}
+
+		for _, collectorType := range collectorTypes {
+			inventory.CollectorTypes[collectorType]++
+		}
+
+		if len(collectorTypes) > 0 {
+			namespaces[hpas[i].Namespace]++
+		}
+	}
+
+	for namespace, count := range namespaces {
+		inventory.TopNamespaces = append(inventory.TopNamespaces, NamespaceCount{Namespace: namespace, HPAs: count})
+	}
+
+	sort.Slice(inventory.TopNamespaces, func(i, j int) bool {
+		a, b := inventory.TopNamespaces[i], inventory.TopNamespaces[j]
+		if a.HPAs != b.HPAs {
+			return a.HPAs > b.HPAs
+		}
+		return a.Namespace < b.Namespace
+	})
+
+	if topNamespaces >= 0 && len(inventory.TopNamespaces) > topNamespaces {
+		inventory.TopNamespaces = inventory.TopNamespaces[:topNamespaces]
+	}
+
+	return inventory
+}
+
+// updateHPAsByCollector sets the HPAsByCollector gauge from the collector
+// types of the HPAs. Collector types no longer used are removed.
+func updateHPAsByCollector(hpaCollectorTypes map[resourceReference][]string) {
+	counts := map[string]int{}
+	for _, collectorTypes := range hpaCollectorTypes {
+		for _, collectorType := range collectorTypes {
+			counts[collectorType]++
+		}
+	}
+
+	HPAsByCollector.Reset()
+	for collectorType, count := range counts {
+		HPAsByCollector.WithLabelValues(collectorType).Set(float64(count))
+	}
+}
+
+// hpaCollectorTypes returns the distinct collector types of the metrics of
+// the HPA.
+func hpaCollectorTypes(hpa *autoscalingv2.HorizontalPodAutoscaler) ([]string, error) {
+	metricConfigs, err := collector.ParseHPAMetrics(hpa)
+	if err != nil {
+		return nil, err
+	}
+
+	return metricCollectorTypes(metricConfigs), nil
+}
+
+// metricCollectorTypes returns the distinct collector types of the metric
+// configs.
+func metricCollectorTypes(metricConfigs []*collector.MetricConfig) []string {
+	var collectorTypes []string
+	seen := map[string]struct{}{}
+	for _, config := range metricConfigs {
+		collectorType := inventoryCollectorType(config)
+		if _, ok := seen[collectorType]; ok {
+			continue
+		}
+		seen[collectorType] = struct{}{}
+		collectorTypes = append(collectorTypes, collectorType)
+	}
+
+	return collectorTypes
+}
+
+// inventoryCollectorType returns the collector type of a metric config.
+// Unlike collectorTypeLabel it resolves the collector of metrics without a
+// collector type annotation the same way the CollectorFactory does: by the
+// `type` label of external metrics and by the kind of object metrics.
+func inventoryCollectorType(config *collector.MetricConfig) string {
+	if config.CollectorType != "" {
+		return config.CollectorType
+	}
+
+	switch config.Type {
+	case autoscalingv2.ExternalMetricSourceType:
+		if selector := config.Metric.Selector; selector != nil && selector.MatchLabels["type"] != "" {
+			return selector.MatchLabels["type"]
+		}
+		return config.Metric.Name
+	case autoscalingv2.ObjectMetricSourceType:
+		if config.ObjectReference.Kind != "" {
+			return strings.ToLower(config.ObjectReference.Kind)
+		}
+	}
+
+	return strings.ToLower(string(config.Type))
+}
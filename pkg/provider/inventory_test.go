@@ -0,0 +1,132 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	autoscaling "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func inventoryHPA(namespace, name string, annotations map[string]string, metrics ...autoscaling.MetricSpec) autoscaling.HorizontalPodAutoscaler {
+	return autoscaling.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: annotations,
+		},
+		Spec: autoscaling.HorizontalPodAutoscalerSpec{
+			MaxReplicas: 10,
+			Metrics:     metrics,
+		},
+	}
+}
+
+func typedExternalMetric(name, typ string) autoscaling.MetricSpec {
+	return autoscaling.MetricSpec{
+		Type: autoscaling.ExternalMetricSourceType,
+		External: &autoscaling.ExternalMetricSource{
+			Metric: autoscaling.MetricIdentifier{
+				Name:     name,
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"type": typ}},
+			},
+		},
+	}
+}
+
+func objectMetric(name, kind string) autoscaling.MetricSpec {
+	return autoscaling.MetricSpec{
+		Type: autoscaling.ObjectMetricSourceType,
+		Object: &autoscaling.ObjectMetricSource{
+			DescribedObject: autoscaling.CrossVersionObjectReference{Kind: kind, Name: name},
+			Metric:          autoscaling.MetricIdentifier{Name: name},
+		},
+	}
+}
+
+func podsMetric(name string) autoscaling.MetricSpec {
+	return autoscaling.MetricSpec{
+		Type: autoscaling.PodsMetricSourceType,
+		Pods: &autoscaling.PodsMetricSource{
+			Metric: autoscaling.MetricIdentifier{Name: name},
+		},
+	}
+}
+
+func cpuMetric() autoscaling.MetricSpec {
+	return autoscaling.MetricSpec{
+		Type: autoscaling.ResourceMetricSourceType,
+		Resource: &autoscaling.ResourceMetricSource{
+			Name: "cpu",
+		},
+	}
+}
+
+func inventoryHPAs() []autoscaling.HorizontalPodAutoscaler {
+	return []autoscaling.HorizontalPodAutoscaler{
+		inventoryHPA("team-a", "sqs", nil, typedExternalMetric("queue-length", "sqs-queue-length")),
+		// multiple metrics of the same collector type count once
+		inventoryHPA("team-a", "zmon", nil, typedExternalMetric("check-1", "zmon"), typedExternalMetric("check-2", "zmon")),
+		inventoryHPA("team-a", "mixed", map[string]string{
+			"metric-config.pods.requests.json-path/json-key": "$.requests",
+		}, podsMetric("requests"), objectMetric("app", "Ingress")),
+		inventoryHPA("team-b", "prometheus", map[string]string{
+			"metric-config.object.processed.prometheus/query": "sum(processed)",
+		}, objectMetric("processed", "Pod")),
+		inventoryHPA("team-b", "legacy", nil, autoscaling.MetricSpec{
+			Type: autoscaling.ExternalMetricSourceType,
+			External: &autoscaling.ExternalMetricSource{
+				Metric: autoscaling.MetricIdentifier{Name: "sqs-queue-length"},
+			},
+		}),
+		// resource metrics are not collected by the adapter
+		inventoryHPA("team-c", "cpu", nil, cpuMetric()),
+		inventoryHPA("team-c", "invalid", map[string]string{
+			"metric-config.pods.requests.json-path/interval": "invalid",
+		}, podsMetric("requests")),
+	}
+}
+
+func TestNewInventory(t *testing.T) {
+	inventory := NewInventory(inventoryHPAs(), 10)
+
+	require.Equal(t, 7, inventory.HPAs)
+	require.Equal(t, 1, inventory.InvalidHPAs)
+	require.Equal(t, map[string]int{
+		"sqs-queue-length": 2,
+		"zmon":             1,
+		"json-path":        1,
+		"ingress":          1,
+		"prometheus":       1,
+	}, inventory.CollectorTypes)
+	require.Equal(t, []NamespaceCount{
+		{Namespace: "team-a", HPAs: 3},
+		{Namespace: "team-b", HPAs: 2},
+	}, inventory.TopNamespaces)
+
+	inventory = NewInventory(inventoryHPAs(), 1)
+	require.Equal(t, []NamespaceCount{{Namespace: "team-a", HPAs: 3}}, inventory.TopNamespaces)
+}
+
+func TestUpdateHPAsByCollector(t *testing.T) {
+	collectorTypes := map[resourceReference][]string{}
+	for _, hpa := range inventoryHPAs() {
+		types, err := hpaCollectorTypes(&hpa)
+		if err != nil {
+			continue
+		}
+		collectorTypes[resourceReference{Name: hpa.Name, Namespace: hpa.Namespace}] = types
+	}
+
+	updateHPAsByCollector(collectorTypes)
+	require.Equal(t, 5, testutil.CollectAndCount(HPAsByCollector))
+	require.Equal(t, 2.0, testutil.ToFloat64(HPAsByCollector.WithLabelValues("sqs-queue-length")))
+
+	// collector types no longer used are removed
+	updateHPAsByCollector(map[resourceReference][]string{
+		{Name: "sqs", Namespace: "team-a"}: {"sqs-queue-length"},
+	})
+	require.Equal(t, 1, testutil.CollectAndCount(HPAsByCollector))
+	require.Equal(t, 1.0, testutil.ToFloat64(HPAsByCollector.WithLabelValues("sqs-queue-length")))
+}
@@ -0,0 +1,132 @@
+package provider
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/hpasim"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+const (
+	// ReasonMaxReplicasExceeded is the reason of the event recorded when
+	// the metrics served for an HPA start to require more than its max
+	// replicas.
+	ReasonMaxReplicasExceeded = "MaxReplicasExceeded"
+	// ReasonWithinMaxReplicas is the reason of the event recorded when the
+	// metrics served for an HPA require at most its max replicas again.
+	ReasonWithinMaxReplicas = "WithinMaxReplicas"
+	// maxReplicasCheckInterval is the interval at which the HPAs are
+	// checked.
+	maxReplicasCheckInterval = time.Minute
+)
+
+// HPAAtMax is 1 for HPAs whose metrics served by the adapter require more
+// than their max replicas and 0 otherwise.
+var HPAAtMax = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "kube_metrics_adapter_hpa_at_max",
+	Help: "Whether the metrics served for an HPA require more than its max replicas",
+}, []string{"namespace", "hpa"})
+
+// EnableMaxReplicasDetection enables checking once per minute whether the
+// value of any External or Object metric served for an HPA divided by its
+// target requires more than the max replicas of the HPA. Transitions are
+// recorded as events on the HPA and the state is exposed by the HPAAtMax
+// metric. tolerance should match the tolerance of the HPA controller.
+func (p *HPAProvider) EnableMaxReplicasDetection(tolerance float64) {
+	p.hpasAtMax = map[resourceReference]struct{}{}
+	p.hpaTolerance = tolerance
+}
+
+// runMaxReplicasDetection checks the HPAs until the context is canceled.
+func (p *HPAProvider) runMaxReplicasDetection(ctx context.Context) {
+	for {
+		select {
+		case <-time.After(maxReplicasCheckInterval):
+			p.checkMaxReplicas()
+		case <-ctx.Done():
+			p.logger.Info("Stopped max replicas detection.")
+			return
+		}
+	}
+}
+
+// checkMaxReplicas checks all HPAs of the HPA cache and records an event for
+// each HPA which started or stopped to require more than its max replicas.
+func (p *HPAProvider) checkMaxReplicas() {
+	p.hpaCacheMu.RLock()
+	hpas := make(map[resourceReference]autoscalingv2.HorizontalPodAutoscaler, len(p.hpaCache))
+	for ref, hpa := range p.hpaCache {
+		hpas[ref] = hpa
+	}
+	p.hpaCacheMu.RUnlock()
+
+	for ref, hpa := range hpas {
+		metric, replicas, above := p.replicasAboveMax(&hpa)
+		_, wasAbove := p.hpasAtMax[ref]
+
+		switch {
+		case above && !wasAbove:
+			p.hpasAtMax[ref] = struct{}{}
+			p.recorder.Eventf(&hpa, apiv1.EventTypeNormal, ReasonMaxReplicasExceeded, "The value of metric %s requires %d replicas, more than the max replicas %d", metric, replicas, hpa.Spec.MaxReplicas)
+		case !above && wasAbove:
+			delete(p.hpasAtMax, ref)
+			p.recorder.Eventf(&hpa, apiv1.EventTypeNormal, ReasonWithinMaxReplicas, "The values of the metrics require at most the max replicas %d", hpa.Spec.MaxReplicas)
+		}
+
+		value := 0.0
+		if above {
+			value = 1
+		}
+		HPAAtMax.WithLabelValues(ref.Namespace, ref.Name).Set(value)
+	}
+
+	for ref := range p.hpasAtMax {
+		if _, ok := hpas[ref]; !ok {
+			delete(p.hpasAtMax, ref)
+		}
+	}
+}
+
+// forgetMaxReplicas drops the HPAAtMax series of a removed HPA.
+func (p *HPAProvider) forgetMaxReplicas(ref resourceReference) {
+	HPAAtMax.DeleteLabelValues(ref.Namespace, ref.Name)
+}
+
+// replicasAboveMax returns the first metric of the HPA whose served value
+// requires more than the max replicas of the HPA and the replicas it
+// requires. The replicas are computed per metric like by the HPA controller
+// with hpasim.ReplicasForMetric.
+func (p *HPAProvider) replicasAboveMax(hpa *autoscalingv2.HorizontalPodAutoscaler) (string, int32, bool) {
+	for _, metric := range hpa.Spec.Metrics {
+		value, err := p.metricStore.MetricValue(hpa.Namespace, metric)
+		if err != nil {
+			continue
+		}
+
+		replicas, err := hpasim.ReplicasForMetric(metric, value, hpa.Status.CurrentReplicas, p.hpaTolerance)
+		if err != nil {
+			continue
+		}
+
+		if replicas > hpa.Spec.MaxReplicas {
+			return specMetricName(metric), replicas, true
+		}
+	}
+	return "", 0, false
+}
+
+// specMetricName returns the name of an External or Object metric.
+func specMetricName(metric autoscalingv2.MetricSpec) string {
+	switch {
+	case metric.Type == autoscalingv2.ExternalMetricSourceType && metric.External != nil:
+		return metric.External.Metric.Name
+	case metric.Type == autoscalingv2.ObjectMetricSourceType && metric.Object != nil:
+		return metric.Object.Metric.Name
+	default:
+		return string(metric.Type)
+	}
+}
@@ -0,0 +1,113 @@
+package provider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscaling "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCheckMaxReplicas(t *testing.T) {
+	for _, tc := range []struct {
+		msg    string
+		target autoscaling.MetricTarget
+		below  string
+		above  string
+	}{
+		{
+			msg:    "Value target scales the current replicas",
+			target: valueTarget("5"),
+			below:  "10", // 2 * 10 / 5 = 4 replicas
+			above:  "20", // 2 * 20 / 5 = 8 replicas
+		},
+		{
+			msg:    "AverageValue target divides the value by the target",
+			target: averageValueTarget("10"),
+			below:  "30", // 30 / 10 = 3 replicas
+			above:  "70", // 70 / 10 = 7 replicas
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			hpa := autoscaling.HorizontalPodAutoscaler{
+				ObjectMeta: metav1.ObjectMeta{Name: "hpa1", Namespace: "default"},
+				Spec: autoscaling.HorizontalPodAutoscalerSpec{
+					MaxReplicas: 5,
+					Metrics:     []autoscaling.MetricSpec{externalMetricSpec("jobs", tc.target)},
+				},
+				Status: autoscaling.HorizontalPodAutoscalerStatus{CurrentReplicas: 2},
+			}
+			ref := resourceReference{Name: "hpa1", Namespace: "default"}
+
+			provider := NewHPAProvider(fake.NewSimpleClientset(), 1*time.Second, 1*time.Second, collector.NewCollectorFactory(), false, 1*time.Hour, 1*time.Second)
+			recorder := &mockEventRecorder{}
+			provider.recorder = recorder
+			provider.EnableMaxReplicasDetection(0.1)
+			provider.hpaCache = map[resourceReference]autoscaling.HorizontalPodAutoscaler{ref: hpa}
+			defer provider.forgetMaxReplicas(ref)
+
+			atMax := func() float64 {
+				return testutil.ToFloat64(HPAAtMax.WithLabelValues("default", "hpa1"))
+			}
+
+			// HPAs without values aren't at max.
+			provider.checkMaxReplicas()
+			require.Empty(t, recorder.Events)
+			require.Equal(t, 0.0, atMax())
+
+			insertExternalValue(provider.metricStore, "jobs", "0", tc.below)
+			provider.checkMaxReplicas()
+			require.Empty(t, recorder.Events)
+			require.Equal(t, 0.0, atMax())
+
+			insertExternalValue(provider.metricStore, "jobs", "0", tc.above)
+			provider.checkMaxReplicas()
+			require.Len(t, recorder.Events, 1)
+			require.Equal(t, ReasonMaxReplicasExceeded, recorder.Events[0].Reason)
+			require.Contains(t, recorder.Events[0].Message, "metric jobs")
+			require.Equal(t, 1.0, atMax())
+
+			// a single event is recorded per transition.
+			provider.checkMaxReplicas()
+			require.Len(t, recorder.Events, 1)
+			require.Equal(t, 1.0, atMax())
+
+			insertExternalValue(provider.metricStore, "jobs", "0", tc.below)
+			provider.checkMaxReplicas()
+			require.Len(t, recorder.Events, 2)
+			require.Equal(t, ReasonWithinMaxReplicas, recorder.Events[1].Reason)
+			require.Equal(t, 0.0, atMax())
+		})
+	}
+}
+
+func TestCheckMaxReplicasForgetsRemovedHPAs(t *testing.T) {
+	hpa := autoscaling.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Name: "hpa2", Namespace: "default"},
+		Spec: autoscaling.HorizontalPodAutoscalerSpec{
+			MaxReplicas: 1,
+			Metrics:     []autoscaling.MetricSpec{externalMetricSpec("backlog", averageValueTarget("1"))},
+		},
+	}
+	ref := resourceReference{Name: "hpa2", Namespace: "default"}
+
+	provider := NewHPAProvider(fake.NewSimpleClientset(), 1*time.Second, 1*time.Second, collector.NewCollectorFactory(), false, 1*time.Hour, 1*time.Second)
+	provider.recorder = &mockEventRecorder{}
+	provider.EnableMaxReplicasDetection(0.1)
+	provider.hpaCache = map[resourceReference]autoscaling.HorizontalPodAutoscaler{ref: hpa}
+
+	insertExternalValue(provider.metricStore, "backlog", "0", "10")
+	provider.checkMaxReplicas()
+	require.Contains(t, provider.hpasAtMax, ref)
+	require.Equal(t, 1, testutil.CollectAndCount(HPAAtMax, "kube_metrics_adapter_hpa_at_max"))
+
+	provider.hpaCache = map[resourceReference]autoscaling.HorizontalPodAutoscaler{}
+	provider.forgetMaxReplicas(ref)
+	provider.checkMaxReplicas()
+	require.NotContains(t, provider.hpasAtMax, ref)
+	require.Equal(t, 0, testutil.CollectAndCount(HPAAtMax, "kube_metrics_adapter_hpa_at_max"))
+}
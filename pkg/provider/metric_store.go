@@ -3,11 +3,13 @@ package provider
 import (
 	"context"
 	"fmt"
+	"runtime"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	"k8s.io/apimachinery/pkg/labels"
@@ -23,13 +25,23 @@ import (
 type customMetricsStoredMetric struct {
 	Value custom_metrics.MetricValue
 	TTL   time.Time
+	// Source is the HPA the metric was collected for, if known.
+	Source resourceReference
+	// Inserted is the time the metric was inserted.
+	Inserted time.Time
 }
 
 type externalMetricsStoredMetric struct {
-	Value external_metrics.ExternalMetricValue
-	TTL   time.Time
+	Value    external_metrics.ExternalMetricValue
+	TTL      time.Time
+	Source   resourceReference
+	Inserted time.Time
 }
 
+// defaultCollisionWindow is the default time within which a series
+// overwritten by the collection of another HPA is considered a collision.
+const defaultCollisionWindow = time.Minute
+
 // MetricStore is a simple in-memory Metrics Store for HPA metrics.
 type MetricStore struct {
 	// metricName -> referencedResource -> objectNamespace -> objectName -> metric
@@ -37,6 +49,17 @@ type MetricStore struct {
 	// namespace -> metricName -> labels -> metric
 	externalMetricsStore externalMetricStore
 	metricsTTLCalculator func() time.Time
+	// collisionWindow is the time within which a series overwritten by
+	// the collection of another HPA is considered a collision, usually
+	// the collection interval.
+	collisionWindow time.Duration
+	// rejectedLogged is the time an invalid insert was last logged by
+	// reason.
+	rejectedLogged map[string]time.Time
+	// deprecatedLogged is the time the metrics of an Ingress referenced
+	// by the deprecated extensions group were last logged.
+	deprecatedLogged map[types.NamespacedName]time.Time
+	now              func() time.Time
 	sync.RWMutex
 }
 
@@ -61,36 +84,186 @@ func NewMetricStore(ttlCalculator func() time.Time) *MetricStore {
 		customMetricsStore:   make(customMetricStore, 0),
 		externalMetricsStore: make(externalMetricStore, 0),
 		metricsTTLCalculator: ttlCalculator,
+		collisionWindow:      defaultCollisionWindow,
+		rejectedLogged:       map[string]time.Time{},
+		deprecatedLogged:     map[types.NamespacedName]time.Time{},
+		now:                  time.Now,
 	}
 }
 
 // Insert inserts a collected metric into the metric customMetricsStore.
+// Invalid metrics are dropped. The metric expires after its TTL if set,
+// otherwise after the metrics TTL of the store.
 func (s *MetricStore) Insert(value collector.CollectedMetric) {
+	s.insertFrom(resourceReference{}, value)
+}
+
+// insertFrom inserts a metric collected for the HPA. If the insert
+// overwrites a series collected for another HPA with a different value
+// within the collision window, the HPAs likely use colliding metric configs,
+// e.g. metrics of same named objects without namespace. The collision is
+// logged and counted. Invalid metrics, which could never be served, are
+// dropped and counted by reason. It returns false if the metric was dropped.
+func (s *MetricStore) insertFrom(source resourceReference, value collector.CollectedMetric) bool {
+	if reason, err := validateCollectedMetric(value); err != nil {
+		StoreRejectedInserts.WithLabelValues(reason).Inc()
+		s.logRejectedInsert(source, reason, err)
+		return false
+	}
+
+	ttl := s.expiry(value.TTL)
 	switch value.Type {
 	case autoscalingv2.ObjectMetricSourceType, autoscalingv2.PodsMetricSourceType:
-		s.insertCustomMetric(value.Custom)
+		s.insertCustomMetric(source, value.Custom, ttl)
 	case autoscalingv2.ExternalMetricSourceType:
-		s.insertExternalMetric(objectNamespace(value.Namespace), value.External)
+		s.insertExternalMetric(source, objectNamespace(value.Namespace), value.External, ttl)
 	}
+	return true
 }
 
-// insertCustomMetric inserts a custom metric plus labels into the store.
-func (s *MetricStore) insertCustomMetric(value custom_metrics.MetricValue) {
+// expiry returns the time a metric inserted now expires. The TTL of the
+// metric overrides the metrics TTL of the store if set.
+func (s *MetricStore) expiry(ttl time.Duration) time.Time {
+	if ttl > 0 {
+		return s.now().UTC().Add(ttl)
+	}
+	return s.metricsTTLCalculator()
+}
+
+// Reasons for rejecting the insert of a collected metric.
+const (
+	rejectedUnknownType     = "unknown_type"
+	rejectedEmptyMetricName = "empty_metric_name"
+	rejectedEmptyObjectName = "empty_object_name"
+	rejectedUnmappedKind    = "unmapped_kind"
+)
+
+// rejectedInsertLogInterval is the interval within which rejected inserts
+// are only logged once per reason.
+const rejectedInsertLogInterval = time.Minute
+
+// validateCollectedMetric returns the reason and an error if the collected
+// metric can't be stored.
+func validateCollectedMetric(value collector.CollectedMetric) (string, error) {
+	switch value.Type {
+	case autoscalingv2.ObjectMetricSourceType, autoscalingv2.PodsMetricSourceType:
+		object := value.Custom.DescribedObject
+		if value.Custom.Metric.Name == "" {
+			return rejectedEmptyMetricName, fmt.Errorf("custom metric of %s '%s' has no name", object.Kind, object.Name)
+		}
+		if object.Name == "" {
+			return rejectedEmptyObjectName, fmt.Errorf("custom metric '%s' of %s has no object name", value.Custom.Metric.Name, object.Kind)
+		}
+		if describedObjectGroupResource(object.Kind, object.APIVersion).Empty() {
+			return rejectedUnmappedKind, fmt.Errorf("custom metric '%s' of %s '%s' has no known resource for the kind", value.Custom.Metric.Name, object.Kind, object.Name)
+		}
+	case autoscalingv2.ExternalMetricSourceType:
+		if value.External.MetricName == "" {
+			return rejectedEmptyMetricName, fmt.Errorf("external metric [%s] has no name", labels.Set(value.External.MetricLabels).String())
+		}
+	default:
+		return rejectedUnknownType, fmt.Errorf("metric has unknown type '%s'", value.Type)
+	}
+	return "", nil
+}
+
+// logRejectedInsert logs the rejected insert unless an insert was rejected
+// for the same reason within the log interval.
+func (s *MetricStore) logRejectedInsert(source resourceReference, reason string, err error) {
 	s.Lock()
-	defer s.Unlock()
+	now := s.now()
+	if last, ok := s.rejectedLogged[reason]; ok && now.Sub(last) < rejectedInsertLogInterval {
+		s.Unlock()
+		return
+	}
+	s.rejectedLogged[reason] = now
+	s.Unlock()
 
+	if source == (resourceReference{}) {
+		log.Warnf("Dropped invalid metric: %v", err)
+		return
+	}
+	log.Warnf("Dropped invalid metric collected for HPA %s/%s: %v", source.Namespace, source.Name, err)
+}
+
+// logDeprecatedGroup logs that the Ingress is referenced by the deprecated
+// extensions group unless it was logged for the Ingress within the log
+// interval. The caller must hold the lock.
+func (s *MetricStore) logDeprecatedGroup(object custom_metrics.ObjectReference, now time.Time) {
+	key := types.NamespacedName{Namespace: object.Namespace, Name: object.Name}
+	if last, ok := s.deprecatedLogged[key]; ok && now.Sub(last) < rejectedInsertLogInterval {
+		return
+	}
+	s.deprecatedLogged[key] = now
+	log.Warnf("Ingress %s/%s is referenced with the deprecated API version '%s', use '%s/v1' instead. Its metrics are also served for %s", object.Namespace, object.Name, object.APIVersion, ingressGroup, ingressGroup)
+}
+
+// collides returns true if a series stored for the existing source at the
+// inserted time is overwritten by a different value of another source.
+func (s *MetricStore) collides(existing, source resourceReference, inserted, now time.Time, sameValue bool) bool {
+	if source == (resourceReference{}) || existing == (resourceReference{}) || existing == source || sameValue {
+		return false
+	}
+	return now.Sub(inserted) < s.collisionWindow
+}
+
+// namespacedGroupResources are the resources of namespaced objects which
+// are expected to be stored with a namespace.
+var namespacedGroupResources = map[string]struct{}{
+	"ingresses":    {},
+	"routegroups":  {},
+	"deployments":  {},
+	"statefulsets": {},
+	"rollouts":     {},
+}
+
+const (
+	// ingressGroup is the group of Ingresses.
+	ingressGroup = "networking.k8s.io"
+	// deprecatedIngressGroup is the group of Ingresses before they moved
+	// to networking.k8s.io. It's no longer served by current clusters.
+	deprecatedIngressGroup = "extensions"
+)
+
+// siblingGroupResources maps the group resources of Ingresses to the group
+// resource of the other Ingress group. Metrics of Ingresses may be stored
+// under either group depending on the API version of the object reference
+// of the HPA, so lookups fall back to the sibling.
+var siblingGroupResources = map[schema.GroupResource]schema.GroupResource{
+	{Group: ingressGroup, Resource: "ingresses"}:           {Group: deprecatedIngressGroup, Resource: "ingresses"},
+	{Group: deprecatedIngressGroup, Resource: "ingresses"}: {Group: ingressGroup, Resource: "ingresses"},
+}
+
+// lookupGroupResources returns the group resources to look up the metrics
+// of the group resource in, in order.
+func lookupGroupResources(groupResource schema.GroupResource) []schema.GroupResource {
+	if sibling, ok := siblingGroupResources[groupResource]; ok {
+		return []schema.GroupResource{groupResource, sibling}
+	}
+	return []schema.GroupResource{groupResource}
+}
+
+// describedObjectGroupResource maps the kind of a described object to the
+// group resource the metrics of the object are stored under.
+func describedObjectGroupResource(kind, apiVersion string) schema.GroupResource {
 	// TODO: handle this mapping nicer. This information should be
 	// registered as the metrics are.
 	var groupResource schema.GroupResource
-	switch value.DescribedObject.Kind {
+	switch kind {
 	case "Pod":
 		groupResource = schema.GroupResource{
 			Resource: "pods",
 		}
+	case "Node":
+		groupResource = schema.GroupResource{
+			Resource: "nodes",
+		}
 	case "Ingress":
-		group := "networking.k8s.io"
-		gv, err := schema.ParseGroupVersion(value.DescribedObject.APIVersion)
-		if err == nil {
+		// Ingresses are not part of the core group, so an empty group
+		// is defaulted as well.
+		group := ingressGroup
+		gv, err := schema.ParseGroupVersion(apiVersion)
+		if err == nil && gv.Group != "" {
 			group = gv.Group
 		}
 		groupResource = schema.GroupResource{
@@ -99,7 +272,7 @@ func (s *MetricStore) insertCustomMetric(value custom_metrics.MetricValue) {
 		}
 	case "RouteGroup":
 		group := "zalando.org"
-		gv, err := schema.ParseGroupVersion(value.DescribedObject.APIVersion)
+		gv, err := schema.ParseGroupVersion(apiVersion)
 		if err == nil {
 			group = gv.Group
 		}
@@ -107,9 +280,29 @@ func (s *MetricStore) insertCustomMetric(value custom_metrics.MetricValue) {
 			Resource: "routegroups",
 			Group:    group,
 		}
+	case "Deployment", "StatefulSet":
+		group := "apps"
+		gv, err := schema.ParseGroupVersion(apiVersion)
+		if err == nil {
+			group = gv.Group
+		}
+		groupResource = schema.GroupResource{
+			Resource: strings.ToLower(kind) + "s",
+			Group:    group,
+		}
+	case "Rollout":
+		group := "argoproj.io"
+		gv, err := schema.ParseGroupVersion(apiVersion)
+		if err == nil {
+			group = gv.Group
+		}
+		groupResource = schema.GroupResource{
+			Resource: "rollouts",
+			Group:    group,
+		}
 	case "ScalingSchedule":
 		group := "zalando.org"
-		gv, err := schema.ParseGroupVersion(value.DescribedObject.APIVersion)
+		gv, err := schema.ParseGroupVersion(apiVersion)
 		if err == nil {
 			group = gv.Group
 		}
@@ -119,7 +312,7 @@ func (s *MetricStore) insertCustomMetric(value custom_metrics.MetricValue) {
 		}
 	case "ClusterScalingSchedule":
 		group := "zalando.org"
-		gv, err := schema.ParseGroupVersion(value.DescribedObject.APIVersion)
+		gv, err := schema.ParseGroupVersion(apiVersion)
 		if err == nil {
 			group = gv.Group
 		}
@@ -129,9 +322,29 @@ func (s *MetricStore) insertCustomMetric(value custom_metrics.MetricValue) {
 		}
 	}
 
+	return groupResource
+}
+
+// insertCustomMetric inserts a custom metric plus labels into the store.
+func (s *MetricStore) insertCustomMetric(source resourceReference, value custom_metrics.MetricValue, ttl time.Time) {
+	s.Lock()
+	defer s.Unlock()
+
+	groupResource := describedObjectGroupResource(value.DescribedObject.Kind, value.DescribedObject.APIVersion)
+	if _, ok := namespacedGroupResources[groupResource.Resource]; ok && value.DescribedObject.Namespace == "" {
+		log.Warnf("Storing metric '%s' of %s '%s' without namespace, it may overwrite metrics of %s with the same name in other namespaces", value.Metric.Name, value.DescribedObject.Kind, value.DescribedObject.Name, groupResource.Resource)
+	}
+
+	now := s.now()
+	if groupResource.Group == deprecatedIngressGroup && groupResource.Resource == "ingresses" {
+		s.logDeprecatedGroup(value.DescribedObject, now)
+	}
+
 	customMetric := customMetricsStoredMetric{
-		Value: value,
-		TTL:   s.metricsTTLCalculator(), // TODO: make TTL configurable
+		Value:    value,
+		TTL:      ttl,
+		Source:   source,
+		Inserted: now,
 	}
 
 	selector := value.Metric.Selector
@@ -144,6 +357,13 @@ func (s *MetricStore) insertCustomMetric(value custom_metrics.MetricValue) {
 	namespace := objectNamespace(value.DescribedObject.Namespace)
 	object := objectName(value.DescribedObject.Name)
 
+	if existing, ok := s.customMetricsStore[metric][groupResource][namespace][object][labelsKey]; ok && s.collides(existing.Source, source, existing.Inserted, now, existing.Value.Value.Equal(value.Value)) {
+		log.Warnf("Metric '%s' of %s %s/%s collected for HPA %s/%s overwrites the value %s collected for HPA %s/%s with %s, the HPAs may use colliding metric configs",
+			value.Metric.Name, value.DescribedObject.Kind, value.DescribedObject.Namespace, value.DescribedObject.Name,
+			source.Namespace, source.Name, existing.Value.Value.String(), existing.Source.Namespace, existing.Source.Name, value.Value.String())
+		MetricCollisions.WithLabelValues(string(autoscalingv2.ObjectMetricSourceType)).Inc()
+	}
+
 	group2namespace, ok := s.customMetricsStore[metric]
 	if !ok {
 		s.customMetricsStore[metric] = groupToNamespaceStore{
@@ -192,19 +412,29 @@ func (s *MetricStore) insertCustomMetric(value custom_metrics.MetricValue) {
 }
 
 // insertExternalMetric inserts an external metric into the store.
-func (s *MetricStore) insertExternalMetric(namespace objectNamespace, metric external_metrics.ExternalMetricValue) {
+func (s *MetricStore) insertExternalMetric(source resourceReference, namespace objectNamespace, metric external_metrics.ExternalMetricValue, ttl time.Time) {
 	s.Lock()
 	defer s.Unlock()
 
+	now := s.now()
 	storedMetric := externalMetricsStoredMetric{
-		Value: metric,
-		TTL:   s.metricsTTLCalculator(), // TODO: make TTL configurable
+		Value:    metric,
+		TTL:      ttl,
+		Source:   source,
+		Inserted: now,
 	}
 
 	labelsKey := hashLabelMap(metric.MetricLabels)
 
 	metricName := metricName(metric.MetricName)
 
+	if existing, ok := s.externalMetricsStore[namespace][metricName][labelsKey]; ok && s.collides(existing.Source, source, existing.Inserted, now, existing.Value.Value.Equal(metric.Value)) {
+		log.Warnf("External metric '%s/%s' [%s] collected for HPA %s/%s overwrites the value %s collected for HPA %s/%s with %s, the HPAs may use colliding metric configs",
+			namespace, metric.MetricName, labels.Set(metric.MetricLabels).String(),
+			source.Namespace, source.Name, existing.Value.Value.String(), existing.Source.Namespace, existing.Source.Name, metric.Value.String())
+		MetricCollisions.WithLabelValues(string(autoscalingv2.ExternalMetricSourceType)).Inc()
+	}
+
 	if metrics, ok := s.externalMetricsStore[namespace]; ok {
 		if labels, ok := metrics[metricName]; ok {
 			labels[labelsKey] = storedMetric
@@ -233,6 +463,9 @@ func hashLabelMap(labels map[string]string) labelsHash {
 	return labelsHash(strings.Join(strLabels, ","))
 }
 
+// parseHashLabelMap parses the labels of a labels hash. Label values may
+// contain '=' and ',', so parts without a '=' are added to the value of the
+// preceding label. Parts without a '=' before the first label are ignored.
 func parseHashLabelMap(s labelsHash) labels.Set {
 	labels := map[string]string{}
 
@@ -242,19 +475,29 @@ func parseHashLabelMap(s labelsHash) labels.Set {
 
 	keyValues := strings.Split(string(s), ",")
 
+	previous := ""
 	for _, keyValue := range keyValues {
-		splittedKeyValue := strings.Split(keyValue, "=")
-		key, value := splittedKeyValue[0], splittedKeyValue[1]
+		key, value, found := strings.Cut(keyValue, "=")
+		if !found {
+			if _, ok := labels[previous]; ok {
+				labels[previous] += "," + keyValue
+			}
+			continue
+		}
 		labels[key] = value
+		previous = key
 	}
 
 	return labels
 }
 
 // GetMetricsBySelector gets metric from the customMetricsStore using a label selector to
-// find metrics for matching resources.
+// find metrics for matching resources. Metrics of Ingresses are looked up in
+// the sibling Ingress group if none match in the requested group. Expired
+// metrics which weren't removed yet aren't returned.
 func (s *MetricStore) GetMetricsBySelector(_ context.Context, namespace objectNamespace, selector labels.Selector, info provider.CustomMetricInfo) *custom_metrics.MetricValueList {
-	matchedMetrics := make([]custom_metrics.MetricValue, 0)
+	var matchedMetrics []custom_metrics.MetricValue
+	now := s.now().UTC()
 
 	s.RLock()
 	defer s.RUnlock()
@@ -264,16 +507,36 @@ func (s *MetricStore) GetMetricsBySelector(_ context.Context, namespace objectNa
 		return &custom_metrics.MetricValueList{}
 	}
 
-	namespace2object, ok := group2namespace[info.GroupResource]
-	if !ok {
+	found := false
+	for _, groupResource := range lookupGroupResources(info.GroupResource) {
+		namespace2object, ok := group2namespace[groupResource]
+		if !ok {
+			continue
+		}
+		found = true
+		matchedMetrics = matchMetricsBySelector(namespace2object, namespace, selector, info.Namespaced, now)
+		if len(matchedMetrics) > 0 {
+			break
+		}
+	}
+
+	if !found {
 		return &custom_metrics.MetricValueList{}
 	}
 
-	if !info.Namespaced {
+	return &custom_metrics.MetricValueList{Items: matchedMetrics}
+}
+
+// matchMetricsBySelector returns the metrics of the objects of a group
+// resource matching the selector which haven't expired at now.
+func matchMetricsBySelector(namespace2object namespaceToObjectStore, namespace objectNamespace, selector labels.Selector, namespaced bool, now time.Time) []custom_metrics.MetricValue {
+	matchedMetrics := make([]custom_metrics.MetricValue, 0)
+
+	if !namespaced {
 		for _, object2labels := range namespace2object {
 			for _, labels2metric := range object2labels {
 				for _, metric := range labels2metric {
-					if selector.Matches(labels.Set(metric.Value.Metric.Selector.MatchLabels)) {
+					if !metric.TTL.Before(now) && selector.Matches(labels.Set(metric.Value.Metric.Selector.MatchLabels)) {
 						matchedMetrics = append(matchedMetrics, metric.Value)
 					}
 				}
@@ -282,20 +545,24 @@ func (s *MetricStore) GetMetricsBySelector(_ context.Context, namespace objectNa
 	} else if object2labels, ok := namespace2object[namespace]; ok {
 		for _, labels2hash := range object2labels {
 			for _, metric := range labels2hash {
-				if metric.Value.Metric.Selector != nil && selector.Matches(labels.Set(metric.Value.Metric.Selector.MatchLabels)) {
+				if metric.Value.Metric.Selector != nil && !metric.TTL.Before(now) && selector.Matches(labels.Set(metric.Value.Metric.Selector.MatchLabels)) {
 					matchedMetrics = append(matchedMetrics, metric.Value)
 				}
 			}
 		}
 	}
 
-	return &custom_metrics.MetricValueList{Items: matchedMetrics}
+	return matchedMetrics
 }
 
 // GetMetricsByName looks up metrics in the customMetricsStore by resource name.
+// Metrics of Ingresses are looked up in the sibling Ingress group if not
+// found in the requested group. Expired metrics which weren't removed yet
+// aren't returned.
 func (s *MetricStore) GetMetricsByName(_ context.Context, object types.NamespacedName, info provider.CustomMetricInfo, selector labels.Selector) *custom_metrics.MetricValue {
 	name := objectName(object.Name)
 	namespace := objectNamespace(object.Namespace)
+	now := s.now().UTC()
 
 	s.RLock()
 	defer s.RUnlock()
@@ -305,19 +572,30 @@ func (s *MetricStore) GetMetricsByName(_ context.Context, object types.Namespace
 		return nil
 	}
 
-	namespace2object, ok := group2namespace[info.GroupResource]
-	if !ok {
-		return nil
+	for _, groupResource := range lookupGroupResources(info.GroupResource) {
+		namespace2object, ok := group2namespace[groupResource]
+		if !ok {
+			continue
+		}
+		if value := findMetricByName(namespace2object, namespace, name, selector, info.Namespaced, now); value != nil {
+			return value
+		}
 	}
 
-	if !info.Namespaced {
+	return nil
+}
+
+// findMetricByName returns the metric of the named object of a group
+// resource matching the selector which hasn't expired at now.
+func findMetricByName(namespace2object namespaceToObjectStore, namespace objectNamespace, name objectName, selector labels.Selector, namespaced bool, now time.Time) *custom_metrics.MetricValue {
+	if !namespaced {
 		// TODO: rethink no namespace queries
 		namespace := objectNamespace(name)
 
 		for _, object2label := range namespace2object {
 			if label2metric, ok := object2label[objectName(namespace)]; ok {
 				for metric, value := range label2metric {
-					if selector.Matches(parseHashLabelMap(metric)) {
+					if !value.TTL.Before(now) && selector.Matches(parseHashLabelMap(metric)) {
 						return &value.Value
 					}
 				}
@@ -326,7 +604,7 @@ func (s *MetricStore) GetMetricsByName(_ context.Context, object types.Namespace
 	} else if object2label, ok := namespace2object[namespace]; ok {
 		if label2metric, ok := object2label[name]; ok {
 			for metric, value := range label2metric {
-				if selector.Matches(parseHashLabelMap(metric)) {
+				if !value.TTL.Before(now) && selector.Matches(parseHashLabelMap(metric)) {
 					return &value.Value
 				}
 			}
@@ -336,12 +614,15 @@ func (s *MetricStore) GetMetricsByName(_ context.Context, object types.Namespace
 	return nil
 }
 
-// ListAllMetrics lists all custom metrics in the Metrics Store.
+// ListAllMetrics lists all custom metrics in the Metrics Store. Metrics
+// present in multiple namespaces are only listed once and the list is
+// sorted by group, resource and metric to provide a stable discovery.
 func (s *MetricStore) ListAllMetrics() []provider.CustomMetricInfo {
 	s.RLock()
 	defer s.RUnlock()
 
 	metrics := make([]provider.CustomMetricInfo, 0, len(s.customMetricsStore))
+	seen := make(map[provider.CustomMetricInfo]struct{}, len(s.customMetricsStore))
 
 	for metric, customMetricsStoredMetrics := range s.customMetricsStore {
 		for groupResource, group := range customMetricsStoredMetrics {
@@ -351,67 +632,143 @@ func (s *MetricStore) ListAllMetrics() []provider.CustomMetricInfo {
 					Namespaced:    namespace != "",
 					Metric:        string(metric),
 				}
+				if _, ok := seen[metric]; ok {
+					continue
+				}
+				seen[metric] = struct{}{}
 				metrics = append(metrics, metric)
 			}
 		}
 	}
 
+	sort.Slice(metrics, func(i, j int) bool {
+		a, b := metrics[i], metrics[j]
+		if a.GroupResource.Group != b.GroupResource.Group {
+			return a.GroupResource.Group < b.GroupResource.Group
+		}
+		if a.GroupResource.Resource != b.GroupResource.Resource {
+			return a.GroupResource.Resource < b.GroupResource.Resource
+		}
+		if a.Metric != b.Metric {
+			return a.Metric < b.Metric
+		}
+		return !a.Namespaced && b.Namespaced
+	})
+
 	return metrics
 }
 
 // GetExternalMetric gets external metric from the store by metric name and
-// selector.
+// selector. The cluster scoped series stored without namespace are returned
+// for any namespace after the series of the namespace. Expired metrics which
+// weren't removed yet aren't returned.
 func (s *MetricStore) GetExternalMetric(_ context.Context, namespace objectNamespace, selector labels.Selector, info provider.ExternalMetricInfo) (*external_metrics.ExternalMetricValueList, error) {
-	matchedMetrics := make([]external_metrics.ExternalMetricValue, 0)
+	now := s.now().UTC()
 
 	s.RLock()
 	defer s.RUnlock()
 
-	if metrics, ok := s.externalMetricsStore[namespace]; ok {
-		if selectors, ok := metrics[metricName(info.Metric)]; ok {
-			for _, sel := range selectors {
-				if selector.Matches(labels.Set(sel.Value.MetricLabels)) {
-					matchedMetrics = append(matchedMetrics, sel.Value)
-				}
-			}
-		}
+	matchedMetrics := s.matchExternalMetrics(namespace, selector, info, now)
+	if namespace != "" {
+		matchedMetrics = append(matchedMetrics, s.matchExternalMetrics("", selector, info, now)...)
 	}
 
 	return &external_metrics.ExternalMetricValueList{Items: matchedMetrics}, nil
 }
 
+// matchExternalMetrics returns the external metrics stored for the namespace
+// matching the metric name and selector which haven't expired at now.
+func (s *MetricStore) matchExternalMetrics(namespace objectNamespace, selector labels.Selector, info provider.ExternalMetricInfo, now time.Time) []external_metrics.ExternalMetricValue {
+	matchedMetrics := make([]external_metrics.ExternalMetricValue, 0)
+	for _, sel := range s.externalMetricsStore[namespace][metricName(info.Metric)] {
+		if !sel.TTL.Before(now) && selector.Matches(labels.Set(sel.Value.MetricLabels)) {
+			matchedMetrics = append(matchedMetrics, sel.Value)
+		}
+	}
+	return matchedMetrics
+}
+
 // ListAllExternalMetrics lists all external metrics in the Metrics Store.
+// Metrics present in multiple namespaces are only listed once and the list
+// is sorted by metric name.
 func (s *MetricStore) ListAllExternalMetrics() []provider.ExternalMetricInfo {
 	s.RLock()
 	defer s.RUnlock()
 
 	metricsInfo := make([]provider.ExternalMetricInfo, 0, len(s.externalMetricsStore))
+	seen := make(map[metricName]struct{}, len(s.externalMetricsStore))
 
 	for _, metrics := range s.externalMetricsStore {
 		for metricName := range metrics {
+			if _, ok := seen[metricName]; ok {
+				continue
+			}
+			seen[metricName] = struct{}{}
 			info := provider.ExternalMetricInfo{
 				Metric: string(metricName),
 			}
 			metricsInfo = append(metricsInfo, info)
 		}
 	}
+
+	sort.Slice(metricsInfo, func(i, j int) bool {
+		return metricsInfo[i].Metric < metricsInfo[j].Metric
+	})
+
 	return metricsInfo
 }
 
+// removeExpiredBatchSize is the number of stored metrics after which
+// RemoveExpired releases the store lock to not starve readers of huge
+// stores.
+const removeExpiredBatchSize = 10000
+
 // RemoveExpired removes expired metrics from the Metrics Store. A metric is
-// considered expired if its metricsTTL is before time.Now().
-func (s *MetricStore) RemoveExpired() {
+// considered expired if its metricsTTL is before the current time of the
+// store. It returns the number of removed metrics.
+func (s *MetricStore) RemoveExpired() int {
+	now := s.now().UTC()
+	return s.removeExpiredCustomMetrics(now) + s.removeExpiredExternalMetrics(now)
+}
+
+// yield releases the store lock for other goroutines once more than
+// removeExpiredBatchSize metrics were visited. The caller must hold the lock.
+func (s *MetricStore) yield(visited *int) {
+	if *visited < removeExpiredBatchSize {
+		return
+	}
+	s.Unlock()
+	runtime.Gosched()
+	s.Lock()
+	*visited = 0
+}
+
+func (s *MetricStore) removeExpiredCustomMetrics(now time.Time) int {
 	s.Lock()
 	defer s.Unlock()
 
-	// cleanup custom metrics
-	for metricName, group2namespace := range s.customMetricsStore {
+	metricNames := make([]metricName, 0, len(s.customMetricsStore))
+	for metricName := range s.customMetricsStore {
+		metricNames = append(metricNames, metricName)
+	}
+
+	removed, visited := 0, 0
+	for _, metricName := range metricNames {
+		s.yield(&visited)
+
+		group2namespace, ok := s.customMetricsStore[metricName]
+		if !ok {
+			continue
+		}
+
 		for group, namespace2object := range group2namespace {
 			for namespace, object2label := range namespace2object {
 				for object, label2metric := range object2label {
 					for labelsHash, metric := range label2metric {
-						if metric.TTL.Before(time.Now().UTC()) {
+						visited++
+						if metric.TTL.Before(now) {
 							delete(label2metric, labelsHash)
+							removed++
 						}
 					}
 					if len(label2metric) == 0 {
@@ -431,12 +788,33 @@ func (s *MetricStore) RemoveExpired() {
 		}
 	}
 
-	// cleanup external metrics
-	for namespace, metrics := range s.externalMetricsStore {
+	return removed
+}
+
+func (s *MetricStore) removeExpiredExternalMetrics(now time.Time) int {
+	s.Lock()
+	defer s.Unlock()
+
+	namespaces := make([]objectNamespace, 0, len(s.externalMetricsStore))
+	for namespace := range s.externalMetricsStore {
+		namespaces = append(namespaces, namespace)
+	}
+
+	removed, visited := 0, 0
+	for _, namespace := range namespaces {
+		s.yield(&visited)
+
+		metrics, ok := s.externalMetricsStore[namespace]
+		if !ok {
+			continue
+		}
+
 		for metricName, selectors := range metrics {
 			for k, metric := range selectors {
-				if metric.TTL.Before(time.Now().UTC()) {
+				visited++
+				if metric.TTL.Before(now) {
 					delete(selectors, k)
+					removed++
 				}
 			}
 			if len(selectors) == 0 {
@@ -447,4 +825,6 @@ func (s *MetricStore) RemoveExpired() {
 			delete(s.externalMetricsStore, namespace)
 		}
 	}
+
+	return removed
 }
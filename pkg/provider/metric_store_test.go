@@ -1,10 +1,15 @@
 package provider
 
 import (
+	"fmt"
 	"sort"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/require"
 	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
 	"golang.org/x/net/context"
@@ -59,7 +64,7 @@ func TestInternalMetricStorage(t *testing.T) {
 			expectedFound: true,
 			list: []provider.CustomMetricInfo{
 				{
-					GroupResource: schema.GroupResource{},
+					GroupResource: schema.GroupResource{Group: "apps", Resource: "deployments"},
 					Namespaced:    true,
 					Metric:        "metric-per-unit",
 				},
@@ -70,7 +75,7 @@ func TestInternalMetricStorage(t *testing.T) {
 			}{
 				name: types.NamespacedName{Name: "metricObject", Namespace: "default"},
 				info: provider.CustomMetricInfo{
-					GroupResource: schema.GroupResource{},
+					GroupResource: schema.GroupResource{Group: "apps", Resource: "deployments"},
 					Namespaced:    true,
 					Metric:        "metric-per-unit",
 				},
@@ -83,7 +88,7 @@ func TestInternalMetricStorage(t *testing.T) {
 				namespace: "default",
 				selector:  labels.Everything(),
 				info: provider.CustomMetricInfo{
-					GroupResource: schema.GroupResource{},
+					GroupResource: schema.GroupResource{Group: "apps", Resource: "deployments"},
 					Namespaced:    true,
 					Metric:        "metric-per-unit",
 				},
@@ -277,7 +282,7 @@ func TestInternalMetricStorage(t *testing.T) {
 			expectedFound: true,
 			list: []provider.CustomMetricInfo{
 				{
-					GroupResource: schema.GroupResource{},
+					GroupResource: schema.GroupResource{Resource: "nodes"},
 					Namespaced:    false,
 					Metric:        "metric-per-unit",
 				},
@@ -288,7 +293,7 @@ func TestInternalMetricStorage(t *testing.T) {
 			}{
 				name: types.NamespacedName{Name: "metricObject", Namespace: ""},
 				info: provider.CustomMetricInfo{
-					GroupResource: schema.GroupResource{},
+					GroupResource: schema.GroupResource{Resource: "nodes"},
 					Namespaced:    false,
 					Metric:        "metric-per-unit",
 				},
@@ -301,7 +306,7 @@ func TestInternalMetricStorage(t *testing.T) {
 				namespace: "",
 				selector:  labels.Everything(),
 				info: provider.CustomMetricInfo{
-					GroupResource: schema.GroupResource{},
+					GroupResource: schema.GroupResource{Resource: "nodes"},
 					Namespaced:    false,
 					Metric:        "metric-per-unit",
 				},
@@ -626,7 +631,7 @@ func TestMultipleMetricValues(t *testing.T) {
 			},
 			list: []provider.CustomMetricInfo{
 				{
-					GroupResource: schema.GroupResource{},
+					GroupResource: schema.GroupResource{Group: "apps", Resource: "deployments"},
 					Namespaced:    true,
 					Metric:        "metric-per-unit",
 				},
@@ -637,7 +642,7 @@ func TestMultipleMetricValues(t *testing.T) {
 			}{
 				name: types.NamespacedName{Name: "metricObject", Namespace: "default"},
 				info: provider.CustomMetricInfo{
-					GroupResource: schema.GroupResource{},
+					GroupResource: schema.GroupResource{Group: "apps", Resource: "deployments"},
 					Namespaced:    true,
 					Metric:        "metric-per-unit",
 				},
@@ -650,7 +655,7 @@ func TestMultipleMetricValues(t *testing.T) {
 				namespace: "default",
 				selector:  labels.Everything(),
 				info: provider.CustomMetricInfo{
-					GroupResource: schema.GroupResource{},
+					GroupResource: schema.GroupResource{Group: "apps", Resource: "deployments"},
 					Namespaced:    true,
 					Metric:        "metric-per-unit",
 				},
@@ -687,10 +692,11 @@ func TestMultipleMetricValues(t *testing.T) {
 
 func TestCustomMetricsStorageErrors(t *testing.T) {
 	var metricStoreTests = []struct {
-		test   string
-		insert collector.CollectedMetric
-		list   []provider.CustomMetricInfo
-		byName struct {
+		test     string
+		insert   collector.CollectedMetric
+		rejected string
+		list     []provider.CustomMetricInfo
+		byName   struct {
 			name types.NamespacedName
 			info provider.CustomMetricInfo
 		}
@@ -701,9 +707,10 @@ func TestCustomMetricsStorageErrors(t *testing.T) {
 		}
 	}{
 		{
-			test:   "insert/list/get an empty metric",
-			insert: collector.CollectedMetric{},
-			list:   []provider.CustomMetricInfo{},
+			test:     "insert/list/get an empty metric",
+			insert:   collector.CollectedMetric{},
+			rejected: rejectedUnknownType,
+			list:     []provider.CustomMetricInfo{},
 			byName: struct {
 				name types.NamespacedName
 				info provider.CustomMetricInfo
@@ -739,18 +746,13 @@ func TestCustomMetricsStorageErrors(t *testing.T) {
 					DescribedObject: custom_metrics.ObjectReference{
 						Name:       "metricObject",
 						Namespace:  "default",
-						Kind:       "Deployment",
+						Kind:       "DaemonSet",
 						APIVersion: "apps/v1",
 					},
 				},
 			},
-			list: []provider.CustomMetricInfo{
-				{
-					GroupResource: schema.GroupResource{},
-					Namespaced:    true,
-					Metric:        "metric-per-unit",
-				},
-			},
+			rejected: rejectedUnmappedKind,
+			list:     []provider.CustomMetricInfo{},
 			byName: struct {
 				name types.NamespacedName
 				info provider.CustomMetricInfo
@@ -790,8 +792,11 @@ func TestCustomMetricsStorageErrors(t *testing.T) {
 				return time.Now().UTC().Add(15 * time.Minute)
 			})
 
+			rejected := testutil.ToFloat64(StoreRejectedInserts.WithLabelValues(tc.rejected))
+
 			// Insert a metric with value
 			metricsStore.Insert(tc.insert)
+			require.Equal(t, rejected+1, testutil.ToFloat64(StoreRejectedInserts.WithLabelValues(tc.rejected)))
 
 			// List a metric with value
 			metricInfos := metricsStore.ListAllMetrics()
@@ -928,7 +933,7 @@ func TestCustomMetricsStorageErrors(t *testing.T) {
 			},
 			list: []provider.CustomMetricInfo{
 				{
-					GroupResource: schema.GroupResource{},
+					GroupResource: schema.GroupResource{Group: "apps", Resource: "deployments"},
 					Namespaced:    true,
 					Metric:        "metric-per-unit",
 				},
@@ -939,7 +944,7 @@ func TestCustomMetricsStorageErrors(t *testing.T) {
 			}{
 				name: types.NamespacedName{Name: "metricObject-000", Namespace: "default"},
 				info: provider.CustomMetricInfo{
-					GroupResource: schema.GroupResource{},
+					GroupResource: schema.GroupResource{Group: "apps", Resource: "deployments"},
 					Namespaced:    true,
 					Metric:        "metric-per-unit",
 				},
@@ -952,7 +957,7 @@ func TestCustomMetricsStorageErrors(t *testing.T) {
 				namespace: "default",
 				selector:  labels.Everything(),
 				info: provider.CustomMetricInfo{
-					GroupResource: schema.GroupResource{},
+					GroupResource: schema.GroupResource{Group: "apps", Resource: "deployments"},
 					Namespaced:    true,
 					Metric:        "metric-per-unit",
 				},
@@ -1140,9 +1145,6 @@ func TestMultipleExternalMetricStorage(t *testing.T) {
 				{
 					Metric: "metric-per-unit",
 				},
-				{
-					Metric: "metric-per-unit",
-				},
 			},
 			get: struct {
 				namespace string
@@ -1269,7 +1271,8 @@ func TestMetricsExpiration(t *testing.T) {
 	metricStore.Insert(customMetric)
 	metricStore.Insert(externalMetric)
 
-	metricStore.RemoveExpired()
+	removed := metricStore.RemoveExpired()
+	require.Equal(t, 2, removed)
 
 	customMetricInfos := metricStore.ListAllMetrics()
 	require.Len(t, customMetricInfos, 0)
@@ -1308,7 +1311,8 @@ func TestMetricsNonExpiration(t *testing.T) {
 	metricStore.Insert(customMetric)
 	metricStore.Insert(externalMetric)
 
-	metricStore.RemoveExpired()
+	removed := metricStore.RemoveExpired()
+	require.Equal(t, 0, removed)
 
 	customMetricInfos := metricStore.ListAllMetrics()
 	require.Len(t, customMetricInfos, 1)
@@ -1317,3 +1321,719 @@ func TestMetricsNonExpiration(t *testing.T) {
 	require.Len(t, externalMetricInfos, 1)
 
 }
+
+func TestMetricsExpirationMetricTTL(t *testing.T) {
+	metricStore := NewMetricStore(func() time.Time {
+		return time.Now().UTC().Add(15 * time.Minute)
+	})
+	// the metrics are inserted 10 minutes ago.
+	now := time.Now().Add(-10 * time.Minute)
+	metricStore.now = func() time.Time {
+		return now
+	}
+
+	customMetric := func(name string, ttl time.Duration) collector.CollectedMetric {
+		return collector.CollectedMetric{
+			Type: autoscalingv2.ObjectMetricSourceType,
+			Custom: custom_metrics.MetricValue{
+				Metric: newMetricIdentifier(name, metav1.LabelSelector{}),
+				Value:  *resource.NewQuantity(0, ""),
+				DescribedObject: custom_metrics.ObjectReference{
+					Name:       "metricObject",
+					Kind:       "Node",
+					APIVersion: "core/v1",
+				},
+			},
+			TTL: ttl,
+		}
+	}
+	externalMetric := func(name string, ttl time.Duration) collector.CollectedMetric {
+		return collector.CollectedMetric{
+			Type:      autoscalingv2.ExternalMetricSourceType,
+			Namespace: "default",
+			External: external_metrics.ExternalMetricValue{
+				MetricName: name,
+				Value:      *resource.NewQuantity(0, ""),
+			},
+			TTL: ttl,
+		}
+	}
+
+	metricStore.Insert(customMetric("expired", 5*time.Minute))
+	metricStore.Insert(customMetric("unexpired", 20*time.Minute))
+	metricStore.Insert(customMetric("global-ttl", 0))
+	metricStore.Insert(externalMetric("expired", 5*time.Minute))
+	metricStore.Insert(externalMetric("unexpired", 20*time.Minute))
+	metricStore.Insert(externalMetric("global-ttl", 0))
+
+	now = now.Add(10 * time.Minute)
+	removed := metricStore.RemoveExpired()
+	require.Equal(t, 2, removed)
+
+	customMetricInfos := metricStore.ListAllMetrics()
+	require.Len(t, customMetricInfos, 2)
+	for _, info := range customMetricInfos {
+		require.NotEqual(t, "expired", info.Metric)
+	}
+
+	require.ElementsMatch(t, []provider.ExternalMetricInfo{
+		{Metric: "global-ttl"},
+		{Metric: "unexpired"},
+	}, metricStore.ListAllExternalMetrics())
+}
+
+func TestRemoveExpiredLargeStore(t *testing.T) {
+	expired := true
+	metricStore := NewMetricStore(func() time.Time {
+		if expired {
+			return time.Now().UTC().Add(-time.Hour)
+		}
+		return time.Now().UTC().Add(time.Hour)
+	})
+
+	// spread the metrics over enough namespaces for the removal to
+	// yield the lock in between.
+	n := 2*removeExpiredBatchSize + 1
+	for i := 0; i < n; i++ {
+		expired = i%2 == 0
+		metricStore.Insert(collector.CollectedMetric{
+			Type:      autoscalingv2.ExternalMetricSourceType,
+			Namespace: fmt.Sprintf("namespace-%d", i%100),
+			External: external_metrics.ExternalMetricValue{
+				MetricName:   "metric",
+				MetricLabels: map[string]string{"id": strconv.Itoa(i)},
+				Value:        *resource.NewQuantity(0, ""),
+			},
+		})
+	}
+
+	// the metrics with an even index are expired
+	removed := metricStore.RemoveExpired()
+	require.Equal(t, (n+1)/2, removed)
+	require.Equal(t, 0, metricStore.RemoveExpired())
+}
+
+func TestExpiredMetricsAreNotServed(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	metricStore := NewMetricStore(func() time.Time {
+		return now.Add(15 * time.Minute)
+	})
+	metricStore.now = func() time.Time { return now }
+
+	metricStore.Insert(collector.CollectedMetric{
+		Type: autoscalingv2.ObjectMetricSourceType,
+		Custom: custom_metrics.MetricValue{
+			Metric: newMetricIdentifier("requests-per-second", metav1.LabelSelector{MatchLabels: map[string]string{"app": "a"}}),
+			Value:  *resource.NewQuantity(1, ""),
+			DescribedObject: custom_metrics.ObjectReference{
+				Name:       "a",
+				Namespace:  "default",
+				Kind:       "Pod",
+				APIVersion: "v1",
+			},
+		},
+		TTL: time.Minute,
+	})
+	metricStore.Insert(collector.CollectedMetric{
+		Type:      autoscalingv2.ExternalMetricSourceType,
+		Namespace: "default",
+		External: external_metrics.ExternalMetricValue{
+			MetricName: "queue-length",
+			Value:      *resource.NewQuantity(1, ""),
+		},
+		TTL: time.Minute,
+	})
+
+	info := provider.CustomMetricInfo{
+		GroupResource: schema.GroupResource{Resource: "pods"},
+		Namespaced:    true,
+		Metric:        "requests-per-second",
+	}
+	served := func() (*custom_metrics.MetricValue, []custom_metrics.MetricValue, []external_metrics.ExternalMetricValue) {
+		byName := metricStore.GetMetricsByName(context.Background(), types.NamespacedName{Namespace: "default", Name: "a"}, info, labels.Everything())
+		bySelector := metricStore.GetMetricsBySelector(context.Background(), "default", labels.Everything(), info)
+		external, err := metricStore.GetExternalMetric(context.Background(), "default", labels.Everything(), provider.ExternalMetricInfo{Metric: "queue-length"})
+		require.NoError(t, err)
+		return byName, bySelector.Items, external.Items
+	}
+
+	byName, bySelector, external := served()
+	require.NotNil(t, byName)
+	require.Len(t, bySelector, 1)
+	require.Len(t, external, 1)
+	require.Equal(t, 0, metricStore.RemoveExpired())
+
+	// the metrics expire with their TTL before the store TTL and aren't
+	// served even though they weren't removed yet.
+	now = now.Add(2 * time.Minute)
+	byName, bySelector, external = served()
+	require.Nil(t, byName)
+	require.Empty(t, bySelector)
+	require.Empty(t, external)
+	require.Len(t, metricStore.ListAllExternalMetrics(), 1)
+
+	// the garbage collection uses the same clock.
+	require.Equal(t, 2, metricStore.RemoveExpired())
+	require.Empty(t, metricStore.ListAllExternalMetrics())
+}
+
+func TestListAllExternalMetricsDeduplicated(t *testing.T) {
+	metricsStore := NewMetricStore(func() time.Time {
+		return time.Now().UTC().Add(15 * time.Minute)
+	})
+
+	for _, namespace := range []string{"ns-c", "ns-a", "ns-b"} {
+		for _, name := range []string{"metric-b", "metric-a"} {
+			metricsStore.Insert(collector.CollectedMetric{
+				Type:      autoscalingv2.ExternalMetricSourceType,
+				Namespace: namespace,
+				External: external_metrics.ExternalMetricValue{
+					MetricName:   name,
+					MetricLabels: map[string]string{"type": "prometheus"},
+					Value:        *resource.NewQuantity(1, ""),
+				},
+			})
+		}
+	}
+
+	require.Equal(t, []provider.ExternalMetricInfo{
+		{Metric: "metric-a"},
+		{Metric: "metric-b"},
+	}, metricsStore.ListAllExternalMetrics())
+}
+
+func TestClusterScopedExternalMetrics(t *testing.T) {
+	metricsStore := NewMetricStore(func() time.Time {
+		return time.Now().UTC().Add(15 * time.Minute)
+	})
+
+	for namespace, value := range map[string]int64{"": 1, "team-a": 2, "team-b": 3} {
+		metricsStore.Insert(collector.CollectedMetric{
+			Type:      autoscalingv2.ExternalMetricSourceType,
+			Namespace: namespace,
+			External: external_metrics.ExternalMetricValue{
+				MetricName:   "queue-length",
+				MetricLabels: map[string]string{"topic": "orders"},
+				Value:        *resource.NewQuantity(value, ""),
+			},
+		})
+	}
+
+	for _, tc := range []struct {
+		namespace string
+		selector  labels.Selector
+		expected  []int64
+	}{
+		{namespace: "team-a", expected: []int64{2, 1}},
+		{namespace: "team-b", expected: []int64{3, 1}},
+		{namespace: "other", expected: []int64{1}},
+		{namespace: "", expected: []int64{1}},
+		// both scopes are served regardless of the selector.
+		{namespace: "team-a", selector: labels.SelectorFromSet(labels.Set{"topic": "orders"}), expected: []int64{2, 1}},
+		{namespace: "team-a", selector: labels.SelectorFromSet(labels.Set{"topic": "payments"})},
+	} {
+		t.Run(tc.namespace, func(t *testing.T) {
+			selector := tc.selector
+			if selector == nil {
+				selector = labels.Everything()
+			}
+			metrics, err := metricsStore.GetExternalMetric(context.Background(), objectNamespace(tc.namespace), selector, provider.ExternalMetricInfo{Metric: "queue-length"})
+			require.NoError(t, err)
+
+			var values []int64
+			for _, item := range metrics.Items {
+				values = append(values, item.Value.Value())
+			}
+			require.Equal(t, tc.expected, values)
+		})
+	}
+
+	require.Equal(t, []provider.ExternalMetricInfo{{Metric: "queue-length"}}, metricsStore.ListAllExternalMetrics())
+}
+
+func TestListAllMetricsDeduplicated(t *testing.T) {
+	metricsStore := NewMetricStore(func() time.Time {
+		return time.Now().UTC().Add(15 * time.Minute)
+	})
+
+	for _, namespace := range []string{"ns-b", "ns-a"} {
+		for _, kind := range []string{"Pod", "Ingress"} {
+			metricsStore.Insert(collector.CollectedMetric{
+				Type: autoscalingv2.ObjectMetricSourceType,
+				Custom: custom_metrics.MetricValue{
+					Metric: newMetricIdentifier("metric-per-unit", metav1.LabelSelector{}),
+					Value:  *resource.NewQuantity(0, ""),
+					DescribedObject: custom_metrics.ObjectReference{
+						Name:       "metricObject",
+						Namespace:  namespace,
+						Kind:       kind,
+						APIVersion: "v1",
+					},
+				},
+			})
+		}
+	}
+
+	// Ingresses referenced with the core API version are stored under
+	// networking.k8s.io.
+	require.Equal(t, []provider.CustomMetricInfo{
+		{
+			GroupResource: schema.GroupResource{Resource: "pods"},
+			Namespaced:    true,
+			Metric:        "metric-per-unit",
+		},
+		{
+			GroupResource: schema.GroupResource{Group: "networking.k8s.io", Resource: "ingresses"},
+			Namespaced:    true,
+			Metric:        "metric-per-unit",
+		},
+	}, metricsStore.ListAllMetrics())
+}
+
+func TestSameNamedRouteGroupsInDifferentNamespaces(t *testing.T) {
+	metricsStore := NewMetricStore(func() time.Time {
+		return time.Now().UTC().Add(15 * time.Minute)
+	})
+
+	values := map[string]int64{"team-a": 10, "team-b": 20}
+	for namespace, value := range values {
+		metricsStore.Insert(collector.CollectedMetric{
+			Type: autoscalingv2.ObjectMetricSourceType,
+			Custom: custom_metrics.MetricValue{
+				Metric: newMetricIdentifier("requests-per-second", metav1.LabelSelector{}),
+				Value:  *resource.NewQuantity(value, ""),
+				DescribedObject: custom_metrics.ObjectReference{
+					Name:       "app",
+					Namespace:  namespace,
+					Kind:       "RouteGroup",
+					APIVersion: "zalando.org/v1",
+				},
+			},
+		})
+	}
+
+	info := provider.CustomMetricInfo{
+		GroupResource: schema.GroupResource{Resource: "routegroups", Group: "zalando.org"},
+		Namespaced:    true,
+		Metric:        "requests-per-second",
+	}
+
+	for namespace, value := range values {
+		metric := metricsStore.GetMetricsByName(context.Background(), types.NamespacedName{Namespace: namespace, Name: "app"}, info, labels.Everything())
+		require.NotNil(t, metric, namespace)
+		require.Equal(t, value, metric.Value.Value(), namespace)
+	}
+}
+
+func TestMetricCollisions(t *testing.T) {
+	now := time.Now()
+	metricsStore := NewMetricStore(func() time.Time {
+		return now.Add(15 * time.Minute)
+	})
+	metricsStore.now = func() time.Time { return now }
+
+	hpaA := resourceReference{Namespace: "team-a", Name: "app"}
+	hpaB := resourceReference{Namespace: "team-b", Name: "app"}
+
+	// both HPAs reference an Ingress named app, but the metrics are
+	// collected without the namespace of the Ingress.
+	ingressMetric := func(value int64) collector.CollectedMetric {
+		return collector.CollectedMetric{
+			Type: autoscalingv2.ObjectMetricSourceType,
+			Custom: custom_metrics.MetricValue{
+				Metric: newMetricIdentifier("requests-per-second,backend", metav1.LabelSelector{}),
+				Value:  *resource.NewQuantity(value, ""),
+				DescribedObject: custom_metrics.ObjectReference{
+					Name:       "app",
+					Kind:       "Ingress",
+					APIVersion: "networking.k8s.io/v1",
+				},
+			},
+		}
+	}
+	externalMetric := func(value int64) collector.CollectedMetric {
+		return collector.CollectedMetric{
+			Type:      autoscalingv2.ExternalMetricSourceType,
+			Namespace: "team-a",
+			External: external_metrics.ExternalMetricValue{
+				MetricName:   "queue-length",
+				MetricLabels: map[string]string{"queue": "jobs"},
+				Value:        *resource.NewQuantity(value, ""),
+			},
+		}
+	}
+
+	custom := MetricCollisions.WithLabelValues(string(autoscalingv2.ObjectMetricSourceType))
+	external := MetricCollisions.WithLabelValues(string(autoscalingv2.ExternalMetricSourceType))
+	customBefore := testutil.ToFloat64(custom)
+	externalBefore := testutil.ToFloat64(external)
+
+	// updates by the same HPA and inserts of unknown sources are no
+	// collisions.
+	metricsStore.insertFrom(hpaA, ingressMetric(10))
+	metricsStore.insertFrom(hpaA, ingressMetric(20))
+	metricsStore.Insert(ingressMetric(30))
+	metricsStore.insertFrom(hpaA, ingressMetric(10))
+	require.Equal(t, customBefore, testutil.ToFloat64(custom))
+
+	// the same value of another HPA is no collision.
+	metricsStore.insertFrom(hpaB, ingressMetric(10))
+	require.Equal(t, customBefore, testutil.ToFloat64(custom))
+
+	// a different value of another HPA within the same tick collides.
+	metricsStore.insertFrom(hpaA, ingressMetric(10))
+	metricsStore.insertFrom(hpaB, ingressMetric(40))
+	require.Equal(t, customBefore+1, testutil.ToFloat64(custom))
+
+	// overwriting a value from a previous tick is no collision.
+	metricsStore.now = func() time.Time { return now.Add(defaultCollisionWindow) }
+	metricsStore.insertFrom(hpaA, ingressMetric(10))
+	require.Equal(t, customBefore+1, testutil.ToFloat64(custom))
+
+	metricsStore.insertFrom(hpaA, externalMetric(1))
+	metricsStore.insertFrom(hpaB, externalMetric(1))
+	require.Equal(t, externalBefore, testutil.ToFloat64(external))
+	metricsStore.insertFrom(hpaA, externalMetric(2))
+	require.Equal(t, externalBefore+1, testutil.ToFloat64(external))
+}
+
+func TestRejectedInserts(t *testing.T) {
+	customMetric := func(name string, object custom_metrics.ObjectReference) collector.CollectedMetric {
+		return collector.CollectedMetric{
+			Type: autoscalingv2.PodsMetricSourceType,
+			Custom: custom_metrics.MetricValue{
+				Metric:          newMetricIdentifier(name, metav1.LabelSelector{}),
+				Value:           *resource.NewQuantity(1, ""),
+				DescribedObject: object,
+			},
+		}
+	}
+
+	for _, tc := range []struct {
+		msg    string
+		insert collector.CollectedMetric
+		reason string
+	}{
+		{
+			msg:    "unknown type",
+			insert: collector.CollectedMetric{Type: autoscalingv2.ResourceMetricSourceType},
+			reason: rejectedUnknownType,
+		},
+		{
+			msg:    "custom metric without name",
+			insert: customMetric("", custom_metrics.ObjectReference{Kind: "Pod", Name: "app-1", Namespace: "default"}),
+			reason: rejectedEmptyMetricName,
+		},
+		{
+			msg:    "custom metric without object name",
+			insert: customMetric("requests-per-second", custom_metrics.ObjectReference{Kind: "Pod", Namespace: "default"}),
+			reason: rejectedEmptyObjectName,
+		},
+		{
+			msg:    "custom metric without kind",
+			insert: customMetric("requests-per-second", custom_metrics.ObjectReference{Name: "app-1", Namespace: "default"}),
+			reason: rejectedUnmappedKind,
+		},
+		{
+			msg: "external metric without name",
+			insert: collector.CollectedMetric{
+				Type:      autoscalingv2.ExternalMetricSourceType,
+				Namespace: "default",
+				External: external_metrics.ExternalMetricValue{
+					MetricLabels: map[string]string{"type": "prometheus"},
+					Value:        *resource.NewQuantity(1, ""),
+				},
+			},
+			reason: rejectedEmptyMetricName,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			metricsStore := NewMetricStore(func() time.Time {
+				return time.Now().UTC().Add(15 * time.Minute)
+			})
+			rejected := testutil.ToFloat64(StoreRejectedInserts.WithLabelValues(tc.reason))
+
+			require.False(t, metricsStore.insertFrom(resourceReference{Namespace: "default", Name: "app"}, tc.insert))
+			require.Equal(t, rejected+1, testutil.ToFloat64(StoreRejectedInserts.WithLabelValues(tc.reason)))
+			require.Empty(t, metricsStore.customMetricsStore)
+			require.Empty(t, metricsStore.externalMetricsStore)
+		})
+	}
+}
+
+func TestRejectedInsertsLogRateLimited(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	now := time.Now()
+	metricsStore := NewMetricStore(func() time.Time {
+		return now.Add(15 * time.Minute)
+	})
+	metricsStore.now = func() time.Time { return now }
+
+	invalid := collector.CollectedMetric{
+		Type:     autoscalingv2.ExternalMetricSourceType,
+		External: external_metrics.ExternalMetricValue{Value: *resource.NewQuantity(1, "")},
+	}
+	rejected := testutil.ToFloat64(StoreRejectedInserts.WithLabelValues(rejectedEmptyMetricName))
+
+	for i := 0; i < 3; i++ {
+		metricsStore.Insert(invalid)
+	}
+	// every insert is counted, but only logged once per interval.
+	require.Equal(t, rejected+3, testutil.ToFloat64(StoreRejectedInserts.WithLabelValues(rejectedEmptyMetricName)))
+	require.Len(t, hook.AllEntries(), 1)
+
+	// other reasons are logged independently.
+	metricsStore.Insert(collector.CollectedMetric{})
+	require.Len(t, hook.AllEntries(), 2)
+
+	metricsStore.now = func() time.Time { return now.Add(rejectedInsertLogInterval) }
+	metricsStore.Insert(invalid)
+	require.Len(t, hook.AllEntries(), 3)
+}
+
+func TestListAllMetricsStableOrder(t *testing.T) {
+	metricsStore := NewMetricStore(func() time.Time {
+		return time.Now().UTC().Add(15 * time.Minute)
+	})
+
+	kinds := []struct {
+		kind       string
+		apiVersion string
+	}{
+		{kind: "Pod", apiVersion: "v1"},
+		{kind: "Ingress", apiVersion: "networking.k8s.io/v1"},
+		{kind: "RouteGroup", apiVersion: "zalando.org/v1"},
+		{kind: "Deployment", apiVersion: "apps/v1"},
+		{kind: "Node", apiVersion: "v1"},
+	}
+
+	for i := 0; i < 50; i++ {
+		for _, kind := range kinds {
+			namespace := fmt.Sprintf("namespace-%d", i%3)
+			if kind.kind == "Node" {
+				namespace = ""
+			}
+			metricsStore.Insert(collector.CollectedMetric{
+				Type: autoscalingv2.ObjectMetricSourceType,
+				Custom: custom_metrics.MetricValue{
+					Metric: newMetricIdentifier(fmt.Sprintf("metric-%d", i%7), metav1.LabelSelector{}),
+					Value:  *resource.NewQuantity(int64(i), ""),
+					DescribedObject: custom_metrics.ObjectReference{
+						Name:       fmt.Sprintf("object-%d", i),
+						Namespace:  namespace,
+						Kind:       kind.kind,
+						APIVersion: kind.apiVersion,
+					},
+				},
+			})
+		}
+		metricsStore.Insert(collector.CollectedMetric{
+			Type:      autoscalingv2.ExternalMetricSourceType,
+			Namespace: fmt.Sprintf("namespace-%d", i%3),
+			External: external_metrics.ExternalMetricValue{
+				MetricName:   fmt.Sprintf("external-%d", i%11),
+				MetricLabels: map[string]string{"queue": strconv.Itoa(i)},
+				Value:        *resource.NewQuantity(int64(i), ""),
+			},
+		})
+	}
+
+	metrics := metricsStore.ListAllMetrics()
+	require.Len(t, metrics, len(kinds)*7)
+	require.True(t, sort.SliceIsSorted(metrics, func(i, j int) bool {
+		a, b := metrics[i], metrics[j]
+		if a.GroupResource.Group != b.GroupResource.Group {
+			return a.GroupResource.Group < b.GroupResource.Group
+		}
+		if a.GroupResource.Resource != b.GroupResource.Resource {
+			return a.GroupResource.Resource < b.GroupResource.Resource
+		}
+		return a.Metric < b.Metric
+	}))
+	require.Equal(t, provider.CustomMetricInfo{GroupResource: schema.GroupResource{Resource: "nodes"}, Metric: "metric-0"}, metrics[0])
+
+	externalMetrics := metricsStore.ListAllExternalMetrics()
+	require.Len(t, externalMetrics, 11)
+
+	for i := 0; i < 20; i++ {
+		require.Equal(t, metrics, metricsStore.ListAllMetrics())
+		require.Equal(t, externalMetrics, metricsStore.ListAllExternalMetrics())
+	}
+}
+
+func FuzzParseHashLabelMap(f *testing.F) {
+	f.Add("")
+	f.Add("app=foo")
+	f.Add("app=foo,type=bar")
+	f.Add("query=a=b")
+	f.Add("hosts=a,b,type=rps")
+	f.Add(",")
+	f.Add("=")
+	f.Add("no-separator")
+	f.Add(",app=foo")
+
+	f.Fuzz(func(t *testing.T, s string) {
+		// arbitrary hashes must not panic.
+		parseHashLabelMap(labelsHash(s))
+
+		// values without commas survive a round trip.
+		if strings.Contains(s, ",") {
+			return
+		}
+		set := labels.Set{"app": "foo", "value": s}
+		require.Equal(t, set, parseHashLabelMap(hashLabelMap(set)))
+	})
+}
+
+func TestIngressGroupLookups(t *testing.T) {
+	ingressMetric := func(apiVersion string, value int64) collector.CollectedMetric {
+		return collector.CollectedMetric{
+			Type: autoscalingv2.ObjectMetricSourceType,
+			Custom: custom_metrics.MetricValue{
+				Metric: newMetricIdentifier("requests-per-second", metav1.LabelSelector{MatchLabels: map[string]string{"backend": "app"}}),
+				Value:  *resource.NewQuantity(value, ""),
+				DescribedObject: custom_metrics.ObjectReference{
+					Name:       "app",
+					Namespace:  "default",
+					Kind:       "Ingress",
+					APIVersion: apiVersion,
+				},
+			},
+		}
+	}
+	info := func(group string) provider.CustomMetricInfo {
+		return provider.CustomMetricInfo{
+			GroupResource: schema.GroupResource{Group: group, Resource: "ingresses"},
+			Namespaced:    true,
+			Metric:        "requests-per-second",
+		}
+	}
+
+	for _, tc := range []struct {
+		msg           string
+		apiVersion    string
+		storedGroup   string
+		requestedInfo provider.CustomMetricInfo
+	}{
+		{
+			msg:           "networking.k8s.io metric requested for networking.k8s.io",
+			apiVersion:    "networking.k8s.io/v1",
+			storedGroup:   "networking.k8s.io",
+			requestedInfo: info("networking.k8s.io"),
+		},
+		{
+			msg:           "extensions metric requested for networking.k8s.io",
+			apiVersion:    "extensions/v1beta1",
+			storedGroup:   "extensions",
+			requestedInfo: info("networking.k8s.io"),
+		},
+		{
+			msg:           "networking.k8s.io metric requested for extensions",
+			apiVersion:    "networking.k8s.io/v1",
+			storedGroup:   "networking.k8s.io",
+			requestedInfo: info("extensions"),
+		},
+		{
+			msg:           "empty API version defaults to networking.k8s.io",
+			apiVersion:    "",
+			storedGroup:   "networking.k8s.io",
+			requestedInfo: info("networking.k8s.io"),
+		},
+		{
+			msg:           "core API version defaults to networking.k8s.io",
+			apiVersion:    "v1",
+			storedGroup:   "networking.k8s.io",
+			requestedInfo: info("networking.k8s.io"),
+		},
+		{
+			msg:           "unparsable API version defaults to networking.k8s.io",
+			apiVersion:    "networking.k8s.io/v1/ingresses",
+			storedGroup:   "networking.k8s.io",
+			requestedInfo: info("extensions"),
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			metricsStore := NewMetricStore(func() time.Time {
+				return time.Now().UTC().Add(15 * time.Minute)
+			})
+			metricsStore.Insert(ingressMetric(tc.apiVersion, 10))
+
+			require.Equal(t, []provider.CustomMetricInfo{info(tc.storedGroup)}, metricsStore.ListAllMetrics())
+
+			value := metricsStore.GetMetricsByName(context.Background(), types.NamespacedName{Name: "app", Namespace: "default"}, tc.requestedInfo, labels.Everything())
+			require.NotNil(t, value)
+			require.Equal(t, int64(10), value.Value.Value())
+
+			values := metricsStore.GetMetricsBySelector(context.Background(), "default", labels.SelectorFromSet(labels.Set{"backend": "app"}), tc.requestedInfo)
+			require.Len(t, values.Items, 1)
+			require.Equal(t, int64(10), values.Items[0].Value.Value())
+		})
+	}
+
+	t.Run("the requested group is preferred", func(t *testing.T) {
+		metricsStore := NewMetricStore(func() time.Time {
+			return time.Now().UTC().Add(15 * time.Minute)
+		})
+		metricsStore.Insert(ingressMetric("networking.k8s.io/v1", 10))
+		metricsStore.Insert(ingressMetric("extensions/v1beta1", 20))
+
+		for group, expected := range map[string]int64{"networking.k8s.io": 10, "extensions": 20} {
+			value := metricsStore.GetMetricsByName(context.Background(), types.NamespacedName{Name: "app", Namespace: "default"}, info(group), labels.Everything())
+			require.NotNil(t, value)
+			require.Equal(t, expected, value.Value.Value())
+
+			values := metricsStore.GetMetricsBySelector(context.Background(), "default", labels.Everything(), info(group))
+			require.Len(t, values.Items, 1)
+			require.Equal(t, expected, values.Items[0].Value.Value())
+		}
+	})
+
+	t.Run("other resources have no sibling group", func(t *testing.T) {
+		metricsStore := NewMetricStore(func() time.Time {
+			return time.Now().UTC().Add(15 * time.Minute)
+		})
+		metricsStore.Insert(ingressMetric("networking.k8s.io/v1", 10))
+
+		requested := info("networking.k8s.io")
+		requested.GroupResource.Resource = "routegroups"
+		require.Nil(t, metricsStore.GetMetricsByName(context.Background(), types.NamespacedName{Name: "app", Namespace: "default"}, requested, labels.Everything()))
+		require.Empty(t, metricsStore.GetMetricsBySelector(context.Background(), "default", labels.Everything(), requested).Items)
+	})
+}
+
+func TestDeprecatedIngressGroupLogRateLimited(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	now := time.Now()
+	metricsStore := NewMetricStore(func() time.Time {
+		return now.Add(15 * time.Minute)
+	})
+	metricsStore.now = func() time.Time { return now }
+
+	metric := collector.CollectedMetric{
+		Type: autoscalingv2.ObjectMetricSourceType,
+		Custom: custom_metrics.MetricValue{
+			Metric: newMetricIdentifier("requests-per-second", metav1.LabelSelector{}),
+			Value:  *resource.NewQuantity(1, ""),
+			DescribedObject: custom_metrics.ObjectReference{
+				Name:       "app",
+				Namespace:  "default",
+				Kind:       "Ingress",
+				APIVersion: "extensions/v1beta1",
+			},
+		},
+	}
+
+	for i := 0; i < 3; i++ {
+		metricsStore.Insert(metric)
+	}
+	require.Len(t, hook.AllEntries(), 1)
+	require.Contains(t, hook.LastEntry().Message, "deprecated API version 'extensions/v1beta1'")
+
+	metricsStore.now = func() time.Time { return now.Add(rejectedInsertLogInterval) }
+	metricsStore.Insert(metric)
+	require.Len(t, hook.AllEntries(), 2)
+}
@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/annotations"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+// ReasonIntervalExceedsMetricsTTL is the reason of the event recorded when
+// the collection interval of a metric exceeds half the metrics TTL, so a
+// single failed collection expires the metric before the next one.
+const ReasonIntervalExceedsMetricsTTL = "IntervalExceedsMetricsTTL"
+
+// ValidateMetricsTTL returns an error if expired metrics aren't removed
+// before new ones expire, i.e. if the garbage collection interval isn't
+// shorter than the metrics TTL.
+func ValidateMetricsTTL(metricsTTL, gcInterval time.Duration) error {
+	if gcInterval <= 0 {
+		return fmt.Errorf("--garbage-collector-interval must be positive, got %s", gcInterval)
+	}
+	if gcInterval >= metricsTTL {
+		return fmt.Errorf("--garbage-collector-interval (%s) must be shorter than --metrics-ttl (%s)", gcInterval, metricsTTL)
+	}
+	return nil
+}
+
+// intervalExceedsTTL returns true if a single failed collection at the
+// interval lets the metric expire before the next collection.
+func intervalExceedsTTL(interval, metricsTTL time.Duration) bool {
+	return interval > metricsTTL/2
+}
+
+// checkIntervalTTL logs a warning and records an event on the HPA if the
+// collection interval of the metric exceeds half its TTL, which is the
+// metrics TTL unless the metric config overrides it. The event is only
+// recorded once per metric and interval.
+func (p *HPAProvider) checkIntervalTTL(hpa *autoscalingv2.HorizontalPodAutoscaler, config *collector.MetricConfig, interval time.Duration) {
+	ref := resourceReference{Name: hpa.Name, Namespace: hpa.Namespace}
+	typeName := config.MetricTypeName
+
+	ttl, option := p.metricsTTL, "--metrics-ttl"
+	if config.TTL > 0 {
+		ttl, option = config.TTL, annotations.TTLConfigKey
+	}
+
+	if !intervalExceedsTTL(interval, ttl) {
+		delete(p.intervalWarnings[ref], typeName)
+		return
+	}
+
+	if warned, ok := p.intervalWarnings[ref][typeName]; ok && warned == interval {
+		return
+	}
+	if p.intervalWarnings[ref] == nil {
+		p.intervalWarnings[ref] = map[collector.MetricTypeName]time.Duration{}
+	}
+	p.intervalWarnings[ref][typeName] = interval
+
+	p.logger.Warnf("Collection interval %s of metric %s of HPA %s exceeds half the metrics TTL %s, a single failed collection expires the metric", interval, typeName.Metric.Name, ref, ttl)
+	p.recorder.Eventf(hpa, apiv1.EventTypeWarning, ReasonIntervalExceedsMetricsTTL, "Collection interval %s of metric %s exceeds half the metrics TTL %s, a single failed collection expires the metric. Use an interval of at most %s or a longer %s", interval, typeName.Metric.Name, ttl, ttl/2, option)
+}
+
+// forgetIntervalWarnings forgets the interval warnings of a removed HPA, so
+// they are recorded again if the HPA is recreated.
+func (p *HPAProvider) forgetIntervalWarnings(ref resourceReference) {
+	delete(p.intervalWarnings, ref)
+}
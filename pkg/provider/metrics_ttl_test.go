@@ -0,0 +1,134 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscaling "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateMetricsTTL(t *testing.T) {
+	for _, tc := range []struct {
+		msg        string
+		metricsTTL time.Duration
+		gcInterval time.Duration
+		valid      bool
+	}{
+		{msg: "defaults", metricsTTL: 15 * time.Minute, gcInterval: 10 * time.Minute, valid: true},
+		{msg: "gc interval equal to ttl", metricsTTL: 10 * time.Minute, gcInterval: 10 * time.Minute},
+		{msg: "gc interval longer than ttl", metricsTTL: 5 * time.Minute, gcInterval: 10 * time.Minute},
+		{msg: "zero gc interval", metricsTTL: 15 * time.Minute},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			err := ValidateMetricsTTL(tc.metricsTTL, tc.gcInterval)
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestUpdateHPAsIntervalExceedsMetricsTTL(t *testing.T) {
+	const intervalAnnotation = "metric-config.pods.requests-per-second.json-path/interval"
+	value := resource.MustParse("1k")
+
+	hpa := &autoscaling.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "hpa1",
+			Namespace: "default",
+			Annotations: map[string]string{
+				intervalAnnotation: "5m",
+			},
+		},
+		Spec: autoscaling.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscaling.CrossVersionObjectReference{
+				Kind:       "Deployment",
+				Name:       "app",
+				APIVersion: "apps/v1",
+			},
+			MaxReplicas: 10,
+			Metrics: []autoscaling.MetricSpec{
+				{
+					Type: autoscaling.PodsMetricSourceType,
+					Pods: &autoscaling.PodsMetricSource{
+						Metric: autoscaling.MetricIdentifier{
+							Name: "requests-per-second",
+						},
+						Target: autoscaling.MetricTarget{
+							Type:         autoscaling.AverageValueMetricType,
+							AverageValue: &value,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	fakeClient := fake.NewSimpleClientset()
+	hpas := fakeClient.AutoscalingV2().HorizontalPodAutoscalers("default")
+
+	var err error
+	hpa, err = hpas.Create(context.TODO(), hpa, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	collectorFactory := collector.NewCollectorFactory()
+	err = collectorFactory.RegisterPodsCollector("", mockCollectorPlugin{})
+	require.NoError(t, err)
+
+	eventRecorder := &mockEventRecorder{}
+	provider := NewHPAProvider(fakeClient, 1*time.Second, 1*time.Second, collectorFactory, false, 10*time.Minute, 1*time.Minute)
+	provider.recorder = eventRecorder
+	provider.collectorScheduler = NewCollectorScheduler(context.Background(), provider.metricSink)
+
+	warnings := func() int {
+		n := 0
+		for _, event := range eventRecorder.Events {
+			if event.Reason == ReasonIntervalExceedsMetricsTTL {
+				n++
+			}
+		}
+		return n
+	}
+
+	update := func(modify func(hpa *autoscaling.HorizontalPodAutoscaler)) {
+		modify(hpa)
+		hpa, err = hpas.Update(context.TODO(), hpa, metav1.UpdateOptions{})
+		require.NoError(t, err)
+		require.NoError(t, provider.updateHPAs())
+	}
+	setInterval := func(interval string) {
+		update(func(hpa *autoscaling.HorizontalPodAutoscaler) {
+			hpa.Annotations[intervalAnnotation] = interval
+		})
+	}
+
+	// an interval of exactly half the TTL is fine.
+	require.NoError(t, provider.updateHPAs())
+	require.Equal(t, 0, warnings())
+
+	setInterval("5m1s")
+	require.Equal(t, 1, warnings())
+
+	// the warning is recorded once per interval.
+	require.NoError(t, provider.updateHPAs())
+	update(func(hpa *autoscaling.HorizontalPodAutoscaler) {
+		hpa.Annotations["metric-config.pods.requests-per-second.json-path/json-key"] = "$.http_server.rps"
+	})
+	require.Equal(t, 1, warnings())
+
+	setInterval("6m")
+	require.Equal(t, 2, warnings())
+
+	// fixing the interval resets the warning.
+	setInterval("1m")
+	setInterval("6m")
+	require.Equal(t, 3, warnings())
+}
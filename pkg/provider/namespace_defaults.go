@@ -0,0 +1,73 @@
+package provider
+
+import (
+	"context"
+	"maps"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/client-go/informers"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/annotations"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+)
+
+// namespaceDefaults provides the default metric configs defined by the
+// annotations of the namespaces.
+type namespaceDefaults struct {
+	informers informers.SharedInformerFactory
+	lister    corev1listers.NamespaceLister
+	// applied are the defaults the collectors of each HPA were created
+	// with. The collectors are recreated if the defaults change.
+	applied map[resourceReference]map[string]string
+}
+
+// EnableNamespaceDefaults enables the default metric configs defined by
+// the metric-config-default.* annotations of the namespace of an HPA. The
+// namespaces are watched with an informer of the factory, which is started
+// by Run.
+func (p *HPAProvider) EnableNamespaceDefaults(factory informers.SharedInformerFactory) {
+	p.namespaceDefaults = &namespaceDefaults{
+		informers: factory,
+		lister:    factory.Core().V1().Namespaces().Lister(),
+		applied:   map[resourceReference]map[string]string{},
+	}
+}
+
+// start starts the namespace informer and waits for its cache to be synced.
+func (d *namespaceDefaults) start(ctx context.Context) bool {
+	d.informers.Start(ctx.Done())
+	informer := d.informers.Core().V1().Namespaces().Informer()
+	return cache.WaitForCacheSync(ctx.Done(), informer.HasSynced)
+}
+
+// get returns the default metric config annotations of the namespace. It
+// returns nil if the defaults are disabled or the namespace isn't known.
+func (d *namespaceDefaults) get(namespace string) map[string]string {
+	if d == nil {
+		return nil
+	}
+
+	ns, err := d.lister.Get(namespace)
+	if err != nil {
+		return nil
+	}
+	return annotations.NamespaceDefaults(ns.Annotations)
+}
+
+// changed returns true if the defaults differ from the ones the collectors
+// of the HPA were created with.
+func (d *namespaceDefaults) changed(ref resourceReference, defaults map[string]string) bool {
+	if d == nil {
+		return false
+	}
+	applied, ok := d.applied[ref]
+	return ok && !maps.Equal(applied, defaults)
+}
+
+// parseHPAMetrics parses the metric configs of the HPA merged with the
+// defaults of its namespace.
+func (p *HPAProvider) parseHPAMetrics(hpa *autoscalingv2.HorizontalPodAutoscaler) ([]*collector.MetricConfig, []string, error) {
+	return collector.ParseHPAMetricsWithDefaults(hpa, p.namespaceDefaults.get(hpa.Namespace))
+}
@@ -0,0 +1,133 @@
+package provider
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscaling "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestUpdateHPAsNamespaceDefaults(t *testing.T) {
+	value := resource.MustParse("1k")
+
+	hpa := &autoscaling.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "hpa1",
+			Namespace: "default",
+			Annotations: map[string]string{
+				"metric-config.pods.requests-per-second.json-path/json-key": "$.http_server.rps",
+			},
+		},
+		Spec: autoscaling.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscaling.CrossVersionObjectReference{
+				Kind:       "Deployment",
+				Name:       "app",
+				APIVersion: "apps/v1",
+			},
+			MinReplicas: &[]int32{1}[0],
+			MaxReplicas: 10,
+			Metrics: []autoscaling.MetricSpec{
+				{
+					Type: autoscaling.PodsMetricSourceType,
+					Pods: &autoscaling.PodsMetricSource{
+						Metric: autoscaling.MetricIdentifier{
+							Name: "requests-per-second",
+						},
+						Target: autoscaling.MetricTarget{
+							Type:         autoscaling.AverageValueMetricType,
+							AverageValue: &value,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "default",
+			Annotations: map[string]string{
+				"metric-config-default.pods.json-path/interval": "1h",
+			},
+		},
+	}
+
+	fakeClient := fake.NewSimpleClientset()
+
+	var err error
+	_, err = fakeClient.AutoscalingV2().HorizontalPodAutoscalers("default").Create(context.TODO(), hpa, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	calls := &atomic.Int64{}
+	collectorFactory := collector.NewCollectorFactory()
+	err = collectorFactory.RegisterPodsCollector("", countingCollectorPlugin{calls: calls})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	provider := NewHPAProvider(fakeClient, 1*time.Second, 1*time.Second, collectorFactory, false, 1*time.Second, 1*time.Second)
+	provider.collectorScheduler = NewCollectorScheduler(ctx, provider.metricSink)
+	go func() {
+		for {
+			select {
+			case <-provider.metricSink:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	factory := informers.NewSharedInformerFactory(fakeClient, 0)
+	provider.EnableNamespaceDefaults(factory)
+	store := factory.Core().V1().Namespaces().Informer().GetStore()
+	require.NoError(t, store.Add(namespace))
+
+	err = provider.updateHPAs()
+	require.NoError(t, err)
+
+	ref := resourceReference{Name: "hpa1", Namespace: "default"}
+	typeName := collector.MetricTypeName{
+		Type:   autoscaling.PodsMetricSourceType,
+		Metric: autoscaling.MetricIdentifier{Name: "requests-per-second"},
+	}
+	scheduled := provider.collectorScheduler.table[ref][typeName]
+	require.NotNil(t, scheduled)
+	require.Equal(t, time.Hour, time.Duration(scheduled.interval.Load()))
+
+	// an unchanged namespace doesn't recreate the collectors.
+	err = provider.updateHPAs()
+	require.NoError(t, err)
+	require.Same(t, scheduled, provider.collectorScheduler.table[ref][typeName])
+
+	// changed defaults recreate the collectors of the HPAs in the namespace.
+	namespace = namespace.DeepCopy()
+	namespace.Annotations["metric-config-default.pods.json-path/interval"] = "2h"
+	require.NoError(t, store.Update(namespace))
+
+	err = provider.updateHPAs()
+	require.NoError(t, err)
+	updated := provider.collectorScheduler.table[ref][typeName]
+	require.NotSame(t, scheduled, updated)
+	require.Equal(t, 2*time.Hour, time.Duration(updated.interval.Load()))
+
+	// removing the defaults recreates the collectors with the default
+	// interval.
+	namespace = namespace.DeepCopy()
+	namespace.Annotations = nil
+	require.NoError(t, store.Update(namespace))
+
+	err = provider.updateHPAs()
+	require.NoError(t, err)
+	require.NotSame(t, updated, provider.collectorScheduler.table[ref][typeName])
+	require.Equal(t, time.Second, time.Duration(provider.collectorScheduler.table[ref][typeName].interval.Load()))
+}
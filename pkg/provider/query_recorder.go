@@ -0,0 +1,126 @@
+package provider
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// RecordedQuery is an effective query which was used to collect a metric
+// value.
+type RecordedQuery struct {
+	Query string `json:"query"`
+	Value string `json:"value"`
+	// RawValue is the collected value if the served value is smoothed.
+	RawValue  string    `json:"rawValue,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// queryRing is a fixed size ring buffer of recorded queries.
+type queryRing struct {
+	queries []RecordedQuery
+	next    int
+	full    bool
+}
+
+func (r *queryRing) add(query RecordedQuery) {
+	r.queries[r.next] = query
+	r.next = (r.next + 1) % len(r.queries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// list returns the recorded queries from oldest to newest.
+func (r *queryRing) list() []RecordedQuery {
+	if !r.full {
+		return append([]RecordedQuery(nil), r.queries[:r.next]...)
+	}
+	return append(append([]RecordedQuery(nil), r.queries[r.next:]...), r.queries[:r.next]...)
+}
+
+// queryRecorder records the last N effective queries per metric.
+type queryRecorder struct {
+	size    int
+	queries map[string]*queryRing
+	sync.RWMutex
+}
+
+// newQueryRecorder initializes a new queryRecorder keeping the last size
+// queries per metric.
+func newQueryRecorder(size int) *queryRecorder {
+	return &queryRecorder{
+		size:    size,
+		queries: map[string]*queryRing{},
+	}
+}
+
+// Record records the query of a collected metric. Metrics not collected by
+// a query are ignored.
+func (r *queryRecorder) Record(value collector.CollectedMetric) {
+	if value.Query == "" {
+		return
+	}
+
+	query := RecordedQuery{Query: value.Query}
+	if value.Raw != nil {
+		query.RawValue = value.Raw.String()
+	}
+	switch value.Type {
+	case autoscalingv2.ObjectMetricSourceType, autoscalingv2.PodsMetricSourceType:
+		query.Value = value.Custom.Value.String()
+		query.Timestamp = value.Custom.Timestamp.Time
+	case autoscalingv2.ExternalMetricSourceType:
+		query.Value = value.External.Value.String()
+		query.Timestamp = value.External.Timestamp.Time
+	}
+
+	key := recordedMetricKey(value)
+
+	r.Lock()
+	defer r.Unlock()
+
+	ring, ok := r.queries[key]
+	if !ok {
+		ring = &queryRing{queries: make([]RecordedQuery, r.size)}
+		r.queries[key] = ring
+	}
+	ring.add(query)
+}
+
+// Queries returns the recorded queries per metric from oldest to newest.
+func (r *queryRecorder) Queries() map[string][]RecordedQuery {
+	r.RLock()
+	defer r.RUnlock()
+
+	queries := make(map[string][]RecordedQuery, len(r.queries))
+	for key, ring := range r.queries {
+		queries[key] = ring.list()
+	}
+	return queries
+}
+
+// recordedMetricKey returns a human readable key identifying the metric of a
+// collected value.
+func recordedMetricKey(value collector.CollectedMetric) string {
+	switch value.Type {
+	case autoscalingv2.ObjectMetricSourceType, autoscalingv2.PodsMetricSourceType:
+		return fmt.Sprintf("%s/%s/%s/%s",
+			value.Custom.DescribedObject.Kind,
+			value.Custom.DescribedObject.Namespace,
+			value.Custom.DescribedObject.Name,
+			value.Custom.Metric.Name,
+		)
+	default:
+		return fmt.Sprintf("%s/%s/%s{%s}",
+			value.Type,
+			value.Namespace,
+			value.External.MetricName,
+			labels.Set(value.External.MetricLabels).String(),
+		)
+	}
+}
@@ -0,0 +1,103 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+	"sigs.k8s.io/custom-metrics-apiserver/pkg/provider"
+)
+
+func externalMetricWithQuery(value int64, query string) collector.CollectedMetric {
+	return collector.CollectedMetric{
+		Type:      autoscalingv2.ExternalMetricSourceType,
+		Namespace: "default",
+		External: external_metrics.ExternalMetricValue{
+			MetricName:   "rps",
+			MetricLabels: map[string]string{"type": "prometheus"},
+			Timestamp:    metav1.Time{Time: time.Unix(value, 0).UTC()},
+			Value:        *resource.NewQuantity(value, resource.DecimalSI),
+		},
+		Query: query,
+	}
+}
+
+func TestQueryRecorder(t *testing.T) {
+	recorder := newQueryRecorder(3)
+
+	recorder.Record(externalMetricWithQuery(1, "query-1"))
+	recorder.Record(externalMetricWithQuery(2, "query-2"))
+	require.Equal(t, map[string][]RecordedQuery{
+		"External/default/rps{type=prometheus}": {
+			{Query: "query-1", Value: "1", Timestamp: time.Unix(1, 0).UTC()},
+			{Query: "query-2", Value: "2", Timestamp: time.Unix(2, 0).UTC()},
+		},
+	}, recorder.Queries())
+
+	// the oldest queries are overwritten once the buffer is full.
+	for i := int64(3); i <= 5; i++ {
+		recorder.Record(externalMetricWithQuery(i, fmt.Sprintf("query-%d", i)))
+	}
+	require.Equal(t, map[string][]RecordedQuery{
+		"External/default/rps{type=prometheus}": {
+			{Query: "query-3", Value: "3", Timestamp: time.Unix(3, 0).UTC()},
+			{Query: "query-4", Value: "4", Timestamp: time.Unix(4, 0).UTC()},
+			{Query: "query-5", Value: "5", Timestamp: time.Unix(5, 0).UTC()},
+		},
+	}, recorder.Queries())
+
+	// metrics without query are not recorded.
+	recorder = newQueryRecorder(3)
+	recorder.Record(externalMetricWithQuery(1, ""))
+	require.Empty(t, recorder.Queries())
+}
+
+func TestCollectMetricsRecordsQueries(t *testing.T) {
+	collectorFactory := collector.NewCollectorFactory()
+	collectorFactory.RegisterExternalCollector([]string{"prometheus"}, mockCollectorPlugin{})
+	hpaProvider := NewHPAProvider(fake.NewSimpleClientset(), 1*time.Second, 1*time.Second, collectorFactory, false, 1*time.Minute, 1*time.Minute)
+	hpaProvider.EnableQueryRecording(2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hpaProvider.collectMetrics(ctx)
+
+	for i := int64(1); i <= 3; i++ {
+		hpaProvider.metricSink <- metricCollection{
+			Values: []collector.CollectedMetric{externalMetricWithQuery(i, fmt.Sprintf("query-%d", i))},
+		}
+	}
+
+	require.Eventually(t, func() bool {
+		queries := hpaProvider.queryRecorder.Queries()["External/default/rps{type=prometheus}"]
+		return len(queries) == 2 && queries[1].Query == "query-3"
+	}, time.Second, 5*time.Millisecond)
+
+	// the query must not be part of the served metric.
+	metrics, err := hpaProvider.GetExternalMetric(context.Background(), "default", labels.Everything(), provider.ExternalMetricInfo{Metric: "rps"})
+	require.NoError(t, err)
+	require.Len(t, metrics.Items, 1)
+	require.Equal(t, map[string]string{"type": "prometheus"}, metrics.Items[0].MetricLabels)
+
+	// the queries are exposed via the debug endpoint.
+	rec := httptest.NewRecorder()
+	hpaProvider.DebugCollectorsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/collectors", nil))
+	var info collectorsDebugInfo
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&info))
+	require.Equal(t, []string{"query-2", "query-3"}, []string{
+		info.Queries["External/default/rps{type=prometheus}"][0].Query,
+		info.Queries["External/default/rps{type=prometheus}"][1].Query,
+	})
+	require.Equal(t, []string{"prometheus"}, info.ExternalTypes)
+}
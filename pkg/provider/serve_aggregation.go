@@ -0,0 +1,190 @@
+package provider
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+)
+
+// ServeAggregationConfigKey is the metric config key defining how the
+// series of an external metric are aggregated before they are served.
+const ServeAggregationConfigKey = "serve-aggregation"
+
+// serveAggregation defines how multiple series of an external metric are
+// aggregated into a single item when served.
+type serveAggregation string
+
+const (
+	// serveAggregationAll serves all series. The HPA averages them.
+	serveAggregationAll serveAggregation = "all"
+	serveAggregationMax serveAggregation = "max"
+	serveAggregationSum serveAggregation = "sum"
+	serveAggregationAvg serveAggregation = "avg"
+)
+
+func parseServeAggregation(value string) (serveAggregation, error) {
+	switch aggregation := serveAggregation(value); aggregation {
+	case serveAggregationAll, serveAggregationMax, serveAggregationSum, serveAggregationAvg:
+		return aggregation, nil
+	default:
+		return "", fmt.Errorf("unknown %s '%s', must be one of all, max, sum, avg", ServeAggregationConfigKey, value)
+	}
+}
+
+// externalMetricIdentity identifies an external metric as requested by an
+// HPA.
+type externalMetricIdentity struct {
+	namespace objectNamespace
+	metric    metricName
+	selector  string
+}
+
+// serveAggregations keeps track of the serve aggregations configured by
+// the HPAs.
+type serveAggregations struct {
+	byHPA map[resourceReference]map[externalMetricIdentity]serveAggregation
+	sync.RWMutex
+}
+
+func newServeAggregations() *serveAggregations {
+	return &serveAggregations{
+		byHPA: map[resourceReference]map[externalMetricIdentity]serveAggregation{},
+	}
+}
+
+// Set replaces the serve aggregations of the HPA based on its metric
+// configs. Invalid aggregations are skipped and returned as errors.
+func (s *serveAggregations) Set(hpa *autoscalingv2.HorizontalPodAutoscaler, configs []*collector.MetricConfig) []error {
+	var errs []error
+	aggregations := map[externalMetricIdentity]serveAggregation{}
+	for _, config := range configs {
+		value, ok := config.Config[ServeAggregationConfigKey]
+		if !ok || config.Type != autoscalingv2.ExternalMetricSourceType {
+			continue
+		}
+
+		aggregation, err := parseServeAggregation(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("metric %s: %w", config.Metric.Name, err))
+			continue
+		}
+
+		selector := labels.Everything()
+		if config.Metric.Selector != nil {
+			selector, err = metav1.LabelSelectorAsSelector(config.Metric.Selector)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("metric %s: invalid selector: %w", config.Metric.Name, err))
+				continue
+			}
+		}
+
+		identity := externalMetricIdentity{
+			namespace: objectNamespace(hpa.Namespace),
+			metric:    metricName(config.Metric.Name),
+			selector:  selector.String(),
+		}
+		aggregations[identity] = aggregation
+	}
+
+	resourceRef := resourceReference{Name: hpa.Name, Namespace: hpa.Namespace}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if len(aggregations) == 0 {
+		delete(s.byHPA, resourceRef)
+	} else {
+		s.byHPA[resourceRef] = aggregations
+	}
+
+	return errs
+}
+
+// Remove removes the serve aggregations of the HPA.
+func (s *serveAggregations) Remove(resourceRef resourceReference) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.byHPA, resourceRef)
+}
+
+// Get returns the serve aggregation for an external metric. If HPAs
+// disagree on the aggregation all series are served.
+func (s *serveAggregations) Get(identity externalMetricIdentity) serveAggregation {
+	s.RLock()
+	defer s.RUnlock()
+
+	result := serveAggregationAll
+	found := false
+	for _, aggregations := range s.byHPA {
+		aggregation, ok := aggregations[identity]
+		if !ok {
+			continue
+		}
+
+		if found && aggregation != result {
+			return serveAggregationAll
+		}
+		result = aggregation
+		found = true
+	}
+
+	return result
+}
+
+// aggregateExternalMetrics aggregates the series into a single item. The
+// item only carries the labels shared by all series and the timestamp of
+// the most recent one.
+func aggregateExternalMetrics(items []external_metrics.ExternalMetricValue, aggregation serveAggregation) []external_metrics.ExternalMetricValue {
+	if aggregation == serveAggregationAll || len(items) == 0 {
+		return items
+	}
+
+	aggregated := external_metrics.ExternalMetricValue{
+		MetricName:   items[0].MetricName,
+		MetricLabels: map[string]string{},
+		Timestamp:    items[0].Timestamp,
+	}
+
+	for k, v := range items[0].MetricLabels {
+		aggregated.MetricLabels[k] = v
+	}
+
+	var sum, maxValue int64
+	for i, item := range items {
+		value := item.Value.MilliValue()
+		sum += value
+		if i == 0 || value > maxValue {
+			maxValue = value
+		}
+
+		if item.Timestamp.After(aggregated.Timestamp.Time) {
+			aggregated.Timestamp = item.Timestamp
+		}
+
+		for k, v := range aggregated.MetricLabels {
+			if item.MetricLabels[k] != v {
+				delete(aggregated.MetricLabels, k)
+			}
+		}
+	}
+
+	var value int64
+	switch aggregation {
+	case serveAggregationMax:
+		value = maxValue
+	case serveAggregationSum:
+		value = sum
+	case serveAggregationAvg:
+		value = sum / int64(len(items))
+	}
+	aggregated.Value = *resource.NewMilliQuantity(value, resource.DecimalSI)
+
+	return []external_metrics.ExternalMetricValue{aggregated}
+}
@@ -0,0 +1,181 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscaling "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+	"sigs.k8s.io/custom-metrics-apiserver/pkg/provider"
+)
+
+func newServeAggregationProvider(t *testing.T, aggregation string) *HPAProvider {
+	value := resource.MustParse("1")
+
+	hpa := &autoscaling.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "hpa1",
+			Namespace:   "default",
+			Annotations: map[string]string{},
+		},
+		Spec: autoscaling.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscaling.CrossVersionObjectReference{
+				Kind:       "Deployment",
+				Name:       "app",
+				APIVersion: "apps/v1",
+			},
+			MaxReplicas: 10,
+			Metrics: []autoscaling.MetricSpec{
+				{
+					Type: autoscaling.ExternalMetricSourceType,
+					External: &autoscaling.ExternalMetricSource{
+						Metric: autoscaling.MetricIdentifier{
+							Name: "queue-length",
+						},
+						Target: autoscaling.MetricTarget{
+							Type:         autoscaling.AverageValueMetricType,
+							AverageValue: &value,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if aggregation != "" {
+		hpa.Annotations["metric-config.external.queue-length.fake/"+ServeAggregationConfigKey] = aggregation
+	}
+
+	fakeClient := fake.NewSimpleClientset()
+	_, err := fakeClient.AutoscalingV2().HorizontalPodAutoscalers("default").Create(context.TODO(), hpa, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	collectorFactory := collector.NewCollectorFactory()
+	collectorFactory.RegisterExternalCollector([]string{"queue-length"}, mockCollectorPlugin{})
+
+	p := NewHPAProvider(fakeClient, 1*time.Second, 1*time.Second, collectorFactory, false, 1*time.Hour, 1*time.Second)
+	p.recorder = &mockEventRecorder{}
+	p.collectorScheduler = NewCollectorScheduler(context.Background(), p.metricSink)
+
+	err = p.updateHPAs()
+	require.NoError(t, err)
+
+	return p
+}
+
+func insertQueueLength(p *HPAProvider, partition string, value int64, timestamp time.Time) {
+	p.metricStore.Insert(collector.CollectedMetric{
+		Type:      autoscaling.ExternalMetricSourceType,
+		Namespace: "default",
+		External: external_metrics.ExternalMetricValue{
+			MetricName:   "queue-length",
+			MetricLabels: map[string]string{"topic": "orders", "partition": partition},
+			Timestamp:    metav1.Time{Time: timestamp},
+			Value:        *resource.NewQuantity(value, resource.DecimalSI),
+		},
+	})
+}
+
+func TestGetExternalMetricServeAggregation(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	for _, tc := range []struct {
+		msg           string
+		aggregation   string
+		expectedItems int
+		expectedValue int64
+	}{
+		{
+			msg:           "all series are served by default",
+			expectedItems: 3,
+		},
+		{
+			msg:           "all series are served",
+			aggregation:   "all",
+			expectedItems: 3,
+		},
+		{
+			msg:           "max of the series is served",
+			aggregation:   "max",
+			expectedItems: 1,
+			expectedValue: 6,
+		},
+		{
+			msg:           "sum of the series is served",
+			aggregation:   "sum",
+			expectedItems: 1,
+			expectedValue: 9,
+		},
+		{
+			msg:           "average of the series is served",
+			aggregation:   "avg",
+			expectedItems: 1,
+			expectedValue: 3,
+		},
+		{
+			msg:           "invalid aggregations serve all series",
+			aggregation:   "median",
+			expectedItems: 3,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			p := newServeAggregationProvider(t, tc.aggregation)
+			insertQueueLength(p, "0", 1, now.Add(-time.Minute))
+			insertQueueLength(p, "1", 2, now)
+			insertQueueLength(p, "2", 6, now.Add(-2*time.Minute))
+
+			metrics, err := p.GetExternalMetric(context.Background(), "default", labels.Everything(), provider.ExternalMetricInfo{Metric: "queue-length"})
+			require.NoError(t, err)
+			require.Len(t, metrics.Items, tc.expectedItems)
+
+			if tc.expectedItems != 1 {
+				return
+			}
+
+			item := metrics.Items[0]
+			require.Equal(t, "queue-length", item.MetricName)
+			require.Equal(t, map[string]string{"topic": "orders"}, item.MetricLabels)
+			require.True(t, now.Equal(item.Timestamp.Time))
+			require.Equal(t, tc.expectedValue*1000, item.Value.MilliValue())
+		})
+	}
+}
+
+func TestGetExternalMetricServeAggregationRecomputed(t *testing.T) {
+	p := newServeAggregationProvider(t, "max")
+	insertQueueLength(p, "0", 1, time.Now())
+
+	info := provider.ExternalMetricInfo{Metric: "queue-length"}
+	metrics, err := p.GetExternalMetric(context.Background(), "default", labels.Everything(), info)
+	require.NoError(t, err)
+	require.Len(t, metrics.Items, 1)
+	require.Equal(t, int64(1000), metrics.Items[0].Value.MilliValue())
+
+	insertQueueLength(p, "1", 5, time.Now())
+
+	metrics, err = p.GetExternalMetric(context.Background(), "default", labels.Everything(), info)
+	require.NoError(t, err)
+	require.Len(t, metrics.Items, 1)
+	require.Equal(t, int64(5000), metrics.Items[0].Value.MilliValue())
+
+	// the aggregation is removed together with the HPA. Removing the
+	// only HPA exceeds the removal threshold, so it's confirmed by a
+	// second update.
+	err = p.client.AutoscalingV2().HorizontalPodAutoscalers("default").Delete(context.TODO(), "hpa1", metav1.DeleteOptions{})
+	require.NoError(t, err)
+	err = p.updateHPAs()
+	require.NoError(t, err)
+	err = p.updateHPAs()
+	require.NoError(t, err)
+
+	metrics, err = p.GetExternalMetric(context.Background(), "default", labels.Everything(), info)
+	require.NoError(t, err)
+	require.Len(t, metrics.Items, 2)
+}
@@ -0,0 +1,94 @@
+package provider
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+)
+
+// OwnedHPAs is the number of HPAs whose metrics are collected by the shard
+// of the adapter.
+var OwnedHPAs = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "kube_metrics_adapter_sharding_owned_hpas",
+	Help: "The number of HPAs whose metrics are collected by the shard of the adapter",
+})
+
+// Sharding splits the collection of the metrics of the HPAs between
+// multiple replicas of the adapter. Each HPA is owned by exactly one shard,
+// selected by a consistent hash of its namespace and name, so changing the
+// number of shards only moves the HPAs of the added or removed shards.
+//
+// Each replica only stores and serves the metrics of the HPAs of its shard.
+// The API aggregator routes the metric requests of the HPA controller to
+// any replica behind the adapter service, so requests hitting another shard
+// fail and are only served once a retry reaches the owning replica. This
+// delays scaling decisions in proportion to the number of shards, which is
+// the trade-off for not sharing the metric store between the replicas.
+type Sharding struct {
+	total int
+	index int
+}
+
+// NewSharding initializes the shard index of total shards.
+func NewSharding(total, index int) (*Sharding, error) {
+	if total < 1 {
+		return nil, fmt.Errorf("the number of shards must be positive, got %d", total)
+	}
+	if index < 0 || index >= total {
+		return nil, fmt.Errorf("the shard index must be between 0 and %d, got %d", total-1, index)
+	}
+	return &Sharding{total: total, index: index}, nil
+}
+
+// ShardIndexFromHostname returns the shard index from the ordinal suffix of
+// the hostname of a StatefulSet pod, e.g. 2 for kube-metrics-adapter-2.
+func ShardIndexFromHostname(hostname string) (int, error) {
+	i := strings.LastIndex(hostname, "-")
+	if i < 0 {
+		return 0, fmt.Errorf("hostname '%s' has no ordinal suffix", hostname)
+	}
+
+	index, err := strconv.Atoi(hostname[i+1:])
+	if err != nil || index < 0 {
+		return 0, fmt.Errorf("hostname '%s' has no ordinal suffix", hostname)
+	}
+	return index, nil
+}
+
+// Owns returns true if the HPA of the namespace and name is owned by the
+// shard.
+func (s *Sharding) Owns(namespace, name string) bool {
+	return shardOf(namespace, name, s.total) == s.index
+}
+
+// filter returns the HPAs owned by the shard.
+func (s *Sharding) filter(hpas []autoscalingv2.HorizontalPodAutoscaler) []autoscalingv2.HorizontalPodAutoscaler {
+	owned := make([]autoscalingv2.HorizontalPodAutoscaler, 0, len(hpas)/s.total+1)
+	for _, hpa := range hpas {
+		if s.Owns(hpa.Namespace, hpa.Name) {
+			owned = append(owned, hpa)
+		}
+	}
+	return owned
+}
+
+// shardOf returns the shard of the HPA of the namespace and name by the jump
+// consistent hash of Lamping and Veach.
+func shardOf(namespace, name string, shards int) int {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(namespace + "/" + name))
+	key := h.Sum64()
+
+	var b, j int64 = -1, 0
+	for j < int64(shards) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,101 @@
+package provider
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardingPartitionsHPAs(t *testing.T) {
+	shards := make([]*Sharding, 2)
+	for i := range shards {
+		sharding, err := NewSharding(len(shards), i)
+		require.NoError(t, err)
+		shards[i] = sharding
+	}
+
+	owned := make([]int, len(shards))
+	for i := 0; i < 1000; i++ {
+		namespace, name := fmt.Sprintf("namespace-%d", i%7), fmt.Sprintf("hpa-%d", i)
+
+		owners := 0
+		for j, sharding := range shards {
+			if sharding.Owns(namespace, name) {
+				owners++
+				owned[j]++
+			}
+		}
+		require.Equal(t, 1, owners, "%s/%s must be owned by exactly one shard", namespace, name)
+	}
+
+	// the HPAs are spread evenly.
+	for _, n := range owned {
+		require.InDelta(t, 500, n, 75)
+	}
+}
+
+func TestShardingIsConsistent(t *testing.T) {
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		name := fmt.Sprintf("hpa-%d", i)
+		before, after := shardOf("default", name, 3), shardOf("default", name, 4)
+		require.Equal(t, before, shardOf("default", name, 3))
+		if before != after {
+			// HPAs only move to the added shard.
+			require.Equal(t, 3, after)
+			moved++
+		}
+	}
+	require.InDelta(t, 250, moved, 75)
+}
+
+func TestNewShardingInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		total int
+		index int
+	}{
+		{total: 0, index: 0},
+		{total: 2, index: -1},
+		{total: 2, index: 2},
+	} {
+		_, err := NewSharding(tc.total, tc.index)
+		require.Error(t, err)
+	}
+}
+
+func TestShardIndexFromHostname(t *testing.T) {
+	index, err := ShardIndexFromHostname("kube-metrics-adapter-2")
+	require.NoError(t, err)
+	require.Equal(t, 2, index)
+
+	for _, hostname := range []string{"kube-metrics-adapter", "adapter-7d9f8b-x2x9z", "adapter-", "adapter"} {
+		_, err := ShardIndexFromHostname(hostname)
+		require.Error(t, err, hostname)
+	}
+}
+
+func TestUpdateHPAsSharding(t *testing.T) {
+	names := make([]string, 20)
+	for i := range names {
+		names[i] = fmt.Sprintf("hpa%d", i)
+	}
+
+	scheduled := 0
+	for i := 0; i < 2; i++ {
+		p, _ := newListRetryProvider(t, names...)
+		sharding, err := NewSharding(2, i)
+		require.NoError(t, err)
+		p.EnableSharding(sharding)
+
+		err = p.updateHPAs()
+		require.NoError(t, err)
+		for ref := range p.hpaCache {
+			require.True(t, sharding.Owns(ref.Namespace, ref.Name))
+		}
+		require.Equal(t, float64(len(p.hpaCache)), testutil.ToFloat64(OwnedHPAs))
+		scheduled += p.collectorScheduler.count()
+	}
+	require.Equal(t, len(names), scheduled)
+}
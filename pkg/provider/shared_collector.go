@@ -0,0 +1,214 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"time"
+
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/annotations"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/metrics/pkg/apis/custom_metrics"
+)
+
+// SharedConfigKey is the metric config key sharing the collector of an
+// external metric between all HPAs of the namespace configuring the same
+// metric with the same config when set to "true".
+const SharedConfigKey = "shared"
+
+// sharedCollectorKey identifies the shared collector of an external metric
+// in a namespace.
+type sharedCollectorKey struct {
+	namespace string
+	metric    string
+}
+
+func newSharedCollectorKey(namespace string, typeName collector.MetricTypeName) sharedCollectorKey {
+	metric := string(typeName.Type) + "/" + typeName.Metric.Name
+	if typeName.Metric.Selector != nil {
+		metric += "{" + metav1.FormatLabelSelector(typeName.Metric.Selector) + "}"
+	}
+	return sharedCollectorKey{namespace: namespace, metric: metric}
+}
+
+// sharedCollector is a collector scheduled once for all subscribing HPAs.
+type sharedCollector struct {
+	scheduled *scheduledCollector
+	// configHash is the hash of the config the collector was created
+	// with. Only HPAs with the same config hash can subscribe.
+	configHash string
+	// subscribers are the subscribing HPAs in the order they subscribed.
+	subscribers []sharedSubscriber
+}
+
+// sharedSubscriber is an HPA subscribing to a shared collector. The metric
+// is the key of the collector in the collector table of the HPA.
+type sharedSubscriber struct {
+	hpa    *autoscalingv2.HorizontalPodAutoscaler
+	metric collector.MetricTypeName
+}
+
+func (s sharedSubscriber) ref() resourceReference {
+	return resourceReference{Name: s.hpa.Name, Namespace: s.hpa.Namespace}
+}
+
+// publish updates the subscribers read by the runner of the collector. The
+// caller must hold the lock of the scheduler.
+func (s *sharedCollector) publish() {
+	subscribers := make([]*autoscalingv2.HorizontalPodAutoscaler, 0, len(s.subscribers))
+	for _, subscriber := range s.subscribers {
+		subscribers = append(subscribers, subscriber.hpa)
+	}
+	s.scheduled.subscribers.Store(&subscribers)
+}
+
+// unsubscribe removes the HPA from the subscribers and returns the number
+// of remaining subscribers.
+func (s *sharedCollector) unsubscribe(resourceRef resourceReference) int {
+	for i, subscriber := range s.subscribers {
+		if subscriber.ref() == resourceRef {
+			s.subscribers = append(s.subscribers[:i], s.subscribers[i+1:]...)
+			break
+		}
+	}
+	return len(s.subscribers)
+}
+
+// sharedConfigHash returns the hash of the config of the metric if it's
+// configured to be shared by the shared config key. Configs which can't be
+// applied are reported as events on the HPA and the metric is collected
+// for the HPA alone.
+func (p *HPAProvider) sharedConfigHash(hpa *autoscalingv2.HorizontalPodAutoscaler, config *collector.MetricConfig, interval time.Duration) (string, bool) {
+	var shared bool
+	b := annotations.NewConfigBinder(config.Config, config.ConfigSources)
+	b.Bool(SharedConfigKey, &shared)
+	if err := b.Err(); err != nil {
+		p.recorder.Eventf(hpa, apiv1.EventTypeWarning, ReasonInvalidConfig, "Failed to configure %s, collecting the metric for the HPA: %v", SharedConfigKey, err)
+		return "", false
+	}
+
+	if !shared {
+		return "", false
+	}
+
+	if config.Type != autoscalingv2.ExternalMetricSourceType {
+		p.recorder.Eventf(hpa, apiv1.EventTypeWarning, ReasonInvalidConfig, "Ignoring %s of %s metric %s, only external metrics can be shared", SharedConfigKey, config.Type, config.Metric.Name)
+		return "", false
+	}
+
+	return configHash(hpa, config, interval), true
+}
+
+// sharedConfig is everything the collector of an external metric is
+// created from besides the HPA. The metric type and name are part of the
+// shared collector key and the config sources only name the annotations,
+// so they're left out.
+type sharedConfig struct {
+	CollectorType   string                         `json:"collectorType"`
+	Config          map[string]string              `json:"config"`
+	ObjectReference custom_metrics.ObjectReference `json:"objectReference"`
+	PerReplica      bool                           `json:"perReplica"`
+	Interval        time.Duration                  `json:"interval"`
+	MinPodReadyAge  time.Duration                  `json:"minPodReadyAge"`
+	TTL             time.Duration                  `json:"ttl"`
+	MetricSpec      autoscalingv2.MetricSpec       `json:"metricSpec"`
+	// ScaleTargetRef is the scale target of the HPA per replica values
+	// are divided by the replicas of. It's only set for per replica
+	// metrics, which therefore aren't shared across scale targets.
+	ScaleTargetRef *autoscalingv2.CrossVersionObjectReference `json:"scaleTargetRef,omitempty"`
+}
+
+// configHash hashes the shared config of the metric, so collectors with the
+// same hash collect identical series.
+func configHash(hpa *autoscalingv2.HorizontalPodAutoscaler, config *collector.MetricConfig, interval time.Duration) string {
+	shared := sharedConfig{
+		CollectorType:   config.CollectorType,
+		Config:          config.Config,
+		ObjectReference: config.ObjectReference,
+		PerReplica:      config.PerReplica,
+		Interval:        interval,
+		MinPodReadyAge:  config.MinPodReadyAge,
+		TTL:             config.TTL,
+		MetricSpec:      config.MetricSpec,
+	}
+	if config.PerReplica {
+		shared.ScaleTargetRef = &hpa.Spec.ScaleTargetRef
+	}
+
+	// the keys of maps are encoded sorted, so equal configs have equal
+	// encodings. Encoding the plain types can't fail.
+	data, _ := json.Marshal(shared)
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// Subscribe subscribes the HPA to the running shared collector of the
+// metric in the namespace of the HPA. It returns false if there's no such
+// collector or it was stopped, so a new one has to be added with AddShared.
+// An error is returned if the running collector was created from a
+// different config, in which case the metric has to be collected for the
+// HPA alone.
+func (t *CollectorScheduler) Subscribe(hpa *autoscalingv2.HorizontalPodAutoscaler, typeName collector.MetricTypeName, configHash string, reason string) (bool, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	key := newSharedCollectorKey(hpa.Namespace, typeName)
+	shared, ok := t.shared[key]
+	if !ok {
+		return false, nil
+	}
+
+	if shared.configHash != configHash {
+		return false, fmt.Errorf("the metric is already shared by HPA %s with a different config", shared.subscribers[0].ref())
+	}
+
+	if shared.scheduled.stopped.Load() {
+		return false, nil
+	}
+
+	resourceRef := resourceReference{Name: hpa.Name, Namespace: hpa.Namespace}
+	t.release(resourceRef, typeName)
+	t.entries(resourceRef)[typeName] = shared.scheduled
+	shared.subscribers = append(shared.subscribers, sharedSubscriber{hpa: hpa, metric: typeName})
+	shared.publish()
+	t.audit.record(auditActionAdd, reason, resourceRef, typeName, shared.scheduled.collectorType)
+	return true, nil
+}
+
+// AddShared adds a new collector shared by all HPAs of the namespace
+// subscribing to the metric with the same config hash. A stopped shared
+// collector with the same config hash is replaced for all of its
+// subscribers.
+func (t *CollectorScheduler) AddShared(hpa *autoscalingv2.HorizontalPodAutoscaler, typeName collector.MetricTypeName, configHash string, collectorType string, metricCollector collector.Collector, reason string) {
+	t.Lock()
+	defer t.Unlock()
+
+	resourceRef := resourceReference{Name: hpa.Name, Namespace: hpa.Namespace}
+	t.release(resourceRef, typeName)
+
+	key := newSharedCollectorKey(hpa.Namespace, typeName)
+	shared := &sharedCollector{configHash: configHash}
+	if stopped, ok := t.shared[key]; ok {
+		stopped.scheduled.cancel()
+		shared.subscribers = stopped.subscribers
+	}
+	shared.subscribers = append(shared.subscribers, sharedSubscriber{hpa: hpa, metric: typeName})
+	t.shared[key] = shared
+
+	ctx, scheduled := t.schedule(typeName, collectorType, metricCollector.Interval())
+	scheduled.shared = &key
+	shared.scheduled = scheduled
+	shared.publish()
+	for _, subscriber := range shared.subscribers {
+		t.entries(subscriber.ref())[subscriber.metric] = scheduled
+	}
+	ActiveCollectors.Set(float64(t.count()))
+	t.audit.record(auditActionAdd, reason, resourceRef, typeName, collectorType)
+
+	t.run(ctx, hpa, typeName, metricCollector, scheduled)
+}
@@ -0,0 +1,359 @@
+package provider
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscaling "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// contextCollector records the context of its last collection. The
+// collection runs until the context is done, so the context is only
+// canceled if the runner is stopped or the collection times out.
+type contextCollector struct {
+	ctx atomic.Pointer[context.Context]
+}
+
+func (c *contextCollector) GetMetrics(ctx context.Context) ([]collector.CollectedMetric, error) {
+	c.ctx.Store(&ctx)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (c *contextCollector) Interval() time.Duration {
+	return time.Minute
+}
+
+// stopped waits for the first collection and returns true if the context
+// of the collector is canceled.
+func (c *contextCollector) stopped(t *testing.T) bool {
+	require.Eventually(t, func() bool { return c.ctx.Load() != nil }, time.Second, time.Millisecond)
+	return (*c.ctx.Load()).Err() != nil
+}
+
+func newSharedTestHPA(name string) *autoscaling.HorizontalPodAutoscaler {
+	return &autoscaling.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+}
+
+func TestCollectorSchedulerShared(t *testing.T) {
+	typeName := collector.MetricTypeName{
+		Type:   autoscaling.ExternalMetricSourceType,
+		Metric: autoscaling.MetricIdentifier{Name: "queue-length", Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"topic": "orders"}}},
+	}
+	// every HPA parses its own selector, so the metrics of the subscribers
+	// are different keys of the collector table.
+	typeNameOf := func() collector.MetricTypeName {
+		return collector.MetricTypeName{
+			Type:   typeName.Type,
+			Metric: autoscaling.MetricIdentifier{Name: typeName.Metric.Name, Selector: typeName.Metric.Selector.DeepCopy()},
+		}
+	}
+	ref := func(hpa *autoscaling.HorizontalPodAutoscaler) resourceReference {
+		return resourceReference{Name: hpa.Name, Namespace: hpa.Namespace}
+	}
+
+	for _, tc := range []struct {
+		msg         string
+		removeOrder []int
+	}{
+		{msg: "first subscriber removed first", removeOrder: []int{0, 1}},
+		{msg: "last subscriber removed first", removeOrder: []int{1, 0}},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			metricsc := make(chan metricCollection, 10)
+			scheduler := NewCollectorScheduler(ctx, metricsc)
+			hpas := []*autoscaling.HorizontalPodAutoscaler{newSharedTestHPA("hpa1"), newSharedTestHPA("hpa2")}
+
+			subscribed, err := scheduler.Subscribe(hpas[0], typeNameOf(), "hash", auditReasonNew)
+			require.NoError(t, err)
+			require.False(t, subscribed)
+
+			c := &contextCollector{}
+			scheduler.AddShared(hpas[0], typeNameOf(), "hash", "fake", c, auditReasonNew)
+			subscribed, err = scheduler.Subscribe(hpas[1], typeNameOf(), "hash", auditReasonNew)
+			require.NoError(t, err)
+			require.True(t, subscribed)
+
+			require.Equal(t, 1, scheduler.count())
+			status := scheduler.Status()
+			require.Len(t, status, 2)
+			for _, s := range status {
+				require.True(t, s.Shared)
+			}
+			require.Equal(t, []*autoscaling.HorizontalPodAutoscaler{hpas[0], hpas[1]}, *scheduler.shared[newSharedCollectorKey("default", typeName)].scheduled.subscribers.Load())
+
+			scheduler.Remove(ref(hpas[tc.removeOrder[0]]), auditReasonHPADeleted)
+			require.False(t, c.stopped(t))
+			require.Equal(t, 1, scheduler.count())
+			remaining := hpas[tc.removeOrder[1]]
+			require.Equal(t, []*autoscaling.HorizontalPodAutoscaler{remaining}, *scheduler.shared[newSharedCollectorKey("default", typeName)].scheduled.subscribers.Load())
+			require.NotNil(t, scheduler.find(ref(remaining), typeName))
+
+			scheduler.Remove(ref(remaining), auditReasonHPADeleted)
+			require.True(t, c.stopped(t))
+			require.Equal(t, 0, scheduler.count())
+			require.Empty(t, scheduler.shared)
+		})
+	}
+}
+
+func TestCollectorSchedulerSharedConfigMismatch(t *testing.T) {
+	typeName := collector.MetricTypeName{Type: autoscaling.ExternalMetricSourceType, Metric: autoscaling.MetricIdentifier{Name: "queue-length"}}
+	scheduler := NewCollectorScheduler(context.Background(), make(chan metricCollection, 10))
+
+	scheduler.AddShared(newSharedTestHPA("hpa1"), typeName, "hash", "fake", &contextCollector{}, auditReasonNew)
+	subscribed, err := scheduler.Subscribe(newSharedTestHPA("hpa2"), typeName, "other", auditReasonNew)
+	require.Error(t, err)
+	require.False(t, subscribed)
+
+	// the HPA with the mismatching config gets its own collector.
+	scheduler.Add(newSharedTestHPA("hpa2"), typeName, "fake", &contextCollector{}, auditReasonNew)
+	require.Equal(t, 2, scheduler.count())
+	require.Len(t, scheduler.shared[newSharedCollectorKey("default", typeName)].subscribers, 1)
+}
+
+func TestCollectorSchedulerSharedReplacesStopped(t *testing.T) {
+	typeName := collector.MetricTypeName{Type: autoscaling.ExternalMetricSourceType, Metric: autoscaling.MetricIdentifier{Name: "queue-length"}}
+	scheduler := NewCollectorScheduler(context.Background(), make(chan metricCollection, 10))
+
+	scheduler.AddShared(newSharedTestHPA("hpa1"), typeName, "hash", "fake", &contextCollector{}, auditReasonNew)
+	stopped := scheduler.shared[newSharedCollectorKey("default", typeName)].scheduled
+	stopped.stopped.Store(true)
+
+	// a stopped collector isn't subscribed to, but replaced for all
+	// subscribers.
+	subscribed, err := scheduler.Subscribe(newSharedTestHPA("hpa2"), typeName, "hash", auditReasonNew)
+	require.NoError(t, err)
+	require.False(t, subscribed)
+	scheduler.AddShared(newSharedTestHPA("hpa2"), typeName, "hash", "fake", &contextCollector{}, auditReasonNew)
+
+	scheduled := scheduler.shared[newSharedCollectorKey("default", typeName)].scheduled
+	require.NotSame(t, stopped, scheduled)
+	require.Same(t, scheduled, scheduler.find(resourceReference{Name: "hpa1", Namespace: "default"}, typeName))
+	require.Same(t, scheduled, scheduler.find(resourceReference{Name: "hpa2", Namespace: "default"}, typeName))
+	require.Equal(t, 1, scheduler.count())
+}
+
+// creationCountingPlugin counts the collectors it created.
+type creationCountingPlugin struct {
+	created *atomic.Int64
+}
+
+func (p creationCountingPlugin) NewCollector(_ context.Context, _ *autoscaling.HorizontalPodAutoscaler, _ *collector.MetricConfig, _ time.Duration) (collector.Collector, error) {
+	p.created.Add(1)
+	return mockCollector{}, nil
+}
+
+func TestUpdateHPAsSharedCollectors(t *testing.T) {
+	value := resource.MustParse("1")
+	newHPA := func(name string, config map[string]string) *autoscaling.HorizontalPodAutoscaler {
+		hpa := &autoscaling.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "default",
+				Annotations: map[string]string{},
+			},
+			Spec: autoscaling.HorizontalPodAutoscalerSpec{
+				ScaleTargetRef: autoscaling.CrossVersionObjectReference{
+					Kind:       "Deployment",
+					Name:       name,
+					APIVersion: "apps/v1",
+				},
+				MaxReplicas: 10,
+				Metrics: []autoscaling.MetricSpec{
+					{
+						Type: autoscaling.ExternalMetricSourceType,
+						External: &autoscaling.ExternalMetricSource{
+							Metric: autoscaling.MetricIdentifier{
+								Name: "queue-length",
+							},
+							Target: autoscaling.MetricTarget{
+								Type:         autoscaling.AverageValueMetricType,
+								AverageValue: &value,
+							},
+						},
+					},
+				},
+			},
+		}
+		for key, value := range config {
+			hpa.Annotations["metric-config.external.queue-length.fake/"+key] = value
+		}
+		return hpa
+	}
+
+	fakeClient := fake.NewSimpleClientset()
+	hpas := fakeClient.AutoscalingV2().HorizontalPodAutoscalers("default")
+	for _, hpa := range []*autoscaling.HorizontalPodAutoscaler{
+		newHPA("hpa1", map[string]string{SharedConfigKey: "true", "query": "orders"}),
+		newHPA("hpa2", map[string]string{SharedConfigKey: "true", "query": "orders"}),
+		newHPA("hpa3", map[string]string{SharedConfigKey: "true", "query": "payments"}),
+		newHPA("hpa4", map[string]string{"query": "orders"}),
+	} {
+		_, err := hpas.Create(context.TODO(), hpa, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	var created atomic.Int64
+	collectorFactory := collector.NewCollectorFactory()
+	collectorFactory.RegisterExternalCollector([]string{"queue-length"}, creationCountingPlugin{created: &created})
+
+	recorder := &mockEventRecorder{}
+	p := NewHPAProvider(fakeClient, 1*time.Second, 1*time.Second, collectorFactory, false, 1*time.Hour, 1*time.Second)
+	p.recorder = recorder
+	p.collectorScheduler = NewCollectorScheduler(context.Background(), p.metricSink)
+
+	require.NoError(t, p.updateHPAs())
+
+	// hpa1 and hpa2 share a collector, hpa3 can't share it because of its
+	// different query and hpa4 doesn't share it.
+	require.EqualValues(t, 3, created.Load())
+	require.Equal(t, 3, p.collectorScheduler.count())
+	mismatches := 0
+	for _, event := range recorder.Events {
+		if event.Reason == ReasonInvalidConfig {
+			mismatches++
+		}
+	}
+	require.Equal(t, 1, mismatches)
+
+	// the shared collector keeps running for hpa2 after hpa1 is deleted.
+	require.NoError(t, hpas.Delete(context.TODO(), "hpa1", metav1.DeleteOptions{}))
+	require.NoError(t, p.updateHPAs())
+	require.EqualValues(t, 3, created.Load())
+	require.Equal(t, 3, p.collectorScheduler.count())
+
+	require.NoError(t, hpas.Delete(context.TODO(), "hpa2", metav1.DeleteOptions{}))
+	require.NoError(t, p.updateHPAs())
+	require.Equal(t, 2, p.collectorScheduler.count())
+	require.Empty(t, p.collectorScheduler.shared)
+}
+
+func TestUpdateHPAsSharedPerReplica(t *testing.T) {
+	value := resource.MustParse("1")
+	newHPA := func(name, target string) *autoscaling.HorizontalPodAutoscaler {
+		return &autoscaling.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				Annotations: map[string]string{
+					"metric-config.external.queue-length.fake/" + SharedConfigKey: "true",
+					"metric-config.external.queue-length.fake/query":              "orders",
+					"metric-config.external.queue-length.fake/per-replica":        "true",
+				},
+			},
+			Spec: autoscaling.HorizontalPodAutoscalerSpec{
+				ScaleTargetRef: autoscaling.CrossVersionObjectReference{Kind: "Deployment", Name: target, APIVersion: "apps/v1"},
+				MaxReplicas:    10,
+				Metrics: []autoscaling.MetricSpec{
+					{
+						Type: autoscaling.ExternalMetricSourceType,
+						External: &autoscaling.ExternalMetricSource{
+							Metric: autoscaling.MetricIdentifier{Name: "queue-length"},
+							Target: autoscaling.MetricTarget{Type: autoscaling.ValueMetricType, Value: &value},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	fakeClient := fake.NewSimpleClientset()
+	hpas := fakeClient.AutoscalingV2().HorizontalPodAutoscalers("default")
+	for _, hpa := range []*autoscaling.HorizontalPodAutoscaler{
+		newHPA("hpa1", "orders"),
+		newHPA("hpa2", "orders"),
+		newHPA("hpa3", "payments"),
+	} {
+		_, err := hpas.Create(context.TODO(), hpa, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	var created atomic.Int64
+	collectorFactory := collector.NewCollectorFactory()
+	collectorFactory.RegisterExternalCollector([]string{"queue-length"}, creationCountingPlugin{created: &created})
+
+	recorder := &mockEventRecorder{}
+	p := NewHPAProvider(fakeClient, 1*time.Second, 1*time.Second, collectorFactory, false, 1*time.Hour, 1*time.Second)
+	p.recorder = recorder
+	p.collectorScheduler = NewCollectorScheduler(context.Background(), p.metricSink)
+
+	require.NoError(t, p.updateHPAs())
+
+	// hpa1 and hpa2 scale the same deployment and share the collector,
+	// hpa3 divides by the replicas of another deployment.
+	require.EqualValues(t, 2, created.Load())
+	require.Equal(t, 2, p.collectorScheduler.count())
+	require.Len(t, p.collectorScheduler.shared, 1)
+	for _, shared := range p.collectorScheduler.shared {
+		require.Len(t, shared.subscribers, 2)
+		for _, subscriber := range shared.subscribers {
+			require.Equal(t, "orders", subscriber.hpa.Spec.ScaleTargetRef.Name)
+		}
+	}
+}
+
+func TestSharedConfigHash(t *testing.T) {
+	hpa := newSharedTestHPA("hpa1")
+	newConfig := func(metricType autoscaling.MetricSourceType, config map[string]string) *collector.MetricConfig {
+		return &collector.MetricConfig{
+			MetricTypeName: collector.MetricTypeName{Type: metricType, Metric: autoscaling.MetricIdentifier{Name: "queue-length"}},
+			CollectorType:  "fake",
+			Config:         config,
+		}
+	}
+
+	recorder := &mockEventRecorder{}
+	p := NewHPAProvider(fake.NewSimpleClientset(), time.Second, time.Second, collector.NewCollectorFactory(), false, time.Minute, time.Second)
+	p.recorder = recorder
+
+	hash, shared := p.sharedConfigHash(hpa, newConfig(autoscaling.ExternalMetricSourceType, map[string]string{SharedConfigKey: "true", "query": "a"}), time.Minute)
+	require.True(t, shared)
+	same, _ := p.sharedConfigHash(hpa, newConfig(autoscaling.ExternalMetricSourceType, map[string]string{"query": "a", SharedConfigKey: "true"}), time.Minute)
+	require.Equal(t, hash, same)
+	otherQuery, _ := p.sharedConfigHash(hpa, newConfig(autoscaling.ExternalMetricSourceType, map[string]string{SharedConfigKey: "true", "query": "b"}), time.Minute)
+	require.NotEqual(t, hash, otherQuery)
+	otherInterval, _ := p.sharedConfigHash(hpa, newConfig(autoscaling.ExternalMetricSourceType, map[string]string{SharedConfigKey: "true", "query": "a"}), time.Second)
+	require.NotEqual(t, hash, otherInterval)
+	otherTTL := newConfig(autoscaling.ExternalMetricSourceType, map[string]string{SharedConfigKey: "true", "query": "a"})
+	otherTTL.TTL = time.Hour
+	ttlHash, _ := p.sharedConfigHash(hpa, otherTTL, time.Minute)
+	require.NotEqual(t, hash, ttlHash)
+	otherMinPodReadyAge := newConfig(autoscaling.ExternalMetricSourceType, map[string]string{SharedConfigKey: "true", "query": "a"})
+	otherMinPodReadyAge.MinPodReadyAge = time.Minute
+	minPodReadyAgeHash, _ := p.sharedConfigHash(hpa, otherMinPodReadyAge, time.Minute)
+	require.NotEqual(t, hash, minPodReadyAgeHash)
+
+	// per replica values are only shared for the same scale target.
+	perReplica := newConfig(autoscaling.ExternalMetricSourceType, map[string]string{SharedConfigKey: "true", "query": "a"})
+	perReplica.PerReplica = true
+	perReplicaHash, _ := p.sharedConfigHash(hpa, perReplica, time.Minute)
+	require.NotEqual(t, hash, perReplicaHash)
+	sameTarget := newSharedTestHPA("hpa2")
+	sameTargetHash, _ := p.sharedConfigHash(sameTarget, perReplica, time.Minute)
+	require.Equal(t, perReplicaHash, sameTargetHash)
+	otherTarget := newSharedTestHPA("hpa3")
+	otherTarget.Spec.ScaleTargetRef = autoscaling.CrossVersionObjectReference{Kind: "Deployment", Name: "other", APIVersion: "apps/v1"}
+	otherTargetHash, _ := p.sharedConfigHash(otherTarget, perReplica, time.Minute)
+	require.NotEqual(t, perReplicaHash, otherTargetHash)
+	require.Empty(t, recorder.Events)
+
+	_, shared = p.sharedConfigHash(hpa, newConfig(autoscaling.ExternalMetricSourceType, map[string]string{"query": "a"}), time.Minute)
+	require.False(t, shared)
+	require.Empty(t, recorder.Events)
+
+	_, shared = p.sharedConfigHash(hpa, newConfig(autoscaling.ExternalMetricSourceType, map[string]string{SharedConfigKey: "yes"}), time.Minute)
+	require.False(t, shared)
+	_, shared = p.sharedConfigHash(hpa, newConfig(autoscaling.PodsMetricSourceType, map[string]string{SharedConfigKey: "true"}), time.Minute)
+	require.False(t, shared)
+	require.Len(t, recorder.Events, 2)
+}
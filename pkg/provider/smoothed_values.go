@@ -0,0 +1,98 @@
+package provider
+
+import (
+	"sync"
+	"time"
+
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+)
+
+// SmoothedValue is the last served value of a smoothed metric next to the
+// collected raw value.
+type SmoothedValue struct {
+	Raw       string    `json:"raw"`
+	Smoothed  string    `json:"smoothed"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type smoothedEntry struct {
+	value   SmoothedValue
+	expires time.Time
+}
+
+// smoothedValues records the last raw and smoothed value per smoothed
+// metric. Values which aren't updated within the ttl are no longer returned
+// and removed by the garbage collection like the stored metrics.
+type smoothedValues struct {
+	ttl    time.Duration
+	now    func() time.Time
+	values map[string]smoothedEntry
+	sync.RWMutex
+}
+
+// newSmoothedValues initializes a new smoothedValues dropping values after
+// the ttl.
+func newSmoothedValues(ttl time.Duration) *smoothedValues {
+	return &smoothedValues{
+		ttl:    ttl,
+		now:    time.Now,
+		values: map[string]smoothedEntry{},
+	}
+}
+
+// Record records the raw and smoothed value of a collected metric. Metrics
+// which weren't smoothed are ignored.
+func (s *smoothedValues) Record(value collector.CollectedMetric) {
+	if value.Raw == nil {
+		return
+	}
+
+	smoothed := SmoothedValue{Raw: value.Raw.String()}
+	switch value.Type {
+	case autoscalingv2.ObjectMetricSourceType, autoscalingv2.PodsMetricSourceType:
+		smoothed.Smoothed = value.Custom.Value.String()
+		smoothed.Timestamp = value.Custom.Timestamp.Time
+	case autoscalingv2.ExternalMetricSourceType:
+		smoothed.Smoothed = value.External.Value.String()
+		smoothed.Timestamp = value.External.Timestamp.Time
+	}
+
+	now := s.now()
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.values[recordedMetricKey(value)] = smoothedEntry{value: smoothed, expires: now.Add(s.ttl)}
+}
+
+// RemoveExpired removes the values which weren't updated within the ttl.
+func (s *smoothedValues) RemoveExpired() {
+	now := s.now()
+
+	s.Lock()
+	defer s.Unlock()
+
+	for key, entry := range s.values {
+		if now.After(entry.expires) {
+			delete(s.values, key)
+		}
+	}
+}
+
+// Values returns the last raw and smoothed value per metric.
+func (s *smoothedValues) Values() map[string]SmoothedValue {
+	now := s.now()
+
+	s.RLock()
+	defer s.RUnlock()
+
+	values := make(map[string]SmoothedValue, len(s.values))
+	for key, entry := range s.values {
+		if now.After(entry.expires) {
+			continue
+		}
+		values[key] = entry.value
+	}
+	return values
+}
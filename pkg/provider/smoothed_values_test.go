@@ -0,0 +1,34 @@
+package provider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestSmoothedValues(t *testing.T) {
+	now := time.Unix(100, 0)
+	values := newSmoothedValues(time.Minute)
+	values.now = func() time.Time { return now }
+
+	// metrics which weren't smoothed are not recorded.
+	values.Record(externalMetricWithQuery(1, ""))
+	require.Empty(t, values.Values())
+
+	metric := externalMetricWithQuery(5, "")
+	raw := resource.MustParse("9")
+	metric.Raw = &raw
+	values.Record(metric)
+	require.Equal(t, map[string]SmoothedValue{
+		"External/default/rps{type=prometheus}": {Raw: "9", Smoothed: "5", Timestamp: time.Unix(5, 0).UTC()},
+	}, values.Values())
+
+	// values which weren't updated within the ttl are dropped.
+	now = now.Add(2 * time.Minute)
+	require.Empty(t, values.Values())
+	require.Len(t, values.values, 1)
+	values.RemoveExpired()
+	require.Empty(t, values.values)
+}
@@ -0,0 +1,232 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/metrics/pkg/apis/custom_metrics"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+)
+
+// stateVersion is the version of the serialized metric store state. It must
+// be increased on incompatible changes of the format. Adding fields is
+// compatible as unknown fields are ignored when reading the state.
+const stateVersion = 1
+
+// storeState is the serialized state of a MetricStore.
+type storeState struct {
+	Version  int                   `json:"version"`
+	Custom   []customMetricState   `json:"custom,omitempty"`
+	External []externalMetricState `json:"external,omitempty"`
+}
+
+// customMetricState is the serialized state of a stored custom metric.
+type customMetricState struct {
+	Kind          string                `json:"kind"`
+	APIVersion    string                `json:"apiVersion,omitempty"`
+	Namespace     string                `json:"namespace,omitempty"`
+	Name          string                `json:"name"`
+	Metric        string                `json:"metric"`
+	Selector      *metav1.LabelSelector `json:"selector,omitempty"`
+	Timestamp     metav1.Time           `json:"timestamp"`
+	WindowSeconds *int64                `json:"windowSeconds,omitempty"`
+	Value         resource.Quantity     `json:"value"`
+	TTL           time.Time             `json:"ttl"`
+}
+
+// externalMetricState is the serialized state of a stored external metric.
+type externalMetricState struct {
+	Namespace     string            `json:"namespace,omitempty"`
+	Metric        string            `json:"metric"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Timestamp     metav1.Time       `json:"timestamp"`
+	WindowSeconds *int64            `json:"windowSeconds,omitempty"`
+	Value         resource.Quantity `json:"value"`
+	TTL           time.Time         `json:"ttl"`
+}
+
+// WriteState serializes the metrics of the store including their TTLs.
+func (s *MetricStore) WriteState(w io.Writer) error {
+	state := storeState{Version: stateVersion}
+
+	s.RLock()
+	for _, group2namespace := range s.customMetricsStore {
+		for _, namespace2object := range group2namespace {
+			for _, object2label := range namespace2object {
+				for _, label2metric := range object2label {
+					for _, metric := range label2metric {
+						value := metric.Value
+						state.Custom = append(state.Custom, customMetricState{
+							Kind:          value.DescribedObject.Kind,
+							APIVersion:    value.DescribedObject.APIVersion,
+							Namespace:     value.DescribedObject.Namespace,
+							Name:          value.DescribedObject.Name,
+							Metric:        value.Metric.Name,
+							Selector:      value.Metric.Selector,
+							Timestamp:     value.Timestamp,
+							WindowSeconds: value.WindowSeconds,
+							Value:         value.Value,
+							TTL:           metric.TTL,
+						})
+					}
+				}
+			}
+		}
+	}
+
+	for namespace, metrics := range s.externalMetricsStore {
+		for _, selectors := range metrics {
+			for _, metric := range selectors {
+				value := metric.Value
+				state.External = append(state.External, externalMetricState{
+					Namespace:     string(namespace),
+					Metric:        value.MetricName,
+					Labels:        value.MetricLabels,
+					Timestamp:     value.Timestamp,
+					WindowSeconds: value.WindowSeconds,
+					Value:         value.Value,
+					TTL:           metric.TTL,
+				})
+			}
+		}
+	}
+	s.RUnlock()
+
+	return json.NewEncoder(w).Encode(state)
+}
+
+// ReadState inserts the metrics serialized by WriteState into the store.
+// Metrics expired at now are discarded, the others keep their TTL. It
+// returns the number of loaded metrics.
+func (s *MetricStore) ReadState(r io.Reader, now time.Time) (int, error) {
+	var state storeState
+	err := json.NewDecoder(r).Decode(&state)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode metric store state: %w", err)
+	}
+
+	if state.Version < 1 || state.Version > stateVersion {
+		return 0, fmt.Errorf("unsupported metric store state version %d, expected %d", state.Version, stateVersion)
+	}
+
+	loaded := 0
+	for _, metric := range state.Custom {
+		if metric.TTL.Before(now) {
+			continue
+		}
+
+		s.insertCustomMetric(resourceReference{}, custom_metrics.MetricValue{
+			DescribedObject: custom_metrics.ObjectReference{
+				Kind:       metric.Kind,
+				APIVersion: metric.APIVersion,
+				Namespace:  metric.Namespace,
+				Name:       metric.Name,
+			},
+			Metric: custom_metrics.MetricIdentifier{
+				Name:     metric.Metric,
+				Selector: metric.Selector,
+			},
+			Timestamp:     metric.Timestamp,
+			WindowSeconds: metric.WindowSeconds,
+			Value:         metric.Value,
+		}, metric.TTL)
+		loaded++
+	}
+
+	for _, metric := range state.External {
+		if metric.TTL.Before(now) {
+			continue
+		}
+
+		s.insertExternalMetric(resourceReference{}, objectNamespace(metric.Namespace), external_metrics.ExternalMetricValue{
+			MetricName:    metric.Metric,
+			MetricLabels:  metric.Labels,
+			Timestamp:     metric.Timestamp,
+			WindowSeconds: metric.WindowSeconds,
+			Value:         metric.Value,
+		}, metric.TTL)
+		loaded++
+	}
+
+	return loaded, nil
+}
+
+// EnableStatePersistence loads the metric store from file on Run and saves
+// it to file every interval and when the provider is stopped. This keeps
+// metrics available across restarts of the adapter until they expire.
+func (p *HPAProvider) EnableStatePersistence(file string, interval time.Duration) {
+	p.stateFile = file
+	p.stateSaveInterval = interval
+}
+
+// loadState loads the metric store from the state file. A missing state
+// file is not an error.
+func (p *HPAProvider) loadState() error {
+	f, err := os.Open(p.stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	loaded, err := p.metricStore.ReadState(f, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to load state file %s: %w", p.stateFile, err)
+	}
+
+	p.logger.Infof("Loaded %d metric(s) from state file %s", loaded, p.stateFile)
+	return nil
+}
+
+// saveState saves the metric store to the state file. The state is written
+// to a temporary file first, so the state file is never partially written.
+func (p *HPAProvider) saveState() error {
+	f, err := os.CreateTemp(filepath.Dir(p.stateFile), filepath.Base(p.stateFile)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	err = p.metricStore.WriteState(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), p.stateFile)
+}
+
+// runStatePersistence saves the metric store to the state file every
+// stateSaveInterval and once more when the context is canceled.
+func (p *HPAProvider) runStatePersistence(ctx context.Context) {
+	for {
+		select {
+		case <-time.After(p.stateSaveInterval):
+			err := p.saveState()
+			if err != nil {
+				p.logger.Errorf("Failed to save state file %s: %v", p.stateFile, err)
+			}
+		case <-ctx.Done():
+			err := p.saveState()
+			if err != nil {
+				p.logger.Errorf("Failed to save state file %s: %v", p.stateFile, err)
+			}
+			p.logger.Info("Stopped metric store state persistence.")
+			return
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscaling "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/metrics/pkg/apis/custom_metrics"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+	"sigs.k8s.io/custom-metrics-apiserver/pkg/provider"
+)
+
+func populatedStore(ttl time.Time) *MetricStore {
+	store := NewMetricStore(func() time.Time { return ttl })
+	store.Insert(collector.CollectedMetric{
+		Type: autoscaling.ObjectMetricSourceType,
+		Custom: custom_metrics.MetricValue{
+			DescribedObject: custom_metrics.ObjectReference{
+				Name:       "app",
+				Namespace:  "default",
+				Kind:       "Ingress",
+				APIVersion: "networking.k8s.io/v1",
+			},
+			Metric: custom_metrics.MetricIdentifier{
+				Name:     "requests-per-second",
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"backend": "app"}},
+			},
+			Value: resource.MustParse("42"),
+		},
+	})
+	store.Insert(collector.CollectedMetric{
+		Type:      autoscaling.ExternalMetricSourceType,
+		Namespace: "default",
+		External: external_metrics.ExternalMetricValue{
+			MetricName:   "queue-length",
+			MetricLabels: map[string]string{"queue": "jobs"},
+			Value:        resource.MustParse("1500m"),
+		},
+	})
+	return store
+}
+
+func storedIngressMetric(store *MetricStore) *custom_metrics.MetricValue {
+	return store.GetMetricsByName(context.Background(),
+		types.NamespacedName{Namespace: "default", Name: "app"},
+		provider.CustomMetricInfo{
+			GroupResource: schema.GroupResource{Group: "networking.k8s.io", Resource: "ingresses"},
+			Namespaced:    true,
+			Metric:        "requests-per-second",
+		},
+		labels.SelectorFromSet(labels.Set{"backend": "app"}),
+	)
+}
+
+func storedQueueLength(t *testing.T, store *MetricStore) *external_metrics.ExternalMetricValueList {
+	metrics, err := store.GetExternalMetric(context.Background(), "default",
+		labels.SelectorFromSet(labels.Set{"queue": "jobs"}),
+		provider.ExternalMetricInfo{Metric: "queue-length"},
+	)
+	require.NoError(t, err)
+	return metrics
+}
+
+func TestMetricStoreStateRoundTrip(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ttl := now.Add(10 * time.Minute)
+
+	var buf bytes.Buffer
+	err := populatedStore(ttl).WriteState(&buf)
+	require.NoError(t, err)
+
+	// the loaded metrics keep their TTL instead of getting a new one
+	store := NewMetricStore(func() time.Time { return now.Add(time.Hour) })
+	store.now = func() time.Time { return now }
+	loaded, err := store.ReadState(&buf, now)
+	require.NoError(t, err)
+	require.Equal(t, 2, loaded)
+
+	custom := storedIngressMetric(store)
+	require.NotNil(t, custom)
+	require.Equal(t, int64(42), custom.Value.Value())
+	require.Equal(t, ttl, store.customMetricsStore["requests-per-second"][schema.GroupResource{Group: "networking.k8s.io", Resource: "ingresses"}]["default"]["app"]["backend=app"].TTL)
+
+	external := storedQueueLength(t, store)
+	require.Len(t, external.Items, 1)
+	require.Equal(t, int64(1500), external.Items[0].Value.MilliValue())
+	require.Equal(t, ttl, store.externalMetricsStore["default"]["queue-length"]["queue=jobs"].TTL)
+}
+
+func TestMetricStoreReadStateDiscardsExpiredMetrics(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	err := populatedStore(now.Add(-time.Second)).WriteState(&buf)
+	require.NoError(t, err)
+
+	store := NewMetricStore(func() time.Time { return now.Add(time.Hour) })
+	loaded, err := store.ReadState(&buf, now)
+	require.NoError(t, err)
+	require.Equal(t, 0, loaded)
+	require.Nil(t, storedIngressMetric(store))
+	require.Empty(t, storedQueueLength(t, store).Items)
+}
+
+func TestMetricStoreReadState(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		msg    string
+		state  string
+		loaded int
+		err    bool
+	}{
+		{
+			msg: "unknown fields are ignored",
+			state: `{"version":1,"future":true,"external":[
+				{"namespace":"default","metric":"queue-length","labels":{"queue":"jobs"},"value":"3","ttl":"2024-01-01T13:00:00Z","future":"field"}
+			]}`,
+			loaded: 1,
+		},
+		{
+			msg:   "unsupported version",
+			state: `{"version":2}`,
+			err:   true,
+		},
+		{
+			msg:   "missing version",
+			state: `{}`,
+			err:   true,
+		},
+		{
+			msg:   "invalid state",
+			state: `{"version":`,
+			err:   true,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			store := NewMetricStore(func() time.Time { return now.Add(time.Hour) })
+			loaded, err := store.ReadState(strings.NewReader(tc.state), now)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.loaded, loaded)
+		})
+	}
+}
+
+func TestHPAProviderStatePersistence(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	p := NewHPAProvider(fake.NewSimpleClientset(), 1*time.Second, 1*time.Second, collector.NewCollectorFactory(), false, 1*time.Hour, 1*time.Hour)
+	p.EnableStatePersistence(stateFile, time.Minute)
+
+	// a missing state file is not an error
+	require.NoError(t, p.loadState())
+
+	p.metricStore = populatedStore(time.Now().UTC().Add(time.Hour))
+	require.NoError(t, p.saveState())
+
+	// no temporary files are left behind
+	files, err := os.ReadDir(filepath.Dir(stateFile))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	restarted := NewHPAProvider(fake.NewSimpleClientset(), 1*time.Second, 1*time.Second, collector.NewCollectorFactory(), false, 1*time.Hour, 1*time.Hour)
+	restarted.EnableStatePersistence(stateFile, time.Minute)
+	require.NoError(t, restarted.loadState())
+	require.NotNil(t, storedIngressMetric(restarted.metricStore))
+	require.Len(t, storedQueueLength(t, restarted.metricStore).Items, 1)
+}
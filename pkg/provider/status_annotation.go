@@ -0,0 +1,218 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	autoscalingv2apply "k8s.io/client-go/applyconfigurations/autoscaling/v2"
+)
+
+const (
+	// StatusAnnotation is the HPA annotation holding the last collected
+	// value of each metric when status annotations are enabled.
+	StatusAnnotation = "metrics.zalando.org/last-collected"
+	// statusAnnotationFieldManager is the field manager of the server-side
+	// apply owning the status annotation.
+	statusAnnotationFieldManager = "kube-metrics-adapter-status"
+	// statusAnnotationInterval is the minimum time between two updates of
+	// the annotation of an HPA.
+	statusAnnotationInterval = time.Minute
+	// statusAnnotationCheckInterval is the interval at which HPAs with
+	// changed statuses are updated.
+	statusAnnotationCheckInterval = 10 * time.Second
+	// maxStatusErrorLength is the length errors are truncated to.
+	maxStatusErrorLength = 200
+	// maxStatusAnnotationSize bounds the size of the annotation. Metrics
+	// exceeding it are left out.
+	maxStatusAnnotationSize = 4096
+)
+
+// metricStatus is the status of a metric in the status annotation.
+type metricStatus struct {
+	Value string    `json:"value,omitempty"`
+	Time  time.Time `json:"time"`
+	Error string    `json:"error,omitempty"`
+}
+
+// hpaStatus is the status of the metrics of an HPA.
+type hpaStatus struct {
+	metrics   map[string]metricStatus
+	changed   bool
+	lastWrite time.Time
+}
+
+// statusAnnotations keeps the status of the metrics of the HPAs until it's
+// written to their status annotation.
+type statusAnnotations struct {
+	sync.Mutex
+	hpas map[resourceReference]*hpaStatus
+	now  func() time.Time
+}
+
+func newStatusAnnotations() *statusAnnotations {
+	return &statusAnnotations{
+		hpas: map[resourceReference]*hpaStatus{},
+		now:  time.Now,
+	}
+}
+
+// EnableStatusAnnotations enables writing the last collected value of each
+// metric to the StatusAnnotation of the HPA, so it's visible to users
+// without access to the adapter. The annotation of an HPA is updated at
+// most once per minute.
+func (p *HPAProvider) EnableStatusAnnotations() {
+	p.statusAnnotations = newStatusAnnotations()
+}
+
+// Record records the status of a collection.
+func (s *statusAnnotations) Record(collection metricCollection) {
+	if collection.HPA == nil || collection.Metric == "" {
+		return
+	}
+
+	status := metricStatus{Time: s.now().UTC().Truncate(time.Second)}
+	if collection.Error != nil {
+		status.Error = truncate(collection.Error.Error(), maxStatusErrorLength)
+	} else if value, ok := statusValue(collection.Values); ok {
+		status.Value = value.String()
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	ref := resourceReference{Namespace: collection.HPA.Namespace, Name: collection.HPA.Name}
+	hpa, ok := s.hpas[ref]
+	if !ok {
+		hpa = &hpaStatus{metrics: map[string]metricStatus{}}
+		s.hpas[ref] = hpa
+	}
+	hpa.metrics[collection.Metric] = status
+	hpa.changed = true
+}
+
+// Forget drops the statuses of HPAs which don't exist anymore.
+func (s *statusAnnotations) Forget(exists func(resourceReference) bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	for ref := range s.hpas {
+		if !exists(ref) {
+			delete(s.hpas, ref)
+		}
+	}
+}
+
+// pending returns the annotations of the HPAs with changed statuses which
+// weren't written within the statusAnnotationInterval.
+func (s *statusAnnotations) pending() map[resourceReference]string {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.now()
+	pending := map[resourceReference]string{}
+	for ref, hpa := range s.hpas {
+		if !hpa.changed || now.Sub(hpa.lastWrite) < statusAnnotationInterval {
+			continue
+		}
+		pending[ref] = encodeStatus(hpa.metrics)
+		hpa.changed = false
+		hpa.lastWrite = now
+	}
+	return pending
+}
+
+// writeStatusAnnotations writes the pending status annotations. Only the
+// annotation is applied with a dedicated field manager, so it neither
+// changes the generation of the HPA nor conflicts with the fields of other
+// managers.
+func (p *HPAProvider) writeStatusAnnotations(ctx context.Context) {
+	for ref, annotation := range p.statusAnnotations.pending() {
+		hpa := autoscalingv2apply.HorizontalPodAutoscaler(ref.Name, ref.Namespace).
+			WithAnnotations(map[string]string{StatusAnnotation: annotation})
+
+		_, err := p.client.AutoscalingV2().HorizontalPodAutoscalers(ref.Namespace).Apply(ctx, hpa, metav1.ApplyOptions{
+			FieldManager: statusAnnotationFieldManager,
+			Force:        true,
+		})
+		if err != nil {
+			p.logger.Errorf("Failed to update status annotation of HPA %s/%s: %v", ref.Namespace, ref.Name, err)
+		}
+	}
+}
+
+// runStatusAnnotations writes the status annotations until the context is
+// canceled.
+func (p *HPAProvider) runStatusAnnotations(ctx context.Context) {
+	for {
+		select {
+		case <-time.After(statusAnnotationCheckInterval):
+			p.writeStatusAnnotations(ctx)
+		case <-ctx.Done():
+			p.logger.Info("Stopped status annotations.")
+			return
+		}
+	}
+}
+
+// statusValue returns the value of the metric as considered by the HPA: the
+// sum of the series of External and Object metrics and the average of the
+// pod values of Pods metrics.
+func statusValue(values []collector.CollectedMetric) (resource.Quantity, bool) {
+	if len(values) == 0 {
+		return resource.Quantity{}, false
+	}
+
+	var sum int64
+	for _, value := range values {
+		switch value.Type {
+		case autoscalingv2.ExternalMetricSourceType:
+			sum += value.External.Value.MilliValue()
+		default:
+			sum += value.Custom.Value.MilliValue()
+		}
+	}
+
+	if values[0].Type == autoscalingv2.PodsMetricSourceType {
+		sum /= int64(len(values))
+	}
+
+	return *resource.NewMilliQuantity(sum, resource.DecimalSI), true
+}
+
+// encodeStatus encodes the statuses of the metrics as compact JSON. Metrics
+// are added in the order of their names until the size limit is reached.
+func encodeStatus(metrics map[string]metricStatus) string {
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	included := make(map[string]metricStatus, len(metrics))
+	encoded := []byte("{}")
+	for _, name := range names {
+		included[name] = metrics[name]
+		data, err := json.Marshal(included)
+		if err != nil || len(data) > maxStatusAnnotationSize {
+			delete(included, name)
+			continue
+		}
+		encoded = data
+	}
+	return string(encoded)
+}
+
+// truncate truncates the string to at most n bytes.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
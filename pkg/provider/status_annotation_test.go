@@ -0,0 +1,152 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	autoscaling "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/metrics/pkg/apis/custom_metrics"
+	"k8s.io/metrics/pkg/apis/external_metrics"
+)
+
+func externalCollection(hpa *autoscaling.HorizontalPodAutoscaler, metric string, values ...string) metricCollection {
+	collection := metricCollection{HPA: hpa, Metric: metric}
+	for _, value := range values {
+		collection.Values = append(collection.Values, collector.CollectedMetric{
+			Type: autoscaling.ExternalMetricSourceType,
+			External: external_metrics.ExternalMetricValue{
+				MetricName: metric,
+				Value:      resource.MustParse(value),
+			},
+		})
+	}
+	return collection
+}
+
+func decodeStatusAnnotation(t *testing.T, p *HPAProvider, hpa *autoscaling.HorizontalPodAutoscaler) map[string]metricStatus {
+	current, err := p.client.AutoscalingV2().HorizontalPodAutoscalers(hpa.Namespace).Get(context.Background(), hpa.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	annotation, ok := current.Annotations[StatusAnnotation]
+	require.True(t, ok)
+
+	var status map[string]metricStatus
+	require.NoError(t, json.Unmarshal([]byte(annotation), &status))
+	return status
+}
+
+func TestStatusAnnotations(t *testing.T) {
+	hpa := &autoscaling.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app",
+			Namespace:   "default",
+			Generation:  3,
+			Annotations: map[string]string{"team": "foo"},
+		},
+		Spec: autoscaling.HorizontalPodAutoscalerSpec{MaxReplicas: 10},
+	}
+
+	client := fake.NewClientset(hpa)
+	p := NewHPAProvider(client, time.Second, time.Second, collector.NewCollectorFactory(), false, time.Hour, time.Hour)
+	p.EnableStatusAnnotations()
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	p.statusAnnotations.now = func() time.Time { return now }
+
+	applies := func() int {
+		n := 0
+		for _, action := range client.Actions() {
+			if action.GetVerb() == "patch" {
+				n++
+			}
+		}
+		return n
+	}
+
+	p.statusAnnotations.Record(externalCollection(hpa, "queue-length", "10", "32"))
+	p.statusAnnotations.Record(metricCollection{HPA: hpa, Metric: "lag", Error: errors.New(strings.Repeat("x", 500))})
+	p.statusAnnotations.Record(metricCollection{
+		HPA:    hpa,
+		Metric: "requests",
+		Values: []collector.CollectedMetric{
+			{Type: autoscaling.PodsMetricSourceType, Custom: custom_metrics.MetricValue{Value: resource.MustParse("1")}},
+			{Type: autoscaling.PodsMetricSourceType, Custom: custom_metrics.MetricValue{Value: resource.MustParse("2")}},
+		},
+	})
+	p.writeStatusAnnotations(context.Background())
+	require.Equal(t, 1, applies())
+
+	status := decodeStatusAnnotation(t, p, hpa)
+	require.Equal(t, metricStatus{Value: "42", Time: now}, status["queue-length"])
+	require.Equal(t, "1500m", status["requests"].Value)
+	require.Len(t, status["lag"].Error, maxStatusErrorLength)
+	require.Empty(t, status["lag"].Value)
+
+	// only the annotation is changed.
+	current, err := client.AutoscalingV2().HorizontalPodAutoscalers("default").Get(context.Background(), "app", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "foo", current.Annotations["team"])
+	require.Equal(t, int32(10), current.Spec.MaxReplicas)
+	require.Equal(t, int64(3), current.Generation)
+
+	// the patch is a server-side apply of the annotation only.
+	for _, action := range client.Actions() {
+		if patch, ok := action.(interface {
+			GetPatchType() types.PatchType
+			GetPatch() []byte
+		}); ok {
+			require.Equal(t, types.ApplyPatchType, patch.GetPatchType())
+			require.NotContains(t, string(patch.GetPatch()), "spec")
+		}
+	}
+
+	// nothing changed, nothing is written.
+	now = now.Add(2 * time.Minute)
+	p.writeStatusAnnotations(context.Background())
+	require.Equal(t, 1, applies())
+
+	// changes are written at most once per minute.
+	p.statusAnnotations.Record(externalCollection(hpa, "queue-length", "50"))
+	p.writeStatusAnnotations(context.Background())
+	require.Equal(t, 2, applies())
+
+	now = now.Add(30 * time.Second)
+	p.statusAnnotations.Record(externalCollection(hpa, "queue-length", "60"))
+	p.writeStatusAnnotations(context.Background())
+	require.Equal(t, 2, applies())
+	require.Equal(t, "50", decodeStatusAnnotation(t, p, hpa)["queue-length"].Value)
+
+	now = now.Add(30 * time.Second)
+	p.writeStatusAnnotations(context.Background())
+	require.Equal(t, 3, applies())
+	require.Equal(t, "60", decodeStatusAnnotation(t, p, hpa)["queue-length"].Value)
+
+	// statuses of removed HPAs are dropped.
+	p.statusAnnotations.Forget(func(resourceReference) bool { return false })
+	require.Empty(t, p.statusAnnotations.hpas)
+}
+
+func TestEncodeStatusSizeLimit(t *testing.T) {
+	metrics := map[string]metricStatus{}
+	for i := 0; i < 100; i++ {
+		metrics[strings.Repeat(string(rune('a'+i%26)), i+1)] = metricStatus{Error: strings.Repeat("e", maxStatusErrorLength)}
+	}
+
+	encoded := encodeStatus(metrics)
+	require.LessOrEqual(t, len(encoded), maxStatusAnnotationSize)
+
+	var decoded map[string]metricStatus
+	require.NoError(t, json.Unmarshal([]byte(encoded), &decoded))
+	require.NotEmpty(t, decoded)
+	require.Less(t, len(decoded), len(metrics))
+}
@@ -0,0 +1,28 @@
+package provider
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// exemplarTraceIDLabel is the exemplar label holding the trace ID of the
+// collection.
+const exemplarTraceIDLabel = "trace_id"
+
+// tracer starts the spans of the collections. It uses the global tracer
+// provider, so the spans are only recorded if tracing is set up. Without it,
+// the spans only carry the span context of the parent, if any.
+var tracer = otel.Tracer("github.com/zalando-incubator/kube-metrics-adapter/pkg/provider")
+
+// incWithExemplar increments the counter. If the span context of the
+// collection is sampled and the counter supports exemplars, the trace ID is
+// added as exemplar, so the increments link to the trace of the collection.
+func incWithExemplar(counter prometheus.Counter, spanContext trace.SpanContext) {
+	adder, ok := counter.(prometheus.ExemplarAdder)
+	if !ok || !spanContext.IsValid() || !spanContext.IsSampled() {
+		counter.Inc()
+		return
+	}
+	adder.AddWithExemplar(1, prometheus.Labels{exemplarTraceIDLabel: spanContext.TraceID().String()})
+}
@@ -0,0 +1,141 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	"go.opentelemetry.io/otel/trace"
+	autoscaling "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestSpanContext(t *testing.T, flags trace.TraceFlags) trace.SpanContext {
+	traceID, err := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	require.NoError(t, err)
+	spanID, err := trace.SpanIDFromHex("00f067aa0ba902b7")
+	require.NoError(t, err)
+	return trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: flags})
+}
+
+// gatherExemplar returns the exemplar of the counter named name gathered from
+// the gatherer, nil if it has none.
+func gatherExemplar(t *testing.T, gatherer prometheus.Gatherer, name string) *dto.Exemplar {
+	t.Helper()
+	families, err := gatherer.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() == name {
+			require.Len(t, family.GetMetric(), 1)
+			return family.GetMetric()[0].GetCounter().GetExemplar()
+		}
+	}
+	t.Fatalf("metric %s not found", name)
+	return nil
+}
+
+func TestIncWithExemplar(t *testing.T) {
+	for _, tc := range []struct {
+		msg         string
+		spanContext trace.SpanContext
+		expected    string
+	}{
+		{
+			msg:         "sampled span context",
+			spanContext: newTestSpanContext(t, trace.FlagsSampled),
+			expected:    "4bf92f3577b34da6a3ce929d0e0e4736",
+		},
+		{
+			msg:         "span context not sampled",
+			spanContext: newTestSpanContext(t, 0),
+		},
+		{
+			msg: "tracing disabled",
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+			counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "collections"})
+			registry.MustRegister(counter)
+
+			incWithExemplar(counter, tc.spanContext)
+
+			exemplar := gatherExemplar(t, registry, "collections")
+			if tc.expected == "" {
+				require.Nil(t, exemplar)
+				return
+			}
+			require.NotNil(t, exemplar)
+			require.Equal(t, float64(1), exemplar.GetValue())
+			require.Len(t, exemplar.GetLabel(), 1)
+			require.Equal(t, exemplarTraceIDLabel, exemplar.GetLabel()[0].GetName())
+			require.Equal(t, tc.expected, exemplar.GetLabel()[0].GetValue())
+		})
+	}
+}
+
+func TestCollectorRunnerSpanContext(t *testing.T) {
+	hpa := &autoscaling.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Name: "hpa1", Namespace: "default"}}
+	spanContext := newTestSpanContext(t, trace.FlagsSampled)
+
+	for _, tc := range []struct {
+		msg      string
+		ctx      context.Context
+		expected trace.TraceID
+	}{
+		{
+			msg:      "span context of the parent",
+			ctx:      trace.ContextWithSpanContext(context.Background(), spanContext),
+			expected: spanContext.TraceID(),
+		},
+		{
+			msg: "tracing disabled",
+			ctx: context.Background(),
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(tc.ctx)
+			defer cancel()
+
+			c := failingCollector{calls: &atomic.Int64{}, err: fmt.Errorf("unknown"), interval: time.Hour}
+			scheduled := newScheduledCollector(cancel, c.Interval())
+			metricsc := make(chan metricCollection)
+			go collectorRunner(ctx, hpa, c, scheduled, metricsc)
+
+			collection := <-metricsc
+			require.Equal(t, tc.expected, collection.SpanContext.TraceID())
+		})
+	}
+}
+
+func TestCollectMetricsExemplars(t *testing.T) {
+	hpa := &autoscaling.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Name: "hpa1", Namespace: "default"}}
+	spanContext := newTestSpanContext(t, trace.FlagsSampled)
+
+	provider := NewHPAProvider(fake.NewSimpleClientset(), 1*time.Second, 1*time.Second, collector.NewCollectorFactory(), false, 1*time.Second, 1*time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go provider.collectMetrics(ctx)
+
+	provider.metricSink <- metricCollection{HPA: hpa, SpanContext: spanContext}
+	provider.metricSink <- metricCollection{Error: fmt.Errorf("connection refused"), HPA: hpa, SpanContext: spanContext}
+	// the next collection is only received once the previous ones are
+	// processed. Increments without span context keep the last exemplar.
+	provider.metricSink <- metricCollection{HPA: hpa}
+
+	for _, name := range []string{"kube_metrics_adapter_collections_success", "kube_metrics_adapter_collections_error"} {
+		exemplar := gatherExemplar(t, prometheus.DefaultGatherer, name)
+		require.NotNil(t, exemplar, name)
+		require.Len(t, exemplar.GetLabel(), 1)
+		require.Equal(t, exemplarTraceIDLabel, exemplar.GetLabel()[0].GetName())
+		require.Equal(t, spanContext.TraceID().String(), exemplar.GetLabel()[0].GetValue())
+	}
+}
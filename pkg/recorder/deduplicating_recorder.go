@@ -0,0 +1,115 @@
+package recorder
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	kube_record "k8s.io/client-go/tools/record"
+)
+
+// DefaultDeduplicationWindow is the default window in which identical
+// events are only recorded once.
+const DefaultDeduplicationWindow = 15 * time.Minute
+
+var (
+	// EventsSuppressed is the number of events not recorded because an
+	// identical event was recorded within the deduplication window.
+	EventsSuppressed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kube_metrics_adapter_events_suppressed",
+		Help: "The total number of events suppressed because an identical event was recorded recently",
+	}, []string{"reason"})
+)
+
+// DeduplicatingRecorder is an event recorder which records identical events,
+// same object, type, reason and message, at most once per window.
+type DeduplicatingRecorder struct {
+	recorder  kube_record.EventRecorder
+	window    time.Duration
+	now       func() time.Time
+	recorded  map[string]time.Time
+	lastPrune time.Time
+	mtx       sync.Mutex
+}
+
+// NewDeduplicatingRecorder wraps the recorder deduplicating identical
+// events within the window.
+func NewDeduplicatingRecorder(recorder kube_record.EventRecorder, window time.Duration) *DeduplicatingRecorder {
+	return &DeduplicatingRecorder{
+		recorder: recorder,
+		window:   window,
+		now:      time.Now,
+		recorded: map[string]time.Time{},
+	}
+}
+
+// Event records the event unless an identical one was recorded within the
+// window.
+func (r *DeduplicatingRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	if r.suppress(object, eventtype, reason, message) {
+		return
+	}
+	r.recorder.Event(object, eventtype, reason, message)
+}
+
+// Eventf is like Event, but with Sprintf for the message.
+func (r *DeduplicatingRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+// AnnotatedEventf is like Eventf, but with annotations attached to the
+// event. The annotations are not part of the deduplication key.
+func (r *DeduplicatingRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	if r.suppress(object, eventtype, reason, message) {
+		return
+	}
+	r.recorder.AnnotatedEventf(object, annotations, eventtype, reason, "%s", message)
+}
+
+// suppress returns true if an identical event was recorded within the
+// window, otherwise the event is remembered as recorded.
+func (r *DeduplicatingRecorder) suppress(object runtime.Object, eventtype, reason, message string) bool {
+	key := eventKey(object, eventtype, reason, message)
+	now := r.now()
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.prune(now)
+
+	if last, ok := r.recorded[key]; ok && now.Sub(last) < r.window {
+		EventsSuppressed.WithLabelValues(reason).Inc()
+		return true
+	}
+
+	r.recorded[key] = now
+	return false
+}
+
+// prune forgets events recorded before the window, at most once per
+// window.
+func (r *DeduplicatingRecorder) prune(now time.Time) {
+	if now.Sub(r.lastPrune) < r.window {
+		return
+	}
+
+	for key, last := range r.recorded {
+		if now.Sub(last) >= r.window {
+			delete(r.recorded, key)
+		}
+	}
+	r.lastPrune = now
+}
+
+func eventKey(object runtime.Object, eventtype, reason, message string) string {
+	objectKey := fmt.Sprintf("%T", object)
+	if accessor, err := meta.Accessor(object); err == nil {
+		objectKey = fmt.Sprintf("%s/%s/%s/%s", objectKey, accessor.GetNamespace(), accessor.GetName(), accessor.GetUID())
+	}
+	return fmt.Sprintf("%s/%s/%s/%s", objectKey, eventtype, reason, message)
+}
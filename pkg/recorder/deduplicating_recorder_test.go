@@ -0,0 +1,43 @@
+package recorder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kube_record "k8s.io/client-go/tools/record"
+)
+
+func TestDeduplicatingRecorder(t *testing.T) {
+	fake := kube_record.NewFakeRecorder(10)
+	recorder := NewDeduplicatingRecorder(fake, 15*time.Minute)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	recorder.now = func() time.Time { return now }
+
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "hpa-1"}}
+	otherHPA := &autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "hpa-2"}}
+
+	suppressed := testutil.ToFloat64(EventsSuppressed.WithLabelValues("TestReason"))
+
+	for i := 0; i < 3; i++ {
+		recorder.Eventf(hpa, corev1.EventTypeWarning, "TestReason", "failed: %s", "error")
+		now = now.Add(time.Minute)
+	}
+	require.Len(t, fake.Events, 1)
+	require.Equal(t, suppressed+2, testutil.ToFloat64(EventsSuppressed.WithLabelValues("TestReason")))
+
+	// different objects and messages are recorded
+	recorder.Eventf(otherHPA, corev1.EventTypeWarning, "TestReason", "failed: %s", "error")
+	recorder.Eventf(hpa, corev1.EventTypeWarning, "TestReason", "failed: %s", "other error")
+	require.Len(t, fake.Events, 3)
+
+	// the event is recorded again once the window passed
+	now = now.Add(15 * time.Minute)
+	recorder.Eventf(hpa, corev1.EventTypeWarning, "TestReason", "failed: %s", "error")
+	require.Len(t, fake.Events, 4)
+	require.Equal(t, suppressed+2, testutil.ToFloat64(EventsSuppressed.WithLabelValues("TestReason")))
+}
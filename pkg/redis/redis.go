@@ -0,0 +1,127 @@
+package redis
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultTimeout  = 5 * time.Second
+	defaultPoolSize = 10
+)
+
+// Kind is the kind of a Redis key whose length is measured.
+type Kind string
+
+const (
+	// KindList is a list, measured by LLEN.
+	KindList Kind = "list"
+	// KindStream is a stream, measured by XLEN.
+	KindStream Kind = "stream"
+	// KindSet is a set, measured by SCARD.
+	KindSet Kind = "set"
+)
+
+// ErrAuthentication is returned if the server rejects the password or
+// requires one which isn't configured.
+var ErrAuthentication = errors.New("redis authentication failed")
+
+// authErrorPrefixes are the prefixes of the error replies of a failed
+// authentication. They don't contain the password.
+var authErrorPrefixes = []string{"NOAUTH", "WRONGPASS", "invalid password"}
+
+// Options configures the connections of a Client.
+type Options struct {
+	// Password is used to authenticate new connections if set.
+	Password string
+	// Timeout limits connecting and each command unless the context has
+	// an earlier deadline. Defaults to 5s.
+	Timeout time.Duration
+	// TLSConfig enables TLS if set.
+	TLSConfig *tls.Config
+	// PoolSize is the max number of connections to the server. Defaults
+	// to 10.
+	PoolSize int
+}
+
+// Redis defines an interface for reading the length of keys from Redis.
+type Redis interface {
+	Length(ctx context.Context, kind Kind, key string) (int64, error)
+}
+
+// Client reads the length of keys from a single server using a pool of
+// connections. It's safe for concurrent use.
+type Client struct {
+	client *goredis.Client
+}
+
+// NewClient initializes a new Client for the server at the address.
+func NewClient(address string, options Options) *Client {
+	if options.Timeout <= 0 {
+		options.Timeout = defaultTimeout
+	}
+	if options.PoolSize <= 0 {
+		options.PoolSize = defaultPoolSize
+	}
+
+	return &Client{
+		client: goredis.NewClient(&goredis.Options{
+			Addr:                  address,
+			Password:              options.Password,
+			DialTimeout:           options.Timeout,
+			ReadTimeout:           options.Timeout,
+			WriteTimeout:          options.Timeout,
+			ContextTimeoutEnabled: true,
+			TLSConfig:             options.TLSConfig,
+			PoolSize:              options.PoolSize,
+			// the client only sends length commands, which don't need
+			// RESP3 or the client name set on connect.
+			Protocol:        2,
+			DisableIdentity: true,
+		}),
+	}
+}
+
+// Length returns the length of the key of the kind. A missing key has the
+// length 0.
+func (c *Client) Length(ctx context.Context, kind Kind, key string) (int64, error) {
+	var cmd *goredis.IntCmd
+	switch kind {
+	case KindList:
+		cmd = c.client.LLen(ctx, key)
+	case KindStream:
+		cmd = c.client.XLen(ctx, key)
+	case KindSet:
+		cmd = c.client.SCard(ctx, key)
+	default:
+		return 0, fmt.Errorf("unknown redis key kind '%s'", kind)
+	}
+
+	length, err := cmd.Result()
+	if err != nil {
+		if isAuthError(err) {
+			return 0, fmt.Errorf("%w: %v", ErrAuthentication, err)
+		}
+		return 0, fmt.Errorf("failed to get length of %s '%s': %w", kind, key, err)
+	}
+	return length, nil
+}
+
+// Close closes the connections of the client.
+func (c *Client) Close() error {
+	return c.client.Close()
+}
+
+func isAuthError(err error) bool {
+	for _, prefix := range authErrorPrefixes {
+		if goredis.HasErrorPrefix(err, prefix) {
+			return true
+		}
+	}
+	return false
+}
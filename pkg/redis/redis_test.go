@@ -0,0 +1,154 @@
+package redis
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+// newMiniredis starts a miniredis server with a list, a stream and a set.
+func newMiniredis(t *testing.T) *miniredis.Miniredis {
+	server := miniredis.RunT(t)
+	populate(t, server)
+	return server
+}
+
+func populate(t *testing.T, server *miniredis.Miniredis) {
+	_, err := server.Push("jobs", "a", "b", "c")
+	require.NoError(t, err)
+	for _, id := range []string{"1-1", "1-2", "1-3", "1-4", "1-5"} {
+		_, err = server.XAdd("events", id, []string{"field", "value"})
+		require.NoError(t, err)
+	}
+	_, err = server.SAdd("workers", "a", "b")
+	require.NoError(t, err)
+}
+
+func TestClientLength(t *testing.T) {
+	server := newMiniredis(t)
+	client := NewClient(server.Addr(), Options{})
+	defer client.Close()
+
+	for _, tc := range []struct {
+		kind     Kind
+		key      string
+		expected int64
+	}{
+		{kind: KindList, key: "jobs", expected: 3},
+		{kind: KindStream, key: "events", expected: 5},
+		{kind: KindSet, key: "workers", expected: 2},
+		{kind: KindList, key: "missing", expected: 0},
+	} {
+		t.Run(string(tc.kind)+"/"+tc.key, func(t *testing.T) {
+			length, err := client.Length(context.Background(), tc.kind, tc.key)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, length)
+		})
+	}
+
+	// the connection is reused.
+	require.Equal(t, 1, server.TotalConnectionCount())
+}
+
+func TestClientLengthWrongType(t *testing.T) {
+	server := newMiniredis(t)
+	client := NewClient(server.Addr(), Options{})
+	defer client.Close()
+
+	_, err := client.Length(context.Background(), KindStream, "jobs")
+	var replyErr goredis.Error
+	require.ErrorAs(t, err, &replyErr)
+	require.Contains(t, err.Error(), "WRONGTYPE")
+
+	_, err = client.Length(context.Background(), Kind("hash"), "jobs")
+	require.Error(t, err)
+}
+
+func TestClientAuthentication(t *testing.T) {
+	server := newMiniredis(t)
+	server.RequireAuth("secret")
+
+	client := NewClient(server.Addr(), Options{Password: "secret"})
+	defer client.Close()
+	length, err := client.Length(context.Background(), KindList, "jobs")
+	require.NoError(t, err)
+	require.Equal(t, int64(3), length)
+
+	for _, password := range []string{"wrong", ""} {
+		client := NewClient(server.Addr(), Options{Password: password})
+		_, err := client.Length(context.Background(), KindList, "jobs")
+		require.True(t, errors.Is(err, ErrAuthentication), err)
+		if password != "" {
+			require.NotContains(t, err.Error(), password)
+		}
+		client.Close()
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	// a server accepting connections without ever replying.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	client := NewClient(listener.Addr().String(), Options{Timeout: 50 * time.Millisecond})
+	defer client.Close()
+	_, err = client.Length(context.Background(), KindList, "jobs")
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	require.True(t, netErr.Timeout())
+
+	// the deadline of the context takes precedence.
+	client = NewClient(listener.Addr().String(), Options{Timeout: time.Minute})
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = client.Length(ctx, KindList, "jobs")
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 10*time.Second)
+}
+
+func TestClientTLS(t *testing.T) {
+	// reuse the self-signed certificate of httptest.
+	certServer := httptest.NewTLSServer(nil)
+	defer certServer.Close()
+
+	server, err := miniredis.RunTLS(certServer.TLS)
+	require.NoError(t, err)
+	defer server.Close()
+	populate(t, server)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(certServer.Certificate())
+
+	client := NewClient(server.Addr(), Options{TLSConfig: &tls.Config{RootCAs: pool, ServerName: "example.com"}})
+	defer client.Close()
+	length, err := client.Length(context.Background(), KindSet, "workers")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), length)
+
+	// the certificate of the server must be trusted.
+	client = NewClient(server.Addr(), Options{TLSConfig: &tls.Config{ServerName: "example.com"}})
+	defer client.Close()
+	_, err = client.Length(context.Background(), KindSet, "workers")
+	require.Error(t, err)
+}
@@ -0,0 +1,799 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	argorolloutsv1alpha1 "github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	argoRolloutsClient "github.com/argoproj/argo-rollouts/pkg/client/clientset/versioned"
+	rg "github.com/szuecs/routegroup-client/client/clientset/versioned"
+	"github.com/zalando-incubator/cluster-lifecycle-manager/pkg/credentials-loader/platformiam"
+	v1 "github.com/zalando-incubator/kube-metrics-adapter/pkg/apis/zalando.org/v1"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/client/clientset/versioned"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/client/informers/externalversions"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector/httpmetrics"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/controller/scheduledscaling"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/nakadi"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/provider"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/redis"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/zmon"
+	"golang.org/x/oauth2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog"
+	"sigs.k8s.io/custom-metrics-apiserver/pkg/apiserver"
+)
+
+const (
+	metricsServerReadHeaderTimeout = 10 * time.Second
+	metricsServerShutdownTimeout   = 5 * time.Second
+)
+
+// Clients are the clients used by the adapter.
+type Clients struct {
+	// Config is the config the clients are created from.
+	Config     *rest.Config
+	Kubernetes kubernetes.Interface
+	// ArgoRollouts is nil if the support for Argo Rollouts is disabled or
+	// the Rollout CRD is not installed.
+	ArgoRollouts argoRolloutsClient.Interface
+	// RouteGroup is nil if the skipper RouteGroup metrics are disabled.
+	RouteGroup rg.Interface
+	// ScalingSchedule is nil if the ScalingSchedule metrics are disabled.
+	ScalingSchedule versioned.Interface
+}
+
+// NewClients initializes the clients either from the lister kubeconfig or
+// the in-cluster config. The clients of optional integrations are only
+// created if the integration is enabled.
+func NewClients(o AdapterServerOptions) (*Clients, error) {
+	var clientConfig *rest.Config
+	var err error
+	if len(o.RemoteKubeConfigFile) > 0 {
+		loadingRules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: o.RemoteKubeConfigFile}
+		loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{})
+
+		clientConfig, err = loader.ClientConfig()
+	} else {
+		clientConfig, err = rest.InClusterConfig()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to construct lister client config to initialize provider: %v", err)
+	}
+
+	clientConfig.Timeout = defaultClientGOTimeout
+	clientConfig.QPS = o.KubeAPIQPS
+	clientConfig.Burst = o.KubeAPIBurst
+	clientConfig.Wrap(collector.NewKubeAPIRoundTripper)
+
+	client, err := kubernetes.NewForConfig(clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize new client: %v", err)
+	}
+
+	var rolloutsClient argoRolloutsClient.Interface
+	if o.DisableArgoRollouts {
+		klog.Info("Argo Rollouts support is disabled")
+	} else {
+		available, err := argoRolloutsAvailable(client.Discovery())
+		if err != nil {
+			// only skip the client if the CRD is known to be missing.
+			klog.Warningf("Failed to discover the Argo Rollouts API, enabling its support: %v", err)
+			available = true
+		}
+
+		if available {
+			rolloutsClient, err = argoRolloutsClient.NewForConfig(clientConfig)
+			if err != nil {
+				return nil, fmt.Errorf("failed to initialize Argo Rollouts client: %v", err)
+			}
+		} else {
+			klog.Info("Argo Rollouts CRD is not installed, Rollout scale targets are not supported")
+		}
+	}
+
+	var rgClient rg.Interface
+	if o.SkipperRouteGroupMetrics {
+		rgClient, err = rg.NewForConfig(clientConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize RouteGroup client: %v", err)
+		}
+	}
+
+	var scalingScheduleClient versioned.Interface
+	if o.ScalingScheduleMetrics {
+		scalingScheduleClient, err = versioned.NewForConfig(clientConfig)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create [Cluster]ScalingSchedule.zalando.org/v1 client: %v", err)
+		}
+	}
+
+	return &Clients{
+		Config:          clientConfig,
+		Kubernetes:      client,
+		ArgoRollouts:    rolloutsClient,
+		RouteGroup:      rgClient,
+		ScalingSchedule: scalingScheduleClient,
+	}, nil
+}
+
+// argoRolloutsAvailable returns whether the API server serves the Rollout
+// resource of Argo Rollouts, i.e. whether its CRD is installed.
+func argoRolloutsAvailable(client discovery.DiscoveryInterface) (bool, error) {
+	resources, err := client.ServerResourcesForGroupVersion(argorolloutsv1alpha1.SchemeGroupVersion.String())
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	for _, resource := range resources.APIResources {
+		if resource.Name == "rollouts" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// BuildCollectorFactory initializes a collector factory with the collector
+// plugins enabled by the options. Background routines needed by the
+// plugins, like the ScalingSchedule informers and controller, are started
+// and stopped when the context is canceled.
+func BuildCollectorFactory(ctx context.Context, o AdapterServerOptions, clients *Clients) (*collector.CollectorFactory, error) {
+	collectorFactory := collector.NewCollectorFactory()
+
+	if o.ChaosMode {
+		if os.Getenv(collector.ChaosEnvVar) != "true" {
+			return nil, fmt.Errorf("chaos mode can only be enabled if %s=true is set", collector.ChaosEnvVar)
+		}
+
+		chaos := collector.ChaosConfig{
+			MaxLatency:  o.ChaosMaxLatency,
+			FailureRate: o.ChaosFailureRate,
+			Seed:        o.ChaosSeed,
+		}
+		if err := chaos.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid chaos mode config: %v", err)
+		}
+		collectorFactory.EnableChaos(chaos)
+	}
+
+	if o.PrometheusServer != "" {
+		promPlugin, err := collector.NewPrometheusCollectorPlugin(clients.Kubernetes, clients.ArgoRollouts, o.PrometheusServer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize prometheus collector plugin: %v", err)
+		}
+
+		promPlugin.SetSamplesWarningThreshold(o.PrometheusSamplesWarningThreshold)
+		err = collectorFactory.RegisterObjectCollector("", "prometheus", promPlugin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to register prometheus object collector plugin: %v", err)
+		}
+
+		collectorFactory.RegisterExternalCollector([]string{collector.PrometheusMetricType, collector.PrometheusMetricNameLegacy}, promPlugin)
+
+		err = collectorFactory.RegisterPodsCollector(collector.PrometheusMetricType, promPlugin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to register prometheus pods collector plugin: %v", err)
+		}
+
+		// the processing rate of the time-to-drain collector is
+		// collected from prometheus, the backlog from the SQS or Nakadi
+		// plugin if registered.
+		collectorFactory.RegisterExternalCollector([]string{collector.TimeToDrainMetricType}, collector.NewTimeToDrainCollectorPlugin(collectorFactory))
+
+		// skipper collector can only be enabled if prometheus is.
+		if o.SkipperIngressMetrics || o.SkipperRouteGroupMetrics {
+			skipperPlugin, err := collector.NewSkipperCollectorPlugin(clients.Kubernetes, clients.RouteGroup, promPlugin, o.SkipperBackendWeightAnnotation)
+			if err != nil {
+				return nil, fmt.Errorf("failed to initialize skipper collector plugin: %v", err)
+			}
+
+			if o.SkipperIngressMetrics {
+				err = collectorFactory.RegisterObjectCollector("Ingress", "", skipperPlugin)
+				if err != nil {
+					return nil, fmt.Errorf("failed to register skipper Ingress collector plugin: %v", err)
+				}
+			}
+
+			if o.SkipperRouteGroupMetrics {
+				err = collectorFactory.RegisterObjectCollector("RouteGroup", "", skipperPlugin)
+				if err != nil {
+					return nil, fmt.Errorf("failed to register skipper RouteGroup collector plugin: %v", err)
+				}
+			}
+		}
+
+		// External RPS collector, like skipper's, depends on prometheus being enabled.
+		// Also, to enable hostname metric its necessary to pass the metric name that
+		// will be used. This was built this way so we can support hostname metrics to
+		// any ingress provider, e.g. Skipper, Nginx, envoy etc, in a simple way.
+		if o.ExternalRPSMetrics && o.ExternalRPSMetricName != "" {
+			externalRPSPlugin, err := collector.NewExternalRPSCollectorPlugin(promPlugin, o.ExternalRPSMetricName)
+			collectorFactory.RegisterExternalCollector([]string{collector.ExternalRPSMetricType}, externalRPSPlugin)
+			if err != nil {
+				return nil, fmt.Errorf("failed to register hostname collector plugin: %v", err)
+			}
+		}
+	}
+
+	if o.InfluxDBAddress != "" {
+		influxdbPlugin, err := collector.NewInfluxDBCollectorPlugin(clients.Kubernetes, clients.ArgoRollouts, o.InfluxDBAddress, o.InfluxDBToken, o.InfluxDBOrg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize InfluxDB collector plugin: %v", err)
+		}
+		collectorFactory.RegisterExternalCollector([]string{collector.InfluxDBMetricType, collector.InfluxDBMetricNameLegacy}, influxdbPlugin)
+
+		err = collectorFactory.RegisterObjectCollector("", collector.InfluxDBMetricType, influxdbPlugin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to register InfluxDB object collector plugin: %v", err)
+		}
+	}
+
+	httpmetrics.DefaultMaxResponseSize = o.MaxResponseSize
+	httpEndpointPolicy, err := httpmetrics.NewEndpointPolicy(o.HTTPCollectorAllowedCIDRs, o.HTTPCollectorDeniedCIDRs, o.HTTPCollectorAllowedSchemes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize HTTP collector endpoint policy: %v", err)
+	}
+	plugin, _ := collector.NewHTTPCollectorPlugin(httpEndpointPolicy)
+	collectorFactory.RegisterExternalCollector([]string{collector.HTTPJSONPathType, collector.HTTPMetricNameLegacy}, plugin)
+	collectorFactory.RegisterExternalCollector([]string{collector.HTTPProbeMetricType}, collector.NewHTTPProbeCollectorPlugin(httpEndpointPolicy))
+	// register generic pod collector
+	podPlugin := collector.NewPodCollectorPlugin(clients.Kubernetes, clients.ArgoRollouts)
+	if o.PushAddress != "" {
+		if o.PushTTL <= 0 {
+			return nil, fmt.Errorf("--push-ttl must be positive when --push-address is set")
+		}
+		pushBuffer := collector.NewPushBuffer(o.PushTTL)
+		err = StartPushReceiver(ctx, o.PushAddress, pushBuffer)
+		if err != nil {
+			return nil, err
+		}
+		podPlugin.SetPushBuffer(pushBuffer)
+		err = collectorFactory.RegisterObjectCollector("", collector.PushedCollectorType, podPlugin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to register pod collector plugin: %v", err)
+		}
+	}
+	err = collectorFactory.RegisterPodsCollector("", podPlugin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register pod collector plugin: %v", err)
+	}
+	// the pod collector serves the aggregate of the pod values as Object
+	// metric of the scale target.
+	for _, collectorType := range []string{collector.HTTPJSONPathType, collector.KubeletCollectorType, collector.PrometheusExpositionCollectorType} {
+		err = collectorFactory.RegisterObjectCollector("", collectorType, podPlugin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to register pod collector plugin: %v", err)
+		}
+	}
+
+	// enable ZMON based metrics
+	if o.ZMONKariosDBEndpoint != "" {
+		var tokenSource oauth2.TokenSource
+		if o.Token != "" {
+			tokenSource = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: o.Token})
+		} else {
+			tokenSource = platformiam.NewTokenSource(o.ZMONTokenName, o.CredentialsDir)
+		}
+
+		httpClient := newOauth2HTTPClient(ctx, tokenSource, o.ExternalClientTimeout)
+
+		zmonClient := zmon.NewZMONClient(o.ZMONKariosDBEndpoint, httpClient)
+		zmonClient.SetMaxResponseSize(o.MaxResponseSize)
+
+		var zmonCheckAliases *zmon.CheckAliases
+		if o.ZMONCheckAliases != "" {
+			zmonCheckAliases, err = watchZMONCheckAliases(ctx, clients.Kubernetes, o.ZMONCheckAliases)
+			if err != nil {
+				return nil, fmt.Errorf("failed to watch ZMON check aliases: %v", err)
+			}
+		}
+
+		zmonPlugin, err := collector.NewZMONCollectorPlugin(zmonClient, zmonCheckAliases)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize ZMON collector plugin: %v", err)
+		}
+
+		collectorFactory.RegisterExternalCollector([]string{collector.ZMONMetricType, collector.ZMONCheckMetricLegacy}, zmonPlugin)
+	}
+
+	// enable Nakadi based metrics
+	if o.NakadiEndpoint != "" {
+		var tokenSource oauth2.TokenSource
+		if o.Token != "" {
+			tokenSource = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: o.Token})
+		} else {
+			tokenSource = platformiam.NewTokenSource(o.NakadiTokenName, o.CredentialsDir)
+		}
+
+		httpClient := newOauth2HTTPClient(ctx, tokenSource, o.ExternalClientTimeout)
+
+		nakadiClient := nakadi.NewNakadiClient(o.NakadiEndpoint, httpClient)
+		nakadiClient.SetMaxResponseSize(o.MaxResponseSize)
+
+		nakadiPlugin, err := collector.NewNakadiCollectorPlugin(nakadiClient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize Nakadi collector plugin: %v", err)
+		}
+
+		collectorFactory.RegisterExternalCollector([]string{collector.NakadiMetricType}, nakadiPlugin)
+	}
+
+	// enable SQL based metrics
+	if o.SQLDriver != "" {
+		db, redact, err := collector.OpenSQLDB(o.SQLDriver, o.SQLDSNFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize SQL connection pool: %v", err)
+		}
+
+		sqlPlugin, err := collector.NewSQLCollectorPlugin(db, redact, o.SQLQueryTimeout, o.SQLMinQueryInterval)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize SQL collector plugin: %v", err)
+		}
+
+		collectorFactory.RegisterExternalCollector([]string{collector.SQLMetricType}, sqlPlugin)
+	}
+
+	// enable Redis based metrics
+	if o.RedisAddress != "" {
+		options := redis.Options{Timeout: o.RedisTimeout}
+		if o.RedisPasswordFile != "" {
+			options.Password, err = collector.ReadRedisPassword(o.RedisPasswordFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to initialize Redis collector plugin: %v", err)
+			}
+		}
+		if o.RedisTLS {
+			options.TLSConfig, err = collector.NewRedisTLSConfig(o.RedisTLSCAFile, o.RedisTLSInsecureSkipVerify)
+			if err != nil {
+				return nil, fmt.Errorf("failed to initialize Redis collector plugin: %v", err)
+			}
+		}
+
+		redisPlugin, err := collector.NewRedisCollectorPlugin(o.RedisAddress, o.RedisAllowedAddresses, options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize Redis collector plugin: %v", err)
+		}
+
+		collectorFactory.RegisterExternalCollector([]string{collector.RedisMetricType}, redisPlugin)
+	}
+
+	if o.AWSExternalMetrics {
+		awsPlugin, err := collector.NewAWSCollectorPlugin(context.TODO(), o.AWSRegions, collector.LoadAWSConfig, o.AWSAllowDynamicRegions, o.AWSSessionRefreshInterval)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize AWS collector plugin: %v", err)
+		}
+		collectorFactory.RegisterExternalCollector([]string{collector.AWSSQSQueueLengthMetric}, awsPlugin)
+	}
+
+	if o.ScalingScheduleMetrics {
+		available, err := scalingSchedulesAvailable(clients.ScalingSchedule.Discovery())
+		if err != nil {
+			// only defer the setup if the CRDs are known to be missing.
+			klog.Warningf("Failed to discover the ScalingSchedule API, enabling its support: %v", err)
+			available = true
+		}
+
+		if available {
+			err = startScalingSchedules(ctx, o, clients, collectorFactory)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			klog.Warningf("ScalingSchedule and ClusterScalingSchedule CRDs are not installed, scaling schedules are enabled once they're installed (checking every %s)", scalingSchedulesDiscoveryInterval)
+			go waitForScalingSchedules(ctx, o, clients, collectorFactory)
+		}
+	}
+
+	return collectorFactory, nil
+}
+
+// scalingSchedulesDiscoveryInterval is the interval in which the API server
+// is probed for the ScalingSchedule CRDs if they're not installed at startup.
+var scalingSchedulesDiscoveryInterval = time.Minute
+
+// scalingSchedulesAvailable returns whether the API server serves the
+// ScalingSchedule and ClusterScalingSchedule resources, i.e. whether their
+// CRDs are installed.
+func scalingSchedulesAvailable(client discovery.DiscoveryInterface) (bool, error) {
+	resources, err := client.ServerResourcesForGroupVersion(v1.SchemeGroupVersion.String())
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	found := map[string]bool{}
+	for _, resource := range resources.APIResources {
+		found[resource.Name] = true
+	}
+	return found["scalingschedules"] && found["clusterscalingschedules"], nil
+}
+
+// waitForScalingSchedules probes the API server for the ScalingSchedule CRDs
+// until they're installed or the context is canceled and starts the scaling
+// schedules once they are. Failures to start them are retried on the next
+// tick. Until then HPAs referencing scaling schedules fail to create their
+// collectors and are retried by the provider.
+func waitForScalingSchedules(ctx context.Context, o AdapterServerOptions, clients *Clients, collectorFactory *collector.CollectorFactory) {
+	ticker := time.NewTicker(scalingSchedulesDiscoveryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		available, err := scalingSchedulesAvailable(clients.ScalingSchedule.Discovery())
+		if err != nil {
+			klog.Warningf("Failed to discover the ScalingSchedule API: %v", err)
+			continue
+		}
+		if !available {
+			continue
+		}
+
+		klog.Info("ScalingSchedule and ClusterScalingSchedule CRDs are installed, enabling scaling schedules")
+		err = startScalingSchedules(ctx, o, clients, collectorFactory)
+		if err != nil {
+			klog.Errorf("Failed to enable scaling schedules, retrying in %s: %v", scalingSchedulesDiscoveryInterval, err)
+			continue
+		}
+		return
+	}
+}
+
+// startScalingSchedules registers the ScalingSchedule and
+// ClusterScalingSchedule collector plugins and starts their informers and
+// the scheduled scaling controller, which are stopped when the context is
+// canceled. The plugins are only registered once everything else is set up,
+// on failure the informers are stopped and nothing is registered.
+func startScalingSchedules(ctx context.Context, o AdapterServerOptions, clients *Clients, collectorFactory *collector.CollectorFactory) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		if err != nil {
+			cancel()
+		}
+	}()
+
+	// use informers rather than plain reflectors to get proper
+	// handling of deletions missed while the watch was
+	// disconnected.
+	informerFactory := externalversions.NewSharedInformerFactory(clients.ScalingSchedule, 0)
+	clusterScalingSchedulesStore := informerFactory.Zalando().V1().ClusterScalingSchedules().Informer().GetStore()
+	scalingSchedulesInformer := informerFactory.Zalando().V1().ScalingSchedules().Informer()
+	scalingSchedulesStore := scalingSchedulesInformer.GetStore()
+	informerFactory.Start(ctx.Done())
+
+	now := scheduledscaling.OffsetNow(o.TimeOffset)
+
+	clusterPlugin, err := collector.NewClusterScalingScheduleCollectorPlugin(clusterScalingSchedulesStore, now, o.DefaultScheduledScalingWindow, o.DefaultTimeZone, o.RampSteps)
+	if err != nil {
+		return fmt.Errorf("unable to create ClusterScalingScheduleCollector plugin: %v", err)
+	}
+	clusterPlugin.SetMaxScheduleDuration(o.ScalingScheduleMaxDuration)
+
+	plugin, err := collector.NewScalingScheduleCollectorPlugin(scalingSchedulesStore, now, o.DefaultScheduledScalingWindow, o.DefaultTimeZone, o.RampSteps)
+	if err != nil {
+		return fmt.Errorf("unable to create ScalingScheduleCollector plugin: %v", err)
+	}
+	plugin.SetMaxScheduleDuration(o.ScalingScheduleMaxDuration)
+	plugin.SetStoreSynced(scalingSchedulesInformer.HasSynced)
+
+	scaler, err := scheduledscaling.NewHPATargetScaler(ctx, clients.Kubernetes, clients.Config)
+	if err != nil {
+		return fmt.Errorf("unable to create HPA target scaler: %w", err)
+	}
+
+	err = collectorFactory.RegisterObjectCollector("ClusterScalingSchedule", "", clusterPlugin)
+	if err != nil {
+		return fmt.Errorf("failed to register ClusterScalingSchedule object collector plugin: %v", err)
+	}
+	err = collectorFactory.RegisterObjectCollector("ScalingSchedule", "", plugin)
+	if err != nil {
+		return fmt.Errorf("failed to register ScalingSchedule object collector plugin: %v", err)
+	}
+
+	// setup ScheduledScaling controller to continuously update
+	// status of ScalingSchedule and ClusterScalingSchedule
+	// resources.
+	scheduledScalingController := scheduledscaling.NewController(
+		clients.ScalingSchedule.ZalandoV1(),
+		clients.Kubernetes,
+		scaler,
+		scalingSchedulesStore,
+		clusterScalingSchedulesStore,
+		now,
+		o.DefaultScheduledScalingWindow,
+		o.DefaultTimeZone,
+		o.HorizontalPodAutoscalerTolerance,
+	)
+	scheduledScalingController.SetPauseAnnotation(o.HPAPauseAnnotation)
+	scheduledScalingController.SetMaxScheduleDuration(o.ScalingScheduleMaxDuration)
+	scheduledScalingController.SetMaxScalesPerRun(o.ScalingScheduleMaxScalesPerRun)
+	scheduledScalingController.SetConflictFactor(o.ScalingScheduleConflictFactor)
+	scheduledScalingController.SetMaxScaleUpFactor(o.ScalingScheduleMaxScaleUpFactor)
+	scheduledScalingController.SetReplicaCeiling(o.ScalingScheduleMaxReplicas)
+	if o.ScalingScheduleValueMetrics {
+		scheduledScalingController.EnableValueMetrics()
+	}
+	if o.EventDeduplicationWindow > 0 {
+		scheduledScalingController.EnableEventDeduplication(o.EventDeduplicationWindow)
+	}
+
+	go scheduledScalingController.Run(ctx)
+	return nil
+}
+
+// Providers are the metrics providers served by the adapter.
+type Providers struct {
+	// HPA is the provider collecting the metrics of all HPAs. It must be
+	// run for the metrics to be collected.
+	HPA *provider.HPAProvider
+	// CustomMetrics serves the Custom Metrics API, nil if disabled.
+	CustomMetrics *provider.HPAProvider
+	// ExternalMetrics serves the External Metrics API, nil if disabled.
+	ExternalMetrics *provider.HPAProvider
+}
+
+// BuildProviders initializes the metrics providers using the collector
+// factory. Collector plugins depending on the provider state are registered
+// to the factory.
+func BuildProviders(collectorFactory *collector.CollectorFactory, o AdapterServerOptions, clients *Clients) (*Providers, error) {
+	hpaProvider := provider.NewHPAProvider(clients.Kubernetes, 30*time.Second, o.CollectorInterval, collectorFactory, o.DisregardIncompatibleHPAs, o.MetricsTTL, o.GCInterval)
+
+	// the self collector is computed from the collector scheduler state
+	// of the HPA provider.
+	if o.SelfMetrics {
+		selfPlugin, err := collector.NewSelfCollectorPlugin(hpaProvider)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize self collector plugin: %v", err)
+		}
+		collectorFactory.RegisterExternalCollector([]string{collector.SelfMetricType}, selfPlugin)
+	}
+
+	if o.RecordQueries {
+		hpaProvider.EnableQueryRecording(recordedQueriesPerMetric)
+	}
+
+	hpaProvider.SetPauseAnnotation(o.HPAPauseAnnotation)
+
+	if o.HPARemovalThreshold < 0 || o.HPARemovalThreshold > 1 {
+		return nil, fmt.Errorf("--hpa-removal-threshold must be between 0 and 1, got %v", o.HPARemovalThreshold)
+	}
+	if o.HPARemovalThreshold > 0 {
+		hpaProvider.SetRemovalThreshold(o.HPARemovalThreshold)
+	}
+
+	if o.StateFile != "" {
+		if o.StateSaveInterval <= 0 {
+			return nil, fmt.Errorf("--state-save-interval must be positive when --state-file is set")
+		}
+		hpaProvider.EnableStatePersistence(o.StateFile, o.StateSaveInterval)
+	}
+
+	if o.AuditLogPath != "" {
+		if err := hpaProvider.EnableAuditLog(o.AuditLogPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.AllowClusterScopedExternalMetrics {
+		hpaProvider.AllowClusterScopedExternalMetrics()
+	}
+
+	if o.ShardingTotal > 1 {
+		sharding, err := newSharding(o.ShardingTotal, o.ShardingIndex)
+		if err != nil {
+			return nil, err
+		}
+		hpaProvider.EnableSharding(sharding)
+	}
+
+	if o.DesiredReplicasMetric {
+		hpaProvider.EnableDesiredReplicasMetric(o.HorizontalPodAutoscalerTolerance)
+	}
+
+	if o.WriteStatusAnnotations {
+		hpaProvider.EnableStatusAnnotations()
+	}
+
+	if o.MaxReplicasDetection {
+		hpaProvider.EnableMaxReplicasDetection(o.HorizontalPodAutoscalerTolerance)
+	}
+
+	if o.NamespaceDefaults {
+		hpaProvider.EnableNamespaceDefaults(informers.NewSharedInformerFactory(clients.Kubernetes, 0))
+	}
+
+	if o.EventDeduplicationWindow > 0 {
+		hpaProvider.EnableEventDeduplication(o.EventDeduplicationWindow)
+	}
+
+	if o.MaxCollectionTimeout < 0 {
+		return nil, fmt.Errorf("--max-collection-timeout must not be negative, got %s", o.MaxCollectionTimeout)
+	}
+	hpaProvider.SetMaxCollectionTimeout(o.MaxCollectionTimeout)
+
+	err := hpaProvider.SuppressEventReasons(o.SuppressEventReasons)
+	if err != nil {
+		return nil, fmt.Errorf("invalid suppressed event reasons: %v", err)
+	}
+
+	providers := &Providers{
+		HPA:             hpaProvider,
+		CustomMetrics:   hpaProvider,
+		ExternalMetrics: hpaProvider,
+	}
+
+	if !o.EnableCustomMetricsAPI {
+		providers.CustomMetrics = nil
+	}
+	if !o.EnableExternalMetricsAPI {
+		providers.ExternalMetrics = nil
+	}
+
+	return providers, nil
+}
+
+// newSharding initializes the sharding of the adapter replica. A negative
+// index is derived from the ordinal of the StatefulSet pod.
+func newSharding(total, index int) (*provider.Sharding, error) {
+	if index < 0 {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the hostname to derive the shard index: %v", err)
+		}
+		index, err = provider.ShardIndexFromHostname(hostname)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive the shard index, set --sharding-index: %v", err)
+		}
+	}
+
+	sharding, err := provider.NewSharding(total, index)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sharding: %v", err)
+	}
+	return sharding, nil
+}
+
+// RunServer runs the HPA provider and serves the Custom and External
+// Metrics APIs until the context is canceled.
+func RunServer(ctx context.Context, providers *Providers, o AdapterServerOptions, clients *Clients) error {
+	serverConfig := genericapiserver.NewRecommendedConfig(apiserver.Codecs)
+	serverConfig.ClientConfig = clients.Config
+	err := o.CustomMetricsAdapterServerOptions.ApplyTo(serverConfig)
+	if err != nil {
+		return err
+	}
+
+	config := &apiserver.Config{
+		GenericConfig: &serverConfig.Config,
+	}
+
+	config.GenericConfig.OpenAPIConfig = openAPIConfig()
+
+	http.Handle("/debug/collectors", providers.HPA.DebugCollectorsHandler())
+	if o.HPASummaryAPI {
+		http.Handle(provider.HPASummaryPattern, providers.HPA.HPASummaryHandler())
+	}
+	if o.DebugQueryAPI {
+		http.Handle(provider.DryRunPattern, providers.HPA.DryRunHandler(dryRunQueryTimeout))
+	}
+
+	go providers.HPA.Run(ctx)
+
+	informer := informers.NewSharedInformerFactory(clients.Kubernetes, 0)
+
+	// the same provider implements both Custom Metrics API and External Metrics API
+	server, err := config.Complete(informer).New("kube-metrics-adapter", providers.CustomMetrics, providers.ExternalMetrics)
+	if err != nil {
+		return err
+	}
+	return server.GenericAPIServer.PrepareRun().RunWithContext(ctx)
+}
+
+// StartMetricsServer binds the metrics listener on the address and serves the
+// handler in the background until the context is canceled. Binding happens
+// synchronously so that an address already in use is returned as an error.
+// An empty address disables the listener.
+func StartMetricsServer(ctx context.Context, address string, handler http.Handler) error {
+	if address == "" {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on metrics address %s: %w", address, err)
+	}
+
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: metricsServerReadHeaderTimeout,
+	}
+
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			klog.Errorf("Metrics server failed: %v", err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), metricsServerShutdownTimeout)
+		defer cancel()
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			klog.Errorf("Failed to shut down metrics server: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// StartPushReceiver binds the TCP and UDP listeners of the push receiver on
+// the address and serves them in the background until the context is
+// canceled. Pods push values with HTTP POST requests or statsd-style gauges
+// via UDP.
+func StartPushReceiver(ctx context.Context, address string, buffer *collector.PushBuffer) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on push address %s: %w", address, err)
+	}
+
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to listen on push address %s: %w", address, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(collector.PushPathPrefix, buffer)
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: metricsServerReadHeaderTimeout,
+	}
+
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			klog.Errorf("Push receiver failed: %v", err)
+		}
+	}()
+
+	go func() {
+		err := buffer.ServePackets(conn)
+		if err != nil {
+			klog.Errorf("Push receiver failed: %v", err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), metricsServerShutdownTimeout)
+		defer cancel()
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			klog.Errorf("Failed to shut down push receiver: %v", err)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,669 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	argorolloutsfake "github.com/argoproj/argo-rollouts/pkg/client/clientset/versioned/fake"
+	"github.com/stretchr/testify/require"
+	rgfake "github.com/szuecs/routegroup-client/client/clientset/versioned/fake"
+	v1 "github.com/zalando-incubator/kube-metrics-adapter/pkg/apis/zalando.org/v1"
+	zfake "github.com/zalando-incubator/kube-metrics-adapter/pkg/client/clientset/versioned/fake"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
+	"golang.org/x/oauth2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newFakeClients() *Clients {
+	scalingScheduleClient := zfake.NewSimpleClientset()
+	scalingScheduleClient.Resources = []*metav1.APIResourceList{{
+		GroupVersion: v1.SchemeGroupVersion.String(),
+		APIResources: []metav1.APIResource{
+			{Name: "scalingschedules", Namespaced: true, Kind: "ScalingSchedule"},
+			{Name: "clusterscalingschedules", Kind: "ClusterScalingSchedule"},
+		},
+	}}
+
+	return &Clients{
+		Config:          &rest.Config{Host: "http://localhost"},
+		Kubernetes:      fake.NewSimpleClientset(),
+		ArgoRollouts:    argorolloutsfake.NewSimpleClientset(),
+		RouteGroup:      rgfake.NewSimpleClientset(),
+		ScalingSchedule: scalingScheduleClient,
+	}
+}
+
+func TestBuildCollectorFactory(t *testing.T) {
+	defaultPlugins := []string{
+		"external/http",
+		"external/http-probe",
+		"external/json-path",
+		"object/*/json-path",
+		"object/*/kubelet",
+		"object/*/prometheus-exposition",
+		"pods/*",
+	}
+
+	for _, tc := range []struct {
+		msg             string
+		options         AdapterServerOptions
+		expectedPlugins []string
+	}{
+		{
+			msg:             "default plugins",
+			expectedPlugins: defaultPlugins,
+		},
+		{
+			msg: "prometheus",
+			options: AdapterServerOptions{
+				PrometheusServer: "http://prometheus",
+			},
+			expectedPlugins: append([]string{
+				"external/prometheus",
+				"external/prometheus-query",
+				"external/time-to-drain",
+				"object/*/prometheus",
+				"pods/prometheus",
+			}, defaultPlugins...),
+		},
+		{
+			msg: "skipper and external RPS without prometheus",
+			options: AdapterServerOptions{
+				SkipperIngressMetrics:    true,
+				SkipperRouteGroupMetrics: true,
+				ExternalRPSMetrics:       true,
+				ExternalRPSMetricName:    "skipper_serve_host_duration_seconds_count",
+			},
+			expectedPlugins: defaultPlugins,
+		},
+		{
+			msg: "prometheus with skipper ingress",
+			options: AdapterServerOptions{
+				PrometheusServer:      "http://prometheus",
+				SkipperIngressMetrics: true,
+			},
+			expectedPlugins: append([]string{
+				"external/prometheus",
+				"external/prometheus-query",
+				"external/time-to-drain",
+				"object/*/prometheus",
+				"pods/prometheus",
+				"object/Ingress/*",
+			}, defaultPlugins...),
+		},
+		{
+			msg: "prometheus with skipper routegroup",
+			options: AdapterServerOptions{
+				PrometheusServer:         "http://prometheus",
+				SkipperRouteGroupMetrics: true,
+			},
+			expectedPlugins: append([]string{
+				"external/prometheus",
+				"external/prometheus-query",
+				"external/time-to-drain",
+				"object/*/prometheus",
+				"pods/prometheus",
+				"object/RouteGroup/*",
+			}, defaultPlugins...),
+		},
+		{
+			msg: "prometheus with external RPS",
+			options: AdapterServerOptions{
+				PrometheusServer:      "http://prometheus",
+				ExternalRPSMetrics:    true,
+				ExternalRPSMetricName: "skipper_serve_host_duration_seconds_count",
+			},
+			expectedPlugins: append([]string{
+				"external/prometheus",
+				"external/prometheus-query",
+				"external/time-to-drain",
+				"external/requests-per-second",
+				"object/*/prometheus",
+				"pods/prometheus",
+			}, defaultPlugins...),
+		},
+		{
+			msg: "prometheus with external RPS without metric name",
+			options: AdapterServerOptions{
+				PrometheusServer:   "http://prometheus",
+				ExternalRPSMetrics: true,
+			},
+			expectedPlugins: append([]string{
+				"external/prometheus",
+				"external/prometheus-query",
+				"external/time-to-drain",
+				"object/*/prometheus",
+				"pods/prometheus",
+			}, defaultPlugins...),
+		},
+		{
+			msg: "influxdb",
+			options: AdapterServerOptions{
+				InfluxDBAddress: "http://influxdb",
+			},
+			expectedPlugins: append([]string{
+				"external/flux-query",
+				"external/influxdb",
+				"object/*/influxdb",
+			}, defaultPlugins...),
+		},
+		{
+			msg: "zmon",
+			options: AdapterServerOptions{
+				ZMONKariosDBEndpoint: "http://zmon",
+				ZMONCheckAliases:     "kube-system/zmon-check-aliases",
+				Token:                "token",
+			},
+			expectedPlugins: append([]string{
+				"external/zmon",
+				"external/zmon-check",
+			}, defaultPlugins...),
+		},
+		{
+			msg: "nakadi",
+			options: AdapterServerOptions{
+				NakadiEndpoint: "http://nakadi",
+				Token:          "token",
+			},
+			expectedPlugins: append([]string{
+				"external/nakadi",
+			}, defaultPlugins...),
+		},
+		{
+			msg: "aws",
+			options: AdapterServerOptions{
+				AWSExternalMetrics: true,
+			},
+			expectedPlugins: append([]string{
+				"external/sqs-queue-length",
+			}, defaultPlugins...),
+		},
+		{
+			msg: "scaling schedules",
+			options: AdapterServerOptions{
+				ScalingScheduleMetrics:        true,
+				DefaultScheduledScalingWindow: 10 * time.Minute,
+				RampSteps:                     10,
+				DefaultTimeZone:               "Europe/Berlin",
+			},
+			expectedPlugins: append([]string{
+				"object/ClusterScalingSchedule/*",
+				"object/ScalingSchedule/*",
+			}, defaultPlugins...),
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			factory, err := BuildCollectorFactory(ctx, tc.options, newFakeClients())
+			require.NoError(t, err)
+			require.ElementsMatch(t, tc.expectedPlugins, factory.RegisteredPlugins())
+		})
+	}
+}
+
+func TestBuildCollectorFactoryScalingSchedulesInstalledLater(t *testing.T) {
+	interval := scalingSchedulesDiscoveryInterval
+	scalingSchedulesDiscoveryInterval = 10 * time.Millisecond
+	defer func() { scalingSchedulesDiscoveryInterval = interval }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clients := newFakeClients()
+	var installed atomic.Bool
+	var probes atomic.Int32
+	clients.ScalingSchedule.(*zfake.Clientset).PrependReactor("get", "resource", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+		probes.Add(1)
+		if !installed.Load() {
+			return true, nil, apierrors.NewNotFound(v1.Resource("scalingschedules"), "")
+		}
+		return false, nil, nil
+	})
+
+	factory, err := BuildCollectorFactory(ctx, AdapterServerOptions{
+		ScalingScheduleMetrics:        true,
+		DefaultScheduledScalingWindow: 10 * time.Minute,
+		RampSteps:                     10,
+		DefaultTimeZone:               "Europe/Berlin",
+	}, clients)
+	require.NoError(t, err)
+	require.NotContains(t, factory.RegisteredPlugins(), "object/ScalingSchedule/*")
+
+	// the plugins are not registered while the CRDs are missing.
+	require.Eventually(t, func() bool { return probes.Load() > 3 }, time.Second, time.Millisecond)
+	require.NotContains(t, factory.RegisteredPlugins(), "object/ScalingSchedule/*")
+
+	installed.Store(true)
+	require.Eventually(t, func() bool {
+		plugins := factory.RegisteredPlugins()
+		return slices.Contains(plugins, "object/ScalingSchedule/*") && slices.Contains(plugins, "object/ClusterScalingSchedule/*")
+	}, time.Second, time.Millisecond)
+
+	// the probing stops once the plugins are registered.
+	registered := probes.Load()
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, registered, probes.Load())
+}
+
+func TestBuildCollectorFactoryScalingSchedulesStartRetried(t *testing.T) {
+	interval := scalingSchedulesDiscoveryInterval
+	scalingSchedulesDiscoveryInterval = 10 * time.Millisecond
+	defer func() { scalingSchedulesDiscoveryInterval = interval }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clients := newFakeClients()
+	// the HPA target scaler can't be created without the CA file.
+	clients.Config = &rest.Config{Host: "https://localhost", TLSClientConfig: rest.TLSClientConfig{CAFile: filepath.Join(t.TempDir(), "missing.crt")}}
+	var installed atomic.Bool
+	var probes atomic.Int32
+	clients.ScalingSchedule.(*zfake.Clientset).PrependReactor("get", "resource", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+		probes.Add(1)
+		if !installed.Load() {
+			return true, nil, apierrors.NewNotFound(v1.Resource("scalingschedules"), "")
+		}
+		return false, nil, nil
+	})
+
+	factory, err := BuildCollectorFactory(ctx, AdapterServerOptions{
+		ScalingScheduleMetrics:        true,
+		DefaultScheduledScalingWindow: 10 * time.Minute,
+		RampSteps:                     10,
+		DefaultTimeZone:               "Europe/Berlin",
+	}, clients)
+	require.NoError(t, err)
+
+	// failures to start the scaling schedules are retried without
+	// registering any of the plugins.
+	installed.Store(true)
+	started := probes.Load()
+	require.Eventually(t, func() bool { return probes.Load() > started+3 }, time.Second, time.Millisecond)
+	require.NotContains(t, factory.RegisteredPlugins(), "object/ScalingSchedule/*")
+	require.NotContains(t, factory.RegisteredPlugins(), "object/ClusterScalingSchedule/*")
+}
+
+func TestScalingSchedulesAvailable(t *testing.T) {
+	for _, tc := range []struct {
+		msg       string
+		resources []metav1.APIResource
+		expected  bool
+	}{
+		{
+			msg: "both CRDs installed",
+			resources: []metav1.APIResource{
+				{Name: "scalingschedules"},
+				{Name: "clusterscalingschedules"},
+			},
+			expected: true,
+		},
+		{
+			msg:       "only ScalingSchedule installed",
+			resources: []metav1.APIResource{{Name: "scalingschedules"}},
+		},
+		{
+			msg: "group version not served",
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			client := zfake.NewSimpleClientset()
+			if tc.resources != nil {
+				client.Resources = []*metav1.APIResourceList{{
+					GroupVersion: v1.SchemeGroupVersion.String(),
+					APIResources: tc.resources,
+				}}
+			}
+
+			available, err := scalingSchedulesAvailable(client.Discovery())
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, available)
+		})
+	}
+}
+
+func TestBuildCollectorFactoryInvalidHTTPCollectorPolicy(t *testing.T) {
+	_, err := BuildCollectorFactory(context.Background(), AdapterServerOptions{
+		HTTPCollectorDeniedCIDRs: []string{"invalid"},
+	}, newFakeClients())
+	require.Error(t, err)
+}
+
+func TestBuildCollectorFactoryPushReceiver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := BuildCollectorFactory(ctx, AdapterServerOptions{PushAddress: "127.0.0.1:0"}, newFakeClients())
+	require.Error(t, err)
+
+	factory, err := BuildCollectorFactory(ctx, AdapterServerOptions{PushAddress: "127.0.0.1:0", PushTTL: time.Minute}, newFakeClients())
+	require.NoError(t, err)
+	require.Contains(t, factory.RegisteredPlugins(), "object/*/pushed")
+}
+
+func TestStartPushReceiver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// reserve a port free for TCP, the UDP port is very likely free as well.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	buffer := collector.NewPushBuffer(time.Minute)
+	require.NoError(t, StartPushReceiver(ctx, address, buffer))
+
+	resp, err := http.Post("http://"+address+"/push/default/pod-a/queue-size", "text/plain", strings.NewReader("10"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	conn, err := net.Dial("udp", address)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("default/pod-b/queue-size:20|g"))
+	require.NoError(t, err)
+
+	value, _, ok := buffer.Get("default", "pod-a", "queue-size", []string{"127.0.0.1"})
+	require.True(t, ok)
+	require.Equal(t, 10.0, value)
+	require.Eventually(t, func() bool {
+		value, _, ok := buffer.Get("default", "pod-b", "queue-size", []string{"127.0.0.1"})
+		return ok && value == 20
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestBuildCollectorFactoryChaosMode(t *testing.T) {
+	o := AdapterServerOptions{
+		ChaosMode:        true,
+		ChaosFailureRate: 0.5,
+	}
+
+	t.Setenv(collector.ChaosEnvVar, "")
+	_, err := BuildCollectorFactory(context.Background(), o, newFakeClients())
+	require.Error(t, err)
+
+	t.Setenv(collector.ChaosEnvVar, "true")
+	_, err = BuildCollectorFactory(context.Background(), o, newFakeClients())
+	require.NoError(t, err)
+
+	o.ChaosFailureRate = 2
+	_, err = BuildCollectorFactory(context.Background(), o, newFakeClients())
+	require.Error(t, err)
+}
+
+func TestBuildProviders(t *testing.T) {
+	clients := newFakeClients()
+	factory, err := BuildCollectorFactory(context.Background(), AdapterServerOptions{}, clients)
+	require.NoError(t, err)
+
+	providers, err := BuildProviders(factory, AdapterServerOptions{
+		EnableExternalMetricsAPI: true,
+		SelfMetrics:              true,
+	}, clients)
+	require.NoError(t, err)
+	require.NotNil(t, providers.HPA)
+	require.Nil(t, providers.CustomMetrics)
+	require.Equal(t, providers.HPA, providers.ExternalMetrics)
+	require.Contains(t, factory.RegisteredPlugins(), "external/kube-metrics-adapter-self")
+}
+
+func TestStartMetricsServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// an empty address disables the listener
+	require.NoError(t, StartMetricsServer(ctx, "", http.NotFoundHandler()))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	// a bind conflict is returned as an error
+	err = StartMetricsServer(ctx, listener.Addr().String(), http.NotFoundHandler())
+	require.Error(t, err)
+
+	require.NoError(t, StartMetricsServer(ctx, "127.0.0.1:0", http.NotFoundHandler()))
+}
+
+func TestStartMetricsServerShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, StartMetricsServer(ctx, address, http.NotFoundHandler()))
+
+	resp, err := http.Get("http://" + address)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	cancel()
+
+	// the address is released after shutdown
+	require.Eventually(t, func() bool {
+		l, err := net.Listen("tcp", address)
+		if err != nil {
+			return false
+		}
+		l.Close()
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func writeKubeconfig(t *testing.T, server string) string {
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	require.NoError(t, os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: `+server+`
+contexts:
+- name: test
+  context:
+    cluster: test
+current-context: test
+`), 0600))
+	return kubeconfig
+}
+
+func TestNewClientsRateLimits(t *testing.T) {
+	clients, err := NewClients(AdapterServerOptions{
+		RemoteKubeConfigFile: writeKubeconfig(t, "http://localhost"),
+		DisableArgoRollouts:  true,
+		KubeAPIQPS:           25,
+		KubeAPIBurst:         50,
+	})
+	require.NoError(t, err)
+	require.Equal(t, float32(25), clients.Config.QPS)
+	require.Equal(t, 50, clients.Config.Burst)
+	require.NotNil(t, clients.Config.WrapTransport)
+}
+
+func TestNewClientsOptional(t *testing.T) {
+	clients, err := NewClients(AdapterServerOptions{
+		RemoteKubeConfigFile: writeKubeconfig(t, "http://localhost"),
+		DisableArgoRollouts:  true,
+	})
+	require.NoError(t, err)
+	require.Nil(t, clients.RouteGroup)
+	require.Nil(t, clients.ScalingSchedule)
+
+	clients, err = NewClients(AdapterServerOptions{
+		RemoteKubeConfigFile:     writeKubeconfig(t, "http://localhost"),
+		DisableArgoRollouts:      true,
+		SkipperRouteGroupMetrics: true,
+		ScalingScheduleMetrics:   true,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, clients.RouteGroup)
+	require.NotNil(t, clients.ScalingSchedule)
+}
+
+func TestNewClientsArgoRollouts(t *testing.T) {
+	for _, tc := range []struct {
+		msg       string
+		disabled  bool
+		installed bool
+		expected  bool
+	}{
+		{
+			msg:       "CRD installed",
+			installed: true,
+			expected:  true,
+		},
+		{
+			msg:      "CRD not installed",
+			expected: false,
+		},
+		{
+			msg:       "disabled",
+			disabled:  true,
+			installed: true,
+			expected:  false,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			discoveryRequests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/apis/argoproj.io/v1alpha1" {
+					http.NotFound(w, r)
+					return
+				}
+				discoveryRequests++
+				if !tc.installed {
+					http.NotFound(w, r)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = io.WriteString(w, `{"kind":"APIResourceList","apiVersion":"v1","groupVersion":"argoproj.io/v1alpha1","resources":[{"name":"rollouts","namespaced":true,"kind":"Rollout","verbs":["get","list"]}]}`)
+			}))
+			defer server.Close()
+
+			clients, err := NewClients(AdapterServerOptions{
+				RemoteKubeConfigFile: writeKubeconfig(t, server.URL),
+				DisableArgoRollouts:  tc.disabled,
+			})
+			require.NoError(t, err)
+			if tc.expected {
+				require.NotNil(t, clients.ArgoRollouts)
+			} else {
+				require.Nil(t, clients.ArgoRollouts)
+			}
+			if tc.disabled {
+				require.Zero(t, discoveryRequests)
+			}
+		})
+	}
+}
+
+func TestNewOauth2HTTPClientTimeout(t *testing.T) {
+	stop := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		// write the headers and stall while sending the body.
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"stalled":`))
+		w.(http.Flusher).Flush()
+		select {
+		case <-stop:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(stop)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newOauth2HTTPClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}), 100*time.Millisecond)
+
+	start := time.Now()
+	resp, err := client.Get(server.URL)
+	if err == nil {
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+	}
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestBuildProvidersHPARemovalThreshold(t *testing.T) {
+	clients := newFakeClients()
+	factory, err := BuildCollectorFactory(context.Background(), AdapterServerOptions{}, clients)
+	require.NoError(t, err)
+
+	for _, threshold := range []float64{0, 0.3, 1} {
+		_, err = BuildProviders(factory, AdapterServerOptions{HPARemovalThreshold: threshold}, clients)
+		require.NoError(t, err)
+	}
+
+	for _, threshold := range []float64{-0.1, 1.5} {
+		_, err = BuildProviders(factory, AdapterServerOptions{HPARemovalThreshold: threshold}, clients)
+		require.Error(t, err)
+	}
+}
+
+func TestBuildProvidersSharding(t *testing.T) {
+	clients := newFakeClients()
+	factory, err := BuildCollectorFactory(context.Background(), AdapterServerOptions{}, clients)
+	require.NoError(t, err)
+
+	providers, err := BuildProviders(factory, AdapterServerOptions{ShardingTotal: 3, ShardingIndex: 2}, clients)
+	require.NoError(t, err)
+	require.NotNil(t, providers.HPA)
+
+	_, err = BuildProviders(factory, AdapterServerOptions{ShardingTotal: 3, ShardingIndex: 3}, clients)
+	require.Error(t, err)
+}
+
+func TestWatchZMONCheckAliases(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "zmon-check-aliases"},
+		Data:       map[string]string{"latency": `{"checkID": 1234}`},
+	})
+
+	// the aliases are known once the function returns.
+	aliases, err := watchZMONCheckAliases(ctx, client, "kube-system/zmon-check-aliases")
+	require.NoError(t, err)
+	alias, err := aliases.Resolve("latency")
+	require.NoError(t, err)
+	require.Equal(t, 1234, alias.CheckID)
+}
+
+func TestWatchZMONCheckAliasesNotSynced(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("list", "configmaps", func(_ k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(corev1.Resource("configmaps"), "", nil)
+	})
+
+	_, err := watchZMONCheckAliases(ctx, client, "kube-system/zmon-check-aliases")
+	require.Error(t, err)
+}
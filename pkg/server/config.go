@@ -0,0 +1,472 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ConfigurationAPIVersion is the supported version of the
+	// configuration file format.
+	ConfigurationAPIVersion = "kube-metrics-adapter.zalando.org/v1alpha1"
+	// ConfigurationKind is the kind of the configuration file.
+	ConfigurationKind = "KubeMetricsAdapterConfiguration"
+)
+
+// Configuration is the structured configuration file format of the adapter.
+// Every option can alternatively be set by a flag, flags take precedence
+// over the values defined in the file. Unset values keep the flag defaults.
+type Configuration struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+
+	Server          *ServerConfiguration          `json:"server,omitempty"`
+	Credentials     *CredentialsConfiguration     `json:"credentials,omitempty"`
+	Prometheus      *PrometheusConfiguration      `json:"prometheus,omitempty"`
+	Skipper         *SkipperConfiguration         `json:"skipper,omitempty"`
+	InfluxDB        *InfluxDBConfiguration        `json:"influxdb,omitempty"`
+	ZMON            *ZMONConfiguration            `json:"zmon,omitempty"`
+	Nakadi          *NakadiConfiguration          `json:"nakadi,omitempty"`
+	SQL             *SQLConfiguration             `json:"sql,omitempty"`
+	Redis           *RedisConfiguration           `json:"redis,omitempty"`
+	AWS             *AWSConfiguration             `json:"aws,omitempty"`
+	HTTPCollector   *HTTPCollectorConfiguration   `json:"httpCollector,omitempty"`
+	ScalingSchedule *ScalingScheduleConfiguration `json:"scalingSchedule,omitempty"`
+}
+
+// ServerConfiguration configures the adapter itself.
+type ServerConfiguration struct {
+	ListerKubeconfig                  *string          `json:"listerKubeconfig,omitempty"`
+	KubeAPIQPS                        *float32         `json:"kubeAPIQPS,omitempty"`
+	KubeAPIBurst                      *int             `json:"kubeAPIBurst,omitempty"`
+	DisableArgoRollouts               *bool            `json:"disableArgoRollouts,omitempty"`
+	EnableCustomMetricsAPI            *bool            `json:"enableCustomMetricsAPI,omitempty"`
+	EnableExternalMetricsAPI          *bool            `json:"enableExternalMetricsAPI,omitempty"`
+	MetricsAddress                    *string          `json:"metricsAddress,omitempty"`
+	DisregardIncompatibleHPAs         *bool            `json:"disregardIncompatibleHPAs,omitempty"`
+	CollectorInterval                 *metav1.Duration `json:"collectorInterval,omitempty"`
+	MetricsTTL                        *metav1.Duration `json:"metricsTTL,omitempty"`
+	GCInterval                        *metav1.Duration `json:"gcInterval,omitempty"`
+	RecordQueries                     *bool            `json:"recordQueries,omitempty"`
+	SelfMetrics                       *bool            `json:"selfMetrics,omitempty"`
+	DesiredReplicasMetric             *bool            `json:"desiredReplicasMetric,omitempty"`
+	WriteStatusAnnotations            *bool            `json:"writeStatusAnnotations,omitempty"`
+	PushAddress                       *string          `json:"pushAddress,omitempty"`
+	PushTTL                           *metav1.Duration `json:"pushTTL,omitempty"`
+	HPARemovalThreshold               *float64         `json:"hpaRemovalThreshold,omitempty"`
+	MaxReplicasDetection              *bool            `json:"maxReplicasDetection,omitempty"`
+	NamespaceDefaults                 *bool            `json:"namespaceDefaults,omitempty"`
+	HPASummaryAPI                     *bool            `json:"hpaSummaryAPI,omitempty"`
+	DebugQueryAPI                     *bool            `json:"debugQueryAPI,omitempty"`
+	HPAPauseAnnotation                *string          `json:"hpaPauseAnnotation,omitempty"`
+	StateFile                         *string          `json:"stateFile,omitempty"`
+	StateSaveInterval                 *metav1.Duration `json:"stateSaveInterval,omitempty"`
+	AuditLogPath                      *string          `json:"auditLogPath,omitempty"`
+	ExternalClientTimeout             *metav1.Duration `json:"externalClientTimeout,omitempty"`
+	MaxResponseSize                   *int64           `json:"maxResponseSize,omitempty"`
+	AllowClusterScopedExternalMetrics *bool            `json:"allowClusterScopedExternalMetrics,omitempty"`
+	ShardingTotal                     *int             `json:"shardingTotal,omitempty"`
+	ShardingIndex                     *int             `json:"shardingIndex,omitempty"`
+	EventDeduplicationWindow          *metav1.Duration `json:"eventDeduplicationWindow,omitempty"`
+	SuppressEventReasons              []string         `json:"suppressEventReasons,omitempty"`
+	MaxCollectionTimeout              *metav1.Duration `json:"maxCollectionTimeout,omitempty"`
+	RBACSelfCheck                     *string          `json:"rbacSelfCheck,omitempty"`
+}
+
+// CredentialsConfiguration configures the credentials used for calling
+// external services like ZMON and Nakadi.
+type CredentialsConfiguration struct {
+	Token          *string `json:"token,omitempty"`
+	CredentialsDir *string `json:"credentialsDir,omitempty"`
+}
+
+// PrometheusConfiguration configures the Prometheus based collectors.
+type PrometheusConfiguration struct {
+	Server                  *string `json:"server,omitempty"`
+	SamplesWarningThreshold *int    `json:"samplesWarningThreshold,omitempty"`
+	ExternalRPSMetrics      *bool   `json:"externalRPSMetrics,omitempty"`
+	ExternalRPSMetricName   *string `json:"externalRPSMetricName,omitempty"`
+}
+
+// SkipperConfiguration configures the skipper collector.
+type SkipperConfiguration struct {
+	IngressMetrics     *bool    `json:"ingressMetrics,omitempty"`
+	RouteGroupMetrics  *bool    `json:"routeGroupMetrics,omitempty"`
+	BackendsAnnotation []string `json:"backendsAnnotation,omitempty"`
+}
+
+// InfluxDBConfiguration configures the InfluxDB collector.
+type InfluxDBConfiguration struct {
+	Address *string `json:"address,omitempty"`
+	Token   *string `json:"token,omitempty"`
+	Org     *string `json:"org,omitempty"`
+}
+
+// ZMONConfiguration configures the ZMON collector.
+type ZMONConfiguration struct {
+	KariosDBEndpoint *string `json:"kariosDBEndpoint,omitempty"`
+	TokenName        *string `json:"tokenName,omitempty"`
+	CheckAliases     *string `json:"checkAliases,omitempty"`
+}
+
+// NakadiConfiguration configures the Nakadi collector.
+type NakadiConfiguration struct {
+	Endpoint  *string `json:"endpoint,omitempty"`
+	TokenName *string `json:"tokenName,omitempty"`
+}
+
+// SQLConfiguration configures the SQL collector.
+type SQLConfiguration struct {
+	Driver           *string          `json:"driver,omitempty"`
+	DSNFile          *string          `json:"dsnFile,omitempty"`
+	QueryTimeout     *metav1.Duration `json:"queryTimeout,omitempty"`
+	MinQueryInterval *metav1.Duration `json:"minQueryInterval,omitempty"`
+}
+
+// RedisConfiguration configures the Redis collector.
+type RedisConfiguration struct {
+	Address               *string          `json:"address,omitempty"`
+	PasswordFile          *string          `json:"passwordFile,omitempty"`
+	AllowedAddresses      []string         `json:"allowedAddresses,omitempty"`
+	Timeout               *metav1.Duration `json:"timeout,omitempty"`
+	TLS                   *bool            `json:"tls,omitempty"`
+	TLSCAFile             *string          `json:"tlsCAFile,omitempty"`
+	TLSInsecureSkipVerify *bool            `json:"tlsInsecureSkipVerify,omitempty"`
+}
+
+// AWSConfiguration configures the AWS collector.
+type AWSConfiguration struct {
+	ExternalMetrics        *bool            `json:"externalMetrics,omitempty"`
+	Regions                []string         `json:"regions,omitempty"`
+	AllowDynamicRegions    *bool            `json:"allowDynamicRegions,omitempty"`
+	SessionRefreshInterval *metav1.Duration `json:"sessionRefreshInterval,omitempty"`
+}
+
+// HTTPCollectorConfiguration configures the endpoint restrictions of the
+// HTTP collector.
+type HTTPCollectorConfiguration struct {
+	AllowedCIDRs   []string `json:"allowedCIDRs,omitempty"`
+	DeniedCIDRs    []string `json:"deniedCIDRs,omitempty"`
+	AllowedSchemes []string `json:"allowedSchemes,omitempty"`
+}
+
+// ScalingScheduleConfiguration configures the ScalingSchedule collectors
+// and controller.
+type ScalingScheduleConfiguration struct {
+	Enabled                          *bool            `json:"enabled,omitempty"`
+	DefaultScalingWindow             *metav1.Duration `json:"defaultScalingWindow,omitempty"`
+	RampSteps                        *int             `json:"rampSteps,omitempty"`
+	DefaultTimeZone                  *string          `json:"defaultTimeZone,omitempty"`
+	MaxDuration                      *metav1.Duration `json:"maxDuration,omitempty"`
+	TimeOffset                       *metav1.Duration `json:"timeOffset,omitempty"`
+	MaxScalesPerRun                  *int             `json:"maxScalesPerRun,omitempty"`
+	ConflictFactor                   *float64         `json:"conflictFactor,omitempty"`
+	MaxScaleUpFactor                 *float64         `json:"maxScaleUpFactor,omitempty"`
+	MaxReplicas                      *int64           `json:"maxReplicas,omitempty"`
+	ValueMetrics                     *bool            `json:"valueMetrics,omitempty"`
+	HorizontalPodAutoscalerTolerance *float64         `json:"horizontalPodAutoscalerTolerance,omitempty"`
+}
+
+// LoadConfiguration reads and decodes a configuration file. Unknown fields
+// are rejected.
+func LoadConfiguration(path string) (*Configuration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration file %s: %w", path, err)
+	}
+	return ParseConfiguration(data)
+}
+
+// ParseConfiguration decodes a YAML or JSON encoded configuration. Unknown
+// fields are rejected.
+func ParseConfiguration(data []byte) (*Configuration, error) {
+	var config Configuration
+	err := yaml.UnmarshalStrict(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode configuration: %w", err)
+	}
+
+	if config.APIVersion != ConfigurationAPIVersion {
+		return nil, fmt.Errorf("unsupported configuration apiVersion '%s', expected '%s'", config.APIVersion, ConfigurationAPIVersion)
+	}
+
+	if config.Kind != ConfigurationKind {
+		return nil, fmt.Errorf("unsupported configuration kind '%s', expected '%s'", config.Kind, ConfigurationKind)
+	}
+
+	return &config, nil
+}
+
+// ConfigurationFromOptions returns the configuration expressing all the
+// options.
+func ConfigurationFromOptions(o *AdapterServerOptions) *Configuration {
+	return &Configuration{
+		APIVersion: ConfigurationAPIVersion,
+		Kind:       ConfigurationKind,
+		Server: &ServerConfiguration{
+			ListerKubeconfig:                  &o.RemoteKubeConfigFile,
+			KubeAPIQPS:                        &o.KubeAPIQPS,
+			KubeAPIBurst:                      &o.KubeAPIBurst,
+			DisableArgoRollouts:               &o.DisableArgoRollouts,
+			EnableCustomMetricsAPI:            &o.EnableCustomMetricsAPI,
+			EnableExternalMetricsAPI:          &o.EnableExternalMetricsAPI,
+			MetricsAddress:                    &o.MetricsAddress,
+			DisregardIncompatibleHPAs:         &o.DisregardIncompatibleHPAs,
+			CollectorInterval:                 &metav1.Duration{Duration: o.CollectorInterval},
+			MetricsTTL:                        &metav1.Duration{Duration: o.MetricsTTL},
+			GCInterval:                        &metav1.Duration{Duration: o.GCInterval},
+			RecordQueries:                     &o.RecordQueries,
+			SelfMetrics:                       &o.SelfMetrics,
+			DesiredReplicasMetric:             &o.DesiredReplicasMetric,
+			WriteStatusAnnotations:            &o.WriteStatusAnnotations,
+			PushAddress:                       &o.PushAddress,
+			PushTTL:                           &metav1.Duration{Duration: o.PushTTL},
+			HPARemovalThreshold:               &o.HPARemovalThreshold,
+			MaxReplicasDetection:              &o.MaxReplicasDetection,
+			NamespaceDefaults:                 &o.NamespaceDefaults,
+			HPASummaryAPI:                     &o.HPASummaryAPI,
+			DebugQueryAPI:                     &o.DebugQueryAPI,
+			HPAPauseAnnotation:                &o.HPAPauseAnnotation,
+			StateFile:                         &o.StateFile,
+			StateSaveInterval:                 &metav1.Duration{Duration: o.StateSaveInterval},
+			AuditLogPath:                      &o.AuditLogPath,
+			ExternalClientTimeout:             &metav1.Duration{Duration: o.ExternalClientTimeout},
+			MaxResponseSize:                   &o.MaxResponseSize,
+			AllowClusterScopedExternalMetrics: &o.AllowClusterScopedExternalMetrics,
+			ShardingTotal:                     &o.ShardingTotal,
+			ShardingIndex:                     &o.ShardingIndex,
+			EventDeduplicationWindow:          &metav1.Duration{Duration: o.EventDeduplicationWindow},
+			SuppressEventReasons:              o.SuppressEventReasons,
+			MaxCollectionTimeout:              &metav1.Duration{Duration: o.MaxCollectionTimeout},
+			RBACSelfCheck:                     &o.RBACSelfCheck,
+		},
+		Credentials: &CredentialsConfiguration{
+			Token:          &o.Token,
+			CredentialsDir: &o.CredentialsDir,
+		},
+		Prometheus: &PrometheusConfiguration{
+			Server:                  &o.PrometheusServer,
+			SamplesWarningThreshold: &o.PrometheusSamplesWarningThreshold,
+			ExternalRPSMetrics:      &o.ExternalRPSMetrics,
+			ExternalRPSMetricName:   &o.ExternalRPSMetricName,
+		},
+		Skipper: &SkipperConfiguration{
+			IngressMetrics:     &o.SkipperIngressMetrics,
+			RouteGroupMetrics:  &o.SkipperRouteGroupMetrics,
+			BackendsAnnotation: o.SkipperBackendWeightAnnotation,
+		},
+		InfluxDB: &InfluxDBConfiguration{
+			Address: &o.InfluxDBAddress,
+			Token:   &o.InfluxDBToken,
+			Org:     &o.InfluxDBOrg,
+		},
+		ZMON: &ZMONConfiguration{
+			KariosDBEndpoint: &o.ZMONKariosDBEndpoint,
+			TokenName:        &o.ZMONTokenName,
+			CheckAliases:     &o.ZMONCheckAliases,
+		},
+		Nakadi: &NakadiConfiguration{
+			Endpoint:  &o.NakadiEndpoint,
+			TokenName: &o.NakadiTokenName,
+		},
+		SQL: &SQLConfiguration{
+			Driver:           &o.SQLDriver,
+			DSNFile:          &o.SQLDSNFile,
+			QueryTimeout:     &metav1.Duration{Duration: o.SQLQueryTimeout},
+			MinQueryInterval: &metav1.Duration{Duration: o.SQLMinQueryInterval},
+		},
+		Redis: &RedisConfiguration{
+			Address:               &o.RedisAddress,
+			PasswordFile:          &o.RedisPasswordFile,
+			AllowedAddresses:      o.RedisAllowedAddresses,
+			Timeout:               &metav1.Duration{Duration: o.RedisTimeout},
+			TLS:                   &o.RedisTLS,
+			TLSCAFile:             &o.RedisTLSCAFile,
+			TLSInsecureSkipVerify: &o.RedisTLSInsecureSkipVerify,
+		},
+		AWS: &AWSConfiguration{
+			ExternalMetrics:        &o.AWSExternalMetrics,
+			Regions:                o.AWSRegions,
+			AllowDynamicRegions:    &o.AWSAllowDynamicRegions,
+			SessionRefreshInterval: &metav1.Duration{Duration: o.AWSSessionRefreshInterval},
+		},
+		HTTPCollector: &HTTPCollectorConfiguration{
+			AllowedCIDRs:   o.HTTPCollectorAllowedCIDRs,
+			DeniedCIDRs:    o.HTTPCollectorDeniedCIDRs,
+			AllowedSchemes: o.HTTPCollectorAllowedSchemes,
+		},
+		ScalingSchedule: &ScalingScheduleConfiguration{
+			Enabled:                          &o.ScalingScheduleMetrics,
+			DefaultScalingWindow:             &metav1.Duration{Duration: o.DefaultScheduledScalingWindow},
+			RampSteps:                        &o.RampSteps,
+			DefaultTimeZone:                  &o.DefaultTimeZone,
+			MaxDuration:                      &metav1.Duration{Duration: o.ScalingScheduleMaxDuration},
+			TimeOffset:                       &metav1.Duration{Duration: o.TimeOffset},
+			MaxScalesPerRun:                  &o.ScalingScheduleMaxScalesPerRun,
+			ConflictFactor:                   &o.ScalingScheduleConflictFactor,
+			MaxScaleUpFactor:                 &o.ScalingScheduleMaxScaleUpFactor,
+			MaxReplicas:                      &o.ScalingScheduleMaxReplicas,
+			ValueMetrics:                     &o.ScalingScheduleValueMetrics,
+			HorizontalPodAutoscalerTolerance: &o.HorizontalPodAutoscalerTolerance,
+		},
+	}
+}
+
+// ApplyTo sets the options defined in the configuration. Options whose flag
+// was explicitly set, as reported by flagChanged, are left untouched.
+func (c *Configuration) ApplyTo(o *AdapterServerOptions, flagChanged func(name string) bool) {
+	a := configApplier{flagChanged: flagChanged}
+
+	if s := c.Server; s != nil {
+		applyValue(a, "lister-kubeconfig", &o.RemoteKubeConfigFile, s.ListerKubeconfig)
+		applyValue(a, "kube-api-qps", &o.KubeAPIQPS, s.KubeAPIQPS)
+		applyValue(a, "kube-api-burst", &o.KubeAPIBurst, s.KubeAPIBurst)
+		applyValue(a, "disable-argo-rollouts", &o.DisableArgoRollouts, s.DisableArgoRollouts)
+		applyValue(a, "enable-custom-metrics-api", &o.EnableCustomMetricsAPI, s.EnableCustomMetricsAPI)
+		applyValue(a, "enable-external-metrics-api", &o.EnableExternalMetricsAPI, s.EnableExternalMetricsAPI)
+		applyValue(a, "metrics-address", &o.MetricsAddress, s.MetricsAddress)
+		applyValue(a, "disregard-incompatible-hpas", &o.DisregardIncompatibleHPAs, s.DisregardIncompatibleHPAs)
+		a.duration("collector-interval", &o.CollectorInterval, s.CollectorInterval)
+		a.duration("metrics-ttl", &o.MetricsTTL, s.MetricsTTL)
+		a.duration("garbage-collector-interval", &o.GCInterval, s.GCInterval)
+		applyValue(a, "record-queries", &o.RecordQueries, s.RecordQueries)
+		applyValue(a, "self-metrics", &o.SelfMetrics, s.SelfMetrics)
+		applyValue(a, "desired-replicas-metric", &o.DesiredReplicasMetric, s.DesiredReplicasMetric)
+		applyValue(a, "write-status-annotations", &o.WriteStatusAnnotations, s.WriteStatusAnnotations)
+		applyValue(a, "push-address", &o.PushAddress, s.PushAddress)
+		a.duration("push-ttl", &o.PushTTL, s.PushTTL)
+		applyValue(a, "hpa-removal-threshold", &o.HPARemovalThreshold, s.HPARemovalThreshold)
+		applyValue(a, "max-replicas-detection", &o.MaxReplicasDetection, s.MaxReplicasDetection)
+		applyValue(a, "namespace-defaults", &o.NamespaceDefaults, s.NamespaceDefaults)
+		applyValue(a, "hpa-summary-api", &o.HPASummaryAPI, s.HPASummaryAPI)
+		applyValue(a, "debug-query-api", &o.DebugQueryAPI, s.DebugQueryAPI)
+		applyValue(a, "hpa-pause-annotation", &o.HPAPauseAnnotation, s.HPAPauseAnnotation)
+		applyValue(a, "state-file", &o.StateFile, s.StateFile)
+		a.duration("state-save-interval", &o.StateSaveInterval, s.StateSaveInterval)
+		applyValue(a, "audit-log-path", &o.AuditLogPath, s.AuditLogPath)
+		a.duration("external-client-timeout", &o.ExternalClientTimeout, s.ExternalClientTimeout)
+		applyValue(a, "max-response-size", &o.MaxResponseSize, s.MaxResponseSize)
+		applyValue(a, "allow-cluster-scoped-external-metrics", &o.AllowClusterScopedExternalMetrics, s.AllowClusterScopedExternalMetrics)
+		applyValue(a, "sharding-total", &o.ShardingTotal, s.ShardingTotal)
+		applyValue(a, "sharding-index", &o.ShardingIndex, s.ShardingIndex)
+		a.duration("event-deduplication-window", &o.EventDeduplicationWindow, s.EventDeduplicationWindow)
+		a.list("suppress-event-reasons", &o.SuppressEventReasons, s.SuppressEventReasons)
+		a.duration("max-collection-timeout", &o.MaxCollectionTimeout, s.MaxCollectionTimeout)
+		applyValue(a, "rbac-self-check", &o.RBACSelfCheck, s.RBACSelfCheck)
+	}
+
+	if s := c.Credentials; s != nil {
+		applyValue(a, "token", &o.Token, s.Token)
+		applyValue(a, "credentials-dir", &o.CredentialsDir, s.CredentialsDir)
+	}
+
+	if s := c.Prometheus; s != nil {
+		applyValue(a, "prometheus-server", &o.PrometheusServer, s.Server)
+		applyValue(a, "prometheus-samples-warning-threshold", &o.PrometheusSamplesWarningThreshold, s.SamplesWarningThreshold)
+		applyValue(a, "external-rps-metrics", &o.ExternalRPSMetrics, s.ExternalRPSMetrics)
+		applyValue(a, "external-rps-metric-name", &o.ExternalRPSMetricName, s.ExternalRPSMetricName)
+	}
+
+	if s := c.Skipper; s != nil {
+		applyValue(a, "skipper-ingress-metrics", &o.SkipperIngressMetrics, s.IngressMetrics)
+		applyValue(a, "skipper-routegroup-metrics", &o.SkipperRouteGroupMetrics, s.RouteGroupMetrics)
+		a.list("skipper-backends-annotation", &o.SkipperBackendWeightAnnotation, s.BackendsAnnotation)
+	}
+
+	if s := c.InfluxDB; s != nil {
+		applyValue(a, "influxdb-address", &o.InfluxDBAddress, s.Address)
+		applyValue(a, "influxdb-token", &o.InfluxDBToken, s.Token)
+		applyValue(a, "influxdb-org", &o.InfluxDBOrg, s.Org)
+	}
+
+	if s := c.ZMON; s != nil {
+		applyValue(a, "zmon-kariosdb-endpoint", &o.ZMONKariosDBEndpoint, s.KariosDBEndpoint)
+		applyValue(a, "zmon-token-name", &o.ZMONTokenName, s.TokenName)
+		applyValue(a, "zmon-check-aliases", &o.ZMONCheckAliases, s.CheckAliases)
+	}
+
+	if s := c.Nakadi; s != nil {
+		applyValue(a, "nakadi-endpoint", &o.NakadiEndpoint, s.Endpoint)
+		applyValue(a, "nakadi-token-name", &o.NakadiTokenName, s.TokenName)
+	}
+
+	if s := c.SQL; s != nil {
+		applyValue(a, "sql-driver", &o.SQLDriver, s.Driver)
+		applyValue(a, "sql-dsn-file", &o.SQLDSNFile, s.DSNFile)
+		a.duration("sql-query-timeout", &o.SQLQueryTimeout, s.QueryTimeout)
+		a.duration("sql-min-query-interval", &o.SQLMinQueryInterval, s.MinQueryInterval)
+	}
+
+	if s := c.Redis; s != nil {
+		applyValue(a, "redis-address", &o.RedisAddress, s.Address)
+		applyValue(a, "redis-password-file", &o.RedisPasswordFile, s.PasswordFile)
+		a.list("redis-allowed-addresses", &o.RedisAllowedAddresses, s.AllowedAddresses)
+		a.duration("redis-timeout", &o.RedisTimeout, s.Timeout)
+		applyValue(a, "redis-tls", &o.RedisTLS, s.TLS)
+		applyValue(a, "redis-tls-ca-file", &o.RedisTLSCAFile, s.TLSCAFile)
+		applyValue(a, "redis-tls-insecure-skip-verify", &o.RedisTLSInsecureSkipVerify, s.TLSInsecureSkipVerify)
+	}
+
+	if s := c.AWS; s != nil {
+		applyValue(a, "aws-external-metrics", &o.AWSExternalMetrics, s.ExternalMetrics)
+		a.list("aws-region", &o.AWSRegions, s.Regions)
+		applyValue(a, "aws-allow-dynamic-regions", &o.AWSAllowDynamicRegions, s.AllowDynamicRegions)
+		a.duration("aws-session-refresh-interval", &o.AWSSessionRefreshInterval, s.SessionRefreshInterval)
+	}
+
+	if s := c.HTTPCollector; s != nil {
+		a.list("http-collector-allowed-cidrs", &o.HTTPCollectorAllowedCIDRs, s.AllowedCIDRs)
+		a.list("http-collector-denied-cidrs", &o.HTTPCollectorDeniedCIDRs, s.DeniedCIDRs)
+		a.list("http-collector-allowed-schemes", &o.HTTPCollectorAllowedSchemes, s.AllowedSchemes)
+	}
+
+	if s := c.ScalingSchedule; s != nil {
+		applyValue(a, "scaling-schedule", &o.ScalingScheduleMetrics, s.Enabled)
+		a.duration("scaling-schedule-default-scaling-window", &o.DefaultScheduledScalingWindow, s.DefaultScalingWindow)
+		applyValue(a, "scaling-schedule-ramp-steps", &o.RampSteps, s.RampSteps)
+		applyValue(a, "scaling-schedule-default-time-zone", &o.DefaultTimeZone, s.DefaultTimeZone)
+		a.duration("scaling-schedule-max-duration", &o.ScalingScheduleMaxDuration, s.MaxDuration)
+		a.duration("time-offset", &o.TimeOffset, s.TimeOffset)
+		applyValue(a, "scaling-schedule-max-scales-per-run", &o.ScalingScheduleMaxScalesPerRun, s.MaxScalesPerRun)
+		applyValue(a, "scaling-schedule-conflict-factor", &o.ScalingScheduleConflictFactor, s.ConflictFactor)
+		applyValue(a, "scaling-schedule-max-scale-up-factor", &o.ScalingScheduleMaxScaleUpFactor, s.MaxScaleUpFactor)
+		applyValue(a, "scaling-schedule-max-replicas", &o.ScalingScheduleMaxReplicas, s.MaxReplicas)
+		applyValue(a, "scaling-schedule-value-metrics", &o.ScalingScheduleValueMetrics, s.ValueMetrics)
+		applyValue(a, "horizontal-pod-autoscaler-tolerance", &o.HorizontalPodAutoscalerTolerance, s.HorizontalPodAutoscalerTolerance)
+	}
+}
+
+// configApplier applies configuration values to options unless the
+// corresponding flag was set.
+type configApplier struct {
+	flagChanged func(name string) bool
+}
+
+func (a configApplier) skip(flag string) bool {
+	return a.flagChanged != nil && a.flagChanged(flag)
+}
+
+func applyValue[T any](a configApplier, flag string, dst *T, src *T) {
+	if src == nil || a.skip(flag) {
+		return
+	}
+	*dst = *src
+}
+
+func (a configApplier) duration(flag string, dst *time.Duration, src *metav1.Duration) {
+	if src == nil || a.skip(flag) {
+		return
+	}
+	*dst = src.Duration
+}
+
+func (a configApplier) list(flag string, dst *[]string, src []string) {
+	if src == nil || a.skip(flag) {
+		return
+	}
+	*dst = src
+}
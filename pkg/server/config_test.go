@@ -0,0 +1,200 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/yaml"
+)
+
+func TestParseConfiguration(t *testing.T) {
+	config, err := ParseConfiguration([]byte(`
+apiVersion: kube-metrics-adapter.zalando.org/v1alpha1
+kind: KubeMetricsAdapterConfiguration
+prometheus:
+  server: http://prometheus.example.org
+scalingSchedule:
+  enabled: true
+  defaultScalingWindow: 5m
+`))
+	require.NoError(t, err)
+
+	o := AdapterServerOptions{DefaultTimeZone: "Europe/Berlin"}
+	config.ApplyTo(&o, nil)
+	require.Equal(t, "http://prometheus.example.org", o.PrometheusServer)
+	require.True(t, o.ScalingScheduleMetrics)
+	require.Equal(t, 5*time.Minute, o.DefaultScheduledScalingWindow)
+	// unset values keep their defaults
+	require.Equal(t, "Europe/Berlin", o.DefaultTimeZone)
+}
+
+func TestParseConfigurationInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		msg    string
+		config string
+	}{
+		{
+			msg: "unknown top level field",
+			config: `
+apiVersion: kube-metrics-adapter.zalando.org/v1alpha1
+kind: KubeMetricsAdapterConfiguration
+unknown: true
+`,
+		},
+		{
+			msg: "unknown nested field",
+			config: `
+apiVersion: kube-metrics-adapter.zalando.org/v1alpha1
+kind: KubeMetricsAdapterConfiguration
+prometheus:
+  servers: http://prometheus.example.org
+`,
+		},
+		{
+			msg: "unsupported apiVersion",
+			config: `
+apiVersion: kube-metrics-adapter.zalando.org/v2
+kind: KubeMetricsAdapterConfiguration
+`,
+		},
+		{
+			msg: "unsupported kind",
+			config: `
+apiVersion: kube-metrics-adapter.zalando.org/v1alpha1
+kind: Configuration
+`,
+		},
+		{
+			msg: "invalid duration",
+			config: `
+apiVersion: kube-metrics-adapter.zalando.org/v1alpha1
+kind: KubeMetricsAdapterConfiguration
+server:
+  metricsTTL: forever
+`,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			_, err := ParseConfiguration([]byte(tc.config))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestConfigurationFlagPrecedence(t *testing.T) {
+	config, err := ParseConfiguration([]byte(`
+apiVersion: kube-metrics-adapter.zalando.org/v1alpha1
+kind: KubeMetricsAdapterConfiguration
+prometheus:
+  server: http://file.example.org
+aws:
+  regions: [eu-west-1]
+`))
+	require.NoError(t, err)
+
+	cmd := NewCommandStartAdapterServer(nil)
+	require.NoError(t, cmd.Flags().Parse([]string{"--prometheus-server=http://flag.example.org"}))
+
+	o := AdapterServerOptions{PrometheusServer: "http://flag.example.org"}
+	config.ApplyTo(&o, cmd.Flags().Changed)
+	require.Equal(t, "http://flag.example.org", o.PrometheusServer)
+	require.Equal(t, []string{"eu-west-1"}, o.AWSRegions)
+}
+
+func TestConfigurationRoundTrip(t *testing.T) {
+	expected := AdapterServerOptions{
+		RemoteKubeConfigFile:              "/kubeconfig",
+		KubeAPIQPS:                        20,
+		KubeAPIBurst:                      40,
+		DisableArgoRollouts:               true,
+		EnableCustomMetricsAPI:            true,
+		EnableExternalMetricsAPI:          true,
+		PrometheusServer:                  "http://prometheus",
+		PrometheusSamplesWarningThreshold: 5000,
+		InfluxDBAddress:                   "http://influxdb",
+		InfluxDBToken:                     "influxdb-token",
+		InfluxDBOrg:                       "influxdb-org",
+		ZMONKariosDBEndpoint:              "http://zmon",
+		ZMONTokenName:                     "zmon",
+		ZMONCheckAliases:                  "kube-system/zmon-check-aliases",
+		NakadiEndpoint:                    "http://nakadi",
+		NakadiTokenName:                   "nakadi",
+		SQLDriver:                         "postgres",
+		SQLDSNFile:                        "/meta/credentials/sql-dsn",
+		SQLQueryTimeout:                   5 * time.Second,
+		SQLMinQueryInterval:               time.Minute,
+		RedisAddress:                      "redis:6379",
+		RedisPasswordFile:                 "/meta/credentials/redis-password",
+		RedisAllowedAddresses:             []string{"redis-2:6379"},
+		RedisTimeout:                      time.Second,
+		RedisTLS:                          true,
+		RedisTLSCAFile:                    "/etc/redis/ca.crt",
+		RedisTLSInsecureSkipVerify:        true,
+		Token:                             "token",
+		CredentialsDir:                    "/meta/credentials",
+		SkipperIngressMetrics:             true,
+		SkipperRouteGroupMetrics:          true,
+		AWSExternalMetrics:                true,
+		AWSRegions:                        []string{"eu-central-1", "eu-west-1"},
+		AWSAllowDynamicRegions:            true,
+		AWSSessionRefreshInterval:         30 * time.Minute,
+		MetricsAddress:                    ":7979",
+		SkipperBackendWeightAnnotation:    []string{"zalando.org/backend-weights"},
+		DisregardIncompatibleHPAs:         true,
+		CollectorInterval:                 30 * time.Second,
+		MetricsTTL:                        15 * time.Minute,
+		GCInterval:                        10 * time.Minute,
+		ScalingScheduleMetrics:            true,
+		DefaultScheduledScalingWindow:     10 * time.Minute,
+		RampSteps:                         10,
+		DefaultTimeZone:                   "Europe/Berlin",
+		ScalingScheduleMaxDuration:        48 * time.Hour,
+		TimeOffset:                        -2 * time.Minute,
+		ScalingScheduleMaxScalesPerRun:    20,
+		ScalingScheduleConflictFactor:     3,
+		ScalingScheduleMaxScaleUpFactor:   4,
+		ScalingScheduleMaxReplicas:        500,
+		ScalingScheduleValueMetrics:       true,
+		HorizontalPodAutoscalerTolerance:  0.1,
+		ExternalRPSMetrics:                true,
+		ExternalRPSMetricName:             "skipper_serve_host_duration_seconds_count",
+		HTTPCollectorAllowedCIDRs:         []string{"10.0.0.0/8"},
+		HTTPCollectorDeniedCIDRs:          []string{"169.254.0.0/16"},
+		HTTPCollectorAllowedSchemes:       []string{"https"},
+		RecordQueries:                     true,
+		SelfMetrics:                       true,
+		DesiredReplicasMetric:             true,
+		WriteStatusAnnotations:            true,
+		PushAddress:                       ":9125",
+		PushTTL:                           10 * time.Minute,
+		HPARemovalThreshold:               0.25,
+		MaxReplicasDetection:              true,
+		NamespaceDefaults:                 true,
+		HPASummaryAPI:                     true,
+		DebugQueryAPI:                     true,
+		HPAPauseAnnotation:                "example.org/paused",
+		StateFile:                         "/var/run/kma/state.json",
+		StateSaveInterval:                 30 * time.Second,
+		AuditLogPath:                      "/var/log/kma/audit.log",
+		ExternalClientTimeout:             15 * time.Second,
+		MaxResponseSize:                   4 << 20,
+		AllowClusterScopedExternalMetrics: true,
+		ShardingTotal:                     3,
+		ShardingIndex:                     1,
+		EventDeduplicationWindow:          5 * time.Minute,
+		SuppressEventReasons:              []string{"PluginNotFound"},
+		MaxCollectionTimeout:              45 * time.Second,
+		RBACSelfCheck:                     RBACSelfCheckStrict,
+	}
+
+	data, err := yaml.Marshal(ConfigurationFromOptions(&expected))
+	require.NoError(t, err)
+
+	config, err := ParseConfiguration(data)
+	require.NoError(t, err)
+
+	var o AdapterServerOptions
+	config.ApplyTo(&o, nil)
+	require.Equal(t, expected, o)
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// deprecatedAutoscalingImports are the autoscaling API versions replaced by
+// autoscaling/v2. Mixing them with v2 requires conversions and risks
+// dropping fields only present in v2.
+var deprecatedAutoscalingImports = []string{
+	"k8s.io/api/autoscaling/v2beta1",
+	"k8s.io/api/autoscaling/v2beta2",
+	"k8s.io/client-go/kubernetes/typed/autoscaling/v2beta1",
+	"k8s.io/client-go/kubernetes/typed/autoscaling/v2beta2",
+}
+
+func TestNoDeprecatedAutoscalingImports(t *testing.T) {
+	root := filepath.Join("..", "..")
+	fset := token.NewFileSet()
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && (strings.HasPrefix(d.Name(), ".") || d.Name() == "vendor") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") {
+			return nil
+		}
+
+		file, err := parser.ParseFile(fset, path, nil, parser.ImportsOnly)
+		if err != nil {
+			return err
+		}
+		for _, spec := range file.Imports {
+			importPath, err := strconv.Unquote(spec.Path.Value)
+			if err != nil {
+				return err
+			}
+			require.NotContains(t, deprecatedAutoscalingImports, importPath, "%s imports a deprecated autoscaling API version", path)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
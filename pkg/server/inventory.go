@@ -0,0 +1,37 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/provider"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// RunInventory lists the HPAs of the cluster and writes the usage of
+// collector types as JSON to w. No collectors are started.
+func (o AdapterServerOptions) RunInventory(w io.Writer) error {
+	clients, err := NewClients(o)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultClientGOTimeout)
+	defer cancel()
+
+	return writeInventory(ctx, clients.Kubernetes, w, o.InventoryTopNamespaces)
+}
+
+func writeInventory(ctx context.Context, client kubernetes.Interface, w io.Writer, topNamespaces int) error {
+	hpas, err := client.AutoscalingV2().HorizontalPodAutoscalers(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list HPAs: %v", err)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(provider.NewInventory(hpas.Items, topNamespaces))
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWriteInventory(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	for i, namespace := range []string{"team-a", "team-a", "team-b"} {
+		hpa := &autoscalingv2.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("hpa-%d", i), Namespace: namespace},
+			Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+				MaxReplicas: 10,
+				Metrics: []autoscalingv2.MetricSpec{
+					{
+						Type: autoscalingv2.ExternalMetricSourceType,
+						External: &autoscalingv2.ExternalMetricSource{
+							Metric: autoscalingv2.MetricIdentifier{
+								Name:     "queue-length",
+								Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"type": "nakadi"}},
+							},
+						},
+					},
+				},
+			},
+		}
+		_, err := client.AutoscalingV2().HorizontalPodAutoscalers(namespace).Create(context.Background(), hpa, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	var out bytes.Buffer
+	err := writeInventory(context.Background(), client, &out, 1)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"hpas": 3,
+		"invalidHPAs": 0,
+		"collectorTypes": {"nakadi": 3},
+		"topNamespaces": [{"namespace": "team-a", "hpas": 2}]
+	}`, out.String())
+}
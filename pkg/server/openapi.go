@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+
+	generatedopenapi "github.com/zalando-incubator/kube-metrics-adapter/pkg/api/generated/openapi"
+	v1 "github.com/zalando-incubator/kube-metrics-adapter/pkg/apis/zalando.org/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	openapinamer "k8s.io/apiserver/pkg/endpoints/openapi"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/kube-openapi/pkg/builder"
+	openapicommon "k8s.io/kube-openapi/pkg/common"
+	"k8s.io/kube-openapi/pkg/util"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"sigs.k8s.io/custom-metrics-apiserver/pkg/apiserver"
+)
+
+// scalingScheduleScheme is the scheme of the zalando.org/v1 types. It's only
+// used to name their OpenAPI definitions after their group, version and
+// kind.
+var scalingScheduleScheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(v1.AddToScheme(scalingScheduleScheme))
+}
+
+// scalingScheduleDefinitions are the zalando.org/v1 types served as OpenAPI
+// definitions in addition to the types of the metrics APIs.
+var scalingScheduleDefinitions = []string{
+	util.GetCanonicalTypeName(&v1.ScalingSchedule{}),
+	util.GetCanonicalTypeName(&v1.ScalingScheduleList{}),
+	util.GetCanonicalTypeName(&v1.ClusterScalingSchedule{}),
+	util.GetCanonicalTypeName(&v1.ClusterScalingScheduleList{}),
+}
+
+// openAPIConfig returns the OpenAPI config of the adapter apiserver. The
+// routes of the apiserver only reference the types of the metrics APIs, so
+// the definitions of the ScalingSchedule types are added to the spec
+// explicitly.
+func openAPIConfig() *openapicommon.Config {
+	namer := openapinamer.NewDefinitionNamer(apiserver.Scheme, scalingScheduleScheme)
+	config := genericapiserver.DefaultOpenAPIConfig(generatedopenapi.GetOpenAPIDefinitions, namer)
+	config.Info.Title = "kube-metrics-adapter"
+	config.Info.Version = "1.0.0"
+
+	definitionsConfig := *config
+	config.PostProcessSpec = func(swagger *spec.Swagger) (*spec.Swagger, error) {
+		definitions, err := builder.BuildOpenAPIDefinitionsForResources(&definitionsConfig, scalingScheduleDefinitions...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build ScalingSchedule definitions: %w", err)
+		}
+
+		if swagger.Definitions == nil {
+			swagger.Definitions = spec.Definitions{}
+		}
+		for name, schema := range definitions.Definitions {
+			if _, ok := swagger.Definitions[name]; !ok {
+				swagger.Definitions[name] = schema
+			}
+		}
+		return swagger, nil
+	}
+
+	return config
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/kube-openapi/pkg/builder"
+)
+
+func TestOpenAPIConfigScalingScheduleDefinitions(t *testing.T) {
+	swagger, err := builder.BuildOpenAPISpecFromRoutes(nil, openAPIConfig())
+	require.NoError(t, err)
+
+	const prefix = "com.github.zalando-incubator.kube-metrics-adapter.pkg.apis.zalando.org.v1."
+	for _, kind := range []string{"ScalingSchedule", "ScalingScheduleList", "ClusterScalingSchedule", "ClusterScalingScheduleList"} {
+		definition, ok := swagger.Definitions[prefix+kind]
+		require.True(t, ok, "missing definition of %s", kind)
+
+		gvks, ok := definition.Extensions["x-kubernetes-group-version-kind"]
+		require.True(t, ok, "missing group version kind of %s", kind)
+		require.Equal(t, []interface{}{
+			map[string]interface{}{"group": "zalando.org", "version": "v1", "kind": kind},
+		}, gvks)
+	}
+
+	// the dependencies are included.
+	spec, ok := swagger.Definitions[prefix+"ScalingScheduleSpec"]
+	require.True(t, ok)
+	require.Contains(t, spec.Properties, "schedules")
+}
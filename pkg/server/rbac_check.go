@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+const (
+	// RBACSelfCheckWarn logs the permissions missing for the enabled
+	// integrations.
+	RBACSelfCheckWarn = "warn"
+	// RBACSelfCheckStrict fails the startup if permissions are missing for
+	// the enabled integrations.
+	RBACSelfCheckStrict = "strict"
+)
+
+// permission is a verb on a resource required by an integration of the
+// adapter.
+type permission struct {
+	integration string
+	namespace   string
+	verb        string
+	group       string
+	resource    string
+	subresource string
+}
+
+func (p permission) String() string {
+	resource := p.resource
+	if p.group != "" {
+		resource += "." + p.group
+	}
+	if p.subresource != "" {
+		resource += "/" + p.subresource
+	}
+	return resource
+}
+
+// permissions returns the permissions for each of the verbs on the resource.
+func permissions(integration, group, resource, subresource string, verbs ...string) []permission {
+	result := make([]permission, 0, len(verbs))
+	for _, verb := range verbs {
+		result = append(result, permission{
+			integration: integration,
+			verb:        verb,
+			group:       group,
+			resource:    resource,
+			subresource: subresource,
+		})
+	}
+	return result
+}
+
+// requiredPermissions returns the permissions required by the integrations
+// enabled by the options and clients.
+func requiredPermissions(o AdapterServerOptions, clients *Clients) []permission {
+	var required []permission
+	required = append(required, permissions("hpa", "autoscaling", "horizontalpodautoscalers", "", "get", "list", "watch")...)
+	required = append(required, permissions("events", "", "events", "", "create", "patch")...)
+	required = append(required, permissions("pods", "", "pods", "", "list")...)
+	required = append(required, permissions("pods", "apps", "deployments", "", "get")...)
+	required = append(required, permissions("pods", "apps", "statefulsets", "", "get")...)
+
+	if clients.ArgoRollouts != nil {
+		required = append(required, permissions("argo-rollouts", "argoproj.io", "rollouts", "", "get")...)
+	}
+	if o.WriteStatusAnnotations {
+		required = append(required, permissions("write-status-annotations", "autoscaling", "horizontalpodautoscalers", "", "patch")...)
+	}
+	if o.NamespaceDefaults {
+		required = append(required, permissions("namespace-defaults", "", "namespaces", "", "list", "watch")...)
+	}
+	if o.PrometheusServer != "" && o.SkipperIngressMetrics {
+		required = append(required, permissions("skipper-ingress-metrics", "networking.k8s.io", "ingresses", "", "get")...)
+	}
+	if o.PrometheusServer != "" && o.SkipperRouteGroupMetrics {
+		required = append(required, permissions("skipper-routegroup-metrics", "zalando.org", "routegroups", "", "get")...)
+	}
+	if o.ZMONCheckAliases != "" {
+		// the ConfigMap is only read from its own namespace.
+		namespace, _, _ := cache.SplitMetaNamespaceKey(o.ZMONCheckAliases)
+		for _, p := range permissions("zmon-check-aliases", "", "configmaps", "", "list", "watch") {
+			p.namespace = namespace
+			required = append(required, p)
+		}
+	}
+	if o.ScalingScheduleMetrics {
+		for _, resource := range []string{"scalingschedules", "clusterscalingschedules"} {
+			required = append(required, permissions("scaling-schedule", "zalando.org", resource, "", "get", "list", "watch")...)
+			required = append(required, permissions("scaling-schedule", "zalando.org", resource, "status", "update")...)
+		}
+		// the scale subresources of the scale targets are updated by the
+		// scheduled scaling controller.
+		for _, resource := range []string{"deployments", "statefulsets"} {
+			required = append(required, permissions("scaling-schedule", "apps", resource, "scale", "get", "update")...)
+		}
+		if clients.ArgoRollouts != nil {
+			required = append(required, permissions("scaling-schedule", "argoproj.io", "rollouts", "scale", "get", "update")...)
+		}
+	}
+	return required
+}
+
+// missingPermissions returns the permissions which are denied to the
+// adapter according to SelfSubjectAccessReviews.
+func missingPermissions(ctx context.Context, client kubernetes.Interface, required []permission) ([]permission, error) {
+	var missing []permission
+	for _, p := range required {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   p.namespace,
+					Verb:        p.verb,
+					Group:       p.group,
+					Resource:    p.resource,
+					Subresource: p.subresource,
+				},
+			},
+		}
+
+		result, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to review access to %s %s: %v", p.verb, p, err)
+		}
+
+		if !result.Status.Allowed {
+			missing = append(missing, p)
+		}
+	}
+	return missing, nil
+}
+
+// formatMissingPermissions formats the missing permissions as a table.
+func formatMissingPermissions(missing []permission) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "INTEGRATION\tVERB\tRESOURCE\tNAMESPACE")
+	for _, p := range missing {
+		namespace := p.namespace
+		if namespace == "" {
+			namespace = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.integration, p.verb, p, namespace)
+	}
+	_ = w.Flush()
+	return b.String()
+}
+
+// RBACSelfCheck reviews whether the adapter is allowed to access the
+// resources required by the enabled integrations. Missing permissions are
+// logged as a single table. In strict mode they fail the check.
+func RBACSelfCheck(ctx context.Context, o AdapterServerOptions, clients *Clients) error {
+	switch o.RBACSelfCheck {
+	case "":
+		return nil
+	case RBACSelfCheckWarn, RBACSelfCheckStrict:
+	default:
+		return fmt.Errorf("invalid --rbac-self-check mode %q, must be %q or %q", o.RBACSelfCheck, RBACSelfCheckWarn, RBACSelfCheckStrict)
+	}
+
+	missing, err := missingPermissions(ctx, clients.Kubernetes, requiredPermissions(o, clients))
+	if err != nil {
+		return err
+	}
+
+	if len(missing) == 0 {
+		klog.Info("RBAC self-check passed, all permissions of the enabled integrations are granted")
+		return nil
+	}
+
+	klog.Warningf("RBAC self-check found %d missing permissions:\n%s", len(missing), formatMissingPermissions(missing))
+	if o.RBACSelfCheck == RBACSelfCheckStrict {
+		return fmt.Errorf("RBAC self-check found %d missing permissions", len(missing))
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newAccessReviewClient returns a fake client allowing all
+// SelfSubjectAccessReviews except for the denied resources, and the
+// reviewed resources.
+func newAccessReviewClient(denied ...string) (*fake.Clientset, *[]string) {
+	client := fake.NewSimpleClientset()
+	reviewed := []string{}
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		attributes := review.Spec.ResourceAttributes
+		resource := attributes.Resource
+		if attributes.Subresource != "" {
+			resource += "/" + attributes.Subresource
+		}
+		reviewed = append(reviewed, attributes.Verb+" "+resource)
+
+		review.Status.Allowed = true
+		for _, d := range denied {
+			if d == attributes.Verb+" "+resource {
+				review.Status.Allowed = false
+			}
+		}
+		return true, review, nil
+	})
+	return client, &reviewed
+}
+
+func TestRBACSelfCheck(t *testing.T) {
+	for _, tc := range []struct {
+		msg      string
+		mode     string
+		denied   []string
+		reviewed int
+		err      bool
+	}{
+		{
+			msg: "disabled",
+		},
+		{
+			msg:      "allowed",
+			mode:     RBACSelfCheckStrict,
+			reviewed: 21,
+		},
+		{
+			msg:      "denied",
+			mode:     RBACSelfCheckWarn,
+			denied:   []string{"update scalingschedules/status", "get routegroups"},
+			reviewed: 21,
+		},
+		{
+			msg:      "denied in strict mode",
+			mode:     RBACSelfCheckStrict,
+			denied:   []string{"update scalingschedules/status", "update deployments/scale"},
+			reviewed: 21,
+			err:      true,
+		},
+		{
+			msg:  "invalid mode",
+			mode: "fail",
+			err:  true,
+		},
+	} {
+		t.Run(tc.msg, func(t *testing.T) {
+			client, reviewed := newAccessReviewClient(tc.denied...)
+			o := AdapterServerOptions{
+				RBACSelfCheck:            tc.mode,
+				PrometheusServer:         "http://prometheus",
+				SkipperRouteGroupMetrics: true,
+				ScalingScheduleMetrics:   true,
+			}
+
+			err := RBACSelfCheck(context.Background(), o, &Clients{Kubernetes: client})
+			if tc.err {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Len(t, *reviewed, tc.reviewed)
+		})
+	}
+}
+
+func TestRequiredPermissions(t *testing.T) {
+	client, reviewed := newAccessReviewClient()
+	o := AdapterServerOptions{
+		// requires prometheus.
+		SkipperIngressMetrics:  true,
+		WriteStatusAnnotations: true,
+		ZMONCheckAliases:       "kube-system/zmon-check-aliases",
+	}
+
+	_, err := missingPermissions(context.Background(), client, requiredPermissions(o, &Clients{}))
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"get horizontalpodautoscalers",
+		"list horizontalpodautoscalers",
+		"watch horizontalpodautoscalers",
+		"create events",
+		"patch events",
+		"list pods",
+		"get deployments",
+		"get statefulsets",
+		"patch horizontalpodautoscalers",
+		"list configmaps",
+		"watch configmaps",
+	}, *reviewed)
+}
+
+func TestFormatMissingPermissions(t *testing.T) {
+	missing := []permission{
+		{integration: "scaling-schedule", verb: "update", group: "zalando.org", resource: "scalingschedules", subresource: "status"},
+		{integration: "zmon-check-aliases", namespace: "kube-system", verb: "watch", resource: "configmaps"},
+	}
+
+	require.Equal(t, `INTEGRATION         VERB    RESOURCE                             NAMESPACE
+scaling-schedule    update  scalingschedules.zalando.org/status  *
+zmon-check-aliases  watch   configmaps                           kube-system
+`, formatMissingPermissions(missing))
+}
@@ -18,44 +18,40 @@ package server
 
 import (
 	"context"
-	"errors"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
 	"time"
 
-	argoRolloutsClient "github.com/argoproj/argo-rollouts/pkg/client/clientset/versioned"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
-	rg "github.com/szuecs/routegroup-client/client/clientset/versioned"
-	"github.com/zalando-incubator/cluster-lifecycle-manager/pkg/credentials-loader/platformiam"
-	generatedopenapi "github.com/zalando-incubator/kube-metrics-adapter/pkg/api/generated/openapi"
-	v1 "github.com/zalando-incubator/kube-metrics-adapter/pkg/apis/zalando.org/v1"
-	"github.com/zalando-incubator/kube-metrics-adapter/pkg/client/clientset/versioned"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/annotations"
 	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector"
-	"github.com/zalando-incubator/kube-metrics-adapter/pkg/controller/scheduledscaling"
-	"github.com/zalando-incubator/kube-metrics-adapter/pkg/nakadi"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/collector/httpmetrics"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/httperrors"
 	"github.com/zalando-incubator/kube-metrics-adapter/pkg/provider"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/recorder"
 	"github.com/zalando-incubator/kube-metrics-adapter/pkg/zmon"
 	"golang.org/x/oauth2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
-	openapinamer "k8s.io/apiserver/pkg/endpoints/openapi"
-	genericapiserver "k8s.io/apiserver/pkg/server"
-	"k8s.io/client-go/informers"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
-	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog"
-	"sigs.k8s.io/custom-metrics-apiserver/pkg/apiserver"
 	"sigs.k8s.io/custom-metrics-apiserver/pkg/cmd/options"
 )
 
 const (
-	defaultClientGOTimeout = 30 * time.Second
+	defaultClientGOTimeout   = 30 * time.Second
+	recordedQueriesPerMetric = 10
+	dryRunQueryTimeout       = 10 * time.Second
 )
 
 // NewCommandStartAdapterServer provides a CLI handler for 'start adapter server' command
@@ -66,19 +62,62 @@ func NewCommandStartAdapterServer(stopCh <-chan struct{}) *cobra.Command {
 		EnableCustomMetricsAPI:            true,
 		EnableExternalMetricsAPI:          true,
 		MetricsAddress:                    ":7979",
+		PushTTL:                           5 * time.Minute,
+		HPARemovalThreshold:               provider.DefaultHPARemovalThreshold,
+		ShardingIndex:                     -1,
 		ZMONTokenName:                     "zmon",
 		NakadiTokenName:                   "nakadi",
 		CredentialsDir:                    "/meta/credentials",
 		ExternalRPSMetricName:             "skipper_serve_host_duration_seconds_count",
+		HTTPCollectorDeniedCIDRs:          httpmetrics.DefaultDeniedCIDRs,
+		HTTPCollectorAllowedSchemes:       httpmetrics.DefaultAllowedSchemes,
+		KubeAPIQPS:                        rest.DefaultQPS,
+		KubeAPIBurst:                      rest.DefaultBurst,
+		EventDeduplicationWindow:          recorder.DefaultDeduplicationWindow,
+		AWSSessionRefreshInterval:         time.Hour,
+		SQLQueryTimeout:                   10 * time.Second,
+		SQLMinQueryInterval:               10 * time.Second,
+		RedisTimeout:                      5 * time.Second,
+		HPAPauseAnnotation:                annotations.DefaultPauseAnnotation,
+		StateSaveInterval:                 time.Minute,
+		ExternalClientTimeout:             30 * time.Second,
+		MaxResponseSize:                   httperrors.DefaultMaxResponseSize,
 	}
 
 	cmd := &cobra.Command{
 		Short: "Launch the custom metrics API adapter server",
 		Long:  "Launch the custom metrics API adapter server",
 		RunE: func(c *cobra.Command, args []string) error {
-			if errList := o.Validate(); len(errList) > 0 {
+			if o.PrintAnnotationSchema {
+				encoder := json.NewEncoder(c.OutOrStdout())
+				encoder.SetEscapeHTML(false)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(annotations.Schema())
+			}
+			if o.ConfigFile != "" {
+				config, err := LoadConfiguration(o.ConfigFile)
+				if err != nil {
+					return err
+				}
+				config.ApplyTo(&o, c.Flags().Changed)
+			}
+			errList := o.Validate()
+			if err := provider.ValidateMetricsTTL(o.MetricsTTL, o.GCInterval); err != nil {
+				errList = append(errList, err)
+			}
+			if len(errList) > 0 {
 				return utilerrors.NewAggregate(errList)
 			}
+			if o.ValidateConfig {
+				fmt.Fprintln(c.OutOrStdout(), "configuration is valid")
+				return nil
+			}
+			if o.Once != o.Inventory {
+				return fmt.Errorf("--once and --inventory must be used together")
+			}
+			if o.Inventory {
+				return o.RunInventory(c.OutOrStdout())
+			}
 			if err := o.RunCustomMetricsAdapterServer(stopCh); err != nil {
 				return err
 			}
@@ -92,15 +131,35 @@ func NewCommandStartAdapterServer(stopCh <-chan struct{}) *cobra.Command {
 	o.Authorization.AddFlags(flags)
 	o.Features.AddFlags(flags)
 
+	flags.StringVar(&o.ConfigFile, "config-file", o.ConfigFile, ""+
+		"path to a "+ConfigurationKind+" file defining the options. Flags take precedence over the file")
+	flags.BoolVar(&o.ValidateConfig, "validate-config", o.ValidateConfig, ""+
+		"only validate the configuration and exit")
+	flags.BoolVar(&o.PrintAnnotationSchema, "print-annotation-schema", o.PrintAnnotationSchema, ""+
+		"print the JSON schema of the metric config annotations of HPAs and exit")
+	flags.BoolVar(&o.Once, "once", o.Once, ""+
+		"run a one-off command instead of the server and exit. Requires --inventory")
+	flags.BoolVar(&o.Inventory, "inventory", o.Inventory, ""+
+		"print the number of HPAs per collector type and the namespaces with the most HPAs as JSON. Requires --once")
+	flags.IntVar(&o.InventoryTopNamespaces, "inventory-top-namespaces", 10, ""+
+		"number of namespaces with the most HPAs listed by --inventory")
 	flags.StringVar(&o.RemoteKubeConfigFile, "lister-kubeconfig", o.RemoteKubeConfigFile, ""+
 		"kubeconfig file pointing at the 'core' kubernetes server with enough rights to list "+
 		"any described objects")
+	flags.Float32Var(&o.KubeAPIQPS, "kube-api-qps", o.KubeAPIQPS, ""+
+		"maximum queries per second to the kubernetes API")
+	flags.IntVar(&o.KubeAPIBurst, "kube-api-burst", o.KubeAPIBurst, ""+
+		"maximum burst of queries to the kubernetes API")
+	flags.BoolVar(&o.DisableArgoRollouts, "disable-argo-rollouts", o.DisableArgoRollouts, ""+
+		"disable the support for Argo Rollout scale targets. If not disabled it's only enabled if the Rollout CRD is installed")
 	flags.BoolVar(&o.EnableCustomMetricsAPI, "enable-custom-metrics-api", o.EnableCustomMetricsAPI, ""+
 		"whether to enable Custom Metrics API")
 	flags.BoolVar(&o.EnableExternalMetricsAPI, "enable-external-metrics-api", o.EnableExternalMetricsAPI, ""+
 		"whether to enable External Metrics API")
 	flags.StringVar(&o.PrometheusServer, "prometheus-server", o.PrometheusServer, ""+
 		"url of prometheus server to query")
+	flags.IntVar(&o.PrometheusSamplesWarningThreshold, "prometheus-samples-warning-threshold", 1000, ""+
+		"log a warning when the result of a prometheus query has more samples than the threshold. 0 disables the warning")
 	flags.StringVar(&o.InfluxDBAddress, "influxdb-address", o.InfluxDBAddress, ""+
 		"address of InfluxDB 2.x server to query (e.g. http://localhost:9999)")
 	flags.StringVar(&o.InfluxDBToken, "influxdb-token", o.InfluxDBToken, ""+
@@ -111,10 +170,38 @@ func NewCommandStartAdapterServer(stopCh <-chan struct{}) *cobra.Command {
 		"url of ZMON KariosDB endpoint to query for ZMON checks")
 	flags.StringVar(&o.ZMONTokenName, "zmon-token-name", o.ZMONTokenName, ""+
 		"name of the token used to query ZMON")
+	flags.StringVar(&o.ZMONCheckAliases, "zmon-check-aliases", o.ZMONCheckAliases, ""+
+		"<namespace>/<name> of a ConfigMap mapping ZMON check aliases to check IDs and default aggregators")
 	flags.StringVar(&o.NakadiEndpoint, "nakadi-endpoint", o.NakadiEndpoint, ""+
 		"url of Nakadi endpoint to for nakadi subscription stats")
 	flags.StringVar(&o.NakadiTokenName, "nakadi-token-name", o.NakadiTokenName, ""+
 		"name of the token used to call nakadi subscription API")
+	flags.DurationVar(&o.ExternalClientTimeout, "external-client-timeout", o.ExternalClientTimeout, ""+
+		"timeout of the requests to ZMON and Nakadi including reading the response. 0 disables the timeout")
+	flags.Int64Var(&o.MaxResponseSize, "max-response-size", o.MaxResponseSize, ""+
+		"maximum number of bytes read from the responses of metrics endpoints, ZMON and Nakadi. Collections of larger responses fail. Can be overridden per metric with the max-response-size config of the json-path and prometheus-exposition collectors. 0 disables the limit")
+	flags.StringVar(&o.SQLDriver, "sql-driver", o.SQLDriver, ""+
+		"SQL driver used for sql metrics, one of postgres, mysql. Enables sql metrics")
+	flags.StringVar(&o.SQLDSNFile, "sql-dsn-file", o.SQLDSNFile, ""+
+		"path to the file containing the DSN of the database queried for sql metrics")
+	flags.DurationVar(&o.SQLQueryTimeout, "sql-query-timeout", o.SQLQueryTimeout, ""+
+		"timeout of the queries for sql metrics")
+	flags.DurationVar(&o.SQLMinQueryInterval, "sql-min-query-interval", o.SQLMinQueryInterval, ""+
+		"minimum interval between two queries of a sql metric")
+	flags.StringVar(&o.RedisAddress, "redis-address", o.RedisAddress, ""+
+		"address (host:port) of the Redis server queried for redis metrics. Enables redis metrics")
+	flags.StringVar(&o.RedisPasswordFile, "redis-password-file", o.RedisPasswordFile, ""+
+		"path to the file containing the password of the Redis servers")
+	flags.StringSliceVar(&o.RedisAllowedAddresses, "redis-allowed-addresses", o.RedisAllowedAddresses, ""+
+		"addresses of Redis servers which redis metrics may query instead of --redis-address")
+	flags.DurationVar(&o.RedisTimeout, "redis-timeout", o.RedisTimeout, ""+
+		"timeout of connecting to Redis and of each command")
+	flags.BoolVar(&o.RedisTLS, "redis-tls", o.RedisTLS, ""+
+		"whether to connect to Redis using TLS")
+	flags.StringVar(&o.RedisTLSCAFile, "redis-tls-ca-file", o.RedisTLSCAFile, ""+
+		"path to the CA certificates verifying the Redis servers. Defaults to the system CAs")
+	flags.BoolVar(&o.RedisTLSInsecureSkipVerify, "redis-tls-insecure-skip-verify", o.RedisTLSInsecureSkipVerify, ""+
+		"whether to skip the verification of the certificates of the Redis servers")
 	flags.StringVar(&o.Token, "token", o.Token, ""+
 		"static oauth2 token to use when calling external services like ZMON and Nakadi")
 	flags.StringVar(&o.CredentialsDir, "credentials-dir", o.CredentialsDir, ""+
@@ -128,300 +215,202 @@ func NewCommandStartAdapterServer(stopCh <-chan struct{}) *cobra.Command {
 	flags.BoolVar(&o.AWSExternalMetrics, "aws-external-metrics", o.AWSExternalMetrics, ""+
 		"whether to enable AWS external metrics")
 	flags.StringSliceVar(&o.AWSRegions, "aws-region", o.AWSRegions, "the AWS regions which should be monitored. eg: eu-central, eu-west-1")
-	flags.StringVar(&o.MetricsAddress, "metrics-address", o.MetricsAddress, "The address where to serve prometheus metrics")
+	flags.BoolVar(&o.AWSAllowDynamicRegions, "aws-allow-dynamic-regions", o.AWSAllowDynamicRegions, ""+
+		"whether to create AWS sessions for regions referenced by metrics which are not configured via --aws-region")
+	flags.DurationVar(&o.AWSSessionRefreshInterval, "aws-session-refresh-interval", o.AWSSessionRefreshInterval, ""+
+		"interval at which AWS sessions are recreated to pick up rotated credentials. 0 disables the refresh")
+	flags.StringVar(&o.MetricsAddress, "metrics-address", o.MetricsAddress, "The address where to serve prometheus metrics. An empty address disables the metrics listener")
 	flags.BoolVar(&o.DisregardIncompatibleHPAs, "disregard-incompatible-hpas", o.DisregardIncompatibleHPAs, ""+
 		"disregard failing to create collectors for incompatible HPAs")
+	flags.DurationVar(&o.CollectorInterval, "collector-interval", 1*time.Minute, "Default interval at which metrics are collected if not defined for the metric.")
 	flags.DurationVar(&o.MetricsTTL, "metrics-ttl", 15*time.Minute, "TTL for metrics that are stored in in-memory cache.")
+	flags.DurationVar(&o.MaxCollectionTimeout, "max-collection-timeout", o.MaxCollectionTimeout, ""+
+		"max deadline of a single collection. Collections are canceled after their interval or this timeout, whichever is shorter. 0 only limits collections to their interval")
 	flags.DurationVar(&o.GCInterval, "garbage-collector-interval", 10*time.Minute, "Interval to clean up metrics that are stored in in-memory cache.")
 	flags.BoolVar(&o.ScalingScheduleMetrics, "scaling-schedule", o.ScalingScheduleMetrics, ""+
 		"whether to enable time-based ScalingSchedule metrics")
 	flags.DurationVar(&o.DefaultScheduledScalingWindow, "scaling-schedule-default-scaling-window", 10*time.Minute, "Default rampup and rampdown window duration for ScalingSchedules")
 	flags.IntVar(&o.RampSteps, "scaling-schedule-ramp-steps", 10, "Number of steps used to rampup and rampdown ScalingSchedules. It's used to guarantee won't avoid reaching the max scaling due to the 10% minimum change rule.")
 	flags.StringVar(&o.DefaultTimeZone, "scaling-schedule-default-time-zone", "Europe/Berlin", "Default time zone to use for ScalingSchedules.")
+	flags.DurationVar(&o.ScalingScheduleMaxDuration, "scaling-schedule-max-duration", 0, "Max duration of a single schedule of a ScalingSchedule including its scaling window. Longer schedules and schedules ending before they start are reported in the status and events of the ScalingSchedule. If zero, 24h is used for repeating and 7 days for one-time schedules.")
+	flags.IntVar(&o.ScalingScheduleMaxScalesPerRun, "scaling-schedule-max-scales-per-run", 0, "Max number of scale targets adjusted by the scheduled scaling controller per run, every 10s, to bound the blast radius of a bad schedule. Targets exceeding it are adjusted in one of the next runs. If zero, the number is unlimited.")
+	flags.Float64Var(&o.ScalingScheduleMaxScaleUpFactor, "scaling-schedule-max-scale-up-factor", 10, "Max factor of the current replicas the scheduled scaling controller scales a target up to. Higher replicas expected by the schedules are clamped and reported by a ScalingClamped event. If zero, the scale-up is not limited.")
+	flags.Int64Var(&o.ScalingScheduleMaxReplicas, "scaling-schedule-max-replicas", 0, "Max replicas the scheduled scaling controller scales any target up to, independent of the max replicas of its HPA. Higher replicas are clamped and reported by a ScalingClamped event. If zero, there is no ceiling.")
+	flags.Float64Var(&o.ScalingScheduleConflictFactor, "scaling-schedule-conflict-factor", 5, "Factor by which the values of schedules of a ScalingSchedule overlapping within the next 7 days have to differ to be reported by its Conflicts condition and an event. If zero, conflicts are not reported.")
+	flags.BoolVar(&o.ScalingScheduleValueMetrics, "scaling-schedule-value-metrics", o.ScalingScheduleValueMetrics, "Whether to export the current value of every ScalingSchedule and ClusterScalingSchedule, referenced by an HPA or not, as the kube_metrics_adapter_schedule_value and kube_metrics_adapter_schedule_active metrics, updated every controller run.")
+	flags.DurationVar(&o.TimeOffset, "time-offset", 0, "Offset, positive or negative, added to the local clock when evaluating ScalingSchedules. Compensates a known clock skew of the cluster relative to the rest of the platform. The effective time is exposed as the kube_metrics_adapter_schedule_clock_seconds metric.")
 	flags.Float64Var(&o.HorizontalPodAutoscalerTolerance, "horizontal-pod-autoscaler-tolerance", 0.1, "The HPA tolerance also configured in the HPA controller.")
 	flags.StringVar(&o.ExternalRPSMetricName, "external-rps-metric-name", o.ExternalRPSMetricName, ""+
 		"The name of the metric that should be used to query prometheus for RPS per hostname.")
 	flags.BoolVar(&o.ExternalRPSMetrics, "external-rps-metrics", o.ExternalRPSMetrics, ""+
 		"whether to enable external RPS metric collector or not")
+	flags.StringSliceVar(&o.HTTPCollectorAllowedCIDRs, "http-collector-allowed-cidrs", o.HTTPCollectorAllowedCIDRs, ""+
+		"networks the HTTP collector is allowed to query. If empty all networks not explicitly denied are allowed")
+	flags.StringSliceVar(&o.HTTPCollectorDeniedCIDRs, "http-collector-denied-cidrs", o.HTTPCollectorDeniedCIDRs, ""+
+		"networks the HTTP collector is not allowed to query")
+	flags.StringSliceVar(&o.HTTPCollectorAllowedSchemes, "http-collector-allowed-schemes", o.HTTPCollectorAllowedSchemes, ""+
+		"URL schemes the HTTP collector is allowed to query")
+	flags.BoolVar(&o.RecordQueries, "record-queries", o.RecordQueries, ""+
+		"whether to record the last effective queries per metric and expose them on the /debug/collectors endpoint")
+	flags.BoolVar(&o.SelfMetrics, "self-metrics", o.SelfMetrics, ""+
+		"whether to enable the kube-metrics-adapter-self external metric exposing the adapter's own collection lag")
+	flags.StringVar(&o.HPAPauseAnnotation, "hpa-pause-annotation", o.HPAPauseAnnotation, ""+
+		"annotation pausing the metric collection and scheduled scaling of an HPA when set to \"true\". Empty disables pausing")
+	flags.StringVar(&o.StateFile, "state-file", o.StateFile, ""+
+		"file to persist the metric store in, so metrics are kept across restarts until they expire. Empty disables persistence")
+	flags.DurationVar(&o.StateSaveInterval, "state-save-interval", o.StateSaveInterval, ""+
+		"interval at which the metric store is saved to the state file")
+	flags.StringVar(&o.AuditLogPath, "audit-log-path", o.AuditLogPath, ""+
+		"file to append a JSON line to for every collector started, updated or stopped. The file is not rotated. Empty disables the audit log")
+	flags.BoolVar(&o.HPASummaryAPI, "hpa-summary-api", o.HPASummaryAPI, ""+
+		"whether to serve the metrics of an HPA with their values and collection health on the metrics address at /apis/metrics-debug/v1/namespaces/{namespace}/hpas/{name}")
+	flags.BoolVar(&o.DebugQueryAPI, "debug-query-api", o.DebugQueryAPI, ""+
+		"whether to serve POST /debug/query on the metrics address, collecting a metric config in the request body once without an HPA")
+	flags.BoolVar(&o.DesiredReplicasMetric, "desired-replicas-metric", o.DesiredReplicasMetric, ""+
+		"whether to enable the "+provider.DesiredReplicasMetricName+" external metric exposing the replicas computed for each HPA")
+	flags.BoolVar(&o.WriteStatusAnnotations, "write-status-annotations", o.WriteStatusAnnotations, ""+
+		"whether to write the last collected value or error of each metric to the "+provider.StatusAnnotation+" annotation of the HPA at most once per minute")
+	flags.StringVar(&o.PushAddress, "push-address", o.PushAddress, "The TCP and UDP address where pods can push the values read by the "+collector.PushedCollectorType+" pods collector. An empty address disables the push receiver")
+	flags.DurationVar(&o.PushTTL, "push-ttl", o.PushTTL, "How long pushed values are kept")
+	flags.Float64Var(&o.HPARemovalThreshold, "hpa-removal-threshold", o.HPARemovalThreshold, ""+
+		"max fraction of the HPAs whose collectors are removed in a single update, e.g. because of an incomplete list. Larger removals are held back until the next update confirms them. 1 disables the check, 0 uses the default")
+	flags.BoolVar(&o.MaxReplicasDetection, "max-replicas-detection", o.MaxReplicasDetection, ""+
+		"whether to check once per minute if the metrics served for an HPA require more than its max replicas, exposed as the kube_metrics_adapter_hpa_at_max metric and as events on the HPA")
+	flags.BoolVar(&o.NamespaceDefaults, "namespace-defaults", o.NamespaceDefaults, ""+
+		"whether to apply the metric-config-default.* annotations of the namespace of an HPA as default metric configs. Requires watching namespaces")
+	flags.BoolVar(&o.AllowClusterScopedExternalMetrics, "allow-cluster-scoped-external-metrics", o.AllowClusterScopedExternalMetrics, ""+
+		"whether to allow external metrics configured with "+provider.ClusterScopedConfigKey+": \"true\" to be stored cluster scoped and served for queries of any namespace")
+	flags.IntVar(&o.ShardingTotal, "sharding-total", o.ShardingTotal, ""+
+		"number of adapter replicas splitting the HPAs by a consistent hash of their namespace and name. Each replica only collects and serves the metrics of its own HPAs. 0 or 1 disables sharding")
+	flags.IntVar(&o.ShardingIndex, "sharding-index", o.ShardingIndex, ""+
+		"shard of the adapter replica when sharding. If negative it's derived from the ordinal suffix of the hostname of a StatefulSet pod")
+	flags.DurationVar(&o.EventDeduplicationWindow, "event-deduplication-window", o.EventDeduplicationWindow, ""+
+		"window in which identical events are only recorded once. 0 disables the deduplication")
+	flags.BoolVar(&o.ChaosMode, "chaos-mode", o.ChaosMode, ""+
+		"developer flag to inject random latency and failures into all collectors. Requires "+collector.ChaosEnvVar+"=true")
+	flags.DurationVar(&o.ChaosMaxLatency, "chaos-latency", o.ChaosMaxLatency, ""+
+		"upper bound of the random latency added to each collection in chaos mode")
+	flags.Float64Var(&o.ChaosFailureRate, "chaos-failure-rate", o.ChaosFailureRate, ""+
+		"probability in the range [0, 1] of a collection to fail in chaos mode")
+	flags.Int64Var(&o.ChaosSeed, "chaos-seed", o.ChaosSeed, ""+
+		"seed of the faults injected in chaos mode")
+	for _, name := range []string{"chaos-mode", "chaos-latency", "chaos-failure-rate", "chaos-seed"} {
+		_ = flags.MarkHidden(name)
+	}
+	flags.StringSliceVar(&o.SuppressEventReasons, "suppress-event-reasons", o.SuppressEventReasons, ""+
+		"reasons of collector creation failures (PluginNotFound, InvalidConfig, UpstreamUnreachable, CreateNewMetricsCollector) for which no events are recorded. The failures are still counted in metrics")
+	flags.StringVar(&o.RBACSelfCheck, "rbac-self-check", o.RBACSelfCheck, ""+
+		"review at startup whether the permissions required by the enabled integrations are granted and log the missing ones. \"strict\" fails the startup if any are missing. --rbac-self-check without value is \"warn\"")
+	flags.Lookup("rbac-self-check").NoOptDefVal = RBACSelfCheckWarn
 	return cmd
 }
 
 func (o AdapterServerOptions) RunCustomMetricsAdapterServer(stopCh <-chan struct{}) error {
-	go func() {
-		http.Handle("/metrics", promhttp.Handler())
-		klog.Fatal(http.ListenAndServe(o.MetricsAddress, nil))
-	}()
-
-	var clientConfig *rest.Config
-	var err error
-	if len(o.RemoteKubeConfigFile) > 0 {
-		loadingRules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: o.RemoteKubeConfigFile}
-		loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{})
-
-		clientConfig, err = loader.ClientConfig()
-	} else {
-		clientConfig, err = rest.InClusterConfig()
-	}
-	if err != nil {
-		return fmt.Errorf("unable to construct lister client config to initialize provider: %v", err)
-	}
-
-	serverConfig := genericapiserver.NewRecommendedConfig(apiserver.Codecs)
-	serverConfig.ClientConfig = clientConfig
-	err = o.CustomMetricsAdapterServerOptions.ApplyTo(serverConfig)
-	if err != nil {
-		return err
-	}
-
-	config := &apiserver.Config{
-		GenericConfig: &serverConfig.Config,
-	}
-
-	config.GenericConfig.OpenAPIConfig = genericapiserver.DefaultOpenAPIConfig(generatedopenapi.GetOpenAPIDefinitions, openapinamer.NewDefinitionNamer(apiserver.Scheme))
-	config.GenericConfig.OpenAPIConfig.Info.Title = "kube-metrics-adapter"
-	config.GenericConfig.OpenAPIConfig.Info.Version = "1.0.0"
-
 	// convert stop channel to a context
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	go func() {
 		<-stopCh
 		cancel()
 	}()
 
-	clientConfig.Timeout = defaultClientGOTimeout
-
-	client, err := kubernetes.NewForConfig(clientConfig)
+	// OpenMetrics is negotiated to expose the exemplars linking the
+	// collection counters to the traces of the collections.
+	http.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+	})))
+	err := StartMetricsServer(ctx, o.MetricsAddress, http.DefaultServeMux)
 	if err != nil {
-		return fmt.Errorf("failed to initialize new client: %v", err)
+		return err
 	}
 
-	argoRolloutsClient, err := argoRolloutsClient.NewForConfig(clientConfig)
+	clients, err := NewClients(o)
 	if err != nil {
-		return fmt.Errorf("failed to initialize Argo Rollouts client: %v", err)
+		return err
 	}
 
-	rgClient, err := rg.NewForConfig(clientConfig)
+	err = RBACSelfCheck(ctx, o, clients)
 	if err != nil {
-		return fmt.Errorf("failed to initialize RouteGroup client: %v", err)
-	}
-
-	collectorFactory := collector.NewCollectorFactory()
-
-	if o.PrometheusServer != "" {
-		promPlugin, err := collector.NewPrometheusCollectorPlugin(client, o.PrometheusServer)
-		if err != nil {
-			return fmt.Errorf("failed to initialize prometheus collector plugin: %v", err)
-		}
-
-		err = collectorFactory.RegisterObjectCollector("", "prometheus", promPlugin)
-		if err != nil {
-			return fmt.Errorf("failed to register prometheus object collector plugin: %v", err)
-		}
-
-		collectorFactory.RegisterExternalCollector([]string{collector.PrometheusMetricType, collector.PrometheusMetricNameLegacy}, promPlugin)
-
-		// skipper collector can only be enabled if prometheus is.
-		if o.SkipperIngressMetrics || o.SkipperRouteGroupMetrics {
-			skipperPlugin, err := collector.NewSkipperCollectorPlugin(client, rgClient, promPlugin, o.SkipperBackendWeightAnnotation)
-			if err != nil {
-				return fmt.Errorf("failed to initialize skipper collector plugin: %v", err)
-			}
-
-			if o.SkipperIngressMetrics {
-				err = collectorFactory.RegisterObjectCollector("Ingress", "", skipperPlugin)
-				if err != nil {
-					return fmt.Errorf("failed to register skipper Ingress collector plugin: %v", err)
-				}
-			}
-
-			if o.SkipperRouteGroupMetrics {
-				err = collectorFactory.RegisterObjectCollector("RouteGroup", "", skipperPlugin)
-				if err != nil {
-					return fmt.Errorf("failed to register skipper RouteGroup collector plugin: %v", err)
-				}
-			}
-		}
-
-		// External RPS collector, like skipper's, depends on prometheus being enabled.
-		// Also, to enable hostname metric its necessary to pass the metric name that
-		// will be used. This was built this way so we can support hostname metrics to
-		// any ingress provider, e.g. Skipper, Nginx, envoy etc, in a simple way.
-		if o.ExternalRPSMetrics && o.ExternalRPSMetricName != "" {
-			externalRPSPlugin, err := collector.NewExternalRPSCollectorPlugin(promPlugin, o.ExternalRPSMetricName)
-			collectorFactory.RegisterExternalCollector([]string{collector.ExternalRPSMetricType}, externalRPSPlugin)
-			if err != nil {
-				return fmt.Errorf("failed to register hostname collector plugin: %v", err)
-			}
-		}
-	}
-
-	if o.InfluxDBAddress != "" {
-		influxdbPlugin, err := collector.NewInfluxDBCollectorPlugin(client, o.InfluxDBAddress, o.InfluxDBToken, o.InfluxDBOrg)
-		if err != nil {
-			return fmt.Errorf("failed to initialize InfluxDB collector plugin: %v", err)
-		}
-		collectorFactory.RegisterExternalCollector([]string{collector.InfluxDBMetricType, collector.InfluxDBMetricNameLegacy}, influxdbPlugin)
+		return err
 	}
 
-	plugin, _ := collector.NewHTTPCollectorPlugin()
-	collectorFactory.RegisterExternalCollector([]string{collector.HTTPJSONPathType, collector.HTTPMetricNameLegacy}, plugin)
-	// register generic pod collector
-	err = collectorFactory.RegisterPodsCollector("", collector.NewPodCollectorPlugin(client, argoRolloutsClient))
+	collectorFactory, err := BuildCollectorFactory(ctx, o, clients)
 	if err != nil {
-		return fmt.Errorf("failed to register pod collector plugin: %v", err)
+		return err
 	}
 
-	// enable ZMON based metrics
-	if o.ZMONKariosDBEndpoint != "" {
-		var tokenSource oauth2.TokenSource
-		if o.Token != "" {
-			tokenSource = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: o.Token})
-		} else {
-			tokenSource = platformiam.NewTokenSource(o.ZMONTokenName, o.CredentialsDir)
-		}
-
-		httpClient := newOauth2HTTPClient(ctx, tokenSource)
-
-		zmonClient := zmon.NewZMONClient(o.ZMONKariosDBEndpoint, httpClient)
-
-		zmonPlugin, err := collector.NewZMONCollectorPlugin(zmonClient)
-		if err != nil {
-			return fmt.Errorf("failed to initialize ZMON collector plugin: %v", err)
-		}
-
-		collectorFactory.RegisterExternalCollector([]string{collector.ZMONMetricType, collector.ZMONCheckMetricLegacy}, zmonPlugin)
+	providers, err := BuildProviders(collectorFactory, o, clients)
+	if err != nil {
+		return err
 	}
 
-	// enable Nakadi based metrics
-	if o.NakadiEndpoint != "" {
-		var tokenSource oauth2.TokenSource
-		if o.Token != "" {
-			tokenSource = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: o.Token})
-		} else {
-			tokenSource = platformiam.NewTokenSource(o.NakadiTokenName, o.CredentialsDir)
-		}
-
-		httpClient := newOauth2HTTPClient(ctx, tokenSource)
-
-		nakadiClient := nakadi.NewNakadiClient(o.NakadiEndpoint, httpClient)
-
-		nakadiPlugin, err := collector.NewNakadiCollectorPlugin(nakadiClient)
-		if err != nil {
-			return fmt.Errorf("failed to initialize Nakadi collector plugin: %v", err)
-		}
-
-		collectorFactory.RegisterExternalCollector([]string{collector.NakadiMetricType}, nakadiPlugin)
-	}
+	return RunServer(ctx, providers, o, clients)
+}
 
-	awsConfigs := make(map[string]aws.Config, len(o.AWSRegions))
-	for _, region := range o.AWSRegions {
-		cfg, err := awsconfig.LoadDefaultConfig(context.TODO(), awsconfig.WithRegion(region))
-		if err != nil {
-			return fmt.Errorf("unabled to create aws session for region: %s", region)
-		}
-		awsConfigs[region] = cfg
+// watchZMONCheckAliases watches the ConfigMap defining the ZMON check
+// aliases and keeps the returned aliases up to date with its content. It
+// returns once the ConfigMap was listed, so HPAs referencing an alias don't
+// fail before the aliases are known.
+func watchZMONCheckAliases(ctx context.Context, client kubernetes.Interface, configMap string) (*zmon.CheckAliases, error) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(configMap)
+	if err != nil {
+		return nil, err
 	}
 
-	if o.AWSExternalMetrics {
-		collectorFactory.RegisterExternalCollector([]string{collector.AWSSQSQueueLengthMetric}, collector.NewAWSCollectorPlugin(awsConfigs))
+	if namespace == "" {
+		return nil, fmt.Errorf("namespace of ConfigMap %s is not specified", configMap)
 	}
 
-	if o.ScalingScheduleMetrics {
-		scalingScheduleClient, err := versioned.NewForConfig(clientConfig)
-		if err != nil {
-			return errors.New("unable to create [Cluster]ScalingSchedule.zalando.org/v1 client")
-		}
-
-		clusterScalingSchedulesStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
-		clusterReflector := cache.NewReflector(
-			cache.NewListWatchFromClient(scalingScheduleClient.ZalandoV1().RESTClient(), "ClusterScalingSchedules", "", fields.Everything()),
-			&v1.ClusterScalingSchedule{},
-			clusterScalingSchedulesStore,
-			0,
-		)
-		go clusterReflector.Run(ctx.Done())
-
-		scalingSchedulesStore := cache.NewStore(cache.MetaNamespaceKeyFunc)
-		reflector := cache.NewReflector(
-			cache.NewListWatchFromClient(scalingScheduleClient.ZalandoV1().RESTClient(), "ScalingSchedules", "", fields.Everything()),
-			&v1.ScalingSchedule{},
-			scalingSchedulesStore,
-			0,
-		)
-		go reflector.Run(ctx.Done())
-
-		clusterPlugin, err := collector.NewClusterScalingScheduleCollectorPlugin(clusterScalingSchedulesStore, time.Now, o.DefaultScheduledScalingWindow, o.DefaultTimeZone, o.RampSteps)
-		if err != nil {
-			return fmt.Errorf("unable to create ClusterScalingScheduleCollector plugin: %v", err)
-		}
-		err = collectorFactory.RegisterObjectCollector("ClusterScalingSchedule", "", clusterPlugin)
-		if err != nil {
-			return fmt.Errorf("failed to register ClusterScalingSchedule object collector plugin: %v", err)
-		}
+	aliases := zmon.NewCheckAliases(nil)
 
-		plugin, err := collector.NewScalingScheduleCollectorPlugin(scalingSchedulesStore, time.Now, o.DefaultScheduledScalingWindow, o.DefaultTimeZone, o.RampSteps)
-		if err != nil {
-			return fmt.Errorf("unable to create ScalingScheduleCollector plugin: %v", err)
-		}
-		err = collectorFactory.RegisterObjectCollector("ScalingSchedule", "", plugin)
-		if err != nil {
-			return fmt.Errorf("failed to register ScalingSchedule object collector plugin: %v", err)
+	update := func(obj interface{}) {
+		cm, ok := obj.(*corev1.ConfigMap)
+		if !ok {
+			return
 		}
 
-		scaler, err := scheduledscaling.NewHPATargetScaler(ctx, client, clientConfig)
+		parsed, err := zmon.ParseCheckAliases(cm.Data)
 		if err != nil {
-			return fmt.Errorf("unable to create HPA target scaler: %w", err)
+			klog.Errorf("Failed to update ZMON check aliases from ConfigMap %s: %v", configMap, err)
+			return
 		}
-
-		// setup ScheduledScaling controller to continuously update
-		// status of ScalingSchedule and ClusterScalingSchedule
-		// resources.
-		scheduledScalingController := scheduledscaling.NewController(
-			scalingScheduleClient.ZalandoV1(),
-			client,
-			scaler,
-			scalingSchedulesStore,
-			clusterScalingSchedulesStore,
-			time.Now,
-			o.DefaultScheduledScalingWindow,
-			o.DefaultTimeZone,
-			o.HorizontalPodAutoscalerTolerance,
-		)
-
-		go scheduledScalingController.Run(ctx)
+		aliases.Update(parsed)
 	}
 
-	hpaProvider := provider.NewHPAProvider(client, 30*time.Second, 1*time.Minute, collectorFactory, o.DisregardIncompatibleHPAs, o.MetricsTTL, o.GCInterval)
-
-	go hpaProvider.Run(ctx)
-
-	customMetricsProvider := hpaProvider
-	externalMetricsProvider := hpaProvider
+	_, controller := cache.NewInformerWithOptions(cache.InformerOptions{
+		ListerWatcher: &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+				return client.CoreV1().ConfigMaps(namespace).List(ctx, options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+				return client.CoreV1().ConfigMaps(namespace).Watch(ctx, options)
+			},
+		},
+		ObjectType: &corev1.ConfigMap{},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc:    update,
+			UpdateFunc: func(_, newObj interface{}) { update(newObj) },
+			DeleteFunc: func(_ interface{}) { aliases.Update(nil) },
+		},
+	})
+	go controller.Run(ctx.Done())
 
-	// var externalMetricsProvider := nil
-	if !o.EnableCustomMetricsAPI {
-		customMetricsProvider = nil
-	}
-	if !o.EnableExternalMetricsAPI {
-		externalMetricsProvider = nil
+	if !cache.WaitForCacheSync(ctx.Done(), controller.HasSynced) {
+		return nil, fmt.Errorf("failed to sync ConfigMap %s", configMap)
 	}
 
-	informer := informers.NewSharedInformerFactory(client, 0)
-
-	// In this example, the same provider implements both Custom Metrics API and External Metrics API
-	server, err := config.Complete(informer).New("kube-metrics-adapter", customMetricsProvider, externalMetricsProvider)
-	if err != nil {
-		return err
-	}
-	return server.GenericAPIServer.PrepareRun().RunWithContext(ctx)
+	return aliases, nil
 }
 
-// newInstrumentedOauth2HTTPClient creates an HTTP client with automatic oauth2
-// token injection. Additionally it will spawn a go-routine for closing idle
-// connections every 20 seconds on the http.Transport. This solves the problem
-// of re-resolving DNS when the endpoint backend changes.
+// newOauth2HTTPClient creates an HTTP client authenticating requests with
+// tokens from the token source. Requests, including reading the response
+// body, are aborted after timeout. Additionally it will spawn a go-routine for
+// closing idle connections every 20 seconds on the http.Transport. This solves
+// the problem of re-resolving DNS when the endpoint backend changes.
 // https://github.com/golang/go/issues/23427
-func newOauth2HTTPClient(ctx context.Context, tokenSource oauth2.TokenSource) *http.Client {
+func newOauth2HTTPClient(ctx context.Context, tokenSource oauth2.TokenSource, timeout time.Duration) *http.Client {
 	transport := &http.Transport{
 		DialContext: (&net.Dialer{
 			Timeout:   30 * time.Second,
@@ -446,18 +435,39 @@ func newOauth2HTTPClient(ctx context.Context, tokenSource oauth2.TokenSource) *h
 
 	client := &http.Client{
 		Transport: transport,
+		Timeout:   timeout,
 	}
 
 	// add HTTP client to context (this is how the oauth2 lib gets it).
 	ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
 
-	// instantiate an http.Client containing the token source.
-	return oauth2.NewClient(ctx, tokenSource)
+	// instantiate an http.Client containing the token source. The timeout
+	// of the base client only applies to token requests.
+	oauth2Client := oauth2.NewClient(ctx, tokenSource)
+	oauth2Client.Timeout = timeout
+	return oauth2Client
 }
 
 type AdapterServerOptions struct {
 	*options.CustomMetricsAdapterServerOptions
 
+	// ConfigFile is the path to a configuration file defining the
+	// options.
+	ConfigFile string
+	// ValidateConfig only validates the configuration without starting
+	// the server.
+	ValidateConfig bool
+	// PrintAnnotationSchema prints the JSON schema of the metric config
+	// annotations without starting the server.
+	PrintAnnotationSchema bool
+	// Once runs a one-off command instead of the server.
+	Once bool
+	// Inventory prints the usage of collector types by the HPAs of the
+	// cluster. It requires Once.
+	Inventory bool
+	// InventoryTopNamespaces is the number of namespaces listed in the
+	// inventory.
+	InventoryTopNamespaces int
 	// RemoteKubeConfigFile is the config used to list pods from the master API server
 	RemoteKubeConfigFile string
 	// EnableCustomMetricsAPI switches on sample apiserver for Custom Metrics API
@@ -467,6 +477,9 @@ type AdapterServerOptions struct {
 	// PrometheusServer enables prometheus queries to the specified
 	// server
 	PrometheusServer string
+	// PrometheusSamplesWarningThreshold is the number of samples in a
+	// prometheus query result above which a warning is logged.
+	PrometheusSamplesWarningThreshold int
 	// InfluxDBAddress enables Flux queries to the specified InfluxDB instance
 	InfluxDBAddress string
 	// InfluxDBToken is the token used for querying InfluxDB
@@ -478,10 +491,48 @@ type AdapterServerOptions struct {
 	ZMONKariosDBEndpoint string
 	// ZMONTokenName is the name of the token used to query ZMON
 	ZMONTokenName string
+	// ZMONCheckAliases is the <namespace>/<name> of a ConfigMap mapping
+	// ZMON check aliases to checks.
+	ZMONCheckAliases string
 	// NakadiEndpoint enables Nakadi metrics from the specified endpoint
 	NakadiEndpoint string
 	// NakadiTokenName is the name of the token used to call Nakadi
 	NakadiTokenName string
+	// ExternalClientTimeout is the timeout of the requests to ZMON and
+	// Nakadi including reading the response.
+	ExternalClientTimeout time.Duration
+	// MaxResponseSize is the maximum number of bytes read from the
+	// responses of metrics endpoints, ZMON and Nakadi.
+	MaxResponseSize int64
+	// SQLDriver enables sql metrics using the specified driver
+	SQLDriver string
+	// SQLDSNFile is the path to the file containing the DSN of the
+	// database queried for sql metrics
+	SQLDSNFile string
+	// SQLQueryTimeout is the timeout of the queries for sql metrics
+	SQLQueryTimeout time.Duration
+	// SQLMinQueryInterval is the minimum interval between two queries of
+	// a sql metric
+	SQLMinQueryInterval time.Duration
+	// RedisAddress enables redis metrics querying the specified server
+	RedisAddress string
+	// RedisPasswordFile is the path to the file containing the password of
+	// the Redis servers
+	RedisPasswordFile string
+	// RedisAllowedAddresses are the addresses of Redis servers which redis
+	// metrics may query instead of RedisAddress
+	RedisAllowedAddresses []string
+	// RedisTimeout is the timeout of connecting to Redis and of each
+	// command
+	RedisTimeout time.Duration
+	// RedisTLS enables TLS for the connections to Redis
+	RedisTLS bool
+	// RedisTLSCAFile is the path to the CA certificates verifying the
+	// Redis servers
+	RedisTLSCAFile string
+	// RedisTLSInsecureSkipVerify skips the verification of the
+	// certificates of the Redis servers
+	RedisTLSInsecureSkipVerify bool
 	// Token is an oauth2 token used to authenticate with services like
 	// ZMON.
 	Token string
@@ -498,6 +549,19 @@ type AdapterServerOptions struct {
 	AWSExternalMetrics bool
 	// AWSRegions the AWS regions which are supported for monitoring.
 	AWSRegions []string
+	// AWSAllowDynamicRegions allows monitoring regions which are not
+	// pre-configured via AWSRegions.
+	AWSAllowDynamicRegions bool
+	// AWSSessionRefreshInterval is the interval at which AWS sessions
+	// are recreated.
+	AWSSessionRefreshInterval time.Duration
+	// KubeAPIQPS is the maximum queries per second to the kubernetes API.
+	KubeAPIQPS float32
+	// KubeAPIBurst is the maximum burst of queries to the kubernetes API.
+	KubeAPIBurst int
+	// DisableArgoRollouts disables the support for Argo Rollout scale
+	// targets.
+	DisableArgoRollouts bool
 	// MetricsAddress is the address where to serve prometheus metrics.
 	MetricsAddress string
 	// SkipperBackendWeightAnnotation is the annotation on the ingress indicating the backend weights
@@ -505,6 +569,8 @@ type AdapterServerOptions struct {
 	// Whether to disregard failing to create collectors for incompatible HPAs - such as when using
 	// kube-metrics-adapter beside another Metrics Provider
 	DisregardIncompatibleHPAs bool
+	// Default interval at which metrics are collected
+	CollectorInterval time.Duration
 	// TTL for metrics that are stored in in-memory cache
 	MetricsTTL time.Duration
 	// Interval to clean up metrics that are stored in in-memory cache
@@ -517,6 +583,26 @@ type AdapterServerOptions struct {
 	RampSteps int
 	// Default time zone to use for ScalingSchedules.
 	DefaultTimeZone string
+	// Max duration of a single schedule, zero uses the defaults per
+	// schedule type.
+	ScalingScheduleMaxDuration time.Duration
+	// Max number of scale operations of the scheduled scaling
+	// controller per run, zero means unlimited.
+	ScalingScheduleMaxScalesPerRun int
+	// Factor by which the values of overlapping schedules have to differ
+	// to be reported as conflict, zero disables the check.
+	ScalingScheduleConflictFactor float64
+	// Max factor of the current replicas a target is pre-scaled to, zero
+	// disables the guard.
+	ScalingScheduleMaxScaleUpFactor float64
+	// Max replicas any target is pre-scaled to, zero disables the
+	// ceiling.
+	ScalingScheduleMaxReplicas int64
+	// Whether to export the current value of every scaling schedule as
+	// metrics.
+	ScalingScheduleValueMetrics bool
+	// Offset added to the local clock when evaluating scaling schedules.
+	TimeOffset time.Duration
 	// The HPA tolerance also configured in the HPA controller.
 	// kube-controller-manager flag: --horizontal-pod-autoscaler-tolerance=
 	HorizontalPodAutoscalerTolerance float64
@@ -524,4 +610,80 @@ type AdapterServerOptions struct {
 	ExternalRPSMetrics bool
 	// Name of the Prometheus metric that stores RPS by hostname for external RPS metrics.
 	ExternalRPSMetricName string
+	// Networks the HTTP collector is allowed to query.
+	HTTPCollectorAllowedCIDRs []string
+	// Networks the HTTP collector is not allowed to query.
+	HTTPCollectorDeniedCIDRs []string
+	// URL schemes the HTTP collector is allowed to query.
+	HTTPCollectorAllowedSchemes []string
+	// Feature flag to record the effective queries of query based
+	// collectors for debugging.
+	RecordQueries bool
+	// Feature flag to enable the external metric exposing the adapter's
+	// own collection lag.
+	SelfMetrics bool
+	// Annotation pausing the metric collection and scheduled scaling of
+	// an HPA.
+	HPAPauseAnnotation string
+	// File the metric store is persisted in across restarts.
+	StateFile string
+	// Interval at which the metric store is saved to the state file.
+	StateSaveInterval time.Duration
+	// File the audit log of collector changes is appended to.
+	AuditLogPath string
+	// Feature flag to serve the per HPA collection summary on the metrics
+	// address.
+	HPASummaryAPI bool
+	// Feature flag to serve the dry-run query endpoint on the metrics
+	// address.
+	DebugQueryAPI bool
+	// Feature flag to enable the external metric exposing the replicas
+	// computed for each HPA from the stored metrics.
+	DesiredReplicasMetric bool
+	// Feature flag to write the last collected value of each metric to
+	// an annotation of the HPA.
+	WriteStatusAnnotations bool
+	// Address of the push receiver of the pushed pods collector.
+	PushAddress string
+	// Time pushed values are kept.
+	PushTTL time.Duration
+	// Max fraction of the HPAs removed in a single update without
+	// confirmation by the next update.
+	HPARemovalThreshold float64
+	// Feature flag to detect HPAs whose metrics require more than their
+	// max replicas.
+	MaxReplicasDetection bool
+	// Feature flag to apply the default metric configs defined by the
+	// annotations of the namespaces.
+	NamespaceDefaults bool
+	// Feature flag to allow external metrics to be stored cluster scoped
+	// and served for queries of any namespace.
+	AllowClusterScopedExternalMetrics bool
+	// Number of shards splitting the collection of the metrics of the
+	// HPAs.
+	ShardingTotal int
+	// Shard of the adapter replica, derived from the hostname if
+	// negative.
+	ShardingIndex int
+	// Window in which identical events are only recorded once.
+	EventDeduplicationWindow time.Duration
+	// Reasons of collector creation failures for which no events are
+	// recorded.
+	SuppressEventReasons []string
+	// Max deadline of a single collection, which is the collection
+	// interval if it's shorter.
+	MaxCollectionTimeout time.Duration
+	// Mode of the startup review of the permissions required by the
+	// enabled integrations, "warn" or "strict". Disabled if empty.
+	RBACSelfCheck string
+	// ChaosMode wraps all collectors to inject random latency and
+	// failures. It's a developer tool and can only be enabled if the
+	// collector.ChaosEnvVar environment variable is set to "true".
+	ChaosMode bool
+	// Upper bound of the random latency injected in chaos mode.
+	ChaosMaxLatency time.Duration
+	// Probability of a collection to fail in chaos mode.
+	ChaosFailureRate float64
+	// Seed of the faults injected in chaos mode.
+	ChaosSeed int64
 }
@@ -0,0 +1,25 @@
+package sleep
+
+import (
+	"context"
+	"time"
+)
+
+// Context sleeps for the duration or until the context is done, whichever
+// happens first. It returns the error of the context if it's done before
+// the duration elapsed.
+func Context(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
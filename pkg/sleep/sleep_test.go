@@ -0,0 +1,18 @@
+package sleep
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestContext(t *testing.T) {
+	require.NoError(t, Context(context.Background(), 0))
+	require.NoError(t, Context(context.Background(), time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, Context(ctx, time.Hour), context.Canceled)
+}
@@ -0,0 +1,98 @@
+package zmon
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// maxListedAliases is the maximum number of aliases listed in the
+	// error returned for an unknown alias.
+	maxListedAliases = 10
+)
+
+// CheckAlias defines a logical name for a ZMON check.
+type CheckAlias struct {
+	CheckID     int      `json:"checkID"`
+	Aggregators []string `json:"aggregators,omitempty"`
+}
+
+// CheckAliases is a thread-safe mapping of alias names to ZMON checks. The
+// mapping can be updated at runtime.
+type CheckAliases struct {
+	aliases map[string]CheckAlias
+	sync.RWMutex
+}
+
+// NewCheckAliases initializes a new CheckAliases mapping.
+func NewCheckAliases(aliases map[string]CheckAlias) *CheckAliases {
+	if aliases == nil {
+		aliases = map[string]CheckAlias{}
+	}
+	return &CheckAliases{
+		aliases: aliases,
+	}
+}
+
+// ParseCheckAliases parses check aliases from a map of alias name to a JSON
+// encoded CheckAlias e.g. as defined in the data of a ConfigMap.
+func ParseCheckAliases(data map[string]string) (map[string]CheckAlias, error) {
+	aliases := make(map[string]CheckAlias, len(data))
+	for name, value := range data {
+		var alias CheckAlias
+		err := json.Unmarshal([]byte(value), &alias)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse ZMON check alias '%s': %w", name, err)
+		}
+
+		if alias.CheckID <= 0 {
+			return nil, fmt.Errorf("invalid check ID %d for ZMON check alias '%s'", alias.CheckID, name)
+		}
+
+		err = ValidateAggregators(alias.Aggregators)
+		if err != nil {
+			return nil, fmt.Errorf("%v for ZMON check alias '%s'", err, name)
+		}
+
+		aliases[name] = alias
+	}
+	return aliases, nil
+}
+
+// Update replaces all aliases.
+func (a *CheckAliases) Update(aliases map[string]CheckAlias) {
+	a.Lock()
+	defer a.Unlock()
+	a.aliases = aliases
+}
+
+// Resolve looks up a check by its alias. If the alias is unknown the
+// returned error lists (some of) the available aliases.
+func (a *CheckAliases) Resolve(name string) (CheckAlias, error) {
+	a.RLock()
+	defer a.RUnlock()
+
+	if alias, ok := a.aliases[name]; ok {
+		return alias, nil
+	}
+
+	available := make([]string, 0, len(a.aliases))
+	for alias := range a.aliases {
+		available = append(available, alias)
+	}
+	sort.Strings(available)
+
+	if len(available) == 0 {
+		return CheckAlias{}, fmt.Errorf("unknown ZMON check alias '%s', no aliases are defined", name)
+	}
+
+	list := strings.Join(available, ", ")
+	if len(available) > maxListedAliases {
+		list = fmt.Sprintf("%s, ... (%d more)", strings.Join(available[:maxListedAliases], ", "), len(available)-maxListedAliases)
+	}
+
+	return CheckAlias{}, fmt.Errorf("unknown ZMON check alias '%s', available aliases: %s", name, list)
+}
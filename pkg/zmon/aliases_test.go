@@ -0,0 +1,52 @@
+package zmon
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCheckAliases(t *testing.T) {
+	aliases, err := ParseCheckAliases(map[string]string{
+		"my-service-latency": `{"checkID": 1234, "aggregators": ["max"]}`,
+		"my-service-errors":  `{"checkID": 5678}`,
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]CheckAlias{
+		"my-service-latency": {CheckID: 1234, Aggregators: []string{"max"}},
+		"my-service-errors":  {CheckID: 5678},
+	}, aliases)
+
+	for _, data := range []map[string]string{
+		{"invalid-json": `{"checkID": `},
+		{"missing-check-id": `{"aggregators": ["max"]}`},
+		{"invalid-aggregator": `{"checkID": 1234, "aggregators": ["median"]}`},
+		{"invalid-percentile": `{"checkID": 1234, "aggregators": ["p90"]}`},
+	} {
+		_, err := ParseCheckAliases(data)
+		require.Error(t, err)
+	}
+}
+
+func TestCheckAliasesResolve(t *testing.T) {
+	aliases := NewCheckAliases(nil)
+
+	_, err := aliases.Resolve("foo")
+	require.EqualError(t, err, "unknown ZMON check alias 'foo', no aliases are defined")
+
+	// hot-reload of the aliases
+	aliases.Update(map[string]CheckAlias{"foo": {CheckID: 1}})
+	alias, err := aliases.Resolve("foo")
+	require.NoError(t, err)
+	require.Equal(t, 1, alias.CheckID)
+
+	// the list of available aliases is capped.
+	many := map[string]CheckAlias{}
+	for i := 0; i < 15; i++ {
+		many[fmt.Sprintf("alias-%02d", i)] = CheckAlias{CheckID: i + 1}
+	}
+	aliases.Update(many)
+	_, err = aliases.Resolve("foo")
+	require.EqualError(t, err, "unknown ZMON check alias 'foo', available aliases: alias-00, alias-01, alias-02, alias-03, alias-04, alias-05, alias-06, alias-07, alias-08, alias-09, ... (5 more)")
+}
@@ -2,12 +2,16 @@ package zmon
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/httperrors"
 )
 
 var (
@@ -22,8 +26,36 @@ var (
 		"sum":   {},
 		"diff":  {},
 	}
+
+	// percentile aggregators mapped to the percentile of the KairosDB
+	// percentile aggregator.
+	percentileAggregators = map[string]float64{
+		"p50": 0.5,
+		"p95": 0.95,
+		"p99": 0.99,
+	}
 )
 
+// ValidateAggregators returns an error for the first of the aggregators
+// which can't be used in queries.
+func ValidateAggregators(aggregators []string) error {
+	for _, name := range aggregators {
+		if _, ok := validAggregators[name]; ok {
+			continue
+		}
+		if _, ok := percentileAggregators[name]; ok {
+			continue
+		}
+		if strings.HasPrefix(name, "p") {
+			if _, err := strconv.ParseFloat(name[1:], 64); err == nil {
+				return fmt.Errorf("unsupported percentile aggregator '%s', supported percentiles are p50, p95 and p99", name)
+			}
+		}
+		return fmt.Errorf("invalid aggregator '%s'", name)
+	}
+	return nil
+}
+
 // Entity defines a ZMON entity.
 type Entity struct {
 	ID string `json:"id"`
@@ -31,13 +63,15 @@ type Entity struct {
 
 // ZMON defines an interface for talking to the ZMON API.
 type ZMON interface {
-	Query(checkID int, key string, tags map[string]string, aggregators []string, duration time.Duration) ([]DataPoint, error)
+	Query(ctx context.Context, checkID int, key string, tags map[string]string, aggregators []string, duration time.Duration) ([]DataPoint, error)
 }
 
 // Client defines client for interfacing with the ZMON API.
 type Client struct {
 	dataServiceEndpoint string
 	http                *http.Client
+	maxErrorBodyLength  int
+	maxResponseSize     int64
 }
 
 // NewZMONClient initializes a new ZMON Client.
@@ -45,9 +79,24 @@ func NewZMONClient(dataServiceEndpoint string, client *http.Client) *Client {
 	return &Client{
 		dataServiceEndpoint: dataServiceEndpoint,
 		http:                client,
+		maxErrorBodyLength:  httperrors.DefaultMaxBodyLength,
+		maxResponseSize:     httperrors.DefaultMaxResponseSize,
 	}
 }
 
+// SetMaxErrorBodyLength sets the maximum number of bytes of a response body
+// included in errors. A length <= 0 includes the full body.
+func (c *Client) SetMaxErrorBodyLength(length int) {
+	c.maxErrorBodyLength = length
+}
+
+// SetMaxResponseSize sets the maximum number of bytes read from a response
+// body. Larger responses fail with a httperrors.TooLargeError. A size <= 0
+// disables the limit.
+func (c *Client) SetMaxResponseSize(size int64) {
+	c.maxResponseSize = size
+}
+
 // DataPoint defines a single datapoint returned from a query.
 type DataPoint struct {
 	Time  time.Time
@@ -78,8 +127,9 @@ type tagGroup struct {
 }
 
 type aggregator struct {
-	Name     string   `json:"name"`
-	Sampling sampling `json:"sampling"`
+	Name       string   `json:"name"`
+	Sampling   sampling `json:"sampling"`
+	Percentile float64  `json:"percentile,omitempty"`
 }
 
 type queryResp struct {
@@ -94,7 +144,7 @@ type queryResp struct {
 // data points for the query.
 //
 // https://kairosdb.github.io/docs/build/html/restapi/QueryMetrics.html
-func (c *Client) Query(checkID int, key string, tags map[string]string, aggregators []string, duration time.Duration) ([]DataPoint, error) {
+func (c *Client) Query(ctx context.Context, checkID int, key string, tags map[string]string, aggregators []string, duration time.Duration) ([]DataPoint, error) {
 	endpoint, err := url.Parse(c.dataServiceEndpoint)
 	if err != nil {
 		return nil, err
@@ -120,14 +170,20 @@ func (c *Client) Query(checkID int, key string, tags map[string]string, aggregat
 	}
 
 	// add aggregators
+	err = ValidateAggregators(aggregators)
+	if err != nil {
+		return nil, err
+	}
 	for _, aggregatorName := range aggregators {
-		if _, ok := validAggregators[aggregatorName]; !ok {
-			return nil, fmt.Errorf("invalid aggregator '%s'", aggregatorName)
-		}
-		query.Metrics[0].Aggregators = append(query.Metrics[0].Aggregators, aggregator{
+		agg := aggregator{
 			Name:     aggregatorName,
 			Sampling: durationToSampling(duration),
-		})
+		}
+		if percentile, ok := percentileAggregators[aggregatorName]; ok {
+			agg.Name = "percentile"
+			agg.Percentile = percentile
+		}
+		query.Metrics[0].Aggregators = append(query.Metrics[0].Aggregators, agg)
 	}
 
 	// add key to query if defined
@@ -146,7 +202,7 @@ func (c *Client) Query(checkID int, key string, tags map[string]string, aggregat
 
 	endpoint.Path += "/api/v1/datapoints/query"
 
-	req, err := http.NewRequest(http.MethodPost, endpoint.String(), bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.String(), bytes.NewBuffer(body))
 	if err != nil {
 		return nil, err
 	}
@@ -161,19 +217,23 @@ func (c *Client) Query(checkID int, key string, tags map[string]string, aggregat
 	}
 	defer resp.Body.Close()
 
-	d, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
+	if resp.StatusCode != http.StatusOK {
+		body, err := httperrors.ReadBody(resp.Body, c.maxErrorBodyLength)
+		if err != nil {
+			return nil, fmt.Errorf("[kariosdb query] unexpected response code: %d", resp.StatusCode)
+		}
+		return nil, fmt.Errorf("[kariosdb query] unexpected response code: %d (%s)", resp.StatusCode, body)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("[kariosdb query] unexpected response code: %d", resp.StatusCode)
+	d, err := httperrors.ReadAll(resp.Body, c.maxResponseSize)
+	if err != nil {
+		return nil, err
 	}
 
 	var result queryResp
 	err = json.Unmarshal(d, &result)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("[kariosdb query] failed to decode response: %w", err)
 	}
 
 	if len(result.Queries) < 1 {
@@ -1,14 +1,19 @@
 package zmon
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zalando-incubator/kube-metrics-adapter/pkg/httperrors"
 )
 
 func TestQuery(tt *testing.T) {
@@ -106,7 +111,7 @@ func TestQuery(tt *testing.T) {
 			msg:    "test query with invalid response",
 			status: http.StatusInternalServerError,
 			body:   `{"error": 500}`,
-			err:    fmt.Errorf("[kariosdb query] unexpected response code: 500"),
+			err:    fmt.Errorf("[kariosdb query] unexpected response code: 500 ({\"error\": 500})"),
 		},
 		{
 			msg:      "test getting invalid values response",
@@ -170,7 +175,7 @@ func TestQuery(tt *testing.T) {
 			defer ts.Close()
 
 			zmonClient := NewZMONClient(ts.URL, client)
-			dataPoints, err := zmonClient.Query(1, ti.key, nil, ti.aggregators, ti.duration)
+			dataPoints, err := zmonClient.Query(context.Background(), 1, ti.key, nil, ti.aggregators, ti.duration)
 			assert.Equal(t, ti.err, err)
 			assert.Len(t, dataPoints, len(ti.dataPoints))
 			assert.Equal(t, ti.dataPoints, dataPoints)
@@ -215,3 +220,122 @@ func TestDurationToSampling(tt *testing.T) {
 		})
 	}
 }
+
+func TestQueryErrorBodyTruncation(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte("<html>" + strings.Repeat("x", 2*1024*1024) + "</html>"))
+	}))
+	defer ts.Close()
+
+	zmonClient := NewZMONClient(ts.URL, &http.Client{})
+	_, err := zmonClient.Query(context.Background(), 1, "", nil, nil, time.Hour)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unexpected response code: 502")
+	require.Contains(t, err.Error(), "... (truncated)")
+	require.Less(t, len(err.Error()), 2*httperrors.DefaultMaxBodyLength)
+
+	zmonClient.SetMaxErrorBodyLength(10)
+	_, err = zmonClient.Query(context.Background(), 1, "", nil, nil, time.Hour)
+	require.EqualError(t, err, "[kariosdb query] unexpected response code: 502 (<html>xxxx... (truncated))")
+}
+
+func TestQueryDeadline(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"queries": [`))
+		w.(http.Flusher).Flush()
+		// block mid-body until the test is done.
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	zmonClient := NewZMONClient(ts.URL, &http.Client{})
+	_, err := zmonClient.Query(ctx, 1, "", nil, nil, time.Hour)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestQueryMalformedResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"queries": [{"results": `))
+	}))
+	defer ts.Close()
+
+	zmonClient := NewZMONClient(ts.URL, &http.Client{})
+	_, err := zmonClient.Query(context.Background(), 1, "", nil, nil, time.Hour)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to decode response")
+}
+
+func TestQueryMaxResponseSize(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"queries": [{"results": [{"values": [` + strings.Repeat("[0, 1],", 1024) + `[0, 1]]}]}]}`))
+	}))
+	defer ts.Close()
+
+	zmonClient := NewZMONClient(ts.URL, &http.Client{})
+	zmonClient.SetMaxResponseSize(1024)
+	_, err := zmonClient.Query(context.Background(), 1, "", nil, nil, time.Hour)
+	require.IsType(t, &httperrors.TooLargeError{}, err)
+
+	zmonClient.SetMaxResponseSize(httperrors.DefaultMaxResponseSize)
+	dataPoints, err := zmonClient.Query(context.Background(), 1, "", nil, nil, time.Hour)
+	require.NoError(t, err)
+	require.Len(t, dataPoints, 1025)
+}
+
+func TestQueryPercentileAggregators(t *testing.T) {
+	var payload string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		payload = string(body)
+		_, _ = w.Write([]byte(`{"queries": [{"results": [{"values": [[1539710395000, 250]]}]}]}`))
+	}))
+	defer ts.Close()
+
+	zmonClient := NewZMONClient(ts.URL, &http.Client{})
+	dataPoints, err := zmonClient.Query(context.Background(), 1, "", nil, []string{"p50", "p99", "max"}, 5*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, []DataPoint{{Time: time.Unix(1539710395, 0), Value: 250}}, dataPoints)
+	require.JSONEq(t, `{
+		"start_relative": {"value": 5, "unit": "minutes"},
+		"metrics": [{
+			"name": "zmon.check.1",
+			"limit": 10000,
+			"tags": {},
+			"group_by": [],
+			"aggregators": [
+				{"name": "percentile", "percentile": 0.5, "sampling": {"value": 5, "unit": "minutes"}},
+				{"name": "percentile", "percentile": 0.99, "sampling": {"value": 5, "unit": "minutes"}},
+				{"name": "max", "sampling": {"value": 5, "unit": "minutes"}}
+			]
+		}]
+	}`, payload)
+}
+
+func TestValidateAggregators(t *testing.T) {
+	for _, tc := range []struct {
+		aggregators []string
+		err         string
+	}{
+		{aggregators: []string{"avg", "p50", "p95", "p99"}},
+		{aggregators: []string{"p95", "p90"}, err: "unsupported percentile aggregator 'p90', supported percentiles are p50, p95 and p99"},
+		{aggregators: []string{"p100"}, err: "unsupported percentile aggregator 'p100', supported percentiles are p50, p95 and p99"},
+		{aggregators: []string{"percentile"}, err: "invalid aggregator 'percentile'"},
+	} {
+		err := ValidateAggregators(tc.aggregators)
+		if tc.err == "" {
+			require.NoError(t, err)
+			continue
+		}
+		require.EqualError(t, err, tc.err)
+	}
+}